@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"hash/maphash"
+	"math/bits"
+)
+
+// ErrAdmissionUnsupported is returned by SetAdmission for caches whose
+// policy cannot name its next victim without evicting it, and for cost
+// bounded caches, where one insert may displace several entries.
+var ErrAdmissionUnsupported = errors.New("admission filter requires a count-bounded LRU, FIFO, LFU, SLRU or LRUK cache")
+
+// frequencySketch is a count-min sketch of recent access frequencies using
+// four rows of 4-bit counters. Once the number of recorded accesses reaches
+// the sample size every counter is halved, so old popularity fades and the
+// sketch tracks the current working set.
+type frequencySketch[K comparable] struct {
+	seed    maphash.Seed
+	rows    [4][]uint8
+	mask    uint64
+	added   int
+	samples int
+}
+
+func newFrequencySketch[K comparable](capacity int) *frequencySketch[K] {
+	width := 1 << bits.Len(uint(max(capacity, 16)-1))
+	s := &frequencySketch[K]{
+		seed:    maphash.MakeSeed(),
+		mask:    uint64(width - 1),
+		samples: 10 * max(capacity, 16),
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index returns key's counter in row i. Each row mixes the one hash with
+// a different odd multiplier so that collisions differ from row to row.
+func (s *frequencySketch[K]) index(h uint64, i int) uint64 {
+	h *= [4]uint64{0x9e3779b97f4a7c15, 0xc2b2ae3d27d4eb4f, 0x165667b19e3779f9, 0xd6e8feb86659fd93}[i]
+	return (h >> 32) & s.mask
+}
+
+func (s *frequencySketch[K]) Increment(key K) {
+	h := maphash.Comparable(s.seed, key)
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(h, i)]; *c < 15 {
+			*c++
+		}
+	}
+	if s.added++; s.added >= s.samples {
+		s.halve()
+	}
+}
+
+// Estimate returns the smallest of key's counters, an upper bound on its
+// recent access count.
+func (s *frequencySketch[K]) Estimate(key K) uint8 {
+	h := maphash.Comparable(s.seed, key)
+	est := uint8(15)
+	for i := range s.rows {
+		est = min(est, s.rows[i][s.index(h, i)])
+	}
+	return est
+}
+
+// estimatesAfter returns what Estimate would return for key and for other
+// once Increment(key) had been called, halving included, without calling
+// it.
+func (s *frequencySketch[K]) estimatesAfter(key, other K) (uint8, uint8) {
+	h, ho := maphash.Comparable(s.seed, key), maphash.Comparable(s.seed, other)
+	halve := s.added+1 >= s.samples
+	est, estOther := uint8(15), uint8(15)
+	for i := range s.rows {
+		j, jo := s.index(h, i), s.index(ho, i)
+		n, no := s.rows[i][j], s.rows[i][jo]
+		if n < 15 {
+			n++
+		}
+		if jo == j {
+			no = n
+		}
+		if halve {
+			n, no = n>>1, no>>1
+		}
+		est, estOther = min(est, n), min(estOther, no)
+	}
+	return est, estOther
+}
+
+func (s *frequencySketch[K]) halve() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.added /= 2
+}
+
+// SetAdmission turns the TinyLFU admission filter on or off. While it is
+// on, a new key that would force an eviction is only admitted if it has
+// been accessed more often recently than the entry it would displace;
+// otherwise the write is dropped and counted in Stats.AdmissionRejected.
+// Every Get and Put, hit or miss, is recorded in the frequency sketch, so
+// a key that keeps being asked for is eventually let in.
+func (c *LRUCache[K, V]) SetAdmission(enabled bool) error {
+	c.lock()
+	defer c.unlock()
+
+	if !enabled {
+		c.sketch = nil
+		return nil
+	}
+	if _, ok := c.policy.(victimPeeker[K, V]); !ok || c.maxCost > 0 {
+		return ErrAdmissionUnsupported
+	}
+	if c.sketch == nil {
+		c.sketch = newFrequencySketch[K](c.capacity)
+	}
+	return nil
+}
+
+// Admission reports whether the admission filter is on.
+func (c *LRUCache[K, V]) Admission() bool {
+	c.rlock()
+	defer c.mu.RUnlock()
+	return c.sketch != nil
+}
+
+// recordAccess notes an access to key in the sketch, if there is one.
+func (c *LRUCache[K, V]) recordAccess(key K) {
+	if c.sketch != nil {
+		c.sketch.Increment(key)
+	}
+}
+
+// admit reports whether a new entry for key may be inserted. It must be
+// called before the entry is added.
+func (c *LRUCache[K, V]) admit(key K) bool {
+	if c.sketch == nil || len(c.cache) < c.capacity {
+		return true
+	}
+	victim := c.lowTier.back()
+	if victim == nil {
+		victim = c.policy.(victimPeeker[K, V]).Victim()
+	}
+	if victim == nil || victim.expired(c.now()) {
+		return true
+	}
+	if c.sketch.Estimate(key) > c.sketch.Estimate(victim.key) {
+		return true
+	}
+	c.counters.admissionRejected.Add(1)
+	return false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAdmissionImprovesScanHitRatio(t *testing.T) {
+	// Each round reads a working set of 20 keys, then scans 100 keys that
+	// are never seen again, through a cache of 50.
+	hot := numbered("h", 20)
+	trace := func(c *LRUCache[string, int]) (hits, reads int) {
+		for round := range 50 {
+			hits += readThrough(c, hot)
+			readThrough(c, numbered("s"+strconv.Itoa(round)+"-", 100))
+			reads += len(hot)
+		}
+		return hits, reads
+	}
+
+	plain := NewLRUCache[string, int](50)
+	plainHits, reads := trace(plain)
+
+	filtered := NewLRUCache[string, int](50)
+	if err := filtered.SetAdmission(true); err != nil {
+		t.Fatal(err)
+	}
+	filteredHits, _ := trace(filtered)
+
+	t.Logf("working set hit ratio: %.2f plain, %.2f with admission",
+		float64(plainHits)/float64(reads), float64(filteredHits)/float64(reads))
+	if plainHits != 0 {
+		t.Errorf("plain LRU kept the working set through the scans: %d hits", plainHits)
+	}
+	// The sketch's hash seed is random, which moves the figure by a few
+	// points from run to run.
+	if filteredHits < reads/2 {
+		t.Errorf("admission filter hit %d of %d working set reads, want at least half", filteredHits, reads)
+	}
+	if st := filtered.Stats(); st.AdmissionRejected == 0 || st.Size != 50 {
+		t.Errorf("stats %+v", st)
+	}
+	if err := filtered.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdmissionRejectedPutIsOK(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 2 LRU TINYLFU", "OK",
+		"PUT a 1", "OK",
+		"GET a", "1",
+		"PUT b 2", "OK",
+		"GET b", "2",
+		// c is colder than a, the victim: dropped, but still OK.
+		"PUT c 3", "OK",
+		"EXISTS c", "0",
+		"EXISTS a", "1",
+		"ADMISSION NONE", "OK",
+		"PUT c 3", "OK",
+		"EXISTS c", "1",
+	)
+	if got := s.Execute("STATS"); got != "hits=2 misses=0 evictions=1 expirations=0 size=2 capacity=2 hit_ratio=1.00 admission_rejected=1" {
+		t.Errorf("STATS = %q", got)
+	}
+}
+
+func TestAdmissionUnsupported(t *testing.T) {
+	for _, c := range []*LRUCache[string, int]{
+		policyCache(t, 4, "ARC"),
+		policyCache(t, 4, "2Q"),
+		NewLRUCacheWeighted(100, newLRUPolicy[string, int]()),
+	} {
+		if err := c.SetAdmission(true); err != ErrAdmissionUnsupported {
+			t.Errorf("%s: SetAdmission = %v", c.policy.Name(), err)
+		}
+	}
+}
+
+func TestFrequencySketchHalves(t *testing.T) {
+	s := newFrequencySketch[string](16)
+	for range 10 {
+		s.Increment("a")
+	}
+	if got := s.Estimate("a"); got != 10 {
+		t.Fatalf("Estimate(a) = %d after 10 increments", got)
+	}
+	// Counters stop at 15, and the increment that completes the sample
+	// halves them all.
+	for range s.samples - 11 {
+		s.Increment("a")
+	}
+	if got := s.Estimate("a"); got != 15 {
+		t.Fatalf("Estimate(a) = %d, want it saturated at 15", got)
+	}
+	s.Increment("a")
+	if got := s.Estimate("a"); got != 7 {
+		t.Errorf("Estimate(a) = %d after halving, want 7", got)
+	}
+}
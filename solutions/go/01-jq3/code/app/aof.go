@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// appendLog is an append-only file of the state-changing commands applied
+// to a cache, stored as the same protocol lines that were read from input.
+// Replaying it into a fresh cache rebuilds the contents.
+//
+// Reads are not logged, so replay recreates recency as of the last write to
+// each key rather than the last access: a key that was only read since it
+// was written comes back older than it was. TTLs are logged as the relative
+// durations given on the command line, so replay restarts them from the
+// time of the replay.
+//
+// All methods are no-ops on a nil *appendLog so callers need not check
+// whether logging is enabled. They are safe for concurrent use.
+type appendLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// openAppendLog opens the log at path, creating it if need be. A trailing
+// line without a newline, which a crash mid-write leaves behind, is cut
+// off first: appended to, it would swallow the next line written.
+func openAppendLog(path string) (*appendLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := trimTornLine(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &appendLog{path: path, f: f}, nil
+}
+
+// trimTornLine truncates f just after its last newline, reading back from
+// the end a block at a time.
+func trimTornLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	buf := make([]byte, 4096)
+	for off := end; off > 0; {
+		n := min(off, int64(len(buf)))
+		off -= n
+		if _, err := f.ReadAt(buf[:n], off); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			if keep := off + int64(i) + 1; keep < end {
+				return f.Truncate(keep)
+			}
+			return nil
+		}
+	}
+	if end > 0 {
+		return f.Truncate(0)
+	}
+	return nil
+}
+
+// Append writes line to the log. Each line is written with a single write
+// call so it reaches the file before the next command is read.
+func (l *appendLog) Append(line string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.WriteString(line + "\n"); err != nil {
+		logs.log(levelError, "append log write failed", "error", err)
+	}
+}
+
+func (l *appendLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Replay applies every complete line in the log to cache. A trailing line
+// without a newline, which openAppendLog has already cut off, is ignored;
+// malformed lines are reported on stderr and skipped.
+func (l *appendLog) Replay(cache *LRUCache[string, Value]) error {
+	if l == nil {
+		return nil
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[:i+1]
+	} else {
+		data = nil
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		parts, err := tokenize(line)
+		if err == nil && len(parts) == 0 {
+			continue
+		}
+		if err == nil {
+			err = applyLogged(cache, parts)
+		}
+		if err != nil {
+			logs.log(levelWarn, "append log line skipped", "line", n+1, "error", err)
+		}
+	}
+	return nil
+}
+
+// Rewrite replaces the log with the shortest sequence of PUTs that rebuilds
+// the current contents of cache; see rebuildLines.
+func (l *appendLog) Rewrite(cache *LRUCache[string, Value]) error {
+	if l == nil {
+		return fmt.Errorf("AOF is not enabled")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var buf strings.Builder
+	for _, line := range rebuildLines(cache) {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(buf.String()), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f.Close()
+	l.f = f
+	return nil
+}
+
+// rebuildLines returns the shortest sequence of logged commands that
+// rebuilds the current contents of cache: a PUT for each entry, oldest
+// first, followed by a PIN for each pinned entry. A list or hash is rebuilt
+// with one command per element and, if it has a TTL, an EXPIRE.
+func rebuildLines(cache *LRUCache[string, Value]) []string {
+	cache.rlock()
+	now := cache.now()
+	var entries [][]string
+	var pins []string
+	cache.each(func(node *Node[string, Value]) bool {
+		if node.expired(now) {
+			return true
+		}
+		ttl, grace := "", ""
+		if !node.expireAt.IsZero() {
+			ttl = strconv.FormatFloat(node.expireAt.Sub(now).Seconds(), 'f', -1, 64)
+		}
+		if node.grace > 0 {
+			grace = strconv.FormatFloat(node.grace.Seconds(), 'f', -1, 64)
+		}
+		rebuilt := node.value.rebuild(node.key, ttl, grace)
+		// A low or high priority string keeps its priority with PUTP,
+		// which takes no TTL; lists, hashes and strings with a grace
+		// period come back normal.
+		if node.priority != PriorityNormal && node.value.Type() == "string" && grace == "" {
+			rebuilt = []string{fmt.Sprintf("PUTP %s %s %d", quoteToken(node.key), quoteToken(node.value.String()), node.priority)}
+			if ttl != "" {
+				rebuilt = append(rebuilt, "EXPIRE "+quoteToken(node.key)+" "+ttl)
+			}
+		}
+		entries = append(entries, rebuilt)
+		if node.pinned {
+			pins = append(pins, "PIN "+quoteToken(node.key))
+		}
+		return true
+	})
+	cache.mu.RUnlock()
+	var lines []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		lines = append(lines, entries[i]...)
+	}
+	return append(lines, pins...)
+}
+
+// applyLogged applies one logged command to cache without producing any
+// output.
+func applyLogged(cache *LRUCache[string, Value], parts []string) error {
+	normalizeCommand(parts)
+	switch parts[0] {
+	case "PUT":
+		if len(parts) < 3 {
+			return fmt.Errorf("PUT requires key and value arguments")
+		}
+		var ttl, grace time.Duration
+		if len(parts) > 3 {
+			var err error
+			if ttl, err = parseTTL(parts[3]); err != nil {
+				return err
+			}
+		}
+		if len(parts) > 4 {
+			var err error
+			if grace, err = parseTTL(parts[4]); err != nil {
+				return err
+			}
+		}
+		return cache.PutWithGrace(parts[1], StringValue(parts[2]), ttl, grace)
+	case "MPUT":
+		if len(parts) < 3 || len(parts)%2 == 0 {
+			return fmt.Errorf("MPUT requires key and value pairs")
+		}
+		pairs := make([]KV[string, Value], 0, len(parts)/2)
+		for i := 1; i < len(parts); i += 2 {
+			pairs = append(pairs, KV[string, Value]{Key: parts[i], Value: StringValue(parts[i+1])})
+		}
+		return cache.PutMulti(pairs)
+	case "PUTW":
+		if len(parts) < 4 {
+			return fmt.Errorf("PUTW requires key, value and cost arguments")
+		}
+		cost, err := strconv.Atoi(parts[3])
+		if err != nil {
+			return fmt.Errorf("Invalid cost: %s", parts[3])
+		}
+		return cache.PutWeighted(parts[1], StringValue(parts[2]), cost)
+	case "PUTP":
+		if len(parts) < 4 {
+			return fmt.Errorf("PUTP requires key, value and priority arguments")
+		}
+		prio, err := parsePriority(parts[3])
+		if err != nil {
+			return err
+		}
+		return cache.PutWithPriority(parts[1], StringValue(parts[2]), prio)
+	case "GETSET":
+		if len(parts) < 3 {
+			return fmt.Errorf("GETSET requires key and value arguments")
+		}
+		_, _, err := cache.GetSet(parts[1], StringValue(parts[2]))
+		return err
+	case "DELETE":
+		if len(parts) < 2 {
+			return fmt.Errorf("DELETE requires key argument")
+		}
+		cache.Remove(parts[1])
+	case "CLEAR":
+		cache.Clear()
+	case "RESIZE":
+		if len(parts) < 2 {
+			return fmt.Errorf("RESIZE requires capacity argument")
+		}
+		capacity, err := strconv.Atoi(parts[1])
+		if err != nil || capacity < 1 {
+			return fmt.Errorf("Invalid capacity: %s", parts[1])
+		}
+		cache.Resize(capacity)
+	case "EXPIRE":
+		if len(parts) < 3 {
+			return fmt.Errorf("EXPIRE requires key and seconds arguments")
+		}
+		ttl, err := parseTTL(parts[2])
+		if err != nil {
+			return err
+		}
+		cache.Expire(parts[1], ttl)
+	case "EXPIREAT":
+		if len(parts) < 3 {
+			return fmt.Errorf("EXPIREAT requires key and timestamp arguments")
+		}
+		t, err := parseUnixTime(parts[2])
+		if err != nil {
+			return err
+		}
+		cache.ExpireAt(parts[1], t)
+	case "PERSIST":
+		if len(parts) < 2 {
+			return fmt.Errorf("PERSIST requires key argument")
+		}
+		cache.Persist(parts[1])
+	case "INCR", "DECR":
+		if len(parts) < 2 {
+			return fmt.Errorf("%s requires key argument", parts[0])
+		}
+		delta := int64(1)
+		if len(parts) > 2 {
+			var err error
+			if delta, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+				return fmt.Errorf("Invalid delta: %s", parts[2])
+			}
+		}
+		if parts[0] == "DECR" {
+			delta = -delta
+		}
+		_, err := incrementValue(cache, parts[1], delta)
+		return err
+	case "APPEND":
+		if len(parts) < 3 {
+			return fmt.Errorf("APPEND requires key and suffix arguments")
+		}
+		_, err := appendValue(cache, parts[1], parts[2])
+		return err
+	case "LPUSH", "RPUSH":
+		if len(parts) < 3 {
+			return fmt.Errorf("%s requires key and value arguments", parts[0])
+		}
+		_, err := pushValue(cache, parts[1], parts[2], parts[0] == "LPUSH")
+		return err
+	case "HSET":
+		if len(parts) < 4 {
+			return fmt.Errorf("HSET requires key, field and value arguments")
+		}
+		_, err := hashSet(cache, parts[1], parts[2], parts[3])
+		return err
+	case "HDEL":
+		if len(parts) < 3 {
+			return fmt.Errorf("HDEL requires key and field arguments")
+		}
+		_, err := hashDelete(cache, parts[1], parts[2])
+		return err
+	case "PUTIF":
+		if len(parts) < 4 {
+			return fmt.Errorf("PUTIF requires key, expected and value arguments")
+		}
+		_, err := cache.PutIfEquals(parts[1], StringValue(parts[2]), StringValue(parts[3]))
+		return err
+	case "PUTV":
+		if len(parts) < 4 {
+			return fmt.Errorf("PUTV requires key, value and version arguments")
+		}
+		expected, err := strconv.ParseUint(parts[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version: %s", parts[3])
+		}
+		_, _, err = cache.PutVersioned(parts[1], StringValue(parts[2]), expected)
+		return err
+	case "SETNX":
+		if len(parts) < 3 {
+			return fmt.Errorf("SETNX requires key and value arguments")
+		}
+		_, err := cache.PutIfAbsent(parts[1], StringValue(parts[2]))
+		return err
+	case "RENAME", "COPY":
+		if len(parts) < 3 {
+			return fmt.Errorf("%s requires source and destination key arguments", parts[0])
+		}
+		if parts[0] == "RENAME" {
+			return cache.Rename(parts[1], parts[2])
+		}
+		return cache.Copy(parts[1], parts[2])
+	case "DELPREFIX":
+		if len(parts) < 2 {
+			return fmt.Errorf("DELPREFIX requires prefix argument")
+		}
+		cache.DeletePrefix(parts[1])
+	case "TOUCH":
+		if len(parts) < 2 {
+			return fmt.Errorf("TOUCH requires key argument")
+		}
+		cache.Touch(parts[1])
+	case "PIN", "UNPIN":
+		if len(parts) < 2 {
+			return fmt.Errorf("%s requires key argument", parts[0])
+		}
+		if parts[0] == "PIN" {
+			cache.Pin(parts[1])
+		} else {
+			cache.Unpin(parts[1])
+		}
+	default:
+		return fmt.Errorf("Unknown command: %s", parts[0])
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// auditBuffer is how many records the audit log holds for its writer
+// before it starts dropping them.
+const auditBuffer = 1024
+
+// auditClass says which commands the audit log records.
+type auditClass int
+
+const (
+	auditNone  auditClass = iota
+	auditWrite            // changes a cache or what the session does to it
+	auditRead             // reads entries; recorded only with --audit-reads
+)
+
+// auditLog records the commands a session is given, and the responses it
+// returned, one line each:
+//
+//	<time> <tab> <cache> <tab> <command> <tab> <response>
+//
+// The command is written as tokens tokenize reads back and the response as
+// one quoted token, with arguments and responses longer than maxValue bytes
+// truncated. Records are handed to a writer goroutine through a bounded
+// channel so that a slow disk never holds up a command; when the channel is
+// full the record is dropped and counted instead.
+//
+// All methods are no-ops on a nil *auditLog so callers need not check
+// whether auditing is enabled.
+type auditLog struct {
+	reads    bool // record auditRead commands as well
+	maxValue int  // 0 means no truncation
+	records  chan string
+	dropped  atomic.Int64
+	done     chan struct{}
+	f        *os.File
+}
+
+func openAuditLog(path string, reads bool, maxValue int) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &auditLog{
+		reads:    reads,
+		maxValue: maxValue,
+		records:  make(chan string, auditBuffer),
+		done:     make(chan struct{}),
+		f:        f,
+	}
+	go l.write()
+	return l, nil
+}
+
+// write copies records to the file, flushing whenever it has caught up.
+func (l *auditLog) write() {
+	defer close(l.done)
+	w := bufio.NewWriter(l.f)
+	for record := range l.records {
+		w.WriteString(record)
+		if len(l.records) == 0 {
+			if err := w.Flush(); err != nil {
+				logs.log(levelError, "audit log write failed", "error", err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		logs.log(levelError, "audit log write failed", "error", err)
+	}
+}
+
+// covers reports whether commands of class are recorded.
+func (l *auditLog) covers(class auditClass) bool {
+	return l != nil && (class == auditWrite || (class == auditRead && l.reads))
+}
+
+// Record queues a record of parts, run against the cache named cache,
+// having written response. Commands run before any cache exists are
+// recorded against "-".
+func (l *auditLog) Record(cache string, parts []string, response string) {
+	if l == nil {
+		return
+	}
+	if cache == "" {
+		cache = "-"
+	}
+	var b strings.Builder
+	b.WriteString(time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteByte('\t')
+	b.WriteString(cache)
+	b.WriteByte('\t')
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteByte(' ')
+			part = l.truncate(part)
+		}
+		b.WriteString(quoteToken(part))
+	}
+	b.WriteByte('\t')
+	b.WriteString(quoteToken(l.truncate(strings.TrimSuffix(response, "\n"))))
+	b.WriteByte('\n')
+	select {
+	case l.records <- b.String():
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+func (l *auditLog) truncate(s string) string {
+	if l.maxValue <= 0 || len(s) <= l.maxValue {
+		return s
+	}
+	return fmt.Sprintf("%s...(%d bytes)", s[:l.maxValue], len(s))
+}
+
+// Close writes out the records still queued and closes the file. Nothing
+// may be recorded after it is called. A count of dropped records, if any,
+// is reported on stderr.
+func (l *auditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	close(l.records)
+	<-l.done
+	if n := l.dropped.Load(); n > 0 {
+		logs.log(levelWarn, "audit records dropped", "count", n)
+	}
+	return l.f.Close()
+}
+
+// runAudited runs a command that the audit log covers, capturing its
+// response to record alongside it.
+func (s *session) runAudited(c *commandSpec, in *bufio.Reader, out *reply, line string, parts []string) {
+	cache := s.current
+	var buf bytes.Buffer
+	captured := *out
+	captured.w = &buf
+	s.runCommand(c, in, &captured, line, parts)
+	captured.w = out.w
+	*out = captured
+	out.w.Write(buf.Bytes())
+	s.audit.Record(cache, parts, buf.String())
+}
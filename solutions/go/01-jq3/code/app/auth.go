@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// In server mode a password, set with --password or --auth-file, shuts out
+// clients that do not know it. A TCP connection, in the line protocol or
+// RESP, must send AUTH <password> before anything else, and every HTTP
+// request must carry it as a bearer token. Stdin is trusted and never
+// asked for it. Wrong passwords, and missing ones, a command refused for
+// want of AUTH or a request without a token, are counted in
+// Stats.FailedAuth.
+
+// readPassword returns the password given by --password or, failing that,
+// the first line of the --auth-file file.
+func readPassword(password, path string) (string, error) {
+	if path == "" {
+		return password, nil
+	}
+	if password != "" {
+		return "", errors.New("--password and --auth-file are mutually exclusive")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	password, _, _ = strings.Cut(string(data), "\n")
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", errors.New(path + ": empty password")
+	}
+	return password, nil
+}
+
+// checkPassword reports whether given is the session's password, counting
+// it as a failed attempt if not. It compares digests so that the time it
+// takes depends neither on the password nor on how much of it was right.
+func (s *session) checkPassword(given string) bool {
+	want, got := sha256.Sum256([]byte(s.password)), sha256.Sum256([]byte(given))
+	if subtle.ConstantTimeCompare(want[:], got[:]) == 1 {
+		return true
+	}
+	s.failedAuth()
+	return false
+}
+
+// checkUser reports whether user is the only user there is, "default", as
+// RESP clients name it, counting it as a failed attempt if not.
+func (s *session) checkUser(user string) bool {
+	if user == "default" {
+		return true
+	}
+	s.failedAuth()
+	return false
+}
+
+func (s *session) failedAuth() {
+	if s.cache != nil {
+		s.cache.counters.failedAuth.Add(1)
+	}
+}
+
+// cmdAuth unlocks the connection. Once it is unlocked a wrong password
+// does not lock it again, though it is still counted.
+func (s *session) cmdAuth(in *bufio.Reader, out *reply, line string, parts []string) {
+	if s.password == "" {
+		out.Error(CodeNotAllowed, "AUTH given but no password is configured")
+		return
+	}
+	if !s.checkPassword(parts[1]) {
+		out.Error(CodeInvalid, "Invalid password")
+		return
+	}
+	out.locked = false
+	out.OK()
+}
+
+// requireBearer rejects HTTP requests that do not carry the session's
+// password as "Authorization: Bearer <password>".
+func (s *session) requireBearer(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			s.failedAuth()
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, CodeNoAuth, "authentication required")
+			return
+		}
+		if !s.checkPassword(token) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeJSONError(w, CodeNoAuth, "invalid password")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
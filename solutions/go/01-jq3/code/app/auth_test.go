@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newAuthSession returns a server session with the password secret.
+func newAuthSession(t *testing.T) *session {
+	t.Helper()
+	s := newServerSession(t, 3)
+	s.password = "secret"
+	return s
+}
+
+func failedAuths(s *session) int {
+	return s.cache.Stats().FailedAuth
+}
+
+func TestAuthOverTCP(t *testing.T) {
+	s := newAuthSession(t)
+	c := dial(t, startTCP(t, s))
+
+	// Without AUTH every command is refused, and counted.
+	if got, want := c.do("GET a"), "ERROR ERR_NOAUTH Authentication required"; got != want {
+		t.Fatalf("GET before AUTH = %q, want %q", got, want)
+	}
+	if got := failedAuths(s); got != 1 {
+		t.Errorf("failed_auth after a refused command = %d, want 1", got)
+	}
+	for _, tc := range []struct{ line, want string }{
+		{"AUTH wrong", "ERROR ERR_INVALID Invalid password"},
+		{"PUT a 1", "ERROR ERR_NOAUTH Authentication required"},
+		{"AUTH", "ERROR ERR_ARITY AUTH requires password argument"},
+		{"AUTH secret", "OK"},
+		{"PUT a 1", "OK"},
+		// A wrong password once in does not lock the connection again.
+		{"AUTH wrong", "ERROR ERR_INVALID Invalid password"},
+		{"GET a", "1"},
+		{"AUTH secret", "OK"},
+		{"GET a", "1"},
+	} {
+		if got := c.do(tc.line); got != tc.want {
+			t.Fatalf("%s = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+	if got := failedAuths(s); got != 4 {
+		t.Errorf("failed_auth = %d, want 4", got)
+	}
+
+	// Another connection starts locked, whatever the first did.
+	if got := dial(t, startTCP(t, s)).do("GET a"); got != "ERROR ERR_NOAUTH Authentication required" {
+		t.Errorf("GET on a new connection = %q", got)
+	}
+	// Stdin never needs it.
+	script(t, s, "GET a", "1")
+}
+
+func TestAuthWithoutPassword(t *testing.T) {
+	s := newServerSession(t, 3)
+	c := dial(t, startTCP(t, s))
+	if got, want := c.do("AUTH secret"), "ERROR ERR_NOT_ALLOWED AUTH given but no password is configured"; got != want {
+		t.Errorf("AUTH = %q, want %q", got, want)
+	}
+	if got := c.do("PUT a 1"); got != "OK" {
+		t.Errorf("PUT = %q", got)
+	}
+}
+
+func TestAuthOverRESP(t *testing.T) {
+	s := newAuthSession(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.runRESP(server)
+	r := bufio.NewReader(client)
+	send := func(args ...string) string {
+		t.Helper()
+		req := fmt.Sprintf("*%d\r\n", len(args))
+		for _, arg := range args {
+			req += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+		}
+		if _, err := client.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSuffix(line, "\r\n")
+	}
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "a"}, "-NOAUTH Authentication required."},
+		{[]string{"AUTH", "wrong"}, "-WRONGPASS invalid username-password pair"},
+		{[]string{"AUTH", "admin", "secret"}, "-WRONGPASS invalid username-password pair"},
+		{[]string{"AUTH", "default", "secret"}, "+OK"},
+		{[]string{"SET", "a", "1"}, "+OK"},
+		{[]string{"AUTH", "wrong"}, "-WRONGPASS invalid username-password pair"},
+		{[]string{"AUTH", "secret"}, "+OK"},
+	} {
+		if got := send(tc.args...); got != tc.want {
+			t.Fatalf("%q = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+	if got := failedAuths(s); got != 4 {
+		t.Errorf("failed_auth = %d, want 4", got)
+	}
+}
+
+func TestRequireBearer(t *testing.T) {
+	s := newAuthSession(t)
+	srv := httptest.NewServer(s.requireBearer(newHTTPHandler(s)))
+	defer srv.Close()
+	s.cache.Put("a", StringValue("1"))
+
+	for _, tc := range []struct {
+		name, header string
+		status       int
+		challenge    string
+	}{
+		{"no token", "", http.StatusUnauthorized, "Bearer"},
+		{"not a bearer token", "Basic c2VjcmV0", http.StatusUnauthorized, "Bearer"},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"right token", "Bearer secret", http.StatusOK, ""},
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+"/cache/a", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, resp.StatusCode, tc.status)
+		}
+		if got := resp.Header.Get("WWW-Authenticate"); got != tc.challenge {
+			t.Errorf("%s: WWW-Authenticate %q, want %q", tc.name, got, tc.challenge)
+		}
+	}
+	if got := failedAuths(s); got != 3 {
+		t.Errorf("failed_auth = %d, want 3", got)
+	}
+}
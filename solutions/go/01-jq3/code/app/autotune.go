@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ErrAutotuning is returned by SetProfile while autotuning owns the
+// profiler.
+var ErrAutotuning = errors.New("profiling is in use by AUTOTUNE")
+
+// Autotuning sizes the cache from the profiler's ghost caches. It profiles
+// the cache at half, once and twice its size, and every interval lookups
+// compares the hit ratios the three ghosts had over that interval: the
+// cache doubles if twice its size would have hit at least autotuneGain
+// more often, and otherwise halves if half its size would have hit at most
+// autotuneLoss less often, in either case staying within [min, max].
+// Ghosts are compared with the 1x ghost rather than the real cache, since
+// all three model LRU whatever the policy. The check runs as the write lock
+// is released, once the operation that crossed the interval is done with
+// its entries, and resizes exactly as Resize does, so a scripted trace
+// tunes the same way every run.
+const (
+	autotuneGain = 0.01
+	autotuneLoss = 0.002
+)
+
+// autotuneSizes are the profile multiples autotuning compares.
+var autotuneSizes = []float64{0.5, 1, 2}
+
+type autotuner struct {
+	min, max, interval int
+	// report is called with the cache locked after each adjustment.
+	report func(from, to int, sizes []ProfileSize)
+	// last holds the profile as of the last check, lookups the real
+	// cache's lookup count then.
+	last    []ProfileSize
+	lookups int
+}
+
+// StartAutotune starts resizing the cache within [min, max], in the unit
+// of its budget, every interval lookups, replacing any profiling or
+// autotuning already under way. report, if not nil, is called with the
+// cache locked after each adjustment and must not call back into it.
+func (c *LRUCache[K, V]) StartAutotune(min, max, interval int, report func(from, to int, sizes []ProfileSize)) error {
+	if min < 1 || max < min || interval < 1 {
+		return fmt.Errorf("autotune needs 1 <= min <= max and interval >= 1")
+	}
+	c.lock()
+	defer c.unlock()
+
+	p := newProfiler[K](autotuneSizes, c.budget())
+	c.profile.Store(p)
+	c.tuner = &autotuner{min: min, max: max, interval: interval, report: report, last: p.report(c.budget())}
+	return nil
+}
+
+// StopAutotune stops autotuning, leaving the capacity where it is, and
+// stops the profiling that fed it.
+func (c *LRUCache[K, V]) StopAutotune() {
+	c.lock()
+	defer c.unlock()
+
+	if c.tuner != nil {
+		c.tuner = nil
+		c.profile.Store(nil)
+	}
+}
+
+// autotune checks whether an interval has passed since the last check and,
+// if so, resizes the cache as the profile suggests. The caller holds the
+// write lock.
+func (c *LRUCache[K, V]) autotune() {
+	t, p := c.tuner, c.profile.Load()
+	if t == nil || p == nil || c.readOnly || p.lookups()-t.lookups < t.interval {
+		return
+	}
+	budget := c.budget()
+	sizes := p.report(budget)
+	lookups := sizes[0].Hits + sizes[0].Misses
+	// sizes[1:] are the 0.5x, 1x and 2x ghosts.
+	ratio := func(i int) float64 {
+		return hitRatio(sizes[i].Hits-t.last[i].Hits, sizes[i].Misses-t.last[i].Misses)
+	}
+	half, same, double := ratio(1), ratio(2), ratio(3)
+	t.last, t.lookups = sizes, lookups
+
+	target := budget
+	switch {
+	case double-same >= autotuneGain:
+		target = min(t.max, budget*2)
+	case same-half <= autotuneLoss:
+		target = max(t.min, budget/2)
+	}
+	// A budget pushed outside the range by RESIZE comes back within it.
+	target = min(max(target, t.min), t.max)
+	if target == budget {
+		return
+	}
+	c.resize(target)
+	c.counters.autotunes.Add(1)
+	if t.report != nil {
+		t.report(budget, target, sizes)
+	}
+}
+
+// autotuneStatus describes the autotuning in effect, or returns "" if it
+// is off.
+func (c *LRUCache[K, V]) autotuneStatus() string {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	if c.tuner == nil {
+		return ""
+	}
+	return fmt.Sprintf("min=%d max=%d interval=%d", c.tuner.min, c.tuner.max, c.tuner.interval)
+}
+
+func (s *session) cmdAutotune(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) == 1 {
+		if status := s.cache.autotuneStatus(); status != "" {
+			out.Value("ON " + status)
+		} else {
+			out.Value("OFF")
+		}
+		return
+	}
+	switch parts[1] {
+	case "ON":
+		if len(parts) != 5 {
+			out.Error(CodeArity, "AUTOTUNE ON requires min, max and interval arguments")
+			return
+		}
+		var n [3]int
+		for i, arg := range parts[2:] {
+			v, err := strconv.Atoi(arg)
+			if err != nil {
+				out.Errorf(CodeInvalid, "Invalid number: %s", arg)
+				return
+			}
+			n[i] = v
+		}
+		err := s.cache.StartAutotune(n[0], n[1], n[2], func(from, to int, sizes []ProfileSize) {
+			fmt.Fprintf(os.Stderr, "AUTOTUNE %d -> %d\n", from, to)
+		})
+		if err != nil {
+			out.Err(err)
+			return
+		}
+	case "OFF":
+		if len(parts) > 2 {
+			out.Error(CodeArity, "AUTOTUNE OFF takes no arguments")
+			return
+		}
+		s.cache.StopAutotune()
+	default:
+		out.Errorf(CodeUnknownCommand, "Unknown AUTOTUNE subcommand: %s", parts[1])
+		return
+	}
+	out.OK()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrBacking wraps the errors a backing store returns, so that they can be
+// told from the cache's own.
+var ErrBacking = errors.New("backing store")
+
+// BackingStore is a durable store a cache can front; see SetBackingStore.
+// Load reports false for a key it does not hold, and Delete of such a key
+// is not an error.
+type BackingStore[K comparable, V any] interface {
+	Load(key K) (V, bool, error)
+	Store(key K, value V) error
+	Delete(key K) error
+}
+
+// SetBackingStore makes the cache write through to store, or stops if
+// store is nil. Every write that stores a value, from Put to Append and
+// Modify, stores it in the backing store first and fails, leaving the
+// cache as it was, if that fails. The writes that depend on the value
+// already there, Increment, Append, Modify and the conditional puts, load
+// a key missing from the cache from the store first. GetThrough and
+// RemoveThrough extend reads and deletes to it; Get, Remove and the other
+// removals, eviction and expiry among them, leave the store alone, so an
+// evicted key can be read back. The store keeps no TTLs: an expired entry
+// read back is live again, without one.
+//
+// The store is called with the cache locked, so its writes happen in the
+// same order as the cache's and a slow store slows every caller.
+func (c *LRUCache[K, V]) SetBackingStore(store BackingStore[K, V]) {
+	c.lock()
+	defer c.unlock()
+	c.backing = store
+}
+
+// writeThrough stores value under key in the backing store, if there is
+// one.
+func (c *LRUCache[K, V]) writeThrough(key K, value V) error {
+	if c.backing == nil {
+		return nil
+	}
+	if err := c.backing.Store(key, value); err != nil {
+		logs.log(levelWarn, "backing store write failed", "key", key, "error", err)
+		return fmt.Errorf("%w: %w", ErrBacking, err)
+	}
+	return nil
+}
+
+// GetThrough is Get that, on a miss, loads key from the backing store and
+// caches it. A miss in the cache is counted as one whether or not the
+// store has the key; a key found there is also counted in
+// Stats.BackingHits. A value the cache refuses, for its size or because
+// the cache is full, is still returned.
+func (c *LRUCache[K, V]) GetThrough(key K) (V, bool, error) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	if value, ok := c.get(key); ok {
+		return value, true, nil
+	}
+	value, ok, err := c.load(key)
+	if ok && !c.readOnly {
+		c.write(key, value, 0, c.defaultCost(key, value), false)
+	}
+	return value, ok, err
+}
+
+// lookupThrough is lookup that, on a miss, loads key from the backing store
+// into the cache first. It fails if the cache cannot hold the value.
+func (c *LRUCache[K, V]) lookupThrough(key K) (*Node[K, V], bool, error) {
+	if node, ok := c.lookup(key); ok {
+		return node, true, nil
+	}
+	value, ok, err := c.load(key)
+	if !ok {
+		return nil, false, err
+	}
+	if err := c.write(key, value, 0, c.defaultCost(key, value), false); err != nil {
+		return nil, false, err
+	}
+	node, ok := c.lookup(key)
+	return node, ok, nil
+}
+
+// load reads key from the backing store, if there is one, counting it in
+// Stats.BackingHits if found. A key with a tombstone is reported missing
+// without asking the store, and a key the store lacks leaves one.
+func (c *LRUCache[K, V]) load(key K) (V, bool, error) {
+	var zero V
+	if c.backing == nil || c.negativeHit(key) {
+		return zero, false, nil
+	}
+	value, ok, err := c.backing.Load(key)
+	if err != nil {
+		logs.log(levelWarn, "backing store read failed", "key", key, "error", err)
+		return zero, false, fmt.Errorf("%w: %w", ErrBacking, err)
+	}
+	if !ok {
+		c.addTombstone(key)
+		return zero, false, nil
+	}
+	c.counters.backingHits.Add(1)
+	return value, true, nil
+}
+
+// RemoveThrough is Remove that deletes key from the backing store too,
+// first, and reports whether either held it. If the store fails the cache
+// is left as it was.
+func (c *LRUCache[K, V]) RemoveThrough(key K) (bool, error) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.cache[key]
+	if !ok && c.l2 != nil {
+		ok = c.l2.Contains(key)
+	}
+	if c.backing != nil {
+		if !ok {
+			var err error
+			if _, ok, err = c.backing.Load(key); err != nil {
+				logs.log(levelWarn, "backing store read failed", "key", key, "error", err)
+				return false, fmt.Errorf("%w: %w", ErrBacking, err)
+			}
+		}
+		if err := c.backing.Delete(key); err != nil {
+			logs.log(levelWarn, "backing store delete failed", "key", key, "error", err)
+			return false, fmt.Errorf("%w: %w", ErrBacking, err)
+		}
+	}
+	if node != nil {
+		c.remove(node, RemovalDeleted)
+	} else if c.l2 != nil {
+		c.l2.Remove(key)
+	}
+	return ok, nil
+}
+
+// dirStore is the backing store --backing names: a directory with one file
+// per key holding its value as JSON, so lists and hashes survive too. File
+// names are the keys in unpadded URL-safe base64, which any key can be
+// written in, though very long keys exceed what file systems allow. Each
+// value is written to a temporary file and renamed over the old one, so a
+// crash leaves either value but never half of one.
+type dirStore struct {
+	dir string
+}
+
+func openDirStore(dir string) (*dirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &dirStore{dir: dir}, nil
+}
+
+func (d *dirStore) path(key string) string {
+	return filepath.Join(d.dir, base64.RawURLEncoding.EncodeToString([]byte(key)))
+}
+
+func (d *dirStore) Load(key string) (Value, bool, error) {
+	data, err := os.ReadFile(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Value{}, false, nil
+	}
+	if err != nil {
+		return Value{}, false, err
+	}
+	var v Value
+	if err := json.Unmarshal(data, &v); err != nil {
+		return Value{}, false, fmt.Errorf("%s: %v", d.path(key), err)
+	}
+	return v, true, nil
+}
+
+func (d *dirStore) Store(key string, value Value) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	path := d.path(key)
+	f, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (d *dirStore) Delete(key string) error {
+	err := os.Remove(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
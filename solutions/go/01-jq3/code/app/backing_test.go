@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// failingStore is a map-backed store whose operations fail, with err, for
+// as long as the matching field is set.
+type failingStore struct {
+	data                    map[string]Value
+	err                     error
+	load, store, deleteKeys bool
+}
+
+func newFailingStore() *failingStore {
+	return &failingStore{data: map[string]Value{}, err: errors.New("disk on fire")}
+}
+
+func (f *failingStore) Load(key string) (Value, bool, error) {
+	if f.load {
+		return Value{}, false, f.err
+	}
+	v, ok := f.data[key]
+	return v, ok, nil
+}
+
+func (f *failingStore) Store(key string, value Value) error {
+	if f.store {
+		return f.err
+	}
+	f.data[key] = value
+	return nil
+}
+
+func (f *failingStore) Delete(key string) error {
+	if f.deleteKeys {
+		return f.err
+	}
+	delete(f.data, key)
+	return nil
+}
+
+// newBackedSession returns a session with its default cache, of capacity,
+// written through to a directory store in dir.
+func newBackedSession(t *testing.T, dir string, capacity int) *session {
+	t.Helper()
+	s := newTestSession(t)
+	store, err := openDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.backing = store
+	script(t, s, "INIT "+strconv.Itoa(capacity), "OK")
+	return s
+}
+
+func TestBackingReadsEvictedKeysBack(t *testing.T) {
+	dir := t.TempDir()
+	s := newBackedSession(t, dir, 2)
+	script(t, s,
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"PUT c 3", "OK",
+		"EXISTS a", "0",
+		"GET a", "1",
+		"GET c", "3",
+		"GET nowhere", "NULL",
+	)
+	st := s.cache.Stats()
+	if st.Hits != 1 || st.Misses != 2 || st.BackingHits != 1 {
+		t.Errorf("hits %d, misses %d, backing hits %d; want 1, 2, 1", st.Hits, st.Misses, st.BackingHits)
+	}
+	if got := s.Execute("STATS"); !strings.Contains(got, " backing_hits=1") {
+		t.Errorf("STATS = %q", got)
+	}
+
+	// A new process on the same directory sees every key written.
+	s2 := newBackedSession(t, dir, 10)
+	script(t, s2, "GET a", "1", "GET b", "2", "GET c", "3")
+}
+
+func TestBackingDeletePropagates(t *testing.T) {
+	dir := t.TempDir()
+	s := newBackedSession(t, dir, 1)
+	script(t, s,
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		// a is only in the store now.
+		"DELETE a", "OK",
+		"DELETE b", "OK",
+		"GET a", "NULL",
+		"GET b", "NULL",
+	)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("the store still holds %d files after the deletes", len(entries))
+	}
+}
+
+func TestBackingErrorsAreResponses(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s, "INIT 2", "OK", "PUT a 1", "OK")
+	store := newFailingStore()
+	s.cache.SetBackingStore(store)
+
+	store.store = true
+	if got := s.Execute("PUT a 2"); !strings.HasPrefix(got, "ERROR ERR_IO") {
+		t.Errorf("PUT with a failing store = %q", got)
+	}
+	script(t, s, "GET a", "1")
+
+	store.load = true
+	if got := s.Execute("GET missing"); !strings.HasPrefix(got, "ERROR ERR_IO") {
+		t.Errorf("GET with a failing store = %q", got)
+	}
+
+	store.deleteKeys = true
+	if got := s.Execute("DELETE a"); !strings.HasPrefix(got, "ERROR ERR_IO") {
+		t.Errorf("DELETE with a failing store = %q", got)
+	}
+	script(t, s, "GET a", "1", "SIZE", "1", "DEBUG CHECK", "OK")
+
+	*store = failingStore{data: store.data}
+	script(t, s, "PUT b 2", "OK", "DELETE a", "OK", "SIZE", "1")
+	if _, ok := store.data["a"]; ok {
+		t.Error("DELETE left a in the store")
+	}
+	if v, err := store.data["b"].Str(); v != "2" || err != nil {
+		t.Errorf("the store holds b = %q, %v", v, err)
+	}
+}
+
+func TestBackingErrorsWrapErrBacking(t *testing.T) {
+	c := NewLRUCache[string, Value](2)
+	store := newFailingStore()
+	c.SetBackingStore(store)
+	store.store, store.load, store.deleteKeys = true, true, true
+	if err := c.Put("a", StringValue("1")); !errors.Is(err, ErrBacking) || !errors.Is(err, store.err) {
+		t.Errorf("Put: %v", err)
+	}
+	if c.Contains("a") {
+		t.Error("a failed write-through was cached")
+	}
+	if _, _, err := c.GetThrough("a"); !errors.Is(err, ErrBacking) {
+		t.Errorf("GetThrough: %v", err)
+	}
+	if _, err := c.RemoveThrough("a"); !errors.Is(err, ErrBacking) {
+		t.Errorf("RemoveThrough: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+// A writeBatch holds back the removals made while a batch of writes is
+// applied, MPUT's or a preload file's, and reports them once the batch is
+// done, so that the handler and the counters see what the batch did rather
+// than each step of it. The writes themselves are made one by one as Put
+// would make them, so the cache ends up exactly as it would have without a
+// batch. A key is then reported at most once:
+//
+//   - a key the batch leaves cached is not reported evicted, even if an
+//     earlier write in the batch evicted it; if it was cached before the
+//     batch, its value from then is reported replaced,
+//   - any other key is reported for the last way it left, with the value
+//     it left with.
+//
+// Restore, Import and LOAD MERGE already apply their entries in one pass
+// and need no batch.
+type writeBatch[K comparable, V any] struct {
+	// order lists the keys with removals, first removed first.
+	order []K
+	held  map[K]*heldRemovals[K, V]
+	// written marks the keys the batch has written so far.
+	written map[K]bool
+}
+
+// heldRemovals are the first and last removals of one key in a batch.
+// before is set if the first was of the value the key had before the
+// batch.
+type heldRemovals[K comparable, V any] struct {
+	first, last removal[K, V]
+	before      bool
+}
+
+func (b *writeBatch[K, V]) hold(r removal[K, V]) {
+	if h := b.held[r.key]; h != nil {
+		h.last = r
+		return
+	}
+	b.order = append(b.order, r.key)
+	b.held[r.key] = &heldRemovals[K, V]{first: r, last: r, before: !b.written[r.key]}
+}
+
+// beginBatch starts holding back removals; see writeBatch. The caller
+// holds the write lock and marks each key in c.batch.written once it has
+// written it.
+func (c *LRUCache[K, V]) beginBatch() {
+	c.batch = &writeBatch[K, V]{held: make(map[K]*heldRemovals[K, V]), written: make(map[K]bool)}
+}
+
+// endBatch records the removals the batch held back, once per key.
+func (c *LRUCache[K, V]) endBatch() {
+	b := c.batch
+	c.batch = nil
+	for _, key := range b.order {
+		h := b.held[key]
+		switch _, cached := c.cache[key]; {
+		case cached && h.before:
+			c.record(key, h.first.value, RemovalReplaced)
+		case !cached:
+			c.record(key, h.last.value, h.last.reason)
+		}
+	}
+}
+
+// PutEach stores pairs in order under a single lock, as if Put had been
+// called for each one, skipping those the cache refuses, and returns the
+// error for each pair, nil for those stored. Removals are reported once
+// per key for the whole batch.
+func (c *LRUCache[K, V]) PutEach(pairs []KV[K, V]) []error {
+	errs := make([]error, len(pairs))
+	c.lock()
+	defer c.unlock()
+
+	c.beginBatch()
+	for i, p := range pairs {
+		key := c.normalize(p.Key)
+		if errs[i] = c.put(key, p.Value, 0, c.defaultCost(key, p.Value)); errs[i] == nil {
+			c.batch.written[key] = true
+		}
+	}
+	c.endBatch()
+	return errs
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// removals records what c's removal handler is told, as "key reason value".
+func removals(c *LRUCache[string, int]) *[]string {
+	var got []string
+	c.SetRemovalHandler(func(key string, value int, reason RemovalReason) {
+		got = append(got, fmt.Sprintf("%s %s %d", key, reason, value))
+	})
+	return &got
+}
+
+func TestPutEachReportsEachKeyOnce(t *testing.T) {
+	pairs := []KV[string, int]{{"x", 10}, {"a", 11}, {"y", 12}, {"a", 13}, {"b", 14}, {"z", 15}, {"x", 16}}
+	fill := func() *LRUCache[string, int] {
+		c := NewLRUCache[string, int](3)
+		c.Put("a", 1)
+		c.Put("b", 2)
+		c.Put("c", 3)
+		return c
+	}
+
+	// One Put at a time, a, b and x are each evicted and written back,
+	// and a is evicted a second time.
+	seq := fill()
+	seqRemovals := removals(seq)
+	for _, p := range pairs {
+		seq.Put(p.Key, p.Value)
+	}
+
+	c := fill()
+	got := removals(c)
+	for i, err := range c.PutEach(pairs) {
+		if err != nil {
+			t.Fatalf("pair %d: %v", i, err)
+		}
+	}
+	if !slices.Equal(c.Keys(), seq.Keys()) {
+		t.Fatalf("keys %v after the batch, %v one Put at a time", c.Keys(), seq.Keys())
+	}
+	// b is cached before and after, so its old value is reported
+	// replaced; a, c and y are gone, with their last removal; x came and
+	// stayed, so there is nothing to say about it.
+	want := []string{"a evicted 13", "b replaced 2", "c evicted 3", "y evicted 12"}
+	if !slices.Equal(*got, want) {
+		t.Errorf("batch removals %q, want %q", *got, want)
+	}
+	seen := map[string]bool{}
+	for _, r := range *got {
+		key, _, _ := strings.Cut(r, " ")
+		if seen[key] {
+			t.Errorf("%s reported twice", key)
+		}
+		seen[key] = true
+	}
+	if len(*got) >= len(*seqRemovals) {
+		t.Errorf("batch reported %d removals, no fewer than the %d of one Put at a time: %q", len(*got), len(*seqRemovals), *seqRemovals)
+	}
+	if st := c.Stats(); st.Evictions != 3 || st.Replaced != 1 {
+		t.Errorf("stats evictions=%d replaced=%d, want 3 and 1", st.Evictions, st.Replaced)
+	}
+}
+
+func TestMPUTReportsEachEvictionOnce(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 3", "OK",
+		"MPUT a 1 b 2 c 3", "OK 3",
+	)
+	stderr := captureStderr(t)
+	script(t, s,
+		"MPUT x 1 a 2 y 3 a 4 z 5", "OK 5",
+		"KEYS", "z a y",
+	)
+	// One Put at a time evicts a, b, c and x. a is back, so it is only
+	// replaced; x was written and evicted within the batch.
+	if got, want := stderr.String(), "EVICT b\nEVICT c\nEVICT x\n"; got != want {
+		t.Errorf("stderr %q, want %q", got, want)
+	}
+}
+
+func TestBatchDuplicateKeyTakesLastValue(t *testing.T) {
+	pairs := []KV[string, int]{{"a", 1}, {"b", 2}, {"a", 3}}
+	for _, tc := range []struct {
+		name string
+		put  func(c *LRUCache[string, int]) error
+	}{
+		{"PutMulti", func(c *LRUCache[string, int]) error { return c.PutMulti(pairs) }},
+		{"PutEach", func(c *LRUCache[string, int]) error { return errors.Join(c.PutEach(pairs)...) }},
+	} {
+		c := NewLRUCache[string, int](3)
+		c.Put("a", 0)
+		got := removals(c)
+		if err := tc.put(c); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if v, ok := c.Peek("a"); !ok || v != 3 {
+			t.Errorf("%s: a = %d, %v, want the last value, 3", tc.name, v, ok)
+		}
+		// The repeat counts as a's last write, so a is most recent.
+		if keys, want := c.Keys(), []string{"a", "b"}; !slices.Equal(keys, want) {
+			t.Errorf("%s: keys %q, want %q", tc.name, keys, want)
+		}
+		if want := []string{"a replaced 0"}; !slices.Equal(*got, want) {
+			t.Errorf("%s: removals %q, want %q", tc.name, *got, want)
+		}
+		if err := c.DebugCheck(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestMPUTDuplicateKeyTakesLastValue(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 3", "OK",
+		"MPUT a 1 b 2 a 3", "OK 3",
+		"GET a", "3",
+		"KEYS", "a b",
+		"MPUT c 1 c 2 c 3", "OK 3",
+		"GET c", "3",
+		"KEYS", "c a b",
+		"DEBUG CHECK", "OK",
+	)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// benchResult summarises one run of runBench.
+type benchResult struct {
+	Ops       int     `json:"ops"`
+	Elapsed   float64 `json:"elapsed_seconds"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	Hits      int     `json:"hits"`
+	HitRatio  float64 `json:"hit_ratio"`
+	Evictions int     `json:"evictions"`
+}
+
+func (r benchResult) String() string {
+	return fmt.Sprintf("ops=%d elapsed=%.3fs ops_per_sec=%.0f hit_ratio=%.4f evictions=%d",
+		r.Ops, r.Elapsed, r.OpsPerSec, r.HitRatio, r.Evictions)
+}
+
+// runBench issues ops operations against cache from a single goroutine,
+// drawing each key index from nextKey. Each operation is a Get with
+// probability readRatio and a Put otherwise. The hit ratio counts only the
+// Gets. Everything is driven by rng, so the same seed gives the same
+// sequence of operations.
+func runBench(cache *LRUCache[string, Value], rng *rand.Rand, nextKey func() int, ops int, readRatio float64) benchResult {
+	before := cache.Stats().Evictions
+	gets, hits := 0, 0
+	start := time.Now()
+	for i := 0; i < ops; i++ {
+		key := "k" + strconv.Itoa(nextKey())
+		if rng.Float64() < readRatio {
+			gets++
+			if _, ok := cache.Get(key); ok {
+				hits++
+			}
+			continue
+		}
+		cache.Put(key, StringValue(strconv.Itoa(i)))
+	}
+	elapsed := time.Since(start).Seconds()
+
+	r := benchResult{
+		Ops:       ops,
+		Elapsed:   elapsed,
+		Hits:      hits,
+		Evictions: cache.Stats().Evictions - before,
+	}
+	if elapsed > 0 {
+		r.OpsPerSec = float64(ops) / elapsed
+	}
+	if gets > 0 {
+		r.HitRatio = float64(hits) / float64(gets)
+	}
+	return r
+}
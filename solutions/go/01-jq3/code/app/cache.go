@@ -0,0 +1,1654 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTooLarge is returned when a single entry is larger than the cache's
+// entire budget, so it could never fit no matter how much is evicted.
+var ErrTooLarge = errors.New("entry exceeds cache budget")
+
+// ErrKeyTooLong and ErrValueTooLong are returned for writes that exceed the
+// limits set with SetLimits.
+var (
+	ErrKeyTooLong   = errors.New("key too long")
+	ErrValueTooLong = errors.New("value too long")
+)
+
+// ErrNotFound is returned by operations that require an existing key.
+var ErrNotFound = errors.New("no such key")
+
+// ErrExpired is returned by Fetch for a key whose entry has expired, which
+// a lookup removes unless the entry is in its grace period.
+var ErrExpired = errors.New("key expired")
+
+// ErrNotWeighted is returned by PutWeighted on a cache that was not created
+// with a weight budget.
+var ErrNotWeighted = errors.New("cache is not weighted")
+
+// ErrInvalidWatermark is returned by SetLowWatermark for a watermark that
+// is negative or not below the capacity, and for caches without an entry
+// capacity.
+var ErrInvalidWatermark = errors.New("low watermark must be below capacity on a count-bounded cache")
+
+// ErrInvalidCost is returned by PutWeighted for costs smaller than 1.
+var ErrInvalidCost = errors.New("cost must be positive")
+
+// The cache pairs a hash map with an eviction policy. The map finds an
+// entry's node in O(1); the policy threads the same nodes through its own
+// intrusive lists, so promoting an entry, inserting it and evicting a victim
+// are all O(1) pointer updates with no searching.
+
+// Node is a cache entry. Besides the key and value it carries the intrusive
+// links used by the eviction policies.
+type Node[K comparable, V any] struct {
+	key      K
+	value    V
+	expireAt time.Time     // zero means the entry never expires
+	idleAt   time.Time     // when it goes idle unused; zero without a max idle time
+	ttl      time.Duration // lifetime expireAt was set from, renewed by Touch
+	grace    time.Duration // how long past expireAt GetStale still returns it
+	cost     int           // size charged against the cache budget
+	version  uint64        // 1 when created, bumped on each change of value
+	seq      uint64        // number of the write that stored the value; see Seq
+	etag     uint64        // hash of the value; see SetETags
+	parsed   *parsedJSON   // what GetJSON last decoded, or nil
+
+	// revalidating is set once GetStale has elected a caller to refresh
+	// the expired entry.
+	revalidating bool
+
+	// Diagnostics reported by EntryInfo.
+	createdAt  time.Time
+	accessedAt time.Time
+	writtenAt  time.Time // last change of value, for GetIfFresh
+	hits       int
+
+	prev, next *Node[K, V]
+	list       *nodeList[K, V]
+	bucket     *lfuBucket[K, V]
+	slot       int      // position in the CLOCK ring or the SAMPLED or LRUK slice
+	ref        bool     // CLOCK reference bit
+	tick       uint64   // SAMPLED access clock at the last access
+	history    []uint64 // LRUK access clock at the last k accesses
+	pinned     bool     // on the cache's pinned list rather than in the policy
+	priority   Priority // low and high entries are on the cache's tier lists
+	tenant     string   // owner of the entry, "" for the default pool; see SetTenant
+	index      int      // position in the cache's resident slice
+	saved      int      // bytes compression saves on value; see savings
+	stored     slabRef  // where the value's bytes are with compact storage
+}
+
+func (n *Node[K, V]) expired(now time.Time) bool {
+	return (!n.expireAt.IsZero() && !now.Before(n.expireAt)) || n.idle(now)
+}
+
+// LRUCache represents a Least Recently Used cache. It is safe for concurrent
+// use; a single lock guards the map and the list. Get usually takes it only
+// for reading and defers promoting the entry to the next write, so the
+// recency order among concurrent readers is approximate; see readBuffer.
+//
+// Keys may be of any comparable type and values of any type; lookups that
+// miss return the zero value of V alongside false.
+type LRUCache[K comparable, V any] struct {
+	mu sync.RWMutex
+	// writer is a one-slot semaphore held with mu's write lock and taken
+	// before it, so that a writer gives up waiting when its context is
+	// done; see lockCtx.
+	writer chan struct{}
+
+	capacity int // maximum entry count; 0 means unbounded
+	cache    map[K]*Node[K, V]
+	policy   EvictionPolicy[K, V]
+
+	// resident holds the same nodes as cache, in no particular order, for
+	// RandomKey to draw from with rng.
+	resident []*Node[K, V]
+	rng      *rand.Rand
+	// keys, if set, holds the keys in order too; see SetKeyIndex.
+	keys     *keyIndex
+	nodeSize int // per-entry overhead in memory estimates; see nodeOverhead
+
+	// When maxCost is positive the cache also evicts until the sum of entry
+	// costs fits within it. In byte-bounded mode sizer computes each cost;
+	// in weighted mode callers supply costs through PutWeighted.
+	sizer    func(key K, value V) int
+	maxCost  int
+	usedCost int
+
+	// maxIdle is how long an entry may go unused; 0 means forever. See
+	// SetMaxIdle.
+	maxIdle time.Duration
+	// ttlJitter spreads the deadlines TTLs give out; see SetTTLJitter.
+	ttlJitter float64
+
+	// compress, set by SetCompression, returns the form a value is
+	// stored in. savings, if set, reports how many bytes a value saves by
+	// being stored compressed; savedBytes is the total over the entries.
+	compress   func(value V) V
+	savings    func(value V) int
+	savedBytes int
+
+	// arena, set by SetCompactStorage, holds the bytes of the values
+	// storeIn copies into it.
+	arena   *slabArena
+	storeIn func(value V, a *slabArena) (V, slabRef)
+	// hasher, set by SetETags, works out each value's ETag.
+	hasher func(value V) uint64
+
+	// clock reports the current time through now. It defaults to the real
+	// clock and is replaced with SetClock to make expiry deterministic.
+	clock Clock
+
+	// onRemove is the removal handler; pending holds the removals made
+	// under the current write lock, reported by unlock.
+	onRemove func(key K, value V, reason RemovalReason)
+	pending  []removal[K, V]
+	// batch, while a batch write is applied, holds back its removals;
+	// see writeBatch.
+	batch *writeBatch[K, V]
+
+	// scanSeed fixes the order in which Scan visits keys.
+	scanSeed maphash.Seed
+
+	// backing, if set, is the store writes go through to; see
+	// SetBackingStore.
+	backing BackingStore[K, V]
+
+	// negative holds the tombstones of keys the backing store lacks, for
+	// negativeTTL, in tombstones oldest first; see SetNegativeTTL.
+	negativeTTL  time.Duration
+	negative     map[K]*list.Element
+	tombstones   *list.List
+	negativeCost int
+
+	// deleteRetention is how long deleted keys keep a tombstone among the
+	// others, and deleteSeq the number of the last; see SetDeleteRetention.
+	deleteRetention time.Duration
+	deleteSeq       uint64
+
+	// writeSeq is the number of the last write; see Seq.
+	writeSeq atomic.Uint64
+
+	// resetAt is when the cache is next cleared, in Unix nanoseconds, or
+	// 0; see ResetAt. It is written under the write lock and read under
+	// either.
+	resetAt atomic.Int64
+
+	// calls tracks GetOrCompute loads in flight so that concurrent
+	// callers for the same key share one call to the loader.
+	calls map[K]*call[V]
+
+	// keyLocks are the stripes LockKey and GetOrCompute lock, picked by
+	// the hash of the key with lockSeed.
+	keyLocks [keyStripes]sync.Mutex
+	lockSeed maphash.Seed
+
+	// maxKeyLen and maxValueLen bound the length in bytes of string keys
+	// and values; 0 means no limit.
+	maxKeyLen, maxValueLen int
+
+	// lowWater, when positive, is the size an overflowing cache is evicted
+	// down to in one batch instead of back to capacity.
+	lowWater int
+
+	noEvict   bool             // writes that need room fail with ErrFull; see SetNoEviction
+	janitor   *janitor         // nil unless StartJanitor is running one
+	memGuard  *memoryGuard     // nil unless StartMemoryGuard is running one
+	refresher *refresher[K, V] // nil unless StartRefresh has turned refresh-ahead on
+
+	// pinned holds the entries protected by Pin, which the policy does not
+	// know about; pinnedCost is their share of usedCost.
+	pinned     *nodeList[K, V]
+	pinnedCost int
+
+	// lowTier and highTier hold the unpinned entries of low and high
+	// priority, which the policy does not know about either; see Priority.
+	lowTier, highTier *nodeList[K, V]
+
+	// tenants holds the entries of each named tenant, which the policy
+	// does not know about either, caller the tenant calls are made for
+	// and tenantQuota the share of the budget each may hold; see
+	// SetTenant.
+	tenants     map[string]*tenantPool[K, V]
+	caller      string
+	tenantQuota int
+
+	// readOnly makes reads change nothing; see SetReadOnly.
+	readOnly bool
+
+	// free holds nodes released by removals for new entries to reuse.
+	free []*Node[K, V]
+
+	// sketch estimates access frequencies for the admission filter; nil
+	// when admission is off.
+	sketch *frequencySketch[K]
+
+	// counters only ever increase; statsBase is their value at the last
+	// ResetStats, which Stats reports them relative to.
+	counters  counters
+	statsBase Counters
+
+	// reads holds the Gets served under the read lock until they are
+	// applied; see lock.
+	reads readBuffer[K]
+
+	// latency is nil unless SetLatencySampling has turned the Get and Put
+	// histograms on. It is read without the lock.
+	latency atomic.Pointer[latencyHistograms]
+
+	// window is nil unless SetStatsWindow has started keeping the last
+	// lookups. It is read without the lock.
+	window atomic.Pointer[statsWindow]
+
+	// profile is nil unless SetProfile has started estimating the hit
+	// ratio at other sizes. It is read without the lock.
+	profile atomic.Pointer[profiler[K]]
+	// tuner is nil unless StartAutotune has handed the profile to it.
+	tuner *autotuner
+	// l2 is the second level of a tiered cache, or nil; see
+	// NewTieredCache.
+	l2 *LRUCache[K, V]
+	// onAccess is called for each Get with the lock held; see
+	// SetAccessHandler.
+	onAccess func(key K, hit bool)
+	// onWrite is called for each write and deletion with the lock held;
+	// see SetWriteHandler.
+	onWrite func(key K, seq uint64, deleted bool)
+	// normalizer is nil unless SetKeyNormalizer has set one. It is read
+	// without the lock.
+	normalizer atomic.Pointer[func(K) K]
+	// anyIndex is nil until the first GetAny builds it; see GetAny.
+	anyIndex atomic.Pointer[anyIndex[K, V]]
+	// hotKeys is nil unless SetHotKeys has started tracking.
+	hotKeys atomic.Pointer[hotKeys[K]]
+}
+
+// Stats is a point-in-time view of the cache counters. Counters are
+// cumulative since the cache was created or last reset.
+type Stats struct {
+	Hits        int `json:"hits"`
+	Misses      int `json:"misses"`
+	Evictions   int `json:"evictions"`
+	Expirations int `json:"expirations"`
+	// Deleted counts explicit removals and Replaced counts values
+	// overwritten in place; see RemovalReason.
+	Deleted  int `json:"deleted"`
+	Replaced int `json:"replaced"`
+	Idle     int `json:"idle,omitempty"`
+	// MemoryShed counts the entries the memory guard evicted.
+	MemoryShed int `json:"memory_shed,omitempty"`
+	// ScheduledReset counts the entries cleared by a reset scheduled with
+	// ResetAt, and ResetAt is when the next is due, if one is.
+	ScheduledReset int       `json:"scheduled_reset,omitempty"`
+	ResetAt        time.Time `json:"reset_at,omitzero"`
+	Rejected       int       `json:"rejected"` // writes refused for size or limits
+	// AdmissionRejected counts new keys the admission filter turned away.
+	AdmissionRejected int `json:"admission_rejected"`
+	// Refreshes and RefreshFailures count the background refreshes of
+	// refresh-ahead that replaced a value or failed.
+	Refreshes       int `json:"refreshes,omitempty"`
+	RefreshFailures int `json:"refresh_failures,omitempty"`
+	FailedAuth      int `json:"failed_auth,omitempty"`   // wrong or missing passwords in server mode
+	BackingHits     int `json:"backing_hits,omitempty"`  // misses served from the backing store
+	NegativeHits    int `json:"negative_hits,omitempty"` // misses a tombstone answered; see SetNegativeTTL
+	StaleRejects    int `json:"stale_rejects,omitempty"` // entries GetIfFresh found too old
+	Autotunes       int `json:"autotunes,omitempty"`     // capacity changes made by autotuning
+	Restarts        int `json:"restarts,omitempty"`      // background workers restarted after a panic
+	// Demotions and Promotions count the entries a tiered cache moved
+	// to its second level and back, and L2Size and L2Capacity describe
+	// the second level. Size is the first level's alone.
+	Demotions  int `json:"demotions,omitempty"`
+	Promotions int `json:"promotions,omitempty"`
+	L2Size     int `json:"l2_size,omitempty"`
+	L2Capacity int `json:"l2_capacity,omitempty"`
+	// LinkStatus and ReplicationLag, in seconds, describe the link of a
+	// replica to its primary. The cache leaves them empty; the server
+	// fills them in.
+	LinkStatus     string  `json:"link_status,omitempty"`
+	ReplicationLag float64 `json:"replication_lag,omitempty"`
+	// Window describes the last lookups, if SetStatsWindow keeps them.
+	Window *WindowStats `json:"window,omitempty"`
+	// Tenants describes each tenant named with SetTenant.
+	Tenants  map[string]TenantStats `json:"tenants,omitempty"`
+	Size     int                    `json:"size"`
+	Capacity int                    `json:"capacity"`
+	// EvictionBatches counts the writes and resizes that evicted anything,
+	// so Evictions/EvictionBatches is the average batch size. LowWatermark
+	// is 0 unless watermark mode is on.
+	EvictionBatches int `json:"eviction_batches"`
+	LowWatermark    int `json:"low_watermark,omitempty"`
+	UsedBytes       int `json:"used_bytes,omitempty"`
+	SavedBytes      int `json:"saved_bytes,omitempty"` // by compression; UsedBytes counts what is stored
+	MaxBytes        int `json:"max_bytes,omitempty"`   // 0 unless the cache is byte-bounded
+	UsedWeight      int `json:"used_weight,omitempty"`
+	MaxWeight       int `json:"max_weight,omitempty"` // 0 unless the cache is weighted
+	// PolicyInfo describes policy-specific state, if the policy has any.
+	PolicyInfo string `json:"policy_info,omitempty"`
+}
+
+// HitRatio returns the fraction of lookups that were hits, or 0 when there
+// have been no lookups yet.
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// MarshalJSON encodes the fields along with hit_ratio, so that every field
+// added to Stats reaches GET /stats and STATS in JSON mode without being
+// listed again.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	type fields Stats
+	return json.Marshal(struct {
+		fields
+		HitRatio float64 `json:"hit_ratio"`
+	}{fields(s), s.HitRatio()})
+}
+
+func (s Stats) String() string {
+	out := fmt.Sprintf("hits=%d misses=%d evictions=%d expirations=%d size=%d capacity=%d hit_ratio=%.2f",
+		s.Hits, s.Misses, s.Evictions, s.Expirations, s.Size, s.Capacity, s.HitRatio())
+	if s.Window != nil {
+		out += " " + s.Window.String()
+	}
+	if s.Deleted > 0 {
+		out += fmt.Sprintf(" deleted=%d", s.Deleted)
+	}
+	if s.Replaced > 0 {
+		out += fmt.Sprintf(" replaced=%d", s.Replaced)
+	}
+	if s.Idle > 0 {
+		out += fmt.Sprintf(" idle=%d", s.Idle)
+	}
+	if s.MemoryShed > 0 {
+		out += fmt.Sprintf(" memory_shed=%d", s.MemoryShed)
+	}
+	if s.ScheduledReset > 0 {
+		out += fmt.Sprintf(" scheduled_reset=%d", s.ScheduledReset)
+	}
+	if !s.ResetAt.IsZero() {
+		out += fmt.Sprintf(" reset_at=%d", s.ResetAt.Unix())
+	}
+	if s.Rejected > 0 {
+		out += fmt.Sprintf(" rejected=%d", s.Rejected)
+	}
+	if s.LowWatermark > 0 {
+		out += fmt.Sprintf(" low_watermark=%d eviction_batches=%d", s.LowWatermark, s.EvictionBatches)
+	}
+	if s.AdmissionRejected > 0 {
+		out += fmt.Sprintf(" admission_rejected=%d", s.AdmissionRejected)
+	}
+	if s.Refreshes > 0 || s.RefreshFailures > 0 {
+		out += fmt.Sprintf(" refreshes=%d refresh_failures=%d", s.Refreshes, s.RefreshFailures)
+	}
+	if s.FailedAuth > 0 {
+		out += fmt.Sprintf(" failed_auth=%d", s.FailedAuth)
+	}
+	if s.BackingHits > 0 {
+		out += fmt.Sprintf(" backing_hits=%d", s.BackingHits)
+	}
+	if s.NegativeHits > 0 {
+		out += fmt.Sprintf(" negative_hits=%d", s.NegativeHits)
+	}
+	if s.StaleRejects > 0 {
+		out += fmt.Sprintf(" stale_rejects=%d", s.StaleRejects)
+	}
+	if s.Autotunes > 0 {
+		out += fmt.Sprintf(" autotunes=%d", s.Autotunes)
+	}
+	if s.Restarts > 0 {
+		out += fmt.Sprintf(" restarts=%d", s.Restarts)
+	}
+	if s.L2Capacity > 0 {
+		out += fmt.Sprintf(" l2_size=%d l2_capacity=%d demotions=%d promotions=%d", s.L2Size, s.L2Capacity, s.Demotions, s.Promotions)
+	}
+	if s.LinkStatus != "" {
+		out += fmt.Sprintf(" link_status=%s replication_lag=%.3f", s.LinkStatus, s.ReplicationLag)
+	}
+	if s.MaxBytes > 0 {
+		out += fmt.Sprintf(" used_bytes=%d max_bytes=%d", s.UsedBytes, s.MaxBytes)
+	}
+	if s.SavedBytes > 0 {
+		out += fmt.Sprintf(" saved_bytes=%d", s.SavedBytes)
+	}
+	if s.MaxWeight > 0 {
+		out += fmt.Sprintf(" used_weight=%d max_weight=%d", s.UsedWeight, s.MaxWeight)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Tenants)) {
+		t := s.Tenants[name]
+		out += fmt.Sprintf(" tenant[%s]=size:%d,used:%d,quota:%d,hits:%d,misses:%d", name, t.Size, t.Used, t.Quota, t.Hits, t.Misses)
+	}
+	if s.PolicyInfo != "" {
+		out += " " + s.PolicyInfo
+	}
+	return out
+}
+
+// NewLRUCache creates a cache holding at most capacity entries. It panics if
+// capacity is less than 1.
+func NewLRUCache[K comparable, V any](capacity int) *LRUCache[K, V] {
+	return NewLRUCacheWithPolicy(capacity, newLRUPolicy[K, V]())
+}
+
+// NewLRUCacheWithPolicy creates a cache that selects eviction victims using
+// policy instead of plain LRU. It panics if capacity is less than 1.
+func NewLRUCacheWithPolicy[K comparable, V any](capacity int, policy EvictionPolicy[K, V]) *LRUCache[K, V] {
+	checkPositive("capacity", capacity)
+	return newCache(capacity, policy)
+}
+
+func checkPositive(name string, n int) {
+	if n < 1 {
+		panic(fmt.Sprintf("lru: %s must be >= 1, got %d", name, n))
+	}
+}
+
+// newCache creates a cache without validating capacity, so that the
+// cost-bounded constructors can pass 0 for no entry limit.
+func newCache[K comparable, V any](capacity int, policy EvictionPolicy[K, V]) *LRUCache[K, V] {
+	if p, ok := policy.(capacityAware); ok {
+		p.SetCapacity(capacity)
+	}
+	return &LRUCache[K, V]{
+		capacity: capacity,
+		writer:   make(chan struct{}, 1),
+		cache:    make(map[K]*Node[K, V]),
+		policy:   policy,
+		pinned:   newNodeList[K, V](),
+		lowTier:  newNodeList[K, V](),
+		highTier: newNodeList[K, V](),
+		clock:    realClock{},
+		scanSeed: maphash.MakeSeed(),
+		lockSeed: maphash.MakeSeed(),
+		rng:      rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		nodeSize: nodeOverhead[K, V](),
+	}
+}
+
+// NewLRUCacheBytes creates a cache bounded by total size rather than entry
+// count: each entry costs len(key)+len(value) bytes, and least recently used
+// entries are evicted until the total fits within maxBytes. It panics if
+// maxBytes is less than 1.
+func NewLRUCacheBytes(maxBytes int) *LRUCache[string, string] {
+	return newByteBoundedCache(maxBytes, newLRUPolicy[string, string](), func(v string) int { return len(v) })
+}
+
+// newByteBoundedCache is NewLRUCacheBytes for any value type, with size
+// giving the length of a value.
+func newByteBoundedCache[V any](maxBytes int, policy EvictionPolicy[string, V], size func(V) int) *LRUCache[string, V] {
+	checkPositive("byte budget", maxBytes)
+	c := newCache(0, policy)
+	c.maxCost = maxBytes
+	c.sizer = func(key string, value V) int { return len(key) + size(value) }
+	return c
+}
+
+// NewLRUCacheWeighted creates a cache bounded by the total cost of its
+// entries. Costs are supplied per entry with PutWeighted; plain Put charges
+// a cost of 1. It panics if budget is less than 1.
+func NewLRUCacheWeighted[K comparable, V any](budget int, policy EvictionPolicy[K, V]) *LRUCache[K, V] {
+	checkPositive("weight budget", budget)
+	c := newCache(0, policy)
+	c.maxCost = budget
+	return c
+}
+
+func (c *LRUCache[K, V]) Get(key K) (V, bool) {
+	value, err := c.Fetch(key)
+	return value, err == nil
+}
+
+// Fetch is Get reporting a miss as an error: ErrNotFound if the cache does
+// not hold key and ErrExpired if its entry has expired.
+func (c *LRUCache[K, V]) Fetch(key K) (V, error) {
+	key = c.normalize(key)
+	if l := c.latency.Load(); l != nil && l.get.sample(l.every) {
+		defer l.get.since(time.Now())
+	}
+	if value, ok, done := c.getShared(key); done {
+		if !ok {
+			return value, ErrNotFound
+		}
+		return value, nil
+	}
+	c.lock()
+	defer c.unlock()
+	return c.fetch(key)
+}
+
+// GetDefault is Get returning def on a miss, and reports whether the value
+// came from the cache. The miss is counted as one, and def is not stored.
+func (c *LRUCache[K, V]) GetDefault(key K, def V) (V, bool) {
+	if value, ok := c.Get(key); ok {
+		return value, true
+	}
+	return def, false
+}
+
+// Result is the outcome of one lookup in a batch.
+type Result[V any] struct {
+	Value V
+	Found bool
+}
+
+// GetMulti looks up each key in order under a single lock, promoting hits
+// exactly as Get would. The results line up with keys one to one; repeated
+// keys are looked up again rather than deduplicated.
+func (c *LRUCache[K, V]) GetMulti(keys []K) []Result[V] {
+	c.lock()
+	defer c.unlock()
+
+	results := make([]Result[V], len(keys))
+	for i, key := range keys {
+		results[i].Value, results[i].Found = c.get(c.normalize(key))
+	}
+	return results
+}
+
+func (c *LRUCache[K, V]) get(key K) (V, bool) {
+	value, err := c.fetch(key)
+	return value, err == nil
+}
+
+func (c *LRUCache[K, V]) fetch(key K) (V, error) {
+	if c.readOnly {
+		return c.peekCounted(key)
+	}
+	c.recordAccess(key)
+	node, err := c.find(key)
+	if p := c.profile.Load(); p != nil {
+		p.get(key, err == nil)
+	}
+	c.accessed(key, err == nil)
+	if err != nil {
+		c.miss()
+		var zero V
+		return zero, err
+	}
+	c.hit()
+	node.hits++
+	c.used(node, c.now())
+	c.access(node)
+	c.scheduleRefresh(node)
+	return node.value, nil
+}
+
+// Peek returns the value for key without promoting it to most recently used.
+func (c *LRUCache[K, V]) Peek(key K) (V, bool) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	if _, ok := c.cache[key]; !ok && c.l2 != nil {
+		return c.l2.Peek(key)
+	}
+	node, ok := c.lookup(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return node.value, true
+}
+
+// Contains reports whether key is cached without promoting it or touching
+// the hit counters. An expired entry counts as absent and is reaped.
+func (c *LRUCache[K, V]) Contains(key K) bool {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	if _, ok := c.cache[key]; !ok && c.l2 != nil {
+		return c.l2.Contains(key)
+	}
+	_, ok := c.lookup(key)
+	return ok
+}
+
+func (c *LRUCache[K, V]) Put(key K, value V) error {
+	return c.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL stores value under key, expiring it after ttl. A ttl of zero or
+// less stores the entry without expiry. Updating an existing key replaces any
+// previous TTL. In byte-bounded mode it returns ErrTooLarge, leaving the
+// cache untouched, if the entry alone exceeds the budget.
+func (c *LRUCache[K, V]) PutWithTTL(key K, value V, ttl time.Duration) error {
+	key = c.normalize(key)
+	if l := c.latency.Load(); l != nil && l.put.sample(l.every) {
+		defer l.put.since(time.Now())
+	}
+	c.lock()
+	err := c.put(key, value, ttl, c.defaultCost(key, value))
+	c.unlock()
+	return err
+}
+
+// GetSet stores value under key as most recently used and returns the value
+// it replaced. An expired entry is treated as absent. Evictions caused by the
+// write happen exactly as they would for Put; if the write fails the cache is
+// left untouched and the error is returned.
+func (c *LRUCache[K, V]) GetSet(key K, value V) (old V, existed bool, err error) {
+	key = c.normalize(key)
+	c.lock()
+	cost := c.defaultCost(key, value)
+	if err := c.checkWrite(key, value, cost); err != nil {
+		c.unlock()
+		return old, false, err
+	}
+	old, existed = c.get(key)
+	err = c.put(key, value, 0, cost)
+	c.unlock()
+	return old, existed, err
+}
+
+// KV is a key/value pair for batch writes.
+type KV[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// PutMulti stores pairs in order under a single lock, as if Put had been
+// called for each one. When the batch is larger than the cache the later
+// pairs win and earlier ones may already have been evicted by the time it
+// returns; a key repeated in the batch keeps its last value and ends up most
+// recently used. If any pair is refused, for exceeding the cost budget or
+// the length limits, nothing is stored. Removals are reported once per key
+// for the whole batch, as a batch write reports them.
+func (c *LRUCache[K, V]) PutMulti(pairs []KV[K, V]) error {
+	if c.normalizer.Load() != nil {
+		pairs = slices.Clone(pairs)
+		for i := range pairs {
+			pairs[i].Key = c.normalize(pairs[i].Key)
+		}
+	}
+	if c.compress != nil {
+		pairs = slices.Clone(pairs)
+		for i := range pairs {
+			pairs[i].Value = c.compress(pairs[i].Value)
+		}
+	}
+	c.lock()
+	costs := make([]int, len(pairs))
+	// With eviction off the batch as a whole has to fit.
+	added, addedCost := 0, 0
+	seen := make(map[K]int, len(pairs))
+	for i, p := range pairs {
+		costs[i] = c.defaultCost(p.Key, p.Value)
+		err := c.checkWrite(p.Key, p.Value, costs[i])
+		if err == nil {
+			err = c.checkRoom(c.cache[p.Key], costs[i])
+		}
+		if err != nil {
+			c.unlock()
+			return err
+		}
+		switch j, ok := seen[p.Key]; {
+		case ok:
+			addedCost += costs[i] - costs[j]
+		case c.cache[p.Key] != nil:
+			addedCost += costs[i] - c.cache[p.Key].cost
+		default:
+			added++
+			addedCost += costs[i]
+		}
+		seen[p.Key] = i
+	}
+	if err := c.checkFull(added, addedCost); err != nil {
+		c.unlock()
+		return err
+	}
+	c.beginBatch()
+	for i, p := range pairs {
+		c.put(p.Key, p.Value, 0, costs[i])
+		c.batch.written[p.Key] = true
+	}
+	c.endBatch()
+	c.unlock()
+	return nil
+}
+
+// PutWeighted stores value under key with the given cost, evicting least
+// recently used entries until the total cost fits the weight budget.
+// Updating an existing key replaces its cost.
+func (c *LRUCache[K, V]) PutWeighted(key K, value V, cost int) error {
+	key = c.normalize(key)
+	if cost < 1 {
+		return ErrInvalidCost
+	}
+	c.lock()
+	if c.maxCost == 0 || c.sizer != nil {
+		c.unlock()
+		return ErrNotWeighted
+	}
+	err := c.put(key, value, 0, cost)
+	c.unlock()
+	return err
+}
+
+func (c *LRUCache[K, V]) defaultCost(key K, value V) int {
+	if c.sizer != nil {
+		return c.sizer(key, value)
+	}
+	return 1
+}
+
+// SetLimits bounds the length in bytes of string keys and values accepted
+// by writes; 0 removes a limit. Entries already cached are not affected.
+func (c *LRUCache[K, V]) SetLimits(maxKeyLen, maxValueLen int) {
+	c.lock()
+	defer c.unlock()
+	c.maxKeyLen, c.maxValueLen = maxKeyLen, maxValueLen
+}
+
+// Limits returns the limits set with SetLimits.
+func (c *LRUCache[K, V]) Limits() (maxKeyLen, maxValueLen int) {
+	c.rlock()
+	defer c.mu.RUnlock()
+	return c.maxKeyLen, c.maxValueLen
+}
+
+// CheckLimits reports whether a string key and a value of valueLen bytes
+// would pass the limits set with SetLimits, counting a refusal as a
+// rejected write. It lets callers refuse a value before reading it.
+func (c *LRUCache[K, V]) CheckLimits(key string, valueLen int) error {
+	c.lock()
+	defer c.unlock()
+
+	var err error
+	switch {
+	case c.maxKeyLen > 0 && len(key) > c.maxKeyLen:
+		err = ErrKeyTooLong
+	case c.maxValueLen > 0 && valueLen > c.maxValueLen:
+		err = ErrValueTooLong
+	}
+	if err != nil {
+		c.counters.rejected.Add(1)
+	}
+	return err
+}
+
+// checkWrite reports whether an entry of the given cost may be stored,
+// counting refusals.
+func (c *LRUCache[K, V]) checkWrite(key K, value V, cost int) error {
+	err := c.writeError(key, value, cost)
+	if err != nil {
+		c.counters.rejected.Add(1)
+	}
+	return err
+}
+
+// writeError is checkWrite without the counting.
+func (c *LRUCache[K, V]) writeError(key K, value V, cost int) error {
+	switch {
+	case c.maxKeyLen > 0 && byteLen(key) > c.maxKeyLen:
+		return ErrKeyTooLong
+	case c.maxValueLen > 0 && byteLen(value) > c.maxValueLen:
+		return ErrValueTooLong
+	case c.maxCost > 0 && cost > c.maxCost:
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// byteLen returns the length of string and byte slice values and of values
+// with a Len method, such as Value, and 0 for anything else, which length
+// limits therefore never reject.
+func byteLen(v any) int {
+	switch v := v.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case interface{ Len() int }:
+		return v.Len()
+	}
+	return 0
+}
+
+func (c *LRUCache[K, V]) put(key K, value V, ttl time.Duration, cost int) error {
+	return c.write(key, value, ttl, cost, true)
+}
+
+// write is put, writing the value through to the backing store only if
+// through is set.
+func (c *LRUCache[K, V]) write(key K, value V, ttl time.Duration, cost int, through bool) error {
+	if c.compress != nil {
+		value = c.compress(value)
+		if c.sizer != nil {
+			cost = c.sizer(key, value)
+		}
+	}
+	if err := c.checkWrite(key, value, cost); err != nil {
+		return err
+	}
+
+	now := c.now()
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = now.Add(c.jitter(ttl))
+	} else {
+		ttl = 0
+	}
+
+	// Updating a live key replaces its value in place and promotes it; the
+	// entry count is unchanged, so in count-bounded mode an update never
+	// evicts anything. An expired entry is reaped first so the write is
+	// treated as a fresh insert rather than inheriting its history.
+	c.recordAccess(key)
+	c.dropLower(key)
+	node, ok := c.lookup(key)
+	if !ok {
+		c.reap(key)
+	}
+	if err := c.checkRoom(node, cost); err != nil {
+		return err
+	}
+	if through {
+		if err := c.writeThrough(key, value); err != nil {
+			return err
+		}
+	}
+	c.forget(key)
+	if p := c.profile.Load(); p != nil {
+		p.put(key, cost)
+	}
+	if ok {
+		c.record(key, node.value, RemovalReplaced)
+		node.value = value
+		node.version++
+		node.seq = c.nextSeq()
+		node.writtenAt = now
+		node.expireAt = expireAt
+		node.ttl = ttl
+		node.grace, node.revalidating = 0, false
+		c.used(node, now)
+		c.access(node)
+	} else {
+		if !c.admit(key) {
+			return nil
+		}
+		node = c.newNode()
+		node.key, node.value, node.priority, node.tenant = key, value, PriorityNormal, c.caller
+		node.version, node.seq = 1, c.nextSeq()
+		node.expireAt, node.ttl = expireAt, ttl
+		node.createdAt, node.writtenAt = now, now
+		c.used(node, now)
+		c.cache[key] = node
+		c.addResident(node)
+		c.place(node)
+	}
+	c.store(node)
+	c.tag(node)
+	c.chargeSaved(node)
+	c.setCost(node, cost)
+	c.share(node)
+	c.wrote(key, node.seq, false)
+
+	c.evictOverflow(node)
+	return nil
+}
+
+// Resize changes the capacity, evicting least recently used entries until
+// the cache fits, and returns the number of entries evicted. In byte-bounded
+// and weighted modes newCapacity is the new budget. Capacities smaller than 1 are
+// ignored.
+func (c *LRUCache[K, V]) Resize(newCapacity int) int {
+	if newCapacity < 1 {
+		return 0
+	}
+	c.lock()
+	defer c.unlock()
+	return c.resize(newCapacity)
+}
+
+// resize is Resize with the write lock held.
+func (c *LRUCache[K, V]) resize(newCapacity int) int {
+	if c.maxCost > 0 {
+		c.maxCost = newCapacity
+	} else {
+		c.capacity = newCapacity
+		if p, ok := c.policy.(capacityAware); ok {
+			p.SetCapacity(newCapacity)
+		}
+		// The sketch is sized for the capacity, so it starts over.
+		if c.sketch != nil {
+			c.sketch = newFrequencySketch[K](newCapacity)
+		}
+	}
+	if p := c.profile.Load(); p != nil {
+		p.resize(c.budget())
+	}
+	return c.evictOverflow(nil)
+}
+
+// SetLowWatermark turns on watermark mode: the cache fills up to its
+// capacity as usual, but the write that would take it over capacity evicts
+// a whole batch, in the policy's usual victim order, until only low entries
+// remain. Eviction work then happens once every capacity-low inserts
+// instead of on each one. 0 turns watermark mode off.
+func (c *LRUCache[K, V]) SetLowWatermark(low int) error {
+	c.lock()
+	defer c.unlock()
+
+	if low < 0 || (low > 0 && (c.capacity == 0 || low >= c.capacity)) {
+		return ErrInvalidWatermark
+	}
+	c.lowWater = low
+	return nil
+}
+
+// Expire sets key to expire after ttl, replacing any previous TTL. It
+// reports false if key is absent or ttl is not positive.
+func (c *LRUCache[K, V]) Expire(key K, ttl time.Duration) bool {
+	key = c.normalize(key)
+	if ttl <= 0 {
+		return false
+	}
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	node.expireAt = c.now().Add(c.jitter(ttl))
+	node.ttl = ttl
+	c.share(node)
+	return true
+}
+
+// ExpireAt sets key to expire at t, replacing any previous TTL, and
+// expires it at once if t has passed. It reports false if key is absent.
+// The deadline is kept as a TTL's is but has no duration, so Touch and
+// refresh-ahead leave it where it is.
+func (c *LRUCache[K, V]) ExpireAt(key K, t time.Time) bool {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	if !c.now().Before(t) {
+		c.remove(node, RemovalExpired)
+		return true
+	}
+	node.expireAt = t
+	node.ttl = 0
+	c.share(node)
+	return true
+}
+
+// Touch promotes key as a read would and restarts its TTL, if it has one,
+// from its original duration, without returning the value. It reports false
+// if key is absent or has expired.
+func (c *LRUCache[K, V]) Touch(key K) bool {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	now := c.now()
+	if node.ttl > 0 {
+		node.expireAt = now.Add(c.jitter(node.ttl))
+		c.share(node)
+	}
+	c.used(node, now)
+	c.access(node)
+	return true
+}
+
+// TTL returns the time left before key expires, or zero if it has no TTL.
+// It reports false if key is absent.
+func (c *LRUCache[K, V]) TTL(key K) (time.Duration, bool) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		return 0, false
+	}
+	if node.expireAt.IsZero() {
+		return 0, true
+	}
+	return node.expireAt.Sub(c.now()), true
+}
+
+// Persist removes any TTL from key so it never expires.
+func (c *LRUCache[K, V]) Persist(key K) bool {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	node.expireAt = time.Time{}
+	node.ttl = 0
+	c.share(node)
+	return true
+}
+
+func (c *LRUCache[K, V]) Remove(key K) bool {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.cache[key]
+	if !ok {
+		return c.l2 != nil && c.l2.Remove(key)
+	}
+	c.remove(node, RemovalDeleted)
+	return true
+}
+
+// GetAndDelete removes key and returns the value it held as one atomic
+// step, so of several callers racing for the same key only one receives
+// the value. It counts as a hit or a miss like Get, and the removal is
+// reported as RemovalDeleted.
+func (c *LRUCache[K, V]) GetAndDelete(key K) (V, bool) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		c.miss()
+		var zero V
+		return zero, false
+	}
+	c.hit()
+	value := node.value
+	c.remove(node, RemovalDeleted)
+	return value, true
+}
+
+// Rename moves the entry under oldKey to newKey, replacing any entry
+// already there, whose value is reported as RemovalReplaced. The entry
+// keeps its value, TTL, diagnostics and position in the eviction order;
+// only its cost is recomputed in byte-bounded mode, where the key length
+// counts. In a tiered cache an entry in the second level is promoted
+// first, and one at newKey is replaced in whichever level holds it.
+func (c *LRUCache[K, V]) Rename(oldKey, newKey K) error {
+	oldKey, newKey = c.normalize(oldKey), c.normalize(newKey)
+	c.lock()
+	node, ok := c.lookup(oldKey)
+	if !ok {
+		c.unlock()
+		return ErrNotFound
+	}
+	if oldKey == newKey {
+		c.unlock()
+		return nil
+	}
+	cost := node.cost
+	if c.sizer != nil {
+		cost = c.sizer(newKey, node.value)
+	}
+	if err := c.checkWrite(newKey, node.value, cost); err != nil {
+		c.unlock()
+		return err
+	}
+	if err := c.checkRoom(node, cost); err != nil {
+		c.unlock()
+		return err
+	}
+	// The entry newKey had is replaced wherever it is. Looking it up
+	// would promote it from the second level and could demote node.
+	c.reap(newKey)
+	if dst, ok := c.cache[newKey]; ok {
+		c.remove(dst, RemovalReplaced)
+	} else {
+		c.dropLower(newKey)
+	}
+	delete(c.cache, oldKey)
+	c.unshare(oldKey)
+	c.unindexKey(oldKey)
+	node.key = newKey
+	c.cache[newKey] = node
+	c.indexKey(newKey)
+	c.setCost(node, cost)
+	c.share(node)
+	c.evictOverflow(node)
+	c.unlock()
+	return nil
+}
+
+// Copy stores the value of src under dst as a new, most recently used
+// entry with the same expiry, replacing any entry already at dst. The copy
+// is a separate entry: later writes to either key leave the other alone.
+// Like any insert it may evict others.
+func (c *LRUCache[K, V]) Copy(src, dst K) error {
+	src, dst = c.normalize(src), c.normalize(dst)
+	c.lock()
+	node, ok := c.lookup(src)
+	if !ok {
+		c.unlock()
+		return ErrNotFound
+	}
+	if src == dst {
+		c.unlock()
+		return nil
+	}
+	cost := node.cost
+	if c.sizer != nil {
+		cost = c.sizer(dst, node.value)
+	}
+	// The put may evict or demote src, releasing node, so what is copied
+	// from it is read first.
+	expireAt, ttl, grace := node.expireAt, node.ttl, node.grace
+	err := c.put(dst, node.value, 0, cost)
+	if copied, ok := c.cache[dst]; ok && err == nil {
+		copied.expireAt, copied.ttl, copied.grace = expireAt, ttl, grace
+		c.share(copied)
+	}
+	c.unlock()
+	return err
+}
+
+// Delete is an alias for Remove.
+func (c *LRUCache[K, V]) Delete(key K) bool {
+	return c.Remove(key)
+}
+
+func (c *LRUCache[K, V]) Clear() {
+	c.clearFor(RemovalDeleted)
+}
+
+// clearFor is Clear reporting each entry as removed for reason.
+func (c *LRUCache[K, V]) clearFor(reason RemovalReason) {
+	c.lock()
+	defer c.unlock()
+	c.clear(reason)
+}
+
+// clear empties the cache and the second level of a tiered one, recording
+// each entry as removed for reason. The caller must hold the write lock.
+func (c *LRUCache[K, V]) clear(reason RemovalReason) {
+	// The resident slice, unlike the map, is in the same order every run.
+	// The removals are recorded once the cache is empty, so that deleted
+	// keys' tombstones have room.
+	cleared := slices.Clone(c.resident)
+	c.cache = make(map[K]*Node[K, V])
+	c.unshareAll()
+	c.resetResident()
+	c.policy.Reset()
+	c.pinned.init()
+	c.lowTier.init()
+	c.highTier.init()
+	c.resetTenants()
+	c.clearNegative()
+	c.usedCost, c.pinnedCost, c.savedBytes = 0, 0, 0
+	c.resetSlabs()
+	for _, node := range cleared {
+		c.record(node.key, node.value, reason)
+	}
+	if c.l2 != nil {
+		c.l2.clearFor(reason)
+	}
+}
+
+// Policy returns the name of the eviction policy in use.
+func (c *LRUCache[K, V]) Policy() string {
+	return c.policy.Name()
+}
+
+// Size returns the number of entries, in both levels of a tiered cache.
+func (c *LRUCache[K, V]) Size() int {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	if c.l2 != nil {
+		return len(c.cache) + c.l2.Size()
+	}
+	return len(c.cache)
+}
+
+// Capacity returns the bound the cache evicts to stay within: the entry
+// count, or the byte or weight budget in those modes. In watermark mode it
+// is the high watermark, the size at which eviction starts.
+func (c *LRUCache[K, V]) Capacity() int {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	if c.maxCost > 0 {
+		return c.maxCost
+	}
+	return c.capacity
+}
+
+// Headroom returns how much more the cache can take before it has to
+// evict: Capacity minus the entry count, bytes or weight in use. It is
+// never negative.
+func (c *LRUCache[K, V]) Headroom() int {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	if c.maxCost > 0 {
+		return max(c.maxCost-c.usedCost, 0)
+	}
+	return max(c.capacity-len(c.cache), 0)
+}
+
+// Len is an alias for Size.
+func (c *LRUCache[K, V]) Len() int {
+	return c.Size()
+}
+
+func (c *LRUCache[K, V]) Stats() Stats {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	n, base := c.counters.load(), c.statsBase
+	stats := Stats{
+		Hits:              int(n.Hits - base.Hits),
+		Misses:            int(n.Misses - base.Misses),
+		Evictions:         int(n.Evictions - base.Evictions),
+		Expirations:       int(n.Expirations - base.Expirations),
+		Deleted:           int(n.Deleted - base.Deleted),
+		Replaced:          int(n.Replaced - base.Replaced),
+		Idle:              int(n.Idle - base.Idle),
+		MemoryShed:        int(n.MemoryShed - base.MemoryShed),
+		ScheduledReset:    int(n.ScheduledReset - base.ScheduledReset),
+		ResetAt:           c.scheduledReset(),
+		Rejected:          int(n.Rejected - base.Rejected),
+		AdmissionRejected: int(n.AdmissionRejected - base.AdmissionRejected),
+		Refreshes:         int(n.Refreshes - base.Refreshes),
+		RefreshFailures:   int(n.RefreshFailures - base.RefreshFailures),
+		FailedAuth:        int(n.FailedAuth - base.FailedAuth),
+		BackingHits:       int(n.BackingHits - base.BackingHits),
+		NegativeHits:      int(n.NegativeHits - base.NegativeHits),
+		StaleRejects:      int(n.StaleRejects - base.StaleRejects),
+		Autotunes:         int(n.Autotunes - base.Autotunes),
+		Demotions:         int(n.Demotions - base.Demotions),
+		Promotions:        int(n.Promotions - base.Promotions),
+		Restarts:          int(n.Restarts - base.Restarts),
+		Tenants:           c.tenantStats(),
+		Window:            c.windowStats(),
+		EvictionBatches:   int(n.EvictionBatches - base.EvictionBatches),
+		LowWatermark:      c.lowWater,
+		Size:              len(c.cache),
+		Capacity:          c.capacity,
+		SavedBytes:        c.savedBytes,
+	}
+	if c.l2 != nil {
+		stats.L2Size, stats.L2Capacity = c.l2.Size(), c.l2.Capacity()
+	}
+	switch {
+	case c.sizer != nil:
+		stats.UsedBytes, stats.MaxBytes = c.usedCost, c.maxCost
+	case c.maxCost > 0:
+		stats.UsedWeight, stats.MaxWeight = c.usedCost, c.maxCost
+	}
+	if d, ok := c.policy.(describer); ok {
+		stats.PolicyInfo = d.Describe()
+	}
+	return stats
+}
+
+// ResetStats zeroes the hit, miss and removal counters that Stats reports.
+// Counters is unaffected.
+func (c *LRUCache[K, V]) ResetStats() {
+	c.lock()
+	defer c.unlock()
+
+	c.statsBase = c.counters.load()
+	if w := c.window.Load(); w != nil {
+		w.reset()
+	}
+	for _, p := range c.tenants {
+		p.hits.Store(0)
+		p.misses.Store(0)
+	}
+}
+
+// Keys returns the cached keys in the policy's retention order, ending with
+// the next eviction victim. Under LRU that is most to least recently used.
+func (c *LRUCache[K, V]) Keys() []K {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	keys := make([]K, 0, len(c.cache))
+	c.each(func(node *Node[K, V]) bool {
+		keys = append(keys, node.key)
+		return true
+	})
+	return keys
+}
+
+// Each calls fn for each live entry in retention order, as Keys lists them,
+// until fn returns false. It promotes nothing and counts no hits.
+//
+// fn is called without the lock held, so it may call any method of the
+// cache. Each walks the keys present when it was called: a key removed
+// before Each reaches it is skipped, and one whose value changed is
+// visited with its new value, so fn may remove the entry it is given or
+// any other. Keys added while it runs are not visited, nor is an order
+// changed by fn's own calls followed; a key removed and added again before
+// Each reaches it is visited.
+func (c *LRUCache[K, V]) Each(fn func(key K, value V) bool) {
+	c.walk(c.Keys(), fn)
+}
+
+// EachReverse is Each in the opposite order, starting with the next
+// eviction victim.
+func (c *LRUCache[K, V]) EachReverse(fn func(key K, value V) bool) {
+	keys := c.Keys()
+	slices.Reverse(keys)
+	c.walk(keys, fn)
+}
+
+func (c *LRUCache[K, V]) walk(keys []K, fn func(key K, value V) bool) {
+	for _, key := range keys {
+		c.rlock()
+		node, ok := c.cache[key]
+		ok = ok && !node.expired(c.now())
+		var value V
+		if ok {
+			value = node.value
+		}
+		c.mu.RUnlock()
+		if ok && !fn(key, value) {
+			return
+		}
+	}
+}
+
+// RemoveOldest removes and returns the entry that would be evicted next,
+// without counting it as an eviction or notifying the eviction handler. It
+// reports false if the cache is empty.
+func (c *LRUCache[K, V]) RemoveOldest() (key K, value V, ok bool) {
+	c.lock()
+	defer c.unlock()
+
+	now := c.now()
+	for {
+		node := c.evictNext(nil)
+		if node == nil {
+			return key, value, false
+		}
+		delete(c.cache, node.key)
+		c.unshare(node.key)
+		c.dropResident(node)
+		c.usedCost -= node.cost
+		c.savedBytes -= node.saved
+		key, value = node.key, node.value
+		expired, reason := node.expired(now), node.expiry(now)
+		c.release(node)
+		if expired {
+			c.record(key, value, reason)
+			continue
+		}
+		c.record(key, value, RemovalDeleted)
+		return key, value, true
+	}
+}
+
+// Oldest returns the live entry the policy would evict next without
+// removing or promoting it. It walks the whole retention order, so it is
+// meant for inspection rather than hot paths.
+func (c *LRUCache[K, V]) Oldest() (key K, value V, ok bool) {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	c.each(func(node *Node[K, V]) bool {
+		if !node.expired(now) {
+			key, value, ok = node.key, node.value, true
+		}
+		return true
+	})
+	return key, value, ok
+}
+
+// Newest returns the live entry the policy would keep longest without
+// promoting it.
+func (c *LRUCache[K, V]) Newest() (key K, value V, ok bool) {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	c.each(func(node *Node[K, V]) bool {
+		if node.expired(now) {
+			return true
+		}
+		key, value, ok = node.key, node.value, true
+		return false
+	})
+	return key, value, ok
+}
+
+// Info is the bookkeeping kept for one entry, as reported by EntryInfo.
+type Info[K comparable] struct {
+	Key      K
+	Hits     int           // reads that found the entry
+	Age      time.Duration // since the entry was inserted
+	Idle     time.Duration // since the entry was last read or written
+	TTL      time.Duration // time left before expiry; 0 if it never expires
+	Position int           // place in retention order; 0 is kept longest
+}
+
+// EntryInfo returns the bookkeeping for key without promoting it. Finding
+// the position walks the retention order, so it costs O(n).
+func (c *LRUCache[K, V]) EntryInfo(key K) (Info[K], bool) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		return Info[K]{}, false
+	}
+	now := c.now()
+	info := Info[K]{
+		Key:  key,
+		Hits: node.hits,
+		Age:  now.Sub(node.createdAt),
+		Idle: now.Sub(node.accessedAt),
+	}
+	if !node.expireAt.IsZero() {
+		info.TTL = node.expireAt.Sub(now)
+	}
+	c.each(func(n *Node[K, V]) bool {
+		if n == node {
+			return false
+		}
+		info.Position++
+		return true
+	})
+	return info, true
+}
+
+// KeysWithPrefix returns the keys starting with prefix in the same order as
+// Keys, or with the key index in lexicographic order. Only string keys can
+// match.
+func (c *LRUCache[K, V]) KeysWithPrefix(prefix string) []K {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	if c.keys != nil {
+		return c.indexedKeys(prefix, func(key string) bool { return !strings.HasPrefix(key, prefix) })
+	}
+	var keys []K
+	c.each(func(node *Node[K, V]) bool {
+		if hasPrefix(node.key, prefix) {
+			keys = append(keys, node.key)
+		}
+		return true
+	})
+	return keys
+}
+
+// DeletePrefix removes every entry whose key starts with prefix and returns
+// how many were removed. Only string keys can match.
+func (c *LRUCache[K, V]) DeletePrefix(prefix string) int {
+	c.lock()
+	defer c.unlock()
+
+	// Collect first: unlinking while the policy or the key index is
+	// iterating would break the walk.
+	var doomed []*Node[K, V]
+	if c.keys != nil {
+		for _, key := range c.indexedKeys(prefix, func(key string) bool { return !strings.HasPrefix(key, prefix) }) {
+			doomed = append(doomed, c.cache[key])
+		}
+	} else {
+		c.each(func(node *Node[K, V]) bool {
+			if hasPrefix(node.key, prefix) {
+				doomed = append(doomed, node)
+			}
+			return true
+		})
+	}
+	for _, node := range doomed {
+		c.remove(node, RemovalDeleted)
+	}
+	return len(doomed)
+}
+
+func hasPrefix[K comparable](key K, prefix string) bool {
+	s, ok := any(key).(string)
+	return ok && strings.HasPrefix(s, prefix)
+}
+
+// lookup returns the live node for key, lazily removing it if it has
+// expired, unless the cache is read-only. An entry within its grace period
+// is reported missing but kept for GetStale.
+func (c *LRUCache[K, V]) lookup(key K) (*Node[K, V], bool) {
+	node, err := c.find(key)
+	return node, err == nil
+}
+
+// find is lookup reporting a miss as ErrNotFound, or as ErrExpired if key
+// has an entry that has expired. In a tiered cache a key the first level
+// lacks is promoted from the second, unless the cache is read-only.
+func (c *LRUCache[K, V]) find(key K) (*Node[K, V], error) {
+	node, ok := c.cache[key]
+	if !ok && c.l2 != nil && !c.readOnly {
+		node = c.promote(key)
+		ok = node != nil
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if now := c.now(); node.expired(now) {
+		if node.gone(now) && !c.readOnly {
+			c.remove(node, node.expiry(now))
+		}
+		return nil, ErrExpired
+	}
+	return node, nil
+}
+
+// unlink removes node from the policy and the map and releases its cost
+// without recording a removal; see remove.
+func (c *LRUCache[K, V]) unlink(node *Node[K, V]) {
+	c.displace(node)
+	if node.pinned {
+		c.pinnedCost -= node.cost
+	}
+	delete(c.cache, node.key)
+	c.unshare(node.key)
+	c.dropResident(node)
+	c.usedCost -= node.cost
+	c.savedBytes -= node.saved
+}
+
+// setCost charges node's new cost to the budget and to the shares of the
+// pinned entries and its tenant.
+func (c *LRUCache[K, V]) setCost(node *Node[K, V], cost int) {
+	c.usedCost += cost - node.cost
+	if node.pinned {
+		c.pinnedCost += cost - node.cost
+	}
+	c.chargeTenant(node, 0, cost-node.cost)
+	node.cost = cost
+}
+
+// chargeSaved brings savedBytes up to date with node's value.
+func (c *LRUCache[K, V]) chargeSaved(node *Node[K, V]) {
+	if c.savings == nil {
+		return
+	}
+	saved := c.savings(node.value)
+	c.savedBytes += saved - node.saved
+	node.saved = saved
+}
+
+func (c *LRUCache[K, V]) overBudget() bool {
+	return (c.capacity > 0 && len(c.cache)+len(c.negative) > c.capacity) ||
+		(c.maxCost > 0 && c.usedCost+c.negativeCost > c.maxCost)
+}
+
+// evictOverflow evicts the policy's victims while the cache is over its
+// capacity or cost budget, never evicting keep, and returns how many it
+// evicted. Tombstones are dropped before any entry is evicted.
+func (c *LRUCache[K, V]) evictOverflow(keep *Node[K, V]) int {
+	evicted := c.evictTenants(keep)
+	// In watermark mode an entry count over capacity is brought down to
+	// the low watermark rather than back to capacity.
+	batch := c.lowWater > 0 && c.capacity > 0 && len(c.cache) > c.capacity
+	for c.overBudget() || (batch && len(c.cache) > c.lowWater) {
+		if c.evictTombstone() {
+			continue
+		}
+		if !c.evictVictim(keep) {
+			break
+		}
+		evicted++
+	}
+	if evicted > 0 {
+		c.counters.evictionBatches.Add(1)
+	}
+	return evicted
+}
+
+// evictVictim evicts the next victim other than keep and reports whether
+// there was one.
+func (c *LRUCache[K, V]) evictVictim(keep *Node[K, V]) bool {
+	victim := c.evictNext(keep)
+	if victim == nil {
+		return false
+	}
+	c.evict(victim)
+	return true
+}
+
+// evict removes victim, already taken out of the policy and the lists, as
+// an eviction.
+func (c *LRUCache[K, V]) evict(victim *Node[K, V]) {
+	c.evictFor(victim, RemovalEvicted)
+}
+
+// evictFor is evict recording reason as the cause.
+func (c *LRUCache[K, V]) evictFor(victim *Node[K, V], reason RemovalReason) {
+	delete(c.cache, victim.key)
+	c.unshare(victim.key)
+	c.dropResident(victim)
+	c.usedCost -= victim.cost
+	c.savedBytes -= victim.saved
+	if reason != RemovalEvicted || c.l2 == nil || !c.demote(victim) {
+		c.record(victim.key, victim.value, reason)
+	}
+	c.release(victim)
+}
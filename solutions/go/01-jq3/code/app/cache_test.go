@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	for _, tc := range []struct {
+		ops  string // p<key> puts, g<key> gets
+		keys []string
+	}{
+		{"pa pb pc pd", []string{"d", "c", "b"}},
+		{"pa pb pc ga pd", []string{"d", "a", "c"}},
+		{"pa pb pc ga gb pd", []string{"d", "b", "a"}},
+		{"pa pb pc gc gb ga pd pe", []string{"e", "d", "a"}},
+		{"pa pb pc pa pd", []string{"d", "a", "c"}},
+		{"pa pb pc gz pd", []string{"d", "c", "b"}},
+		{"pa pb gb gb pc pd", []string{"d", "c", "b"}},
+		{"pa ga pb ga pc ga pd pe", []string{"e", "d", "a"}},
+	} {
+		c := NewLRUCache[string, int](3)
+		for i, op := range strings.Fields(tc.ops) {
+			switch op[0] {
+			case 'p':
+				c.Put(op[1:], i)
+			case 'g':
+				c.Get(op[1:])
+			}
+			if c.Size() > 3 {
+				t.Fatalf("%s: size %d over capacity", tc.ops, c.Size())
+			}
+		}
+		if got := c.Keys(); !slices.Equal(got, tc.keys) {
+			t.Errorf("%s: keys %v, want %v", tc.ops, got, tc.keys)
+		}
+		if err := c.DebugCheck(); err != nil {
+			t.Errorf("%s: %v", tc.ops, err)
+		}
+	}
+}
+
+func TestPutExistingKeyUpdatesAndPromotes(t *testing.T) {
+	c := NewLRUCache[string, string](2)
+	var evicted []string
+	c.SetEvictionHandler(func(key, value string) { evicted = append(evicted, key) })
+	c.Put("a", "1")
+	c.Put("b", "2")
+	c.Put("a", "updated")
+	if c.Size() != 2 || len(evicted) != 0 {
+		t.Fatalf("re-PUT changed the size to %d or evicted %v", c.Size(), evicted)
+	}
+	c.Put("c", "3")
+	if !slices.Equal(evicted, []string{"b"}) {
+		t.Errorf("evicted %v, want [b]", evicted)
+	}
+	if v, _ := c.Get("a"); v != "updated" {
+		t.Errorf("Get(a) = %q, want updated", v)
+	}
+	if got := c.Keys(); !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("keys %v", got)
+	}
+	for range 5 {
+		c.Put("a", "again")
+	}
+	if c.Size() != 2 || len(c.Keys()) != 2 {
+		t.Errorf("size %d, keys %v: a key is counted twice", c.Size(), c.Keys())
+	}
+}
+
+func TestPutExistingKeyCommand(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 2", "OK",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"PUT a 3", "OK",
+		"SIZE", "2",
+		"PUT c 4", "OK",
+		"GET b", "NULL",
+		"GET a", "3",
+		"GET c", "4",
+		"SIZE", "2",
+	)
+}
+
+func TestRandomOpsMatchModel(t *testing.T) {
+	c := NewLRUCache[int, int](8)
+	var model []int // most recent first
+	for i := range 20000 {
+		key := (i * 7919) % 13
+		if i%3 == 0 {
+			c.Get(key)
+			if j := slices.Index(model, key); j >= 0 {
+				model = slices.Insert(slices.Delete(model, j, j+1), 0, key)
+			}
+			continue
+		}
+		c.Put(key, i)
+		if j := slices.Index(model, key); j >= 0 {
+			model = slices.Delete(model, j, j+1)
+		}
+		model = slices.Insert(model, 0, key)
+		if len(model) > 8 {
+			model = model[:8]
+		}
+		if got := c.Keys(); !slices.Equal(got, model) {
+			t.Fatalf("op %d: keys %v, want %v", i, got, model)
+		}
+	}
+}
+
+func TestPeekDoesNotPromote(t *testing.T) {
+	for _, tc := range []struct {
+		read    string
+		evicted string
+	}{
+		{"", "a"},
+		{"PEEK", "a"},
+		{"GET", "b"},
+	} {
+		c := NewLRUCache[string, string](2)
+		c.Put("a", "1")
+		c.Put("b", "2")
+		switch tc.read {
+		case "PEEK":
+			if v, ok := c.Peek("a"); !ok || v != "1" {
+				t.Fatalf("Peek(a) = %q, %v, want 1, true", v, ok)
+			}
+		case "GET":
+			c.Get("a")
+		}
+		c.Put("c", "3")
+		if c.Contains(tc.evicted) {
+			t.Errorf("after %q: %s was kept, want it evicted; keys %v", tc.read, tc.evicted, c.Keys())
+		}
+	}
+}
+
+func TestPeekMiss(t *testing.T) {
+	c := NewLRUCache[string, string](2)
+	if v, ok := c.Peek("a"); ok || v != "" {
+		t.Errorf("Peek(a) on an empty cache = %q, %v", v, ok)
+	}
+	c.Put("a", "1")
+	c.Peek("a")
+	if got := c.Stats(); got.Hits != 0 || got.Misses != 0 {
+		t.Errorf("Peek counted %d hits and %d misses, want none", got.Hits, got.Misses)
+	}
+	if got := c.Keys(); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("keys %v", got)
+	}
+}
+
+func TestPeekCommand(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"PEEK a", "ERROR ERR_NOT_INITIALIZED Cache not initialized",
+		"INIT 2", "OK",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"PEEK a", "1",
+		"PEEK z", "NULL",
+		"PUT c 3", "OK",
+		"PEEK a", "NULL",
+		"GET b", "2",
+		"PUT d 4", "OK",
+		"PEEK b", "2",
+		"PEEK c", "NULL",
+	)
+}
+
+// BenchmarkCacheSize shows Get and Put take the same time whatever the
+// capacity: each Put of a new key into a full cache evicts one.
+func BenchmarkCacheSize(b *testing.B) {
+	for _, size := range []int{1_000, 100_000, 1_000_000} {
+		c := NewLRUCache[string, string](size)
+		keys := make([]string, size)
+		for i := range keys {
+			keys[i] = "k" + strconv.Itoa(i)
+			c.Put(keys[i], "v")
+		}
+		b.Run(fmt.Sprintf("Get/%d", size), func(b *testing.B) {
+			for i := range b.N {
+				c.Get(keys[(i*7919)%size])
+			}
+		})
+		b.Run(fmt.Sprintf("Put/%d", size), func(b *testing.B) {
+			for i := range b.N {
+				c.Put(keys[(i*7919)%size]+"x", "v")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Checkpoint is a copy of a cache's live keys, in retention order, and
+// their values, taken by Checkpoint for Diff to compare a later state
+// with. It shares nothing with the cache, so later writes cannot change it.
+type Checkpoint[K comparable, V any] struct {
+	keys   []K
+	values map[K]V
+}
+
+// CheckpointDiff is how a cache changed since a Checkpoint. Added and
+// Removed are the keys only the later or only the earlier state holds,
+// Changed the keys both hold with different values, and Moved the fewest
+// keys both hold that have to move to turn the earlier retention order of
+// the keys they share into the later one. Each is sorted.
+type CheckpointDiff[K comparable] struct {
+	Added   []K `json:"added"`
+	Removed []K `json:"removed"`
+	Changed []K `json:"changed"`
+	Moved   []K `json:"moved"`
+}
+
+// Checkpoint returns a copy of the live entries as they are now.
+func (c *LRUCache[K, V]) Checkpoint() Checkpoint[K, V] {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	cp := Checkpoint[K, V]{values: make(map[K]V, len(c.cache))}
+	c.each(func(node *Node[K, V]) bool {
+		if !node.expired(now) {
+			cp.keys = append(cp.keys, node.key)
+			cp.values[node.key] = node.value
+		}
+		return true
+	})
+	return cp
+}
+
+// Diff returns how later differs from cp. Values are compared with ==, so V
+// must hold comparable values or Diff panics.
+func (cp Checkpoint[K, V]) Diff(later Checkpoint[K, V]) CheckpointDiff[K] {
+	d := CheckpointDiff[K]{Added: []K{}, Removed: []K{}, Changed: []K{}, Moved: []K{}}
+	// The shared keys in the earlier order, each numbered by its place in
+	// the later one.
+	place := make(map[K]int, len(later.keys))
+	var shared []K
+	for _, key := range later.keys {
+		if _, ok := cp.values[key]; !ok {
+			d.Added = append(d.Added, key)
+			continue
+		}
+		place[key] = len(shared)
+		shared = append(shared, key)
+		if any(cp.values[key]) != any(later.values[key]) {
+			d.Changed = append(d.Changed, key)
+		}
+	}
+	var order []int
+	var orderKeys []K
+	for _, key := range cp.keys {
+		if _, ok := later.values[key]; !ok {
+			d.Removed = append(d.Removed, key)
+			continue
+		}
+		order = append(order, place[key])
+		orderKeys = append(orderKeys, key)
+	}
+	// The keys that keep their place are the longest run of them already
+	// in the later order; every other shared key moved.
+	stays := longestIncreasing(order)
+	for i, key := range orderKeys {
+		if !stays[i] {
+			d.Moved = append(d.Moved, key)
+		}
+	}
+	for _, keys := range [][]K{d.Added, d.Removed, d.Changed, d.Moved} {
+		slices.SortFunc(keys, compareKeys)
+	}
+	return d
+}
+
+// compareKeys orders keys by how they print, which for strings is the
+// usual order.
+func compareKeys[K comparable](a, b K) int {
+	return cmp.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// longestIncreasing marks the elements of a longest strictly increasing
+// subsequence of seq. Of several, it prefers the one starting earliest, so
+// that a key promoted to the front is the one reported moved rather than
+// all those it passed.
+func longestIncreasing(seq []int) []bool {
+	// Working from the end, tails[n] is the index of the largest first
+	// element of an increasing subsequence of length n+1 found so far, and
+	// next links each element to the one after it in the subsequence it
+	// starts.
+	var tails []int
+	next := make([]int, len(seq))
+	for i := len(seq) - 1; i >= 0; i-- {
+		v := seq[i]
+		n := sort.Search(len(tails), func(j int) bool { return seq[tails[j]] <= v })
+		next[i] = -1
+		if n > 0 {
+			next[i] = tails[n-1]
+		}
+		if n == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[n] = i
+		}
+	}
+	in := make([]bool, len(seq))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = next[i] {
+			in[i] = true
+		}
+	}
+	return in
+}
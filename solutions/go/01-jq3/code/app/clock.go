@@ -0,0 +1,29 @@
+package main
+
+import "time"
+
+// Clock is the cache's source of time. Every expiry check and age in
+// EntryInfo reads it, so substituting a fake clock, such as the one in
+// internal/fakeclock, makes TTL behaviour deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// SetClock replaces the cache's clock. Entries keep their expiry times, so
+// switching to a clock reading a different time expires them accordingly.
+func (c *LRUCache[K, V]) SetClock(clock Clock) {
+	c.lock()
+	defer c.unlock()
+	c.clock = clock
+	if c.l2 != nil {
+		c.l2.SetClock(clock)
+	}
+}
+
+func (c *LRUCache[K, V]) now() time.Time {
+	return c.clock.Now()
+}
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// commandSpec describes one line-protocol command. The dispatcher, the
+// argument count check and HELP all work from these, so a command exists
+// exactly when it is registered.
+type commandSpec struct {
+	name    string
+	args    string // argument signature, as HELP shows it
+	summary string // one-line description
+	details string // further lines for HELP <name>, if any
+
+	// minArgs and maxArgs bound the number of arguments after the
+	// command word; maxArgs < 0 means no limit. arity says what the
+	// command requires, completing "<name> requires ..." when there are
+	// too few.
+	minArgs, maxArgs int
+	arity            string
+
+	needsCache bool // fail with "Cache not initialized" before INIT
+	noServer   bool // fail in server mode, where the session is shared
+	mutates    bool // fail with ERR_READONLY in read-only mode; see READONLY
+	// raw commands take the text after the command word exactly as
+	// written as their single argument instead of its tokens.
+	raw bool
+	// retired is the protocol version that dropped the command, which
+	// fails with ERR_UNSUPPORTED from then on; 0 if none has.
+	retired int
+	// audit is the class of command for --audit: writes are recorded,
+	// and reads too with --audit-reads.
+	audit auditClass
+
+	run func(s *session, in *bufio.Reader, out *reply, line string, parts []string)
+}
+
+// usage returns the command with its argument signature.
+func (c *commandSpec) usage() string {
+	if c.args == "" {
+		return c.name
+	}
+	return c.name + " " + c.args
+}
+
+// checkArity returns a message describing what is wrong with n arguments,
+// or "" if the count is acceptable.
+func (c *commandSpec) checkArity(n int) string {
+	switch {
+	case n < c.minArgs:
+		return fmt.Sprintf("%s requires %s", c.name, c.arity)
+	case c.maxArgs == 0 && n > 0:
+		return fmt.Sprintf("%s takes no arguments", c.name)
+	case c.maxArgs == 1 && n > 1:
+		return fmt.Sprintf("%s takes at most 1 argument", c.name)
+	case c.maxArgs >= 0 && n > c.maxArgs:
+		return fmt.Sprintf("%s takes at most %d arguments", c.name, c.maxArgs)
+	}
+	return ""
+}
+
+// commandTable lists every command in the order HELP shows them, and
+// commandIndex finds them by name. Both are filled in by init, since the
+// HELP handler reads them.
+var (
+	commandTable []*commandSpec
+	commandIndex map[string]*commandSpec
+)
+
+func init() {
+	commandTable = []*commandSpec{
+		{name: "INIT", args: "[BYTES|WEIGHTED|TIERED] <capacity> [l2-capacity] [policy [args...]] [TINYLFU] [NOEVICT] [WATERMARK <low>] [IDLE <seconds>] [NORM <steps>] [MIGRATE] [name]",
+			summary: "Create a cache and select it",
+			details: "BYTES and WEIGHTED bound the total size or cost of the values instead of the\n" +
+				"entry count. TIERED, which takes l2-capacity, demotes evicted entries to a\n" +
+				"second LRU level of that many entries and promotes them back when used. The\n" +
+				"policy is LRU [MIDPOINT [fraction]] (the default), FIFO, LFU [DECAY <n>], 2Q,\n" +
+				"ARC, CLOCK, SLRU, LRUK [k] or SAMPLED [size [seed]]; MIDPOINT inserts new keys\n" +
+				"that fraction (0.5 by default) of the way down the list, and DECAY halves LFU\n" +
+				"frequencies every <n> inserts and hits. TINYLFU turns on the admission filter,\n" +
+				"NOEVICT makes writes to a full cache fail with ERR_FULL instead of evicting,\n" +
+				"WATERMARK evicts down to <low> entries once the cache is full, and IDLE expires\n" +
+				"entries not read or written for <seconds>. NORM applies the comma-separated\n" +
+				"steps lower, trim and collapse to every key, so that variants of a key share\n" +
+				"an entry. These options may come anywhere after the capacity. A trailing\n" +
+				"name, after the policy, creates a named cache.\n" +
+				"Over an existing cache of the same name INIT prints OK reinitialized\n" +
+				"dropped=<n>, the entries lost. MIGRATE instead carries over the most recently\n" +
+				"used entries that fit, in order and with their TTLs and pins, and prints\n" +
+				"migrated=<n> too; the new policy starts them afresh, as LFU at frequency 1.",
+			minArgs: 1, maxArgs: -1, arity: "capacity argument", noServer: true, mutates: true, audit: auditWrite, run: (*session).cmdInit},
+		{name: "SELECT", args: "<name>", summary: "Select a cache created with INIT",
+			minArgs: 1, maxArgs: 1, arity: "name argument", noServer: true, audit: auditWrite, run: (*session).cmdSelectDrop},
+		{name: "DROP", args: "<name>", summary: "Delete a cache other than the selected one",
+			minArgs: 1, maxArgs: 1, arity: "name argument", noServer: true, mutates: true, audit: auditWrite, run: (*session).cmdSelectDrop},
+		{name: "CACHES", summary: "List the caches with their sizes",
+			run: (*session).cmdCaches},
+		{name: "ADMISSION", args: "TINYLFU|NONE", summary: "Turn the TinyLFU admission filter on or off",
+			minArgs: 1, maxArgs: 1, arity: "TINYLFU or NONE", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdAdmission},
+		{name: "JANITOR", args: "ON <seconds>|OFF", summary: "Remove expired entries in the background",
+			minArgs: 1, maxArgs: 2, arity: "ON <seconds> or OFF", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdJanitor},
+		{name: "REFRESHSOURCE", args: "<path> [threshold]|OFF", summary: "Refresh entries near expiry from a key=value file",
+			details: "A GET that finds an entry with less than threshold (default 0.2) of its TTL\n" +
+				"left reloads its value from the file in the background and restarts the\n" +
+				"TTL. Refreshed values are not written to the AOF.",
+			minArgs: 1, maxArgs: 2, arity: "path argument or OFF", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdRefreshsource},
+		{name: "LIMITS", args: "<max-key-bytes> <max-value-bytes>", summary: "Reject longer keys and values; 0 for no limit",
+			minArgs: 2, maxArgs: 2, arity: "key and value length arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdLimits},
+		{name: "SETIDLE", args: "<seconds>", summary: "Expire entries left unused that long; 0 for no limit",
+			details: "GET, TOUCH and writes count as use; PEEK and EXISTS do not. Entries already\n" +
+				"cached are measured from their last use.",
+			minArgs: 1, maxArgs: 1, arity: "seconds argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdSetidle},
+		{name: "RESIZE", args: "<capacity>", summary: "Change the capacity, evicting as needed",
+			minArgs: 1, maxArgs: 1, arity: "capacity argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdResize},
+
+		{name: "PUT", args: "<key> <value> [ttl [grace]]", summary: "Store a value, expiring after ttl seconds if given",
+			details: "For grace seconds after it expires the value is still returned by GETSTALE.",
+			minArgs: 2, maxArgs: 4, arity: "key and value arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdPut},
+		{name: "MPUT", args: "<key> <value> [<key> <value>...]", summary: "Store several values at once",
+			minArgs: 2, maxArgs: -1, arity: "key and value pairs", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdMput},
+		{name: "PUTW", args: "<key> <value> <cost>", summary: "Store a value with an explicit cost in a WEIGHTED cache",
+			minArgs: 3, maxArgs: 3, arity: "key, value and cost arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdPutw},
+		{name: "PUTP", args: "<key> <value> <prio>", summary: "Store a value at priority 0 (low), 1 (normal) or 2 (high)",
+			details: "Low priority entries are all evicted before normal ones, and normal ones\n" +
+				"before high ones. PUT keeps the priority of a key it updates.",
+			minArgs: 3, maxArgs: 3, arity: "key, value and priority arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdPutp},
+		{name: "PUTRAW", args: "<key> <length>", summary: "Store the next <length> bytes of input as the value",
+			minArgs: 2, maxArgs: 2, arity: "key and length arguments", mutates: true, audit: auditWrite, run: (*session).cmdPutraw},
+		{name: "PUTIF", args: "<key> <expected> <value>", summary: "Replace the value only if it is <expected>",
+			minArgs: 3, maxArgs: 3, arity: "key, expected and value arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdPutifSetnx},
+		{name: "SETNX", args: "<key> <value>", summary: "Store a value only if the key is absent",
+			minArgs: 2, maxArgs: 2, arity: "key and value arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdPutifSetnx},
+		{name: "PUTV", args: "<key> <value> <version>", summary: "Store a value only if the key is at the given version",
+			details: "Every entry has a version, 1 when created and bumped each time its value\n" +
+				"changes; GETV shows it. Version 0 means the key must not exist. Prints the\n" +
+				"new version, or CONFLICT <version> with the current one and writes nothing.\n" +
+				"An existing entry keeps its TTL. Deleting a key resets its version.",
+			minArgs: 3, maxArgs: 3, arity: "key, value and version arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdPutv},
+		{name: "GETSET", args: "<key> <value>", summary: "Store a value and return the one it replaced",
+			minArgs: 2, maxArgs: 2, arity: "key and value arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdGetset},
+		{name: "GETORPUT", args: "<key> <value>", summary: "Return the cached value, storing <value> if absent",
+			minArgs: 2, maxArgs: 2, arity: "key and value arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdGetorput},
+		{name: "INCR", args: "<key> [delta]", summary: "Add delta (default 1) to an integer value",
+			minArgs: 1, maxArgs: 2, arity: "key argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdIncrDecr},
+		{name: "DECR", args: "<key> [delta]", summary: "Subtract delta (default 1) from an integer value",
+			minArgs: 1, maxArgs: 2, arity: "key argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdIncrDecr},
+		{name: "APPEND", args: "<key> <suffix>", summary: "Append to a value and return its new length",
+			minArgs: 2, maxArgs: 2, arity: "key and suffix arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdAppend},
+
+		{name: "LPUSH", args: "<key> <value>", summary: "Add to the front of a list and return its length",
+			details: "A list is one entry: it is evicted as a whole, and in a BYTES cache it costs\n" +
+				"the total length of its elements. Every list command marks it recently used.",
+			minArgs: 2, maxArgs: 2, arity: "key and value arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdPush},
+		{name: "RPUSH", args: "<key> <value>", summary: "Add to the back of a list and return its length",
+			minArgs: 2, maxArgs: 2, arity: "key and value arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdPush},
+		{name: "LLEN", args: "<key>", summary: "Return the length of a list, 0 if absent",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdLlen},
+		{name: "LRANGE", args: "<key> <start> <stop>", summary: "Return list elements start to stop, one per line",
+			details: "Both ends are inclusive and negative indices count from the end, so\n" +
+				"LRANGE key 0 -1 returns the whole list.",
+			minArgs: 3, maxArgs: 3, arity: "key, start and stop arguments", needsCache: true,
+			audit: auditRead, run: (*session).cmdLrange},
+		{name: "HSET", args: "<key> <field> <value>", summary: "Set a field of a hash; 1 if it is new, 0 if replaced",
+			details: "Like a list, a hash is one entry that is evicted as a whole, and every hash\n" +
+				"command marks it recently used.",
+			minArgs: 3, maxArgs: 3, arity: "key, field and value arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdHset},
+		{name: "HGET", args: "<key> <field>", summary: "Return a field of a hash",
+			minArgs: 2, maxArgs: 2, arity: "key and field arguments", needsCache: true,
+			audit: auditRead, run: (*session).cmdHget},
+		{name: "HDEL", args: "<key> <field>", summary: "Remove a field, and the key with its last field",
+			minArgs: 2, maxArgs: 2, arity: "key and field arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdHdel},
+		{name: "HGETALL", args: "<key>", summary: "Return every field and value of a hash in field order",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdHgetall},
+
+		{name: "GET", args: "<key>", summary: "Return a value and mark it recently used",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdGet},
+		{name: "GETANY", args: "<key>", summary: "Return a possibly stale value without locking or promoting",
+			details: "The read takes no lock, so it may miss a write or deletion made at the same\n" +
+				"moment, or return a value just overwritten or deleted. It never changes the\n" +
+				"entry's recency, and ignores max idle time and the second level of a tiered\n" +
+				"cache. Hits and misses are counted in STATS.",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdGetany},
+		{name: "GETDEF", args: "<key> <default>", summary: "Print the value for a key, or <default> if it is missing",
+			details: "The default is not stored and the miss is counted. In JSON mode the status\n" +
+				"is hit for a cached value and miss for the default.",
+			minArgs: 2, maxArgs: 2, arity: "key and default arguments", needsCache: true, audit: auditRead, run: (*session).cmdGetdef},
+		{name: "GETFRESH", args: "<key> <max_age_seconds>", summary: "Print the value for a key written at most max_age_seconds ago",
+			details: "An older entry prints NULL but stays cached and unpromoted, and is counted as\n" +
+				"stale_rejects in STATS rather than as a hit or a miss. Only a change of value\n" +
+				"renews it: TOUCH and EXPIRE do not.",
+			minArgs: 2, maxArgs: 2, arity: "key and max age arguments", needsCache: true, audit: auditRead, run: (*session).cmdGetfresh},
+		{name: "GETV", args: "<key>", summary: "Print the version and the value for a key",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdGetv},
+		{name: "GETINT", args: "<key>", summary: "Print a key's value as an integer",
+			details: "Fails with ERR_NOT_INTEGER, as INCR does, if the value is not a base-10\n" +
+				"64-bit integer.",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdGetint},
+		{name: "GETSEQ", args: "<key>", summary: "Print the number of the write that stored a key's value, and the value",
+			details: "Writes are numbered in one sequence per cache, shared by every connection;\n" +
+				"see SEQ.",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdGetseq},
+		{name: "ETAG", args: "<key>", summary: "Print the hash of a key's value that HTTP sends as its ETag",
+			details: "The FNV-64a hash of the value, in hex, worked out whenever it is written.\n" +
+				"The entry is not promoted and the read is not counted.",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdEtag},
+		{name: "GETRAW", args: "<key>", summary: "Return a value as VALUE <length> followed by its bytes",
+			details: "Protocol 2 drops it, as GET frames the values that need it there; see HELLO.",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, retired: 2, audit: auditRead, run: (*session).cmdGetraw},
+		{name: "MGET", args: "<key>...", summary: "Return several values, one per line",
+			minArgs: 1, maxArgs: -1, arity: "at least one key argument", needsCache: true,
+			audit: auditRead, run: (*session).cmdMget},
+		{name: "GETSTALE", args: "<key>", summary: "Return a value like GET, or STALE <value> in its grace period",
+			details: "The first GETSTALE to find the value stale gets REVALIDATE <value> instead:\n" +
+				"that caller should fetch a fresh value and PUT it. The others get STALE\n" +
+				"until then.",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdGetstale},
+		{name: "PEEK", args: "<key>", summary: "Return a value without marking it used",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdPeek},
+		{name: "EXISTS", args: "<key>...", summary: "Print 1 or 0 for each key that is present",
+			minArgs: 1, maxArgs: -1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdExists},
+		{name: "INFO", args: "<key>", summary: "Show an entry's hits, age, idle time, TTL and position",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdInfo},
+		{name: "SIZEOF", args: "<key>", summary: "Estimate the memory an entry takes, in bytes",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, audit: auditRead, run: (*session).cmdSizeof},
+		{name: "KEYS", args: "[prefix]", summary: "List the keys in retention order",
+			maxArgs: 1, needsCache: true, audit: auditRead, run: (*session).cmdKeys},
+		{name: "RANGEKEYS", args: "<start> <end>", summary: "List the keys from start up to end in lexicographic order",
+			details: "The range includes start but not end; an empty end, \"\", has no upper\n" +
+				"bound. With --key-index this costs O(log n + k) rather than a walk of every key.",
+			minArgs: 2, maxArgs: 2, arity: "start and end arguments", needsCache: true, audit: auditRead, run: (*session).cmdRangekeys},
+		{name: "SCAN", args: "<cursor> [count]", summary: "List keys incrementally, starting from cursor 0",
+			minArgs: 1, maxArgs: 2, arity: "cursor argument", needsCache: true, audit: auditRead, run: (*session).cmdScan},
+		{name: "RANDOMKEY", summary: "Print a key chosen uniformly at random, without marking it used",
+			needsCache: true, audit: auditRead, run: (*session).cmdRandomkey},
+		{name: "OLDEST", summary: "Show the entry that would be evicted next",
+			needsCache: true, audit: auditRead, run: (*session).cmdPopOldestNewest},
+		{name: "NEWEST", summary: "Show the most recently used entry",
+			needsCache: true, audit: auditRead, run: (*session).cmdPopOldestNewest},
+
+		{name: "DELETE", args: "<key>", summary: "Remove an entry",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdDelete},
+		{name: "GETDEL", args: "<key>", summary: "Remove an entry and return its value",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdGetdel},
+		{name: "DELPREFIX", args: "<prefix>", summary: "Remove every key with the prefix and print the count",
+			minArgs: 1, maxArgs: 1, arity: "prefix argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdDelprefix},
+		{name: "POP", summary: "Remove and show the entry that would be evicted next",
+			needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdPopOldestNewest},
+		{name: "CLEAR", summary: "Remove every entry",
+			needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdClear},
+		{name: "RESETAT", args: "<unix_seconds>", summary: "Clear the cache once the clock reaches a time; 0 cancels",
+			details: "The clear happens on the first command at or after the time, or in a janitor\n" +
+				"sweep, whatever the entries' TTLs, and only once. Another RESETAT replaces the\n" +
+				"schedule. STATS shows the time as reset_at, and SAVE keeps it for LOAD.",
+			minArgs: 1, maxArgs: 1, arity: "time argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdResetat},
+		{name: "TOMBSTONES", args: "ON <seconds>|OFF", summary: "Remember deleted keys for a while, for DELETED",
+			details: "While ON, each key DELETE, GETDEL, DELPREFIX, POP or CLEAR removes leaves a\n" +
+				"numbered tombstone for <seconds>. Tombstones count against the capacity and\n" +
+				"are dropped first when room is needed; writing the key drops its tombstone.\n" +
+				"OFF forgets them all.",
+			minArgs: 1, maxArgs: 2, arity: "ON or OFF", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdTombstones},
+		{name: "DELETED", args: "<since>", summary: "List the keys deleted after the deletion numbered <since>",
+			details: "Prints a line of <seq> <key> for each retained tombstone, in deletion order;\n" +
+				"pass the last <seq> seen to get only newer deletions, or 0 for all.",
+			minArgs: 1, maxArgs: 1, arity: "since argument", needsCache: true, audit: auditRead, run: (*session).cmdDeleted},
+		{name: "RENAME", args: "<src> <dst>", summary: "Move an entry to a new key, replacing any entry there",
+			minArgs: 2, maxArgs: 2, arity: "source and destination key arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdRenameCopy},
+		{name: "COPY", args: "<src> <dst>", summary: "Copy an entry to a new key, replacing any entry there",
+			minArgs: 2, maxArgs: 2, arity: "source and destination key arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdRenameCopy},
+
+		{name: "EXPIRE", args: "<key> <seconds>", summary: "Set an entry's TTL",
+			minArgs: 2, maxArgs: 2, arity: "key and seconds arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdExpire},
+		{name: "EXPIREAT", args: "<key> <unix-seconds>", summary: "Set the instant an entry expires",
+			details: "The time is in seconds since the Unix epoch, by the cache's clock, and may be\n" +
+				"fractional; one that has passed expires the entry at once. Unlike a TTL it is\n" +
+				"not restarted by TOUCH or refresh-ahead. PERSIST removes it as it does a TTL.",
+			minArgs: 2, maxArgs: 2, arity: "key and timestamp arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdExpireat},
+		{name: "JITTER", args: "<fraction>", summary: "Spread TTL deadlines by up to a fraction either way",
+			details: "Each TTL that PUT, EXPIRE, TOUCH or refresh-ahead sets is scaled by a random\n" +
+				"factor between 1-fraction and 1+fraction, drawn as SEED makes repeatable, so\n" +
+				"keys written together do not all expire together. EXPIREAT is exact. 0 turns\n" +
+				"it off; --ttl-jitter sets it for every cache.",
+			minArgs: 1, maxArgs: 1, arity: "fraction argument", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdJitter},
+		{name: "PERSIST", args: "<key>", summary: "Remove an entry's TTL",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdPersist},
+		{name: "TOUCH", args: "<key>", summary: "Mark an entry used without reading it",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdTouch},
+		{name: "PIN", args: "<key>", summary: "Protect an entry from eviction",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdPinUnpin},
+		{name: "UNPIN", args: "<key>", summary: "Make a pinned entry evictable again",
+			minArgs: 1, maxArgs: 1, arity: "key argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdPinUnpin},
+
+		{name: "SIZE", summary: "Print the number of entries",
+			needsCache: true, run: (*session).cmdSize},
+		{name: "CAPACITY", summary: "Print the capacity, or the byte or weight budget",
+			needsCache: true, run: (*session).cmdCapacity},
+		{name: "HEADROOM", summary: "Print how much can be added before eviction starts",
+			details: "This is the capacity minus the size, or the bytes or weight left in those\n" +
+				"modes. With a WATERMARK it is the distance to the capacity, where the\n" +
+				"batch eviction starts.",
+			needsCache: true, run: (*session).cmdHeadroom},
+		{name: "USEDBYTES", summary: "Print the bytes used by a BYTES cache",
+			needsCache: true, run: (*session).cmdUsedbytes},
+		{name: "STATS", args: "[RESET]", summary: "Show the cache counters, or zero them",
+			maxArgs: 1, needsCache: true, run: (*session).cmdStats},
+		{name: "STATSWINDOW", args: "<n>", summary: "Report the hit ratio over the last n lookups in STATS",
+			details: "STATS then adds the size of the window, the lookups in it so far, the share\n" +
+				"of them that were hits and the entries evicted per lookup, and /metrics\n" +
+				"reports the last two as gauges. STATS RESET empties the window and\n" +
+				"STATSWINDOW 0 stops keeping it.",
+			minArgs: 1, maxArgs: 1, arity: "window size", needsCache: true, run: (*session).cmdStatswindow},
+		{name: "MEMORY", summary: "Estimate the memory the entries take, beside the Go heap in use",
+			details: "The estimate covers entries, their keys and their values; heap_alloc is\n" +
+				"everything the process has allocated and not yet freed. With\n" +
+				"--compact-storage it adds the cache's slabs, the bytes in them, how many of\n" +
+				"those are dead and how many compactions have run.",
+			needsCache: true, run: (*session).cmdMemory},
+		{name: "SEED", args: "<n>", summary: "Seed the random choices of RANDOMKEY and the SAMPLED policy",
+			minArgs: 1, maxArgs: 1, arity: "seed argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdSeed},
+		{name: "CHECKPOINT", args: "<name>|DROP <name>", summary: "Remember the keys, values and order for DIFF",
+			details: "At most --max-checkpoints (default 8) are kept at once; CHECKPOINT DROP\n" +
+				"frees one. Taking one under a name already in use replaces it.",
+			minArgs: 1, maxArgs: 2, arity: "name argument", needsCache: true, run: (*session).cmdCheckpoint},
+		{name: "DIFF", args: "<name>", summary: "Show how the cache changed since a CHECKPOINT",
+			details: "Lists the keys added, removed, holding a changed value and moved in the\n" +
+				"retention order, each sorted. Moved names the fewest keys whose moves\n" +
+				"explain the new order.",
+			minArgs: 1, maxArgs: 1, arity: "name argument", needsCache: true, run: (*session).cmdDiff},
+		{name: "PROFILE", args: "ON <multiple,...>|OFF|REPORT", summary: "Estimate the hit ratio at other cache sizes",
+			details: "PROFILE ON 2x,4x,0.5x keeps a key-only LRU ghost cache at each multiple of\n" +
+				"the capacity, fed every GET and PUT from then on. PROFILE REPORT prints the\n" +
+				"hits and misses of the real cache and of each ghost, and PROFILE OFF frees\n" +
+				"the ghosts.",
+			minArgs: 1, maxArgs: 2, arity: "ON, OFF or REPORT", needsCache: true, audit: auditWrite, run: (*session).cmdProfile},
+		{name: "HOTKEYS", args: "[ON <k>|OFF]", summary: "Track the k keys read most often, or list them",
+			details: "HOTKEYS ON 10 counts every GET from then on with the space-saving algorithm,\n" +
+				"in memory bounded by k. HOTKEYS lists the keys tracked, most read first, each\n" +
+				"with its estimated count and the most by which that may overstate it; any key\n" +
+				"read more than 1/k of the time is listed.",
+			maxArgs: 2, needsCache: true, audit: auditWrite, run: (*session).cmdHotkeys},
+		{name: "AUTOTUNE", args: "[ON <min> <max> <interval>|OFF]", summary: "Resize the cache to where the profiler shows hits are gained or not lost",
+			details: "Profiles the cache at 0.5x, 1x and 2x and, every <interval> lookups, doubles it\n" +
+				"if 2x would have hit at least 1% more often or else halves it if 0.5x would\n" +
+				"have hit at most 0.2% less often, within [<min>, <max>]. Each change is logged\n" +
+				"to stderr and counted as autotunes in STATS; PROFILE REPORT shows the ghosts.\n" +
+				"OFF keeps the capacity reached.",
+			maxArgs: 4, needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdAutotune},
+		{name: "EVENTS", args: "[ON|OFF [classes]]", summary: "Write a line to stderr for each cache event of some classes",
+			details: "The classes are a comma-separated list of evict, expire, hit and miss, or all,\n" +
+				"the default. ON adds them to those written, as --verbose does at startup, and\n" +
+				"OFF takes them away. With no arguments, shows the classes written.",
+			maxArgs: 2, run: (*session).cmdEvents},
+		{name: "CONFIG", args: "GET <name>", summary: "Show the value a startup option took and where it was set",
+			details: "<name> is a flag's name without the dashes. The source is flag, env and the\n" +
+				"LRUCACHE_ variable, the --config file and line, or default, in that order of\n" +
+				"precedence. A password is not shown.",
+			minArgs: 2, maxArgs: 2, arity: "GET and name arguments", run: (*session).cmdConfig},
+		{name: "LOGLEVEL", args: "[debug|info|warn|error]", summary: "Set or show the level of the log written to stderr",
+			details: "The log reports the problems of background work, such as a failed refresh, a\n" +
+				"recovered panic or a failing backing store, as key=value lines. Lines below\n" +
+				"the level, info at startup unless --log-level says otherwise, are dropped.",
+			maxArgs: 1, audit: auditWrite, run: (*session).cmdLoglevel},
+		{name: "LATENCY", args: "[RESET|ON [sample]|OFF]", summary: "Show GET and PUT latency percentiles in microseconds",
+			details: "Prints the count, p50, p90, p99 and max for each operation. Only one\n" +
+				"operation in sample (default 32) is timed, to keep the cost down; ON 1\n" +
+				"times them all. RESET empties the histograms and OFF stops tracking.\n" +
+				"Tracking is on by default.",
+			maxArgs: 2, needsCache: true, run: (*session).cmdLatency},
+		{name: "DEBUG", args: "DUMP|CHECK|OBJECT <key>|SLEEP <millis>", summary: "Dump or check the cache's internal structure",
+			details: "OBJECT prints what is known about one entry without promoting it: its type,\n" +
+				"length and cost, its tier, the segment ordering it for eviction and the\n" +
+				"policy's state for it, its hits, the admission filter's or else the hot key\n" +
+				"tracker's estimate of its reads, its version, its TTL and whether it is pinned.\n" +
+				"SLEEP holds up this connection's reply for up to 60000 milliseconds, without\n" +
+				"holding up other connections, to test clients' timeouts; it needs no cache.",
+			minArgs: 1, maxArgs: 2, arity: "DUMP, CHECK, OBJECT or SLEEP", audit: auditWrite, run: (*session).cmdDebug},
+
+		{name: "WARM", args: "<path>", summary: "Store the key/value lines of a file in order",
+			details: "Each line is a key and a value quoted as for PUT; malformed lines are\n" +
+				"skipped. Later lines end up most recently used, and if the file holds more\n" +
+				"than fits only its tail survives. Prints OK loaded=<n> evicted=<m>.",
+			minArgs: 1, maxArgs: 1, arity: "path argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdWarm},
+		{name: "SAVE", args: "<path>", summary: "Write a snapshot of the cache to a file",
+			details: "The reply gives the number of entries saved and how long the cache was\n" +
+				"locked while they were copied; clients are not held up while the file is\n" +
+				"written, and writes made meanwhile are not in it.",
+			minArgs: 1, maxArgs: 1, arity: "path argument", needsCache: true, run: (*session).cmdSaveLoad},
+		{name: "LOAD", args: "<path> [MERGE] [COLD]", summary: "Replace the cache's contents with a snapshot",
+			details: "The snapshot's entries keep their order. MERGE keeps the entries already\n" +
+				"cached, but for those the snapshot has keys of, and puts the snapshot's in\n" +
+				"front of them, evicting the least recently used to make room. COLD puts the\n" +
+				"snapshot's entries at the cold end instead, to be evicted first unless read;\n" +
+				"with MERGE they go behind every entry already cached, as many as there is\n" +
+				"room for. Policies without a recency order place them as they would a PUT.",
+			minArgs: 1, maxArgs: 3, arity: "path argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdSaveLoad},
+		{name: "SPLIT", args: "<n> <dir>", summary: "Write the cache as n snapshots, partitioned by key hash",
+			details: "Each key goes to file shard-<i>-of-<n>.snapshot in <dir>, where i is the\n" +
+				"64-bit FNV-1a hash of the key modulo n, so a router can send it to the same\n" +
+				"instance. Each file is in SAVE's format with its entries in the same recency\n" +
+				"order, ready for LOAD; some may be empty. Prints each file and its count.",
+			minArgs: 2, maxArgs: 2, arity: "n and dir arguments", needsCache: true, run: (*session).cmdSplit},
+		{name: "EXPORT", args: "<path>", summary: "Write the cache as an editable JSON document",
+			details: "The document gives the capacity, the policy and the live entries, most\n" +
+				"recently used first, each with its key, value, time left to live and whether\n" +
+				"it is pinned. Prints OK <n> with the number of entries written.",
+			minArgs: 1, maxArgs: 1, arity: "path argument", needsCache: true, run: (*session).cmdExportImport},
+		{name: "IMPORT", args: "<path>", summary: "Replace the cache's contents with an EXPORT document",
+			details: "As with LOAD the cache keeps its own capacity and policy, and only the most\n" +
+				"recent entries that fit are kept. A document with unknown fields, values of\n" +
+				"the wrong type or a key given twice is rejected and the cache left as it was.",
+			minArgs: 1, maxArgs: 1, arity: "path argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdExportImport},
+		{name: "AOF", args: "ON <path>|OFF|REWRITE", summary: "Start, stop or compact the append-only log",
+			minArgs: 1, maxArgs: 2, arity: "ON, OFF or REWRITE", run: (*session).cmdAof},
+
+		{name: "STRESS", args: "<goroutines> <ops>", summary: "Run random operations from concurrent goroutines",
+			minArgs: 2, maxArgs: 2, arity: "goroutines and ops arguments", needsCache: true,
+			mutates: true, audit: auditWrite, run: (*session).cmdStress},
+		{name: "BENCH", args: "<ops> <keyspace> [ZIPF <s>] [read-ratio] | SEED <n> | CONTENTION <seconds>", summary: "Time a random workload against the cache",
+			details: "Keys are drawn uniformly from <keyspace> keys, or from a Zipf distribution\n" +
+				"with exponent <s>. read-ratio is the fraction of GETs (default 0.5). SEED sets\n" +
+				"the seed of later runs, so they issue the same operations. CONTENTION runs 32\n" +
+				"readers against 2 writers for <seconds>, first with GET and then with GETANY,\n" +
+				"and prints the reads per second of each.",
+			minArgs: 2, maxArgs: 5, arity: "ops and keyspace arguments", mutates: true, audit: auditWrite, run: (*session).cmdBench},
+		{name: "REPLAY", args: "<path>", summary: "Drive the cache with an access trace",
+			minArgs: 1, maxArgs: 1, arity: "path argument", needsCache: true, mutates: true, audit: auditWrite, run: (*session).cmdReplay},
+		{name: "SIMULATE", args: "<path> <capacity> <policy,...>", summary: "Compare policies' hit ratios on an access trace",
+			minArgs: 3, maxArgs: 3, arity: "path, capacity and policies arguments", run: (*session).cmdSimulate},
+
+		{name: "SUBSCRIBE", summary: "Stream every change to the default cache to this connection",
+			details: "After OK, each PUT, DELETE, EXPIRE and other change arrives as the line the AOF\n" +
+				"would record for it, from the moment of subscribing. Nothing sent afterwards is\n" +
+				"run; close the connection to unsubscribe. A subscriber that falls 1024 changes\n" +
+				"behind gets ERR_SLOW_CONSUMER and is disconnected.",
+			run: (*session).cmdSubscribe},
+		{name: "SYNC", summary: "Send a snapshot of the default cache, then stream its changes, to a replica",
+			details: "The reply, always in text, is SNAPSHOT <n> and n lines that rebuild the cache,\n" +
+				"oldest entry first, then each change stamped with the Unix time in nanoseconds it\n" +
+				"was made, and a bare time every second as a heartbeat. REPLICATE speaks it.",
+			run: (*session).cmdSync},
+		{name: "REPLICATE", args: "[<host:port> [<password>]|OFF]", summary: "Follow another server into the default cache, stop, or show the link",
+			details: "The cache is replaced with the primary's contents over SYNC and then kept up to\n" +
+				"date; meanwhile every command that changes a cache fails with ERR_READONLY. A lost\n" +
+				"link is retried with backoff from a fresh snapshot. OFF keeps the contents and\n" +
+				"takes writes again. STATS reports link_status and replication_lag in seconds.",
+			maxArgs: 2, audit: auditWrite, run: (*session).cmdReplicate},
+		{name: "AUTH", args: "<password>", summary: "Authenticate a connection to a server started with a password",
+			details: "Until it does, a TCP connection to a server started with --password or\n" +
+				"--auth-file gets ERR_NOAUTH for every other command. Stdin never needs it.",
+			minArgs: 1, maxArgs: 1, arity: "password argument", run: (*session).cmdAuth},
+		{name: "TENANT", args: "[<name>]", summary: "Set or show the tenant this connection's entries belong to",
+			details: "Requires --tenant-quota, the share of the cache in percent each tenant may hold;\n" +
+				"a tenant over it loses its own least recently used entries, and room for others\n" +
+				"comes from entries outside any tenant first. TENANT \"\" returns to that default\n" +
+				"pool. The tenant lasts for the connection; STATS reports each tenant.",
+			maxArgs: 1, audit: auditWrite, run: (*session).cmdTenant},
+		{name: "READONLY", args: "[ON|OFF]", summary: "Refuse or allow writes, or show which",
+			details: "While ON, every command that changes a cache fails with ERR_READONLY, over\n" +
+				"TCP, RESP and HTTP alike, and reads change nothing either: GET does not\n" +
+				"promote, expired entries are not removed and the janitor does not sweep.\n" +
+				"The mode is the server's, not the connection's; --readonly starts in it.",
+			maxArgs: 1, audit: auditWrite, run: (*session).cmdReadonly},
+		{name: "TIME", args: "<command> [args...]", summary: "Run a command and append the time it took to its response",
+			details: "Only the command's own work is timed, in microseconds: the text response ends\n" +
+				"with \" (<micros>µs)\" and a JSON one gains an elapsed_us field. In a line of\n" +
+				"commands separated by semicolons TIME applies to the one it prefixes.",
+			minArgs: 1, maxArgs: -1, arity: "command argument", run: (*session).cmdTime},
+		{name: "DRYRUN", args: "<command> [args...]", summary: "Show what a PUT, RESIZE or read would do without doing it",
+			details: "Nothing changes: no entry is written, evicted, promoted or counted. PUT reports\n" +
+				"INSERT, UPDATE or REJECTED (turned away by the admission filter), then the\n" +
+				"number of keys it would evict and the keys, in eviction order, and RESIZE\n" +
+				"reports RESIZE, the number and the keys. GET, GETANY, GETDEF, GETRAW, GETV,\n" +
+				"MGET and EXISTS report HIT or MISS for each key. The command is refused, or\n" +
+				"fails, as it would if it were run.",
+			minArgs: 1, maxArgs: -1, arity: "command argument", needsCache: true, run: (*session).cmdDryrun},
+		{name: "TIMEALL", args: "ON|OFF", summary: "Time every command on this connection, as TIME does",
+			minArgs: 1, maxArgs: 1, arity: "ON or OFF", run: (*session).cmdTimeall},
+		{name: "SEQ", args: "ON|OFF", summary: "Append the last write number to every mutating command's response",
+			details: "Each change of value and each deletion takes the next number of the cache's\n" +
+				"sequence. With SEQ ON a successful PUT prints OK seq=<n>, other mutating\n" +
+				"commands append seq=<n> to their response, or a seq field in JSON mode, and\n" +
+				"run one at a time, so n is the number of the command's own last write. SAVE\n" +
+				"keeps the numbers and LOAD restores them; GETSEQ reads them back.",
+			minArgs: 1, maxArgs: 1, arity: "ON or OFF", run: (*session).cmdSeq},
+		{name: "SHADOW", args: "ON|OFF|REPORT", summary: "Check GET, PEEK, PUT and DELETE against a reference LRU model",
+			details: "With SHADOW ON each of these commands also runs on a slow reference model of an\n" +
+				"LRU cache, and a response, or a set or order of keys afterwards, that differs\n" +
+				"from the model's is written to stderr as DIVERGENCE with the command, both\n" +
+				"responses and the least key they disagree on; other commands are not checked.\n" +
+				"Only a plain LRU cache bounded by entry count can be checked. SHADOW REPORT\n" +
+				"prints how many commands were checked and not, the number of divergences and\n" +
+				"the latest of them.",
+			minArgs: 1, maxArgs: 1, arity: "ON, OFF or REPORT", noServer: true, run: (*session).cmdShadow},
+		{name: "ALIAS", args: "<name> <command...>", summary: "Name a command with parameters for this connection",
+			details: "In the command, $1, $2 and so on stand for the arguments the alias is given,\n" +
+				"which it must be given all of; ALIAS SETJ PUT $1 $2 30 makes SETJ k v store\n" +
+				"v under k for 30 seconds. An argument holding spaces stays one token. An alias\n" +
+				"or macro that would run itself, directly or through others, is refused.",
+			minArgs: 2, maxArgs: -1, arity: "name and command arguments", run: (*session).cmdAlias},
+		{name: "MACRO", args: "<name> BEGIN", summary: "Name the commands on the lines up to END for RUN",
+			details: "The lines are stored, not run, and may use $1, $2 and so on as ALIAS does;\n" +
+				"each of their commands gives its own response when the macro is run.",
+			minArgs: 2, maxArgs: 2, arity: "name and BEGIN", run: (*session).cmdMacro},
+		{name: "RUN", args: "<name> [args...]", summary: "Run a macro or alias with arguments",
+			minArgs: 1, maxArgs: -1, arity: "name argument", run: (*session).cmdRun},
+		{name: "ALIASES", summary: "List this connection's aliases and macros",
+			run: (*session).cmdAliases},
+		{name: "UNALIAS", args: "<name>", summary: "Remove an alias or macro",
+			minArgs: 1, maxArgs: 1, arity: "name argument", run: (*session).cmdUnalias},
+		{name: "MODE", args: "JSON|TEXT|FRAMED", summary: "Switch the response format",
+			details: "FRAMED is TEXT with every value written as VALUE <len>, a newline, the value's\n" +
+				"bytes and a newline, as GETRAW writes it, and every miss as MISS, so that no\n" +
+				"value reads as a status such as NULL or ERROR; MGET writes a frame or MISS\n" +
+				"for each key.",
+			minArgs: 1, maxArgs: 1, arity: "JSON, TEXT or FRAMED", run: (*session).cmdMode},
+		{name: "PING", summary: "Print PONG",
+			run: (*session).cmdPing},
+		{name: "ECHO", args: "<text>", summary: "Print the rest of the command exactly as written",
+			details: "The text ends at the first semicolon outside a quoted token, as every\n" +
+				"command does, and is printed with any quotes as they were typed.",
+			minArgs: 1, maxArgs: 1, arity: "text argument", raw: true, run: (*session).cmdEcho},
+		{name: "VERSION", summary: "Print the program version",
+			run: (*session).cmdVersion},
+		{name: "HELLO", args: "[version]", summary: "Show the protocol version and features, or switch this stream's version",
+			details: "It prints the stream's protocol version, the versions it can switch to and the\n" +
+				"features that work over it, which depend on the flags and on whether it is a\n" +
+				"TCP connection, after switching to the version given, if any. Version 1 is the\n" +
+				"protocol as it has always been. Version 2 writes every error with its code,\n" +
+				"even with --legacy-errors; frames a value that spans lines, or starts with\n" +
+				"VALUE or LINES, as GETRAW does, and any other such response as LINES <n>\n" +
+				"followed by its n lines; and drops GETRAW. JSON mode is the same in both but\n" +
+				"for the codes.",
+			maxArgs: 1, run: (*session).cmdHello},
+		{name: "HELP", args: "[command]", summary: "List the commands, or describe one",
+			maxArgs: 1, run: (*session).cmdHelp},
+	}
+	commandIndex = make(map[string]*commandSpec, len(commandTable))
+	for _, c := range commandTable {
+		commandIndex[c.name] = c
+	}
+}
+
+// dispatch runs the command described by c, recording it in the audit log
+// if it is one the log covers and checking it against the reference model
+// with SHADOW ON.
+func (s *session) dispatch(c *commandSpec, in *bufio.Reader, out *reply, line string, parts []string) {
+	run := s.runCommand
+	if s.audit.covers(c.audit) {
+		run = s.runAudited
+	}
+	if out.shadow != nil && c.needsCache {
+		s.runShadowed(run, c, in, out, line, parts)
+		return
+	}
+	run(c, in, out, line, parts)
+}
+
+// runCommand runs the command described by c after the checks every
+// command shares.
+func (s *session) runCommand(c *commandSpec, in *bufio.Reader, out *reply, line string, parts []string) {
+	if !s.allowed(c, out, len(parts)-1) {
+		return
+	}
+	if c.needsCache {
+		defer s.asTenant(out.tenant)()
+	}
+	switch {
+	case c.mutates && c.needsCache && out.seqs:
+		// Run alone, so that the number the response carries is the
+		// command's own.
+		s.writing.Lock()
+		defer s.writing.Unlock()
+		defer seqReply(out, s.cache)()
+	case c.mutates:
+		s.writing.RLock()
+		defer s.writing.RUnlock()
+	}
+	start := time.Now()
+	c.run(s, in, out, line, parts)
+	out.elapsed = time.Since(start)
+}
+
+// allowed reports whether c may run now with nargs arguments, writing the
+// error that refuses it if not.
+func (s *session) allowed(c *commandSpec, out *reply, nargs int) bool {
+	if out.locked && c.name != "AUTH" {
+		s.failedAuth()
+		out.Error(CodeNoAuth, "Authentication required")
+		return false
+	}
+	if c.noServer && s.server {
+		out.Errorf(CodeNotAllowed, "%s is not allowed in server mode", c.name)
+		return false
+	}
+	if why := s.refusal(); c.mutates && why != "" {
+		out.Errorf(CodeReadOnly, "%s is not allowed %s", c.name, why)
+		return false
+	}
+	if c.retired > 0 && out.protocol >= c.retired {
+		out.Errorf(CodeUnsupported, "%s is not in protocol %d", c.name, out.protocol)
+		return false
+	}
+	if c.needsCache && s.cache == nil {
+		out.Error(CodeNotInitialized, "Cache not initialized")
+		return false
+	}
+	if msg := c.checkArity(nargs); msg != "" {
+		out.Error(CodeArity, msg)
+		return false
+	}
+	return true
+}
+
+func (s *session) cmdHelp(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) == 1 {
+		lines := make([]string, len(commandTable))
+		list := make([]map[string]string, len(commandTable))
+		for i, c := range commandTable {
+			lines[i] = c.usage() + " - " + c.summary
+			list[i] = map[string]string{"name": c.name, "usage": c.usage(), "summary": c.summary}
+		}
+		out.Result(strings.Join(lines, "\n"), list)
+		return
+	}
+	name := strings.ToUpper(parts[1])
+	c, ok := commandIndex[name]
+	if !ok {
+		if suggestion := closestCommand(name); suggestion != "" {
+			out.Errorf(CodeUnknownCommand, "Unknown command: %s (did you mean %s?)", parts[1], suggestion)
+		} else {
+			out.Errorf(CodeUnknownCommand, "Unknown command: %s", parts[1])
+		}
+		return
+	}
+	text := "Usage: " + c.usage() + "\n" + c.summary
+	if c.details != "" {
+		text += "\n" + c.details
+	}
+	out.Result(text, map[string]string{"name": c.name, "usage": c.usage(), "summary": c.summary, "details": c.details})
+}
+
+// closestCommand returns the registered command nearest to name in edit
+// distance, or "" if none is close enough to be a likely typo.
+func closestCommand(name string) string {
+	best, bestDist := "", max(2, len(name)/3)+1
+	for _, c := range commandTable {
+		if d := editDistance(name, c.name); d < bestDist {
+			best, bestDist = c.name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+var errCorruptValue = errors.New("corrupt compressed value")
+
+var (
+	flateWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	}}
+	flateReaders = sync.Pool{New: func() any {
+		return flate.NewReader(nil)
+	}}
+)
+
+// pack compresses s, prefixed with its length as a uvarint, and reports
+// whether that came out shorter than s. Strings that do not shrink are
+// better stored as they are, where reading them costs nothing.
+func pack(s string) (string, bool) {
+	var buf bytes.Buffer
+	buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	io.WriteString(w, s)
+	if err := w.Close(); err != nil || buf.Len() >= len(s) {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// packedLen returns the length of the string packed holds.
+func packedLen(packed string) int {
+	n, _ := binary.Uvarint([]byte(packed[:min(len(packed), binary.MaxVarintLen64)]))
+	return int(n)
+}
+
+// unpack returns the string pack compressed into packed.
+func unpack(packed string) (string, error) {
+	n, k := binary.Uvarint([]byte(packed[:min(len(packed), binary.MaxVarintLen64)]))
+	if k <= 0 {
+		return "", errCorruptValue
+	}
+	r := flateReaders.Get().(io.ReadCloser)
+	defer flateReaders.Put(r)
+	if err := r.(flate.Resetter).Reset(strings.NewReader(packed[k:]), nil); err != nil {
+		return "", err
+	}
+	out := make([]byte, n)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return "", errCorruptValue
+	}
+	return string(out), nil
+}
+
+// SetCompression makes the cache store each value in the form compress
+// returns for it, before it is charged for, so that in a byte-bounded cache
+// a value compress shrinks costs what it takes to store. compress must
+// return a value it has already transformed as it is. It must be called
+// before anything is stored; a second level stores values the same way.
+func (c *LRUCache[K, V]) SetCompression(compress func(value V) V) {
+	c.lock()
+	defer c.unlock()
+	c.compress = compress
+	if c.l2 != nil {
+		c.l2.SetCompression(compress)
+	}
+}
+
+// compressFrom returns the SetCompression function that stores strings of
+// at least minLen bytes compressed, when that makes them shorter.
+func compressFrom(minLen int) func(Value) Value {
+	return func(v Value) Value {
+		if v.list != nil || v.hash != nil || v.packed || len(v.str) < minLen {
+			return v
+		}
+		if packed, ok := pack(v.str); ok {
+			return Value{str: packed, packed: true}
+		}
+		return v
+	}
+}
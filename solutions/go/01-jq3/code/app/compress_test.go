@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressionIsPerCache(t *testing.T) {
+	long := strings.Repeat("ab", 500)
+	newCache := func(compressMin int) *LRUCache[string, Value] {
+		c := newByteBoundedCache(300, newLRUPolicy[string, Value](), Value.Size)
+		c.savings = Value.saved
+		if compressMin > 0 {
+			c.SetCompression(compressFrom(compressMin))
+		}
+		return c
+	}
+	plain, packed := newCache(0), newCache(100)
+
+	if err := plain.Put("a", StringValue(long)); err != ErrTooLarge {
+		t.Errorf("Put of %d bytes into a 300-byte cache without compression = %v", len(long), err)
+	}
+	if err := packed.Put("a", StringValue(long)); err != nil {
+		t.Fatalf("Put into the compressing cache: %v", err)
+	}
+	// The key is charged too.
+	st := packed.Stats()
+	if st.UsedBytes >= 100 || st.UsedBytes+st.SavedBytes != len("a")+len(long) {
+		t.Errorf("used_bytes=%d saved_bytes=%d for %d bytes", st.UsedBytes, st.SavedBytes, len("a")+len(long))
+	}
+	if v, ok := packed.Get("a"); !ok || v.String() != long || v.Len() != len(long) {
+		t.Errorf("Get(a) = %d bytes, %v", v.Len(), ok)
+	}
+	// A plain value compares equal to the stored compressed one.
+	if ok, err := packed.PutIfEquals("a", StringValue(long), StringValue("x")); !ok || err != nil {
+		t.Errorf("PutIfEquals against the value as given = %v, %v", ok, err)
+	}
+	// Short values are stored as they are.
+	if st := packed.Stats(); st.SavedBytes != 0 || st.UsedBytes != len("ax") {
+		t.Errorf("after storing x: used_bytes=%d saved_bytes=%d", st.UsedBytes, st.SavedBytes)
+	}
+}
+
+func TestCompressMinSetsEverySessionCache(t *testing.T) {
+	long := strings.Repeat("ab", 500)
+	s := newTestSession(t)
+	s.compressMin = 100
+	script(t, s,
+		"INIT BYTES 300", "OK",
+		"PUT a "+long, "OK",
+		"MPUT b "+long+" c "+long, "OK 2",
+		"GET b", long,
+		"PUTIF c "+long+" x", "1",
+		"INIT 3 LRU other", "OK",
+		"PUT d "+long, "OK",
+	)
+	for name, c := range s.caches {
+		if st := c.Stats(); st.SavedBytes == 0 {
+			t.Errorf("cache %s saved nothing", name)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLoaderPanicked is returned by GetOrCompute to the callers that were
+// waiting for a loader that panicked.
+var ErrLoaderPanicked = errors.New("loader panicked")
+
+// call is a GetOrCompute load in progress. Callers that find one wait for
+// done to close and then share its result.
+type call[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// GetOrPut returns the cached value for key if there is one. Otherwise it
+// stores value and returns it. hit reports which of the two happened.
+func (c *LRUCache[K, V]) GetOrPut(key K, value V) (actual V, hit bool, err error) {
+	key = c.normalize(key)
+	c.lock()
+	if v, ok := c.get(key); ok {
+		c.unlock()
+		return v, true, nil
+	}
+	err = c.put(key, value, 0, c.defaultCost(key, value))
+	c.unlock()
+	return value, false, err
+}
+
+// GetOrCompute returns the cached value for key, calling fn to produce and
+// cache it on a miss. If fn fails its error is returned and nothing is
+// cached. Concurrent calls for the same key while fn is running wait for
+// that call and share its result instead of calling fn again.
+//
+// fn is called holding the key's stripe, as LockKey takes it, so it never
+// runs while a caller of LockKey holds the key, and a value that caller
+// stored is returned without calling fn at all. fn must therefore not call
+// LockKey or GetOrCompute itself. If fn panics, nothing is cached, the
+// callers waiting for it get ErrLoaderPanicked and the panic carries on in
+// the caller that called fn.
+func (c *LRUCache[K, V]) GetOrCompute(key K, fn func() (V, error)) (V, error) {
+	key = c.normalize(key)
+	c.lock()
+	if v, ok := c.get(key); ok {
+		c.unlock()
+		return v, nil
+	}
+	cl, leader := c.join(key)
+	c.unlock()
+	if !leader {
+		<-cl.done
+		return cl.value, cl.err
+	}
+	c.loadCall(key, cl, fn)
+	return cl.value, cl.err
+}
+
+// loadCall calls fn for the call loading key, holding the key's stripe, and
+// finishes the call, unless the key was stored while it waited for the
+// stripe, in which case it shares that value instead. A panic in fn
+// finishes the call with ErrLoaderPanicked before it goes on up the stack,
+// so that the key can be loaded again.
+func (c *LRUCache[K, V]) loadCall(key K, cl *call[V], fn func() (V, error)) {
+	unlock := c.lockKey(key)
+	defer unlock()
+	c.lock()
+	if node, ok := c.lookup(key); ok {
+		cl.value = node.value
+		delete(c.calls, key)
+		c.unlock()
+		close(cl.done)
+		return
+	}
+	c.unlock()
+	defer func() {
+		if p := recover(); p != nil {
+			var zero V
+			cl.value, cl.err = zero, fmt.Errorf("%w: %v", ErrLoaderPanicked, p)
+			c.finish(key, cl)
+			panic(p)
+		}
+	}()
+	cl.value, cl.err = fn()
+	c.finish(key, cl)
+}
+
+// join returns the call loading key, starting one if there is none, and
+// reports whether it started it, in which case the caller must load the
+// value and finish the call. The caller holds the write lock.
+func (c *LRUCache[K, V]) join(key K) (*call[V], bool) {
+	if cl, ok := c.calls[key]; ok {
+		return cl, false
+	}
+	cl := &call[V]{done: make(chan struct{})}
+	if c.calls == nil {
+		c.calls = make(map[K]*call[V])
+	}
+	c.calls[key] = cl
+	return cl, true
+}
+
+// finish caches the value a call loaded, unless loading it failed, and
+// hands the result to the callers waiting for it.
+func (c *LRUCache[K, V]) finish(key K, cl *call[V]) {
+	c.lock()
+	delete(c.calls, key)
+	if cl.err == nil {
+		cl.err = c.put(key, cl.value, 0, c.defaultCost(key, cl.value))
+	}
+	c.unlock()
+	close(cl.done)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrPut(t *testing.T) {
+	c := NewLRUCache[string, string](2)
+	if v, hit, err := c.GetOrPut("a", "1"); v != "1" || hit || err != nil {
+		t.Errorf("first GetOrPut = %q, %v, %v", v, hit, err)
+	}
+	if v, hit, err := c.GetOrPut("a", "2"); v != "1" || !hit || err != nil {
+		t.Errorf("second GetOrPut = %q, %v, %v", v, hit, err)
+	}
+}
+
+func TestGetOrPutCommand(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 2", "OK",
+		"GETORPUT a 1", "STORED 1",
+		"GETORPUT a 2", "HIT 1",
+		"GET a", "1",
+	)
+}
+
+func TestGetOrComputeCallsOnce(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func() (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	results := make(chan int, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrCompute("k", fn)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- v
+		}()
+	}
+	// Let every caller find the load in flight before it finishes.
+	waitFor(t, func() bool {
+		c.lock()
+		defer c.unlock()
+		return calls.Load() == 1 && c.calls["k"] != nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+	for v := range results {
+		if v != 42 {
+			t.Errorf("a caller got %d", v)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fn called %d times, want 1", n)
+	}
+	if v, ok := c.Get("k"); !ok || v != 42 {
+		t.Errorf("Get(k) = %d, %v", v, ok)
+	}
+}
+
+func TestGetOrComputeErrorCachesNothing(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	boom := errors.New("boom")
+	if _, err := c.GetOrCompute("k", func() (int, error) { return 1, boom }); err != boom {
+		t.Errorf("err = %v, want boom", err)
+	}
+	if c.Contains("k") {
+		t.Error("a failed load was cached")
+	}
+	v, err := c.GetOrCompute("k", func() (int, error) { return 2, nil })
+	if v != 2 || err != nil {
+		t.Errorf("retry = %d, %v", v, err)
+	}
+	v, _ = c.GetOrCompute("k", func() (int, error) { return 3, nil })
+	if v != 2 {
+		t.Errorf("a hit called fn: got %d", v)
+	}
+}
+
+func TestGetOrComputePanicFreesKey(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	panicked := make(chan any)
+	go func() {
+		defer func() { panicked <- recover() }()
+		c.GetOrCompute("k", func() (int, error) {
+			close(started)
+			<-release
+			panic("loader bug")
+		})
+	}()
+	<-started
+	waiter := make(chan error)
+	go func() {
+		_, err := c.GetOrCompute("k", func() (int, error) { return 0, nil })
+		waiter <- err
+	}()
+	// Give the waiter time to join the load.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	if p := <-panicked; p != "loader bug" {
+		t.Errorf("the caller that loaded recovered %v, want the loader's panic", p)
+	}
+	select {
+	case err := <-waiter:
+		// The waiter either joined the failed load or loaded afresh.
+		if err != nil && !errors.Is(err, ErrLoaderPanicked) {
+			t.Errorf("waiter err = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a caller waiting for the panicked load never returned")
+	}
+	v, err := c.GetOrCompute("k", func() (int, error) { return 7, nil })
+	if err != nil || (v != 7 && v != 0) {
+		t.Errorf("GetOrCompute after the panic = %d, %v", v, err)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds.
+func waitFor(t testing.TB, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configEnvPrefix starts the name of each environment variable that sets
+// an option: LRUCACHE_SWEEP_INTERVAL sets --sweep-interval.
+const configEnvPrefix = "LRUCACHE_"
+
+// A Config gathers the options set outside the command line, from the
+// --config file and the environment, and applies them to the flags. Any
+// flag can be set either way except --config itself. An option given in
+// more than one place takes its value from the first of
+//
+//  1. the command line,
+//  2. the environment,
+//  3. the config file,
+//  4. the flag's default,
+//
+// so an environment variable can override a shared file for one run, and a
+// flag overrides both.
+//
+// The file holds one name=value pair a line, the name as the flag's without
+// the dashes and the value quoted as a Go string if it needs to be, with
+// blank lines and lines starting with # ignored:
+//
+//	# A server for the staging cluster.
+//	listen = :6380
+//	capacity = 100000
+//	sweep-interval = 5s
+//
+// or, if it starts with "{", a JSON object of the same names, with string,
+// number or boolean values.
+type Config struct {
+	flags *flag.FlagSet
+	// set holds the values from the file and the environment, by flag
+	// name, once the environment's have replaced the file's.
+	set map[string]configValue
+	// flagged names the flags given on the command line.
+	flagged map[string]bool
+	// unknown lists the names the file or the environment gave that no
+	// flag has, for Validate to report.
+	unknown []configValue
+}
+
+// A configValue is a value for an option and where it was given.
+type configValue struct {
+	name, value string
+	// source is the file and line, or the environment variable.
+	source string
+}
+
+// LoadConfig reads the options in the config file at path, if path is not
+// "", and in environ, for the flags of fs, which must have been parsed.
+// It only reports a file that cannot be read or parsed; Validate checks
+// the values.
+func LoadConfig(fs *flag.FlagSet, path string, environ []string) (*Config, error) {
+	c := &Config{flags: fs, set: make(map[string]configValue), flagged: make(map[string]bool)}
+	fs.Visit(func(f *flag.Flag) { c.flagged[f.Name] = true })
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		values, err := parseConfigFile(path, data)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			c.add(v)
+		}
+	}
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		if rest, ok := strings.CutPrefix(key, configEnvPrefix); ok && rest != "" {
+			name := strings.ReplaceAll(strings.ToLower(rest), "_", "-")
+			c.add(configValue{name: name, value: value, source: "env " + key})
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) add(v configValue) {
+	if c.flags.Lookup(v.name) == nil || v.name == "config" {
+		c.unknown = append(c.unknown, v)
+		return
+	}
+	c.set[v.name] = v
+}
+
+// parseConfigFile parses the name=value lines, or the JSON object, of the
+// config file at path.
+func parseConfigFile(path string, data []byte) ([]configValue, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		var object map[string]any
+		if err := dec.Decode(&object); err != nil {
+			return nil, fmt.Errorf("config: %s: %v", path, err)
+		}
+		var values []configValue
+		for _, name := range slices.Sorted(maps.Keys(object)) {
+			var value string
+			switch v := object[name].(type) {
+			case string:
+				value = v
+			case json.Number, bool:
+				value = fmt.Sprint(v)
+			default:
+				return nil, fmt.Errorf("config: %s: %s must be a string, number or boolean", path, name)
+			}
+			values = append(values, configValue{name: name, value: value, source: path})
+		}
+		return values, nil
+	}
+
+	var values []configValue
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("config: %s:%d: want name=value, got %q", path, n, line)
+		}
+		name = strings.TrimLeft(strings.TrimSpace(name), "-")
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("config: %s:%d: badly quoted value %s", path, n, value)
+			}
+			value = unquoted
+		}
+		values = append(values, configValue{name: name, value: value, source: fmt.Sprintf("%s:%d", path, n)})
+	}
+	return values, scanner.Err()
+}
+
+// Validate checks every option the file and the environment set, and
+// returns an error listing each unknown name and each value its flag would
+// not accept, saying what it should look like.
+func (c *Config) Validate() error {
+	var errs []error
+	for _, v := range c.unknown {
+		errs = append(errs, fmt.Errorf("%s: unknown option %q", v.source, v.name))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.set)) {
+		if c.flagged[name] {
+			continue
+		}
+		v := c.set[name]
+		if want := checkConfigValue(c.flags.Lookup(name), v.value); want != "" {
+			errs = append(errs, fmt.Errorf("%s: %s must be %s, not %q", v.source, name, want, v.value))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkConfigValue describes the values f takes if value is not one of
+// them, or returns "".
+func checkConfigValue(f *flag.Flag, value string) string {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return ""
+	}
+	switch getter.Get().(type) {
+	case bool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "true or false"
+		}
+	case int:
+		if _, err := strconv.ParseInt(value, 0, strconv.IntSize); err != nil {
+			return "a whole number like 100"
+		}
+	case float64:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "a number like 1.5"
+		}
+	case time.Duration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return "a duration like 5s"
+		}
+	}
+	return ""
+}
+
+// Apply sets each flag not given on the command line to the value from
+// the environment or the file, if either gave one. Validate should be
+// called first.
+func (c *Config) Apply() error {
+	for _, name := range slices.Sorted(maps.Keys(c.set)) {
+		if c.flagged[name] {
+			continue
+		}
+		v := c.set[name]
+		if err := c.flags.Set(name, v.value); err != nil {
+			return fmt.Errorf("%s: %s: %v", v.source, name, err)
+		}
+	}
+	return nil
+}
+
+// Get returns the effective value of the option name and where it came
+// from: "flag", "env <variable>", the file and line or "default". It
+// reports false if there is no such option.
+func (c *Config) Get(name string) (value, source string, ok bool) {
+	f := c.flags.Lookup(name)
+	if f == nil {
+		return "", "", false
+	}
+	value, source = f.Value.String(), "default"
+	if c.flagged[name] {
+		source = "flag"
+	} else if v, ok := c.set[name]; ok {
+		source = v.source
+	}
+	if name == "password" && value != "" {
+		value = "(hidden)"
+	}
+	return value, source, true
+}
+
+// cmdConfig reports the effective value of a startup option and where it
+// was set.
+func (s *session) cmdConfig(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[1] != "GET" || len(parts) != 3 {
+		out.Error(CodeArity, "CONFIG takes GET <name>")
+		return
+	}
+	name := strings.TrimLeft(parts[2], "-")
+	value, source, ok := s.config.Get(name)
+	if !ok {
+		out.Errorf(CodeInvalid, "Unknown option: %s", parts[2])
+		return
+	}
+	out.Result(fmt.Sprintf("%s=%s source=%s", name, quoteToken(value), quoteToken(source)),
+		map[string]any{"name": name, "value": value, "source": source})
+}
@@ -0,0 +1,262 @@
+// Package conformance checks an implementation of the cache's line
+// protocol, in any language, against a catalogue of scripted scenarios. It
+// runs the implementation once per scenario, writes the scenario's commands
+// to its stdin, closes it and compares what the implementation wrote to
+// stdout with what the scenario expects, line by line.
+//
+// Scenarios are the files in scenarios/, embedded in the binary, so adding
+// one takes no code. A scenario file is a list of commands, each on a line
+// starting with "> ", every one followed by the lines the command should
+// print:
+//
+//	# LRU evicts the least recently used key.
+//	> INIT 1
+//	OK
+//	> PUT a 1
+//	OK
+//
+// The comment lines at the top describe the scenario; other lines starting
+// with "#" and blank lines are ignored. A line "@flags <flags...>" before
+// the first command gives the command-line flags to run the implementation
+// with. An expected line ending in "*" matches any line starting with the
+// rest of it, for output such as error messages whose wording is up to the
+// implementation. "{repeat:<n>:<text>}", in a command or an expected line,
+// stands for text repeated n times, for large values.
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is how long Run gives each scenario before it kills the
+// implementation and fails the scenario.
+const DefaultTimeout = 10 * time.Second
+
+//go:embed scenarios/*.txt
+var files embed.FS
+
+// A Scenario is one scripted session with an implementation.
+type Scenario struct {
+	Name        string   // the file name without .txt
+	Description string   // the comment at the top of the file
+	Flags       []string // passed to the implementation
+	steps       []step
+}
+
+// A step is one command and the output it should produce.
+type step struct {
+	line    int // in the scenario file
+	command string
+	want    []string
+}
+
+// A Result is the outcome of running one scenario.
+type Result struct {
+	Scenario *Scenario
+	Passed   bool
+	// Failure describes the first mismatching line, or why the scenario
+	// could not be run, if it did not pass.
+	Failure string
+	Elapsed time.Duration
+}
+
+// Scenarios returns the embedded scenarios in name order.
+func Scenarios() ([]*Scenario, error) {
+	names, err := fs.Glob(files, "scenarios/*.txt")
+	if err != nil {
+		return nil, err
+	}
+	scenarios := make([]*Scenario, 0, len(names))
+	for _, name := range names {
+		data, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		sc, err := Parse(strings.TrimSuffix(path.Base(name), ".txt"), data)
+		if err != nil {
+			return nil, err
+		}
+		scenarios = append(scenarios, sc)
+	}
+	return scenarios, nil
+}
+
+var repeatPattern = regexp.MustCompile(`\{repeat:(\d+):([^}]*)\}`)
+
+// expand replaces each {repeat:n:text} in s with text repeated n times.
+func expand(s string) string {
+	return repeatPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := repeatPattern.FindStringSubmatch(m)
+		n, _ := strconv.Atoi(sub[1])
+		return strings.Repeat(sub[2], n)
+	})
+}
+
+// Parse reads a scenario in the format described in the package comment.
+func Parse(name string, data []byte) (*Scenario, error) {
+	sc := &Scenario{Name: name}
+	var description []string
+	header := true
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "#"):
+			if header {
+				description = append(description, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+			}
+			continue
+		case strings.TrimSpace(line) == "":
+			header = header && len(description) == 0
+			continue
+		}
+		header = false
+		switch {
+		case strings.HasPrefix(line, "@flags"):
+			if len(sc.steps) > 0 {
+				return nil, fmt.Errorf("%s:%d: @flags after the first command", name, n)
+			}
+			sc.Flags = append(sc.Flags, strings.Fields(strings.TrimPrefix(line, "@flags"))...)
+		case strings.HasPrefix(line, "> "):
+			sc.steps = append(sc.steps, step{line: n, command: expand(strings.TrimPrefix(line, "> "))})
+		case len(sc.steps) == 0:
+			return nil, fmt.Errorf("%s:%d: expected output before the first command", name, n)
+		default:
+			last := &sc.steps[len(sc.steps)-1]
+			last.want = append(last.want, expand(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(sc.steps) == 0 {
+		return nil, fmt.Errorf("%s: no commands", name)
+	}
+	sc.Description = strings.Join(description, " ")
+	return sc, nil
+}
+
+// Commands returns the scenario's commands in order, as they are written
+// to the implementation.
+func (sc *Scenario) Commands() []string {
+	commands := make([]string, len(sc.steps))
+	for i, st := range sc.steps {
+		commands[i] = st.command
+	}
+	return commands
+}
+
+// match reports whether got is the line want expects.
+func match(want, got string) bool {
+	if prefix, ok := strings.CutSuffix(want, "*"); ok {
+		return strings.HasPrefix(got, prefix)
+	}
+	return got == want
+}
+
+// Run runs the scenario against the implementation at binary, killing it
+// if it has not exited within timeout.
+func (sc *Scenario) Run(binary string, timeout time.Duration) (result Result) {
+	start := time.Now()
+	result.Scenario = sc
+	defer func() { result.Elapsed = time.Since(start) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var input strings.Builder
+	for _, st := range sc.steps {
+		input.WriteString(st.command + "\n")
+	}
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, sc.Flags...)
+	cmd.Stdin = strings.NewReader(input.String())
+	cmd.Stdout = &stdout
+	// A child the implementation started may hold stdout open after
+	// it is killed; don't wait on it for long.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		result.Failure = fmt.Sprintf("timed out after %v", timeout)
+		return result
+	}
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		result.Failure = err.Error()
+		return result
+	}
+
+	got := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if stdout.Len() == 0 {
+		got = nil
+	}
+	i := 0
+	for _, st := range sc.steps {
+		for _, want := range st.want {
+			if i == len(got) {
+				result.Failure = fmt.Sprintf("line %d: after %s: want %q, got end of output", st.line, abbreviate(st.command), abbreviate(want))
+				return result
+			}
+			if line := strings.TrimSuffix(got[i], "\r"); !match(want, line) {
+				result.Failure = fmt.Sprintf("line %d: after %s: want %q, got %q", st.line, abbreviate(st.command), abbreviate(want), abbreviate(line))
+				return result
+			}
+			i++
+		}
+	}
+	if i < len(got) {
+		result.Failure = fmt.Sprintf("unexpected output after the last command: %q", abbreviate(got[i]))
+		return result
+	}
+	if exit != nil {
+		result.Failure = fmt.Sprintf("output matched but the implementation %v", exit)
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// abbreviate shortens the long lines of large value scenarios for the
+// report.
+func abbreviate(s string) string {
+	const limit = 80
+	if len(s) <= limit {
+		return s
+	}
+	return fmt.Sprintf("%s... (%d bytes)", s[:limit], len(s))
+}
+
+// Run runs every scenario against the implementation at binary, giving
+// each timeout, and writes a line per scenario to w, followed by a
+// summary. It returns the number of scenarios that failed.
+func Run(w io.Writer, binary string, timeout time.Duration) (int, error) {
+	scenarios, err := Scenarios()
+	if err != nil {
+		return 0, err
+	}
+	failed := 0
+	for _, sc := range scenarios {
+		r := sc.Run(binary, timeout)
+		if r.Passed {
+			fmt.Fprintf(w, "PASS %s (%.2fs)\n", sc.Name, r.Elapsed.Seconds())
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL %s (%.2fs): %s\n", sc.Name, r.Elapsed.Seconds(), sc.Description)
+		fmt.Fprintf(w, "     %s\n", r.Failure)
+	}
+	fmt.Fprintf(w, "%d of %d scenarios passed\n", len(scenarios)-failed, len(scenarios))
+	return failed, nil
+}
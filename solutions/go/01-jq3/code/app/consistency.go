@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A client log is the transcript of one connection that turned SEQ on, in
+// text mode: each command on a line starting with "> ", followed by the
+// lines of its response, as conformance scenarios are written. The
+// consistency checker replays the logs of clients that ran at the same
+// time against one cache and reports every read that went back in time:
+// a GETSEQ that returned a value of a key older than one the same client
+// had already seen for it, whether by reading it or by writing it with
+// PUT or DELETE. It also reports a GETSEQ that returned a value other
+// than the one the PUT of that number, in any of the logs, wrote. Other
+// lines are skipped. An admission filter can turn down a PUT that still
+// reports OK, so the checker is only sound for caches without one.
+
+// A loggedWrite is a PUT or DELETE that a client log shows succeeding.
+type loggedWrite struct {
+	key     string
+	value   string
+	deleted bool
+	at      string // the file and line, for reports
+}
+
+// A loggedRead is a GETSEQ that a client log shows finding a value.
+type loggedRead struct {
+	key   string
+	value string
+	seq   uint64
+	at    string
+}
+
+// A clientStep is a write or a read from a client log, in the order the
+// client made them.
+type clientStep struct {
+	seq   uint64 // where write is set
+	write *loggedWrite
+	read  *loggedRead
+}
+
+// checkConsistency checks the client logs at paths, writes a line to w for
+// each violation and a summary, and returns the number of violations.
+func checkConsistency(w io.Writer, paths []string) (int, error) {
+	logs := make([][]clientStep, len(paths))
+	writes := make(map[uint64]*loggedWrite)
+	for i, path := range paths {
+		steps, err := readClientLog(path)
+		if err != nil {
+			return 0, err
+		}
+		logs[i] = steps
+		for _, step := range steps {
+			if step.write == nil {
+				continue
+			}
+			if other, ok := writes[step.seq]; ok {
+				return 0, fmt.Errorf("%s: write %d already made at %s", step.write.at, step.seq, other.at)
+			}
+			writes[step.seq] = step.write
+		}
+	}
+
+	violations, reads := 0, 0
+	report := func(r *loggedRead, format string, args ...any) {
+		violations++
+		fmt.Fprintf(w, "%s: GETSEQ %s: %s\n", r.at, r.key, fmt.Sprintf(format, args...))
+	}
+	for _, steps := range logs {
+		// seen holds the newest write of each key the client knows of.
+		seen := make(map[string]uint64)
+		for _, step := range steps {
+			if step.write != nil {
+				seen[step.write.key] = max(seen[step.write.key], step.seq)
+				continue
+			}
+			r := step.read
+			reads++
+			if last := seen[r.key]; r.seq < last {
+				report(r, "read write %d after seeing write %d", r.seq, last)
+			}
+			seen[r.key] = max(seen[r.key], r.seq)
+			switch wr, ok := writes[r.seq]; {
+			case !ok:
+			case wr.key != r.key:
+				report(r, "read write %d, which was to %s", r.seq, wr.key)
+			case wr.deleted:
+				report(r, "read write %d, which deleted it", r.seq)
+			case wr.value != r.value:
+				report(r, "read %s as write %d, which wrote %s", quoteToken(r.value), r.seq, quoteToken(wr.value))
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d reads in %d logs checked, %d violations\n", reads, len(paths), violations)
+	return violations, nil
+}
+
+// readClientLog returns the writes and reads in the client log at path.
+func readClientLog(path string) ([]clientStep, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var steps []clientStep
+	var parts []string // the command awaiting its response, if any
+	var at string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxTraceLine)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if cmd, ok := strings.CutPrefix(line, "> "); ok {
+			parts, at = nil, fmt.Sprintf("%s:%d", path, n)
+			if tokens, err := tokenize(cmd); err == nil && len(tokens) > 0 {
+				normalizeCommand(tokens)
+				parts = tokens
+			}
+			continue
+		}
+		if parts == nil {
+			continue
+		}
+		if step, ok := parseClientStep(parts, line, at); ok {
+			steps = append(steps, step)
+		}
+		parts = nil
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return steps, nil
+}
+
+// parseClientStep returns the step that the command parts and the first
+// line of its response, resp, record, if they record one.
+func parseClientStep(parts []string, resp, at string) (clientStep, bool) {
+	switch {
+	case parts[0] == "PUT" && len(parts) >= 3:
+		if seq, ok := cutSeq(resp, "OK"); ok {
+			return clientStep{seq: seq, write: &loggedWrite{key: parts[1], value: parts[2], at: at}}, true
+		}
+	case parts[0] == "DELETE" && len(parts) == 2:
+		if seq, ok := cutSeq(resp, "OK"); ok {
+			return clientStep{seq: seq, write: &loggedWrite{key: parts[1], deleted: true, at: at}}, true
+		}
+	case parts[0] == "GETSEQ" && len(parts) == 2:
+		num, value, ok := strings.Cut(resp, " ")
+		seq, err := strconv.ParseUint(num, 10, 64)
+		if ok && err == nil {
+			return clientStep{read: &loggedRead{key: parts[1], value: value, seq: seq, at: at}}, true
+		}
+	}
+	return clientStep{}, false
+}
+
+// cutSeq parses resp as status followed by " seq=<n>", as SEQ ON writes a
+// successful write's response.
+func cutSeq(resp, status string) (uint64, bool) {
+	num, ok := strings.CutPrefix(resp, status+" seq=")
+	if !ok {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(num, 10, 64)
+	return seq, err == nil
+}
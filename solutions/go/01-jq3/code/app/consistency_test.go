@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeLogs writes each client log to a file of its own and returns their
+// paths.
+func writeLogs(t *testing.T, logs ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, len(logs))
+	for i, log := range logs {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("client%d.log", i))
+		if err := os.WriteFile(paths[i], []byte(log), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestConsistencyOfConcurrentClients(t *testing.T) {
+	s := newServerSession(t, 100)
+	addr := startTCP(t, s)
+	logs := make([]string, 4)
+	var wg sync.WaitGroup
+	for i := range logs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := dial(t, addr)
+			var log strings.Builder
+			do := func(line string) {
+				log.WriteString("> " + line + "\n" + c.do(line) + "\n")
+			}
+			do("SEQ ON")
+			for n := range 200 {
+				key := fmt.Sprint("k", n%5)
+				switch n % 4 {
+				case 0:
+					do(fmt.Sprintf("PUT %s c%d-%d", key, i, n))
+				case 3:
+					if i == 0 {
+						do("DELETE " + key)
+						continue
+					}
+					fallthrough
+				default:
+					do("GETSEQ " + key)
+				}
+			}
+			logs[i] = log.String()
+		}()
+	}
+	wg.Wait()
+
+	var report strings.Builder
+	violations, err := checkConsistency(&report, writeLogs(t, logs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if violations != 0 || strings.HasPrefix(report.String(), "0 reads") || !strings.HasSuffix(report.String(), " in 4 logs checked, 0 violations\n") {
+		t.Errorf("%d violations:\n%s", violations, report.String())
+	}
+}
+
+func TestConsistencyCheckerFindsViolations(t *testing.T) {
+	writer := "> SEQ ON\nOK\n" +
+		"> PUT a 1\nOK seq=1\n" +
+		"> PUT a 2\nOK seq=2\n" +
+		"> PUT b x\nOK seq=3\n" +
+		"> DELETE b\nOK seq=4\n"
+	for _, tc := range []struct {
+		name, reader string
+		want         []string
+	}{
+		{"consistent", "> GETSEQ a\n1 1\n> GETSEQ a\n2 2\n> GETSEQ b\n3 x\n", nil},
+		{"back in time", "> GETSEQ a\n2 2\n> GETSEQ a\n1 1\n",
+			[]string{"client1.log:3: GETSEQ a: read write 1 after seeing write 2"}},
+		{"older than own write", "> PUT c 9\nOK seq=5\n> GETSEQ a\n2 2\n> PUT a 3\nOK seq=6\n> GETSEQ a\n2 2\n",
+			[]string{"client1.log:7: GETSEQ a: read write 2 after seeing write 6"}},
+		{"wrong value", "> GETSEQ a\n2 7\n",
+			[]string{"client1.log:1: GETSEQ a: read 7 as write 2, which wrote 2"}},
+		{"other key's write", "> GETSEQ a\n3 x\n",
+			[]string{"client1.log:1: GETSEQ a: read write 3, which was to b"}},
+		{"deleted value", "> GETSEQ b\n4 x\n",
+			[]string{"client1.log:1: GETSEQ b: read write 4, which deleted it"}},
+		{"misses and failures skipped", "> GETSEQ z\nNULL\n> PUT q\nERROR ERR_ARITY PUT requires key and value arguments\n> GET a\n2\n", nil},
+	} {
+		paths := writeLogs(t, writer, tc.reader)
+		var report strings.Builder
+		violations, err := checkConsistency(&report, paths)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		lines := strings.Split(strings.TrimSuffix(report.String(), "\n"), "\n")
+		got := lines[:len(lines)-1]
+		for i, line := range got {
+			got[i] = strings.TrimPrefix(line, filepath.Dir(paths[0])+string(filepath.Separator))
+		}
+		if violations != len(tc.want) || strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Errorf("%s: %d violations:\n%s\nwant:\n%s", tc.name, violations, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+func TestConsistencyCheckerRejectsDuplicateWrites(t *testing.T) {
+	log := "> PUT a 1\nOK seq=1\n"
+	if _, err := checkConsistency(&strings.Builder{}, writeLogs(t, log, log)); err == nil || !strings.Contains(err.Error(), "write 1 already made at") {
+		t.Errorf("two logs claiming write 1 = %v", err)
+	}
+	if _, err := checkConsistency(&strings.Builder{}, []string{filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("a missing log was accepted")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// The Ctx variants of the methods that can block on a slow backing store
+// or loader return ctx.Err() as soon as ctx is done. Waiting for the lock
+// is given up cleanly: if ctx is done first, nothing has been read or
+// written. Once the lock is held, a call to the backing store or a loader
+// is not interrupted, as neither takes a context; the caller stops waiting
+// for it instead, and the operation finishes in the background exactly as
+// it would have, so the cache stays consistent and the next caller sees
+// its outcome. A put abandoned that way may therefore still take effect.
+//
+// Writers queue for the lock on a channel semaphore, writer, which they
+// hold along with the write half of the sync.RWMutex, so a wait can be
+// abandoned with a select and leaves nothing behind. Once a writer holds
+// the semaphore it only waits for the readers in the mutex to leave, and
+// readers hold it only to look at memory. Reads stay on the RWMutex, so
+// Get pays nothing for this.
+
+// lockCtx takes the write lock as lock does, unless ctx is done first.
+func (c *LRUCache[K, V]) lockCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case c.writer <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	c.mu.Lock()
+	c.locked()
+	return nil
+}
+
+// await runs fn, which holds the write lock and releases it when done, in
+// the background and waits for its result or for ctx to be done.
+func await[T any](ctx context.Context, fn func() T) (T, error) {
+	done := make(chan T, 1)
+	go func() { done <- fn() }()
+	select {
+	case result := <-done:
+		return result, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// GetCtx is GetThrough that gives up when ctx is done.
+func (c *LRUCache[K, V]) GetCtx(ctx context.Context, key K) (V, bool, error) {
+	value, _, ok, err := c.GetTaggedCtx(ctx, key)
+	return value, ok, err
+}
+
+// GetTaggedCtx is GetCtx that also returns the value's ETag; see SetETags.
+func (c *LRUCache[K, V]) GetTaggedCtx(ctx context.Context, key K) (V, uint64, bool, error) {
+	key = c.normalize(key)
+	if l := c.latency.Load(); l != nil && l.get.sample(l.every) {
+		defer l.get.since(time.Now())
+	}
+	var zero V
+	if err := c.lockCtx(ctx); err != nil {
+		return zero, 0, false, err
+	}
+	if value, ok := c.get(key); ok || c.backing == nil {
+		etag := c.etagOf(key, value)
+		c.unlock()
+		return value, etag, ok, nil
+	}
+	type loaded struct {
+		value V
+		etag  uint64
+		ok    bool
+		err   error
+	}
+	r, err := await(ctx, func() loaded {
+		defer c.unlock()
+		value, ok, err := c.load(key)
+		if ok && !c.readOnly {
+			c.write(key, value, 0, c.defaultCost(key, value), false)
+		}
+		return loaded{value, c.etagOf(key, value), ok, err}
+	})
+	if err != nil {
+		return zero, 0, false, err
+	}
+	return r.value, r.etag, r.ok, r.err
+}
+
+// PutCtx is PutWithGrace that gives up when ctx is done; ttl and grace are
+// 0 for none.
+func (c *LRUCache[K, V]) PutCtx(ctx context.Context, key K, value V, ttl, grace time.Duration) error {
+	key = c.normalize(key)
+	if l := c.latency.Load(); l != nil && l.put.sample(l.every) {
+		defer l.put.since(time.Now())
+	}
+	if err := c.lockCtx(ctx); err != nil {
+		return err
+	}
+	put := func() error {
+		defer c.unlock()
+		if err := c.put(key, value, ttl, c.defaultCost(key, value)); err != nil {
+			return err
+		}
+		if node, ok := c.cache[key]; ok && ttl > 0 {
+			node.grace = max(grace, 0)
+		}
+		return nil
+	}
+	// Only a write through to the backing store can take long.
+	if c.backing == nil {
+		return put()
+	}
+	err, ctxErr := await(ctx, put)
+	if ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// GetOrComputeCtx is GetOrCompute that gives up when ctx is done. fn is
+// called with a context that is never cancelled, as other callers may be
+// waiting for its result, and that value is cached even if the caller
+// that started the load has given up.
+func (c *LRUCache[K, V]) GetOrComputeCtx(ctx context.Context, key K, fn func(context.Context) (V, error)) (V, error) {
+	key = c.normalize(key)
+	var zero V
+	if err := c.lockCtx(ctx); err != nil {
+		return zero, err
+	}
+	if v, ok := c.get(key); ok {
+		c.unlock()
+		return v, nil
+	}
+	cl, leader := c.join(key)
+	c.unlock()
+	// The load runs on its own goroutine, where a panic in fn would take
+	// the process down: it is logged and counted instead, and the callers
+	// waiting for it get ErrLoaderPanicked.
+	if leader {
+		go c.protect("compute", func() {
+			c.loadCall(key, cl, func() (V, error) { return fn(context.WithoutCancel(ctx)) })
+		})
+	}
+	select {
+	case <-cl.done:
+		return cl.value, cl.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// commandContext returns the context a command's cache calls run under:
+// one that times out after --command-timeout, if set, derived from parent.
+func (s *session) commandContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.commandTimeout <= 0 {
+		return parent, func() {}
+	}
+	return context.WithTimeout(parent, s.commandTimeout)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingStore is a backing store whose Load and Store wait for release
+// to be closed, announcing each call on entered first.
+type blockingStore struct {
+	entered chan string
+	release chan struct{}
+
+	mu   sync.Mutex
+	data map[string]Value
+}
+
+func newBlockingStore() *blockingStore {
+	return &blockingStore{entered: make(chan string, 16), release: make(chan struct{}), data: map[string]Value{}}
+}
+
+func (b *blockingStore) Load(key string) (Value, bool, error) {
+	b.entered <- key
+	<-b.release
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.data[key]
+	return v, ok, nil
+}
+
+func (b *blockingStore) Store(key string, value Value) error {
+	b.entered <- key
+	<-b.release
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data[key] = value
+	return nil
+}
+
+func (b *blockingStore) Delete(key string) error { return nil }
+
+// returnsWithin fails the test unless fn returns within d, and returns its
+// error.
+func returnsWithin(t *testing.T, d time.Duration, fn func() error) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(d):
+		t.Fatalf("still waiting after %v", d)
+		return nil
+	}
+}
+
+func TestLockCtxGivesUpWithoutLeaking(t *testing.T) {
+	checkGoroutines(t)
+	c := NewLRUCache[string, Value](10)
+	c.lock()
+	before := runtime.NumGoroutine()
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if _, _, err := c.GetCtx(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("GetCtx behind a held lock: %v", err)
+			}
+			if err := c.PutCtx(ctx, "k", StringValue("v"), 0, 0); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("PutCtx behind a held lock: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	// An abandoned wait leaves nothing behind, even with the lock held.
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= before })
+	c.unlock()
+	if !c.mu.TryLock() {
+		t.Fatal("the lock is held after every waiter gave up")
+	}
+	c.mu.Unlock()
+	if err := c.PutCtx(context.Background(), "k", StringValue("v"), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if c.Size() != 1 {
+		t.Errorf("size %d", c.Size())
+	}
+}
+
+func TestLockCtxDoneBeforeCall(t *testing.T) {
+	c := NewLRUCache[string, Value](10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.PutCtx(ctx, "k", StringValue("v"), 0, 0); err != context.Canceled {
+		t.Errorf("PutCtx with a cancelled context: %v", err)
+	}
+	if c.Contains("k") {
+		t.Error("a cancelled PutCtx wrote")
+	}
+}
+
+func TestGetCtxCancelledMidLoad(t *testing.T) {
+	checkGoroutines(t)
+	c := NewLRUCache[string, Value](10)
+	store := newBlockingStore()
+	store.data["k"] = StringValue("stored")
+	c.SetBackingStore(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-store.entered
+		cancel()
+	}()
+	err := returnsWithin(t, time.Second, func() error {
+		_, _, err := c.GetCtx(ctx, "k")
+		return err
+	})
+	if err != context.Canceled {
+		t.Errorf("GetCtx cancelled mid-load: %v", err)
+	}
+
+	// The load finishes in the background and caches what it read.
+	close(store.release)
+	v, ok, err := c.GetCtx(context.Background(), "k")
+	if s, _ := v.Str(); !ok || err != nil || s != "stored" {
+		t.Errorf("GetCtx after the load = %q, %v, %v", s, ok, err)
+	}
+	if st := c.Stats(); st.BackingHits != 1 {
+		t.Errorf("%d backing hits, want 1", st.BackingHits)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutCtxCancelledMidWriteThrough(t *testing.T) {
+	checkGoroutines(t)
+	c := NewLRUCache[string, Value](10)
+	store := newBlockingStore()
+	c.SetBackingStore(store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := returnsWithin(t, time.Second, func() error {
+		return c.PutCtx(ctx, "k", StringValue("v"), 0, 0)
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("PutCtx timed out mid-write: %v", err)
+	}
+	// The abandoned put still holds the lock until the store returns.
+	close(store.release)
+	waitFor(t, func() bool { return c.Contains("k") })
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetOrComputeCtxCancelledMidLoader(t *testing.T) {
+	checkGoroutines(t)
+	c := NewLRUCache[string, int](10)
+	started, release := make(chan struct{}), make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	err := returnsWithin(t, time.Second, func() error {
+		_, err := c.GetOrComputeCtx(ctx, "k", func(ctx context.Context) (int, error) {
+			close(started)
+			<-release
+			// The loader's context outlives the caller's.
+			return 7, ctx.Err()
+		})
+		return err
+	})
+	if err != context.Canceled {
+		t.Errorf("GetOrComputeCtx cancelled mid-loader: %v", err)
+	}
+	close(release)
+	waitFor(t, func() bool { return c.Contains("k") })
+	v, err := c.GetOrComputeCtx(context.Background(), "k", func(context.Context) (int, error) { return 0, errors.New("called") })
+	if v != 7 || err != nil {
+		t.Errorf("GetOrComputeCtx after the load = %d, %v", v, err)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	s := newServerSession(t, 10)
+	s.commandTimeout = 10 * time.Millisecond
+	store := newBlockingStore()
+	s.cache.SetBackingStore(store)
+	defer close(store.release)
+	for _, cmd := range []string{"GET k", "PUT k v"} {
+		got := s.Execute(cmd)
+		if !strings.HasPrefix(got, "ERROR ERR_TIMEOUT") {
+			t.Errorf("%s with a stuck store = %q", cmd, got)
+		}
+	}
+}
+
+func TestSessionCommandsRecordLatency(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 10", "OK",
+		"LATENCY ON 1", "OK",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"GET a", "1",
+		"GET missing", "NULL",
+		"GET b", "2",
+	)
+	lines := strings.Split(s.Execute("LATENCY"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "GET count=3 samples=3 ") || !strings.HasPrefix(lines[1], "PUT count=2 samples=2 ") {
+		t.Errorf("LATENCY = %q", lines)
+	}
+}
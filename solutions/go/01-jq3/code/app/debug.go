@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DebugDump describes the cache's internal state in a fixed format meant
+// to be compared against golden output. The first line is
+//
+//	capacity=<n> policy=<name> size=<n>
+//
+// followed by one line per entry in retention order:
+//
+//	<pos> <key> <value>[ <flags>]
+//
+// where pos counts from 0, key and value are quoted as the command parser
+// expects when they contain spaces, and flags is the policy's per-entry
+// state (freq=<n> for LFU, ref=<0|1> slot=<n> for CLOCK, segment=<name>
+// for SLRU, queue=<name> for 2Q, list=<name> for ARC), or pinned for a
+// pinned entry and prio=low or prio=high for an entry of that priority,
+// which the policy does not track, followed by tenant=<name> for an entry
+// of a tenant. Expired entries that have not been reaped yet are listed
+// like live ones.
+func (c *LRUCache[K, V]) DebugDump() string {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "capacity=%d policy=%s size=%d", c.capacity, c.policy.Name(), len(c.cache))
+	insp, _ := c.policy.(inspector[K, V])
+	pos := 0
+	c.each(func(node *Node[K, V]) bool {
+		fmt.Fprintf(&b, "\n%d %s %s", pos, quoteToken(fmt.Sprint(node.key)), quoteToken(fmt.Sprint(node.value)))
+		switch {
+		case node.pinned:
+			b.WriteString(" pinned")
+		case node.priority != PriorityNormal:
+			b.WriteString(" prio=" + node.priority.String())
+		case node.tenant != "":
+			// The policy does not hold it.
+		case insp != nil:
+			if flags := insp.NodeFlags(node); flags != "" {
+				b.WriteString(" " + flags)
+			}
+		}
+		if node.tenant != "" {
+			b.WriteString(" tenant=" + quoteToken(node.tenant))
+		}
+		pos++
+		return true
+	})
+	return b.String()
+}
+
+// DebugCheck verifies the cache's internal invariants and returns a
+// description of the first violation, or nil if everything is consistent:
+// the policy's own structures, the pinned list and the priority lists are
+// sound, between them they hold each cached node exactly once and no
+// others, none of them is waiting for reuse, the cost totals match the
+// entries, and the key index, if there is one, holds exactly their keys.
+func (c *LRUCache[K, V]) DebugCheck() error {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	if insp, ok := c.policy.(inspector[K, V]); ok {
+		if err := insp.Check(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.pinned.check("pinned"); err != nil {
+		return err
+	}
+	pinnedCost := 0
+	var err error
+	c.pinned.each(func(node *Node[K, V]) bool {
+		if !node.pinned {
+			err = fmt.Errorf("node %v is on the pinned list but not marked pinned", node.key)
+		}
+		pinnedCost += node.cost
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if pinnedCost != c.pinnedCost {
+		return fmt.Errorf("pinned entry costs sum to %d but pinned cost is %d", pinnedCost, c.pinnedCost)
+	}
+	if err := c.checkTenants(); err != nil {
+		return err
+	}
+	if err := c.checkTiers(); err != nil {
+		return err
+	}
+	if err := c.checkSlabs(); err != nil {
+		return err
+	}
+	for _, p := range []Priority{PriorityLow, PriorityHigh} {
+		l := c.tier(p)
+		if err := l.check(p.String() + " priority"); err != nil {
+			return err
+		}
+		l.each(func(node *Node[K, V]) bool {
+			if node.pinned || node.priority != p {
+				err = fmt.Errorf("node %v is on the %s priority list but is pinned or of %s priority", node.key, p, node.priority)
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[*Node[K, V]]bool, len(c.cache))
+	cost := 0
+	c.each(func(node *Node[K, V]) bool {
+		switch {
+		case seen[node]:
+			err = fmt.Errorf("node %v appears twice", node.key)
+		case c.cache[node.key] != node:
+			err = fmt.Errorf("node %v is not the cached entry for its key", node.key)
+		case len(seen) >= len(c.cache):
+			err = fmt.Errorf("policy and pinned list hold more nodes than the %d cached", len(c.cache))
+		}
+		seen[node] = true
+		cost += node.cost
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(seen) != len(c.cache) {
+		return fmt.Errorf("policy and pinned list hold %d nodes but the map has %d", len(seen), len(c.cache))
+	}
+	if cost != c.usedCost {
+		return fmt.Errorf("entry costs sum to %d but used cost is %d", cost, c.usedCost)
+	}
+	if len(c.resident) != len(c.cache) {
+		return fmt.Errorf("resident slice holds %d nodes but the map has %d", len(c.resident), len(c.cache))
+	}
+	for i, node := range c.resident {
+		if node.index != i || c.cache[node.key] != node {
+			return fmt.Errorf("resident node %v at index %d records index %d or is not cached", node.key, i, node.index)
+		}
+	}
+	if err := c.checkKeyIndex(); err != nil {
+		return err
+	}
+	for _, node := range c.free {
+		if seen[node] {
+			return fmt.Errorf("released node %v is still in the cache", node.key)
+		}
+	}
+	return nil
+}
+
+// ObjectInfo is everything DebugObject knows about one entry.
+type ObjectInfo[V any] struct {
+	Value V
+	Cost  int
+	// Tier is 1, or 2 for an entry in the second level of a tiered
+	// cache.
+	Tier int
+	// Segment is what orders the entry for eviction: the policy, or the
+	// pinned, low or high priority or tenant list.
+	Segment string
+	// PolicyState is the policy's per-entry state, as in DebugDump.
+	PolicyState string
+	Hits        int
+	// Frequency estimates how often the key has been read: by the
+	// admission filter's sketch if it is on, or else by the hot key
+	// tracker if it tracks the key. It is -1 if neither knows.
+	Frequency int64
+	Version   uint64
+	TTL       time.Duration // time left before expiry; 0 if it never expires
+	Pinned    bool
+}
+
+// DebugObject returns everything known about the live entry for key,
+// without promoting it or counting a read.
+func (c *LRUCache[K, V]) DebugObject(key K) (ObjectInfo[V], bool) {
+	key = c.normalize(key)
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	var info ObjectInfo[V]
+	node, ok := c.cache[key]
+	info.Tier = 1
+	if !ok && c.l2 != nil {
+		node, ok = c.l2.cache[key]
+		info.Tier = 2
+	}
+	if !ok || node.expired(now) {
+		return ObjectInfo[V]{}, false
+	}
+	info.Value, info.Cost, info.Hits, info.Version, info.Pinned = node.value, node.cost, node.hits, node.version, node.pinned
+	if !node.expireAt.IsZero() {
+		info.TTL = node.expireAt.Sub(now)
+	}
+	owner := c
+	if info.Tier == 2 {
+		owner = c.l2
+	}
+	switch {
+	case node.pinned:
+		info.Segment = "pinned"
+	case node.priority != PriorityNormal:
+		info.Segment = node.priority.String()
+	case node.tenant != "":
+		info.Segment = "tenant"
+	default:
+		info.Segment = "policy"
+		if insp, ok := owner.policy.(inspector[K, V]); ok {
+			info.PolicyState = insp.NodeFlags(node)
+		}
+	}
+	info.Frequency = -1
+	if c.sketch != nil {
+		info.Frequency = int64(c.sketch.Estimate(key))
+	} else if h := c.hotKeys.Load(); h != nil {
+		if n, ok := h.count(key); ok {
+			info.Frequency = n
+		}
+	}
+	return info, true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestDebugDumpGolden runs each testdata/dump/*.in script and compares
+// DEBUG DUMP afterwards with the .golden file next to it. Run with
+// -update to rewrite the golden files after a deliberate format change.
+func TestDebugDumpGolden(t *testing.T) {
+	scripts, err := filepath.Glob("testdata/dump/*.in")
+	if err != nil || len(scripts) == 0 {
+		t.Fatalf("no scripts in testdata/dump: %v", err)
+	}
+	for _, path := range scripts {
+		name := strings.TrimSuffix(filepath.Base(path), ".in")
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			s := newTestSession(t)
+			sc := bufio.NewScanner(f)
+			for sc.Scan() {
+				if got := s.Execute(sc.Text()); strings.HasPrefix(got, "ERROR") {
+					t.Fatalf("%s: %s", sc.Text(), got)
+				}
+			}
+			got := s.Execute("DEBUG DUMP") + "\n"
+			if check := s.Execute("DEBUG CHECK"); check != "OK" {
+				t.Errorf("DEBUG CHECK = %s", check)
+			}
+
+			golden := strings.TrimSuffix(path, ".in") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("DEBUG DUMP:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDebugCheckFindsViolations(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		corrupt func(c *LRUCache[string, int])
+		want    string
+	}{
+		{"broken link", func(c *LRUCache[string, int]) {
+			p := c.policy.(*lruPolicy[string, int])
+			p.list.root.next.next.prev = nil
+		}, "list: broken link after node 1"},
+		{"wrong length", func(c *LRUCache[string, int]) {
+			c.policy.(*lruPolicy[string, int]).list.len++
+		}, "list: 3 nodes but length 4"},
+		{"missing from map", func(c *LRUCache[string, int]) {
+			delete(c.cache, "b")
+		}, "node b is not the cached entry for its key"},
+		{"missing from list", func(c *LRUCache[string, int]) {
+			p := c.policy.(*lruPolicy[string, int])
+			p.list.remove(c.cache["b"])
+		}, "policy and pinned list hold 2 nodes but the map has 3"},
+		{"cost drift", func(c *LRUCache[string, int]) {
+			c.usedCost++
+		}, "entry costs sum to 3 but used cost is 4"},
+	} {
+		c := NewLRUCache[string, int](5)
+		c.Put("a", 1)
+		c.Put("b", 2)
+		c.Put("c", 3)
+		if err := c.DebugCheck(); err != nil {
+			t.Fatalf("%s: sound cache: %v", tc.name, err)
+		}
+		tc.corrupt(c)
+		if err := c.DebugCheck(); err == nil || err.Error() != tc.want {
+			t.Errorf("%s: DebugCheck() = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestDebugCommandsNeedCache(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"DEBUG DUMP", "ERROR ERR_NOT_INITIALIZED Cache not initialized",
+		"DEBUG CHECK", "ERROR ERR_NOT_INITIALIZED Cache not initialized",
+	)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+// evictionHeavyScript returns a script that keeps a cache of 8 entries
+// evicting: writes, reads, batches, deletions and TTLs over 30 keys, with
+// the contents dumped along the way.
+func evictionHeavyScript(policy string) string {
+	rng := rand.New(rand.NewPCG(87, 0))
+	lines := []string{"INIT 8 " + policy}
+	key := func() string { return fmt.Sprintf("k%02d", rng.IntN(30)) }
+	for i := range 400 {
+		switch n := rng.IntN(100); {
+		case n < 40:
+			lines = append(lines, fmt.Sprintf("PUT %s %d", key(), i))
+		case n < 70:
+			lines = append(lines, "GET "+key())
+		case n < 78:
+			lines = append(lines, fmt.Sprintf("MPUT %s a %s b %s c", key(), key(), key()))
+		case n < 84:
+			lines = append(lines, "DELETE "+key())
+		case n < 92:
+			lines = append(lines, fmt.Sprintf("PUT %s %d %d", key(), i, 1+rng.IntN(3)))
+		case n < 96:
+			lines = append(lines, "DEBUG ADVANCECLOCK 1")
+		default:
+			lines = append(lines, "KEYS", "DEBUG DUMP")
+		}
+	}
+	lines = append(lines, "CLEAR", "STATS")
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestEvictionIsDeterministic(t *testing.T) {
+	for _, policy := range []string{
+		"LRU", "LRU MIDPOINT", "FIFO", "LFU", "LFU DECAY 20", "SLRU", "CLOCK",
+		"SAMPLED", "SAMPLED 3 7", "LRUK 2", "ARC", "2Q",
+	} {
+		t.Run(policy, func(t *testing.T) {
+			input := evictionHeavyScript(policy)
+			var first string
+			for run := range 100 {
+				// The evictions and expiries are reported on stderr.
+				stderr := captureStderr(t)
+				out, err := runScript(t, newClockSession(t), input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := out + "--- stderr\n" + stderr.String()
+				if run == 0 {
+					first = got
+					if !strings.Contains(got, "EVICT ") {
+						t.Fatal("the script evicted nothing")
+					}
+					continue
+				}
+				if got != first {
+					t.Fatalf("run %d differs from the first at byte %d", run, firstDifference(got, first))
+				}
+			}
+		})
+	}
+}
+
+func firstDifference(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
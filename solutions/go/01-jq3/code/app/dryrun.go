@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A WritePlan is what a write would do, as PlanPut works it out.
+type WritePlan[K comparable] struct {
+	// Update is set if the key has a live entry the write would replace.
+	Update bool
+	// Rejected is set if the admission filter would turn the new entry
+	// away, in which case nothing is evicted.
+	Rejected bool
+	// Evicted lists the keys the write would evict, in the order it
+	// would evict them.
+	Evicted []K
+}
+
+// PlanPut works out what Put(key, value) would do without doing it: no
+// entry moves, nothing expired is removed and nothing is counted. It
+// returns the error Put would, such as ErrFull, and otherwise whether the
+// write would update the key or insert it and what it would evict. In a
+// tiered cache the evicted entries would move to the second level.
+//
+// Entries the write would first remove as expired, its own or, with
+// eviction off, any past their grace period, are only skipped over, not
+// taken out of the policy's reckoning, so for 2Q, ARC and SAMPLED the plan
+// can differ from the write when there are any.
+func (c *LRUCache[K, V]) PlanPut(key K, value V) (WritePlan[K], error) {
+	key = c.normalize(key)
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	var plan WritePlan[K]
+	if c.compress != nil {
+		value = c.compress(value)
+	}
+	cost := c.defaultCost(key, value)
+	if err := c.writeError(key, value, cost); err != nil {
+		return plan, err
+	}
+	p := c.newEvictionPlan(c.capacity, c.maxCost)
+	node, ok := c.cache[key]
+	if ok && node.expired(p.now) {
+		p.drop(node)
+		node, ok = nil, false
+	}
+	if err := p.checkRoom(node, cost); err != nil {
+		return plan, err
+	}
+	p.forget(key)
+
+	q := victimQuery[K, V]{keep: node}
+	if ok {
+		plan.Update = true
+		p.used += cost - node.cost
+		p.charge(node, 0, cost-node.cost)
+		if node.pinned || c.list(node) != nil {
+			q.keep = nil
+		}
+	} else {
+		if !p.admit(key) {
+			plan.Rejected = true
+			return plan, nil
+		}
+		node = &Node[K, V]{key: key, priority: PriorityNormal, tenant: c.caller}
+		p.entries++
+		p.used += cost
+		p.charge(node, 1, cost)
+		q = victimQuery[K, V]{keep: node, added: c.list(node) == nil}
+	}
+	p.keep = node
+	p.evictOverflow(q)
+	plan.Evicted = p.evicted
+	return plan, nil
+}
+
+// PlanResize returns the keys Resize(newCapacity) would evict, in the
+// order it would evict them, without evicting them.
+func (c *LRUCache[K, V]) PlanResize(newCapacity int) []K {
+	if newCapacity < 1 {
+		return nil
+	}
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	if c.maxCost > 0 {
+		p := c.newEvictionPlan(c.capacity, newCapacity)
+		p.evictOverflow(victimQuery[K, V]{})
+		return p.evicted
+	}
+	p := c.newEvictionPlan(newCapacity, 0)
+	p.evictOverflow(victimQuery[K, V]{capacity: newCapacity})
+	return p.evicted
+}
+
+// PlanGet reports whether Get(key) would hit, without promoting the entry,
+// counting the lookup or consulting the backing store.
+func (c *LRUCache[K, V]) PlanGet(key K) bool {
+	key = c.normalize(key)
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	if node, ok := c.cache[key]; ok {
+		return !node.expired(c.now())
+	}
+	return c.l2 != nil && c.l2.PlanGet(key)
+}
+
+// An evictionPlan follows what evictOverflow would evict from the cache as
+// it stands, keeping its own count of the entries, cost and tombstones
+// left and of each tenant's share, and marking the entries it has taken
+// instead of removing them. It is used with the read lock held.
+type evictionPlan[K comparable, V any] struct {
+	c                 *LRUCache[K, V]
+	now               time.Time
+	capacity, maxCost int
+	keep              *Node[K, V]
+
+	entries, used int
+	pinnedLen     int
+	pinnedCost    int
+	taken         map[*Node[K, V]]bool
+	tenants       map[*tenantPool[K, V]][2]int // entries and cost
+
+	tombstones, tombstoneCost int
+	nextTombstone             *list.Element
+	forgotten                 *list.Element
+
+	evicted []K
+}
+
+func (c *LRUCache[K, V]) newEvictionPlan(capacity, maxCost int) *evictionPlan[K, V] {
+	p := &evictionPlan[K, V]{
+		c: c, now: c.now(), capacity: capacity, maxCost: maxCost,
+		entries: len(c.cache), used: c.usedCost,
+		pinnedLen: c.pinned.len, pinnedCost: c.pinnedCost,
+		taken:      make(map[*Node[K, V]]bool),
+		tenants:    make(map[*tenantPool[K, V]][2]int),
+		tombstones: len(c.negative), tombstoneCost: c.negativeCost,
+	}
+	for _, pool := range c.tenants {
+		p.tenants[pool] = [2]int{pool.count, pool.cost}
+	}
+	if c.tombstones != nil {
+		p.nextTombstone = c.tombstones.Front()
+	}
+	return p
+}
+
+// charge adds n entries of the given cost to node's tenant.
+func (p *evictionPlan[K, V]) charge(node *Node[K, V], n, cost int) {
+	if pool := p.c.tenants[node.tenant]; pool != nil {
+		share := p.tenants[pool]
+		p.tenants[pool] = [2]int{share[0] + n, share[1] + cost}
+	}
+}
+
+// usage is LRUCache.usage for the planned shares.
+func (p *evictionPlan[K, V]) usage(pool *tenantPool[K, V]) int {
+	if p.maxCost > 0 {
+		return p.tenants[pool][1]
+	}
+	return p.tenants[pool][0]
+}
+
+// drop takes node out of the plan's counts, as removing it would.
+func (p *evictionPlan[K, V]) drop(node *Node[K, V]) {
+	p.taken[node] = true
+	p.entries--
+	p.used -= node.cost
+	if node.pinned {
+		p.pinnedLen--
+		p.pinnedCost -= node.cost
+	}
+	p.charge(node, -1, -node.cost)
+}
+
+// evict drops node as an eviction.
+func (p *evictionPlan[K, V]) evict(node *Node[K, V]) {
+	p.drop(node)
+	p.evicted = append(p.evicted, node.key)
+}
+
+func (p *evictionPlan[K, V]) full(entries, cost int) bool {
+	return (p.capacity > 0 && p.entries+entries > p.capacity) ||
+		(p.maxCost > 0 && p.used+cost > p.maxCost)
+}
+
+// checkRoom is LRUCache.checkRoom for the plan, without counting a
+// refusal.
+func (p *evictionPlan[K, V]) checkRoom(node *Node[K, V], cost int) error {
+	c := p.c
+	entries, delta := 1, cost
+	if node != nil {
+		entries, delta = 0, cost-node.cost
+	}
+	if c.noEvict && p.full(entries, delta) {
+		for _, n := range c.resident {
+			if !p.taken[n] && n.gone(p.now) {
+				p.drop(n)
+			}
+		}
+		if p.full(entries, delta) {
+			return ErrFull
+		}
+	}
+	if p.pinnedLen == 0 {
+		return nil
+	}
+	pinnedLen, pinnedCost := p.pinnedLen, p.pinnedCost
+	if node != nil && node.pinned {
+		pinnedLen--
+		pinnedCost -= node.cost
+	}
+	if (node == nil && p.capacity > 0 && pinnedLen+1 > p.capacity) ||
+		(p.maxCost > 0 && pinnedCost+cost > p.maxCost) {
+		return ErrAllPinned
+	}
+	return nil
+}
+
+// forget leaves key's tombstone, which the write drops, out of the plan.
+func (p *evictionPlan[K, V]) forget(key K) {
+	if elem, ok := p.c.negative[key]; ok {
+		p.forgotten = elem
+		p.tombstones--
+		p.tombstoneCost -= elem.Value.(tombstone[K]).cost
+	}
+}
+
+// admit is LRUCache.admit for the plan, taking the sketch as it would be
+// once the write had recorded its access.
+func (p *evictionPlan[K, V]) admit(key K) bool {
+	c := p.c
+	if c.sketch == nil || p.entries < c.capacity {
+		return true
+	}
+	var victim *Node[K, V]
+	first := func(node *Node[K, V]) bool {
+		if p.taken[node] {
+			return true
+		}
+		victim = node
+		return false
+	}
+	if c.lowTier.eachBack(nil, first) {
+		c.policy.Victims(victimQuery[K, V]{}, first)
+	}
+	if victim == nil || victim.expired(p.now) {
+		return true
+	}
+	est, victimEst := c.sketch.estimatesAfter(key, victim.key)
+	return est > victimEst
+}
+
+func (p *evictionPlan[K, V]) overBudget() bool {
+	return (p.capacity > 0 && p.entries+p.tombstones > p.capacity) ||
+		(p.maxCost > 0 && p.used+p.tombstoneCost > p.maxCost)
+}
+
+// evictOverflow works out what evictOverflow(p.keep) would evict, in the
+// same order: tenants over their quota, then tombstones, then the low
+// priority entries, the policy's victims, the high priority entries and
+// the tenants' entries. q is the policy's view of the write.
+func (p *evictionPlan[K, V]) evictOverflow(q victimQuery[K, V]) {
+	c := p.c
+	p.evictTenants()
+	batch := c.lowWater > 0 && p.capacity > 0 && p.entries > p.capacity
+	over := func() bool {
+		return p.overBudget() || (batch && p.entries > c.lowWater)
+	}
+	for over() && p.tombstones > 0 {
+		p.dropTombstone()
+	}
+	take := func(node *Node[K, V]) bool {
+		if !over() {
+			return false
+		}
+		if !p.taken[node] {
+			p.evict(node)
+		}
+		return true
+	}
+	if !c.lowTier.eachBack(p.keep, take) {
+		return
+	}
+	stopped := false
+	c.policy.Victims(q, func(node *Node[K, V]) bool {
+		stopped = !take(node)
+		return !stopped
+	})
+	if stopped || !c.highTier.eachBack(p.keep, take) {
+		return
+	}
+	cursors := make(map[*tenantPool[K, V]]*listCursor[K, V])
+	for over() {
+		pool, node := p.tenantVictim(cursors)
+		if node == nil {
+			return
+		}
+		cursors[pool].advance()
+		p.evict(node)
+	}
+}
+
+// evictTenants is LRUCache.evictTenants for the plan.
+func (p *evictionPlan[K, V]) evictTenants() {
+	c := p.c
+	if c.tenantQuota == 0 || len(c.tenants) == 0 {
+		return
+	}
+	budget := p.capacity
+	if p.maxCost > 0 {
+		budget = p.maxCost
+	}
+	limit := max(1, budget*c.tenantQuota/100)
+	for _, name := range c.tenantNames() {
+		pool := c.tenants[name]
+		cur := pool.entries.cursor(p.keep)
+		for p.usage(pool) > limit {
+			node := p.next(cur)
+			if node == nil {
+				break
+			}
+			cur.advance()
+			p.evict(node)
+		}
+	}
+}
+
+// next returns the node cur is at, past any the plan has taken, or nil.
+func (p *evictionPlan[K, V]) next(cur *listCursor[K, V]) *Node[K, V] {
+	for node := cur.peek(); node != nil; node = cur.peek() {
+		if !p.taken[node] {
+			return node
+		}
+		cur.advance()
+	}
+	return nil
+}
+
+// tenantVictim is LRUCache.tenantVictim for the plan, walking each
+// tenant's list with its cursor in cursors.
+func (p *evictionPlan[K, V]) tenantVictim(cursors map[*tenantPool[K, V]]*listCursor[K, V]) (*tenantPool[K, V], *Node[K, V]) {
+	c := p.c
+	candidate := func(pool *tenantPool[K, V]) *Node[K, V] {
+		if cursors[pool] == nil {
+			cursors[pool] = pool.entries.cursor(p.keep)
+		}
+		return p.next(cursors[pool])
+	}
+	var from *tenantPool[K, V]
+	if pool := c.tenants[c.caller]; pool != nil && candidate(pool) != nil {
+		from = pool
+	} else {
+		for _, name := range c.tenantNames() {
+			pool := c.tenants[name]
+			if candidate(pool) != nil && (from == nil || p.usage(pool) > p.usage(from)) {
+				from = pool
+			}
+		}
+	}
+	if from == nil {
+		return nil, nil
+	}
+	return from, candidate(from)
+}
+
+// dropTombstone leaves the oldest tombstone the plan has not dropped out
+// of its counts.
+func (p *evictionPlan[K, V]) dropTombstone() {
+	if p.nextTombstone == p.forgotten {
+		p.nextTombstone = p.nextTombstone.Next()
+	}
+	p.tombstones--
+	p.tombstoneCost -= p.nextTombstone.Value.(tombstone[K]).cost
+	p.nextTombstone = p.nextTombstone.Next()
+}
+
+// dryRunReads lists the reads DRYRUN can report on, and whether every
+// argument is a key rather than just the first.
+var dryRunReads = map[string]bool{
+	"GET": false, "GETANY": false, "GETDEF": false, "GETRAW": false, "GETV": false,
+	"MGET": true, "EXISTS": true,
+}
+
+// cmdDryrun reports what a PUT, RESIZE or read would do without doing it.
+// The command is refused as it would be if it were run.
+func (s *session) cmdDryrun(in *bufio.Reader, out *reply, line string, parts []string) {
+	args := parts[1:]
+	normalizeCommand(args)
+	c := commandIndex[args[0]]
+	if c == nil {
+		out.Errorf(CodeUnknownCommand, "Unknown command: %s", args[0])
+		return
+	}
+	if !s.allowed(c, out, len(args)-1) {
+		return
+	}
+	switch all, read := dryRunReads[c.name]; {
+	case c.name == "PUT":
+		s.dryrunPut(out, args)
+	case c.name == "RESIZE":
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			out.Errorf(CodeBadCapacity, "Invalid capacity: %v", err)
+			return
+		}
+		if n < 1 {
+			out.Error(CodeBadCapacity, "capacity must be >= 1")
+			return
+		}
+		s.dryrunResult(out, "resize", s.cache.PlanResize(n))
+	case read:
+		keys := args[1:2]
+		if all {
+			keys = args[1:]
+		}
+		words := make([]string, len(keys))
+		hits := make([]bool, len(keys))
+		for i, key := range keys {
+			hits[i] = s.cache.PlanGet(key)
+			words[i] = "MISS"
+			if hits[i] {
+				words[i] = "HIT"
+			}
+		}
+		out.Result(strings.Join(words, " "), map[string]any{"outcome": "read", "hits": hits})
+	default:
+		out.Errorf(CodeUnsupported, "DRYRUN does not support %s", c.name)
+	}
+}
+
+// dryrunPut plans a PUT, after checking its value and TTL as cmdPut does.
+func (s *session) dryrunPut(out *reply, args []string) {
+	if len(args) > 3 {
+		if _, err := parseTTL(args[3]); err != nil {
+			out.Err(err)
+			return
+		}
+	}
+	if len(args) > 4 {
+		if _, err := parseTTL(args[4]); err != nil {
+			out.Errorf(CodeInvalid, "Invalid grace: %s", args[4])
+			return
+		}
+	}
+	plan, err := s.cache.PlanPut(args[1], StringValue(args[2]))
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	outcome := "insert"
+	switch {
+	case plan.Rejected:
+		outcome = "rejected"
+	case plan.Update:
+		outcome = "update"
+	}
+	s.dryrunResult(out, outcome, plan.Evicted)
+}
+
+// dryrunResult writes the outcome in upper case, the number of keys
+// evicted and the keys.
+func (s *session) dryrunResult(out *reply, outcome string, evicted []string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %d", strings.ToUpper(outcome), len(evicted))
+	for _, key := range evicted {
+		b.WriteString(" " + quoteToken(key))
+	}
+	if evicted == nil {
+		evicted = []string{}
+	}
+	out.Result(b.String(), map[string]any{"outcome": outcome, "evicted": evicted})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// filled returns an LRU cache holding k0 to k<n-1>, k<n-1> the most
+// recently used.
+func filled(n int) *LRUCache[string, int] {
+	c := NewLRUCache[string, int](n)
+	for i, key := range numbered("k", n) {
+		c.Put(key, i)
+	}
+	return c
+}
+
+// collect returns the keys each visits, stopping after limit of them if
+// limit is above 0.
+func collect(each func(func(string, int) bool), limit int) []string {
+	var keys []string
+	each(func(key string, _ int) bool {
+		keys = append(keys, key)
+		return limit <= 0 || len(keys) < limit
+	})
+	return keys
+}
+
+func TestEachOrderAndStop(t *testing.T) {
+	c := filled(5)
+	want := []string{"k4", "k3", "k2", "k1", "k0"}
+	if got := collect(c.Each, 0); !slices.Equal(got, want) {
+		t.Errorf("Each visited %v, want %v", got, want)
+	}
+	slices.Reverse(want)
+	if got := collect(c.EachReverse, 0); !slices.Equal(got, want) {
+		t.Errorf("EachReverse visited %v, want %v", got, want)
+	}
+	if got := collect(c.Each, 2); !slices.Equal(got, []string{"k4", "k3"}) {
+		t.Errorf("Each stopped after %v", got)
+	}
+	if got := collect(c.EachReverse, 1); !slices.Equal(got, []string{"k0"}) {
+		t.Errorf("EachReverse stopped after %v", got)
+	}
+}
+
+func TestEachDoesNotPromote(t *testing.T) {
+	c := filled(3)
+	c.EachReverse(func(string, int) bool { return true })
+	c.Each(func(string, int) bool { return true })
+	if st := c.Stats(); st.Hits != 0 || st.Misses != 0 {
+		t.Errorf("iterating counted %d hits and %d misses", st.Hits, st.Misses)
+	}
+	c.Put("new", 0)
+	if c.Contains("k0") {
+		t.Errorf("keys %v: iterating promoted the LRU entry", c.Keys())
+	}
+}
+
+func TestEachDeletingEveryEntry(t *testing.T) {
+	for _, reverse := range []bool{false, true} {
+		c := filled(50)
+		each := c.Each
+		if reverse {
+			each = c.EachReverse
+		}
+		visited := 0
+		each(func(key string, value int) bool {
+			if !c.Remove(key) {
+				t.Errorf("Remove(%s) found nothing", key)
+			}
+			visited++
+			return true
+		})
+		if visited != 50 || c.Size() != 0 {
+			t.Errorf("reverse=%v: visited %d, %d left", reverse, visited, c.Size())
+		}
+		if err := c.DebugCheck(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestEachSkipsRemovedAndNewKeys(t *testing.T) {
+	c := NewLRUCache[string, int](100)
+	for i, key := range numbered("k", 10) {
+		c.Put(key, i)
+	}
+	var visited []string
+	c.Each(func(key string, value int) bool {
+		visited = append(visited, key)
+		switch key {
+		case "k9":
+			// Remove one not yet visited, change another, and add keys.
+			c.Remove("k5")
+			c.Put("k3", 33)
+			for _, key := range numbered("new", 5) {
+				c.Put(key, 0)
+			}
+		case "k3":
+			if value != 33 {
+				t.Errorf("k3 visited with %d, want its new value", value)
+			}
+		}
+		return true
+	})
+	want := []string{"k9", "k8", "k7", "k6", "k4", "k3", "k2", "k1", "k0"}
+	if !slices.Equal(visited, want) {
+		t.Errorf("visited %v, want %v", visited, want)
+	}
+	if c.Size() != 14 {
+		t.Errorf("size %d", c.Size())
+	}
+}
+
+func TestEachSkipsExpired(t *testing.T) {
+	c, clock := clockCache(10)
+	c.Put("a", 1)
+	c.PutWithTTL("b", 2, time.Second)
+	c.Put("c", 3)
+	clock.Advance(2 * time.Second)
+	if got := collect(c.Each, 0); !slices.Equal(got, []string{"c", "a"}) {
+		t.Errorf("Each visited %v", got)
+	}
+}
+
+func TestEachCallbackMayUseCache(t *testing.T) {
+	c := filled(20)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Each(func(key string, value int) bool {
+			c.Get(key)
+			c.Put(key+"x", value)
+			c.Keys()
+			c.Stats()
+			c.EachReverse(func(string, int) bool { return false })
+			return true
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Each deadlocked with fn calling into the cache")
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEachUnderConcurrentWrites(t *testing.T) {
+	c := NewLRUCache[string, int](64)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := "k" + strconv.Itoa((g*31+i)%128)
+				if i%3 == 0 {
+					c.Remove(key)
+				} else {
+					c.Put(key, i)
+				}
+			}
+		}()
+	}
+	for range 200 {
+		seen := map[string]bool{}
+		c.Each(func(key string, _ int) bool {
+			if seen[key] {
+				t.Errorf("Each visited %s twice", key)
+			}
+			seen[key] = true
+			c.Remove(key)
+			return true
+		})
+	}
+	close(stop)
+	wg.Wait()
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
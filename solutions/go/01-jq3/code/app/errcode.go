@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+)
+
+// ErrorCode classifies a failed command for clients that need to tell
+// failures apart without matching on messages. Text mode writes it after
+// ERROR, JSON mode in the code field, RESP as the error kind and HTTP as
+// the status.
+type ErrorCode string
+
+const (
+	CodeArity          ErrorCode = "ERR_ARITY"           // wrong number of arguments
+	CodeSyntax         ErrorCode = "ERR_SYNTAX"          // malformed line or payload
+	CodeInvalid        ErrorCode = "ERR_INVALID"         // an argument has an invalid value
+	CodeBadCapacity    ErrorCode = "ERR_BAD_CAPACITY"    // capacity is not a positive integer
+	CodeUnknownCommand ErrorCode = "ERR_UNKNOWN_COMMAND" // no such command or subcommand
+	CodeNotInitialized ErrorCode = "ERR_NOT_INITIALIZED" // no cache has been created yet
+	CodeNotAllowed     ErrorCode = "ERR_NOT_ALLOWED"     // forbidden in this mode or state
+	CodeReadOnly       ErrorCode = "ERR_READONLY"        // a write in read-only mode
+	CodePrecondition   ErrorCode = "ERR_PRECONDITION"    // the entry does not meet a write's condition
+	CodeTooLarge       ErrorCode = "ERR_TOO_LARGE"       // key, value or line exceeds a limit
+	CodeNoSuchKey      ErrorCode = "ERR_NO_SUCH_KEY"     // the key is absent
+	CodeNoSuchCache    ErrorCode = "ERR_NO_SUCH_CACHE"   // no cache has that name
+	CodeWrongType      ErrorCode = "ERR_WRONG_TYPE"      // the value is not of the key's type or cannot be parsed as asked
+	CodeNotInteger     ErrorCode = "ERR_NOT_INTEGER"     // the value is not an integer
+	CodeOverflow       ErrorCode = "ERR_OVERFLOW"        // the result would overflow
+	CodeNoRoom         ErrorCode = "ERR_NO_ROOM"         // pinned entries hold the room needed
+	CodeFull           ErrorCode = "ERR_FULL"            // eviction is off and the cache is full
+	CodeUnsupported    ErrorCode = "ERR_UNSUPPORTED"     // the cache's kind or the protocol version does not allow it
+	CodeNoAuth         ErrorCode = "ERR_NOAUTH"          // the connection has not authenticated
+	CodeSlowConsumer   ErrorCode = "ERR_SLOW_CONSUMER"   // a subscriber fell too far behind the changes
+	CodeTimeout        ErrorCode = "ERR_TIMEOUT"         // the command gave up waiting; see --command-timeout
+	CodeIO             ErrorCode = "ERR_IO"              // reading or writing a file failed
+	CodeInternal       ErrorCode = "ERR_INTERNAL"        // an internal invariant is broken
+)
+
+// errorCodes maps each error the cache and the helpers commands call
+// return to its code, so that commands report them all with Err and none
+// spells out its own message or code for them. codeOf matches the errors
+// in order with errors.Is.
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrTooLarge, CodeTooLarge},
+	{ErrKeyTooLong, CodeTooLarge},
+	{ErrValueTooLong, CodeTooLarge},
+	{ErrNotFound, CodeNoSuchKey},
+	{ErrExpired, CodeNoSuchKey},
+	{ErrNotInteger, CodeNotInteger},
+	{ErrNotBool, CodeWrongType},
+	{ErrNotFloat, CodeWrongType},
+	{ErrNotJSON, CodeWrongType},
+	{errNotString, CodeWrongType},
+	{ErrWrongType, CodeWrongType},
+	{ErrOverflow, CodeOverflow},
+	{ErrAllPinned, CodeNoRoom},
+	{ErrCacheFull, CodeFull},
+	{ErrPrecondition, CodePrecondition},
+	{ErrAdmissionUnsupported, CodeUnsupported},
+	{ErrNotWeighted, CodeUnsupported},
+	{errKeyIndex, CodeUnsupported},
+	{ErrAutotuning, CodeNotAllowed},
+	{errUnterminatedQuote, CodeSyntax},
+	{errBadEscape, CodeSyntax},
+	{errAfterQuote, CodeSyntax},
+	{errBadSnapshot, CodeIO},
+	{errBadExport, CodeIO},
+	{ErrBacking, CodeIO},
+	{context.DeadlineExceeded, CodeTimeout},
+	{context.Canceled, CodeTimeout},
+	{ErrLoaderPanicked, CodeInternal},
+	{errCorruptValue, CodeInternal},
+}
+
+// codeOf returns the code for an error returned by the cache or by the
+// helpers commands call. Errors it does not know are taken to come from a
+// bad argument.
+func codeOf(err error) ErrorCode {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return CodeIO
+	}
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return CodeInvalid
+}
+
+// httpStatus returns the HTTP status reporting an error with code.
+func (code ErrorCode) httpStatus() int {
+	switch code {
+	case CodeNoSuchKey, CodeNoSuchCache, CodeUnknownCommand:
+		return http.StatusNotFound
+	case CodeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case CodeNotAllowed, CodeReadOnly:
+		return http.StatusForbidden
+	case CodeNoAuth:
+		return http.StatusUnauthorized
+	case CodeNotInitialized, CodeWrongType, CodeNotInteger, CodeOverflow, CodeNoRoom, CodeFull:
+		return http.StatusConflict
+	case CodeIO, CodeInternal:
+		return http.StatusInternalServerError
+	case CodePrecondition:
+		return http.StatusPreconditionFailed
+	case CodeTimeout:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
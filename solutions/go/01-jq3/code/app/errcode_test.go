@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sentinels holds every error the package declares with errors.New, by
+// name. TestSentinelErrorsHaveCodes fails if one is missing, so a new
+// sentinel cannot go without a code unnoticed.
+var sentinels = map[string]error{
+	"ErrAdmissionUnsupported": ErrAdmissionUnsupported,
+	"ErrAllPinned":            ErrAllPinned,
+	"ErrAutotuning":           ErrAutotuning,
+	"ErrBacking":              ErrBacking,
+	"ErrBadJitter":            ErrBadJitter,
+	"ErrBadPriority":          ErrBadPriority,
+	"ErrBadProfileSize":       ErrBadProfileSize,
+	"ErrBadQuota":             ErrBadQuota,
+	"ErrExpired":              ErrExpired,
+	"ErrFull":                 ErrFull,
+	"ErrInvalidCost":          ErrInvalidCost,
+	"ErrInvalidThreshold":     ErrInvalidThreshold,
+	"ErrInvalidWatermark":     ErrInvalidWatermark,
+	"ErrKeyTooLong":           ErrKeyTooLong,
+	"ErrLoaderPanicked":       ErrLoaderPanicked,
+	"ErrNotBool":              ErrNotBool,
+	"ErrNotFloat":             ErrNotFloat,
+	"ErrNotFound":             ErrNotFound,
+	"ErrNotInteger":           ErrNotInteger,
+	"ErrNotJSON":              ErrNotJSON,
+	"ErrNotWeighted":          ErrNotWeighted,
+	"ErrOverflow":             ErrOverflow,
+	"ErrPrecondition":         ErrPrecondition,
+	"ErrTooLarge":             ErrTooLarge,
+	"ErrValueTooLong":         ErrValueTooLong,
+	"ErrWrongType":            ErrWrongType,
+	"errAfterQuote":           errAfterQuote,
+	"errBadEscape":            errBadEscape,
+	"errBadExport":            errBadExport,
+	"errBadSnapshot":          errBadSnapshot,
+	"errCorruptValue":         errCorruptValue,
+	"errKeyIndex":             errKeyIndex,
+	"errNotString":            errNotString,
+	"errUnterminatedQuote":    errUnterminatedQuote,
+	"errFailFast":             errFailFast,
+	"errProtocol":             errProtocol,
+	"errStrict":               errStrict,
+	"errUnchanged":            errUnchanged,
+}
+
+// badArguments are the sentinels for an argument out of range, which
+// CodeInvalid is the right code for.
+var badArguments = map[error]bool{
+	ErrBadJitter:        true,
+	ErrBadPriority:      true,
+	ErrBadProfileSize:   true,
+	ErrBadQuota:         true,
+	ErrInvalidCost:      true,
+	ErrInvalidThreshold: true,
+	ErrInvalidWatermark: true,
+}
+
+// uncoded are the sentinels that never reach a client through codeOf:
+// errProtocol closes a RESP connection, errStrict and errFailFast end the
+// process and errUnchanged never leaves the cache.
+var uncoded = map[error]bool{
+	errFailFast:  true,
+	errProtocol:  true,
+	errStrict:    true,
+	errUnchanged: true,
+}
+
+func TestSentinelErrorsHaveCodes(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	declared := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, name := range vs.Names {
+					if i >= len(vs.Values) || !isErrorsNew(vs.Values[i]) {
+						continue
+					}
+					declared++
+					if _, ok := sentinels[name.Name]; !ok {
+						t.Errorf("%s: %s is missing from sentinels", fset.Position(name.Pos()), name.Name)
+					}
+				}
+			}
+		}
+	}
+	if declared != len(sentinels) {
+		t.Errorf("%d sentinels declared, %d listed", declared, len(sentinels))
+	}
+
+	for name, err := range sentinels {
+		if uncoded[err] {
+			continue
+		}
+		code := codeOf(err)
+		if code == CodeInvalid && !badArguments[err] {
+			t.Errorf("%s falls back to %s", name, code)
+		}
+		if code != CodeInvalid && badArguments[err] {
+			t.Errorf("%s is a bad argument but has code %s", name, code)
+		}
+		if wrapped := codeOf(fmt.Errorf("context: %w", err)); wrapped != code {
+			t.Errorf("%s wrapped has code %s, %s bare", name, wrapped, code)
+		}
+	}
+}
+
+// isErrorsNew reports whether expr is a call of errors.New.
+func isErrorsNew(expr ast.Expr) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "errors" && sel.Sel.Name == "New"
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Every entry of the session's caches carries an ETag, the FNV-64a hash
+// of its value, worked out each time the value is written, however it was
+// written: a PUTRAW value hashes its bytes as they are, a compressed one
+// the string it holds, and a list or hash its elements. Equal values always have the same ETag, so a
+// downstream HTTP cache can validate what it holds with If-None-Match,
+// and a client can make a PUT conditional on the value it last read with
+// If-Match. HTTP quotes it; ETAG prints it bare.
+
+// ErrPrecondition is returned by a write made on a condition the entry
+// does not meet.
+var ErrPrecondition = errors.New("precondition failed")
+
+// SetETags has hash work out the ETag of each value written from now on,
+// in a second level too.
+func (c *LRUCache[K, V]) SetETags(hash func(value V) uint64) {
+	c.lock()
+	defer c.unlock()
+	c.hasher = hash
+	if c.l2 != nil {
+		c.l2.SetETags(hash)
+	}
+}
+
+// tag works out node's ETag. It must be called with the write lock held
+// whenever node's value is set.
+func (c *LRUCache[K, V]) tag(node *Node[K, V]) {
+	if c.hasher != nil {
+		node.etag = c.hasher(node.value)
+	}
+}
+
+// ETag returns the ETag of the live entry for key without promoting it or
+// counting a read, or false if there is none or ETags are off.
+func (c *LRUCache[K, V]) ETag(key K) (uint64, bool) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	if _, ok := c.cache[key]; !ok && c.l2 != nil {
+		return c.l2.ETag(key)
+	}
+	node, ok := c.lookup(key)
+	if !ok || c.hasher == nil {
+		return 0, false
+	}
+	return node.etag, true
+}
+
+// etagOf returns the ETag of value, just read under the write lock from
+// the entry for key.
+func (c *LRUCache[K, V]) etagOf(key K, value V) uint64 {
+	if node, ok := c.cache[key]; ok {
+		return node.etag
+	}
+	if c.hasher != nil {
+		return c.hasher(value)
+	}
+	return 0
+}
+
+// PutIfMatch stores value under key with the TTL ttl, 0 for none, only if
+// key is live and match accepts its ETag, as one atomic step, and returns
+// ErrPrecondition if not.
+func (c *LRUCache[K, V]) PutIfMatch(key K, value V, ttl time.Duration, match func(etag uint64) bool) error {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok, err := c.lookupThrough(key)
+	if err != nil {
+		return err
+	}
+	if !ok || !match(node.etag) {
+		return ErrPrecondition
+	}
+	return c.put(key, value, ttl, c.defaultCost(key, value))
+}
+
+// etag returns the FNV-64a hash of the string v holds, or of its list
+// elements or hash fields and values, each prefixed with its length, after
+// a byte naming the type.
+func (v Value) etag() uint64 {
+	h := fnv.New64a()
+	write := func(s string) {
+		h.Write(binary.AppendUvarint(nil, uint64(len(s))))
+		h.Write([]byte(s))
+	}
+	switch {
+	case v.list != nil:
+		h.Write([]byte{'l'})
+		for _, item := range v.list.items {
+			write(item)
+		}
+	case v.hash != nil:
+		h.Write([]byte{'h'})
+		for _, field := range v.fieldNames() {
+			write(field)
+			write(v.hash.fields[field])
+		}
+	default:
+		str, err := v.Str()
+		if err != nil {
+			str = v.str
+		}
+		h.Write([]byte(str))
+	}
+	return h.Sum64()
+}
+
+// formatETag returns etag as HTTP writes it, a quoted strong validator.
+func formatETag(etag uint64) string {
+	return fmt.Sprintf(`"%016x"`, etag)
+}
+
+// etagMatches reports whether header, an If-Match or If-None-Match list
+// of ETags or "*", names etag. Weak ETags match as strong ones do.
+func etagMatches(header string, etag uint64) bool {
+	want := formatETag(etag)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// setETag sets the ETag header of w.
+func setETag(w http.ResponseWriter, etag uint64) {
+	w.Header().Set("ETag", formatETag(etag))
+}
+
+func (s *session) cmdEtag(in *bufio.Reader, out *reply, line string, parts []string) {
+	etag, ok := s.cache.ETag(parts[1])
+	if !ok {
+		out.Null()
+		return
+	}
+	tag := fmt.Sprintf("%016x", etag)
+	out.Result(tag, map[string]any{"etag": tag})
+}
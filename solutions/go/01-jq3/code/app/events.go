@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// SetAccessHandler registers fn to be called for every Get, and every call
+// built on it, with whether it hit. Unlike the removal handler, fn runs
+// with the lock held, so it must be quick and must not call back into the
+// cache.
+func (c *LRUCache[K, V]) SetAccessHandler(fn func(key K, hit bool)) {
+	c.lock()
+	defer c.unlock()
+	c.onAccess = fn
+}
+
+// accessed reports a Get to the access handler, if there is one, and
+// counts it for the calling tenant and the hot key tracker.
+func (c *LRUCache[K, V]) accessed(key K, hit bool) {
+	c.countTenant(hit)
+	if h := c.hotKeys.Load(); h != nil {
+		h.add(key)
+	}
+	if c.onAccess != nil {
+		c.onAccess(key, hit)
+	}
+}
+
+// An eventClass is a kind of cache event, and a set of them as a mask.
+type eventClass uint32
+
+const (
+	eventEvict eventClass = 1 << iota
+	eventExpire
+	eventHit
+	eventMiss
+
+	eventAll = eventEvict | eventExpire | eventHit | eventMiss
+)
+
+// eventClassNames are the names --verbose and EVENTS take, in the order
+// they are listed.
+var eventClassNames = []struct {
+	name  string
+	class eventClass
+}{
+	{"evict", eventEvict},
+	{"expire", eventExpire},
+	{"hit", eventHit},
+	{"miss", eventMiss},
+}
+
+// parseEventClasses parses a comma-separated list of event class names,
+// or "all".
+func parseEventClasses(list string) (eventClass, error) {
+	var mask eventClass
+	for name := range strings.SplitSeq(strings.ToLower(list), ",") {
+		if name == "all" {
+			mask |= eventAll
+			continue
+		}
+		found := false
+		for _, n := range eventClassNames {
+			if n.name == name {
+				mask |= n.class
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown event class %q: want evict, expire, hit, miss or all", name)
+		}
+	}
+	return mask, nil
+}
+
+func (m eventClass) String() string {
+	var names []string
+	for _, n := range eventClassNames {
+		if m&n.class != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "OFF"
+	}
+	return strings.Join(names, ",")
+}
+
+// A cacheEvent is one thing that happened to a key of a cache. Size and
+// Capacity are the cache's after an eviction or expiry, and zero for hits
+// and misses, which are published with the cache locked.
+type cacheEvent struct {
+	class    eventClass
+	cache    string
+	key      string
+	reason   string
+	size     int
+	capacity int
+}
+
+// String formats the event as the stderr narrator writes it.
+func (e cacheEvent) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] key=%s", e.class, quoteToken(e.key))
+	if e.cache != defaultCacheName {
+		fmt.Fprintf(&b, " cache=%s", e.cache)
+	}
+	if e.reason != "" {
+		fmt.Fprintf(&b, " reason=%s", e.reason)
+	}
+	if e.capacity > 0 {
+		fmt.Fprintf(&b, " size=%d/%d", e.size, e.capacity)
+	}
+	return b.String()
+}
+
+// An eventBus passes the events of every cache of a session to whoever
+// subscribed to their class: the stderr narrator for now, and other
+// front ends later. Publishing an event nobody wants costs one atomic
+// load, so the caches publish everything.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[*eventSub]struct{}
+	// wanted is the union of the subscribers' classes.
+	wanted atomic.Uint32
+}
+
+type eventSub struct {
+	classes eventClass
+	fn      func(cacheEvent)
+}
+
+// subscribe calls fn for each event of the given classes until the
+// returned function is called. fn may be called concurrently from the
+// goroutines using the caches.
+func (b *eventBus) subscribe(classes eventClass, fn func(cacheEvent)) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &eventSub{classes: classes, fn: fn}
+	if b.subs == nil {
+		b.subs = make(map[*eventSub]struct{})
+	}
+	b.subs[sub] = struct{}{}
+	b.rewant()
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, sub)
+		b.rewant()
+	}
+}
+
+func (b *eventBus) rewant() {
+	var wanted eventClass
+	for sub := range b.subs {
+		wanted |= sub.classes
+	}
+	b.wanted.Store(uint32(wanted))
+}
+
+// wants reports whether anyone has subscribed to class.
+func (b *eventBus) wants(class eventClass) bool {
+	return eventClass(b.wanted.Load())&class != 0
+}
+
+func (b *eventBus) publish(e cacheEvent) {
+	if !b.wants(e.class) {
+		return
+	}
+	b.mu.Lock()
+	subs := make([]*eventSub, 0, len(b.subs))
+	for sub := range b.subs {
+		if sub.classes&e.class != 0 {
+			subs = append(subs, sub)
+		}
+	}
+	b.mu.Unlock()
+	for _, sub := range subs {
+		sub.fn(e)
+	}
+}
+
+// A lineWriter writes whole lines, each with a single write under its own
+// lock, so lines from concurrent writers never interleave.
+type lineWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func newLineWriter(w io.Writer) *lineWriter {
+	return &lineWriter{w: bufio.NewWriter(w)}
+}
+
+func (l *lineWriter) println(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.WriteString(line)
+	l.w.WriteByte('\n')
+	l.w.Flush()
+}
+
+var stderrLines = newLineWriter(os.Stderr)
+
+// narrate adds the events of the classes in on to those written to
+// stderr, one line each, and takes those in off away.
+func (s *session) narrate(on, off eventClass) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	if s.stopNarrating != nil {
+		s.stopNarrating()
+		s.stopNarrating = nil
+	}
+	classes := (s.narrated | on) &^ off
+	s.narrated = classes
+	if classes != 0 {
+		s.stopNarrating = s.events.subscribe(classes, func(e cacheEvent) {
+			stderrLines.println(e.String())
+		})
+	}
+}
+
+// publishRemovals registers a removal handler on the cache named name that
+// logs its evictions to stderr, as it always has, and publishes its
+// evictions and expiries as events, and passes its writes, deletions and
+// expiries to the watchers.
+func (s *session) publishRemovals(name string, cache *LRUCache[string, Value]) {
+	cache.SetWriteHandler(func(key string, seq uint64, deleted bool) {
+		if deleted {
+			s.watched(name, key, "delete", seq)
+		} else {
+			s.watched(name, key, "put", seq)
+		}
+	})
+	cache.SetRemovalHandler(func(key string, value Value, reason RemovalReason) {
+		if reason == RemovalExpired || reason == RemovalIdle {
+			s.watched(name, key, "expire", cache.Seq())
+		}
+		e := cacheEvent{cache: name, key: key}
+		switch reason {
+		case RemovalEvicted:
+			stderrLines.println("EVICT " + key)
+			e.class, e.reason = eventEvict, "capacity"
+		case RemovalMemoryPressure:
+			e.class, e.reason = eventEvict, "memory"
+		case RemovalExpired:
+			e.class, e.reason = eventExpire, "ttl"
+		case RemovalIdle:
+			e.class, e.reason = eventExpire, "idle"
+		default:
+			return
+		}
+		if s.events.wants(e.class) {
+			e.size, e.capacity = cache.Size(), cache.Capacity()
+			s.events.publish(e)
+		}
+	})
+	cache.SetAccessHandler(func(key string, hit bool) {
+		if hit {
+			s.events.publish(cacheEvent{class: eventHit, cache: name, key: key})
+		} else {
+			s.events.publish(cacheEvent{class: eventMiss, cache: name, key: key})
+		}
+	})
+}
+
+// cmdEvents turns the stderr narration of the given event classes, or of
+// all of them, on or off, or reports which are on.
+func (s *session) cmdEvents(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) == 1 {
+		s.eventsMu.Lock()
+		defer s.eventsMu.Unlock()
+		out.Value(s.narrated.String())
+		return
+	}
+	classes := eventAll
+	if len(parts) > 2 {
+		var err error
+		if classes, err = parseEventClasses(parts[2]); err != nil {
+			out.Errorf(CodeInvalid, "Invalid event classes: %s", parts[2])
+			return
+		}
+	}
+	switch parts[1] {
+	case "ON":
+		s.narrate(classes, 0)
+	case "OFF":
+		s.narrate(0, classes)
+	default:
+		out.Error(CodeArity, "EVENTS takes ON or OFF")
+		return
+	}
+	out.OK()
+}
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestExecuteErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		init bool // run INIT 3 first
+		line string
+		want string
+	}{
+		{"put before init", false, "PUT a 1", "ERROR ERR_NOT_INITIALIZED Cache not initialized"},
+		{"get before init", false, "GET a", "ERROR ERR_NOT_INITIALIZED Cache not initialized"},
+		{"size before init", false, "SIZE", "ERROR ERR_NOT_INITIALIZED Cache not initialized"},
+		{"init without capacity", false, "INIT", "ERROR ERR_ARITY INIT requires capacity argument"},
+		{"init non-numeric", false, "INIT x", `ERROR ERR_BAD_CAPACITY Invalid capacity: strconv.Atoi: parsing "x": invalid syntax`},
+		{"init zero", false, "INIT 0", "ERROR ERR_BAD_CAPACITY capacity must be >= 1"},
+		{"unknown before init", false, "FOO", "ERROR ERR_UNKNOWN_COMMAND Unknown command: FOO"},
+		{"put without args", true, "PUT", "ERROR ERR_ARITY PUT requires key and value arguments"},
+		{"put without value", true, "PUT a", "ERROR ERR_ARITY PUT requires key and value arguments"},
+		{"get without key", true, "GET", "ERROR ERR_ARITY GET requires key argument"},
+		{"get with two keys", true, "GET a b", "ERROR ERR_ARITY GET takes at most 1 argument"},
+		{"size with argument", true, "SIZE extra", "ERROR ERR_ARITY SIZE takes no arguments"},
+		{"peek without key", true, "PEEK", "ERROR ERR_ARITY PEEK requires key argument"},
+		{"unknown", true, "FOO bar", "ERROR ERR_UNKNOWN_COMMAND Unknown command: FOO"},
+	} {
+		s := newTestSession(t)
+		if tc.init {
+			script(t, s, "INIT 3", "OK")
+		}
+		if got := s.Execute(tc.line); got != tc.want {
+			t.Errorf("%s: Execute(%q) = %q, want %q", tc.name, tc.line, got, tc.want)
+		}
+	}
+}
+
+// The stage scripts expect the starter's error lines, which --legacy-errors
+// keeps.
+func TestExecuteLegacyErrors(t *testing.T) {
+	s := newTestSession(t)
+	s.legacyErrors = true
+	script(t, s,
+		"GET a", "ERROR: Cache not initialized",
+		"INIT", "ERROR: INIT requires capacity argument",
+		"INIT x", `ERROR: Invalid capacity: strconv.Atoi: parsing "x": invalid syntax`,
+		"INIT 2", "OK",
+		"PUT a", "ERROR: PUT requires key and value arguments",
+		"GET", "ERROR: GET requires key argument",
+		"FOO", "ERROR: Unknown command: FOO",
+		"GET a", "NULL",
+	)
+}
+
+func TestExecuteIsCaseInsensitive(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s, "init 2", "OK", "put a 1", "OK", "get a", "1", "size", "1")
+}
+
+func TestExecuteSeveralCommands(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 2; PUT a 1;;GET a ; ", "OK\nOK\n1",
+		`PUT b "x;y"; GET b`, "OK\nx;y",
+		"GET; GET a", "ERROR ERR_ARITY GET requires key argument\n1",
+		// ECHO ends at a semicolon like any other command, and keeps the
+		// quotes of one it is to print.
+		"ECHO hi; PING", "hi\nPONG",
+		`ECHO "a;b"  c ;GET a`, "\"a;b\"  c\n1",
+		`ECHO it"s; PING`, "it\"s\nPONG",
+	)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Snapshot is the whole state of a cache in a form meant for people and
+// other programs rather than for LOAD: one JSON document, with expiry given
+// as the time left, for people, and as the instant, for Import. Its JSON
+// looks like
+//
+//	{"capacity": 100, "policy": "LRU", "entries": [
+//	  {"key": "a", "value": "1", "ttl": "1m30s", "expire_at": "2026-10-14T09:30:00Z", "pinned": true},
+//	  {"key": "b", "value": {"list": ["x", "y"]}}
+//	]}
+//
+// with entries in retention order, most recently used first, ttl and grace
+// written as Go durations, expire_at in RFC 3339, and each left out when
+// there are none. A document written by hand may give ttl alone.
+type Snapshot[K comparable, V any] struct {
+	Capacity int
+	Policy   string
+	Entries  []SnapshotEntry[K, V]
+}
+
+// SnapshotEntry is one entry of a Snapshot. TTL is the time it has left,
+// or 0 if it never expires, and ExpireAt, if it is not zero, the instant
+// it expires, which Import goes by in place of TTL so that entries do not
+// outlive their deadlines however long the document waits to be loaded.
+type SnapshotEntry[K comparable, V any] struct {
+	Key      K
+	Value    V
+	TTL      time.Duration
+	ExpireAt time.Time
+	Grace    time.Duration
+	Pinned   bool
+}
+
+var errBadExport = errors.New("invalid export")
+
+type exportDoc[K comparable, V any] struct {
+	Capacity int                 `json:"capacity"`
+	Policy   string              `json:"policy"`
+	Entries  []exportEntry[K, V] `json:"entries"`
+}
+
+type exportEntry[K comparable, V any] struct {
+	Key      K      `json:"key"`
+	Value    V      `json:"value"`
+	TTL      string `json:"ttl,omitempty"`
+	ExpireAt string `json:"expire_at,omitempty"`
+	Grace    string `json:"grace,omitempty"`
+	Pinned   bool   `json:"pinned,omitempty"`
+}
+
+func (s Snapshot[K, V]) MarshalJSON() ([]byte, error) {
+	doc := exportDoc[K, V]{Capacity: s.Capacity, Policy: s.Policy, Entries: make([]exportEntry[K, V], len(s.Entries))}
+	for i, e := range s.Entries {
+		doc.Entries[i] = exportEntry[K, V]{Key: e.Key, Value: e.Value, Pinned: e.Pinned}
+		if e.TTL > 0 {
+			// To the millisecond is plenty for someone reading the file.
+			doc.Entries[i].TTL = max(e.TTL.Round(time.Millisecond), time.Millisecond).String()
+		}
+		if !e.ExpireAt.IsZero() {
+			doc.Entries[i].ExpireAt = e.ExpireAt.UTC().Format(time.RFC3339Nano)
+		}
+		if e.Grace > 0 {
+			doc.Entries[i].Grace = e.Grace.String()
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON reads a Snapshot, rejecting fields it does not know, values
+// of the wrong type, and duplicate keys.
+func (s *Snapshot[K, V]) UnmarshalJSON(data []byte) error {
+	var doc exportDoc[K, V]
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("%w: %v", errBadExport, err)
+	}
+	out := Snapshot[K, V]{Capacity: doc.Capacity, Policy: doc.Policy, Entries: make([]SnapshotEntry[K, V], len(doc.Entries))}
+	for i, e := range doc.Entries {
+		entry := SnapshotEntry[K, V]{Key: e.Key, Value: e.Value, Pinned: e.Pinned}
+		var err error
+		if entry.TTL, err = parseExportDuration(e.TTL); err != nil {
+			return fmt.Errorf("%w: key %v: ttl: %v", errBadExport, e.Key, err)
+		}
+		if e.ExpireAt != "" {
+			if entry.ExpireAt, err = time.Parse(time.RFC3339Nano, e.ExpireAt); err != nil {
+				return fmt.Errorf("%w: key %v: expire_at: %v", errBadExport, e.Key, err)
+			}
+		}
+		if entry.Grace, err = parseExportDuration(e.Grace); err != nil {
+			return fmt.Errorf("%w: key %v: grace: %v", errBadExport, e.Key, err)
+		}
+		out.Entries[i] = entry
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+	*s = out
+	return nil
+}
+
+// parseExportDuration parses a ttl or grace, which must be positive when
+// it is given at all.
+func parseExportDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s is not positive", s)
+	}
+	return d, nil
+}
+
+func (s Snapshot[K, V]) validate() error {
+	if s.Capacity < 0 {
+		return fmt.Errorf("%w: negative capacity", errBadExport)
+	}
+	seen := make(map[K]bool, len(s.Entries))
+	for _, e := range s.Entries {
+		if seen[e.Key] {
+			return fmt.Errorf("%w: duplicate key %v", errBadExport, e.Key)
+		}
+		seen[e.Key] = true
+		if e.TTL < 0 || e.Grace < 0 {
+			return fmt.Errorf("%w: key %v: negative ttl or grace", errBadExport, e.Key)
+		}
+		if e.Grace > 0 && e.TTL == 0 && e.ExpireAt.IsZero() {
+			return fmt.Errorf("%w: key %v: grace without a ttl", errBadExport, e.Key)
+		}
+	}
+	return nil
+}
+
+// Export returns the cache's capacity, policy and live entries as they
+// are now.
+func (c *LRUCache[K, V]) Export() Snapshot[K, V] {
+	header, entries, now := c.capture()
+	s := Snapshot[K, V]{Capacity: header.Capacity, Policy: header.Policy, Entries: make([]SnapshotEntry[K, V], len(entries))}
+	for i, e := range entries {
+		s.Entries[i] = SnapshotEntry[K, V]{Key: e.Key, Value: e.Value, ExpireAt: e.ExpireAt, Grace: e.Grace, Pinned: e.Pinned}
+		if !e.ExpireAt.IsZero() {
+			s.Entries[i].TTL = e.ExpireAt.Sub(now)
+		}
+	}
+	return s
+}
+
+// Import replaces the cache contents with the entries of s, as Restore
+// does with a snapshot: the cache keeps its own capacity and policy, and
+// only the most recent entries that fit are kept. Each entry expires at its
+// ExpireAt, or failing that its TTL from now, and one whose ExpireAt has
+// passed is dropped. Eviction handlers are not called.
+func (c *LRUCache[K, V]) Import(s Snapshot[K, V]) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	c.lock()
+	defer c.unlock()
+
+	now := c.now()
+	entries := make([]snapshotEntry[K, V], len(s.Entries))
+	for i, e := range s.Entries {
+		entries[i] = snapshotEntry[K, V]{Key: e.Key, Value: e.Value, TTL: e.TTL, ExpireAt: e.ExpireAt, Grace: e.Grace, Pinned: e.Pinned}
+		if e.ExpireAt.IsZero() && e.TTL > 0 {
+			entries[i].ExpireAt = now.Add(e.TTL)
+		}
+	}
+	c.replace(entries, now, false)
+	return nil
+}
+
+// exportCache writes the state of cache to path as a Snapshot document,
+// replacing the file only once it has been written completely.
+func exportCache(cache *LRUCache[string, Value], path string) (int, error) {
+	s := cache.Export()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return len(s.Entries), os.Rename(tmp, path)
+}
+
+// importCache replaces the contents of cache with the Snapshot document at
+// path.
+func importCache(cache *LRUCache[string, Value], path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var s Snapshot[string, Value]
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return cache.Import(s)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// feedBuffer is how many changes a subscriber may fall behind by before it
+// is dropped.
+const feedBuffer = 1024
+
+// A changefeed passes every change to the default cache, as the line the
+// AOF records for it, to the connections that SUBSCRIBE. Changes are
+// published after the command has released the cache, and to each
+// subscriber through a buffer: one that falls feedBuffer changes behind is
+// dropped rather than hold up writers or buffer without bound. Evictions
+// and expirations are not changes of their own: a follower of the same
+// capacity applying the same commands makes them itself.
+//
+// The same type passes the watchers of GET /watch the events of the keys
+// they watch; see watch.go. Subscribing and unsubscribing swap the list of
+// subscribers rather than lock it, so they never hold up a publisher.
+type changefeed struct {
+	mu    sync.Mutex // held by publishers, so that every subscriber sees one order
+	subMu sync.Mutex // held by subscribe and unsubscribe
+	subs  atomic.Pointer[[]*subscriber]
+}
+
+// subscriber receives changes on lines until it is dropped, when dropped
+// is closed. A watcher receives only the events of keys starting with
+// prefix.
+type subscriber struct {
+	lines    chan change
+	dropped  chan struct{}
+	dropOnce sync.Once
+	prefix   string
+}
+
+// change is a published line, or the event of a key for watchers, and
+// when it was published.
+type change struct {
+	line  string
+	at    time.Time
+	key   string
+	event string // "put", "delete" or "expire"
+	seq   uint64
+}
+
+func (f *changefeed) subscribe() *subscriber {
+	return f.subscribeTo("")
+}
+
+// subscribeTo subscribes to the changes whose key starts with prefix.
+func (f *changefeed) subscribeTo(prefix string) *subscriber {
+	f.subMu.Lock()
+	defer f.subMu.Unlock()
+
+	sub := &subscriber{lines: make(chan change, feedBuffer), dropped: make(chan struct{}), prefix: prefix}
+	var subs []*subscriber
+	if old := f.subs.Load(); old != nil {
+		subs = slices.Clone(*old)
+	}
+	subs = append(subs, sub)
+	f.subs.Store(&subs)
+	return sub
+}
+
+func (f *changefeed) unsubscribe(sub *subscriber) {
+	f.subMu.Lock()
+	defer f.subMu.Unlock()
+
+	if old := f.subs.Load(); old != nil {
+		subs := slices.DeleteFunc(slices.Clone(*old), func(s *subscriber) bool { return s == sub })
+		f.subs.Store(&subs)
+	}
+}
+
+// publish passes line to every subscriber, dropping those with no room for
+// it.
+func (f *changefeed) publish(line string) {
+	f.send(change{line: line})
+}
+
+// send passes c to every subscriber it matches, dropping those with no
+// room for it. It costs one atomic load when there are none.
+func (f *changefeed) send(c change) {
+	subs := f.subs.Load()
+	if subs == nil || len(*subs) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Publishers wait for each other, so the list is loaded again to see
+	// the subscribers that were added meanwhile.
+	subs = f.subs.Load()
+	c.at = time.Now()
+	for _, sub := range *subs {
+		if !strings.HasPrefix(c.key, sub.prefix) {
+			continue
+		}
+		select {
+		case sub.lines <- c:
+		default:
+			// A dropped subscriber stays on the list until it
+			// unsubscribes, which it does once it sees dropped closed.
+			sub.dropOnce.Do(func() { close(sub.dropped) })
+		}
+	}
+}
+
+// cmdSubscribe streams changes to the connection until it closes, falls
+// too far behind or the server shuts down. Nothing the client sends is
+// run meanwhile, and the connection is closed afterwards.
+func (s *session) cmdSubscribe(in *bufio.Reader, out *reply, line string, parts []string) {
+	sub := s.feed.subscribe()
+	defer s.feed.unsubscribe(sub)
+	out.OK()
+	s.stream(in, out, sub, 0, func(c change) { out.Change(c.line) })
+}
+
+// stream hands the connection over to sub: it writes each change with send
+// until the connection closes, sub is dropped or the server shuts down, and
+// then hangs up. With a heartbeat, send is also called with an empty change
+// each time that long passes, so the client can tell a quiet feed from a
+// lost one.
+func (s *session) stream(in *bufio.Reader, out *reply, sub *subscriber, heartbeat time.Duration, send func(change)) {
+	out.hangUp = true
+	flusher, _ := out.w.(interface{ Flush() error })
+	flush := func() error {
+		if flusher == nil {
+			return nil
+		}
+		return flusher.Flush()
+	}
+	if flush() != nil {
+		return
+	}
+	var beat <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		beat = ticker.C
+	}
+	// Reading is how a closed connection shows; what is read is ignored.
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, in)
+		close(gone)
+	}()
+	for {
+		select {
+		case c := <-sub.lines:
+			send(c)
+			if len(sub.lines) > 0 {
+				continue
+			}
+			if flush() != nil {
+				return
+			}
+		case now := <-beat:
+			send(change{at: now})
+			if flush() != nil {
+				return
+			}
+		case <-sub.dropped:
+			// Changes still buffered are not sent: the subscriber has
+			// missed one anyway.
+			out.Error(CodeSlowConsumer, "Subscriber fell too far behind")
+			return
+		case <-gone:
+			return
+		case <-s.stopping:
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"time"
+)
+
+// GetIfFresh is Get for a value written no more than maxAge ago, by a Put
+// or any other change of value; a Touch or an EXPIRE does not renew it. An
+// older entry is reported missing but left in place, neither promoted nor
+// removed, since it may be fresh enough for another caller, and is counted
+// in Stats.StaleRejects rather than as a hit or a miss.
+func (c *LRUCache[K, V]) GetIfFresh(key K, maxAge time.Duration) (V, bool) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	if node, ok := c.lookup(key); ok && c.now().Sub(node.writtenAt) > maxAge {
+		c.counters.staleRejects.Add(1)
+		var zero V
+		return zero, false
+	}
+	return c.get(key)
+}
+
+func (s *session) cmdGetfresh(in *bufio.Reader, out *reply, line string, parts []string) {
+	maxAge, err := parseTTL(parts[2])
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid max age: %s", parts[2])
+		return
+	}
+	value, ok := s.cache.GetIfFresh(parts[1], maxAge)
+	if !ok {
+		out.Null()
+		return
+	}
+	str, err := value.Str()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	out.Value(str)
+}
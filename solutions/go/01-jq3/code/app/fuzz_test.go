@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/systemquest/lru-cache-starter-go/app/conformance"
+)
+
+// fuzzSkipped are the commands FuzzExecute does not run: they touch files
+// or the network, sleep, or run for as long as their arguments ask.
+var fuzzSkipped = map[string]bool{
+	"SAVE": true, "LOAD": true, "EXPORT": true, "IMPORT": true, "AOF": true,
+	"SPLIT": true, "WARM": true, "REPLAY": true, "REFRESHSOURCE": true,
+	"STRESS": true, "BENCH": true, "SIMULATE": true,
+	"SUBSCRIBE": true, "SYNC": true, "REPLICATE": true,
+	"DEBUG": true,
+}
+
+// FuzzExecute runs arbitrary input through a session, a line at a time and
+// a command at a time, in JSON mode, where every command has exactly one
+// response line. It checks that nothing panics and that each command gets
+// that one line and it is valid JSON. The corpus starts from the
+// conformance scenarios.
+func FuzzExecute(f *testing.F) {
+	scenarios, err := conformance.Scenarios()
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, sc := range scenarios {
+		f.Add(strings.Join(sc.Commands(), "\n"))
+	}
+	f.Add("PUT a 1 ;; GET a")
+	f.Add("PUT\tk\t\"v \\\"q\\\"\"\r\nGET k")
+	f.Add("PUT \x00 \xff\xfe\nGET \x00")
+	f.Add("  GET   \"unterminated")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		s := newTestSession(t)
+		script(t, s, "INIT 8", "OK")
+		for _, line := range strings.Split(input, "\n") {
+			for _, cmd := range splitCommands(line) {
+				word, _ := cutWord(strings.TrimSpace(cmd))
+				if fuzzSkipped[strings.ToUpper(word)] {
+					continue
+				}
+				s.json = true
+				got := s.Execute(cmd)
+				cmd = strings.TrimSpace(cmd)
+				if cmd == "" || isComment(cmd) {
+					if got != "" {
+						t.Fatalf("%q: got %q, want no response", cmd, got)
+					}
+					continue
+				}
+				if strings.Contains(got, "\n") || !json.Valid([]byte(got)) {
+					t.Fatalf("%q: got %q, want one line of JSON", cmd, got)
+				}
+			}
+		}
+		if s.cache != nil {
+			if err := s.cache.DebugCheck(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+type point struct {
+	X, Y int
+	Tags []string
+}
+
+func TestGenericIntKeysStructValues(t *testing.T) {
+	c := NewLRUCache[int, point](2)
+	var evicted []int
+	c.SetEvictionHandler(func(key int, value point) {
+		if value.X != key {
+			t.Errorf("evicted %d with value %+v", key, value)
+		}
+		evicted = append(evicted, key)
+	})
+	c.Put(1, point{X: 1, Tags: []string{"a"}})
+	c.Put(2, point{X: 2})
+	if v, ok := c.Get(1); !ok || v.X != 1 || !slices.Equal(v.Tags, []string{"a"}) {
+		t.Errorf("Get(1) = %+v, %v", v, ok)
+	}
+	c.Put(3, point{X: 3})
+	if !slices.Equal(evicted, []int{2}) {
+		t.Errorf("evicted %v, want [2]", evicted)
+	}
+	if v, ok := c.Peek(1); !ok || v.X != 1 {
+		t.Errorf("Peek(1) = %+v, %v", v, ok)
+	}
+	if !c.Delete(1) || c.Len() != 1 {
+		t.Errorf("after Delete(1) len %d", c.Len())
+	}
+	if got := c.Keys(); !slices.Equal(got, []int{3}) {
+		t.Errorf("keys %v", got)
+	}
+	st := c.Stats()
+	if st.Hits != 1 || st.Evictions != 1 || st.Size != 1 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestGenericMissReturnsZeroValue(t *testing.T) {
+	ints := NewLRUCache[string, int](1)
+	if v, ok := ints.Get("a"); ok || v != 0 {
+		t.Errorf("int miss = %d, %v", v, ok)
+	}
+	structs := NewLRUCache[int, point](1)
+	if v, ok := structs.Get(7); ok || v.X != 0 || v.Tags != nil {
+		t.Errorf("struct miss = %+v, %v", v, ok)
+	}
+	ptrs := NewLRUCache[point2, *point](1)
+	if v, ok := ptrs.Get(point2{1, 2}); ok || v != nil {
+		t.Errorf("pointer miss = %v, %v", v, ok)
+	}
+	ptrs.Put(point2{1, 2}, &point{X: 9})
+	if v, ok := ptrs.Get(point2{1, 2}); !ok || v.X != 9 {
+		t.Errorf("struct key hit = %v, %v", v, ok)
+	}
+}
+
+// point2 is a comparable struct, for struct keys.
+type point2 struct{ X, Y int }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GetAny reads without the cache's lock, from an index of the entries kept
+// beside the map once the first GetAny has built it. Each writer, holding
+// the lock, publishes the entry it changed to the index as an immutable
+// record: a value and an expiry. A removal withdraws the key's record, and
+// a write swaps in a new one through the key's atomic pointer, so once
+// built the index's map only changes when keys come and go.
+//
+// The consistency model is therefore this. A GetAny returns the value of
+// some write that completed before it returned, and, on a hit, that write
+// completed after any removal or overwrite which finished before the
+// GetAny started; but a write or removal that overlaps it may or may not
+// be seen, a value overwritten or removed a moment ago may be returned, and
+// two GetAnys of different keys may see changes in different orders. Reads
+// from one goroutine of one key never go back in time. An entry past its
+// TTL is missing, by the clock of the GetAny, which is read without the
+// lock, so SetClock must not run alongside GetAny; but max idle time, grace
+// periods and read-only mode are ignored, the second level of a tiered
+// cache is not looked in, and the backing store is not asked. A GetAny
+// counts as a hit or a miss in Stats, but never promotes the entry, marks
+// it used or feeds the admission filter, so it has no say in eviction.
+
+// anyRecord is what GetAny returns for a key. It is never modified.
+type anyRecord[V any] struct {
+	value    V
+	expireAt time.Time
+}
+
+// anyIndex maps each key to the pointer to its current record.
+type anyIndex[K comparable, V any] struct {
+	m sync.Map // K -> *atomic.Pointer[anyRecord[V]]
+}
+
+// GetAny returns the value for key, if the cache holds one, without
+// locking the cache: it may return a value just removed or overwritten,
+// and it never changes the entry's recency. See the consistency model
+// above.
+func (c *LRUCache[K, V]) GetAny(key K) (V, bool) {
+	key = c.normalize(key)
+	ix := c.anyIndex.Load()
+	if ix == nil {
+		ix = c.buildAnyIndex()
+	}
+	var zero V
+	slot, ok := ix.m.Load(key)
+	if !ok {
+		c.miss()
+		return zero, false
+	}
+	rec := slot.(*atomic.Pointer[anyRecord[V]]).Load()
+	if rec == nil || (!rec.expireAt.IsZero() && !c.now().Before(rec.expireAt)) {
+		c.miss()
+		return zero, false
+	}
+	c.hit()
+	return rec.value, true
+}
+
+// buildAnyIndex publishes every entry to a new index, which the writers
+// keep up to date from then on.
+func (c *LRUCache[K, V]) buildAnyIndex() *anyIndex[K, V] {
+	c.lock()
+	defer c.unlock()
+
+	if ix := c.anyIndex.Load(); ix != nil {
+		return ix
+	}
+	ix := &anyIndex[K, V]{}
+	c.anyIndex.Store(ix)
+	for _, node := range c.cache {
+		c.share(node)
+	}
+	return ix
+}
+
+// share publishes node's value and expiry to the GetAny index, if there is
+// one. Every change to either calls it with the lock held.
+func (c *LRUCache[K, V]) share(node *Node[K, V]) {
+	ix := c.anyIndex.Load()
+	if ix == nil {
+		return
+	}
+	rec := &anyRecord[V]{value: node.value, expireAt: node.expireAt}
+	if slot, ok := ix.m.Load(node.key); ok {
+		slot.(*atomic.Pointer[anyRecord[V]]).Store(rec)
+		return
+	}
+	slot := new(atomic.Pointer[anyRecord[V]])
+	slot.Store(rec)
+	ix.m.Store(node.key, slot)
+}
+
+// unshare withdraws key from the GetAny index, if there is one, as it
+// leaves the map.
+func (c *LRUCache[K, V]) unshare(key K) {
+	ix := c.anyIndex.Load()
+	if ix == nil {
+		return
+	}
+	if slot, ok := ix.m.LoadAndDelete(key); ok {
+		slot.(*atomic.Pointer[anyRecord[V]]).Store(nil)
+	}
+}
+
+// unshareAll empties the GetAny index when the map is replaced wholesale.
+func (c *LRUCache[K, V]) unshareAll() {
+	if ix := c.anyIndex.Load(); ix != nil {
+		ix.m.Range(func(key, slot any) bool {
+			c.unshare(key.(K))
+			return true
+		})
+	}
+}
+
+// cmdGetany reads a key with GetAny.
+func (s *session) cmdGetany(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, ok := s.cache.GetAny(parts[1])
+	if !ok {
+		out.Null()
+		return
+	}
+	out.Value(value.String())
+}
+
+// contentionReaders and contentionWriters are the goroutines of each kind
+// runContention starts.
+const (
+	contentionReaders = 32
+	contentionWriters = 2
+)
+
+// runContention fills a new cache of capacity entries and reads random
+// keys of a keyspace twice that size with read from contentionReaders
+// goroutines, while contentionWriters put and remove them, for d. It
+// returns the reads per second.
+func runContention(capacity int, read func(cache *LRUCache[string, Value], key string), d time.Duration) float64 {
+	cache := NewLRUCache[string, Value](capacity)
+	keys := make([]string, max(capacity*2, 128))
+	for i := range keys {
+		keys[i] = "k" + strconv.Itoa(i)
+		cache.Put(keys[i], StringValue(keys[i]))
+	}
+	var (
+		stop  atomic.Bool
+		reads atomic.Int64
+		wg    sync.WaitGroup
+	)
+	for g := range contentionReaders + contentionWriters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(g)))
+			n := int64(0)
+			for ; !stop.Load(); n++ {
+				key := keys[rng.Intn(len(keys))]
+				switch {
+				case g < contentionReaders:
+					read(cache, key)
+				case rng.Intn(10) == 0:
+					cache.Remove(key)
+				default:
+					cache.Put(key, StringValue(key))
+				}
+			}
+			if g < contentionReaders {
+				reads.Add(n)
+			}
+		}()
+	}
+	time.Sleep(d)
+	stop.Store(true)
+	wg.Wait()
+	return float64(reads.Load()) / d.Seconds()
+}
+
+// benchContention compares the read throughput of GET and GETANY under
+// contention, for seconds each, on caches of the session cache's capacity
+// but without its handlers.
+func (s *session) benchContention(out *reply, arg string) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || !(seconds > 0 && seconds <= 60) {
+		out.Errorf(CodeInvalid, "Invalid seconds: %s", arg)
+		return
+	}
+	d := time.Duration(seconds * float64(time.Second))
+	capacity := s.cache.Capacity()
+	get := runContention(capacity, func(cache *LRUCache[string, Value], key string) { cache.Get(key) }, d)
+	getAny := runContention(capacity, func(cache *LRUCache[string, Value], key string) { cache.GetAny(key) }, d)
+	out.OKWith(fmt.Sprintf("readers=%d writers=%d get_per_sec=%.0f getany_per_sec=%.0f speedup=%.2f",
+		contentionReaders, contentionWriters, get, getAny, getAny/get),
+		map[string]any{"readers": contentionReaders, "writers": contentionWriters, "get_per_sec": get, "getany_per_sec": getAny})
+}
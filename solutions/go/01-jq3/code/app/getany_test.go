@@ -0,0 +1,192 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetAnyDoesNotPromote(t *testing.T) {
+	c := NewLRUCache[string, int](2)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	if v, ok := c.GetAny("a"); !ok || v != 1 {
+		t.Fatalf("GetAny(a) = %d, %v", v, ok)
+	}
+	if _, ok := c.GetAny("missing"); ok {
+		t.Error("GetAny(missing) hit")
+	}
+	c.Put("c", 3)
+	if c.Contains("a") {
+		t.Errorf("keys %v: GetAny promoted a", c.Keys())
+	}
+	if st := c.Stats(); st.Hits != 1 || st.Misses != 1 {
+		t.Errorf("hits %d, misses %d; want 1 and 1", st.Hits, st.Misses)
+	}
+}
+
+func TestGetAnyFollowsEveryWrite(t *testing.T) {
+	c, clock := clockCache(3)
+	// Build the index first, so that what follows keeps it up to date.
+	c.GetAny("")
+	check := func(step string) {
+		t.Helper()
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			want, wantOK := c.Peek(key)
+			if got, ok := c.GetAny(key); ok != wantOK || got != want {
+				t.Errorf("after %s: GetAny(%s) = %d, %v; Peek gives %d, %v", step, key, got, ok, want, wantOK)
+			}
+		}
+	}
+	for _, step := range []struct {
+		name string
+		fn   func()
+	}{
+		{"Put", func() { c.Put("a", 1); c.Put("b", 2) }},
+		{"overwrite", func() { c.Put("a", 10) }},
+		{"Rename", func() { c.Rename("b", "c") }},
+		{"Copy", func() { c.Copy("a", "d") }},
+		{"eviction", func() { c.Put("e", 5); c.Put("b", 2) }},
+		{"Remove", func() { c.Remove("b") }},
+		{"TTL", func() { c.PutWithTTL("b", 20, time.Second) }},
+		{"expiry", func() { clock.Advance(2 * time.Second) }},
+		{"Clear", func() { c.Clear() }},
+	} {
+		step.fn()
+		check(step.name)
+	}
+}
+
+func TestGetAnyBuildsIndexFromExistingEntries(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	for i, key := range numbered("k", 10) {
+		c.Put(key, i)
+	}
+	for i, key := range numbered("k", 10) {
+		if v, ok := c.GetAny(key); !ok || v != i {
+			t.Errorf("GetAny(%s) = %d, %v", key, v, ok)
+		}
+	}
+}
+
+func TestGetAnyUnderConcurrentWrites(t *testing.T) {
+	const keys = 16
+	c := NewLRUCache[string, int](keys)
+	var stop atomic.Bool
+	var writers, readers sync.WaitGroup
+	for w := range 2 {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			// Each writer owns half the keys and writes each an
+			// increasing count, removing it now and then.
+			for n := 1; n <= 2000; n++ {
+				key := "k" + strconv.Itoa(w+2*(n%(keys/2)))
+				if n%7 == 0 {
+					c.Remove(key)
+				} else {
+					c.Put(key, n)
+				}
+			}
+		}()
+	}
+	for range 8 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			last := map[string]int{}
+			for !stop.Load() {
+				for i := range keys {
+					key := "k" + strconv.Itoa(i)
+					v, ok := c.GetAny(key)
+					if !ok {
+						continue
+					}
+					if v < last[key] {
+						t.Errorf("GetAny(%s) went back from %d to %d", key, last[key], v)
+						return
+					}
+					last[key] = v
+				}
+			}
+		}()
+	}
+	writers.Wait()
+	stop.Store(true)
+	readers.Wait()
+	for i := range keys {
+		key := "k" + strconv.Itoa(i)
+		want, wantOK := c.Peek(key)
+		if got, ok := c.GetAny(key); ok != wantOK || got != want {
+			t.Errorf("once quiet, GetAny(%s) = %d, %v; Peek gives %d, %v", key, got, ok, want, wantOK)
+		}
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetAnyCommand(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 2", "OK",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"GETANY a", "1",
+		"GETANY zz", "NULL",
+		"PUT c 3", "OK",
+		"GETANY a", "NULL",
+	)
+}
+
+// BenchmarkGetAnyVsGet reads with 32 goroutines while 2 more write, with
+// GET taking the lock and GETANY not.
+func BenchmarkGetAnyVsGet(b *testing.B) {
+	const capacity = 1 << 12
+	keys := numbered("k", capacity)
+	for _, tc := range []struct {
+		name string
+		get  func(c *LRUCache[string, int], key string) (int, bool)
+	}{
+		{"Get", (*LRUCache[string, int]).Get},
+		{"GetAny", (*LRUCache[string, int]).GetAny},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			c := NewLRUCache[string, int](capacity)
+			for i, key := range keys {
+				c.Put(key, i)
+			}
+			c.GetAny(keys[0])
+			var stop atomic.Bool
+			var writers sync.WaitGroup
+			for w := range 2 {
+				writers.Add(1)
+				go func() {
+					defer writers.Done()
+					for i := w; !stop.Load(); i += 2 {
+						c.Put(keys[i*7919%capacity], i)
+					}
+				}()
+			}
+			const readers = 32
+			per := b.N/readers + 1
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for r := range readers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range per {
+						tc.get(c, keys[(r*per+i)*31%capacity])
+					}
+				}()
+			}
+			wg.Wait()
+			b.StopTimer()
+			stop.Store(true)
+			writers.Wait()
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import "container/list"
+
+// ghostList is a bounded FIFO of keys remembered after their entries left
+// the cache. Policies such as 2Q and ARC use it to recognise keys that come
+// back soon after eviction. It stores keys only, never values.
+type ghostList[K comparable] struct {
+	limit int
+	order *list.List // front is the most recently added key
+	index map[K]*list.Element
+}
+
+func newGhostList[K comparable](limit int) *ghostList[K] {
+	return &ghostList[K]{
+		limit: limit,
+		order: list.New(),
+		index: make(map[K]*list.Element),
+	}
+}
+
+func (g *ghostList[K]) contains(key K) bool {
+	_, ok := g.index[key]
+	return ok
+}
+
+func (g *ghostList[K]) len() int {
+	return g.order.Len()
+}
+
+// push remembers key as the most recent ghost, dropping the oldest ghosts if
+// the list grows past its limit.
+func (g *ghostList[K]) push(key K) {
+	if elem, ok := g.index[key]; ok {
+		g.order.MoveToFront(elem)
+		return
+	}
+	g.index[key] = g.order.PushFront(key)
+	g.trim()
+}
+
+func (g *ghostList[K]) remove(key K) bool {
+	elem, ok := g.index[key]
+	if !ok {
+		return false
+	}
+	g.order.Remove(elem)
+	delete(g.index, key)
+	return true
+}
+
+// removeOldest drops the oldest ghost and reports whether there was one.
+func (g *ghostList[K]) removeOldest() bool {
+	elem := g.order.Back()
+	if elem == nil {
+		return false
+	}
+	g.order.Remove(elem)
+	delete(g.index, elem.Value.(K))
+	return true
+}
+
+func (g *ghostList[K]) setLimit(limit int) {
+	g.limit = limit
+	g.trim()
+}
+
+func (g *ghostList[K]) trim() {
+	for g.order.Len() > g.limit {
+		g.removeOldest()
+	}
+}
+
+func (g *ghostList[K]) reset() {
+	g.order.Init()
+	g.index = make(map[K]*list.Element)
+}
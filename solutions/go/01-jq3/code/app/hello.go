@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// protocolVersions lists the versions of the line protocol a stream can
+// switch to with HELLO, oldest first. Every stream starts at 1, the
+// protocol as it was before HELLO, so clients that never send it see no
+// change; HELP HELLO says what 2 does differently.
+var protocolVersions = []int{1, 2}
+
+// features lists what a stream can use, for HELLO: what the binary has, as
+// the commands it registered show, and what the flags turned on. remote is
+// set for a TCP connection, which alone can authenticate, subscribe and
+// replicate.
+func (s *session) features(remote bool) []string {
+	var list []string
+	has := func(feature string, ok bool) {
+		if ok {
+			list = append(list, feature)
+		}
+	}
+	has("ttl", commandIndex["EXPIRE"] != nil)
+	has("json", commandIndex["MODE"] != nil)
+	has("pipeline", true)
+	has("binary", commandIndex["PUTRAW"] != nil)
+	has("auth", remote && s.password != "")
+	has("subscribe", remote && commandIndex["SUBSCRIBE"] != nil)
+	has("replication", remote && commandIndex["SYNC"] != nil)
+	has("http", s.servesHTTP)
+	has("aof", s.aof != nil)
+	has("backing", s.backing != nil)
+	has("readonly", s.refusal() != "")
+	return list
+}
+
+// cmdHello reports the stream's protocol version and features, or switches
+// the stream to another version.
+func (s *session) cmdHello(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) == 2 {
+		version, err := strconv.Atoi(parts[1])
+		if err != nil || !slices.Contains(protocolVersions, version) {
+			out.Errorf(CodeUnsupported, "Unsupported protocol version: %s", parts[1])
+			return
+		}
+		out.protocol = version
+		out.legacyErrors = version == 1 && s.legacyErrors
+	}
+	versions := make([]string, len(protocolVersions))
+	for i, v := range protocolVersions {
+		versions[i] = strconv.Itoa(v)
+	}
+	features := s.features(out.remote)
+	out.Result(fmt.Sprintf("HELLO protocol=%d versions=%s features=%s version=%s",
+		out.protocol, strings.Join(versions, ","), strings.Join(features, ","), Version),
+		map[string]any{"protocol": out.protocol, "versions": protocolVersions, "features": features, "version": Version})
+}
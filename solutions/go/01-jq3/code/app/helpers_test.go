@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain drops the lines the cache writes to stderr, such as EVICT, so
+// that they do not bury the test output; captureStderr reads them. With
+// runMainEnv set it runs main instead, for runMain.
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	stderrLines.w = bufio.NewWriter(io.Discard)
+	os.Exit(m.Run())
+}
+
+// captureStderr collects what is written to stderr until the test ends.
+func captureStderr(t testing.TB) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	stderrLines.mu.Lock()
+	old := stderrLines.w
+	stderrLines.w = bufio.NewWriter(buf)
+	stderrLines.mu.Unlock()
+	t.Cleanup(func() {
+		stderrLines.mu.Lock()
+		stderrLines.w = old
+		stderrLines.mu.Unlock()
+	})
+	return buf
+}
+
+// syncBuffer is a bytes.Buffer safe to write from one goroutine while
+// another reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestSession returns a session with the defaults main gives it and no
+// flags set. Its caches' background work is stopped when the test ends.
+func newTestSession(t testing.TB) *session {
+	t.Helper()
+	s := &session{maxCheckpoints: 8, stopping: make(chan struct{})}
+	t.Cleanup(s.close)
+	return s
+}
+
+// script runs commands through s, checking each response. pairs alternate
+// between a command line and the exact response it should get, lines of a
+// response of several lines separated by "\n".
+func script(t testing.TB, s *session, pairs ...string) {
+	t.Helper()
+	if len(pairs)%2 != 0 {
+		t.Fatal("script has a command without a response")
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		if got := s.Execute(pairs[i]); got != pairs[i+1] {
+			t.Fatalf("%s: got %q, want %q", pairs[i], got, pairs[i+1])
+		}
+	}
+}
+
+// checkGoroutines fails the test if it ends with more goroutines running
+// than when it called checkGoroutines, once the cleanups registered after
+// it have run. Goroutines get a moment to exit.
+func checkGoroutines(t testing.TB) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Errorf("%d goroutines running, %d before the test:\n%s",
+					runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+// runMainEnv, set in the environment, makes the test binary run main.
+const runMainEnv = "LRU_CACHE_RUN_MAIN"
+
+// runMain runs the program with args and input on stdin in a process of
+// its own and returns what it wrote to stdout and its exit status.
+func runMain(t testing.TB, input string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		t.Fatal(err)
+	}
+	return string(out), cmd.ProcessState.ExitCode()
+}
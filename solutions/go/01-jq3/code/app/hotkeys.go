@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The hot key tracker counts the reads of the k keys read most often with
+// the space-saving algorithm, in memory bounded by k whatever the number of
+// keys. Each key tracked has a counter; a read of a key not tracked, once k
+// are, takes over the counter with the smallest count, inheriting that
+// count as its error, and increments it. A key's true count is therefore
+// at least its count less its error, and any key read more often than
+// total/k times is sure to be tracked.
+//
+// Counters of equal count share a bucket, and the buckets are linked in
+// increasing order of count, so finding the smallest and moving a counter
+// up one both take constant time. The tracker has its own lock, like the
+// profiler, so that it can be fed from Gets served under the cache's read
+// lock, and read without taking the cache's lock at all.
+type hotKeys[K comparable] struct {
+	mu       sync.Mutex
+	k        int
+	counters map[K]*hotCounter[K]
+	min      *hotBucket[K] // the bucket of the smallest count, nil when empty
+	total    int64         // reads counted since the tracker started
+}
+
+type hotCounter[K comparable] struct {
+	key        K
+	count, err int64
+	bucket     *hotBucket[K]
+	prev, next *hotCounter[K] // within the bucket, oldest first
+}
+
+type hotBucket[K comparable] struct {
+	count      int64
+	prev, next *hotBucket[K] // of smaller and larger count
+	head, tail *hotCounter[K]
+}
+
+// HotKey is a key the tracker counts, with its estimated read count and
+// the most by which that may exceed the true count.
+type HotKey[K comparable] struct {
+	Key   K     `json:"key"`
+	Count int64 `json:"count"`
+	Error int64 `json:"error"`
+}
+
+func newHotKeys[K comparable](k int) *hotKeys[K] {
+	return &hotKeys[K]{k: k, counters: make(map[K]*hotCounter[K], k)}
+}
+
+// add counts a read of key.
+func (h *hotKeys[K]) add(key K) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.total++
+	c, ok := h.counters[key]
+	if !ok {
+		if len(h.counters) < h.k {
+			c = &hotCounter[K]{}
+		} else {
+			// The oldest of the smallest counters goes, so a key
+			// that has just taken one over is not the next to lose it.
+			c = h.min.head
+			delete(h.counters, c.key)
+			c.err = c.count
+		}
+		c.key = key
+		h.counters[key] = c
+	}
+	h.bump(c)
+}
+
+// bump moves c up from its bucket, if it has one, to the bucket of the
+// next count, making that bucket if there is none.
+func (h *hotKeys[K]) bump(c *hotCounter[K]) {
+	from := c.bucket
+	count := c.count + 1
+	next := h.min
+	if from != nil {
+		next = from.next
+	}
+	to := next
+	if to == nil || to.count != count {
+		to = &hotBucket[K]{count: count, prev: from, next: next}
+		if next != nil {
+			next.prev = to
+		}
+		if from != nil {
+			from.next = to
+		} else {
+			h.min = to
+		}
+	}
+	if from != nil {
+		from.detach(c)
+		if from.head == nil {
+			h.unlinkBucket(from)
+		}
+	}
+	to.append(c)
+	c.count = count
+}
+
+func (h *hotKeys[K]) unlinkBucket(b *hotBucket[K]) {
+	if b.prev != nil {
+		b.prev.next = b.next
+	} else {
+		h.min = b.next
+	}
+	if b.next != nil {
+		b.next.prev = b.prev
+	}
+}
+
+func (b *hotBucket[K]) append(c *hotCounter[K]) {
+	c.bucket, c.prev, c.next = b, b.tail, nil
+	if b.tail != nil {
+		b.tail.next = c
+	} else {
+		b.head = c
+	}
+	b.tail = c
+}
+
+func (b *hotBucket[K]) detach(c *hotCounter[K]) {
+	if c.prev != nil {
+		c.prev.next = c.next
+	} else {
+		b.head = c.next
+	}
+	if c.next != nil {
+		c.next.prev = c.prev
+	} else {
+		b.tail = c.prev
+	}
+	c.bucket, c.prev, c.next = nil, nil, nil
+}
+
+// top returns the tracked keys, most read first, and the reads counted.
+// Keys of equal count are listed most recently counted first.
+func (h *hotKeys[K]) top() ([]HotKey[K], int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]HotKey[K], len(h.counters))
+	i := len(keys)
+	for b := h.min; b != nil; b = b.next {
+		for c := b.head; c != nil; c = c.next {
+			i--
+			keys[i] = HotKey[K]{Key: c.key, Count: c.count, Error: c.err}
+		}
+	}
+	return keys, h.total
+}
+
+// count returns key's estimated read count, if it is tracked.
+func (h *hotKeys[K]) count(key K) (int64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if c, ok := h.counters[key]; ok {
+		return c.count, true
+	}
+	return 0, false
+}
+
+// SetHotKeys starts tracking the k keys read most often, replacing any
+// tracker already running, or stops tracking if k is not positive. Every
+// Get and call built on it, hit or miss, counts as a read from then on.
+func (c *LRUCache[K, V]) SetHotKeys(k int) {
+	if k <= 0 {
+		c.hotKeys.Store(nil)
+		return
+	}
+	c.hotKeys.Store(newHotKeys[K](k))
+}
+
+// HotKeys returns the keys read most often since SetHotKeys, most read
+// first, and the number of reads counted. It returns nil if tracking is
+// off.
+func (c *LRUCache[K, V]) HotKeys() ([]HotKey[K], int64) {
+	h := c.hotKeys.Load()
+	if h == nil {
+		return nil, 0
+	}
+	return h.top()
+}
+
+// cmdHotkeys turns hot key tracking on or off, or reports the keys tracked.
+func (s *session) cmdHotkeys(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) == 1 {
+		keys, total := s.cache.HotKeys()
+		if keys == nil {
+			out.Error(CodeUnsupported, "Hot key tracking is off")
+			return
+		}
+		if len(keys) == 0 {
+			out.Result("EMPTY", keys)
+			return
+		}
+		lines := make([]string, len(keys))
+		for i, hk := range keys {
+			lines[i] = fmt.Sprintf("%d %s count=%d error=%d", i+1, quoteToken(hk.Key), hk.Count, hk.Error)
+		}
+		out.Result(strings.Join(lines, "\n"), map[string]any{"total": total, "keys": keys})
+		return
+	}
+	switch {
+	case parts[1] == "ON" && len(parts) == 3:
+		k, err := strconv.Atoi(parts[2])
+		if err != nil || k < 1 || k > maxHotKeys {
+			out.Errorf(CodeInvalid, "Invalid k: %s", parts[2])
+			return
+		}
+		s.cache.SetHotKeys(k)
+	case parts[1] == "OFF" && len(parts) == 2:
+		s.cache.SetHotKeys(0)
+	default:
+		out.Error(CodeArity, "HOTKEYS takes ON <k> or OFF")
+		return
+	}
+	out.OK()
+}
+
+// maxHotKeys bounds the k of HOTKEYS ON, and so the tracker's memory.
+const maxHotKeys = 100_000
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxHTTPBody bounds the size of a PUT request body.
+const maxHTTPBody = 1 << 20
+
+// putRequest is the body of PUT /cache/{key}. TTL is in seconds; zero or
+// absent means the entry never expires.
+type putRequest struct {
+	Value *string `json:"value"`
+	TTL   float64 `json:"ttl"`
+}
+
+// newHTTPHandler exposes the session's cache as a small REST API:
+//
+//	GET    /cache/{key}  200 {"key", "value"} or 404; a list value is {"list": [...]}
+//	                     304 if If-None-Match names its ETag
+//	PUT    /cache/{key}  204, body {"value": "...", "ttl": 30}
+//	                     412 if it has If-Match and the entry's ETag is not named
+//	DELETE /cache/{key}  204 or 404
+//	GET    /watch        200 streaming the events of keys starting with ?prefix=; see watch.go
+//	GET    /stats        200 with the cache counters, as Stats encodes them
+//	GET    /metrics      200 with the counters, gauges and latencies for Prometheus
+//	GET    /debug/vars   200 with the expvar variables, the cache's under "cache"
+//
+// Keys are taken from the rest of the path after unescaping, so an escaped
+// slash (%2F) is part of the key. GET and PUT return the value's ETag; see
+// etag.go. A server started with a password wraps the handler in
+// requireBearer.
+func newHTTPHandler(s *session) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /cache/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		ctx, cancel := s.commandContext(r.Context())
+		defer cancel()
+		value, etag, ok, err := s.cache.GetTaggedCtx(ctx, key)
+		if err == nil && !ok {
+			err = ErrNotFound
+		}
+		if err != nil {
+			writeJSONError(w, codeOf(err), err.Error())
+			return
+		}
+		setETag(w, etag)
+		if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"key": key, "value": value})
+	})
+
+	mux.HandleFunc("PUT /cache/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		if why := s.refusal(); why != "" {
+			writeJSONError(w, CodeReadOnly, "writes are not allowed "+why)
+			return
+		}
+		s.writing.RLock()
+		defer s.writing.RUnlock()
+		key := r.PathValue("key")
+		var req putRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHTTPBody)).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeJSONError(w, CodeTooLarge, "request body too large")
+				return
+			}
+			writeJSONError(w, CodeSyntax, "invalid JSON body")
+			return
+		}
+		if req.Value == nil {
+			writeJSONError(w, CodeArity, "missing value")
+			return
+		}
+		if req.TTL < 0 {
+			writeJSONError(w, CodeInvalid, "ttl must not be negative")
+			return
+		}
+		ttl := time.Duration(req.TTL * float64(time.Second))
+		value := StringValue(*req.Value)
+		var err error
+		if match := r.Header.Get("If-Match"); match != "" {
+			err = s.cache.PutIfMatch(key, value, ttl, func(etag uint64) bool { return etagMatches(match, etag) })
+		} else {
+			ctx, cancel := s.commandContext(r.Context())
+			defer cancel()
+			err = s.cache.PutCtx(ctx, key, value, ttl, 0)
+		}
+		if err != nil {
+			writeJSONError(w, codeOf(err), err.Error())
+			return
+		}
+		line := "PUT " + quoteToken(key) + " " + quoteToken(*req.Value)
+		if ttl > 0 {
+			line += " " + strconv.FormatFloat(req.TTL, 'f', -1, 64)
+		}
+		s.log(line)
+		setETag(w, value.etag())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /watch", s.serveWatch)
+
+	mux.HandleFunc("DELETE /cache/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		if why := s.refusal(); why != "" {
+			writeJSONError(w, CodeReadOnly, "writes are not allowed "+why)
+			return
+		}
+		s.writing.RLock()
+		defer s.writing.RUnlock()
+		key := r.PathValue("key")
+		removed, err := s.cache.RemoveThrough(key)
+		if err == nil && !removed {
+			err = ErrNotFound
+		}
+		if err != nil {
+			writeJSONError(w, codeOf(err), err.Error())
+			return
+		}
+		s.log("DELETE " + quoteToken(key))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		st := s.cache.Stats()
+		s.linkStats(s.cache, &st)
+		writeJSON(w, http.StatusOK, st)
+	})
+
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		// The counters are read before the gauges so that they need no
+		// lock.
+		n := s.cache.Counters()
+		st := s.cache.Stats()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, n, st)
+		writeLatencyMetrics(w, s.cache.Latency())
+	})
+
+	if expvar.Get("cache") == nil {
+		expvar.Publish("cache", expvar.Func(func() any {
+			return map[string]any{"counters": s.cache.Counters(), "stats": s.cache.Stats(), "latency": s.cache.Latency()}
+		}))
+	}
+	mux.Handle("GET /debug/vars", expvar.Handler())
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError reports a failure with the status for its code.
+func writeJSONError(w http.ResponseWriter, code ErrorCode, message string) {
+	writeJSON(w, code.httpStatus(), map[string]string{"error": message, "code": string(code)})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPStats(t *testing.T) {
+	s := newServerSession(t, 2)
+	srv := httptest.NewServer(newHTTPHandler(s))
+	defer srv.Close()
+	script(t, s,
+		"PUT a 1", "OK",
+		"PUT a 2", "OK", // replaced
+		"PUT b 2", "OK",
+		"GET a", "2",
+		"PUT c 3", "OK", // evicts b
+		"DELETE c", "OK",
+		"GET x", "NULL",
+	)
+
+	resp, err := srv.Client().Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]float64{
+		"hits": 1, "misses": 1, "evictions": 1, "expirations": 0,
+		"deleted": 1, "replaced": 1, "rejected": 0, "admission_rejected": 0,
+		"eviction_batches": 1, "size": 1, "capacity": 2, "hit_ratio": 0.5,
+	} {
+		if v, ok := got[field].(float64); !ok || v != want {
+			t.Errorf("%s = %v, want %v", field, got[field], want)
+		}
+	}
+	// Fields with nothing to say are left out rather than null.
+	for _, field := range []string{"tenants", "window", "reset_at", "max_bytes", "l2_capacity"} {
+		if v, ok := got[field]; ok {
+			t.Errorf("%s = %v, want it left out", field, v)
+		}
+	}
+	// And every field of Stats is there when it has something to say.
+	s.cache = newByteBoundedCache(1000, newLRUPolicy[string, Value](), Value.Size)
+	s.cache.Put("a", StringValue("1"))
+	resp, err = srv.Client().Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got = nil
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["max_bytes"] != 1000.0 || got["used_bytes"] == nil || got["evictions"] != 0.0 {
+		t.Errorf("max_bytes %v, used_bytes %v, evictions %v", got["max_bytes"], got["used_bytes"], got["evictions"])
+	}
+}
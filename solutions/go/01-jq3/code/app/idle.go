@@ -0,0 +1,58 @@
+package main
+
+import "time"
+
+// A cache with a max idle time treats an entry nobody has used for that
+// long as expired, whatever its TTL says. Get, Touch and writes count as
+// use; Peek, Contains and the other reads that leave recency alone do not.
+// Each entry keeps the instant it goes idle in idleAt, so everything that
+// checks expiry sees idle entries as expired too, and they are removed the
+// same ways: lazily by the operations that find them or by the janitor.
+// An idle entry has no grace period.
+
+// idle reports whether node has gone unused for the cache's max idle time.
+func (n *Node[K, V]) idle(now time.Time) bool {
+	return !n.idleAt.IsZero() && !now.Before(n.idleAt)
+}
+
+// expiry returns the reason to give for removing an expired node: idle if
+// it went idle before its TTL ran out, or has no TTL, and expired
+// otherwise.
+func (n *Node[K, V]) expiry(now time.Time) RemovalReason {
+	if n.idle(now) && (n.expireAt.IsZero() || n.idleAt.Before(n.expireAt)) {
+		return RemovalIdle
+	}
+	return RemovalExpired
+}
+
+// used records that node was used at now, restarting its idle time.
+func (c *LRUCache[K, V]) used(node *Node[K, V], now time.Time) {
+	node.accessedAt = now
+	if c.maxIdle > 0 {
+		node.idleAt = now.Add(c.maxIdle)
+	}
+}
+
+// SetMaxIdle sets how long an entry may go unused before it is treated as
+// expired; 0 turns the limit off. Entries already cached are measured from
+// when they were last used, so some may be idle at once.
+func (c *LRUCache[K, V]) SetMaxIdle(d time.Duration) {
+	c.lock()
+	defer c.unlock()
+
+	c.maxIdle = max(d, 0)
+	for _, node := range c.resident {
+		if c.maxIdle > 0 {
+			node.idleAt = node.accessedAt.Add(c.maxIdle)
+		} else {
+			node.idleAt = time.Time{}
+		}
+	}
+}
+
+// MaxIdle returns the max idle time, or 0 if there is none.
+func (c *LRUCache[K, V]) MaxIdle() time.Duration {
+	c.rlock()
+	defer c.mu.RUnlock()
+	return c.maxIdle
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInitRejectsBadCapacity(t *testing.T) {
+	s := newTestSession(t)
+	for _, tc := range []struct{ line, want string }{
+		{"INIT 0", "ERROR ERR_BAD_CAPACITY capacity must be >= 1"},
+		{"INIT -3", "ERROR ERR_BAD_CAPACITY capacity must be >= 1"},
+		{"INIT x", `ERROR ERR_BAD_CAPACITY Invalid capacity: strconv.Atoi: parsing "x": invalid syntax`},
+		{"INIT", "ERROR ERR_ARITY INIT requires capacity argument"},
+	} {
+		script(t, s, tc.line, tc.want)
+		if s.cache != nil {
+			t.Fatalf("%s created a cache", tc.line)
+		}
+	}
+	script(t, s, "INIT 2", "OK", "PUT a 1", "OK")
+
+	// A bad INIT leaves the cache there is alone.
+	script(t, s, "INIT 0", "ERROR ERR_BAD_CAPACITY capacity must be >= 1", "GET a", "1", "CAPACITY", "2")
+}
+
+func TestNewLRUCachePanicsOnBadCapacity(t *testing.T) {
+	for _, capacity := range []int{0, -3} {
+		func() {
+			defer func() {
+				if p := recover(); p == nil || !strings.Contains(p.(string), "capacity must be >= 1") {
+					t.Errorf("NewLRUCache(%d) panicked with %v", capacity, p)
+				}
+			}()
+			NewLRUCache[string, string](capacity)
+		}()
+	}
+}
+
+func TestReinitStopsJanitor(t *testing.T) {
+	checkGoroutines(t)
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 2", "OK",
+		"JANITOR ON 1", "OK",
+		"PUT a 1", "OK",
+	)
+	old := s.cache
+	script(t, s, "INIT 3", "OK reinitialized dropped=1", "SIZE", "0")
+	old.lock()
+	running := old.janitor != nil
+	old.unlock()
+	if running {
+		t.Error("the janitor of the replaced cache is still running")
+	}
+}
+
+func TestInitMigrateKeepsRecentEntries(t *testing.T) {
+	s := newClockSession(t)
+	script(t, s,
+		"INIT 5", "OK",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"PUT c 3", "OK",
+		"PUT d 4", "OK",
+		"PUT e 5 100", "OK",
+		"PIN a", "1",
+		"GET b", "2",
+		"INIT 3 MIGRATE", "OK reinitialized migrated=3 dropped=2",
+		"DEBUG DUMP", "capacity=3 policy=LRU size=3\n"+
+			"0 a 1 pinned\n"+
+			"1 b 2\n"+
+			"2 e 5",
+		"DEBUG CHECK", "OK",
+	)
+	if info, ok := s.cache.EntryInfo("e"); !ok || info.TTL != 100*time.Second {
+		t.Errorf("e info %+v, %v: want its TTL of 100s kept", info, ok)
+	}
+	// e is next to go after b; the pinned a stays.
+	script(t, s, "PUT f 6", "OK", "EXISTS e", "0", "EXISTS a", "1")
+}
+
+func TestInitMigrateAcrossPolicies(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 3", "OK",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"GET a", "1",
+		"GET a", "1",
+		"INIT 3 LFU MIGRATE", "OK reinitialized migrated=2 dropped=0",
+		// The old frequencies are not carried over: both start at 1.
+		"DEBUG DUMP", "capacity=3 policy=LFU size=2\n"+
+			"0 a 1 freq=1\n"+
+			"1 b 2 freq=1",
+		"INIT 2 MIGRATE", "OK reinitialized migrated=2 dropped=0",
+		"INIT 2", "OK reinitialized dropped=2",
+		"INIT 2 MIGRATE", "OK reinitialized migrated=0 dropped=0",
+	)
+}
+
+func TestInitMigrateStopsOldWorkers(t *testing.T) {
+	checkGoroutines(t)
+	s := newTestSession(t)
+	source := filepath.Join(t.TempDir(), "source")
+	if err := os.WriteFile(source, []byte("a=2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	script(t, s,
+		"INIT 3", "OK",
+		"JANITOR ON 1", "OK",
+		"REFRESHSOURCE "+source, "OK",
+		"PUT a 1 100", "OK",
+	)
+	if runtime.NumGoroutine() <= before {
+		t.Fatal("JANITOR and REFRESHSOURCE started no goroutines")
+	}
+	old := s.cache
+	script(t, s, "INIT 3 MIGRATE", "OK reinitialized migrated=1 dropped=0", "GET a", "1")
+
+	old.lock()
+	running := old.janitor != nil || old.refresher != nil
+	old.unlock()
+	if running {
+		t.Error("the workers of the migrated-from cache are still running")
+	}
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= before })
+}
+
+func TestInitOptionsWithoutPolicy(t *testing.T) {
+	s := newClockSession(t)
+	script(t, s,
+		"INIT 2 NOEVICT", "OK",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"PUT c 3", "ERROR ERR_FULL cache is full",
+
+		"INIT 5 IDLE 3", "OK reinitialized dropped=2",
+		"PUT a 1", "OK",
+		"DEBUG ADVANCECLOCK 3", "OK",
+		"GET a", "NULL",
+
+		"INIT 4 WATERMARK 2", "OK reinitialized dropped=0",
+		"MPUT a 1 b 2 c 3 d 4", "OK 4",
+		"PUT e 5", "OK",
+		"SIZE", "2",
+
+		"INIT 3 NORM lower", "OK reinitialized dropped=2",
+		"PUT A 1", "OK",
+		"GET a", "1",
+
+		"INIT 3 TINYLFU", "OK reinitialized dropped=1",
+	)
+	if !s.cache.Admission() {
+		t.Error("INIT 3 TINYLFU left admission off")
+	}
+	// The options mix with a policy that comes after them, and with each
+	// other.
+	script(t, s,
+		"INIT 2 NOEVICT IDLE 3 LFU", "OK reinitialized dropped=0",
+		"DEBUG DUMP", "capacity=2 policy=LFU size=0",
+	)
+	if !s.cache.NoEviction() || s.cache.MaxIdle() != 3*time.Second {
+		t.Errorf("noevict %v, max idle %v", s.cache.NoEviction(), s.cache.MaxIdle())
+	}
+}
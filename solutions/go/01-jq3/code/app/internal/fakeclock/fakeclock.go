@@ -0,0 +1,42 @@
+// Package fakeclock provides a clock that only moves when told to, for
+// driving TTL behaviour deterministically: in tests, and under the cache's
+// --test-clock flag.
+package fakeclock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a clock that only moves when Advance or Set is called. It is
+// safe for concurrent use.
+type Clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// New returns a clock set to start.
+func New(start time.Time) *Clock {
+	return &Clock{t: start}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// Set moves the clock to t, which may be in its past.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
@@ -0,0 +1,101 @@
+package main
+
+import "time"
+
+// sweepChunk is the number of entries a sweep examines per lock
+// acquisition, which bounds how long it can hold up other operations.
+const sweepChunk = 64
+
+// maxSweepRounds bounds the chunks examined on one tick so a cache full of
+// expired entries is cleaned over several ticks rather than in one burst.
+const maxSweepRounds = 64
+
+// janitor is a goroutine that removes expired entries in the background.
+// Closing stop asks it to exit and it closes done once it has.
+type janitor struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartJanitor starts a background goroutine that removes expired entries
+// every interval, replacing any janitor already running. Without one,
+// expired entries are only removed when an operation touches them.
+//
+// Each tick samples entries sweepChunk at a time, each chunk under one
+// short lock acquisition, starting from a random point of the map as Go's
+// map iteration does. It keeps going while at least a quarter of a chunk
+// had expired, so a cache with many expired entries is cleaned quickly and
+// one with few costs a single chunk per tick. Removals count as
+// expirations. A panic in a sweep is logged and the next tick sweeps
+// again.
+func (c *LRUCache[K, V]) StartJanitor(interval time.Duration) {
+	c.StopJanitor()
+	j := &janitor{stop: make(chan struct{}), done: make(chan struct{})}
+	c.lock()
+	c.janitor = j
+	c.unlock()
+
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				c.protect("janitor", c.sweep)
+			}
+		}
+	}()
+}
+
+// StopJanitor stops the janitor, if one is running, and waits for its
+// goroutine to exit.
+func (c *LRUCache[K, V]) StopJanitor() {
+	c.lock()
+	j := c.janitor
+	c.janitor = nil
+	c.unlock()
+	if j != nil {
+		close(j.stop)
+		<-j.done
+	}
+}
+
+func (c *LRUCache[K, V]) sweep() {
+	for range maxSweepRounds {
+		if c.sweepTombstones(sweepChunk) < sweepChunk {
+			break
+		}
+	}
+	for range maxSweepRounds {
+		examined, removed := c.sweepOnce(sweepChunk)
+		if examined == 0 || removed*4 < examined {
+			return
+		}
+	}
+}
+
+// sweepOnce examines up to n entries and removes the expired ones,
+// returning how many it looked at and how many it removed.
+func (c *LRUCache[K, V]) sweepOnce(n int) (examined, removed int) {
+	c.lock()
+	defer c.unlock()
+
+	if c.readOnly {
+		return 0, 0
+	}
+	now := c.now()
+	for _, node := range c.cache {
+		if examined == n {
+			break
+		}
+		examined++
+		if node.gone(now) {
+			c.remove(node, node.expiry(now))
+			removed++
+		}
+	}
+	return examined, removed
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/systemquest/lru-cache-starter-go/app/internal/fakeclock"
+)
+
+// expiringCache returns a cache on a fake clock holding n entries that
+// expire after a second and n that never do.
+func expiringCache(t *testing.T, n int) (*LRUCache[string, int], *fakeclock.Clock) {
+	t.Helper()
+	clock := fakeclock.New(time.Unix(0, 0))
+	c := NewLRUCache[string, int](2 * n)
+	c.SetClock(clock)
+	for i := range n {
+		c.PutWithTTL("t"+strconv.Itoa(i), i, time.Second)
+		c.Put("p"+strconv.Itoa(i), i)
+	}
+	return c, clock
+}
+
+func TestSweepRemovesExpired(t *testing.T) {
+	c, clock := expiringCache(t, 500)
+	var mu sync.Mutex
+	reasons := map[RemovalReason]int{}
+	c.SetRemovalHandler(func(key string, _ int, reason RemovalReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		reasons[reason]++
+	})
+
+	c.sweep()
+	if c.Size() != 1000 {
+		t.Fatalf("a sweep before expiry removed entries: size %d", c.Size())
+	}
+	clock.Advance(2 * time.Second)
+	for c.Size() > 500 {
+		c.sweep()
+	}
+	if c.Size() != 500 || c.Stats().Expirations != 500 {
+		t.Errorf("stats %+v", c.Stats())
+	}
+	mu.Lock()
+	if reasons[RemovalExpired] != 500 || len(reasons) != 1 {
+		t.Errorf("removal reasons %v, want 500 expired", reasons)
+	}
+	mu.Unlock()
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSweepOnceIsBounded(t *testing.T) {
+	c, clock := expiringCache(t, 500)
+	clock.Advance(2 * time.Second)
+	examined, removed := c.sweepOnce(sweepChunk)
+	if examined != sweepChunk || removed > examined {
+		t.Errorf("sweepOnce examined %d and removed %d, want %d examined", examined, removed, sweepChunk)
+	}
+	if c.Size() != 1000-removed {
+		t.Errorf("size %d after removing %d", c.Size(), removed)
+	}
+}
+
+func TestJanitorSweepsInBackground(t *testing.T) {
+	checkGoroutines(t)
+	c, clock := expiringCache(t, 100)
+	clock.Advance(2 * time.Second)
+	c.StartJanitor(time.Millisecond)
+	defer c.StopJanitor()
+	waitFor(t, func() bool { return c.Size() == 100 })
+}
+
+func TestJanitorStopDoesNotLeak(t *testing.T) {
+	checkGoroutines(t)
+	c := NewLRUCache[string, int](10)
+	for range 50 {
+		c.StartJanitor(time.Millisecond)
+	}
+	c.StopJanitor()
+	c.StopJanitor()
+
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 2", "OK",
+		"JANITOR ON 1", "OK",
+		"JANITOR ON 1", "OK",
+		"JANITOR OFF", "OK",
+		"JANITOR OFF", "OK",
+		"JANITOR ON 1", "OK",
+		"JANITOR ON 0", "ERROR ERR_INVALID Invalid interval: 0",
+	)
+	// The last janitor is stopped with the session, before
+	// checkGoroutines looks.
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrBadJitter is returned by SetTTLJitter for a fraction outside [0, 1).
+var ErrBadJitter = errors.New("ttl jitter must be a fraction from 0 up to but not including 1")
+
+// SetTTLJitter spreads the deadlines of entries given the same TTL, so that
+// a burst of writes does not expire, and send its readers to the backing
+// store, all at once. Each time a TTL is turned into a deadline, by a write,
+// Expire, Touch or a refresh, it is scaled by a factor drawn uniformly from
+// [1-fraction, 1+fraction] with the generator Seed sets, so seeded runs
+// draw the same deadlines. The entry keeps its TTL as given, so each Touch
+// draws afresh around it. ExpireAt deadlines are taken as they are. 0
+// turns jitter off.
+func (c *LRUCache[K, V]) SetTTLJitter(fraction float64) error {
+	if !(fraction >= 0 && fraction < 1) {
+		return ErrBadJitter
+	}
+	c.lock()
+	defer c.unlock()
+
+	c.ttlJitter = fraction
+	return nil
+}
+
+// TTLJitter returns the fraction set by SetTTLJitter.
+func (c *LRUCache[K, V]) TTLJitter() float64 {
+	c.rlock()
+	defer c.mu.RUnlock()
+	return c.ttlJitter
+}
+
+// jitter returns ttl scaled by a random factor within the jitter fraction
+// of 1. The caller holds the write lock.
+func (c *LRUCache[K, V]) jitter(ttl time.Duration) time.Duration {
+	if c.ttlJitter == 0 {
+		return ttl
+	}
+	factor := 1 + c.ttlJitter*(2*c.rng.Float64()-1)
+	return max(time.Duration(float64(ttl)*factor), 1)
+}
+
+// cmdJitter sets the TTL jitter of the current cache.
+func (s *session) cmdJitter(in *bufio.Reader, out *reply, line string, parts []string) {
+	fraction, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid jitter: %s", parts[1])
+		return
+	}
+	if err := s.cache.SetTTLJitter(fraction); err != nil {
+		out.Err(err)
+		return
+	}
+	out.OK()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLJitterSpreadsExpiries(t *testing.T) {
+	const n, ttl, fraction = 200, 100 * time.Second, 0.2
+	lo, hi := time.Duration(float64(ttl)*(1-fraction)), time.Duration(float64(ttl)*(1+fraction))
+	c, clock := clockCache(n)
+	c.Seed(1)
+	if err := c.SetTTLJitter(fraction); err != nil {
+		t.Fatal(err)
+	}
+	keys := numbered("k", n)
+	for _, k := range keys {
+		c.PutWithTTL(k, 1, ttl)
+	}
+
+	deadlines := map[time.Duration]bool{}
+	for _, k := range keys {
+		left, _ := c.TTL(k)
+		if left < lo || left > hi {
+			t.Errorf("TTL(%s) = %v, outside [%v, %v]", k, left, lo, hi)
+		}
+		deadlines[left] = true
+	}
+	if len(deadlines) < n/2 {
+		t.Errorf("%d keys written together drew only %d deadlines", n, len(deadlines))
+	}
+
+	// Nothing goes before the window opens and everything has gone once it
+	// closes; in between, the keys go a few at a time.
+	live := func() int {
+		count := 0
+		for _, k := range keys {
+			if c.Contains(k) {
+				count++
+			}
+		}
+		return count
+	}
+	start := clock.Now()
+	var counts []int
+	for _, at := range []time.Duration{lo - time.Nanosecond, lo + (hi-lo)/4, lo + (hi-lo)/2, hi - (hi-lo)/4, hi} {
+		clock.Set(start.Add(at))
+		counts = append(counts, live())
+	}
+	if counts[0] != n || counts[len(counts)-1] != 0 {
+		t.Fatalf("live keys across the window %v, want %d then 0", counts, n)
+	}
+	for i := 1; i < len(counts)-1; i++ {
+		if !(counts[i] < counts[i-1] && counts[i] > 0) {
+			t.Errorf("live keys across the window %v, want them to fall steadily", counts)
+			break
+		}
+	}
+
+	// Without jitter they all share one deadline.
+	c.SetTTLJitter(0)
+	for _, k := range keys {
+		c.PutWithTTL(k, 1, ttl)
+	}
+	first, _ := c.TTL(keys[0])
+	for _, k := range keys {
+		if left, _ := c.TTL(k); left != first {
+			t.Fatalf("TTL(%s) = %v without jitter, want %v like %s", k, left, first, keys[0])
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// With --key-index, or SetKeyIndex, a cache of string keys keeps its keys
+// sorted in a skip list as well as in the map, so that KEYS with a prefix,
+// DELPREFIX and RANGEKEYS find the k keys they want in O(log n + k)
+// instead of walking every entry. Each insert and removal costs O(log n)
+// more; --key-index-bench measures both. The list holds each key in the map,
+// expired ones included until they are reaped, and no others.
+//
+// A key's height in the list is worked out from a hash of the key rather
+// than drawn at random, so the same keys always give the same list, and
+// the same memory: about 1.33 pointers a key on top of the node.
+
+// keyIndexLevels bounds the height of the list, enough for 4^16 keys.
+const keyIndexLevels = 16
+
+var errKeyIndex = errors.New("the key index needs string keys")
+
+// keyIndex is a skip list of keys in ascending order.
+type keyIndex struct {
+	head   indexNode // next has keyIndexLevels entries
+	levels int       // levels in use
+	len    int
+	// path is scratch space for insert and remove: the last node before
+	// the key at each level.
+	path [keyIndexLevels]*indexNode
+}
+
+type indexNode struct {
+	key  string
+	next []*indexNode
+}
+
+func newKeyIndex() *keyIndex {
+	ix := &keyIndex{levels: 1}
+	ix.head.next = make([]*indexNode, keyIndexLevels)
+	return ix
+}
+
+// indexLevel returns the height of key's node: one more than the number of
+// trailing pairs of zero bits in its FNV-1a hash, so each level holds about
+// a quarter of the keys of the one below.
+func indexLevel(key string) int {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 32
+	level := 1
+	for ; level < keyIndexLevels && h&3 == 0; h >>= 2 {
+		level++
+	}
+	return level
+}
+
+// seek fills path with the last node before key at each level.
+func (ix *keyIndex) seek(key string) {
+	node := &ix.head
+	for level := ix.levels - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+		ix.path[level] = node
+	}
+}
+
+// insert adds key, which must not be in the index.
+func (ix *keyIndex) insert(key string) {
+	ix.seek(key)
+	n := &indexNode{key: key, next: make([]*indexNode, indexLevel(key))}
+	for level := ix.levels; level < len(n.next); level++ {
+		ix.path[level] = &ix.head
+	}
+	ix.levels = max(ix.levels, len(n.next))
+	for level := range n.next {
+		n.next[level] = ix.path[level].next[level]
+		ix.path[level].next[level] = n
+	}
+	ix.len++
+}
+
+// remove drops key, if it is in the index.
+func (ix *keyIndex) remove(key string) {
+	ix.seek(key)
+	n := ix.path[0].next[0]
+	if n == nil || n.key != key {
+		return
+	}
+	for level := range n.next {
+		ix.path[level].next[level] = n.next[level]
+	}
+	for ix.levels > 1 && ix.head.next[ix.levels-1] == nil {
+		ix.levels--
+	}
+	ix.len--
+}
+
+// ascend calls fn for each key from the first at or after start, in
+// order, until fn returns false.
+func (ix *keyIndex) ascend(start string, fn func(key string) bool) {
+	node := &ix.head
+	for level := ix.levels - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < start {
+			node = node.next[level]
+		}
+	}
+	for node = node.next[0]; node != nil; node = node.next[0] {
+		if !fn(node.key) {
+			return
+		}
+	}
+}
+
+// check verifies that every level is in ascending order and holds only
+// keys of the one below, and that the bottom one holds len keys.
+func (ix *keyIndex) check() error {
+	for level := ix.levels - 1; level >= 0; level-- {
+		count := 0
+		below := ix.head.next[0]
+		for n := ix.head.next[level]; n != nil; n = n.next[level] {
+			if next := n.next[level]; next != nil && next.key <= n.key {
+				return fmt.Errorf("key index level %d has %q before %q", level, n.key, next.key)
+			}
+			if level > 0 {
+				for below != nil && below != n {
+					below = below.next[0]
+				}
+				if below == nil {
+					return fmt.Errorf("key index level %d holds %q, which the bottom level lacks", level, n.key)
+				}
+			}
+			count++
+		}
+		if level == 0 && count != ix.len {
+			return fmt.Errorf("key index holds %d keys but counts %d", count, ix.len)
+		}
+	}
+	return nil
+}
+
+// SetKeyIndex turns the sorted key index on or off. Turning it on builds
+// it from the keys cached, which takes O(n log n); it needs string keys.
+func (c *LRUCache[K, V]) SetKeyIndex(on bool) error {
+	var zero K
+	if _, ok := any(zero).(string); !ok {
+		return errKeyIndex
+	}
+	c.lock()
+	defer c.unlock()
+
+	if !on {
+		c.keys = nil
+		return nil
+	}
+	if c.keys == nil {
+		c.keys = newKeyIndex()
+		for key := range c.cache {
+			c.keys.insert(any(key).(string))
+		}
+	}
+	return nil
+}
+
+// indexKey adds key to the key index, if there is one.
+func (c *LRUCache[K, V]) indexKey(key K) {
+	if c.keys != nil {
+		c.keys.insert(any(key).(string))
+	}
+}
+
+// unindexKey removes key from the key index, if there is one.
+func (c *LRUCache[K, V]) unindexKey(key K) {
+	if c.keys != nil {
+		c.keys.remove(any(key).(string))
+	}
+}
+
+// resetKeyIndex empties the key index, if there is one.
+func (c *LRUCache[K, V]) resetKeyIndex() {
+	if c.keys != nil {
+		c.keys = newKeyIndex()
+	}
+}
+
+// indexedKeys returns the keys of the key index from start up to, but
+// not including, the first for which stop reports true.
+func (c *LRUCache[K, V]) indexedKeys(start string, stop func(key string) bool) []K {
+	var keys []K
+	c.keys.ascend(start, func(key string) bool {
+		if stop(key) {
+			return false
+		}
+		keys = append(keys, any(key).(K))
+		return true
+	})
+	return keys
+}
+
+// RangeKeys returns the keys from start up to, but not including, end, in
+// lexicographic order; an empty end means no upper bound. Only string keys
+// can match. Without the key index it walks every entry.
+func (c *LRUCache[K, V]) RangeKeys(start, end string) []K {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	past := func(key string) bool { return end != "" && key >= end }
+	if c.keys != nil {
+		return c.indexedKeys(start, past)
+	}
+	var keys []string
+	for key := range c.cache {
+		if s, ok := any(key).(string); ok && s >= start && !past(s) {
+			keys = append(keys, s)
+		}
+	}
+	slices.Sort(keys)
+	found := make([]K, len(keys))
+	for i, key := range keys {
+		found[i] = any(key).(K)
+	}
+	return found
+}
+
+// checkKeyIndex verifies that the key index, if there is one, is sound and
+// holds exactly the keys of the map.
+func (c *LRUCache[K, V]) checkKeyIndex() error {
+	if c.keys == nil {
+		return nil
+	}
+	if err := c.keys.check(); err != nil {
+		return err
+	}
+	if c.keys.len != len(c.cache) {
+		return fmt.Errorf("key index holds %d keys but the map has %d", c.keys.len, len(c.cache))
+	}
+	var err error
+	c.keys.ascend("", func(key string) bool {
+		if _, ok := c.cache[any(key).(K)]; !ok {
+			err = fmt.Errorf("key index holds %q, which is not cached", key)
+		}
+		return err == nil
+	})
+	return err
+}
+
+func (s *session) cmdRangekeys(in *bufio.Reader, out *reply, line string, parts []string) {
+	s.showKeys(out, s.cache.RangeKeys(parts[1], parts[2]))
+}
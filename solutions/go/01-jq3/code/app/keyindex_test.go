@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/systemquest/lru-cache-starter-go/app/internal/fakeclock"
+)
+
+func TestKeyIndexSkipList(t *testing.T) {
+	ix := newKeyIndex()
+	want := map[string]bool{}
+	rng := rand.New(rand.NewPCG(146, 1))
+	for range 5000 {
+		key := fmt.Sprintf("k%03d", rng.IntN(500))
+		if rng.IntN(3) == 0 {
+			ix.remove(key)
+			delete(want, key)
+		} else if !want[key] {
+			ix.insert(key)
+			want[key] = true
+		}
+	}
+	if err := ix.check(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	ix.ascend("k250", func(key string) bool {
+		got = append(got, key)
+		return true
+	})
+	var sorted []string
+	for key := range want {
+		if key >= "k250" {
+			sorted = append(sorted, key)
+		}
+	}
+	slices.Sort(sorted)
+	if !slices.Equal(got, sorted) {
+		t.Errorf("ascend from k250 = %d keys, want %d", len(got), len(sorted))
+	}
+	if ix.len != len(want) {
+		t.Errorf("len %d, want %d", ix.len, len(want))
+	}
+}
+
+// TestKeyIndexFollowsCache runs the same random operations on a cache with
+// the key index and one without, checking the index after every one and
+// that both answer the same to every query.
+func TestKeyIndexFollowsCache(t *testing.T) {
+	clock := fakeclock.New(time.Unix(1_000_000, 0))
+	newCache := func(indexed bool) *LRUCache[string, int] {
+		c := NewLRUCache[string, int](40)
+		c.SetClock(clock)
+		if indexed {
+			if err := c.SetKeyIndex(true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return c
+	}
+	indexed, plain := newCache(true), newCache(false)
+	spaces := []string{"user:", "sess:", "img:"}
+	rng := rand.New(rand.NewPCG(146, 2))
+	randomKey := func() string {
+		return fmt.Sprintf("%s%02d", spaces[rng.IntN(len(spaces))], rng.IntN(30))
+	}
+	sorted := func(keys []string) []string {
+		return slices.Sorted(slices.Values(keys))
+	}
+	for i := range 20000 {
+		var op string
+		switch n := rng.IntN(100); {
+		case n < 40:
+			op = "put" // evicts once full
+			key, value := randomKey(), rng.Int()
+			indexed.Put(key, value)
+			plain.Put(key, value)
+		case n < 55:
+			op = "put with ttl"
+			key, value, ttl := randomKey(), rng.Int(), time.Duration(1+rng.IntN(5))*time.Second
+			indexed.PutWithTTL(key, value, ttl)
+			plain.PutWithTTL(key, value, ttl)
+		case n < 70:
+			op = "get" // reaps an expired entry
+			key := randomKey()
+			indexed.Get(key)
+			plain.Get(key)
+		case n < 78:
+			op = "delete"
+			key := randomKey()
+			indexed.Delete(key)
+			plain.Delete(key)
+		case n < 86:
+			op = "rename"
+			from, to := randomKey(), randomKey()
+			indexed.Rename(from, to)
+			plain.Rename(from, to)
+		case n < 90:
+			op = "advance and sweep"
+			clock.Advance(time.Second)
+			indexed.sweep()
+			plain.sweep()
+		case n < 93:
+			op = "delete prefix"
+			prefix := spaces[rng.IntN(len(spaces))] + fmt.Sprint(rng.IntN(3))
+			if got, want := indexed.DeletePrefix(prefix), plain.DeletePrefix(prefix); got != want {
+				t.Fatalf("op %d: DeletePrefix(%q) removed %d, want %d", i, prefix, got, want)
+			}
+		case n < 94:
+			op = "clear"
+			indexed.Clear()
+			plain.Clear()
+		default:
+			op = "query"
+			start, end := randomKey(), randomKey()
+			if got, want := indexed.RangeKeys(start, end), plain.RangeKeys(start, end); !slices.Equal(got, want) {
+				t.Fatalf("op %d: RangeKeys(%q, %q) = %q, want %q", i, start, end, got, want)
+			}
+			prefix := spaces[rng.IntN(len(spaces))]
+			if got, want := indexed.KeysWithPrefix(prefix), sorted(plain.KeysWithPrefix(prefix)); !slices.Equal(got, want) {
+				t.Fatalf("op %d: KeysWithPrefix(%q) = %q, want %q", i, prefix, got, want)
+			}
+		}
+		if err := indexed.DebugCheck(); err != nil {
+			t.Fatalf("op %d (%s): %v", i, op, err)
+		}
+	}
+
+	// A restored snapshot is indexed too.
+	var buf bytes.Buffer
+	if _, err := indexed.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := newCache(true)
+	restored.Put("stale", 1)
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.DebugCheck(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.RangeKeys("", ""), indexed.RangeKeys("", ""); !slices.Equal(got, want) {
+		t.Errorf("restored keys %q, want %q", got, want)
+	}
+}
+
+func TestDebugCheckFindsKeyIndexDamage(t *testing.T) {
+	c := NewLRUCache[string, int](4)
+	c.SetKeyIndex(true)
+	c.Put("a", 1)
+	c.Put("b", 2)
+
+	c.keys.remove("a")
+	if err := c.DebugCheck(); err == nil || !strings.Contains(err.Error(), "key index holds 1 keys but the map has 2") {
+		t.Errorf("DebugCheck with a key missing from the index = %v", err)
+	}
+	c.keys.insert("a")
+	c.keys.remove("b")
+	c.keys.insert("z")
+	if err := c.DebugCheck(); err == nil || !strings.Contains(err.Error(), `key index holds "z", which is not cached`) {
+		t.Errorf("DebugCheck with a stray key in the index = %v", err)
+	}
+}
+
+func TestSetKeyIndex(t *testing.T) {
+	if err := NewLRUCache[int, int](2).SetKeyIndex(true); err != errKeyIndex {
+		t.Errorf("SetKeyIndex on int keys = %v, want %v", err, errKeyIndex)
+	}
+	c := NewLRUCache[string, int](4)
+	c.Put("b", 2)
+	c.Put("a", 1)
+	c.SetKeyIndex(true) // built from the keys there are
+	if err := c.DebugCheck(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.KeysWithPrefix(""); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("indexed keys %q", got)
+	}
+	c.SetKeyIndex(false)
+	if got := c.KeysWithPrefix(""); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("keys in retention order after the index is dropped = %q", got)
+	}
+}
+
+func TestKeyIndexCommands(t *testing.T) {
+	s := newClockSession(t)
+	s.keyIndex = true
+	snap := t.TempDir() + "/snap"
+	script(t, s,
+		"INIT 4", "OK",
+		"PUT user:2 b", "OK",
+		"PUT user:1 a", "OK",
+		"PUT sess:1 c", "OK",
+		"PUT img:1 d 5", "OK",
+		"KEYS user:", "user:1 user:2",
+		"RANGEKEYS user: user:9", "user:1 user:2",
+		"RANGEKEYS a z", "img:1 sess:1 user:1 user:2",
+		"RANGEKEYS x a", "EMPTY",
+		`RANGEKEYS sess: ""`, "sess:1 user:1 user:2",
+		"RENAME user:1 sess:0", "OK",
+		`RANGEKEYS "" ""`, "img:1 sess:0 sess:1 user:2",
+		"DELPREFIX sess:", "2",
+		"DEBUG CHECK", "OK",
+	)
+	// SAVE also reports how long it paused the cache.
+	if got := s.Execute("SAVE " + snap); !strings.HasPrefix(got, "OK entries=2 ") {
+		t.Fatalf("SAVE: %q", got)
+	}
+	script(t, s,
+		"CLEAR", "OK",
+		`RANGEKEYS "" ""`, "EMPTY",
+		"LOAD "+snap, "OK",
+		`RANGEKEYS "" ""`, "img:1 user:2",
+		"DEBUG ADVANCECLOCK 5", "OK",
+		"GET img:1", "NULL",
+		`RANGEKEYS "" ""`, "user:2",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"PUT c 3", "OK",
+		"PUT d 4", "OK", // evicts user:2
+		`RANGEKEYS "" ""`, "a b c d",
+		"DEBUG CHECK", "OK",
+		"RANGEKEYS a", "ERROR ERR_ARITY RANGEKEYS requires start and end arguments",
+	)
+}
+
+// BenchmarkKeyIndex measures what the key index saves on prefix and range
+// queries over keyIndexBenchSize/10 keys in three namespaces, and what it
+// costs each Put of a new key, which evicts one.
+func BenchmarkKeyIndex(b *testing.B) {
+	const size = keyIndexBenchSize / 10
+	perSpace := size / len(keyIndexBenchSpaces)
+	for _, indexed := range []bool{false, true} {
+		name := "index=off"
+		if indexed {
+			name = "index=on"
+		}
+		cache := NewLRUCache[string, Value](size)
+		cache.SetKeyIndex(indexed)
+		for i := range size {
+			cache.Put(keyIndexBenchKey(i), StringValue("v"))
+		}
+		rng := rand.New(rand.NewPCG(1, 2))
+
+		b.Run("prefix/"+name, func(b *testing.B) {
+			for range b.N {
+				space := keyIndexBenchSpaces[rng.IntN(len(keyIndexBenchSpaces))]
+				cache.KeysWithPrefix(fmt.Sprintf("%s%05d", space, rng.IntN(perSpace/100)))
+			}
+		})
+		b.Run("range/"+name, func(b *testing.B) {
+			for range b.N {
+				space := keyIndexBenchSpaces[rng.IntN(len(keyIndexBenchSpaces))]
+				n := rng.IntN(perSpace - 100)
+				cache.RangeKeys(fmt.Sprintf("%s%07d", space, n), fmt.Sprintf("%s%07d", space, n+100))
+			}
+		})
+		b.Run("put/"+name, func(b *testing.B) {
+			keys := make([]string, b.N)
+			for i := range keys {
+				keys[i] = keyIndexBenchKey(size + rng.IntN(1<<30))
+			}
+			b.ResetTimer()
+			for i := range b.N {
+				cache.Put(keys[i], StringValue("v"))
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// The key index benchmark compares a cache with the key index to one
+// without, full with keyIndexBenchSize keys spread over three namespaces
+// as user:0000000, sess:0000000 and img:0000000: the time KEYS with a
+// prefix matching 100 keys and RANGEKEYS over 100 keys take, averaged over
+// keyIndexBenchQueries queries, and Put latency timed as the self-test
+// times it. Puts are of new keys in random order, so each evicts an entry
+// and inserts and removes one in the index.
+
+const (
+	keyIndexBenchSize    = 1_000_000
+	keyIndexBenchQueries = 50
+)
+
+var keyIndexBenchSpaces = []string{"user:", "sess:", "img:"}
+
+type keyIndexBenchRow struct {
+	index          string
+	put            time.Duration
+	prefix, ranged time.Duration // per query
+}
+
+// runKeyIndexBench benchmarks a cache with and without the key index and
+// writes a table of the results to w.
+func runKeyIndexBench(w io.Writer) {
+	rows := []keyIndexBenchRow{keyIndexBenchWith(false), keyIndexBenchWith(true)}
+	fmt.Fprintf(w, "%-6s %8s %10s %10s\n", "index", "put_ns", "prefix_us", "range_us")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%-6s %8d %10.1f %10.1f\n", r.index, r.put.Nanoseconds(),
+			float64(r.prefix)/float64(time.Microsecond), float64(r.ranged)/float64(time.Microsecond))
+	}
+	off, on := rows[0], rows[1]
+	fmt.Fprintf(w, "prefix %.0fx faster, range %.0fx faster, put %+d ns\n",
+		float64(off.prefix)/float64(on.prefix), float64(off.ranged)/float64(on.ranged),
+		on.put.Nanoseconds()-off.put.Nanoseconds())
+}
+
+// keyIndexBenchKey returns the i'th key of the benchmark.
+func keyIndexBenchKey(i int) string {
+	return fmt.Sprintf("%s%07d", keyIndexBenchSpaces[i%len(keyIndexBenchSpaces)], i/len(keyIndexBenchSpaces))
+}
+
+// keyIndexBenchWith benchmarks a cache with the key index if indexed is
+// set, and one without otherwise.
+func keyIndexBenchWith(indexed bool) keyIndexBenchRow {
+	row := keyIndexBenchRow{index: "off", put: time.Hour}
+	if indexed {
+		row.index = "on"
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	fresh := make([]string, selftestRounds*selftestBatches*selftestBatch)
+	for i, n := range rng.Perm(len(fresh)) {
+		fresh[i] = keyIndexBenchKey(keyIndexBenchSize + n)
+	}
+	value := StringValue("v")
+
+	cache := NewLRUCache[string, Value](keyIndexBenchSize)
+	if indexed {
+		cache.SetKeyIndex(true)
+	}
+	for i := range keyIndexBenchSize {
+		cache.Put(keyIndexBenchKey(i), value)
+	}
+
+	// Each prefix, such as user:00123, and each range of 100 numbers
+	// within a namespace, matches 100 keys.
+	perSpace := keyIndexBenchSize / len(keyIndexBenchSpaces)
+	began := time.Now()
+	for range keyIndexBenchQueries {
+		space := keyIndexBenchSpaces[rng.Intn(len(keyIndexBenchSpaces))]
+		cache.KeysWithPrefix(fmt.Sprintf("%s%05d", space, rng.Intn(perSpace/100)))
+	}
+	row.prefix = time.Since(began) / keyIndexBenchQueries
+	began = time.Now()
+	for range keyIndexBenchQueries {
+		space := keyIndexBenchSpaces[rng.Intn(len(keyIndexBenchSpaces))]
+		n := rng.Intn(perSpace - 100)
+		cache.RangeKeys(fmt.Sprintf("%s%07d", space, n), fmt.Sprintf("%s%07d", space, n+100))
+	}
+	row.ranged = time.Since(began) / keyIndexBenchQueries
+
+	for round := range selftestRounds {
+		first := round * selftestBatches * selftestBatch
+		row.put = min(row.put, selftestMedian(func(batch int) {
+			for i := first + batch*selftestBatch; i < first+(batch+1)*selftestBatch; i++ {
+				cache.Put(fresh[i], value)
+			}
+		}))
+	}
+	return row
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"hash/maphash"
+	"sync/atomic"
+)
+
+// keyStripes is how many mutexes the keys of a cache share for LockKey.
+// Two keys hashed to the same stripe lock each other out, which with this
+// many is rare enough not to matter for mutual exclusion around a slow
+// computation.
+const keyStripes = 256
+
+// LockKey locks key for the caller, for work that spans several calls on
+// the cache, such as recomputing its value, and returns the function that
+// unlocks it. Another LockKey of the same key, and GetOrCompute's load of
+// it, wait until then; other keys mostly go ahead, see keyStripes. The
+// cache itself is not locked, and the lock has nothing to do with whether
+// key is cached: the entry may be written, evicted or expire while it is
+// held, by the holder or anyone else.
+//
+// The lock is not reentrant, and as keys share stripes, a holder must not
+// call LockKey or GetOrCompute for any key until it unlocks. Calling the
+// unlock function twice panics.
+func (c *LRUCache[K, V]) LockKey(key K) (unlock func()) {
+	return c.lockKey(c.normalize(key))
+}
+
+// lockKey is LockKey for a normalized key.
+func (c *LRUCache[K, V]) lockKey(key K) func() {
+	mu := &c.keyLocks[maphash.Comparable(c.lockSeed, key)%keyStripes]
+	mu.Lock()
+	var unlocked atomic.Bool
+	return func() {
+		if unlocked.Swap(true) {
+			panic("lru: key unlocked twice")
+		}
+		mu.Unlock()
+	}
+}
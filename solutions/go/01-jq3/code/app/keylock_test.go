@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"strings"
+	"testing"
+	"time"
+)
+
+// takes reports whether done is closed within a short time.
+func takes(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+// stripeMate returns a key other than key on the same stripe of c, and
+// one on another.
+func stripeMate(c *LRUCache[string, int], key string) (same, other string) {
+	stripe := func(k string) uint64 { return maphash.Comparable(c.lockSeed, k) % keyStripes }
+	for i := 0; same == "" || other == ""; i++ {
+		k := fmt.Sprint("k", i)
+		if stripe(k) == stripe(key) {
+			same = k
+		} else {
+			other = k
+		}
+	}
+	return same, other
+}
+
+func TestGetOrComputeWaitsForLockKey(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	unlock := c.LockKey("a")
+	done := make(chan struct{})
+	var got int
+	go func() {
+		defer close(done)
+		got, _ = c.GetOrCompute("a", func() (int, error) {
+			t.Error("fn called though the lock holder stored a value")
+			return 0, nil
+		})
+	}()
+	if takes(done) {
+		t.Fatal("GetOrCompute loaded a key held by LockKey")
+	}
+	c.Put("a", 7)
+	unlock()
+	<-done
+	if got != 7 {
+		t.Errorf("GetOrCompute = %d, want the holder's 7", got)
+	}
+}
+
+func TestLockKeyStripes(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	same, other := stripeMate(c, "a")
+	unlock := c.LockKey("a")
+
+	// A key on another stripe goes ahead.
+	if v, err := c.GetOrCompute(other, func() (int, error) { return 1, nil }); err != nil || v != 1 {
+		t.Fatalf("GetOrCompute(%s) = %d, %v", other, v, err)
+	}
+	// A key sharing the stripe waits, then is computed.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, _ := c.GetOrCompute(same, func() (int, error) { return 2, nil }); v != 2 {
+			t.Errorf("GetOrCompute(%s) = %d, want 2", same, v)
+		}
+	}()
+	if takes(done) {
+		t.Fatalf("GetOrCompute(%s) ran while %s, on its stripe, was locked", same, "a")
+	}
+	unlock()
+	<-done
+}
+
+func TestEvictWhileKeyLocked(t *testing.T) {
+	c := NewLRUCache[string, int](2)
+	var evicted []string
+	c.SetEvictionHandler(func(key string, _ int) { evicted = append(evicted, key) })
+	c.Put("a", 1)
+	unlock := c.LockKey("a")
+	c.Put("b", 2)
+	c.Put("c", 3) // evicts a, locked or not
+	if c.Contains("a") || strings.Join(evicted, " ") != "a" {
+		t.Errorf("a cached %v, evicted %q", c.Contains("a"), evicted)
+	}
+	// The holder may write it back.
+	c.Put("a", 4)
+	unlock()
+	if v, ok := c.Get("a"); !ok || v != 4 {
+		t.Errorf("Get(a) = %d, %v", v, ok)
+	}
+	// The stripe is free again.
+	done := make(chan struct{})
+	go func() {
+		c.LockKey("a")()
+		close(done)
+	}()
+	if !takes(done) {
+		t.Error("LockKey(a) still waits after the unlock")
+	}
+}
+
+func TestLockKeyDoubleUnlockPanics(t *testing.T) {
+	c := NewLRUCache[string, int](2)
+	unlock := c.LockKey("a")
+	unlock()
+	func() {
+		defer func() {
+			if p := recover(); p != "lru: key unlocked twice" {
+				t.Errorf("second unlock panicked with %v", p)
+			}
+		}()
+		unlock()
+	}()
+
+	// The panic left the stripe as it was: locked once, it is held.
+	again := c.LockKey("a")
+	done := make(chan struct{})
+	go func() {
+		c.LockKey("a")()
+		close(done)
+	}()
+	if takes(done) {
+		t.Fatal("a second LockKey(a) went ahead while the first held it")
+	}
+	again()
+	<-done
+}
+
+func TestLockKeyNormalizes(t *testing.T) {
+	c := NewLRUCache[string, int](2)
+	c.SetKeyNormalizer(strings.ToLower)
+	unlock := c.LockKey("A")
+	done := make(chan struct{})
+	go func() {
+		c.LockKey("a")()
+		close(done)
+	}()
+	if takes(done) {
+		t.Fatal("LockKey(a) went ahead while A was held")
+	}
+	unlock()
+	<-done
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets is the number of buckets in a histogram. Durations in
+// nanoseconds below 4 get a bucket each; above that every power of two is
+// split into 4 equal buckets, so a bucket's bounds are within 25% of each
+// other whatever the magnitude.
+const latencyBuckets = 252
+
+// defaultLatencySample is how many operations share one timing by default.
+// Reading the clock twice can cost as much as a cache hit, so timing only
+// one operation in this many keeps the overhead to a few percent.
+const defaultLatencySample = 32
+
+// histogram counts durations into fixed log-scaled buckets. Recording is a
+// few atomic adds and allocates nothing, so it is safe and cheap to call
+// from any goroutine without a lock. ops counts every operation, whether
+// or not it was timed.
+type histogram struct {
+	buckets [latencyBuckets]atomic.Int64
+	ops     atomic.Int64
+	sum     atomic.Int64 // nanoseconds
+	max     atomic.Int64 // nanoseconds
+}
+
+func latencyBucket(ns int64) int {
+	if ns < 4 {
+		return int(max(ns, 0))
+	}
+	e := bits.Len64(uint64(ns)) - 1
+	return (e-1)*4 + int(ns>>(e-2))&3
+}
+
+// bucketUpper returns the largest duration in nanoseconds that falls into
+// bucket i.
+func bucketUpper(i int) int64 {
+	if i < 4 {
+		return int64(i)
+	}
+	if i == latencyBuckets-1 {
+		return math.MaxInt64
+	}
+	e, sub := i/4+1, int64(i%4)
+	return (4+sub+1)<<(e-2) - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	ns := int64(d)
+	h.buckets[latencyBucket(ns)].Add(1)
+	h.sum.Add(ns)
+	for {
+		old := h.max.Load()
+		if ns <= old || h.max.CompareAndSwap(old, ns) {
+			return
+		}
+	}
+}
+
+// sample counts an operation and reports whether it is one of the one in
+// every that should be timed. The first operation always is.
+func (h *histogram) sample(every int64) bool {
+	return (h.ops.Add(1)-1)%every == 0
+}
+
+// since records the time elapsed since start. It is meant to be deferred.
+func (h *histogram) since(start time.Time) {
+	h.record(time.Since(start))
+}
+
+func (h *histogram) reset() {
+	for i := range h.buckets {
+		h.buckets[i].Store(0)
+	}
+	h.ops.Store(0)
+	h.sum.Store(0)
+	h.max.Store(0)
+}
+
+// LatencySummary describes the durations one kind of operation took.
+// Count is every operation; Samples is how many of them were timed, and
+// Sum and the quantiles cover only those. Quantiles are the upper bound of
+// the bucket they fall in, capped at Max, so they overstate the true value
+// by at most a quarter.
+type LatencySummary struct {
+	Op      string        `json:"op"`
+	Count   int64         `json:"count"`
+	Samples int64         `json:"samples"`
+	Sum     time.Duration `json:"sum_ns"`
+	P50     time.Duration `json:"p50_ns"`
+	P90     time.Duration `json:"p90_ns"`
+	P99     time.Duration `json:"p99_ns"`
+	Max     time.Duration `json:"max_ns"`
+}
+
+func micros(d time.Duration) string {
+	return fmt.Sprintf("%.2f", float64(d)/float64(time.Microsecond))
+}
+
+func (s LatencySummary) String() string {
+	return fmt.Sprintf("%s count=%d samples=%d p50=%s p90=%s p99=%s max=%s",
+		s.Op, s.Count, s.Samples, micros(s.P50), micros(s.P90), micros(s.P99), micros(s.Max))
+}
+
+// summary reads the histogram bucket by bucket. A record in progress may
+// show up in some of the figures and not yet in others.
+func (h *histogram) summary(op string) LatencySummary {
+	var counts [latencyBuckets]int64
+	var total int64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	s := LatencySummary{
+		Op:      op,
+		Count:   h.ops.Load(),
+		Samples: total,
+		Sum:     time.Duration(h.sum.Load()),
+		Max:     time.Duration(h.max.Load()),
+	}
+	quantile := func(q float64) time.Duration {
+		rank := int64(q*float64(total) + 0.5)
+		var seen int64
+		for i, n := range counts {
+			seen += n
+			if n > 0 && seen >= max(rank, 1) {
+				return min(time.Duration(bucketUpper(i)), s.Max)
+			}
+		}
+		return s.Max
+	}
+	if total > 0 {
+		s.P50, s.P90, s.P99 = quantile(0.50), quantile(0.90), quantile(0.99)
+	}
+	return s
+}
+
+// latencyHistograms holds a histogram per instrumented operation, and the
+// sampling rate they were started with.
+type latencyHistograms struct {
+	get, put histogram
+	every    int64
+}
+
+// SetLatencySampling turns the Get and Put latency histograms on, timing one
+// operation in every, or off when every is 0. Every operation is still
+// counted. Changing the rate starts again from empty histograms.
+func (c *LRUCache[K, V]) SetLatencySampling(every int) {
+	if every <= 0 {
+		c.latency.Store(nil)
+		return
+	}
+	if l := c.latency.Load(); l == nil || l.every != int64(every) {
+		c.latency.Store(&latencyHistograms{every: int64(every)})
+	}
+}
+
+// Latency returns a summary of the time taken by Get and GetCtx and by the
+// writes that store a single entry: Put, PutWithTTL, PutWithGrace and
+// PutCtx. Times include waiting for the lock and any evictions the write
+// causes. It returns nil when tracking is off.
+func (c *LRUCache[K, V]) Latency() []LatencySummary {
+	l := c.latency.Load()
+	if l == nil {
+		return nil
+	}
+	return []LatencySummary{l.get.summary("GET"), l.put.summary("PUT")}
+}
+
+// ResetLatency empties the latency histograms.
+func (c *LRUCache[K, V]) ResetLatency() {
+	if l := c.latency.Load(); l != nil {
+		l.get.reset()
+		l.put.reset()
+	}
+}
+
+// writeLatencyMetrics writes the latency summaries as Prometheus summaries
+// in seconds, labelled with the operation.
+func writeLatencyMetrics(w io.Writer, summaries []LatencySummary) {
+	if len(summaries) == 0 {
+		return
+	}
+	const name = "lru_cache_operation_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time taken by cache operations.\n# TYPE %s summary\n", name, name)
+	for _, s := range summaries {
+		op := strings.ToLower(s.Op)
+		for _, q := range []struct {
+			label string
+			value time.Duration
+		}{{"0.5", s.P50}, {"0.9", s.P90}, {"0.99", s.P99}, {"1", s.Max}} {
+			fmt.Fprintf(w, "%s{op=%q,quantile=%q} %g\n", name, op, q.label, q.value.Seconds())
+		}
+		fmt.Fprintf(w, "%s_sum{op=%q} %g\n", name, op, s.Sum.Seconds())
+		fmt.Fprintf(w, "%s_count{op=%q} %d\n", name, op, s.Samples)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyBucketBounds(t *testing.T) {
+	for ns := int64(0); ns < 1<<20; ns = ns*5/4 + 1 {
+		b := latencyBucket(ns)
+		if upper := bucketUpper(b); ns > upper {
+			t.Errorf("%dns falls in bucket %d, whose upper bound is %d", ns, b, upper)
+		}
+		if b > 0 && ns <= bucketUpper(b-1) {
+			t.Errorf("%dns falls in bucket %d but fits bucket %d", ns, b, b-1)
+		}
+	}
+	if b := latencyBucket(int64(time.Hour)); b >= latencyBuckets {
+		t.Errorf("an hour falls in bucket %d of %d", b, latencyBuckets)
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h histogram
+	// 90 fast operations, 9 slower and one very slow.
+	for range 90 {
+		h.record(time.Microsecond)
+	}
+	for range 9 {
+		h.record(100 * time.Microsecond)
+	}
+	h.record(10 * time.Millisecond)
+	s := h.summary("GET")
+	within := func(got, want time.Duration) bool { return got >= want && got <= want*5/4 }
+	if !within(s.P50, time.Microsecond) || !within(s.P90, time.Microsecond) ||
+		!within(s.P99, 100*time.Microsecond) || s.Max != 10*time.Millisecond || s.Samples != 100 {
+		t.Errorf("summary %+v", s)
+	}
+	h.reset()
+	if s := h.summary("GET"); s.Samples != 0 || s.Max != 0 || s.P99 != 0 {
+		t.Errorf("summary after reset %+v", s)
+	}
+}
+
+func TestHistogramRecordDoesNotAllocate(t *testing.T) {
+	var h histogram
+	if n := testing.AllocsPerRun(1000, func() { h.record(3 * time.Microsecond) }); n != 0 {
+		t.Errorf("record allocates %v times", n)
+	}
+}
+
+func TestLatencySampling(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	if c.Latency() != nil {
+		t.Fatal("latency tracked before it was turned on")
+	}
+	c.SetLatencySampling(4)
+	for i := range 40 {
+		c.Put("k"+strconv.Itoa(i%10), i)
+		c.Get("k" + strconv.Itoa(i%20))
+	}
+	for _, s := range c.Latency() {
+		if s.Count != 40 || s.Samples != 10 || s.Max <= 0 {
+			t.Errorf("%s: %+v, want 40 counted and 10 timed", s.Op, s)
+		}
+	}
+	c.ResetLatency()
+	for _, s := range c.Latency() {
+		if s.Count != 0 || s.Samples != 0 {
+			t.Errorf("%s after reset: %+v", s.Op, s)
+		}
+	}
+}
+
+func TestLatencyCommand(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 10", "OK",
+		"LATENCY", "GET count=0 samples=0 p50=0.00 p90=0.00 p99=0.00 max=0.00\n"+
+			"PUT count=0 samples=0 p50=0.00 p90=0.00 p99=0.00 max=0.00",
+		"LATENCY ON 0", "ERROR ERR_INVALID Invalid sample rate: 0",
+		"LATENCY RESET x", "ERROR ERR_ARITY LATENCY RESET takes no arguments",
+		"LATENCY OFF", "OK",
+		"LATENCY", "ERROR ERR_UNSUPPORTED Latency tracking is off",
+		"LATENCY ON 1", "OK",
+		"LATENCY RESET", "OK",
+	)
+	s.cache.Put("a", StringValue("1"))
+	s.cache.Get("a")
+	lines := strings.Split(s.Execute("LATENCY"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "GET count=1 samples=1 ") || !strings.HasPrefix(lines[1], "PUT count=1 samples=1 ") {
+		t.Errorf("LATENCY = %q", lines)
+	}
+}
+
+// BenchmarkLatencyOverhead compares Get and Put with the histograms off,
+// sampling as the cache does by default, and timing every operation.
+func BenchmarkLatencyOverhead(b *testing.B) {
+	keys := numbered("k", 1<<12)
+	for _, tc := range []struct {
+		name  string
+		every int
+	}{
+		{"off", 0},
+		{"sampled", defaultLatencySample},
+		{"every", 1},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			c := NewLRUCache[string, int](1 << 11)
+			c.SetLatencySampling(tc.every)
+			i := 0
+			for b.Loop() {
+				key := keys[i&(len(keys)-1)]
+				if i%4 == 0 {
+					c.Put(key, i)
+				} else {
+					c.Get(key)
+				}
+				i++
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import "fmt"
+
+// nodeList is an intrusive doubly linked list of cache nodes. It uses a
+// sentinel root so that insertion and removal never need nil checks:
+// root.next is the front of the list and root.prev the back.
+type nodeList[K comparable, V any] struct {
+	root Node[K, V]
+	len  int
+}
+
+func newNodeList[K comparable, V any]() *nodeList[K, V] {
+	l := &nodeList[K, V]{}
+	l.init()
+	return l
+}
+
+func (l *nodeList[K, V]) init() {
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	l.len = 0
+}
+
+// front returns the first node, or nil if the list is empty.
+func (l *nodeList[K, V]) front() *Node[K, V] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.next
+}
+
+// back returns the last node, or nil if the list is empty.
+func (l *nodeList[K, V]) back() *Node[K, V] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+// backExcept returns the last node other than keep, or nil if there is none.
+func (l *nodeList[K, V]) backExcept(keep *Node[K, V]) *Node[K, V] {
+	node := l.back()
+	if node != nil && node == keep {
+		node = node.prev
+		if node == &l.root {
+			return nil
+		}
+	}
+	return node
+}
+
+func (l *nodeList[K, V]) pushFront(node *Node[K, V]) {
+	l.insertAfter(node, &l.root)
+}
+
+func (l *nodeList[K, V]) pushBack(node *Node[K, V]) {
+	l.insertAfter(node, l.root.prev)
+}
+
+func (l *nodeList[K, V]) insertAfter(node, at *Node[K, V]) {
+	node.prev = at
+	node.next = at.next
+	at.next.prev = node
+	at.next = node
+	node.list = l
+	l.len++
+}
+
+func (l *nodeList[K, V]) remove(node *Node[K, V]) {
+	node.prev.next = node.next
+	node.next.prev = node.prev
+	node.prev = nil
+	node.next = nil
+	node.list = nil
+	l.len--
+}
+
+func (l *nodeList[K, V]) moveToFront(node *Node[K, V]) {
+	if l.root.next == node {
+		return
+	}
+	l.remove(node)
+	l.pushFront(node)
+}
+
+// each visits nodes from front to back until fn returns false.
+func (l *nodeList[K, V]) each(fn func(node *Node[K, V]) bool) {
+	for node := l.root.next; node != &l.root; {
+		next := node.next
+		if !fn(node) {
+			return
+		}
+		node = next
+	}
+}
+
+// eachBack visits nodes from back to front, skipping skip, until fn returns
+// false, and reports whether it visited them all.
+func (l *nodeList[K, V]) eachBack(skip *Node[K, V], fn func(node *Node[K, V]) bool) bool {
+	for cur := l.cursor(skip); cur.peek() != nil; cur.advance() {
+		if !fn(cur.peek()) {
+			return false
+		}
+	}
+	return true
+}
+
+// A listCursor walks a list from back to front, skipping one node, without
+// changing it, for a policy to work out its victims ahead of evicting them.
+type listCursor[K comparable, V any] struct {
+	list       *nodeList[K, V]
+	next, skip *Node[K, V]
+}
+
+func (l *nodeList[K, V]) cursor(skip *Node[K, V]) *listCursor[K, V] {
+	return &listCursor[K, V]{list: l, next: l.root.prev, skip: skip}
+}
+
+// peek returns the node the cursor is at, or nil once it has passed the
+// front.
+func (c *listCursor[K, V]) peek() *Node[K, V] {
+	if c.next == c.skip {
+		c.next = c.next.prev
+	}
+	if c.next == &c.list.root {
+		return nil
+	}
+	return c.next
+}
+
+// advance moves the cursor past the node peek returns, which must not be
+// nil.
+func (c *listCursor[K, V]) advance() {
+	c.peek()
+	c.next = c.next.prev
+}
+
+// check verifies that the links form a single cycle through the sentinel
+// whose length matches len and whose nodes all point back at l. name
+// identifies the list in the error.
+func (l *nodeList[K, V]) check(name string) error {
+	n := 0
+	for node := &l.root; ; node = node.next {
+		if node.next == nil || node.next.prev != node {
+			return fmt.Errorf("%s: broken link after node %d", name, n)
+		}
+		if node.next == &l.root {
+			break
+		}
+		n++
+		if n > l.len {
+			return fmt.Errorf("%s: more nodes than its length %d", name, l.len)
+		}
+		if node.next.list != l {
+			return fmt.Errorf("%s: node %v belongs to another list", name, node.next.key)
+		}
+	}
+	if n != l.len {
+		return fmt.Errorf("%s: %d nodes but length %d", name, n, l.len)
+	}
+	return nil
+}
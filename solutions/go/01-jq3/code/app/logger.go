@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A logLevel is how serious a log line is. Lines below the logger's level
+// are dropped.
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	if l < levelDebug || l > levelError {
+		return strconv.Itoa(int(l))
+	}
+	return logLevelNames[l]
+}
+
+// parseLogLevel parses a level name, in any case.
+func parseLogLevel(name string) (logLevel, error) {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(name, n) {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q: want debug, info, warn or error", name)
+}
+
+// A logger writes the problems the cache and its background workers run
+// into, which have nobody to return an error to, as lines of key=value
+// pairs:
+//
+//	time=2024-05-01T12:00:00.000Z level=error msg="worker panicked" worker=janitor panic="boom" restarts=1
+//
+// A value is quoted as a Go string when it is empty or holds a space, a
+// quote, an equals sign or a character that is not printable.
+type logger struct {
+	level atomic.Int32
+	out   *lineWriter
+}
+
+func newLogger(out *lineWriter, level logLevel) *logger {
+	l := &logger{out: out}
+	l.level.Store(int32(level))
+	return l
+}
+
+// logs is the process's logger, writing to stderr at --log-level.
+var logs = newLogger(stderrLines, levelInfo)
+
+func (l *logger) setLevel(level logLevel) {
+	l.level.Store(int32(level))
+}
+
+func (l *logger) getLevel() logLevel {
+	return logLevel(l.level.Load())
+}
+
+// log writes msg at level with the alternating keys and values of kv.
+func (l *logger) log(level logLevel, msg string, kv ...any) {
+	if level < l.getLevel() {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "time=%s level=%s msg=%s", time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), level, logValue(msg))
+	for i := 0; i < len(kv); i += 2 {
+		var value any = "MISSING"
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%s", kv[i], logValue(fmt.Sprint(value)))
+	}
+	l.out.println(b.String())
+}
+
+func logValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=") || strings.ContainsFunc(s, func(r rune) bool { return !strconv.IsPrint(r) }) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// rescue is deferred by the cache's background workers. It recovers a
+// panic in the worker, logs it once and counts it in Stats.Restarts, so
+// that the worker carries on with its next job, or is started again,
+// instead of the panic taking the process down. If err is not nil the
+// panic is also stored in it as an error, for the worker to handle as it
+// would any failure of the job.
+func (c *LRUCache[K, V]) rescue(worker string, err *error) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	restarts := c.counters.restarts.Add(1)
+	logs.log(levelError, "worker panicked", "worker", worker, "panic", p, "restarts", restarts)
+	logs.log(levelDebug, "worker panic stack", "worker", worker, "stack", string(debug.Stack()))
+	if err != nil {
+		*err = fmt.Errorf("%s panicked: %v", worker, p)
+	}
+}
+
+// protect runs fn as a job of worker, logging and counting a panic in it
+// with rescue.
+func (c *LRUCache[K, V]) protect(worker string, fn func()) {
+	defer c.rescue(worker, nil)
+	fn()
+}
+
+// cmdLoglevel sets the level of the logger, or reports it.
+func (s *session) cmdLoglevel(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) == 1 {
+		out.Value(logs.getLevel().String())
+		return
+	}
+	level, err := parseLogLevel(parts[1])
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid log level: %s", parts[1])
+		return
+	}
+	logs.setLevel(level)
+	out.OK()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// logLines returns the lines of buf holding msg.
+func logLines(buf *syncBuffer, msg string) []string {
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "msg="+logValue(msg)) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestRefreshPanicIsLoggedOnceAndCounted(t *testing.T) {
+	stderr := captureStderr(t)
+	c, clock := clockCache(10)
+	var calls atomic.Int32
+	if err := c.StartRefresh(func(key string) (int, error) {
+		calls.Add(1)
+		if key == "bad" {
+			panic("refresh bug")
+		}
+		return 2, nil
+	}, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.StopRefresh)
+
+	c.PutWithTTL("bad", 1, 10*time.Second)
+	clock.Advance(6 * time.Second)
+	c.Get("bad")
+	waitFor(t, func() bool { return c.Stats().Restarts == 1 })
+
+	// The worker carries on: a second panic is logged and counted too, and
+	// a good key still refreshes.
+	c.PutWithTTL("good", 1, 10*time.Second)
+	c.PutWithTTL("bad", 1, 10*time.Second)
+	clock.Advance(6 * time.Second)
+	c.Get("bad")
+	c.Get("good")
+	waitFor(t, func() bool { return c.Stats().Restarts == 2 })
+	waitFor(t, func() bool { v, _ := c.Peek("good"); return v == 2 })
+
+	lines := logLines(stderr, "worker panicked")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d panics, want 2:\n%s", len(lines), stderr)
+	}
+	for i, line := range lines {
+		for _, want := range []string{"level=error", "worker=refresh", `panic="refresh bug"`, "restarts=" + strconv.Itoa(i+1)} {
+			if !strings.Contains(line, want) {
+				t.Errorf("log line %q lacks %s", line, want)
+			}
+		}
+	}
+	if st := c.Stats(); st.RefreshFailures != 2 {
+		t.Errorf("%d refresh failures, want 2", st.RefreshFailures)
+	}
+}
+
+func TestGetOrComputeCtxPanicIsRecovered(t *testing.T) {
+	stderr := captureStderr(t)
+	c := NewLRUCache[string, int](10)
+	_, err := c.GetOrComputeCtx(context.Background(), "k", func(context.Context) (int, error) {
+		panic("loader bug")
+	})
+	if !errors.Is(err, ErrLoaderPanicked) {
+		t.Errorf("GetOrComputeCtx with a panicking loader: %v", err)
+	}
+	waitFor(t, func() bool { return c.Stats().Restarts == 1 })
+	if lines := logLines(stderr, "worker panicked"); len(lines) != 1 || !strings.Contains(lines[0], "worker=compute") {
+		t.Errorf("logged %q", lines)
+	}
+	v, err := c.GetOrComputeCtx(context.Background(), "k", func(context.Context) (int, error) { return 3, nil })
+	if v != 3 || err != nil {
+		t.Errorf("GetOrComputeCtx after the panic = %d, %v", v, err)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	t.Cleanup(func() { logs.setLevel(levelInfo) })
+	stderr := captureStderr(t)
+	s := newTestSession(t)
+	script(t, s,
+		"LOGLEVEL", "info",
+		"LOGLEVEL loud", "ERROR ERR_INVALID Invalid log level: loud",
+		"LOGLEVEL ERROR", "OK",
+		"LOGLEVEL", "error",
+	)
+	logs.log(levelWarn, "dropped")
+	logs.log(levelError, "kept", "key", "two words", "empty", "")
+	if lines := logLines(stderr, "dropped"); len(lines) != 0 {
+		t.Errorf("a warning was logged at level error: %q", lines)
+	}
+	lines := logLines(stderr, "kept")
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], ` level=error msg=kept key="two words" empty=""`) {
+		t.Errorf("logged %q", lines)
+	}
+}
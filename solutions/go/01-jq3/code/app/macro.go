@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ALIAS names a command with parameters, and MACRO a sequence of them;
+// both belong to the stream that defines them. In the commands, $1, $2
+// and so on stand for the arguments the alias or macro is given, whole
+// tokens or parts of them. The commands are tokenized when defined and
+// the arguments put in token by token, so an argument holding spaces or
+// quotes stays one token. An alias runs when its name is used as a
+// command, and a macro with RUN, each of its commands giving its own
+// response as if sent in turn. A definition that would make an alias or
+// macro run itself, directly or through others, is refused, and so is
+// nesting deeper than maxMacroDepth, which arguments naming commands can
+// still reach.
+
+// maxMacroDepth bounds how many aliases and macros can run inside each
+// other.
+const maxMacroDepth = 16
+
+// A macro is an alias, which has one command, or a macro.
+type macro struct {
+	name   string
+	alias  bool
+	cmds   [][]string // tokens; a raw command has its word and its text
+	params int        // the highest $n used
+}
+
+// parseMacroLine tokenizes line, a line of a macro, into its commands,
+// splitting it as execute does.
+func parseMacroLine(line string) ([][]string, error) {
+	var cmds [][]string
+	for more := true; more; {
+		var cmd, rest string
+		cmd, rest, more = cutCommand(line)
+		word, _ := cutWord(cmd)
+		switch c := commandIndex[strings.ToUpper(word)]; {
+		case c != nil && c.raw:
+			cmd, more = strings.TrimSpace(line), false
+			tokens := []string{c.name}
+			if _, text := cutWord(cmd); text != "" {
+				tokens = append(tokens, text)
+			}
+			cmds = append(cmds, tokens)
+		case cmd != "" && !isComment(cmd):
+			tokens, err := tokenize(cmd)
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, tokens)
+		}
+		line = rest
+	}
+	return cmds, nil
+}
+
+// newMacro returns the alias or macro of cmds, with its parameters counted.
+func newMacro(name string, alias bool, cmds [][]string) *macro {
+	m := &macro{name: name, alias: alias, cmds: cmds}
+	for _, cmd := range cmds {
+		for _, tok := range cmd {
+			substitute(tok, func(n int) string {
+				m.params = max(m.params, n)
+				return ""
+			})
+		}
+	}
+	return m
+}
+
+// substitute returns tok with each $n in it replaced by arg(n), n >= 1.
+func substitute(tok string, arg func(n int) string) string {
+	if !strings.Contains(tok, "$") {
+		return tok
+	}
+	var b strings.Builder
+	for i := 0; i < len(tok); i++ {
+		j := i + 1
+		for j < len(tok) && tok[j] >= '0' && tok[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(tok[i+1 : j])
+		if tok[i] != '$' || err != nil || n < 1 {
+			b.WriteByte(tok[i])
+			continue
+		}
+		b.WriteString(arg(n))
+		i = j - 1
+	}
+	return b.String()
+}
+
+// macroLine returns cmd with arg(n) put in for each $n, as a line
+// executeCommand reads back as those tokens.
+func macroLine(cmd []string, arg func(n int) string) string {
+	words := make([]string, len(cmd))
+	for i, tok := range cmd {
+		words[i] = substitute(tok, arg)
+	}
+	if c := commandIndex[strings.ToUpper(words[0])]; c == nil || !c.raw {
+		for i, word := range words {
+			words[i] = quoteToken(word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// lines returns the commands of m as they were defined.
+func (m *macro) lines() []string {
+	lines := make([]string, len(m.cmds))
+	for i, cmd := range m.cmds {
+		lines[i] = macroLine(cmd, func(n int) string { return "$" + strconv.Itoa(n) })
+	}
+	return lines
+}
+
+// refs returns the names of the aliases and macros the commands of m may
+// run: their command words, and what RUN is given.
+func (m *macro) refs() []string {
+	var names []string
+	for _, cmd := range m.cmds {
+		names = append(names, strings.ToUpper(cmd[0]))
+		if len(cmd) > 1 && strings.EqualFold(cmd[0], "RUN") {
+			names = append(names, strings.ToUpper(cmd[1]))
+		}
+	}
+	return names
+}
+
+// recursive reports whether m would run itself once defined among defs.
+func recursive(defs map[string]*macro, m *macro) bool {
+	seen := make(map[string]bool)
+	var reaches func(d *macro) bool
+	reaches = func(d *macro) bool {
+		for _, name := range d.refs() {
+			if name == m.name {
+				return true
+			}
+			if next := defs[name]; next != nil && !seen[name] {
+				seen[name] = true
+				if reaches(next) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return reaches(m)
+}
+
+// define adds m to the stream's aliases and macros, replacing any of the
+// same name, unless it is refused.
+func (s *session) define(out *reply, m *macro) {
+	switch {
+	case commandIndex[m.name] != nil:
+		out.Errorf(CodeInvalid, "%s is a command", m.name)
+		return
+	case strings.Contains(m.name, "$"):
+		out.Errorf(CodeInvalid, "Invalid name: %s", m.name)
+		return
+	case recursive(out.macros, m):
+		out.Errorf(CodeInvalid, "%s would run itself", m.name)
+		return
+	}
+	if out.macros == nil {
+		out.macros = make(map[string]*macro)
+	}
+	out.macros[m.name] = m
+	out.OK()
+}
+
+// runMacro runs the commands of m with args.
+func (s *session) runMacro(in *bufio.Reader, out *reply, m *macro, args []string) {
+	if len(args) != m.params {
+		noun := "arguments"
+		if m.params == 1 {
+			noun = "argument"
+		}
+		out.Errorf(CodeArity, "%s takes %d %s", m.name, m.params, noun)
+		return
+	}
+	if out.expanding == maxMacroDepth {
+		out.Errorf(CodeInvalid, "%s nested more than %d aliases and macros deep", m.name, maxMacroDepth)
+		return
+	}
+	out.expanding++
+	defer func() { out.expanding-- }()
+	for _, cmd := range m.cmds {
+		s.executeCommand(in, out, macroLine(cmd, func(n int) string { return args[n-1] }))
+		if out.hangUp {
+			return
+		}
+	}
+}
+
+func (s *session) cmdAlias(in *bufio.Reader, out *reply, line string, parts []string) {
+	s.define(out, newMacro(strings.ToUpper(parts[1]), true, [][]string{slices.Clone(parts[2:])}))
+}
+
+func (s *session) cmdMacro(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[2] != "BEGIN" {
+		out.Error(CodeSyntax, "MACRO requires a name and BEGIN")
+		return
+	}
+	// The body is read to its END even if part of it is refused, so that
+	// none of it runs as commands.
+	var cmds [][]string
+	var failed error
+	tooLong := false
+	for {
+		body, long, err := readLine(in, s.maxLine)
+		if strings.EqualFold(strings.TrimSpace(body), "END") {
+			break
+		}
+		tooLong = tooLong || long
+		if !long && failed == nil {
+			var more [][]string
+			more, failed = parseMacroLine(body)
+			cmds = append(cmds, more...)
+		}
+		if err != nil {
+			out.Error(CodeSyntax, "MACRO is missing END")
+			return
+		}
+	}
+	name := strings.ToUpper(parts[1])
+	switch {
+	case tooLong:
+		out.Errorf(CodeTooLarge, "Line exceeds %d bytes", s.maxLine)
+	case failed != nil:
+		out.Err(failed)
+	case len(cmds) == 0:
+		out.Errorf(CodeInvalid, "Macro %s has no commands", name)
+	default:
+		s.define(out, newMacro(name, false, cmds))
+	}
+}
+
+func (s *session) cmdRun(in *bufio.Reader, out *reply, line string, parts []string) {
+	m := out.macros[strings.ToUpper(parts[1])]
+	if m == nil {
+		out.Errorf(CodeInvalid, "No such alias or macro: %s", parts[1])
+		return
+	}
+	s.runMacro(in, out, m, parts[2:])
+}
+
+func (s *session) cmdAliases(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(out.macros) == 0 {
+		out.Result("EMPTY", []any{})
+		return
+	}
+	names := slices.Sorted(maps.Keys(out.macros))
+	lines := make([]string, len(names))
+	list := make([]map[string]any, len(names))
+	for i, name := range names {
+		m := out.macros[name]
+		kind := "MACRO"
+		if m.alias {
+			kind = "ALIAS"
+		}
+		lines[i] = name + " " + kind + " " + strings.Join(m.lines(), "; ")
+		list[i] = map[string]any{"name": name, "alias": m.alias, "commands": m.lines()}
+	}
+	out.Result(strings.Join(lines, "\n"), list)
+}
+
+func (s *session) cmdUnalias(in *bufio.Reader, out *reply, line string, parts []string) {
+	name := strings.ToUpper(parts[1])
+	if out.macros[name] == nil {
+		out.Errorf(CodeInvalid, "No such alias or macro: %s", parts[1])
+		return
+	}
+	delete(out.macros, name)
+	out.OK()
+}
@@ -2,10 +2,30 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"maps"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/systemquest/lru-cache-starter-go/app/conformance"
+	"github.com/systemquest/lru-cache-starter-go/app/internal/fakeclock"
 )
 
 // Prevents unused imports from being removed by goimports
@@ -14,103 +34,2429 @@ var _ = strconv.Atoi
 var _ = strings.Fields
 var _ = strconv.Atoi
 
-type LRUCache struct {
-	capacity int
-	cache    map[string]string
-}
+// Version is the version reported by VERSION. Release builds set it with
+// -ldflags "-X main.Version=...".
+var Version = "dev"
 
-func NewLRUCache(capacity int) *LRUCache {
-	return &LRUCache{
-		capacity: capacity,
-		cache:    make(map[string]string),
+// maxRawValue bounds the length accepted by PUTRAW.
+const maxRawValue = 64 << 20
+
+// parseTTL parses a TTL given in (possibly fractional) seconds. Zero and
+// negative values are rejected since they are almost always a caller bug.
+func parseTTL(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("Invalid TTL: %s", s)
 	}
+	return time.Duration(seconds * float64(time.Second)), nil
 }
 
-func (c *LRUCache) Get(key string) (string, bool) {
-	value, ok := c.cache[key]
-	return value, ok
+// parseUnixTime parses an instant given in (possibly fractional) seconds
+// since the Unix epoch.
+func parseUnixTime(s string) (time.Time, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return time.Time{}, fmt.Errorf("Invalid timestamp: %s", s)
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))), nil
 }
 
-func (c *LRUCache) Put(key, value string) {
-	c.cache[key] = value
-}
+func main() {
+	aofPath := flag.String("aof", "", "append state-changing commands to `path` and replay it on INIT")
+	listen := flag.String("listen", "", "serve the line protocol on TCP `address` instead of reading stdin")
+	capacity := flag.Int("capacity", 0, "cache capacity in server mode")
+	resp := flag.Bool("resp", false, "speak RESP instead of the line protocol in server mode")
+	jsonMode := flag.Bool("json", false, "write responses as JSON objects")
+	framed := flag.Bool("framed", false, "start each stream in MODE FRAMED, writing values as VALUE <len> frames and misses as MISS")
+	maxLine := flag.Int("max-line-bytes", 64<<20, "reject command lines longer than `n` bytes (0 for no limit)")
+	maxKey := flag.Int("max-key-bytes", 0, "reject keys longer than `n` bytes (0 for no limit)")
+	maxValue := flag.Int("max-value-bytes", 0, "reject values longer than `n` bytes (0 for no limit)")
+	httpAddr := flag.String("http", "", "also serve a JSON API on `address`")
+	testClock := flag.Bool("test-clock", false, "start every cache on a fake clock moved only by DEBUG ADVANCECLOCK")
+	sweepInterval := flag.Duration("sweep-interval", 0, "remove expired entries in the background every `interval` (0 to only expire lazily)")
+	replay := flag.String("replay", "", "replay the access trace at `path` into a cache of --capacity, print a summary and exit")
+	legacyErrors := flag.Bool("legacy-errors", false, "write errors as \"ERROR: <message>\" without an error code")
+	script := flag.String("f", "", "run the commands in the file at `path` before reading stdin")
+	eval := flag.String("eval", "", "run the semicolon-separated `commands` before reading stdin, after any -f file")
+	strict := flag.Bool("strict", false, "exit with status 1 at the end of stdin if any command failed")
+	failFast := flag.Bool("fail-fast", false, "stop reading stdin and exit with status 2 at the first command that fails, after writing its error")
+	noStdin := flag.Bool("no-stdin", false, "exit, or keep serving HTTP, once the -f and --eval commands have run instead of reading stdin")
+	latency := flag.Int("latency", 0, "delay every line protocol and RESP response by `millis` milliseconds, to simulate a slow cache")
+	unbuffered := flag.Bool("unbuffered", false, "flush after every response even when stdin is not a terminal")
+	preload := flag.String("preload", "", "warm the default cache with the key/value lines of `path` when it is created")
+	dumpOnExit := flag.String("dump-on-exit", "", "on EOF or SIGINT/SIGTERM, SAVE the selected cache to `path` before exiting")
+	auditPath := flag.String("audit", "", "append a timestamped record of each state-changing command and its response to `path`")
+	auditReads := flag.Bool("audit-reads", false, "with --audit, record reads such as GET as well")
+	auditMax := flag.Int("audit-max-value", 256, "with --audit, truncate arguments and responses to `n` bytes (0 for no limit)")
+	password := flag.String("password", "", "in server mode, require AUTH `secret` on TCP connections and it as a bearer token over HTTP")
+	authFile := flag.String("auth-file", "", "like --password, with the secret read from the first line of `path`")
+	backing := flag.String("backing", "", "write the default cache through to a store of one file per key in directory `dir`, and read misses back from it")
+	negativeTTL := flag.Duration("negative-ttl", 0, "with --backing, remember for `duration` that the store lacks a key instead of asking it again (0 to turn off)")
+	maxCheckpoints := flag.Int("max-checkpoints", 8, "keep at most `n` CHECKPOINT snapshots at once")
+	ttlJitter := flag.Float64("ttl-jitter", 0, "scale every TTL by a random factor within `fraction` of 1, so entries written together expire apart (0 to turn off)")
+	tenantQuota := flag.Int("tenant-quota", 0, "let connections name a TENANT and each tenant hold at most `percent` of the cache (0 to turn tenants off)")
+	readOnly := flag.Bool("readonly", false, "start in read-only mode, refusing every command that changes a cache until READONLY OFF")
+	maxHeapMB := flag.Int("max-heap-mb", 0, "shed cache entries whenever the Go heap grows past `mb` megabytes (0 to turn off)")
+	commandTimeout := flag.Duration("command-timeout", 0, "in server mode, fail reads and writes that wait on the lock or the backing store longer than `duration` with ERR_TIMEOUT (0 for no limit)")
+	replicateOf := flag.String("replicate-of", "", "in server mode, follow the server at `address` into the default cache, authenticating with --password if set")
+	verbose := flag.String("verbose", "", "write a line to stderr for each cache event of the comma-separated `classes`: evict, expire, hit, miss or all")
+	selftest := flag.Bool("selftest", false, "time Get and Put on caches of 1k, 100k and 1M entries, print a table and exit, failing if the largest is over --selftest-factor times slower")
+	selftestFactor := flag.Float64("selftest-factor", defaultSelftestFactor, "with --selftest, how many times slower than at 1k entries Get and Put may be at 1M")
+	storageBench := flag.Bool("storage-bench", false, "fill a cache of 1M entries with and without --compact-storage, print the heap, garbage collection and Get and Put latency of each and exit")
+	check := flag.String("check", "", "run the conformance scenarios against the implementation at `path`, print a report and exit")
+	consistencyLogs := flag.String("check-consistency", "", "check the comma-separated client logs at `paths`, made with SEQ ON, for reads that went back in time, print a report and exit")
+	checkTimeout := flag.Duration("check-timeout", conformance.DefaultTimeout, "with --check, fail a scenario the implementation has not finished within `duration`")
+	logLevel := flag.String("log-level", "info", "write log lines about internal problems to stderr at `level` and above: debug, info, warn or error")
+	configPath := flag.String("config", "", "read options from the name=value or JSON file at `path`; flags override LRUCACHE_ environment variables, which override the file")
+	compact := flag.Bool("compact-storage", false, "keep string values in large shared slabs rather than one allocation each, to ease the garbage collector's work on large caches")
+	keyIndex := flag.Bool("key-index", false, "keep the keys of every cache sorted as well, so KEYS with a prefix, DELPREFIX and RANGEKEYS cost O(log n + k) rather than O(n), at O(log n) more per write")
+	keyIndexBench := flag.Bool("key-index-bench", false, "fill a cache of 1M entries with and without --key-index, print the Put latency and prefix and range query time of each and exit")
+	compress := flag.Int("compress-min", 0, "store string values of at least `n` bytes compressed when that makes them smaller (0 to turn off)")
+	flag.Parse()
+	config, err := LoadConfig(flag.CommandLine, *configPath, os.Environ())
+	if err == nil {
+		err = config.Validate()
+	}
+	if err == nil {
+		err = config.Apply()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	compactStorage = *compact
 
-func (c *LRUCache) Size() int {
-	return len(c.cache)
-}
+	if *resp && *listen == "" {
+		fmt.Fprintln(os.Stderr, "Error: --resp requires --listen")
+		os.Exit(1)
+	}
 
-func main() {
-	var cache *LRUCache
-	scanner := bufio.NewScanner(os.Stdin)
+	if *selftest {
+		if !runSelftest(os.Stdout, *selftestFactor) {
+			os.Exit(1)
+		}
+		return
+	}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+	if *storageBench {
+		runStorageBench(os.Stdout)
+		return
+	}
+
+	if *keyIndexBench {
+		runKeyIndexBench(os.Stdout)
+		return
+	}
+
+	if *check != "" {
+		failed, err := conformance.Run(os.Stdout, *check, *checkTimeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if failed > 0 {
+			os.Exit(1)
 		}
+		return
+	}
 
-		parts := strings.Fields(line)
-		command := parts[0]
+	if *consistencyLogs != "" {
+		violations, err := checkConsistency(os.Stdout, strings.Split(*consistencyLogs, ","))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if violations > 0 {
+			os.Exit(1)
+		}
+		return
+	}
 
-		switch command {
-		case "INIT":
-			if len(parts) < 2 {
-				fmt.Println("ERROR: INIT requires capacity argument")
-				continue
-			}
-			capacity, err := strconv.Atoi(parts[1])
-			if err != nil {
-				fmt.Printf("ERROR: Invalid capacity: %v\n", err)
-				continue
-			}
-			cache = NewLRUCache(capacity)
-			fmt.Println("OK")
+	if *replay != "" {
+		if *capacity < 1 {
+			fmt.Fprintln(os.Stderr, "Error: --replay requires --capacity >= 1")
+			os.Exit(1)
+		}
+		result, err := replayTrace(NewLRUCache[string, Value](*capacity), *replay)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(result)
+		return
+	}
 
-		case "PUT":
-			if cache == nil {
-				fmt.Println("ERROR: Cache not initialized")
-				continue
-			}
-			if len(parts) < 3 {
-				fmt.Println("ERROR: PUT requires key and value arguments")
-				continue
+	s := &session{
+		json:        *jsonMode,
+		framed:      *framed,
+		maxLine:     *maxLine,
+		maxKeyLen:   *maxKey,
+		maxValueLen: *maxValue,
+		sweepEvery:  *sweepInterval,
+		maxHeap:     uint64(max(*maxHeapMB, 0)) << 20,
+		unbuffered:  *unbuffered,
+		preload:     *preload,
+
+		maxCheckpoints: *maxCheckpoints,
+		tenantQuota:    *tenantQuota,
+		ttlJitter:      *ttlJitter,
+		keyIndex:       *keyIndex,
+		compressMin:    max(*compress, 0),
+
+		legacyErrors:   *legacyErrors,
+		latency:        time.Duration(*latency) * time.Millisecond,
+		commandTimeout: *commandTimeout,
+		config:         config,
+		servesHTTP:     *httpAddr != "",
+		stopping:       make(chan struct{}),
+	}
+	if *latency < 0 || s.latency > maxDelay {
+		fmt.Fprintf(os.Stderr, "Error: --latency must be from 0 to %d milliseconds\n", maxDelay/time.Millisecond)
+		os.Exit(1)
+	}
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		s.unbuffered = true
+	}
+	if *testClock {
+		s.clock = fakeclock.New(time.Now())
+	}
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --log-level: %v\n", err)
+		os.Exit(1)
+	}
+	logs.setLevel(level)
+	if *verbose != "" {
+		classes, err := parseEventClasses(*verbose)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --verbose: %v\n", err)
+			os.Exit(1)
+		}
+		s.narrate(classes, 0)
+	}
+	input, err := scriptInput(*script, *eval, !*noStdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if input != os.Stdin && *listen != "" {
+		fmt.Fprintln(os.Stderr, "Error: -f, --eval and --no-stdin cannot be used with --listen")
+		os.Exit(1)
+	}
+	if (*strict || *failFast) && *listen != "" {
+		fmt.Fprintln(os.Stderr, "Error: --strict and --fail-fast cannot be used with --listen")
+		os.Exit(1)
+	}
+	s.strict, s.failFast = *strict, *failFast
+	if *password != "" || *authFile != "" {
+		if *listen == "" && *httpAddr == "" {
+			fmt.Fprintln(os.Stderr, "Error: --password and --auth-file require --listen or --http")
+			os.Exit(1)
+		}
+		var err error
+		if s.password, err = readPassword(*password, *authFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *aofPath != "" {
+		var err error
+		if s.aof, err = openAppendLog(*aofPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening AOF: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *commandTimeout < 0 || (*commandTimeout > 0 && *listen == "" && *httpAddr == "") {
+		fmt.Fprintln(os.Stderr, "Error: --command-timeout must not be negative and requires --listen or --http")
+		os.Exit(1)
+	}
+	if *negativeTTL != 0 && *backing == "" {
+		fmt.Fprintln(os.Stderr, "Error: --negative-ttl requires --backing")
+		os.Exit(1)
+	}
+	s.negativeTTL = max(*negativeTTL, 0)
+	if !(*ttlJitter >= 0 && *ttlJitter < 1) {
+		fmt.Fprintf(os.Stderr, "Error: --ttl-jitter: %v\n", ErrBadJitter)
+		os.Exit(1)
+	}
+	if *tenantQuota < 0 || *tenantQuota > 100 {
+		fmt.Fprintf(os.Stderr, "Error: --tenant-quota: %v\n", ErrBadQuota)
+		os.Exit(1)
+	}
+	if *backing != "" {
+		var err error
+		if s.backing, err = openDirStore(*backing); err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening backing store: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *auditPath != "" {
+		var err error
+		if s.audit, err = openAuditLog(*auditPath, *auditReads, *auditMax); err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening audit log: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// The TCP and HTTP front ends share one cache created up front, and
+	// stdin, if it is being read at all, uses that same cache.
+	if *listen != "" || *httpAddr != "" {
+		if *capacity < 1 {
+			fmt.Fprintln(os.Stderr, "Error: --listen and --http require --capacity >= 1")
+			os.Exit(1)
+		}
+		s.cache = NewLRUCache[string, Value](*capacity)
+		s.cache.savings = Value.saved
+		if s.compressMin > 0 {
+			s.cache.SetCompression(compressFrom(s.compressMin))
+		}
+		s.cache.SetETags(Value.etag)
+		if compactStorage {
+			s.cache.SetCompactStorage(Value.storeIn)
+		}
+		if s.keyIndex {
+			s.cache.SetKeyIndex(true)
+		}
+		if err := s.aof.Replay(s.cache); err != nil {
+			fmt.Fprintf(os.Stderr, "Error replaying AOF: %v\n", err)
+			os.Exit(1)
+		}
+		s.publishRemovals(defaultCacheName, s.cache)
+		s.cache.SetLimits(s.maxKeyLen, s.maxValueLen)
+		s.cache.SetTenantQuota(s.tenantQuota)
+		s.cache.SetTTLJitter(s.ttlJitter)
+		s.cache.SetLatencySampling(defaultLatencySample)
+		if s.clock != nil {
+			s.cache.SetClock(s.clock)
+		}
+		if s.sweepEvery > 0 {
+			s.cache.StartJanitor(s.sweepEvery)
+		}
+		if s.maxHeap > 0 {
+			s.cache.StartMemoryGuard(s.maxHeap)
+		}
+		// The cache is warm before either front end starts accepting.
+		if err := s.warm(s.cache); err != nil {
+			fmt.Fprintf(os.Stderr, "Error preloading: %v\n", err)
+			os.Exit(1)
+		}
+		s.setBacking(s.cache)
+		s.caches = map[string]*LRUCache[string, Value]{defaultCacheName: s.cache}
+		s.current = defaultCacheName
+		s.server = true
+	}
+	s.setReadOnly(*readOnly)
+	if *replicateOf != "" {
+		if _, _, err := net.SplitHostPort(*replicateOf); err != nil || s.cache == nil {
+			fmt.Fprintln(os.Stderr, "Error: --replicate-of requires --listen or --http and a host:port address")
+			os.Exit(1)
+		}
+		s.replicate(*replicateOf, s.password)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	// fatal receives the error of a front end that stopped on its own.
+	fatal := make(chan error, 2)
+
+	var hs *http.Server
+	if *httpAddr != "" {
+		handler := s.defaultTenant(newHTTPHandler(s))
+		if s.password != "" {
+			handler = s.requireBearer(handler)
+		}
+		hs = &http.Server{Addr: *httpAddr, Handler: handler}
+		go func() {
+			if err := hs.ListenAndServe(); err != http.ErrServerClosed {
+				fatal <- err
 			}
-			key := parts[1]
-			value := parts[2]
-			cache.Put(key, value)
-			fmt.Println("OK")
-
-		case "GET":
-			if cache == nil {
-				fmt.Println("ERROR: Cache not initialized")
-				continue
+		}()
+	}
+
+	var tcp *tcpServer
+	if *listen != "" {
+		handle := func(conn net.Conn) error { return s.serve(conn, conn, true) }
+		if *resp {
+			handle = func(conn net.Conn) error { return s.runRESP(conn) }
+		}
+		var err error
+		if tcp, err = listenTCP(*listen, handle); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := tcp.Serve(); err != nil {
+				fatal <- err
 			}
-			if len(parts) < 2 {
-				fmt.Println("ERROR: GET requires key argument")
-				continue
+		}()
+	}
+
+	var stdinDone chan error
+	if *listen == "" {
+		stdinDone = make(chan error, 1)
+		go func() { stdinDone <- s.run(input, os.Stdout) }()
+	}
+
+	code := 0
+	for waiting := true; waiting; {
+		select {
+		case <-sigs:
+			waiting = false
+		case err := <-fatal:
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			code, waiting = 1, false
+		case err := <-stdinDone:
+			switch {
+			case errors.Is(err, errFailFast):
+				code, waiting = 2, false
+			case errors.Is(err, errStrict):
+				code = 1
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+				code, waiting = 1, false
 			}
-			key := parts[1]
-			value, ok := cache.Get(key)
-			if !ok {
-				fmt.Println("NULL")
-			} else {
-				fmt.Println(value)
+			// Keep serving HTTP after stdin is closed.
+			waiting = waiting && hs != nil
+		}
+	}
+	if !s.shutdown(tcp, hs, *dumpOnExit) {
+		code = 1
+	}
+	os.Exit(code)
+}
+
+// scriptInput returns what the stdin stream reads: the -f file at path, if
+// any, then the --eval commands, then stdin itself if withStdin is set, as
+// one stream of lines, so that PUTRAW payloads and MODE carry over from one
+// to the next.
+func scriptInput(path, eval string, withStdin bool) (io.Reader, error) {
+	if path == "" && eval == "" && withStdin {
+		return os.Stdin, nil
+	}
+	var parts []io.Reader
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		// A last line without a newline must not run into the next part.
+		parts = append(parts, f, strings.NewReader("\n"))
+	}
+	if eval != "" {
+		parts = append(parts, strings.NewReader(strings.Join(splitCommands(eval), "\n")+"\n"))
+	}
+	if withStdin {
+		parts = append(parts, os.Stdin)
+	}
+	return io.MultiReader(parts...), nil
+}
+
+// defaultCacheName is the name INIT gives a cache when none is specified.
+const defaultCacheName = "default"
+
+// isCacheName reports whether an INIT argument is a cache name rather than
+// a policy argument: it starts with a letter.
+func isCacheName(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[0]
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// session is the state a stream of commands works against. In server mode
+// all connections share one session, so commands that would replace its
+// cache or log are rejected and the remaining fields are only read.
+type session struct {
+	cache  *LRUCache[string, Value]
+	aof    *appendLog
+	audit  *auditLog // nil unless --audit is given
+	server bool
+	// password, if set, must be given by each TCP connection with AUTH and
+	// by each HTTP request as a bearer token.
+	password string
+
+	// caches holds every cache created with INIT by name; cache is the
+	// selected one, named current.
+	caches  map[string]*LRUCache[string, Value]
+	current string
+
+	json bool // initial output mode for each stream
+	// strict and failFast make the stdin stream report failed commands
+	// in its result; see run.
+	strict, failFast bool
+	// framed starts each stream in MODE FRAMED instead.
+	framed bool
+	// legacyErrors makes every stream write errors without a code.
+	legacyErrors bool
+	// unbuffered makes run flush after every response instead of only
+	// before a read that could block.
+	unbuffered bool
+	// maxLine is the longest command line accepted, in bytes; 0 means no
+	// limit.
+	maxLine int
+	// maxKeyLen and maxValueLen are the limits applied to each new cache.
+	maxKeyLen, maxValueLen int
+	// sweepEvery is the janitor interval for each new cache; 0 means no
+	// janitor.
+	sweepEvery time.Duration
+	// maxHeap, set by --max-heap-mb, is the heap limit in bytes the memory
+	// guard of each new cache keeps to; 0 means no guard.
+	maxHeap uint64
+	// clock, when set by --test-clock, is the fake clock shared by every
+	// cache and advanced by DEBUG ADVANCECLOCK.
+	clock *fakeclock.Clock
+	// preload, when set by --preload, is the file warmCache loads into the
+	// default cache each time it is created, after the AOF is replayed.
+	preload string
+	// backing, when set by --backing, is the store the default cache
+	// writes through to, and negativeTTL how long that cache remembers a
+	// key the store does not have.
+	backing     *dirStore
+	negativeTTL time.Duration
+	// checkpoints holds the CHECKPOINT snapshots by name, at most
+	// maxCheckpoints of them.
+	checkpoints    map[string]Checkpoint[string, Value]
+	maxCheckpoints int
+	// tenantQuota, when set by --tenant-quota, is the share of each cache
+	// every tenant may hold, in percent; tenantMu runs the commands that
+	// use the cache one at a time, so that it knows whose they are.
+	tenantQuota int
+	tenantMu    sync.Mutex
+	// ttlJitter, when set by --ttl-jitter, is every cache's TTL jitter.
+	ttlJitter float64
+	// keyIndex, set by --key-index, keeps the keys of every cache sorted;
+	// see SetKeyIndex.
+	keyIndex bool
+	// compressMin, set by --compress-min, is the length from which every
+	// cache stores string values compressed; 0 turns compression off.
+	compressMin int
+	// benchSeed seeds the random number generator of every BENCH run, so
+	// repeated runs issue the same operations.
+	benchSeed int64
+	// latency, set by --latency, delays every TCP response; stopping is
+	// closed when shutdown starts, to cut delays short.
+	latency  time.Duration
+	stopping chan struct{}
+	// readOnly, set by READONLY and --readonly, refuses the commands that
+	// change a cache, for every connection at once.
+	readOnly atomic.Bool
+	// replica, set by REPLICATE and --replicate-of, follows another server
+	// into the default cache while writes are refused; replicaMu orders
+	// starting and stopping it.
+	replica   atomic.Pointer[replica]
+	replicaMu sync.Mutex
+	// writing is read-locked by each command that changes a cache while it
+	// runs and logs the change; SYNC write-locks it to take its snapshot
+	// between changes.
+	writing sync.RWMutex
+	// commandTimeout, set by --command-timeout, bounds how long GET and PUT
+	// wait for the cache over TCP, RESP and HTTP; see commandContext.
+	commandTimeout time.Duration
+	// config holds where each startup option came from, for CONFIG GET.
+	config *Config
+	// servesHTTP records --http, for HELLO.
+	servesHTTP bool
+	// feed passes the default cache's changes to SUBSCRIBE connections,
+	// and watches the events of its keys to GET /watch.
+	feed    changefeed
+	watches changefeed
+	// events passes every cache's evictions, expiries, hits and misses
+	// to their subscribers; narrated are the classes --verbose and EVENTS
+	// have it write to stderr, and stopNarrating unsubscribes the writer.
+	events        eventBus
+	eventsMu      sync.Mutex
+	narrated      eventClass
+	stopNarrating func()
+	// inflight is read-locked while a command runs; shutdown write-locks
+	// it to wait for running commands and keep new ones from starting.
+	inflight sync.RWMutex
+}
+
+// shutdownTimeout bounds how long shutdown waits for in-flight commands.
+const shutdownTimeout = 5 * time.Second
+
+// maxDelay bounds DEBUG SLEEP and --latency.
+const maxDelay = 60 * time.Second
+
+// parseDelay parses a DEBUG SLEEP or --latency delay in milliseconds.
+func parseDelay(arg string) (time.Duration, error) {
+	ms, err := strconv.Atoi(arg)
+	if err != nil || ms < 0 || ms > int(maxDelay/time.Millisecond) {
+		return 0, fmt.Errorf("Invalid milliseconds: %s (at most %d)", arg, maxDelay/time.Millisecond)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// sleep waits for d, or until shutdown starts. The caller must not hold any
+// cache's lock.
+func (s *session) sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-s.stopping:
+	}
+}
+
+// shutdown stops the TCP and HTTP front ends, if running, and waits up to
+// shutdownTimeout for the commands in flight to finish. It then saves the
+// selected cache to dumpPath, if set, prints its final stats to stderr and
+// closes the session. It reports whether the dump succeeded.
+func (s *session) shutdown(tcp *tcpServer, hs *http.Server, dumpPath string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	close(s.stopping)
+	if tcp != nil {
+		tcp.Shutdown(ctx)
+	}
+	if hs != nil {
+		hs.Shutdown(ctx)
+	}
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Lock()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr, "Shutdown: timed out waiting for commands in flight")
+	}
+
+	ok := true
+	if s.cache != nil {
+		if dumpPath != "" {
+			if _, err := saveSnapshot(s.cache, dumpPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Error dumping cache: %v\n", err)
+				ok = false
 			}
+		}
+		fmt.Fprintf(os.Stderr, "STATS %s\n", s.cache.Stats())
+	}
+	s.close()
+	return ok
+}
+
+// close stops the background work of every cache and closes the command
+// and audit logs.
+func (s *session) close() {
+	s.stopReplicating()
+	for _, cache := range s.caches {
+		cache.StopJanitor()
+		cache.StopMemoryGuard()
+		cache.StopRefresh()
+	}
+	s.aof.Close()
+	s.audit.Close()
+}
+
+// setBacking makes cache, the default cache, write through to the --backing
+// store if there is one. It is called once the AOF and --preload file are
+// loaded, as what they hold was written through when it was first written.
+func (s *session) setBacking(cache *LRUCache[string, Value]) {
+	if s.backing != nil {
+		cache.SetBackingStore(s.backing)
+		cache.SetNegativeTTL(s.negativeTTL)
+	}
+}
+
+// warm loads the --preload file, if there is one, into cache and reports
+// the outcome on stderr. The entries are not logged: the file is loaded
+// again whenever the cache is created.
+func (s *session) warm(cache *LRUCache[string, Value]) error {
+	if s.preload == "" {
+		return nil
+	}
+	result, err := warmCache(cache, s.preload, s.maxLine, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "PRELOAD %s\n", result)
+	return nil
+}
 
-		case "SIZE":
-			if cache == nil {
-				fmt.Println("ERROR: Cache not initialized")
-				continue
+// log appends line to the command log and publishes it to subscribers if
+// the default cache is selected; neither records changes to other caches.
+func (s *session) log(line string) {
+	if s.current == defaultCacheName {
+		s.aof.Append(line)
+		s.feed.publish(line)
+	}
+}
+
+// readLine reads one line from r, including its newline. A line longer than
+// max bytes (when max > 0) is read to its end and discarded rather than
+// buffered, and tooLong is reported instead.
+func readLine(r *bufio.Reader, max int) (line string, tooLong bool, err error) {
+	var buf []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLong {
+			buf = append(buf, chunk...)
+			if max > 0 && len(bytes.TrimRight(buf, "\r\n")) > max {
+				tooLong, buf = true, nil
 			}
-			fmt.Println(cache.Size())
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return string(buf), tooLong, err
+	}
+}
 
-		default:
-			fmt.Printf("ERROR: Unknown command: %s\n", command)
+// streamBuffer is the size of run's input and output buffers.
+const streamBuffer = 64 << 10
+
+// errStrict and errFailFast are what run returns, with --strict at the
+// end of the input and with --fail-fast at once, when a command failed.
+var (
+	errStrict   = errors.New("a command failed")
+	errFailFast = errors.New("a command failed; the rest of the input was not read")
+)
+
+// run executes the commands read from in, writing the responses to w.
+// Responses are buffered while more input is already at hand and flushed
+// before any read that could block, so a client waiting for a response
+// always gets it while a piped script is not slowed by a write per line.
+func (s *session) run(in io.Reader, w io.Writer) error {
+	return s.serve(in, w, false)
+}
+
+// serve is run for a stream that is a TCP connection if remote, which must
+// AUTH before anything else if the server has a password.
+func (s *session) serve(in io.Reader, w io.Writer, remote bool) error {
+	r := bufio.NewReaderSize(in, streamBuffer)
+	bw := bufio.NewWriterSize(w, streamBuffer)
+	out := &reply{w: bw, json: s.json, framedValues: s.framed && !s.json, legacyErrors: s.legacyErrors, protocol: 1,
+		remote: remote, locked: remote && s.password != "", failFast: s.failFast && !remote}
+	for {
+		line, tooLong, err := readLine(r, s.maxLine)
+		s.inflight.RLock()
+		if tooLong {
+			out.Errorf(CodeTooLarge, "Line exceeds %d bytes", s.maxLine)
+		} else if line != "" {
+			s.execute(r, out, line)
+		}
+		if tooLong || line != "" {
+			s.sleep(s.latency)
+		}
+		var flushErr error
+		if s.unbuffered || r.Buffered() == 0 || err != nil || out.hangUp {
+			flushErr = bw.Flush()
+		}
+		s.inflight.RUnlock()
+		if flushErr != nil {
+			return flushErr
+		}
+		if out.hangUp && out.failFast {
+			return errFailFast
+		}
+		if out.hangUp {
+			return nil
+		}
+		if err == io.EOF && s.strict && !remote && out.errorCount > 0 {
+			return errStrict
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-		os.Exit(1)
+// Execute runs a single protocol line and returns exactly what run would
+// have written for it, without the trailing newline. A PUTRAW payload is
+// read from the text after the first newline in line. The output mode set
+// by MODE carries over to later calls.
+func (s *session) Execute(line string) string {
+	var buf strings.Builder
+	out := &reply{w: &buf, json: s.json, framedValues: s.framed && !s.json, legacyErrors: s.legacyErrors, protocol: 1}
+	head, payload, _ := strings.Cut(line, "\n")
+	s.inflight.RLock()
+	defer s.inflight.RUnlock()
+	s.execute(bufio.NewReader(strings.NewReader(payload)), out, head)
+	s.json, s.framed = out.json, out.framedValues
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// execute runs a single protocol line, which may hold several commands
+// separated by semicolons; see cutCommand. Each gets its own response, in
+// order, whether or not the ones before it failed, and empty ones are
+// skipped. A raw command, ECHO, ends at a semicolon like any other, so to
+// print one it must be within a quoted token. Commands that carry a
+// payload after the line, such as PUTRAW, read it from in.
+func (s *session) execute(in *bufio.Reader, out *reply, line string) {
+	if isComment(line) {
+		return
+	}
+	for more := true; more; {
+		var cmd, rest string
+		cmd, rest, more = cutCommand(line)
+		s.executeCommand(in, out, cmd)
+		if out.hangUp {
+			return
+		}
+		line = rest
+	}
+}
+
+// executeCommand runs a single command.
+func (s *session) executeCommand(in *bufio.Reader, out *reply, line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || isComment(line) {
+		return
+	}
+	if untimed, timed := cutTime(line); timed || out.timeAll {
+		defer timeReply(out)()
+		line = untimed
+	}
+
+	// A raw command's text is not tokenized, so it may hold unbalanced
+	// quotes.
+	word, text := cutWord(line)
+	if c := commandIndex[strings.ToUpper(word)]; c != nil && c.raw {
+		parts := []string{c.name}
+		if text != "" {
+			parts = append(parts, text)
+		}
+		s.dispatch(c, in, out, line, parts)
+		return
+	}
+
+	parts, err := tokenize(line)
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	typed := parts[0]
+	normalizeCommand(parts)
+	c, ok := commandIndex[parts[0]]
+	if m := out.macros[parts[0]]; !ok && m != nil && m.alias {
+		s.runMacro(in, out, m, parts[1:])
+		return
+	}
+	if !ok {
+		out.Errorf(CodeUnknownCommand, "Unknown command: %s", typed)
+		return
+	}
+	s.dispatch(c, in, out, line, parts)
+}
+
+func (s *session) cmdInit(in *bufio.Reader, out *reply, line string, parts []string) {
+	// INIT BYTES <n> and INIT WEIGHTED <n> bound the cache by
+	// total size or total cost instead of entry count, and INIT
+	// TIERED <n> <l2> adds a second level of <l2> entries; the
+	// remaining arguments are the same. A trailing argument
+	// starting with a letter after the policy names the cache;
+	// policy arguments are always numeric.
+	args := parts[1:]
+	mode := ""
+	if len(args) > 0 && (args[0] == "BYTES" || args[0] == "WEIGHTED" || args[0] == "TIERED") {
+		mode, args = args[0], args[1:]
+	}
+	l2Capacity := 0
+	if mode == "TIERED" {
+		if len(args) < 2 {
+			out.Error(CodeArity, "INIT TIERED requires capacity and second level capacity arguments")
+			return
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			out.Errorf(CodeBadCapacity, "Invalid second level capacity: %s", args[1])
+			return
+		}
+		l2Capacity, args = n, slices.Delete(args, 1, 2)
+	}
+	// The options may come anywhere after the capacity, with or
+	// without a policy. TINYLFU turns on the admission filter.
+	admission := false
+	if i := slices.Index(args, "TINYLFU"); i >= 1 {
+		admission, args = true, slices.Delete(args, i, i+1)
+	}
+	// MIGRATE carries the entries of the cache being replaced over.
+	migrate := false
+	if i := slices.Index(args, "MIGRATE"); i >= 1 {
+		migrate, args = true, slices.Delete(args, i, i+1)
+	}
+	// NOEVICT turns eviction off, so writes to a full cache fail.
+	noEvict := false
+	if i := slices.Index(args, "NOEVICT"); i >= 1 {
+		noEvict, args = true, slices.Delete(args, i, i+1)
+	}
+	// WATERMARK <low> likewise turns on batch eviction.
+	lowWater := 0
+	if i := slices.Index(args, "WATERMARK"); i >= 1 {
+		if i+1 == len(args) {
+			out.Error(CodeArity, "WATERMARK requires low watermark argument")
+			return
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 1 {
+			out.Errorf(CodeInvalid, "Invalid low watermark: %s", args[i+1])
+			return
+		}
+		lowWater, args = n, slices.Delete(args, i, i+2)
+	}
+	// NORM <steps> normalizes keys.
+	var normalizer KeyNormalizer
+	if i := slices.Index(args, "NORM"); i >= 1 {
+		if i+1 == len(args) {
+			out.Error(CodeArity, "NORM requires steps argument")
+			return
+		}
+		fn, err := ParseKeyNormalizer(args[i+1])
+		if err != nil {
+			out.Errorf(CodeInvalid, "Invalid key normalization: %s", args[i+1])
+			return
+		}
+		normalizer, args = fn, slices.Delete(args, i, i+2)
+	}
+	// IDLE <seconds> sets the max idle time.
+	var maxIdle time.Duration
+	if i := slices.Index(args, "IDLE"); i >= 1 {
+		if i+1 == len(args) {
+			out.Error(CodeArity, "IDLE requires seconds argument")
+			return
+		}
+		d, err := parseTTL(args[i+1])
+		if err != nil {
+			out.Errorf(CodeInvalid, "Invalid idle time: %s", args[i+1])
+			return
+		}
+		maxIdle, args = d, slices.Delete(args, i, i+2)
+	}
+	name := defaultCacheName
+	if len(args) > 2 && isCacheName(args[len(args)-1]) && !slices.Contains(options["INIT"], args[len(args)-1]) {
+		name, args = args[len(args)-1], args[:len(args)-1]
+	}
+	if len(args) < 1 {
+		out.Error(CodeArity, "INIT requires capacity argument")
+		return
+	}
+	capacity, err := strconv.Atoi(args[0])
+	if err != nil {
+		out.Errorf(CodeBadCapacity, "Invalid capacity: %v", err)
+		return
+	}
+	if capacity < 1 {
+		out.Error(CodeBadCapacity, "capacity must be >= 1")
+		return
+	}
+	policyName := "LRU"
+	if len(args) > 1 {
+		policyName = args[1]
+	}
+	policy, err := newPolicy[string, Value](policyName, args[min(len(args), 2):])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	var cache *LRUCache[string, Value]
+	switch mode {
+	case "BYTES":
+		cache = newByteBoundedCache(capacity, policy, Value.Size)
+	case "WEIGHTED":
+		cache = NewLRUCacheWeighted(capacity, policy)
+	case "TIERED":
+		cache = newTieredCache(capacity, l2Capacity, policy)
+	default:
+		cache = NewLRUCacheWithPolicy(capacity, policy)
+	}
+	cache.savings = Value.saved
+	if s.compressMin > 0 {
+		cache.SetCompression(compressFrom(s.compressMin))
+	}
+	cache.SetETags(Value.etag)
+	if compactStorage {
+		cache.SetCompactStorage(Value.storeIn)
+	}
+	if s.keyIndex {
+		cache.SetKeyIndex(true)
+	}
+	if normalizer != nil {
+		cache.SetKeyNormalizer(normalizer)
+	}
+	if err := cache.SetLowWatermark(lowWater); err != nil {
+		out.Err(err)
+		return
+	}
+	if admission {
+		if err := cache.SetAdmission(true); err != nil {
+			out.Err(err)
+			return
+		}
+	}
+	cache.SetNoEviction(noEvict)
+	old := s.caches[name]
+	// The log only describes the default cache, and only the default
+	// cache is preloaded. A migrated cache has the old one's entries
+	// instead, which already reflect both.
+	if name == defaultCacheName && (old == nil || !migrate) {
+		if err := s.aof.Replay(cache); err != nil {
+			out.Err(err)
+			return
+		}
+		if err := s.warm(cache); err != nil {
+			out.Err(err)
+			return
+		}
+	}
+	if name == defaultCacheName {
+		s.setBacking(cache)
+	}
+	s.publishRemovals(name, cache)
+	cache.SetLimits(s.maxKeyLen, s.maxValueLen)
+	cache.SetTenantQuota(s.tenantQuota)
+	cache.SetTTLJitter(s.ttlJitter)
+	cache.SetLatencySampling(defaultLatencySample)
+	if s.clock != nil {
+		cache.SetClock(s.clock)
+	}
+	cache.SetMaxIdle(maxIdle)
+	if s.sweepEvery > 0 {
+		cache.StartJanitor(s.sweepEvery)
+	}
+	if s.maxHeap > 0 {
+		cache.StartMemoryGuard(s.maxHeap)
+	}
+	if s.caches == nil {
+		s.caches = make(map[string]*LRUCache[string, Value])
+	}
+	if old == nil {
+		s.caches[name] = cache
+		s.cache, s.current = cache, name
+		out.OK()
+		return
+	}
+	// The old cache's goroutines are stopped before it is migrated from,
+	// so that it no longer changes, and before it is let go.
+	old.StopJanitor()
+	old.StopMemoryGuard()
+	old.StopRefresh()
+	migrated := 0
+	if migrate {
+		migrated = cache.MigrateFrom(old)
+	}
+	dropped := max(old.Size()-migrated, 0)
+	s.caches[name] = cache
+	s.cache, s.current = cache, name
+	if migrate {
+		out.OKWith(fmt.Sprintf("reinitialized migrated=%d dropped=%d", migrated, dropped),
+			map[string]any{"reinitialized": true, "migrated": migrated, "dropped": dropped})
+		return
+	}
+	out.OKWith(fmt.Sprintf("reinitialized dropped=%d", dropped), map[string]any{"reinitialized": true, "dropped": dropped})
+}
+
+func (s *session) cmdAdmission(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[1] != "TINYLFU" && parts[1] != "NONE" {
+		out.Error(CodeArity, "ADMISSION requires TINYLFU or NONE")
+		return
+	}
+	if err := s.cache.SetAdmission(parts[1] == "TINYLFU"); err != nil {
+		out.Err(err)
+		return
+	}
+	out.OK()
+}
+
+func (s *session) cmdJanitor(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[1] != "ON" && parts[1] != "OFF" {
+		out.Error(CodeArity, "JANITOR requires ON <seconds> or OFF")
+		return
+	}
+	if parts[1] == "OFF" {
+		s.cache.StopJanitor()
+		out.OK()
+		return
+	}
+	if len(parts) < 3 {
+		out.Error(CodeArity, "JANITOR ON requires seconds argument")
+		return
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil || !(seconds > 0) || seconds > math.MaxInt64/float64(time.Second) {
+		out.Errorf(CodeInvalid, "Invalid interval: %s", parts[2])
+		return
+	}
+	s.cache.StartJanitor(time.Duration(seconds * float64(time.Second)))
+	out.OK()
+}
+
+// defaultRefreshThreshold is the fraction of its TTL an entry has left when
+// REFRESHSOURCE refreshes it, unless told otherwise.
+const defaultRefreshThreshold = 0.2
+
+func (s *session) cmdRefreshsource(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[1] == "OFF" {
+		s.cache.StopRefresh()
+		out.OK()
+		return
+	}
+	path := parts[1]
+	threshold := defaultRefreshThreshold
+	if len(parts) > 2 {
+		var err error
+		if threshold, err = strconv.ParseFloat(parts[2], 64); err != nil {
+			out.Errorf(CodeInvalid, "Invalid threshold: %s", parts[2])
+			return
+		}
+	}
+	if _, err := readKeyValues(path); err != nil {
+		out.Err(err)
+		return
+	}
+	// The file is read again for every refresh, so editing it changes what
+	// later refreshes load.
+	err := s.cache.StartRefresh(func(key string) (Value, error) {
+		values, err := readKeyValues(path)
+		if err != nil {
+			return Value{}, err
+		}
+		value, ok := values[key]
+		if !ok {
+			return Value{}, fmt.Errorf("%s: no value for %s", path, key)
+		}
+		return StringValue(value), nil
+	}, threshold)
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	out.OK()
+}
+
+// readKeyValues reads a file of key=value lines. Blank lines and comment
+// lines are skipped; the key ends at the first '='.
+func readKeyValues(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) == "" || isComment(line) {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: line %d: expected key=value", path, n+1)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (s *session) cmdWarm(in *bufio.Reader, out *reply, line string, parts []string) {
+	result, err := warmCache(s.cache, parts[1], s.maxLine, func(key, value string) {
+		s.log("PUT " + quoteToken(key) + " " + quoteToken(value))
+	})
+	if err != nil {
+		out.Err(err)
+		return
 	}
+	out.OKWith(result.String(), result)
+}
+
+func (s *session) cmdLimits(in *bufio.Reader, out *reply, line string, parts []string) {
+	maxKey, err1 := strconv.Atoi(parts[1])
+	maxValue, err2 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil || maxKey < 0 || maxValue < 0 {
+		out.Error(CodeInvalid, "Invalid limits: lengths must be non-negative integers")
+		return
+	}
+	s.cache.SetLimits(maxKey, maxValue)
+	out.OK()
+}
+
+func (s *session) cmdSetidle(in *bufio.Reader, out *reply, line string, parts []string) {
+	seconds, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || seconds < 0 {
+		out.Errorf(CodeInvalid, "Invalid idle time: %s", parts[1])
+		return
+	}
+	s.cache.SetMaxIdle(time.Duration(seconds * float64(time.Second)))
+	out.OK()
+}
+
+func (s *session) cmdSelectDrop(in *bufio.Reader, out *reply, line string, parts []string) {
+	command := parts[0]
+	name := parts[1]
+	cache, ok := s.caches[name]
+	if !ok {
+		out.Errorf(CodeNoSuchCache, "No such cache: %s", name)
+		return
+	}
+	if command == "SELECT" {
+		s.cache, s.current = cache, name
+	} else {
+		if s.cache == cache {
+			out.Errorf(CodeNotAllowed, "Cannot drop the selected cache: %s", name)
+			return
+		}
+		cache.StopJanitor()
+		cache.StopMemoryGuard()
+		cache.StopRefresh()
+		delete(s.caches, name)
+	}
+	out.OK()
+}
+
+func (s *session) cmdPing(in *bufio.Reader, out *reply, line string, parts []string) {
+	out.Result("PONG", "PONG")
+}
+
+// cmdEcho prints its text, which is the rest of the line as written.
+func (s *session) cmdEcho(in *bufio.Reader, out *reply, line string, parts []string) {
+	out.Result(parts[1], parts[1])
+}
+
+func (s *session) cmdVersion(in *bufio.Reader, out *reply, line string, parts []string) {
+	out.Result(Version, Version)
+}
+
+func (s *session) cmdCaches(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(s.caches) == 0 {
+		out.Result("EMPTY", []any{})
+		return
+	}
+	names := make([]string, 0, len(s.caches))
+	for name := range s.caches {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	lines := make([]string, len(names))
+	list := make([]map[string]any, len(names))
+	for i, name := range names {
+		stats := s.caches[name].Stats()
+		lines[i] = fmt.Sprintf("%s size=%d capacity=%d", name, stats.Size, stats.Capacity)
+		if name == s.current {
+			lines[i] += " selected"
+		}
+		list[i] = map[string]any{
+			"name":     name,
+			"size":     stats.Size,
+			"capacity": stats.Capacity,
+			"selected": name == s.current,
+		}
+	}
+	out.Result(strings.Join(lines, "\n"), list)
+}
+
+func (s *session) cmdPut(in *bufio.Reader, out *reply, line string, parts []string) {
+	key := parts[1]
+	value := parts[2]
+	var ttl, grace time.Duration
+	if len(parts) > 3 {
+		var err error
+		if ttl, err = parseTTL(parts[3]); err != nil {
+			out.Err(err)
+			return
+		}
+	}
+	if len(parts) > 4 {
+		var err error
+		if grace, err = parseTTL(parts[4]); err != nil {
+			out.Errorf(CodeInvalid, "Invalid grace: %s", parts[4])
+			return
+		}
+	}
+	ctx, cancel := s.commandContext(context.Background())
+	defer cancel()
+	if err := s.cache.PutCtx(ctx, key, StringValue(value), ttl, grace); err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	out.OK()
+}
+
+func (s *session) cmdMput(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts)%2 == 0 {
+		out.Error(CodeArity, "MPUT requires key and value pairs")
+		return
+	}
+	pairs := make([]KV[string, Value], 0, len(parts)/2)
+	for i := 1; i < len(parts); i += 2 {
+		pairs = append(pairs, KV[string, Value]{Key: parts[i], Value: StringValue(parts[i+1])})
+	}
+	if err := s.cache.PutMulti(pairs); err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	out.OKWith(strconv.Itoa(len(pairs)), len(pairs))
+}
+
+func (s *session) cmdPutw(in *bufio.Reader, out *reply, line string, parts []string) {
+	cost, err := strconv.Atoi(parts[3])
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid cost: %s", parts[3])
+		return
+	}
+	if err := s.cache.PutWeighted(parts[1], StringValue(parts[2]), cost); err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	out.OK()
+}
+
+func (s *session) cmdPutp(in *bufio.Reader, out *reply, line string, parts []string) {
+	prio, err := parsePriority(parts[3])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if err := s.cache.PutWithPriority(parts[1], StringValue(parts[2]), prio); err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	out.OK()
+}
+
+// parsePriority parses a PUTP priority, 0, 1 or 2.
+func parsePriority(s string) (Priority, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < int(PriorityLow) || n > int(PriorityHigh) {
+		return 0, fmt.Errorf("Invalid priority: %s", s)
+	}
+	return Priority(n), nil
+}
+
+func (s *session) cmdPutraw(in *bufio.Reader, out *reply, line string, parts []string) {
+	// Once the length is known the payload is always consumed, so
+	// that a rejected command does not leave its bytes to be parsed
+	// as commands; payloads refused up front are skipped without
+	// being buffered. PUTRAW therefore checks for a cache itself,
+	// after reading the length.
+	n, err := strconv.Atoi(parts[2])
+	if err != nil || n < 0 || n > maxRawValue {
+		out.Errorf(CodeInvalid, "Invalid length: %s", parts[2])
+		return
+	}
+	if s.cache == nil {
+		io.CopyN(io.Discard, in, int64(n))
+		out.Error(CodeNotInitialized, "Cache not initialized")
+		return
+	}
+	if err := s.cache.CheckLimits(parts[1], n); err != nil {
+		io.CopyN(io.Discard, in, int64(n))
+		out.Err(err)
+		return
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(in, buf); err != nil {
+		out.Error(CodeSyntax, "unexpected end of input reading PUTRAW value")
+		return
+	}
+	value := string(buf)
+	if err := s.cache.Put(parts[1], StringValue(value)); err != nil {
+		out.Err(err)
+		return
+	}
+	s.log("PUT " + quoteToken(parts[1]) + " " + quoteToken(value))
+	out.OK()
+}
+
+func (s *session) cmdGetraw(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, ok, err := s.cache.GetThrough(parts[1])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if !ok {
+		out.Null()
+		return
+	}
+	str, err := value.Str()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	out.RawValue(str)
+}
+
+func (s *session) cmdGet(in *bufio.Reader, out *reply, line string, parts []string) {
+	key := parts[1]
+	ctx, cancel := s.commandContext(context.Background())
+	defer cancel()
+	value, ok, err := s.cache.GetCtx(ctx, key)
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if !ok {
+		out.Null()
+		return
+	}
+	str, err := value.Str()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	out.Value(str)
+}
+
+func (s *session) cmdGetdef(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, hit := s.cache.GetDefault(parts[1], StringValue(parts[2]))
+	str, err := value.Str()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if hit {
+		out.Value(str)
+	} else {
+		out.Default(str)
+	}
+}
+
+func (s *session) cmdMget(in *bufio.Reader, out *reply, line string, parts []string) {
+	results := s.cache.GetMulti(parts[1:])
+	values := make([]*string, len(results))
+	// A key holding a list reads as missing, as it has no string value.
+	for i, r := range results {
+		if str, err := r.Value.Str(); r.Found && err == nil {
+			values[i] = &str
+		}
+	}
+	out.Values(values)
+}
+
+func (s *session) cmdGetset(in *bufio.Reader, out *reply, line string, parts []string) {
+	old, existed, err := s.cache.GetSet(parts[1], StringValue(parts[2]))
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	if !existed {
+		out.Null()
+	} else {
+		out.Value(old.String())
+	}
+}
+
+func (s *session) cmdGetorput(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, hit, err := s.cache.GetOrPut(parts[1], StringValue(parts[2]))
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	str, err := value.Str()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if hit {
+		out.Tagged("HIT", str)
+		return
+	}
+	s.log("PUT " + quoteToken(parts[1]) + " " + quoteToken(parts[2]))
+	out.Tagged("STORED", str)
+}
+
+func (s *session) cmdGetstale(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, stale, revalidate, ok := s.cache.GetStale(parts[1])
+	if !ok {
+		out.Null()
+		return
+	}
+	str, err := value.Str()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	switch {
+	case revalidate:
+		out.Tagged("REVALIDATE", str)
+	case stale:
+		out.Tagged("STALE", str)
+	default:
+		out.Value(str)
+	}
+}
+
+func (s *session) cmdPeek(in *bufio.Reader, out *reply, line string, parts []string) {
+	key := parts[1]
+	value, ok := s.cache.Peek(key)
+	if !ok {
+		out.Null()
+		return
+	}
+	str, err := value.Str()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	out.Value(str)
+}
+
+func (s *session) cmdExists(in *bufio.Reader, out *reply, line string, parts []string) {
+	flags := make([]string, len(parts)-1)
+	found := make([]int, len(parts)-1)
+	for i, key := range parts[1:] {
+		if s.cache.Contains(key) {
+			found[i] = 1
+		}
+		flags[i] = strconv.Itoa(found[i])
+	}
+	out.Result(strings.Join(flags, " "), found)
+}
+
+func (s *session) cmdSaveLoad(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[0] == "SAVE" {
+		info, err := saveSnapshot(s.cache, parts[1])
+		if err != nil {
+			out.Err(err)
+			return
+		}
+		out.OKWith(info.String(), info)
+		return
+	}
+	var opts RestoreOptions
+	for _, opt := range parts[2:] {
+		switch opt {
+		case "MERGE":
+			opts.Merge = true
+		case "COLD":
+			opts.Cold = true
+		default:
+			out.Errorf(CodeInvalid, "Invalid LOAD option: %s", opt)
+			return
+		}
+	}
+	if err := loadSnapshot(s.cache, parts[1], opts); err != nil {
+		out.Err(err)
+		return
+	}
+	// A loaded snapshot replaces everything the log describes.
+	if s.aof != nil {
+		if err := s.aof.Rewrite(s.cache); err != nil {
+			out.Err(err)
+			return
+		}
+	}
+	out.OK()
+}
+
+func (s *session) cmdExportImport(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[0] == "EXPORT" {
+		n, err := exportCache(s.cache, parts[1])
+		if err != nil {
+			out.Err(err)
+			return
+		}
+		out.OKWith(strconv.Itoa(n), n)
+		return
+	}
+	if err := importCache(s.cache, parts[1]); err != nil {
+		out.Err(err)
+		return
+	}
+	// Like LOAD, an import replaces everything the log describes.
+	if s.aof != nil {
+		if err := s.aof.Rewrite(s.cache); err != nil {
+			out.Err(err)
+			return
+		}
+	}
+	out.OK()
+}
+
+func (s *session) cmdAof(in *bufio.Reader, out *reply, line string, parts []string) {
+	if s.server && parts[1] != "REWRITE" {
+		out.Error(CodeNotAllowed, "AOF ON/OFF is not allowed in server mode")
+		return
+	}
+	switch parts[1] {
+	case "ON":
+		if len(parts) < 3 {
+			out.Error(CodeArity, "AOF ON requires path argument")
+			return
+		}
+		l, err := openAppendLog(parts[2])
+		if err != nil {
+			out.Err(err)
+			return
+		}
+		s.aof.Close()
+		s.aof = l
+	case "OFF":
+		s.aof.Close()
+		s.aof = nil
+	case "REWRITE":
+		if s.cache == nil {
+			out.Error(CodeNotInitialized, "Cache not initialized")
+			return
+		}
+		if err := s.aof.Rewrite(s.cache); err != nil {
+			out.Err(err)
+			return
+		}
+	default:
+		out.Errorf(CodeUnknownCommand, "Unknown AOF subcommand: %s", parts[1])
+		return
+	}
+	out.OK()
+}
+
+func (s *session) cmdMode(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[1] != "JSON" && parts[1] != "TEXT" && parts[1] != "FRAMED" {
+		out.Error(CodeArity, "MODE requires JSON, TEXT or FRAMED")
+		return
+	}
+	out.json, out.framedValues = parts[1] == "JSON", parts[1] == "FRAMED"
+	out.OK()
+}
+
+func (s *session) cmdDelete(in *bufio.Reader, out *reply, line string, parts []string) {
+	key := parts[1]
+	removed, err := s.cache.RemoveThrough(key)
+	switch {
+	case err != nil:
+		out.Err(err)
+	case removed:
+		s.log(line)
+		out.OK()
+	default:
+		out.Null()
+	}
+}
+
+func (s *session) cmdGetdel(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, ok := s.cache.GetAndDelete(parts[1])
+	if !ok {
+		out.Null()
+		return
+	}
+	s.log("DELETE " + quoteToken(parts[1]))
+	out.Value(value.String())
+}
+
+func (s *session) cmdRenameCopy(in *bufio.Reader, out *reply, line string, parts []string) {
+	command := parts[0]
+	var err error
+	if command == "RENAME" {
+		err = s.cache.Rename(parts[1], parts[2])
+	} else {
+		err = s.cache.Copy(parts[1], parts[2])
+	}
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	out.OK()
+}
+
+func (s *session) cmdClear(in *bufio.Reader, out *reply, line string, parts []string) {
+	s.cache.Clear()
+	s.log(line)
+	out.OK()
+}
+
+func (s *session) cmdExpire(in *bufio.Reader, out *reply, line string, parts []string) {
+	ttl, err := parseTTL(parts[2])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if s.cache.Expire(parts[1], ttl) {
+		s.log(line)
+		out.OK()
+	} else {
+		out.Null()
+	}
+}
+
+func (s *session) cmdExpireat(in *bufio.Reader, out *reply, line string, parts []string) {
+	t, err := parseUnixTime(parts[2])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if s.cache.ExpireAt(parts[1], t) {
+		s.log(line)
+		out.OK()
+	} else {
+		out.Null()
+	}
+}
+
+func (s *session) cmdPersist(in *bufio.Reader, out *reply, line string, parts []string) {
+	if s.cache.Persist(parts[1]) {
+		s.log(line)
+		out.OK()
+	} else {
+		out.Null()
+	}
+}
+
+func (s *session) cmdResize(in *bufio.Reader, out *reply, line string, parts []string) {
+	capacity, err := strconv.Atoi(parts[1])
+	if err != nil {
+		out.Errorf(CodeBadCapacity, "Invalid capacity: %v", err)
+		return
+	}
+	if capacity < 1 {
+		out.Error(CodeBadCapacity, "capacity must be >= 1")
+		return
+	}
+	evicted := s.cache.Resize(capacity)
+	s.log(line)
+	out.OKWith(strconv.Itoa(evicted), evicted)
+}
+
+func (s *session) cmdStress(in *bufio.Reader, out *reply, line string, parts []string) {
+	goroutines, err := strconv.Atoi(parts[1])
+	if err != nil || goroutines < 1 {
+		out.Errorf(CodeInvalid, "Invalid goroutines: %s", parts[1])
+		return
+	}
+	ops, err := strconv.Atoi(parts[2])
+	if err != nil || ops < 0 {
+		out.Errorf(CodeInvalid, "Invalid ops: %s", parts[2])
+		return
+	}
+	runStress(s.cache, goroutines, ops)
+	stats := s.cache.Stats()
+	out.OKWith(fmt.Sprintf("size=%d hits=%d misses=%d evictions=%d",
+		stats.Size, stats.Hits, stats.Misses, stats.Evictions), stats)
+}
+
+func (s *session) cmdBench(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) == 3 && parts[1] == "SEED" {
+		seed, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			out.Errorf(CodeInvalid, "Invalid seed: %s", parts[2])
+			return
+		}
+		s.benchSeed = seed
+		out.OK()
+		return
+	}
+	if s.cache == nil {
+		out.Error(CodeNotInitialized, "Cache not initialized")
+		return
+	}
+	if len(parts) == 3 && parts[1] == "CONTENTION" {
+		s.benchContention(out, parts[2])
+		return
+	}
+	ops, err := strconv.Atoi(parts[1])
+	if err != nil || ops < 0 {
+		out.Errorf(CodeInvalid, "Invalid ops: %s", parts[1])
+		return
+	}
+	keySpace, err := strconv.Atoi(parts[2])
+	if err != nil || keySpace < 1 {
+		out.Errorf(CodeInvalid, "Invalid keyspace: %s", parts[2])
+		return
+	}
+	rng := rand.New(rand.NewSource(s.benchSeed))
+	nextKey := func() int { return rng.Intn(keySpace) }
+	args := parts[3:]
+	if len(args) > 0 && args[0] == "ZIPF" {
+		if len(args) < 2 {
+			out.Error(CodeArity, "BENCH zipf requires an exponent argument")
+			return
+		}
+		exponent, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || !(exponent > 0) || math.IsInf(exponent, 0) {
+			out.Errorf(CodeInvalid, "Invalid exponent: %s", args[1])
+			return
+		}
+		nextKey = newZipf(rng, exponent, keySpace).Next
+		args = args[2:]
+	}
+	readRatio := 0.5
+	if len(args) > 0 {
+		readRatio, err = strconv.ParseFloat(args[0], 64)
+		if err != nil || !(readRatio >= 0 && readRatio <= 1) {
+			out.Errorf(CodeInvalid, "Invalid read ratio: %s", args[0])
+			return
+		}
+	}
+	result := runBench(s.cache, rng, nextKey, ops, readRatio)
+	out.OKWith(result.String(), result)
+}
+
+func (s *session) cmdReplay(in *bufio.Reader, out *reply, line string, parts []string) {
+	result, err := replayTrace(s.cache, parts[1])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	// The replayed accesses are not logged one by one.
+	if s.aof != nil {
+		if err := s.aof.Rewrite(s.cache); err != nil {
+			out.Err(err)
+			return
+		}
+	}
+	out.OKWith(result.String(), result)
+}
+
+func (s *session) cmdSimulate(in *bufio.Reader, out *reply, line string, parts []string) {
+	capacity, err := strconv.Atoi(parts[2])
+	if err != nil || capacity < 1 {
+		out.Errorf(CodeBadCapacity, "Invalid capacity: %s", parts[2])
+		return
+	}
+	results, invalid, err := simulateTrace(parts[1], capacity, strings.Split(parts[3], ","))
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	lines := make([]string, 0, len(results)+1)
+	for _, r := range results {
+		lines = append(lines, r.String())
+	}
+	lines = append(lines, fmt.Sprintf("invalid=%d", invalid))
+	out.Result(strings.Join(lines, "\n"), map[string]any{"policies": results, "invalid": invalid})
+}
+
+func (s *session) cmdPopOldestNewest(in *bufio.Reader, out *reply, line string, parts []string) {
+	command := parts[0]
+	var key string
+	var value Value
+	var ok bool
+	switch command {
+	case "POP":
+		if key, value, ok = s.cache.RemoveOldest(); ok {
+			s.log("DELETE " + quoteToken(key))
+		}
+	case "OLDEST":
+		key, value, ok = s.cache.Oldest()
+	case "NEWEST":
+		key, value, ok = s.cache.Newest()
+	}
+	if !ok {
+		out.Null()
+		return
+	}
+	out.Result(key+" "+value.String(), map[string]any{"key": key, "value": value})
+}
+
+func (s *session) cmdRandomkey(in *bufio.Reader, out *reply, line string, parts []string) {
+	key, ok := s.cache.RandomKey()
+	if !ok {
+		out.Null()
+		return
+	}
+	out.Result(key, key)
+}
+
+func (s *session) cmdSeed(in *bufio.Reader, out *reply, line string, parts []string) {
+	seed, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid seed: %s", parts[1])
+		return
+	}
+	s.cache.Seed(seed)
+	out.OK()
+}
+
+func (s *session) cmdIncrDecr(in *bufio.Reader, out *reply, line string, parts []string) {
+	command := parts[0]
+	delta := int64(1)
+	if len(parts) > 2 {
+		var err error
+		if delta, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+			out.Errorf(CodeInvalid, "Invalid delta: %s", parts[2])
+			return
+		}
+	}
+	if command == "DECR" {
+		if delta == math.MinInt64 {
+			out.Err(ErrOverflow)
+			return
+		}
+		delta = -delta
+	}
+	n, err := incrementValue(s.cache, parts[1], delta)
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	out.Result(strconv.FormatInt(n, 10), n)
+}
+
+func (s *session) cmdAppend(in *bufio.Reader, out *reply, line string, parts []string) {
+	n, err := appendValue(s.cache, parts[1], parts[2])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	out.Result(strconv.Itoa(n), n)
+}
+
+func (s *session) cmdPush(in *bufio.Reader, out *reply, line string, parts []string) {
+	n, err := pushValue(s.cache, parts[1], parts[2], parts[0] == "LPUSH")
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	out.Result(strconv.Itoa(n), n)
+}
+
+func (s *session) cmdLlen(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, ok, err := s.cache.GetThrough(parts[1])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	n := 0
+	if ok {
+		items, err := value.List()
+		if err != nil {
+			out.Err(err)
+			return
+		}
+		n = len(items)
+	}
+	out.Result(strconv.Itoa(n), n)
+}
+
+func (s *session) cmdLrange(in *bufio.Reader, out *reply, line string, parts []string) {
+	start, err := strconv.Atoi(parts[2])
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid start: %s", parts[2])
+		return
+	}
+	stop, err := strconv.Atoi(parts[3])
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid stop: %s", parts[3])
+		return
+	}
+	value, ok, err := s.cache.GetThrough(parts[1])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	var items []string
+	if ok {
+		if items, err = value.List(); err != nil {
+			out.Err(err)
+			return
+		}
+	}
+	items = listRange(items, start, stop)
+	if len(items) == 0 {
+		out.Result("EMPTY", []string{})
+	} else {
+		out.Result(strings.Join(items, "\n"), items)
+	}
+}
+
+func (s *session) cmdHset(in *bufio.Reader, out *reply, line string, parts []string) {
+	added, err := hashSet(s.cache, parts[1], parts[2], parts[3])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	s.log(line)
+	if added {
+		out.Result("1", 1)
+	} else {
+		out.Result("0", 0)
+	}
+}
+
+func (s *session) cmdHget(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, ok, err := s.cache.GetThrough(parts[1])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if !ok {
+		out.Null()
+		return
+	}
+	fields, err := value.Hash()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if v, ok := fields[parts[2]]; ok {
+		out.Value(v)
+	} else {
+		out.Null()
+	}
+}
+
+func (s *session) cmdHdel(in *bufio.Reader, out *reply, line string, parts []string) {
+	removed, err := hashDelete(s.cache, parts[1], parts[2])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if removed {
+		s.log(line)
+		out.Result("1", 1)
+	} else {
+		out.Result("0", 0)
+	}
+}
+
+func (s *session) cmdHgetall(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, ok, err := s.cache.GetThrough(parts[1])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	fields := map[string]string{}
+	if ok {
+		if fields, err = value.Hash(); err != nil {
+			out.Err(err)
+			return
+		}
+	}
+	if len(fields) == 0 {
+		out.Result("EMPTY", fields)
+		return
+	}
+	names := slices.Sorted(maps.Keys(fields))
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = name + " " + fields[name]
+	}
+	out.Result(strings.Join(lines, "\n"), fields)
+}
+
+func (s *session) cmdPutifSetnx(in *bufio.Reader, out *reply, line string, parts []string) {
+	command := parts[0]
+	var ok bool
+	var err error
+	if command == "PUTIF" {
+		ok, err = s.cache.PutIfEquals(parts[1], StringValue(parts[2]), StringValue(parts[3]))
+	} else {
+		ok, err = s.cache.PutIfAbsent(parts[1], StringValue(parts[2]))
+	}
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if ok {
+		s.log(line)
+		out.Result("1", 1)
+	} else {
+		out.Result("0", 0)
+	}
+}
+
+func (s *session) cmdTouch(in *bufio.Reader, out *reply, line string, parts []string) {
+	if s.cache.Touch(parts[1]) {
+		s.log(line)
+		out.Result("1", 1)
+	} else {
+		out.Result("0", 0)
+	}
+}
+
+func (s *session) cmdPinUnpin(in *bufio.Reader, out *reply, line string, parts []string) {
+	command := parts[0]
+	ok := false
+	if command == "PIN" {
+		ok = s.cache.Pin(parts[1])
+	} else {
+		ok = s.cache.Unpin(parts[1])
+	}
+	if ok {
+		s.log(line)
+		out.Result("1", 1)
+	} else {
+		out.Result("0", 0)
+	}
+}
+
+func (s *session) cmdInfo(in *bufio.Reader, out *reply, line string, parts []string) {
+	info, ok := s.cache.EntryInfo(parts[1])
+	if !ok {
+		out.Null()
+		return
+	}
+	ttl := "none"
+	fields := map[string]any{
+		"key":  info.Key,
+		"hits": info.Hits,
+		"age":  info.Age.Seconds(),
+		"idle": info.Idle.Seconds(),
+		"ttl":  nil,
+		"pos":  info.Position,
+	}
+	if info.TTL > 0 {
+		ttl = fmt.Sprintf("%.3f", info.TTL.Seconds())
+		fields["ttl"] = info.TTL.Seconds()
+	}
+	out.Result(fmt.Sprintf("key=%s hits=%d age=%.3f idle=%.3f ttl=%s pos=%d",
+		info.Key, info.Hits, info.Age.Seconds(), info.Idle.Seconds(), ttl, info.Position), fields)
+}
+
+func (s *session) cmdSizeof(in *bufio.Reader, out *reply, line string, parts []string) {
+	size, ok := s.cache.EstimateSize(parts[1])
+	if !ok {
+		out.Null()
+		return
+	}
+	out.Result(strconv.Itoa(size), size)
+}
+
+func (s *session) cmdDelprefix(in *bufio.Reader, out *reply, line string, parts []string) {
+	n := s.cache.DeletePrefix(parts[1])
+	if n > 0 {
+		s.log(line)
+	}
+	out.Result(strconv.Itoa(n), n)
+}
+
+func (s *session) cmdScan(in *bufio.Reader, out *reply, line string, parts []string) {
+	cursor, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid cursor: %s", parts[1])
+		return
+	}
+	count := 10
+	if len(parts) > 2 {
+		if count, err = strconv.Atoi(parts[2]); err != nil || count < 1 {
+			out.Errorf(CodeInvalid, "Invalid count: %s", parts[2])
+			return
+		}
+	}
+	keys, next := s.cache.Scan(cursor, count)
+	text := strconv.FormatUint(next, 10)
+	if len(keys) > 0 {
+		text += " " + strings.Join(keys, " ")
+	}
+	out.Result(text, map[string]any{"cursor": next, "keys": keys})
+}
+
+func (s *session) cmdDebug(in *bufio.Reader, out *reply, line string, parts []string) {
+	// SLEEP is not registered as needing the cache so that it does not
+	// hold up other tenants; see asTenant.
+	if parts[1] == "SLEEP" {
+		if len(parts) < 3 {
+			out.Error(CodeArity, "DEBUG SLEEP requires milliseconds argument")
+			return
+		}
+		d, err := parseDelay(parts[2])
+		if err != nil {
+			out.Err(err)
+			return
+		}
+		s.sleep(d)
+		out.OK()
+		return
+	}
+	if s.cache == nil {
+		out.Error(CodeNotInitialized, "Cache not initialized")
+		return
+	}
+	defer s.asTenant(out.tenant)()
+	switch parts[1] {
+	case "DUMP":
+		dump := s.cache.DebugDump()
+		out.Result(dump, strings.Split(dump, "\n"))
+	case "CHECK":
+		if err := s.cache.DebugCheck(); err != nil {
+			out.Error(CodeInternal, err.Error())
+			return
+		}
+		out.OK()
+	case "OBJECT":
+		if len(parts) < 3 {
+			out.Error(CodeArity, "DEBUG OBJECT requires key argument")
+			return
+		}
+		s.debugObject(out, parts[2])
+	case "ADVANCECLOCK":
+		// Only exists under --test-clock.
+		if s.clock == nil {
+			out.Errorf(CodeUnknownCommand, "Unknown DEBUG subcommand: %s", parts[1])
+			return
+		}
+		if len(parts) < 3 {
+			out.Error(CodeArity, "DEBUG ADVANCECLOCK requires seconds argument")
+			return
+		}
+		seconds, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || !(seconds >= 0) || seconds > math.MaxInt64/float64(time.Second) {
+			out.Errorf(CodeInvalid, "Invalid seconds: %s", parts[2])
+			return
+		}
+		s.clock.Advance(time.Duration(seconds * float64(time.Second)))
+		out.OK()
+	default:
+		out.Errorf(CodeUnknownCommand, "Unknown DEBUG subcommand: %s", parts[1])
+	}
+}
+
+// debugObject prints what DebugObject reports for key, or NULL if it has
+// no live entry.
+func (s *session) debugObject(out *reply, key string) {
+	info, ok := s.cache.DebugObject(key)
+	if !ok {
+		out.Null()
+		return
+	}
+	fields := map[string]any{
+		"type":      info.Value.Type(),
+		"length":    info.Value.Len(),
+		"cost":      info.Cost,
+		"tier":      info.Tier,
+		"segment":   info.Segment,
+		"hits":      info.Hits,
+		"frequency": nil,
+		"version":   info.Version,
+		"ttl":       nil,
+		"pinned":    info.Pinned,
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "type=%s length=%d cost=%d tier=%d segment=%s", info.Value.Type(), info.Value.Len(), info.Cost, info.Tier, info.Segment)
+	if info.PolicyState != "" {
+		fmt.Fprintf(&b, " policy_state=%s", quoteToken(info.PolicyState))
+		fields["policy_state"] = info.PolicyState
+	}
+	frequency := "none"
+	if info.Frequency >= 0 {
+		frequency = strconv.FormatInt(info.Frequency, 10)
+		fields["frequency"] = info.Frequency
+	}
+	ttl := "none"
+	if info.TTL > 0 {
+		ttl = fmt.Sprintf("%.3f", info.TTL.Seconds())
+		fields["ttl"] = info.TTL.Seconds()
+	}
+	fmt.Fprintf(&b, " hits=%d frequency=%s version=%d ttl=%s pinned=%t", info.Hits, frequency, info.Version, ttl, info.Pinned)
+	out.Result(b.String(), fields)
+}
+
+func (s *session) cmdKeys(in *bufio.Reader, out *reply, line string, parts []string) {
+	var keys []string
+	if len(parts) > 1 {
+		keys = s.cache.KeysWithPrefix(parts[1])
+	} else {
+		keys = s.cache.Keys()
+	}
+	s.showKeys(out, keys)
+}
+
+// showKeys writes keys as KEYS shows them.
+func (s *session) showKeys(out *reply, keys []string) {
+	if len(keys) == 0 {
+		out.Result("EMPTY", keys)
+		return
+	}
+	// Keys of low or high priority are shown with it, as key[0] or key[2].
+	shown := make([]string, len(keys))
+	for i, key := range keys {
+		shown[i] = key
+		if prio, ok := s.cache.Priority(key); ok && prio != PriorityNormal {
+			shown[i] = fmt.Sprintf("%s[%d]", key, prio)
+		}
+	}
+	out.Result(strings.Join(shown, " "), keys)
+}
+
+func (s *session) cmdStats(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) > 1 && parts[1] == "RESET" {
+		s.cache.ResetStats()
+		out.OK()
+		return
+	}
+	stats := s.cache.Stats()
+	s.linkStats(s.cache, &stats)
+	out.Result(stats.String(), stats)
+}
+
+func (s *session) cmdMemory(in *bufio.Reader, out *reply, line string, parts []string) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	estimate := s.cache.EstimateTotal()
+	text := fmt.Sprintf("entries=%d estimate=%d heap_alloc=%d", s.cache.Size(), estimate, mem.HeapAlloc)
+	result := map[string]any{"entries": s.cache.Size(), "estimate": estimate, "heap_alloc": mem.HeapAlloc}
+	if slabs, ok := s.cache.SlabStats(); ok {
+		text += " " + slabs.String()
+		result["slabs"] = slabs
+	}
+	out.Result(text, result)
+}
+
+func (s *session) cmdLatency(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) > 2 && parts[1] != "ON" {
+		out.Errorf(CodeArity, "LATENCY %s takes no arguments", parts[1])
+		return
+	}
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "RESET":
+			s.cache.ResetLatency()
+		case "OFF":
+			s.cache.SetLatencySampling(0)
+		case "ON":
+			every := defaultLatencySample
+			if len(parts) > 2 {
+				n, err := strconv.Atoi(parts[2])
+				if err != nil || n < 1 {
+					out.Errorf(CodeInvalid, "Invalid sample rate: %s", parts[2])
+					return
+				}
+				every = n
+			}
+			s.cache.SetLatencySampling(every)
+		default:
+			out.Error(CodeArity, "LATENCY takes RESET, ON [sample] or OFF")
+			return
+		}
+		out.OK()
+		return
+	}
+	summaries := s.cache.Latency()
+	if summaries == nil {
+		out.Error(CodeUnsupported, "Latency tracking is off")
+		return
+	}
+	lines := make([]string, len(summaries))
+	for i, summary := range summaries {
+		lines[i] = summary.String()
+	}
+	out.Result(strings.Join(lines, "\n"), summaries)
+}
+
+func (s *session) cmdCheckpoint(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[1] == "DROP" {
+		if len(parts) != 3 {
+			out.Error(CodeArity, "CHECKPOINT DROP requires name argument")
+			return
+		}
+		if _, ok := s.checkpoints[parts[2]]; !ok {
+			out.Errorf(CodeInvalid, "No such checkpoint: %s", parts[2])
+			return
+		}
+		delete(s.checkpoints, parts[2])
+		out.OK()
+		return
+	}
+	if len(parts) != 2 {
+		out.Error(CodeArity, "CHECKPOINT takes a name or DROP <name>")
+		return
+	}
+	if _, ok := s.checkpoints[parts[1]]; !ok && len(s.checkpoints) >= s.maxCheckpoints {
+		out.Errorf(CodeNotAllowed, "Too many checkpoints (max %d); free one with CHECKPOINT DROP", s.maxCheckpoints)
+		return
+	}
+	if s.checkpoints == nil {
+		s.checkpoints = make(map[string]Checkpoint[string, Value])
+	}
+	s.checkpoints[parts[1]] = s.cache.Checkpoint()
+	out.OK()
+}
+
+// cmdDiff compares the selected cache with a checkpoint, which may have
+// been taken of another.
+func (s *session) cmdDiff(in *bufio.Reader, out *reply, line string, parts []string) {
+	cp, ok := s.checkpoints[parts[1]]
+	if !ok {
+		out.Errorf(CodeInvalid, "No such checkpoint: %s", parts[1])
+		return
+	}
+	d := cp.Diff(s.cache.Checkpoint())
+	lines := make([]string, 0, 4)
+	for _, section := range []struct {
+		name string
+		keys []string
+	}{{"added", d.Added}, {"removed", d.Removed}, {"changed", d.Changed}, {"moved", d.Moved}} {
+		quoted := make([]string, len(section.keys))
+		for i, key := range section.keys {
+			quoted[i] = quoteToken(key)
+		}
+		lines = append(lines, strings.TrimSpace(section.name+": "+strings.Join(quoted, " ")))
+	}
+	out.Result(strings.Join(lines, "\n"), d)
+}
+
+func (s *session) cmdProfile(in *bufio.Reader, out *reply, line string, parts []string) {
+	if (parts[1] == "ON") != (len(parts) == 3) {
+		if parts[1] == "ON" {
+			out.Error(CodeArity, "PROFILE ON requires a list of sizes")
+		} else {
+			out.Errorf(CodeArity, "PROFILE %s takes no arguments", parts[1])
+		}
+		return
+	}
+	switch parts[1] {
+	case "ON":
+		var multiples []float64
+		for _, size := range strings.Split(parts[2], ",") {
+			m, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(size), "x"), 64)
+			if err != nil || !(m > 0) || math.IsInf(m, 0) {
+				out.Errorf(CodeInvalid, "Invalid profile size: %s", size)
+				return
+			}
+			multiples = append(multiples, m)
+		}
+		if err := s.cache.SetProfile(multiples); err != nil {
+			out.Err(err)
+			return
+		}
+	case "OFF":
+		if err := s.cache.SetProfile(nil); err != nil {
+			out.Err(err)
+			return
+		}
+	case "REPORT":
+		sizes := s.cache.Profile()
+		if sizes == nil {
+			out.Error(CodeUnsupported, "Profiling is off")
+			return
+		}
+		lines := make([]string, len(sizes))
+		for i, size := range sizes {
+			lines[i] = size.String()
+		}
+		out.Result(strings.Join(lines, "\n"), sizes)
+		return
+	default:
+		out.Error(CodeArity, "PROFILE takes ON <sizes>, OFF or REPORT")
+		return
+	}
+	out.OK()
+}
+
+func (s *session) cmdUsedbytes(in *bufio.Reader, out *reply, line string, parts []string) {
+	stats := s.cache.Stats()
+	if stats.MaxBytes == 0 {
+		out.Error(CodeUnsupported, "Cache is not byte-bounded")
+		return
+	}
+	out.Result(strconv.Itoa(stats.UsedBytes), stats.UsedBytes)
+}
+
+func (s *session) cmdSize(in *bufio.Reader, out *reply, line string, parts []string) {
+	size := s.cache.Size()
+	out.Result(strconv.Itoa(size), size)
+}
+
+func (s *session) cmdCapacity(in *bufio.Reader, out *reply, line string, parts []string) {
+	capacity := s.cache.Capacity()
+	out.Result(strconv.Itoa(capacity), capacity)
+}
+
+func (s *session) cmdHeadroom(in *bufio.Reader, out *reply, line string, parts []string) {
+	headroom := s.cache.Headroom()
+	out.Result(strconv.Itoa(headroom), headroom)
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+// memoryCheckInterval is how often the memory guard samples the heap.
+// runtime.ReadMemStats stops the world briefly, so it is kept to a few
+// times a second, or twice as many while the heap is over the limit.
+const memoryCheckInterval = 250 * time.Millisecond
+
+// memoryShedBatch is the number of entries the memory guard evicts per
+// lock acquisition, which bounds how long it can hold up other operations.
+const memoryShedBatch = 64
+
+// memoryGuard is a goroutine that sheds entries while the heap is over a
+// limit. Closing stop asks it to exit and it closes done once it has.
+type memoryGuard struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartMemoryGuard starts a background goroutine that keeps the process's
+// heap, as runtime.MemStats.HeapAlloc reports it, under limit bytes,
+// replacing any guard already running. Whenever a sample finds the heap
+// over the limit it evicts entries in the policy's usual victim order,
+// batch by batch, until their estimated sizes add up to the excess over
+// nine tenths of the limit, or nothing but pinned entries is left. Removals
+// are reported as RemovalMemoryPressure and counted in Stats.MemoryShed.
+//
+// The heap also holds garbage not yet collected, so a sample over the
+// limit is followed by a collection and a second sample, and the guard
+// sheds only for what is still live. The heap is the process's, so with
+// several guarded caches each sheds its own entries.
+func (c *LRUCache[K, V]) StartMemoryGuard(limit uint64) {
+	c.StopMemoryGuard()
+	g := &memoryGuard{stop: make(chan struct{}), done: make(chan struct{})}
+	c.lock()
+	c.memGuard = g
+	c.unlock()
+
+	go func() {
+		defer close(g.done)
+		ticker := time.NewTicker(memoryCheckInterval)
+		defer ticker.Stop()
+		low := limit / 10 * 9
+		var ms runtime.MemStats
+		for {
+			select {
+			case <-g.stop:
+				return
+			case <-ticker.C:
+			}
+			c.protect("memguard", func() {
+				runtime.ReadMemStats(&ms)
+				if ms.HeapAlloc <= limit {
+					return
+				}
+				// HeapAlloc counts garbage not yet collected too,
+				// which shedding would not give back.
+				runtime.GC()
+				runtime.ReadMemStats(&ms)
+				if ms.HeapAlloc > limit {
+					c.shedMemory(int(ms.HeapAlloc - low))
+				}
+			})
+		}
+	}()
+}
+
+// StopMemoryGuard stops the memory guard, if one is running, and waits for
+// its goroutine to exit.
+func (c *LRUCache[K, V]) StopMemoryGuard() {
+	c.lock()
+	g := c.memGuard
+	c.memGuard = nil
+	c.unlock()
+	if g != nil {
+		close(g.stop)
+		<-g.done
+	}
+}
+
+// shedMemory evicts entries until their estimated sizes add up to excess
+// bytes or there is nothing left to evict.
+func (c *LRUCache[K, V]) shedMemory(excess int) {
+	for excess > 0 {
+		freed, n := c.shedBatch(memoryShedBatch, excess)
+		if n == 0 {
+			return
+		}
+		excess -= freed
+	}
+}
+
+// shedBatch evicts up to n entries under one lock acquisition, stopping
+// early once they add up to excess bytes, and returns their estimated size
+// and how many it evicted.
+func (c *LRUCache[K, V]) shedBatch(n, excess int) (freed, evicted int) {
+	c.lock()
+	defer c.unlock()
+
+	for evicted < n && freed < excess {
+		victim := c.evictNext(nil)
+		if victim == nil {
+			break
+		}
+		freed += c.footprint(victim)
+		c.evictFor(victim, RemovalMemoryPressure)
+		evicted++
+	}
+	return freed, evicted
+}
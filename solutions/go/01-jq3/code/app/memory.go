@@ -0,0 +1,78 @@
+package main
+
+import "unsafe"
+
+// Memory estimates count what an entry holds on the heap: its node, its
+// slots in the map and the resident slice, and the bytes its key and value
+// point to. Strings and byte slices are measured directly and values with a
+// heapBytes method, such as Value, measure themselves; anything else is
+// counted only as the part stored inline in the node. Allocator rounding,
+// map buckets left empty and the policy's own structures are not counted,
+// so the total is a floor rather than an exact figure.
+
+// heapBytes returns the bytes x refers to outside its own inline size.
+func heapBytes(x any) int {
+	switch x := x.(type) {
+	case string:
+		return len(x)
+	case []byte:
+		return cap(x)
+	case interface{ heapBytes() int }:
+		return x.heapBytes()
+	}
+	return 0
+}
+
+// nodeOverhead returns what every entry costs before its key and value
+// contents: the node struct, a copy of the key plus a pointer in the map,
+// and a pointer in the resident slice. It follows the Node definition, so
+// it stays right as fields are added.
+func nodeOverhead[K comparable, V any]() int {
+	var node Node[K, V]
+	return int(unsafe.Sizeof(node) + unsafe.Sizeof(node.key) + 2*unsafe.Sizeof(&node))
+}
+
+func (c *LRUCache[K, V]) footprint(node *Node[K, V]) int {
+	return c.nodeSize + heapBytes(node.key) + heapBytes(node.value) + cap(node.history)*int(unsafe.Sizeof(uint64(0)))
+}
+
+// EstimateSize returns an estimate of the memory the entry for key takes,
+// in bytes, without promoting it.
+func (c *LRUCache[K, V]) EstimateSize(key K) (int, bool) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		return 0, false
+	}
+	return c.footprint(node), true
+}
+
+// EstimateTotal returns the sum of EstimateSize over every entry held,
+// including expired ones not yet removed.
+func (c *LRUCache[K, V]) EstimateTotal() int {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	total := 0
+	for _, node := range c.resident {
+		total += c.footprint(node)
+	}
+	return total
+}
+
+// heapBytes counts a string as the bytes stored, compressed or not, a list
+// as its slice of string headers and their contents, and a hash as a key
+// and value header per field plus their contents.
+func (v Value) heapBytes() int {
+	const header = int(unsafe.Sizeof(""))
+	switch {
+	case v.list != nil:
+		return int(unsafe.Sizeof(*v.list)) + cap(v.list.items)*header + v.list.bytes
+	case v.hash != nil:
+		return int(unsafe.Sizeof(*v.hash)) + len(v.hash.fields)*2*header + v.hash.bytes
+	}
+	return len(v.str)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// counters are the cache's event counts. They are updated under the cache
+// lock like everything else, but as atomics, so that Counters can read them
+// without taking it.
+type counters struct {
+	hits, misses               atomic.Int64
+	evictions, expirations     atomic.Int64
+	deletions, replacements    atomic.Int64
+	idled                      atomic.Int64 // entries removed for going unused past the max idle time
+	memoryShed                 atomic.Int64 // entries the memory guard evicted
+	scheduledReset             atomic.Int64 // entries cleared by a scheduled reset
+	backingHits                atomic.Int64 // cache misses GetThrough found in the backing store
+	negativeHits               atomic.Int64 // cache misses answered by a tombstone instead of the backing store
+	staleRejects               atomic.Int64 // entries GetIfFresh found but turned down as written too long ago
+	autotunes                  atomic.Int64 // capacity changes made by autotuning
+	demotions, promotions      atomic.Int64 // entries moved between the levels of a tiered cache
+	rejected                   atomic.Int64 // writes refused by checkWrite, checkRoom or checkFull
+	admissionRejected          atomic.Int64 // inserts refused by the admission filter
+	evictionBatches            atomic.Int64 // evictOverflow calls that evicted anything
+	refreshes, refreshFailures atomic.Int64 // background refreshes that succeeded or failed
+	failedAuth                 atomic.Int64 // AUTH commands and HTTP requests with a wrong or no secret
+	restarts                   atomic.Int64 // background workers restarted after a panic
+}
+
+// Counters are the cache's event counts since it was created. Unlike the
+// counters in Stats they are never reset, so they only ever increase, as
+// monitoring systems expect of counters.
+type Counters struct {
+	Hits              int64 `json:"hits"`
+	Misses            int64 `json:"misses"`
+	Evictions         int64 `json:"evictions"`
+	Expirations       int64 `json:"expirations"`
+	Deleted           int64 `json:"deleted"`
+	Replaced          int64 `json:"replaced"`
+	Idle              int64 `json:"idle"`
+	MemoryShed        int64 `json:"memory_shed"`
+	ScheduledReset    int64 `json:"scheduled_reset"`
+	Rejected          int64 `json:"rejected"`
+	AdmissionRejected int64 `json:"admission_rejected"`
+	EvictionBatches   int64 `json:"eviction_batches"`
+	Refreshes         int64 `json:"refreshes"`
+	RefreshFailures   int64 `json:"refresh_failures"`
+	FailedAuth        int64 `json:"failed_auth"`
+	BackingHits       int64 `json:"backing_hits"`
+	NegativeHits      int64 `json:"negative_hits"`
+	StaleRejects      int64 `json:"stale_rejects"`
+	Autotunes         int64 `json:"autotunes"`
+	Demotions         int64 `json:"demotions"`
+	Promotions        int64 `json:"promotions"`
+	Restarts          int64 `json:"restarts"`
+}
+
+func (c *counters) load() Counters {
+	return Counters{
+		Hits:              c.hits.Load(),
+		Misses:            c.misses.Load(),
+		Evictions:         c.evictions.Load(),
+		Expirations:       c.expirations.Load(),
+		Deleted:           c.deletions.Load(),
+		Replaced:          c.replacements.Load(),
+		Idle:              c.idled.Load(),
+		MemoryShed:        c.memoryShed.Load(),
+		ScheduledReset:    c.scheduledReset.Load(),
+		Rejected:          c.rejected.Load(),
+		AdmissionRejected: c.admissionRejected.Load(),
+		EvictionBatches:   c.evictionBatches.Load(),
+		Refreshes:         c.refreshes.Load(),
+		RefreshFailures:   c.refreshFailures.Load(),
+		FailedAuth:        c.failedAuth.Load(),
+		BackingHits:       c.backingHits.Load(),
+		NegativeHits:      c.negativeHits.Load(),
+		StaleRejects:      c.staleRejects.Load(),
+		Autotunes:         c.autotunes.Load(),
+		Demotions:         c.demotions.Load(),
+		Promotions:        c.promotions.Load(),
+		Restarts:          c.restarts.Load(),
+	}
+}
+
+// Counters returns the cumulative event counts without taking the cache
+// lock. Each count is read atomically, but a write in progress may be
+// reflected in some counts and not yet in others.
+func (c *LRUCache[K, V]) Counters() Counters {
+	return c.counters.load()
+}
+
+// writeMetrics writes the counters and the gauges in stats in the
+// Prometheus text exposition format. Removals are one counter labelled
+// with the RemovalReason.
+func writeMetrics(w io.Writer, n Counters, stats Stats) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("lru_cache_hits_total", "counter", "Lookups that found a live entry.")
+	fmt.Fprintf(w, "lru_cache_hits_total %d\n", n.Hits)
+	metric("lru_cache_misses_total", "counter", "Lookups that found nothing.")
+	fmt.Fprintf(w, "lru_cache_misses_total %d\n", n.Misses)
+	metric("lru_cache_removals_total", "counter", "Entries and values that left the cache, by reason.")
+	for _, r := range []struct {
+		reason RemovalReason
+		count  int64
+	}{
+		{RemovalEvicted, n.Evictions},
+		{RemovalExpired, n.Expirations},
+		{RemovalDeleted, n.Deleted},
+		{RemovalReplaced, n.Replaced},
+		{RemovalIdle, n.Idle},
+		{RemovalMemoryPressure, n.MemoryShed},
+		{RemovalScheduledReset, n.ScheduledReset},
+	} {
+		fmt.Fprintf(w, "lru_cache_removals_total{reason=%q} %d\n", r.reason, r.count)
+	}
+	metric("lru_cache_rejected_total", "counter", "Writes refused for size, limits or lack of room.")
+	fmt.Fprintf(w, "lru_cache_rejected_total %d\n", n.Rejected)
+	metric("lru_cache_admission_rejected_total", "counter", "New keys turned away by the admission filter.")
+	fmt.Fprintf(w, "lru_cache_admission_rejected_total %d\n", n.AdmissionRejected)
+	metric("lru_cache_refreshes_total", "counter", "Background refreshes, by outcome.")
+	fmt.Fprintf(w, "lru_cache_refreshes_total{outcome=\"ok\"} %d\n", n.Refreshes)
+	fmt.Fprintf(w, "lru_cache_refreshes_total{outcome=\"failed\"} %d\n", n.RefreshFailures)
+	metric("lru_cache_failed_auth_total", "counter", "Commands and HTTP requests that gave a wrong password or none.")
+	fmt.Fprintf(w, "lru_cache_failed_auth_total %d\n", n.FailedAuth)
+	metric("lru_cache_backing_hits_total", "counter", "Cache misses found in the backing store.")
+	fmt.Fprintf(w, "lru_cache_backing_hits_total %d\n", n.BackingHits)
+	metric("lru_cache_negative_hits_total", "counter", "Cache misses answered by a tombstone without asking the backing store.")
+	fmt.Fprintf(w, "lru_cache_negative_hits_total %d\n", n.NegativeHits)
+	metric("lru_cache_stale_rejects_total", "counter", "Entries a freshness-bounded lookup found but turned down as too old.")
+	fmt.Fprintf(w, "lru_cache_stale_rejects_total %d\n", n.StaleRejects)
+	metric("lru_cache_autotunes_total", "counter", "Capacity changes made by autotuning.")
+	fmt.Fprintf(w, "lru_cache_autotunes_total %d\n", n.Autotunes)
+	metric("lru_cache_tier_moves_total", "counter", "Entries a tiered cache moved between its levels.")
+	fmt.Fprintf(w, "lru_cache_tier_moves_total{direction=\"demoted\"} %d\n", n.Demotions)
+	fmt.Fprintf(w, "lru_cache_tier_moves_total{direction=\"promoted\"} %d\n", n.Promotions)
+	metric("lru_cache_worker_restarts_total", "counter", "Background workers restarted after a panic.")
+	fmt.Fprintf(w, "lru_cache_worker_restarts_total %d\n", n.Restarts)
+
+	metric("lru_cache_size", "gauge", "Entries in the cache.")
+	fmt.Fprintf(w, "lru_cache_size %d\n", stats.Size)
+	metric("lru_cache_capacity", "gauge", "Maximum entry count; 0 when the cache is bounded by bytes or weight.")
+	fmt.Fprintf(w, "lru_cache_capacity %d\n", stats.Capacity)
+	if win := stats.Window; win != nil {
+		metric("lru_cache_window_lookups", "gauge", "Lookups in the window of recent lookups, up to its size.")
+		fmt.Fprintf(w, "lru_cache_window_lookups %d\n", win.Lookups)
+		metric("lru_cache_window_hit_ratio", "gauge", "Share of the lookups in the window that found a live entry.")
+		fmt.Fprintf(w, "lru_cache_window_hit_ratio %g\n", win.HitRatio)
+		metric("lru_cache_window_eviction_rate", "gauge", "Entries evicted per lookup over the window.")
+		fmt.Fprintf(w, "lru_cache_window_eviction_rate %g\n", win.EvictionRate)
+	}
+	if stats.MaxBytes > 0 {
+		metric("lru_cache_used_bytes", "gauge", "Bytes used by keys and values.")
+		fmt.Fprintf(w, "lru_cache_used_bytes %d\n", stats.UsedBytes)
+		metric("lru_cache_max_bytes", "gauge", "Byte budget.")
+		fmt.Fprintf(w, "lru_cache_max_bytes %d\n", stats.MaxBytes)
+	}
+	if stats.MaxWeight > 0 {
+		metric("lru_cache_used_weight", "gauge", "Total cost of the entries.")
+		fmt.Fprintf(w, "lru_cache_used_weight %d\n", stats.UsedWeight)
+		metric("lru_cache_max_weight", "gauge", "Weight budget.")
+		fmt.Fprintf(w, "lru_cache_max_weight %d\n", stats.MaxWeight)
+	}
+}
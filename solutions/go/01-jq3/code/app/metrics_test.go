@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape fetches the /metrics page of srv and returns each sample line,
+// name and labels, mapped to its value.
+func scrape(t *testing.T, srv *httptest.Server) map[string]string {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	samples := map[string]string{}
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		samples[line[:i]] = line[i+1:]
+	}
+	return samples
+}
+
+func TestMetricsAfterWorkload(t *testing.T) {
+	s := newServerSession(t, 3)
+	srv := httptest.NewServer(newHTTPHandler(s))
+	defer srv.Close()
+
+	script(t, s,
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"PUT c 3", "OK",
+		"GET a", "1",
+		"GET a", "1",
+		"GET x", "NULL",
+		"PUT d 4", "OK", // evicts b
+		"PUT a 10", "OK", // replaces 1
+		"DELETE c", "OK",
+		"GET b", "NULL",
+	)
+	want := map[string]string{
+		"lru_cache_hits_total":                        "2",
+		"lru_cache_misses_total":                      "2",
+		`lru_cache_removals_total{reason="evicted"}`:  "1",
+		`lru_cache_removals_total{reason="expired"}`:  "0",
+		`lru_cache_removals_total{reason="deleted"}`:  "1",
+		`lru_cache_removals_total{reason="replaced"}`: "1",
+		"lru_cache_rejected_total":                    "0",
+		"lru_cache_size":                              "2",
+		"lru_cache_capacity":                          "3",
+	}
+	got := scrape(t, srv)
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %q, want %s", name, got[name], value)
+		}
+	}
+
+	// Counters only go up; the size gauge follows the cache.
+	script(t, s, "GET a", "10", "CLEAR", "OK")
+	got = scrape(t, srv)
+	if got["lru_cache_hits_total"] != "3" || got[`lru_cache_removals_total{reason="deleted"}`] != "3" || got["lru_cache_size"] != "0" {
+		t.Errorf("after CLEAR: hits %s, deleted %s, size %s", got["lru_cache_hits_total"],
+			got[`lru_cache_removals_total{reason="deleted"}`], got["lru_cache_size"])
+	}
+}
+
+func TestMetricsBytesGauges(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s, "INIT BYTES 100", "OK", "PUT ab cde", "OK")
+	srv := httptest.NewServer(newHTTPHandler(s))
+	defer srv.Close()
+	got := scrape(t, srv)
+	if got["lru_cache_max_bytes"] != "100" || got["lru_cache_used_bytes"] == "" || got["lru_cache_used_bytes"] == "0" {
+		t.Errorf("bytes gauges: used %q, max %q", got["lru_cache_used_bytes"], got["lru_cache_max_bytes"])
+	}
+}
+
+func TestExpvarPublishesCache(t *testing.T) {
+	s := newServerSession(t, 3)
+	srv := httptest.NewServer(newHTTPHandler(s))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(body, &vars); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vars["cache"]; !ok {
+		t.Errorf("/debug/vars has no cache variable: %s", body)
+	}
+}
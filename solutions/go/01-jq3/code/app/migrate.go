@@ -0,0 +1,19 @@
+package main
+
+// MigrateFrom fills c, which must be empty, with the live entries of old,
+// as INIT MIGRATE does: the most recently used that fit, with their
+// values, TTLs and pinned flags, in the same order. c keeps its own
+// capacity and policy, which places the entries as it would any insert,
+// so an LFU cache starts them all at frequency 1; only the first level of
+// a tiered old cache comes across. Write numbers carry over too, and old
+// is left as it was. It returns how many entries came across.
+func (c *LRUCache[K, V]) MigrateFrom(old *LRUCache[K, V]) int {
+	header, entries, now := old.capture()
+
+	c.lock()
+	defer c.unlock()
+
+	c.restoreSeq(header.Seq)
+	c.replace(entries, now, false)
+	return len(c.cache)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// ErrNotInteger is returned by Increment when the existing value is not a
+// base-10 64-bit integer.
+var ErrNotInteger = errors.New("value is not an integer")
+
+// ErrOverflow is returned by Increment when the result would not fit in 64
+// bits. The stored value is left unchanged.
+var ErrOverflow = errors.New("increment would overflow")
+
+// errNotString is returned by the string operations on caches whose values
+// are not strings.
+var errNotString = errors.New("cache values are not strings")
+
+// Increment adds delta to the integer stored under key and returns the
+// result, storing delta itself if key is absent. It only works on caches
+// with string values. Like any write it promotes the entry and may evict
+// others; an existing TTL is kept.
+func (c *LRUCache[K, V]) Increment(key K, delta int64) (int64, error) {
+	key = c.normalize(key)
+	c.lock()
+	n, err := c.increment(key, delta)
+	c.unlock()
+	return n, err
+}
+
+func (c *LRUCache[K, V]) increment(key K, delta int64) (int64, error) {
+	node, ok, err := c.lookupThrough(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		value, ok := any(strconv.FormatInt(delta, 10)).(V)
+		if !ok {
+			return 0, errNotString
+		}
+		err = c.put(key, value, 0, c.defaultCost(key, value))
+		return delta, err
+	}
+
+	s, ok := any(node.value).(string)
+	if !ok {
+		return 0, errNotString
+	}
+	n, err := addInteger(s, delta)
+	if err != nil {
+		return 0, err
+	}
+	err = c.update(node, any(strconv.FormatInt(n, 10)).(V))
+	return n, err
+}
+
+// addInteger returns the base-10 integer s plus delta.
+func addInteger(s string, delta int64) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	return n + delta, nil
+}
+
+// Append adds suffix to the end of the string stored under key, storing
+// suffix itself if key is absent, and returns the new length in bytes. It
+// only works on caches with string values. The entry becomes most recently
+// used; in byte-bounded mode the growth may evict other entries but never
+// the one being appended to.
+func (c *LRUCache[K, V]) Append(key K, suffix string) (int, error) {
+	key = c.normalize(key)
+	c.lock()
+	n, err := c.append(key, suffix)
+	c.unlock()
+	return n, err
+}
+
+func (c *LRUCache[K, V]) append(key K, suffix string) (int, error) {
+	node, ok, err := c.lookupThrough(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		value, ok := any(suffix).(V)
+		if !ok {
+			return 0, errNotString
+		}
+		err = c.put(key, value, 0, c.defaultCost(key, value))
+		return len(suffix), err
+	}
+
+	s, ok := any(node.value).(string)
+	if !ok {
+		return 0, errNotString
+	}
+	s += suffix
+	err = c.update(node, any(s).(V))
+	return len(s), err
+}
+
+// Modify replaces the value under key with the one fn returns, as one
+// atomic step. fn is given the current value and whether key is live; if
+// it returns an error nothing is written and Modify returns that error. If
+// it returns keep false the entry is removed instead, as RemovalDeleted. An
+// existing entry keeps its TTL and is promoted, as with Append; a new one
+// is stored without a TTL. fn runs with the cache locked and must not call
+// back into it.
+func (c *LRUCache[K, V]) Modify(key K, fn func(old V, ok bool) (value V, keep bool, err error)) error {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok, err := c.lookupThrough(key)
+	if err != nil {
+		return err
+	}
+	var old V
+	if ok {
+		old = node.value
+	}
+	value, keep, err := fn(old, ok)
+	switch {
+	case err != nil:
+		return err
+	case !keep:
+		if ok {
+			c.remove(node, RemovalDeleted)
+		}
+		return nil
+	case ok:
+		return c.update(node, value)
+	}
+	return c.put(key, value, 0, c.defaultCost(key, value))
+}
+
+// update replaces the value of a live entry in place, keeping its TTL, and
+// promotes it. In byte-bounded mode the new size may push other entries
+// out; node itself is never evicted to make room for its own update.
+func (c *LRUCache[K, V]) update(node *Node[K, V], value V) error {
+	if c.compress != nil {
+		value = c.compress(value)
+	}
+	cost := node.cost
+	if c.sizer != nil {
+		cost = c.sizer(node.key, value)
+	}
+	if err := c.checkWrite(node.key, value, cost); err != nil {
+		return err
+	}
+	if err := c.checkRoom(node, cost); err != nil {
+		return err
+	}
+	if err := c.writeThrough(node.key, value); err != nil {
+		return err
+	}
+	c.record(node.key, node.value, RemovalReplaced)
+	node.value = value
+	node.version++
+	node.seq = c.nextSeq()
+	now := c.now()
+	node.writtenAt = now
+	c.store(node)
+	c.tag(node)
+	c.chargeSaved(node)
+	c.setCost(node, cost)
+	c.share(node)
+	c.wrote(node.key, node.seq, false)
+	c.used(node, now)
+	c.access(node)
+	c.evictOverflow(node)
+	return nil
+}
+
+// PutIfEquals replaces the value under key with value only if key is live
+// and currently holds expected, as one atomic step. It reports whether the
+// swap happened; a missing or expired key is never inserted. Like Append it
+// keeps the entry's TTL and promotes it. Values are compared with ==, in
+// the form the cache stores them in, so V must hold comparable values or
+// PutIfEquals panics.
+func (c *LRUCache[K, V]) PutIfEquals(key K, expected, value V) (bool, error) {
+	key = c.normalize(key)
+	if c.compress != nil {
+		expected = c.compress(expected)
+	}
+	c.lock()
+	node, ok, err := c.lookupThrough(key)
+	if err != nil || !ok || any(node.value) != any(expected) {
+		c.unlock()
+		return false, err
+	}
+	err = c.update(node, value)
+	c.unlock()
+	return err == nil, err
+}
+
+// PutIfAbsent stores value under key only if key is missing or expired, as
+// one atomic step, and reports whether it did. A live entry is left as it
+// is and not promoted.
+func (c *LRUCache[K, V]) PutIfAbsent(key K, value V) (bool, error) {
+	key = c.normalize(key)
+	c.lock()
+	if _, ok, err := c.lookupThrough(key); err != nil || ok {
+		c.unlock()
+		return false, err
+	}
+	err := c.put(key, value, 0, c.defaultCost(key, value))
+	c.unlock()
+	return err == nil, err
+}
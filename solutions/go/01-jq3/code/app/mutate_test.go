@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestIncrementOverflowLeavesValue(t *testing.T) {
+	c := NewLRUCache[string, string](4)
+	for _, tc := range []struct {
+		start int64
+		delta int64
+	}{
+		{math.MaxInt64, 1},
+		{math.MaxInt64, math.MaxInt64},
+		{math.MaxInt64 - 1, 2},
+		{math.MinInt64, -1},
+		{math.MinInt64, math.MinInt64},
+		{math.MinInt64 + 1, -2},
+		{-1, math.MinInt64},
+	} {
+		start := strconv.FormatInt(tc.start, 10)
+		c.Put("n", start)
+		if n, err := c.Increment("n", tc.delta); err != ErrOverflow {
+			t.Errorf("%d + %d = %d, %v, want ErrOverflow", tc.start, tc.delta, n, err)
+		}
+		if v, _ := c.Get("n"); v != start {
+			t.Errorf("%d + %d left %s", tc.start, tc.delta, v)
+		}
+	}
+	// Right up to the limits is fine.
+	c.Put("n", strconv.FormatInt(math.MaxInt64-1, 10))
+	if n, err := c.Increment("n", 1); err != nil || n != math.MaxInt64 {
+		t.Errorf("up to MaxInt64 = %d, %v", n, err)
+	}
+	if n, err := c.Increment("n", math.MinInt64); err != nil || n != -1 {
+		t.Errorf("MaxInt64 + MinInt64 = %d, %v", n, err)
+	}
+}
+
+func TestIncrDecrOverflow(t *testing.T) {
+	const overflow = "ERROR ERR_OVERFLOW increment would overflow"
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 3", "OK",
+		"PUT max 9223372036854775807", "OK",
+		"PUT min -9223372036854775808", "OK",
+		"INCR max", overflow,
+		"DECR max -1", overflow,
+		"INCR max 9223372036854775807", overflow,
+		"GET max", "9223372036854775807",
+		"DECR min", overflow,
+		"INCR min -1", overflow,
+		"DECR min 9223372036854775807", overflow,
+		"GET min", "-9223372036854775808",
+		// -MinInt64 does not fit, whatever it would be added to.
+		"DECR min -9223372036854775808", overflow,
+		"DECR absent -9223372036854775808", overflow,
+		"EXISTS absent", "0",
+		// Back from the edges goes through.
+		"DECR max", "9223372036854775806",
+		"INCR min", "-9223372036854775807",
+		"INCR max 1", "9223372036854775807",
+		"DECR min 1", "-9223372036854775808",
+	)
+}
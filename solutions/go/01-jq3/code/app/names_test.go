@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestNamedCaches(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 2", "OK",
+		"PUT a 1", "OK",
+		"INIT 3 LFU b", "OK",
+		"GET a", "NULL",
+		"PUT a 2", "OK",
+		"CACHES", "b size=1 capacity=3 selected\ndefault size=1 capacity=2",
+		"SELECT default", "OK",
+		"GET a", "1",
+		"SELECT c", "ERROR ERR_NO_SUCH_CACHE No such cache: c",
+		"DROP default", "ERROR ERR_NOT_ALLOWED Cannot drop the selected cache: default",
+		"DROP b", "OK",
+		"DROP b", "ERROR ERR_NO_SUCH_CACHE No such cache: b",
+		"CACHES", "default size=1 capacity=2 selected",
+	)
+}
+
+// An empty argument is not a cache name: it goes to the policy, which
+// rejects it, rather than crashing the session.
+func TestInitEmptyNameArgument(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		`INIT 3 LRU ""`, "ERROR ERR_INVALID Invalid LRU argument:  (expected MIDPOINT [fraction])",
+		`INIT 3 ""`, "ERROR ERR_INVALID Unknown policy: ",
+		"INIT 3", "OK",
+		"SIZE", "0",
+	)
+	if !isCacheName("b") || isCacheName("") || isCacheName("0.5") {
+		t.Error("isCacheName misclassifies its argument")
+	}
+}
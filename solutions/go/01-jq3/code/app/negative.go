@@ -0,0 +1,138 @@
+package main
+
+import (
+	"container/list"
+	"time"
+)
+
+// With a negative TTL, a key the backing store does not have leaves a
+// tombstone behind for that long, and lookups that find it report the key
+// missing without asking the store again. Tombstones live apart from the
+// entries, so nothing that lists or counts entries sees them, but they
+// take up room like one: a slot against the capacity, and in byte or
+// weight mode the cost of the key alone. When the cache needs room they go
+// first, oldest first, before any entry is evicted, and their going is not
+// reported as a removal; a new tombstone in a cache with no older ones
+// evicts an entry like any insert, unless eviction is off. Any write of
+// the key replaces its tombstone.
+
+type tombstone[K comparable] struct {
+	key      K
+	expireAt time.Time
+	cost     int
+	// seq numbers the tombstone of a deleted key, and is 0 for one left
+	// by the backing store; see SetDeleteRetention.
+	seq       uint64
+	deletedAt time.Time
+}
+
+// SetNegativeTTL sets how long the cache remembers that the backing store
+// does not have a key; 0 turns negative caching off and drops every
+// tombstone.
+func (c *LRUCache[K, V]) SetNegativeTTL(ttl time.Duration) {
+	c.lock()
+	defer c.unlock()
+
+	c.negativeTTL = max(ttl, 0)
+	if c.negativeTTL == 0 {
+		c.clearNegative()
+	}
+}
+
+// negativeHit reports whether key has a live tombstone, dropping it if it
+// has expired, and counts the hit in Stats.NegativeHits.
+func (c *LRUCache[K, V]) negativeHit(key K) bool {
+	elem, ok := c.negative[key]
+	if !ok {
+		return false
+	}
+	if !c.now().Before(elem.Value.(tombstone[K]).expireAt) {
+		if !c.readOnly {
+			c.dropTombstone(elem)
+		}
+		return false
+	}
+	c.counters.negativeHits.Add(1)
+	return true
+}
+
+// addTombstone records that the backing store does not have key, if
+// negative caching is on, and makes room for it.
+func (c *LRUCache[K, V]) addTombstone(key K) {
+	if c.negativeTTL == 0 || c.readOnly {
+		return
+	}
+	if elem, ok := c.negative[key]; ok {
+		c.dropTombstone(elem)
+	}
+	var zero V
+	elem := c.pushTombstone(tombstone[K]{key: key, expireAt: c.now().Add(c.negativeTTL), cost: c.defaultCost(key, zero)})
+	for c.overBudget() {
+		if front := c.tombstones.Front(); front != elem {
+			c.dropTombstone(front)
+		} else if c.noEvict || !c.evictVictim(nil) {
+			c.dropTombstone(elem)
+			return
+		}
+	}
+}
+
+// pushTombstone adds t as the newest tombstone.
+func (c *LRUCache[K, V]) pushTombstone(t tombstone[K]) *list.Element {
+	if c.negative == nil {
+		c.negative = make(map[K]*list.Element)
+		c.tombstones = list.New()
+	}
+	elem := c.tombstones.PushBack(t)
+	c.negative[t.key] = elem
+	c.negativeCost += t.cost
+	return elem
+}
+
+// forget drops the tombstone for key, if there is one, before key is
+// written.
+func (c *LRUCache[K, V]) forget(key K) {
+	if elem, ok := c.negative[key]; ok {
+		c.dropTombstone(elem)
+	}
+}
+
+func (c *LRUCache[K, V]) dropTombstone(elem *list.Element) {
+	t := c.tombstones.Remove(elem).(tombstone[K])
+	delete(c.negative, t.key)
+	c.negativeCost -= t.cost
+}
+
+// evictTombstone drops the oldest tombstone and reports whether there was
+// one.
+func (c *LRUCache[K, V]) evictTombstone() bool {
+	if len(c.negative) == 0 {
+		return false
+	}
+	c.dropTombstone(c.tombstones.Front())
+	return true
+}
+
+func (c *LRUCache[K, V]) clearTombstones() {
+	c.negative, c.tombstones, c.negativeCost = nil, nil, 0
+}
+
+// clearNegative drops the tombstones the backing store left, keeping those
+// of deleted keys.
+func (c *LRUCache[K, V]) clearNegative() {
+	c.dropTombstonesIf(func(t tombstone[K]) bool { return t.seq == 0 })
+}
+
+// dropTombstonesIf drops every tombstone drop reports true for.
+func (c *LRUCache[K, V]) dropTombstonesIf(drop func(t tombstone[K]) bool) {
+	if c.tombstones == nil {
+		return
+	}
+	for elem := c.tombstones.Front(); elem != nil; {
+		next := elem.Next()
+		if drop(elem.Value.(tombstone[K])) {
+			c.dropTombstone(elem)
+		}
+		elem = next
+	}
+}
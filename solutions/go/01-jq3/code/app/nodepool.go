@@ -0,0 +1,34 @@
+package main
+
+// maxFreeNodes bounds how many released nodes a cache keeps for reuse.
+// Churn at a steady size releases about one node per insert, so a few
+// are enough; the rest of a large batch eviction is left to the GC.
+const maxFreeNodes = 256
+
+// newNode returns a zeroed node, reusing a released one if there is one.
+// It must be called with the write lock held.
+func (c *LRUCache[K, V]) newNode() *Node[K, V] {
+	n := len(c.free)
+	if n == 0 {
+		return new(Node[K, V])
+	}
+	node := c.free[n-1]
+	c.free[n-1] = nil
+	c.free = c.free[:n-1]
+	return node
+}
+
+// release hands back a node that has left the cache, the map and the
+// policy, for newNode to reuse. The bytes of its value in the slabs are
+// freed, and the node is zeroed so that it keeps no key or value alive;
+// removal handlers are given their own copies of both, so recycling cannot
+// change what they see. Nothing may use the node after releasing it. It
+// must be called with the write lock held.
+func (c *LRUCache[K, V]) release(node *Node[K, V]) {
+	c.unstore(node)
+	if len(c.free) == maxFreeNodes {
+		return
+	}
+	*node = Node[K, V]{}
+	c.free = append(c.free, node)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+func TestReleasedNodesAreZeroedAndUnreachable(t *testing.T) {
+	c := NewLRUCache[string, string](16)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range 5000 {
+		key := "k" + strconv.Itoa(rng.IntN(64))
+		switch rng.IntN(4) {
+		case 0:
+			c.Remove(key)
+		case 1:
+			c.Get(key)
+		default:
+			c.Put(key, "v"+strconv.Itoa(i))
+		}
+		if i%100 == 0 {
+			// DebugCheck fails if a node on the free list is still cached.
+			if err := c.DebugCheck(); err != nil {
+				t.Fatalf("op %d: %v", i, err)
+			}
+		}
+	}
+	c.lock()
+	defer c.unlock()
+	if len(c.free) == 0 {
+		t.Fatal("nothing was released for reuse")
+	}
+	for _, node := range c.free {
+		if node.key != "" || node.value != "" || node.prev != nil || node.next != nil || node.list != nil {
+			t.Errorf("released node still holds %+v", *node)
+		}
+	}
+}
+
+func TestHandlersKeepTheirDataAfterReuse(t *testing.T) {
+	c := NewLRUCache[string, string](4)
+	type kv struct{ key, value string }
+	var removed []kv
+	c.SetRemovalHandler(func(key, value string, _ RemovalReason) {
+		removed = append(removed, kv{key, value})
+	})
+	for i := range 1000 {
+		c.Put("k"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+	if len(removed) != 996 {
+		t.Fatalf("%d removals, want 996", len(removed))
+	}
+	for i, r := range removed {
+		// The nodes these came from have been reused many times over.
+		if r.key != "k"+strconv.Itoa(i) || r.value != "v"+strconv.Itoa(i) {
+			t.Fatalf("removal %d reported %s=%s", i, r.key, r.value)
+		}
+	}
+}
+
+func TestFreeListIsBounded(t *testing.T) {
+	c := NewLRUCache[string, int](4 * maxFreeNodes)
+	for i := range 4 * maxFreeNodes {
+		c.Put("k"+strconv.Itoa(i), i)
+	}
+	c.Resize(1)
+	c.lock()
+	n := len(c.free)
+	c.unlock()
+	if n != maxFreeNodes {
+		t.Errorf("free list holds %d nodes after a batch eviction, want %d", n, maxFreeNodes)
+	}
+}
+
+func TestSteadyStatePutDoesNotAllocate(t *testing.T) {
+	keys := numbered("k", 1<<12)
+	c := NewLRUCache[string, int](1 << 10)
+	i := 0
+	put := func() {
+		c.Put(keys[i&(len(keys)-1)], i)
+		i++
+	}
+	for range 1 << 14 {
+		put()
+	}
+	if n := testing.AllocsPerRun(10000, put); n > 0.1 {
+		t.Errorf("a Put that evicts allocates %v times", n)
+	}
+}
+
+// BenchmarkChurnPut puts new keys into a full cache, so that every Put
+// evicts, with released nodes reused and with the free list emptied after
+// every Put as if there were none.
+func BenchmarkChurnPut(b *testing.B) {
+	keys := numbered("k", 1<<16)
+	for _, reuse := range []bool{true, false} {
+		b.Run("reuse="+strconv.FormatBool(reuse), func(b *testing.B) {
+			c := NewLRUCache[string, int](1 << 12)
+			for i := range 1 << 13 {
+				c.Put(keys[i], i)
+			}
+			b.ReportAllocs()
+			i := 0
+			for b.Loop() {
+				c.Put(keys[i&(len(keys)-1)], i)
+				if !reuse {
+					c.free = c.free[:0]
+				}
+				i++
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "errors"
+
+// ErrFull is returned, with eviction turned off, by writes that would take
+// the cache over its capacity or budget.
+var ErrFull = errors.New("cache is full")
+
+// ErrCacheFull is ErrFull under the name it has among the errors of the
+// lookup and write methods, such as ErrNotFound and ErrTooLarge.
+var ErrCacheFull = ErrFull
+
+// SetNoEviction turns eviction off or back on. While it is off a write
+// that needs room fails with ErrFull instead of evicting: inserting a new
+// key into a full cache, or in byte-bounded and weighted modes growing an
+// entry past the budget. Updates that need no room still succeed, and
+// Remove and Clear free room as usual. Expired entries are removed to make
+// room, since they are gone anyway, but entries in their grace period are
+// kept. Resize still evicts to fit the new capacity.
+func (c *LRUCache[K, V]) SetNoEviction(enabled bool) {
+	c.lock()
+	c.noEvict = enabled
+	c.unlock()
+}
+
+// NoEviction reports whether eviction is turned off.
+func (c *LRUCache[K, V]) NoEviction() bool {
+	c.rlock()
+	defer c.mu.RUnlock()
+	return c.noEvict
+}
+
+// checkFull returns ErrFull if eviction is off and adding entries new
+// entries and cost to the cost in use would not fit.
+func (c *LRUCache[K, V]) checkFull(entries, cost int) error {
+	if !c.noEvict {
+		return nil
+	}
+	full := func() bool {
+		return (c.capacity > 0 && len(c.cache)+entries > c.capacity) ||
+			(c.maxCost > 0 && c.usedCost+cost > c.maxCost)
+	}
+	if !full() {
+		return nil
+	}
+	c.removeExpired()
+	if !full() {
+		return nil
+	}
+	c.counters.rejected.Add(1)
+	return ErrFull
+}
+
+// removeExpired removes every entry past its TTL and any grace period.
+// It walks the resident slice rather than the map so that they are
+// reported in the same order every run; walking it backwards, each removal
+// only moves an entry already looked at into the gap.
+func (c *LRUCache[K, V]) removeExpired() {
+	now := c.now()
+	for i := len(c.resident) - 1; i >= 0; i-- {
+		if node := c.resident[i]; node.gone(now) {
+			c.remove(node, node.expiry(now))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A KeyNormalizer maps the variants of a key that should share an entry,
+// such as "User:42" and "user:42 ", to one key.
+type KeyNormalizer func(string) string
+
+// keyNormalizers are the steps ParseKeyNormalizer knows.
+var keyNormalizers = map[string]KeyNormalizer{
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"collapse": collapseSpace,
+}
+
+// collapseSpace replaces each run of whitespace inside s with a single
+// space, leaving any at either end as it is.
+func collapseSpace(s string) string {
+	start := len(s) - len(strings.TrimLeft(s, " \t\r\n\v\f"))
+	end := len(strings.TrimRight(s, " \t\r\n\v\f"))
+	if start >= end {
+		return s
+	}
+	return s[:start] + strings.Join(strings.Fields(s[start:end]), " ") + s[end:]
+}
+
+// ParseKeyNormalizer returns the normalizer that applies the
+// comma-separated steps of spec in order: lower folds the key to lower
+// case, trim drops surrounding whitespace and collapse turns each run of
+// whitespace inside it into one space.
+func ParseKeyNormalizer(spec string) (KeyNormalizer, error) {
+	var steps []KeyNormalizer
+	for name := range strings.SplitSeq(spec, ",") {
+		step, ok := keyNormalizers[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown key normalization %q: want lower, trim or collapse", name)
+		}
+		steps = append(steps, step)
+	}
+	return func(key string) string {
+		for _, step := range steps {
+			key = step(key)
+		}
+		return key
+	}, nil
+}
+
+// SetKeyNormalizer makes every method that takes a key apply fn to it
+// first, exactly once, so that keys fn maps to the same key share an
+// entry; a KeyNormalizer will do for string keys. Stored keys are the
+// normalized ones, so Keys, Scan and the removal handler report those.
+// Prefixes, snapshots and imports are taken as they are. nil turns it off;
+// entries already stored under other keys stay under them.
+func (c *LRUCache[K, V]) SetKeyNormalizer(fn func(K) K) {
+	if fn == nil {
+		c.normalizer.Store(nil)
+		return
+	}
+	c.normalizer.Store(&fn)
+}
+
+// normalize returns key as the normalizer maps it. Each exported method
+// taking a key calls it once, before passing the key on to unexported
+// ones; those that only call another exported method leave it to that
+// one.
+func (c *LRUCache[K, V]) normalize(key K) K {
+	if fn := c.normalizer.Load(); fn != nil {
+		return (*fn)(key)
+	}
+	return key
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// marking is a normalizer that is not idempotent: it marks the key each
+// time it runs, so a key normalized twice is stored or looked up under the
+// wrong key.
+func marking(key string) string { return key + "#" }
+
+func TestKeysNormalizedOnce(t *testing.T) {
+	c := NewLRUCache[string, int](4)
+	c.SetKeyNormalizer(marking)
+	c.Put("a", 1)
+	if err := c.Rename("a", "b"); err != nil {
+		t.Fatalf("Rename(a, b): %v", err)
+	}
+	if err := c.Copy("b", "c"); err != nil {
+		t.Fatalf("Copy(b, c): %v", err)
+	}
+	if old, existed, err := c.GetSet("c", 2); err != nil || !existed || old != 1 {
+		t.Fatalf("GetSet(c, 2) = %d, %v, %v", old, existed, err)
+	}
+	if old, existed, err := c.GetSet("d", 3); err != nil || existed {
+		t.Fatalf("GetSet(d, 3) = %d, %v, %v on a new key", old, existed, err)
+	}
+	keys := c.Keys()
+	slices.Sort(keys)
+	if want := []string{"b#", "c#", "d#"}; !slices.Equal(keys, want) {
+		t.Errorf("keys %q, want %q", keys, want)
+	}
+	for key, want := range map[string]int{"b": 1, "c": 2, "d": 3} {
+		if v, ok := c.Get(key); !ok || v != want {
+			t.Errorf("Get(%s) = %d, %v, want %d", key, v, ok, want)
+		}
+	}
+}
+
+func TestCommandsNormalizeKeysOnce(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s, "INIT 4", "OK")
+	s.cache.SetKeyNormalizer(marking)
+	script(t, s,
+		"PUT a 1", "OK",
+		"RENAME a b", "OK",
+		"COPY b c", "OK",
+		"GETSET c 2", "1",
+		"GETSET d 3", "NULL",
+		"GET b", "1",
+		"GET c", "2",
+		"GET d", "3",
+		"EXISTS a", "0",
+		"DEBUG CHECK", "OK",
+	)
+	keys := s.cache.Keys()
+	slices.Sort(keys)
+	if want := []string{"b#", "c#", "d#"}; !slices.Equal(keys, want) {
+		t.Errorf("keys %q, want %q", keys, want)
+	}
+}
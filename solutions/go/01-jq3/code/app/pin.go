@@ -0,0 +1,129 @@
+package main
+
+import "errors"
+
+// ErrAllPinned is returned for writes that need room the cache cannot make
+// because the entries holding it are pinned.
+var ErrAllPinned = errors.New("no room: remaining entries are pinned")
+
+// Pinned entries are taken out of the eviction policy altogether and kept
+// on the cache's own pinned list, most recently used first, so no policy
+// can pick them as a victim. They still count towards the capacity or
+// budget, expire as usual, and can be removed explicitly.
+
+// Pin protects key from eviction until it is unpinned or removed. It
+// reports false if key is absent.
+func (c *LRUCache[K, V]) Pin(key K) bool {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	if !node.pinned {
+		c.displace(node)
+		c.pinned.pushFront(node)
+		c.pinnedCost += node.cost
+		node.pinned = true
+	}
+	return true
+}
+
+// Unpin hands key back to the eviction policy, or to its tenant's or
+// priority's list, as a newly added entry. If pinned entries had kept the
+// cache over its capacity, for example after a Resize, entries are evicted
+// now until it fits, as Resize evicts them, key included if it is the next
+// victim. It reports false if key is absent.
+func (c *LRUCache[K, V]) Unpin(key K) bool {
+	key = c.normalize(key)
+	c.lock()
+	node, ok := c.lookup(key)
+	if !ok || !node.pinned {
+		c.unlock()
+		return ok
+	}
+	c.pinned.remove(node)
+	c.pinnedCost -= node.cost
+	node.pinned = false
+	c.place(node)
+	c.evictOverflow(nil)
+	c.unlock()
+	return true
+}
+
+// Pinned reports whether key is present and pinned.
+func (c *LRUCache[K, V]) Pinned(key K) bool {
+	key = c.normalize(key)
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	node, ok := c.cache[key]
+	return ok && node.pinned && !node.expired(c.now())
+}
+
+// checkRoom returns ErrAllPinned if an entry of the given cost could not
+// fit however much was evicted, because pinned entries hold the room, and
+// ErrFull if it would need an eviction while eviction is off. node is the
+// entry being updated, or nil for an insert.
+func (c *LRUCache[K, V]) checkRoom(node *Node[K, V], cost int) error {
+	if node == nil {
+		if err := c.checkFull(1, cost); err != nil {
+			return err
+		}
+	} else if err := c.checkFull(0, cost-node.cost); err != nil {
+		return err
+	}
+	if c.pinned.len == 0 {
+		return nil
+	}
+	pinnedLen, pinnedCost := c.pinned.len, c.pinnedCost
+	if node != nil && node.pinned {
+		pinnedLen--
+		pinnedCost -= node.cost
+	}
+	if (node == nil && c.capacity > 0 && pinnedLen+1 > c.capacity) ||
+		(c.maxCost > 0 && pinnedCost+cost > c.maxCost) {
+		c.counters.rejected.Add(1)
+		return ErrAllPinned
+	}
+	return nil
+}
+
+// access records a hit on node with whichever of the policy, the pinned
+// list and the tenant and priority lists holds it.
+func (c *LRUCache[K, V]) access(node *Node[K, V]) {
+	switch l := c.list(node); {
+	case node.pinned:
+		c.pinned.moveToFront(node)
+	case l != nil:
+		l.moveToFront(node)
+	default:
+		c.policy.Access(node)
+	}
+}
+
+// each visits every node in retention order: pinned entries first, since
+// they are never evicted, then tenants' entries, high priority entries,
+// the policy's and low priority entries.
+func (c *LRUCache[K, V]) each(fn func(node *Node[K, V]) bool) {
+	stopped := false
+	visit := func(node *Node[K, V]) bool {
+		stopped = !fn(node)
+		return !stopped
+	}
+	c.pinned.each(visit)
+	if !stopped {
+		stopped = c.eachTenant(fn)
+	}
+	if !stopped {
+		c.highTier.each(visit)
+	}
+	if !stopped {
+		c.policy.Each(visit)
+	}
+	if !stopped {
+		c.lowTier.each(visit)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestPinnedSurvivesEveryPolicy(t *testing.T) {
+	for _, policy := range []string{"LRU", "LFU", "FIFO", "CLOCK", "2Q", "ARC", "SLRU", "LRUK 2"} {
+		c := policyCache(t, 4, policy)
+		c.Put("config", 0)
+		if !c.Pin("config") {
+			t.Fatalf("%s: Pin(config) = false", policy)
+		}
+		readThrough(c, numbered("k", 100))
+		if !c.Contains("config") || !c.Pinned("config") {
+			t.Errorf("%s: the pinned key was evicted", policy)
+		}
+		if c.Size() != 4 {
+			t.Errorf("%s: size %d", policy, c.Size())
+		}
+		if err := c.DebugCheck(); err != nil {
+			t.Errorf("%s: %v", policy, err)
+		}
+	}
+}
+
+func TestPutWhenAllPinned(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 2", "OK",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"PIN a", "1",
+		"PIN b", "1",
+		"PUT c 3", "ERROR ERR_NO_ROOM no room: remaining entries are pinned",
+		// Updating a pinned key needs no room.
+		"PUT a 10", "OK",
+		"SIZE", "2",
+		"KEYS", "a b",
+		"DELETE b", "OK",
+		"PUT c 3", "OK",
+		"PIN missing", "0",
+		"DEBUG CHECK", "OK",
+	)
+}
+
+func TestUnpinEvictsOverflow(t *testing.T) {
+	c := NewLRUCache[string, int](3)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Put(key, 0)
+		c.Pin(key)
+	}
+	// The pinned entries keep the cache over its new capacity.
+	if n := c.Resize(1); n != 0 || c.Size() != 3 {
+		t.Fatalf("Resize(1) evicted %d, size %d", n, c.Size())
+	}
+	c.Unpin("a")
+	if c.Size() != 2 || c.Contains("a") {
+		t.Errorf("after Unpin(a): keys %v, want a evicted", c.Keys())
+	}
+	c.Unpin("b")
+	c.Unpin("c")
+	if c.Size() != 1 {
+		t.Errorf("after unpinning everything: keys %v, want 1", c.Keys())
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EvictionPolicy decides which entry leaves the cache when it is over
+// capacity. The cache owns the key→node map and calls into the policy to
+// keep its bookkeeping in step; the policy never adds or removes map
+// entries itself.
+//
+// Every policy is deterministic: the same operations in the same order
+// evict the same entries in the same order, run after run. Policies order
+// entries by list position or by logical counters, never by the wall
+// clock, so entries written by one MPUT are still ordered by their place
+// in it. Where a policy's rule leaves entries tied, as LFU's does for
+// entries used equally often, the one that has waited longest goes first,
+// which for entries never read since they were written is the one
+// inserted first. SAMPLED's draws come from a generator seeded with
+// defaultSampledSeed unless INIT or SEED gives another seed. Only the
+// TinyLFU admission filter, whose sketch hashes keys with a per-process
+// seed, can decide differently from run to run.
+type EvictionPolicy[K comparable, V any] interface {
+	// Name returns the policy name as accepted by INIT.
+	Name() string
+	// Add records a newly inserted node.
+	Add(node *Node[K, V])
+	// Access records a hit on node, from Get or from a Put that updated an
+	// existing key.
+	Access(node *Node[K, V])
+	// Remove forgets node, which is leaving the cache for a reason other
+	// than eviction (explicit delete, expiry).
+	Remove(node *Node[K, V])
+	// Evict removes and returns the next victim. It never picks keep, the
+	// entry currently being written, and returns nil if keep is the only
+	// entry left. keep may be nil.
+	Evict(keep *Node[K, V]) *Node[K, V]
+	// Each visits nodes in retention order: the first node visited is the
+	// one the policy would keep longest and the last is the next victim.
+	Each(fn func(node *Node[K, V]) bool)
+	// Victims visits, until fn returns false, the nodes that successive
+	// calls of Evict(q.keep) would return, in the order they would
+	// return them, without changing any state, so that a write can be
+	// planned before it is made; see victimQuery. fn must not change the
+	// policy either.
+	Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool)
+	// Reset forgets all nodes.
+	Reset()
+}
+
+// A victimQuery describes the write or resize whose victims Victims is
+// asked for, as the state it would find once it had got as far as
+// evicting.
+type victimQuery[K comparable, V any] struct {
+	// keep, if not nil, is the node being written, which is never a
+	// victim. If added is set it is a new node, taken as if Add(keep)
+	// had been called, and otherwise one the policy holds, taken as if
+	// Access(keep) had been.
+	keep  *Node[K, V]
+	added bool
+	// capacity, if not 0, is taken as the capacity of a capacityAware
+	// policy in place of the one it has.
+	capacity int
+}
+
+// capacityAware is implemented by policies that size internal structures
+// relative to the cache capacity. The cache calls SetCapacity on creation
+// and whenever the capacity changes.
+type capacityAware interface {
+	SetCapacity(capacity int)
+}
+
+// describer is implemented by policies with internal state worth showing
+// alongside the cache statistics, such as ARC's adaptation target.
+type describer interface {
+	Describe() string
+}
+
+// victimPeeker is implemented by policies that can name their next victim
+// without evicting it or changing any state, which the admission filter
+// needs in order to weigh a newcomer against it.
+type victimPeeker[K comparable, V any] interface {
+	// Victim returns the node Evict(nil) would return, or nil if there
+	// are no nodes.
+	Victim() *Node[K, V]
+}
+
+// coldInserter is implemented by policies that can insert a node at the
+// cold end of their order, as RestoreWith does for a cold restore.
+type coldInserter[K comparable, V any] interface {
+	// AddCold records a newly inserted node at the end of the policy's
+	// order that its victims come from, behind every node already there.
+	AddCold(node *Node[K, V])
+}
+
+// inspector is implemented by policies that can show and verify their
+// internal structure for DEBUG DUMP and DEBUG CHECK.
+type inspector[K comparable, V any] interface {
+	// NodeFlags describes the per-entry state the policy keeps for node,
+	// such as its frequency or segment, or returns "" if there is none.
+	NodeFlags(node *Node[K, V]) string
+	// Check verifies the policy's internal invariants and describes the
+	// first violation found.
+	Check() error
+}
+
+// defaultSampledSeed seeds SAMPLED when INIT gives no seed of its own, so
+// that its victims are the same from one run to the next.
+const defaultSampledSeed = 1
+
+// newPolicy returns the policy registered under name, matched
+// case-insensitively. args holds any policy-specific INIT arguments.
+func newPolicy[K comparable, V any](name string, args []string) (EvictionPolicy[K, V], error) {
+	switch strings.ToUpper(name) {
+	case "LRU":
+		midpoint, err := parseLRU(args)
+		if err != nil {
+			return nil, err
+		}
+		if midpoint > 0 {
+			return newMidpointPolicy[K, V](midpoint), nil
+		}
+		return newLRUPolicy[K, V](), nil
+	case "FIFO":
+		return newFIFOPolicy[K, V](), nil
+	case "LFU":
+		decayEvery, err := parseLFU(args)
+		if err != nil {
+			return nil, err
+		}
+		return newLFUPolicy[K, V](decayEvery), nil
+	case "SLRU":
+		fraction := 0.8
+		if len(args) > 0 {
+			var err error
+			fraction, err = strconv.ParseFloat(args[0], 64)
+			if err != nil || fraction <= 0 || fraction >= 1 {
+				return nil, fmt.Errorf("Invalid SLRU protected fraction: %s", args[0])
+			}
+		}
+		return newSLRUPolicy[K, V](fraction), nil
+	case "CLOCK":
+		return newClockPolicy[K, V](), nil
+	case "SAMPLED":
+		// SAMPLED [size [seed]]
+		size, seed := 5, uint64(defaultSampledSeed)
+		if len(args) > 0 {
+			var err error
+			if size, err = strconv.Atoi(args[0]); err != nil || size < 1 {
+				return nil, fmt.Errorf("Invalid SAMPLED sample size: %s", args[0])
+			}
+		}
+		if len(args) > 1 {
+			var err error
+			if seed, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("Invalid SAMPLED seed: %s", args[1])
+			}
+		}
+		return newSampledPolicy[K, V](size, seed), nil
+	case "LRUK":
+		k, err := parseLRUK(args)
+		if err != nil {
+			return nil, err
+		}
+		return newLRUKPolicy[K, V](k), nil
+	case "ARC":
+		return newARCPolicy[K, V](), nil
+	case "2Q":
+		in, out := 25, 50
+		if len(args) > 0 {
+			var err error
+			if in, out, err = parseQueueSplit(args[0]); err != nil {
+				return nil, err
+			}
+		}
+		return newTwoQueuePolicy[K, V](in, out), nil
+	default:
+		return nil, fmt.Errorf("Unknown policy: %s", name)
+	}
+}
+
+// parseQueueSplit parses a 2Q queue split written as "<in>,<out>", each a
+// percentage of the cache capacity.
+func parseQueueSplit(s string) (in, out int, err error) {
+	inStr, outStr, found := strings.Cut(s, ",")
+	if found {
+		in, err = strconv.Atoi(inStr)
+		if err == nil {
+			out, err = strconv.Atoi(outStr)
+		}
+	}
+	if !found || err != nil || in < 1 || in > 99 || out < 1 {
+		return 0, 0, fmt.Errorf("Invalid 2Q split: %s (expected <in%%>,<out%%>)", s)
+	}
+	return in, out, nil
+}
+
+// lruPolicy keeps nodes in a single list ordered from most to least
+// recently used and evicts from the back.
+type lruPolicy[K comparable, V any] struct {
+	list *nodeList[K, V]
+}
+
+func newLRUPolicy[K comparable, V any]() *lruPolicy[K, V] {
+	return &lruPolicy[K, V]{list: newNodeList[K, V]()}
+}
+
+func (p *lruPolicy[K, V]) Name() string { return "LRU" }
+
+func (p *lruPolicy[K, V]) Add(node *Node[K, V]) {
+	p.list.pushFront(node)
+}
+
+func (p *lruPolicy[K, V]) AddCold(node *Node[K, V]) {
+	p.list.pushBack(node)
+}
+
+func (p *lruPolicy[K, V]) Access(node *Node[K, V]) {
+	p.list.moveToFront(node)
+}
+
+func (p *lruPolicy[K, V]) Remove(node *Node[K, V]) {
+	p.list.remove(node)
+}
+
+func (p *lruPolicy[K, V]) Evict(keep *Node[K, V]) *Node[K, V] {
+	node := p.list.backExcept(keep)
+	if node != nil {
+		p.list.remove(node)
+	}
+	return node
+}
+
+func (p *lruPolicy[K, V]) Victim() *Node[K, V] {
+	return p.list.back()
+}
+
+func (p *lruPolicy[K, V]) Each(fn func(node *Node[K, V]) bool) {
+	p.list.each(fn)
+}
+
+func (p *lruPolicy[K, V]) Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool) {
+	p.list.eachBack(q.keep, fn)
+}
+
+func (p *lruPolicy[K, V]) Reset() {
+	p.list.init()
+}
+
+// fifoPolicy evicts strictly in insertion order. It reuses the LRU list but
+// never moves a node once inserted, so neither Get nor a Put that updates an
+// existing key changes its position.
+type fifoPolicy[K comparable, V any] struct {
+	*lruPolicy[K, V]
+}
+
+func newFIFOPolicy[K comparable, V any]() *fifoPolicy[K, V] {
+	return &fifoPolicy[K, V]{lruPolicy: newLRUPolicy[K, V]()}
+}
+
+func (p *fifoPolicy[K, V]) Name() string { return "FIFO" }
+
+func (p *fifoPolicy[K, V]) Access(node *Node[K, V]) {}
+
+func (p *lruPolicy[K, V]) NodeFlags(node *Node[K, V]) string { return "" }
+
+func (p *lruPolicy[K, V]) Check() error { return p.list.check("list") }
@@ -0,0 +1,162 @@
+package main
+
+// twoQueuePolicy implements the full 2Q algorithm. First-time keys enter a
+// small FIFO queue (in). When they are evicted from it their keys are
+// remembered in a ghost queue (out); a key that is inserted again while
+// still in the ghost queue has proven itself and goes straight into the main
+// LRU queue. A one-pass scan over cold keys therefore only churns the in
+// queue and cannot flush the working set held in main.
+type twoQueuePolicy[K comparable, V any] struct {
+	inPercent, outPercent int
+
+	in   *nodeList[K, V] // FIFO, newest first
+	main *nodeList[K, V] // LRU, most recently used first
+	out  *ghostList[K]
+
+	inLimit int
+}
+
+func newTwoQueuePolicy[K comparable, V any](inPercent, outPercent int) *twoQueuePolicy[K, V] {
+	return &twoQueuePolicy[K, V]{
+		inPercent:  inPercent,
+		outPercent: outPercent,
+		in:         newNodeList[K, V](),
+		main:       newNodeList[K, V](),
+		out:        newGhostList[K](1),
+		inLimit:    1,
+	}
+}
+
+func (p *twoQueuePolicy[K, V]) Name() string { return "2Q" }
+
+// SetCapacity sizes the in and ghost queues as a share of the capacity.
+func (p *twoQueuePolicy[K, V]) SetCapacity(capacity int) {
+	p.inLimit = max(1, capacity*p.inPercent/100)
+	p.out.setLimit(max(1, capacity*p.outPercent/100))
+}
+
+func (p *twoQueuePolicy[K, V]) Add(node *Node[K, V]) {
+	if p.out.remove(node.key) {
+		p.main.pushFront(node)
+		return
+	}
+	p.in.pushFront(node)
+}
+
+// AddCold puts node at the tail of whichever queue Add would, where the
+// queue's next victim is.
+func (p *twoQueuePolicy[K, V]) AddCold(node *Node[K, V]) {
+	if p.out.remove(node.key) {
+		p.main.pushBack(node)
+		return
+	}
+	p.in.pushBack(node)
+}
+
+func (p *twoQueuePolicy[K, V]) Access(node *Node[K, V]) {
+	// Hits in the in queue deliberately do nothing: a key only earns a
+	// place in main by coming back after it has been evicted from in.
+	if node.list == p.main {
+		p.main.moveToFront(node)
+	}
+}
+
+func (p *twoQueuePolicy[K, V]) Remove(node *Node[K, V]) {
+	node.list.remove(node)
+}
+
+func (p *twoQueuePolicy[K, V]) Evict(keep *Node[K, V]) *Node[K, V] {
+	fromIn := p.evictFromIn()
+	node := p.main.backExcept(keep)
+	if fromIn || node == nil {
+		if inNode := p.in.backExcept(keep); inNode != nil {
+			p.in.remove(inNode)
+			p.out.push(inNode.key)
+			return inNode
+		}
+	}
+	if node != nil {
+		p.main.remove(node)
+	}
+	return node
+}
+
+// evictFromIn reports whether the next victim comes from the in queue: it
+// does once in has outgrown its share, or when main is empty.
+func (p *twoQueuePolicy[K, V]) evictFromIn() bool {
+	return p.in.len > 0 && (p.in.len > p.inLimit || p.main.len == 0)
+}
+
+func (p *twoQueuePolicy[K, V]) Each(fn func(node *Node[K, V]) bool) {
+	first, second := p.in, p.main
+	if p.evictFromIn() {
+		first, second = p.main, p.in
+	}
+	stopped := false
+	first.each(func(node *Node[K, V]) bool {
+		stopped = !fn(node)
+		return !stopped
+	})
+	if !stopped {
+		second.each(fn)
+	}
+}
+
+// Victims replays Evict's choice of queue, which turns on their lengths as
+// the victims leave them.
+func (p *twoQueuePolicy[K, V]) Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool) {
+	inLen, mainLen, inLimit := p.in.len, p.main.len, p.inLimit
+	if q.capacity > 0 {
+		inLimit = max(1, q.capacity*p.inPercent/100)
+	}
+	if q.added {
+		if p.out.contains(q.keep.key) {
+			mainLen++
+		} else {
+			inLen++
+		}
+	}
+	in, main := p.in.cursor(q.keep), p.main.cursor(q.keep)
+	for {
+		fromIn := inLen > 0 && (inLen > inLimit || mainLen == 0)
+		node := main.peek()
+		if fromIn || node == nil {
+			if inNode := in.peek(); inNode != nil {
+				in.advance()
+				inLen--
+				if !fn(inNode) {
+					return
+				}
+				continue
+			}
+		}
+		if node == nil {
+			return
+		}
+		main.advance()
+		mainLen--
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+func (p *twoQueuePolicy[K, V]) Reset() {
+	p.in.init()
+	p.main.init()
+	p.out.reset()
+}
+
+func (p *twoQueuePolicy[K, V]) NodeFlags(node *Node[K, V]) string {
+	if node.list == p.main {
+		return "queue=main"
+	}
+	return "queue=in"
+}
+
+func (p *twoQueuePolicy[K, V]) Check() error {
+	if err := p.in.check("in"); err != nil {
+		return err
+	}
+	return p.main.check("main")
+}
@@ -0,0 +1,237 @@
+package main
+
+import "fmt"
+
+// arcPolicy implements the Adaptive Replacement Cache of Megiddo and Modha.
+// Resident entries live in t1 (seen once recently) or t2 (seen at least
+// twice); b1 and b2 are ghost lists remembering keys recently evicted from
+// t1 and t2. A ghost hit in b1 means t1 was too small, so the target size p
+// for t1 grows; a ghost hit in b2 shrinks it. Only t1 and t2 count toward
+// the cache size.
+type arcPolicy[K comparable, V any] struct {
+	capacity int
+	p        int // target size of t1
+
+	t1, t2 *nodeList[K, V] // most recently used first
+	b1, b2 *ghostList[K]
+
+	// pending is the node inserted by the latest Add. It sits at the front
+	// of t1 or t2 but is not yet part of the replacement decision, since
+	// ARC makes room before admitting a new key.
+	pending *Node[K, V]
+	// hitB2 records whether the pending key was a ghost hit in b2, which
+	// biases REPLACE towards evicting from t1.
+	hitB2 bool
+	// skipGhost is set when a miss found t1 alone filling the cache; the
+	// next victim then leaves without being remembered in b1.
+	skipGhost bool
+}
+
+func newARCPolicy[K comparable, V any]() *arcPolicy[K, V] {
+	return &arcPolicy[K, V]{
+		capacity: 1,
+		t1:       newNodeList[K, V](),
+		t2:       newNodeList[K, V](),
+		b1:       newGhostList[K](1),
+		b2:       newGhostList[K](1),
+	}
+}
+
+func (p *arcPolicy[K, V]) Name() string { return "ARC" }
+
+func (p *arcPolicy[K, V]) SetCapacity(capacity int) {
+	p.capacity = capacity
+	p.p = min(p.p, capacity)
+	p.b1.setLimit(capacity)
+	p.b2.setLimit(capacity)
+}
+
+func (p *arcPolicy[K, V]) Add(node *Node[K, V]) {
+	p.pending = node
+	p.hitB2 = false
+	p.skipGhost = false
+
+	switch {
+	case p.b1.contains(node.key):
+		p.p = min(p.capacity, p.p+max(p.b2.len()/p.b1.len(), 1))
+		p.b1.remove(node.key)
+		p.t2.pushFront(node)
+	case p.b2.contains(node.key):
+		p.p = max(0, p.p-max(p.b1.len()/p.b2.len(), 1))
+		p.b2.remove(node.key)
+		p.hitB2 = true
+		p.t2.pushFront(node)
+	default:
+		resident := p.t1.len + p.t2.len
+		if p.t1.len+p.b1.len() >= p.capacity {
+			if p.t1.len < p.capacity {
+				p.b1.removeOldest()
+			} else {
+				p.skipGhost = true
+			}
+		} else if resident+p.b1.len()+p.b2.len() >= 2*p.capacity {
+			p.b2.removeOldest()
+		}
+		p.t1.pushFront(node)
+	}
+}
+
+func (p *arcPolicy[K, V]) Access(node *Node[K, V]) {
+	node.list.remove(node)
+	p.t2.pushFront(node)
+}
+
+func (p *arcPolicy[K, V]) Remove(node *Node[K, V]) {
+	if node == p.pending {
+		p.pending = nil
+	}
+	node.list.remove(node)
+}
+
+func (p *arcPolicy[K, V]) Evict(keep *Node[K, V]) *Node[K, V] {
+	fromT1 := p.evictFromT1()
+	node := p.t2.backExcept(keep)
+	if fromT1 || node == nil {
+		if t1Node := p.t1.backExcept(keep); t1Node != nil {
+			p.t1.remove(t1Node)
+			if !p.skipGhost {
+				p.b1.push(t1Node.key)
+			}
+			node = t1Node
+			fromT1 = true
+		}
+	}
+	if node != nil && !fromT1 {
+		p.t2.remove(node)
+		p.b2.push(node.key)
+	}
+	if node == p.pending {
+		p.pending = nil
+	}
+	p.skipGhost = false
+	return node
+}
+
+// evictFromT1 is the REPLACE step of ARC: evict from t1 when it exceeds its
+// target p (or meets it on a b2 ghost hit), otherwise from t2.
+func (p *arcPolicy[K, V]) evictFromT1() bool {
+	t1 := p.t1.len
+	if p.pending != nil && p.pending.list == p.t1 {
+		t1--
+	}
+	if t1 >= 1 && ((p.hitB2 && t1 == p.p) || t1 > p.p) {
+		return true
+	}
+	return p.t2.len == 0 && p.t1.len > 0
+}
+
+func (p *arcPolicy[K, V]) Each(fn func(node *Node[K, V]) bool) {
+	first, second := p.t1, p.t2
+	if p.evictFromT1() {
+		first, second = p.t2, p.t1
+	}
+	stopped := false
+	first.each(func(node *Node[K, V]) bool {
+		stopped = !fn(node)
+		return !stopped
+	})
+	if !stopped {
+		second.each(fn)
+	}
+}
+
+// Victims replays REPLACE as Evict makes it, with the target and list
+// lengths the write's Add or Access would leave.
+func (p *arcPolicy[K, V]) Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool) {
+	capacity, target := p.capacity, p.p
+	if q.capacity > 0 {
+		capacity, target = q.capacity, min(target, q.capacity)
+	}
+	t1Len, t2Len := p.t1.len, p.t2.len
+	pending, hitB2 := p.pending, p.hitB2
+	pendingInT1 := pending != nil && pending.list == p.t1
+	switch {
+	case q.added:
+		pending, hitB2, pendingInT1 = q.keep, false, false
+		switch {
+		case p.b1.contains(q.keep.key):
+			target = min(capacity, target+max(p.b2.len()/p.b1.len(), 1))
+			t2Len++
+		case p.b2.contains(q.keep.key):
+			target = max(0, target-max(p.b1.len()/p.b2.len(), 1))
+			hitB2 = true
+			t2Len++
+		default:
+			t1Len++
+			pendingInT1 = true
+		}
+	case q.keep != nil && q.keep.list == p.t1:
+		t1Len--
+		t2Len++
+		if q.keep == pending {
+			pendingInT1 = false
+		}
+	}
+	t1, t2 := p.t1.cursor(q.keep), p.t2.cursor(q.keep)
+	for {
+		n1 := t1Len
+		if pendingInT1 {
+			n1--
+		}
+		fromT1 := (n1 >= 1 && ((hitB2 && n1 == target) || n1 > target)) || (t2Len == 0 && t1Len > 0)
+		node := t2.peek()
+		if fromT1 || node == nil {
+			if t1Node := t1.peek(); t1Node != nil {
+				t1.advance()
+				t1Len--
+				if t1Node == pending {
+					pending, pendingInT1 = nil, false
+				}
+				if !fn(t1Node) {
+					return
+				}
+				continue
+			}
+		}
+		if node == nil {
+			return
+		}
+		t2.advance()
+		t2Len--
+		if node == pending {
+			pending, pendingInT1 = nil, false
+		}
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+func (p *arcPolicy[K, V]) Reset() {
+	p.p = 0
+	p.t1.init()
+	p.t2.init()
+	p.b1.reset()
+	p.b2.reset()
+	p.pending = nil
+}
+
+// Describe reports the adaptation target and list sizes.
+func (p *arcPolicy[K, V]) Describe() string {
+	return fmt.Sprintf("p=%d t1=%d t2=%d b1=%d b2=%d",
+		p.p, p.t1.len, p.t2.len, p.b1.len(), p.b2.len())
+}
+
+func (p *arcPolicy[K, V]) NodeFlags(node *Node[K, V]) string {
+	if node.list == p.t2 {
+		return "list=t2"
+	}
+	return "list=t1"
+}
+
+func (p *arcPolicy[K, V]) Check() error {
+	if err := p.t1.check("t1"); err != nil {
+		return err
+	}
+	return p.t2.check("t2")
+}
@@ -0,0 +1,187 @@
+package main
+
+import "fmt"
+
+// clockPolicy approximates LRU with the CLOCK (second chance) algorithm.
+// Entries sit in a circular buffer with a reference bit that Get sets. To
+// evict, the hand sweeps the ring: a referenced entry has its bit cleared
+// and is passed over, and the first unreferenced entry is the victim. A hit
+// only flips a bit, so reads never rearrange any links.
+//
+// New entries start referenced so they survive at least one sweep of the
+// hand.
+type clockPolicy[K comparable, V any] struct {
+	ring []*Node[K, V] // nil marks a free slot
+	free []int         // indexes of free slots, reused in order
+	hand int
+	len  int
+}
+
+func newClockPolicy[K comparable, V any]() *clockPolicy[K, V] {
+	return &clockPolicy[K, V]{}
+}
+
+func (p *clockPolicy[K, V]) Name() string { return "CLOCK" }
+
+// SetCapacity shrinks the ring to fit capacity, compacting live entries
+// into a ring no larger than needed and preserving their order from the
+// hand onwards so the sweep continues where it left off. The ring is never
+// grown ahead of use: Add extends it one slot at a time, so a huge capacity
+// costs nothing until it is filled.
+func (p *clockPolicy[K, V]) SetCapacity(capacity int) {
+	size := max(capacity, p.len)
+	if size >= len(p.ring) {
+		return
+	}
+	ring := make([]*Node[K, V], 0, size)
+	for i := range p.ring {
+		if node := p.ring[(p.hand+i)%len(p.ring)]; node != nil {
+			node.slot = len(ring)
+			ring = append(ring, node)
+		}
+	}
+	p.free = p.free[:0]
+	p.ring = ring
+	p.hand = 0
+}
+
+func (p *clockPolicy[K, V]) Add(node *Node[K, V]) {
+	if len(p.free) == 0 {
+		p.free = append(p.free, len(p.ring))
+		p.ring = append(p.ring, nil)
+	}
+	slot := p.free[0]
+	p.free = p.free[1:]
+	p.ring[slot] = node
+	node.slot = slot
+	node.ref = true
+	p.len++
+}
+
+func (p *clockPolicy[K, V]) Access(node *Node[K, V]) {
+	node.ref = true
+}
+
+func (p *clockPolicy[K, V]) Remove(node *Node[K, V]) {
+	p.ring[node.slot] = nil
+	p.free = append(p.free, node.slot)
+	p.len--
+}
+
+func (p *clockPolicy[K, V]) Evict(keep *Node[K, V]) *Node[K, V] {
+	if p.len == 0 || (p.len == 1 && keep != nil) {
+		return nil
+	}
+	for {
+		node := p.ring[p.hand]
+		p.hand = (p.hand + 1) % len(p.ring)
+		if node == nil || node == keep {
+			continue
+		}
+		if node.ref {
+			node.ref = false
+			continue
+		}
+		p.Remove(node)
+		return node
+	}
+}
+
+// Each visits entries in the reverse of the order the hand would evict
+// them: unreferenced entries from the hand onwards go first, followed by
+// the referenced ones that would only lose their second chance after a
+// full sweep.
+func (p *clockPolicy[K, V]) Each(fn func(node *Node[K, V]) bool) {
+	var unreferenced, referenced []*Node[K, V]
+	for i := range p.ring {
+		node := p.ring[(p.hand+i)%len(p.ring)]
+		switch {
+		case node == nil:
+		case node.ref:
+			referenced = append(referenced, node)
+		default:
+			unreferenced = append(unreferenced, node)
+		}
+	}
+	order := append(unreferenced, referenced...)
+	for i := len(order) - 1; i >= 0; i-- {
+		if !fn(order[i]) {
+			return
+		}
+	}
+}
+
+// Victims follows the hand as Each describes, skipping keep, whose
+// reference bit the write would set and the hand never clears. Like Evict
+// it stops at the last entry when there is a keep, held or not.
+func (p *clockPolicy[K, V]) Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool) {
+	left := p.len
+	if q.added {
+		left++
+	}
+	for _, referenced := range []bool{false, true} {
+		for i := range p.ring {
+			node := p.ring[(p.hand+i)%len(p.ring)]
+			if node == nil || node == q.keep || node.ref != referenced {
+				continue
+			}
+			if left == 0 || (left == 1 && q.keep != nil) || !fn(node) {
+				return
+			}
+			left--
+		}
+	}
+}
+
+func (p *clockPolicy[K, V]) Reset() {
+	for i := range p.ring {
+		p.ring[i] = nil
+	}
+	p.free = p.free[:0]
+	for i := range p.ring {
+		p.free = append(p.free, i)
+	}
+	p.hand = 0
+	p.len = 0
+}
+
+func (p *clockPolicy[K, V]) NodeFlags(node *Node[K, V]) string {
+	ref := 0
+	if node.ref {
+		ref = 1
+	}
+	return fmt.Sprintf("ref=%d slot=%d", ref, node.slot)
+}
+
+// Check verifies that every occupied slot's node records that slot, that
+// the free list names exactly the empty slots, and that the hand is in
+// range.
+func (p *clockPolicy[K, V]) Check() error {
+	if len(p.ring) > 0 && (p.hand < 0 || p.hand >= len(p.ring)) {
+		return fmt.Errorf("hand %d outside ring of %d slots", p.hand, len(p.ring))
+	}
+	used := 0
+	for i, node := range p.ring {
+		if node == nil {
+			continue
+		}
+		used++
+		if node.slot != i {
+			return fmt.Errorf("node %v in slot %d records slot %d", node.key, i, node.slot)
+		}
+	}
+	if used != p.len {
+		return fmt.Errorf("%d occupied slots but length %d", used, p.len)
+	}
+	seen := make(map[int]bool, len(p.free))
+	for _, i := range p.free {
+		if i < 0 || i >= len(p.ring) || p.ring[i] != nil || seen[i] {
+			return fmt.Errorf("free list entry %d is not a distinct empty slot", i)
+		}
+		seen[i] = true
+	}
+	if used+len(p.free) != len(p.ring) {
+		return fmt.Errorf("%d occupied and %d free slots in a ring of %d", used, len(p.free), len(p.ring))
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// lfuPolicy evicts the least frequently used entry, breaking ties by
+// evicting the least recently used among them.
+//
+// Nodes with the same access count share a bucket, and buckets form a list
+// ordered by ascending count. An access moves a node from its bucket to the
+// next one up, creating that bucket if needed, so every operation is O(1)
+// with no heap or scan.
+//
+// With decay, every decayEvery adds and accesses halve every frequency, so
+// that an entry once hot but no longer used comes down to the newer ones
+// in time. Halving walks the buckets rather than the entries, and keeps
+// their order, but may leave neighbours of equal frequency; those are not
+// merged, which would mean moving their entries, but treated as one, so
+// that an access moves an entry past all of them. Among tied buckets the
+// first one's entries are evicted first.
+type lfuPolicy[K comparable, V any] struct {
+	// root is a sentinel: root.next is the lowest-frequency bucket.
+	root lfuBucket[K, V]
+
+	// decayEvery is 0 without decay; ops counts the adds and accesses
+	// since the last halving and decays the halvings so far.
+	decayEvery, ops, decays int
+}
+
+type lfuBucket[K comparable, V any] struct {
+	freq       int
+	nodes      *nodeList[K, V] // most recently used first
+	prev, next *lfuBucket[K, V]
+}
+
+func newLFUPolicy[K comparable, V any](decayEvery int) *lfuPolicy[K, V] {
+	p := &lfuPolicy[K, V]{decayEvery: decayEvery}
+	p.Reset()
+	return p
+}
+
+// parseLFU parses the LFU arguments of INIT, [DECAY <n>], and returns the
+// decay interval, 0 for none.
+func parseLFU(args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	if args[0] != "DECAY" {
+		return 0, fmt.Errorf("Invalid LFU argument: %s (expected DECAY <n>)", args[0])
+	}
+	if len(args) < 2 {
+		return 0, fmt.Errorf("DECAY requires operations argument")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("Invalid LFU decay interval: %s", args[1])
+	}
+	return n, nil
+}
+
+func (p *lfuPolicy[K, V]) Name() string { return "LFU" }
+
+func (p *lfuPolicy[K, V]) Add(node *Node[K, V]) {
+	p.tick()
+	first := p.root.next
+	if first == &p.root || first.freq != 1 {
+		first = p.insertBucketAfter(&p.root, 1)
+	}
+	p.addToBucket(node, first)
+}
+
+func (p *lfuPolicy[K, V]) Access(node *Node[K, V]) {
+	p.tick()
+	bucket := node.bucket
+	next := bucket.next
+	for next != &p.root && next.freq == bucket.freq {
+		next = next.next
+	}
+	if next == &p.root || next.freq != bucket.freq+1 {
+		next = p.insertBucketAfter(next.prev, bucket.freq+1)
+	}
+	p.removeFromBucket(node)
+	p.addToBucket(node, next)
+}
+
+func (p *lfuPolicy[K, V]) Remove(node *Node[K, V]) {
+	p.removeFromBucket(node)
+}
+
+func (p *lfuPolicy[K, V]) Evict(keep *Node[K, V]) *Node[K, V] {
+	for bucket := p.root.next; bucket != &p.root; bucket = bucket.next {
+		if node := bucket.nodes.backExcept(keep); node != nil {
+			p.removeFromBucket(node)
+			return node
+		}
+	}
+	return nil
+}
+
+func (p *lfuPolicy[K, V]) Victim() *Node[K, V] {
+	if p.root.next == &p.root {
+		return nil
+	}
+	return p.root.next.nodes.back()
+}
+
+func (p *lfuPolicy[K, V]) Each(fn func(node *Node[K, V]) bool) {
+	for bucket := p.root.prev; bucket != &p.root; bucket = bucket.prev {
+		stopped := false
+		bucket.nodes.each(func(node *Node[K, V]) bool {
+			if !fn(node) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// Victims follows Evict, bucket by bucket from the lowest frequency. A
+// decay that the write's Add or Access would set off keeps the order.
+func (p *lfuPolicy[K, V]) Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool) {
+	for bucket := p.root.next; bucket != &p.root; bucket = bucket.next {
+		if !bucket.nodes.eachBack(q.keep, fn) {
+			return
+		}
+	}
+}
+
+func (p *lfuPolicy[K, V]) Reset() {
+	p.root.next = &p.root
+	p.root.prev = &p.root
+	p.ops = 0
+}
+
+// tick counts an add or access and halves every frequency, none below 1,
+// once decayEvery of them have gone by.
+func (p *lfuPolicy[K, V]) tick() {
+	if p.decayEvery == 0 {
+		return
+	}
+	if p.ops++; p.ops < p.decayEvery {
+		return
+	}
+	p.ops = 0
+	p.decays++
+	for bucket := p.root.next; bucket != &p.root; bucket = bucket.next {
+		bucket.freq = max(1, bucket.freq/2)
+	}
+}
+
+func (p *lfuPolicy[K, V]) Describe() string {
+	if p.decayEvery == 0 {
+		return ""
+	}
+	return fmt.Sprintf("decay_every=%d decays=%d", p.decayEvery, p.decays)
+}
+
+func (p *lfuPolicy[K, V]) insertBucketAfter(at *lfuBucket[K, V], freq int) *lfuBucket[K, V] {
+	bucket := &lfuBucket[K, V]{freq: freq, nodes: newNodeList[K, V]()}
+	bucket.prev = at
+	bucket.next = at.next
+	at.next.prev = bucket
+	at.next = bucket
+	return bucket
+}
+
+func (p *lfuPolicy[K, V]) addToBucket(node *Node[K, V], bucket *lfuBucket[K, V]) {
+	bucket.nodes.pushFront(node)
+	node.bucket = bucket
+}
+
+// removeFromBucket unlinks node and drops its bucket once it is empty.
+func (p *lfuPolicy[K, V]) removeFromBucket(node *Node[K, V]) {
+	bucket := node.bucket
+	bucket.nodes.remove(node)
+	node.bucket = nil
+	if bucket.nodes.len == 0 {
+		bucket.prev.next = bucket.next
+		bucket.next.prev = bucket.prev
+	}
+}
+
+func (p *lfuPolicy[K, V]) NodeFlags(node *Node[K, V]) string {
+	return fmt.Sprintf("freq=%d", node.bucket.freq)
+}
+
+// Check verifies that buckets are non-empty, in strictly increasing
+// frequency order, or without decay only nondecreasing, and that every
+// node points at the bucket holding it.
+func (p *lfuPolicy[K, V]) Check() error {
+	last := 0
+	for bucket := p.root.next; bucket != &p.root; bucket = bucket.next {
+		if bucket.next.prev != bucket {
+			return fmt.Errorf("bucket freq=%d: broken link", bucket.freq)
+		}
+		if bucket.freq < last || (bucket.freq == last && p.decayEvery == 0) {
+			return fmt.Errorf("bucket freq=%d follows freq=%d", bucket.freq, last)
+		}
+		last = bucket.freq
+		name := fmt.Sprintf("bucket freq=%d", bucket.freq)
+		if bucket.nodes.len == 0 {
+			return fmt.Errorf("%s: empty bucket", name)
+		}
+		if err := bucket.nodes.check(name); err != nil {
+			return err
+		}
+		var err error
+		bucket.nodes.each(func(node *Node[K, V]) bool {
+			if node.bucket != bucket {
+				err = fmt.Errorf("%s: node %v points at another bucket", name, node.key)
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
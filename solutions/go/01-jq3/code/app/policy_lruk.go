@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// lrukPolicy is LRU-K: it remembers the last k accesses of every entry and
+// evicts the one whose k-th most recent access lies furthest back. A key
+// read twice in quick succession and then left alone, as a scan or a
+// correlated burst leaves it, ranks no better than one read once, while a
+// key read steadily keeps its place. Entries with fewer than k accesses
+// have no k-th access at all and are evicted before any that do, those
+// seen earliest first. With k = 1 it is plain LRU.
+//
+// Accesses are stamped with a logical clock rather than the time, so that
+// ties cannot happen and runs are repeatable. Each entry keeps its stamps
+// in history, most recent first; the history leaves with the entry.
+//
+// Add, Access and Remove are O(k). Evict scans every entry, O(n), and Each
+// sorts them; a heap or a pair of ordered lists keyed on rank could
+// replace the scan without changing anything outside victim.
+type lrukPolicy[K comparable, V any] struct {
+	k     int
+	nodes []*Node[K, V] // dense, each node recording its index in slot
+	clock uint64
+}
+
+func newLRUKPolicy[K comparable, V any](k int) *lrukPolicy[K, V] {
+	return &lrukPolicy[K, V]{k: k}
+}
+
+// parseLRUK parses the LRUK INIT argument, K, which defaults to 2.
+func parseLRUK(args []string) (int, error) {
+	if len(args) == 0 {
+		return 2, nil
+	}
+	k, err := strconv.Atoi(args[0])
+	if err != nil || k < 1 {
+		return 0, fmt.Errorf("Invalid LRUK history length: %s", args[0])
+	}
+	return k, nil
+}
+
+func (p *lrukPolicy[K, V]) Name() string { return "LRUK" }
+
+func (p *lrukPolicy[K, V]) Add(node *Node[K, V]) {
+	node.slot = len(p.nodes)
+	p.nodes = append(p.nodes, node)
+	node.history = make([]uint64, 0, p.k)
+	p.Access(node)
+}
+
+func (p *lrukPolicy[K, V]) Access(node *Node[K, V]) {
+	p.clock++
+	if len(node.history) < p.k {
+		node.history = append(node.history, 0)
+	}
+	copy(node.history[1:], node.history)
+	node.history[0] = p.clock
+}
+
+func (p *lrukPolicy[K, V]) Remove(node *Node[K, V]) {
+	last := p.nodes[len(p.nodes)-1]
+	p.nodes[node.slot] = last
+	last.slot = node.slot
+	p.nodes[len(p.nodes)-1] = nil
+	p.nodes = p.nodes[:len(p.nodes)-1]
+	node.history = nil
+}
+
+// before reports whether a should be evicted before b: an entry without k
+// accesses goes before one with them, and otherwise the one whose oldest
+// remembered access is older goes first.
+func (p *lrukPolicy[K, V]) before(a, b *Node[K, V]) bool {
+	if fullA, fullB := len(a.history) == p.k, len(b.history) == p.k; fullA != fullB {
+		return fullB
+	}
+	return a.history[len(a.history)-1] < b.history[len(b.history)-1]
+}
+
+// victim returns the entry to evict other than keep, or nil if there is
+// none.
+func (p *lrukPolicy[K, V]) victim(keep *Node[K, V]) *Node[K, V] {
+	var victim *Node[K, V]
+	for _, node := range p.nodes {
+		if node != keep && (victim == nil || p.before(node, victim)) {
+			victim = node
+		}
+	}
+	return victim
+}
+
+func (p *lrukPolicy[K, V]) Evict(keep *Node[K, V]) *Node[K, V] {
+	victim := p.victim(keep)
+	if victim != nil {
+		p.Remove(victim)
+	}
+	return victim
+}
+
+func (p *lrukPolicy[K, V]) Victim() *Node[K, V] {
+	return p.victim(nil)
+}
+
+func (p *lrukPolicy[K, V]) Each(fn func(node *Node[K, V]) bool) {
+	order := slices.Clone(p.nodes)
+	slices.SortFunc(order, func(a, b *Node[K, V]) int {
+		switch {
+		case p.before(a, b):
+			return 1
+		case p.before(b, a):
+			return -1
+		}
+		return 0
+	})
+	for _, node := range order {
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+// Victims sorts the entries into the order victim would pick them in. The
+// stamps the write's Add or Access would give keep are later than all the
+// others', and keep is never picked, so they make no difference.
+func (p *lrukPolicy[K, V]) Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool) {
+	order := slices.DeleteFunc(slices.Clone(p.nodes), func(node *Node[K, V]) bool { return node == q.keep })
+	slices.SortFunc(order, func(a, b *Node[K, V]) int {
+		switch {
+		case p.before(a, b):
+			return -1
+		case p.before(b, a):
+			return 1
+		}
+		return 0
+	})
+	for _, node := range order {
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+func (p *lrukPolicy[K, V]) Reset() {
+	clear(p.nodes)
+	p.nodes = p.nodes[:0]
+}
+
+func (p *lrukPolicy[K, V]) Describe() string {
+	return fmt.Sprintf("k=%d", p.k)
+}
+
+func (p *lrukPolicy[K, V]) NodeFlags(node *Node[K, V]) string {
+	stamps := make([]string, len(node.history))
+	for i, tick := range node.history {
+		stamps[i] = strconv.FormatUint(tick, 10)
+	}
+	return "history=" + strings.Join(stamps, ",")
+}
+
+// Check verifies that every entry records its own index and holds between
+// one and k stamps, newest first and none ahead of the clock.
+func (p *lrukPolicy[K, V]) Check() error {
+	for i, node := range p.nodes {
+		if node.slot != i {
+			return fmt.Errorf("node %v at index %d records slot %d", node.key, i, node.slot)
+		}
+		if len(node.history) == 0 || len(node.history) > p.k {
+			return fmt.Errorf("node %v has %d accesses recorded, want 1 to %d", node.key, len(node.history), p.k)
+		}
+		if node.history[0] > p.clock {
+			return fmt.Errorf("node %v accessed at %d, after the clock %d", node.key, node.history[0], p.clock)
+		}
+		if !slices.IsSortedFunc(node.history, func(a, b uint64) int { return cmp.Compare(b, a) }) {
+			return fmt.Errorf("node %v history %v is out of order", node.key, node.history)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// defaultMidpoint is where LRU MIDPOINT inserts new entries when INIT gives
+// no fraction: halfway down the list.
+const defaultMidpoint = 0.5
+
+// midpointPolicy is LRU with midpoint insertion. The list is split in two:
+// the top holds the given fraction of the entries, most recently used
+// first, and new entries go to the front of the bottom, so that they start
+// that far down the list rather than at its head. A hit on an entry in the
+// bottom moves it to the head of the top, pushing the top's last entry
+// down to the front of the bottom. Victims come from the tail of the
+// bottom, so a scan of keys read once churns only the bottom while the
+// entries read more than once keep the top.
+//
+// The split is kept by count: after every change entries move between the
+// end of the top and the front of the bottom until the top holds exactly
+// its share, which takes at most a couple of moves, so every operation
+// stays O(1).
+type midpointPolicy[K comparable, V any] struct {
+	fraction float64
+
+	top    *nodeList[K, V] // most recently used first
+	bottom *nodeList[K, V] // most recently used first
+}
+
+func newMidpointPolicy[K comparable, V any](fraction float64) *midpointPolicy[K, V] {
+	return &midpointPolicy[K, V]{
+		fraction: fraction,
+		top:      newNodeList[K, V](),
+		bottom:   newNodeList[K, V](),
+	}
+}
+
+// parseLRU parses the arguments INIT gives LRU: none, or MIDPOINT and an
+// optional fraction of the list above the insertion point. It returns 0
+// for plain LRU.
+func parseLRU(args []string) (float64, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	if args[0] != "MIDPOINT" {
+		return 0, fmt.Errorf("Invalid LRU argument: %s (expected MIDPOINT [fraction])", args[0])
+	}
+	if len(args) < 2 {
+		return defaultMidpoint, nil
+	}
+	fraction, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || fraction <= 0 || fraction >= 1 {
+		return 0, fmt.Errorf("Invalid LRU midpoint fraction: %s", args[1])
+	}
+	return fraction, nil
+}
+
+func (p *midpointPolicy[K, V]) Name() string { return "LRU" }
+
+func (p *midpointPolicy[K, V]) Add(node *Node[K, V]) {
+	p.bottom.pushFront(node)
+	p.balance()
+}
+
+func (p *midpointPolicy[K, V]) AddCold(node *Node[K, V]) {
+	p.bottom.pushBack(node)
+	p.balance()
+}
+
+func (p *midpointPolicy[K, V]) Access(node *Node[K, V]) {
+	if node.list == p.top {
+		p.top.moveToFront(node)
+		return
+	}
+	p.bottom.remove(node)
+	p.top.pushFront(node)
+	p.balance()
+}
+
+func (p *midpointPolicy[K, V]) Remove(node *Node[K, V]) {
+	node.list.remove(node)
+	p.balance()
+}
+
+func (p *midpointPolicy[K, V]) Evict(keep *Node[K, V]) *Node[K, V] {
+	node := p.bottom.backExcept(keep)
+	if node == nil {
+		node = p.top.backExcept(keep)
+	}
+	if node != nil {
+		node.list.remove(node)
+		p.balance()
+	}
+	return node
+}
+
+func (p *midpointPolicy[K, V]) Victim() *Node[K, V] {
+	if node := p.bottom.back(); node != nil {
+		return node
+	}
+	return p.top.back()
+}
+
+func (p *midpointPolicy[K, V]) Each(fn func(node *Node[K, V]) bool) {
+	stopped := false
+	p.top.each(func(node *Node[K, V]) bool {
+		stopped = !fn(node)
+		return !stopped
+	})
+	if !stopped {
+		p.bottom.each(fn)
+	}
+}
+
+// Victims follows Evict: the bottom from its tail, then the top. Balancing
+// only moves entries across the split, keeping their order.
+func (p *midpointPolicy[K, V]) Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool) {
+	if p.bottom.eachBack(q.keep, fn) {
+		p.top.eachBack(q.keep, fn)
+	}
+}
+
+func (p *midpointPolicy[K, V]) Reset() {
+	p.top.init()
+	p.bottom.init()
+}
+
+// Describe reports the insertion point and the size of each region.
+func (p *midpointPolicy[K, V]) Describe() string {
+	return fmt.Sprintf("midpoint=%g top=%d bottom=%d", p.fraction, p.top.len, p.bottom.len)
+}
+
+// topLimit returns how many entries the top holds.
+func (p *midpointPolicy[K, V]) topLimit() int {
+	return int(float64(p.top.len+p.bottom.len) * p.fraction)
+}
+
+// balance moves entries across the split until the top holds its share.
+func (p *midpointPolicy[K, V]) balance() {
+	limit := p.topLimit()
+	for p.top.len > limit {
+		node := p.top.back()
+		p.top.remove(node)
+		p.bottom.pushFront(node)
+	}
+	for p.top.len < limit {
+		node := p.bottom.front()
+		p.bottom.remove(node)
+		p.top.pushBack(node)
+	}
+}
+
+func (p *midpointPolicy[K, V]) NodeFlags(node *Node[K, V]) string {
+	if node.list == p.top {
+		return "region=top"
+	}
+	return "region=bottom"
+}
+
+func (p *midpointPolicy[K, V]) Check() error {
+	if err := p.top.check("top"); err != nil {
+		return err
+	}
+	if err := p.bottom.check("bottom"); err != nil {
+		return err
+	}
+	if limit := p.topLimit(); p.top.len != limit {
+		return fmt.Errorf("top holds %d entries instead of %d", p.top.len, limit)
+	}
+	return nil
+}
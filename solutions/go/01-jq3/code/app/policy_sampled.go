@@ -0,0 +1,153 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+)
+
+// sampledPolicy approximates LRU the way Redis does: rather than keeping
+// the entries in recency order it stamps each one with a logical access
+// clock, and to evict it draws sampleSize entries at random and picks the
+// one accessed longest ago. A hit only writes the stamp, so reads touch no
+// links at all, at the price of sometimes evicting an entry that is not
+// the least recently used.
+//
+// Entries live in a dense slice, each recording its index in slot, so a
+// removal swaps the last entry into the gap. Pinned entries are not in the
+// policy and can never be sampled.
+type sampledPolicy[K comparable, V any] struct {
+	nodes      []*Node[K, V]
+	sampleSize int
+	clock      uint64
+	// rng draws from src, which Victims copies to draw ahead.
+	rng *rand.Rand
+	src *rand.PCG
+}
+
+func newSampledPolicy[K comparable, V any](sampleSize int, seed uint64) *sampledPolicy[K, V] {
+	p := &sampledPolicy[K, V]{sampleSize: sampleSize}
+	p.Seed(seed)
+	return p
+}
+
+func (p *sampledPolicy[K, V]) Name() string { return "SAMPLED" }
+
+func (p *sampledPolicy[K, V]) Seed(seed uint64) {
+	p.src = rand.NewPCG(seed, seed)
+	p.rng = rand.New(p.src)
+}
+
+func (p *sampledPolicy[K, V]) Add(node *Node[K, V]) {
+	node.slot = len(p.nodes)
+	p.nodes = append(p.nodes, node)
+	p.Access(node)
+}
+
+func (p *sampledPolicy[K, V]) Access(node *Node[K, V]) {
+	p.clock++
+	node.tick = p.clock
+}
+
+func (p *sampledPolicy[K, V]) Remove(node *Node[K, V]) {
+	last := p.nodes[len(p.nodes)-1]
+	p.nodes[node.slot] = last
+	last.slot = node.slot
+	p.nodes[len(p.nodes)-1] = nil
+	p.nodes = p.nodes[:len(p.nodes)-1]
+}
+
+// Evict samples with replacement, so a small cache may see the same entry
+// twice. If every draw lands on keep the first other entry is taken.
+func (p *sampledPolicy[K, V]) Evict(keep *Node[K, V]) *Node[K, V] {
+	if len(p.nodes) == 0 || (len(p.nodes) == 1 && keep != nil) {
+		return nil
+	}
+	var victim *Node[K, V]
+	for range p.sampleSize {
+		node := p.nodes[p.rng.IntN(len(p.nodes))]
+		if node != keep && (victim == nil || node.tick < victim.tick) {
+			victim = node
+		}
+	}
+	if victim == nil {
+		victim = p.nodes[0]
+		if victim == keep {
+			victim = p.nodes[1]
+		}
+	}
+	p.Remove(victim)
+	return victim
+}
+
+// Each visits entries from most to least recently accessed, the order an
+// exact LRU would keep them in; the sampled victims only approximate it.
+func (p *sampledPolicy[K, V]) Each(fn func(node *Node[K, V]) bool) {
+	order := slices.Clone(p.nodes)
+	slices.SortFunc(order, func(a, b *Node[K, V]) int { return cmp.Compare(b.tick, a.tick) })
+	for _, node := range order {
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+// Victims replays Evict's draws with a copy of the generator, over a copy
+// of the entries that loses each victim as Remove would.
+func (p *sampledPolicy[K, V]) Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool) {
+	nodes := slices.Clone(p.nodes)
+	if q.added {
+		nodes = append(nodes, q.keep)
+	}
+	src := *p.src
+	rng := rand.New(&src)
+	for len(nodes) > 1 || (len(nodes) == 1 && q.keep == nil) {
+		victim := -1
+		for range p.sampleSize {
+			i := rng.IntN(len(nodes))
+			if nodes[i] != q.keep && (victim < 0 || nodes[i].tick < nodes[victim].tick) {
+				victim = i
+			}
+		}
+		if victim < 0 {
+			victim = 0
+			if nodes[0] == q.keep {
+				victim = 1
+			}
+		}
+		node := nodes[victim]
+		nodes[victim] = nodes[len(nodes)-1]
+		nodes = nodes[:len(nodes)-1]
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+func (p *sampledPolicy[K, V]) Reset() {
+	clear(p.nodes)
+	p.nodes = p.nodes[:0]
+}
+
+func (p *sampledPolicy[K, V]) Describe() string {
+	return fmt.Sprintf("sample_size=%d", p.sampleSize)
+}
+
+func (p *sampledPolicy[K, V]) NodeFlags(node *Node[K, V]) string {
+	return fmt.Sprintf("tick=%d slot=%d", node.tick, node.slot)
+}
+
+// Check verifies that every entry records its own index and that no access
+// stamp is ahead of the clock.
+func (p *sampledPolicy[K, V]) Check() error {
+	for i, node := range p.nodes {
+		if node.slot != i {
+			return fmt.Errorf("node %v at index %d records slot %d", node.key, i, node.slot)
+		}
+		if node.tick > p.clock {
+			return fmt.Errorf("node %v accessed at %d, after the clock %d", node.key, node.tick, p.clock)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import "fmt"
+
+// slruPolicy is segmented LRU. New entries enter the probationary segment
+// and are promoted to the protected segment on their next hit. When the
+// protected segment outgrows its share of the capacity its least recently
+// used entry is demoted back to the front of probation. Victims always come
+// from the probationary tail, so a burst of one-hit wonders can only evict
+// each other.
+type slruPolicy[K comparable, V any] struct {
+	protectedFraction float64
+	protectedLimit    int
+
+	probation *nodeList[K, V] // most recently used first
+	protected *nodeList[K, V] // most recently used first
+}
+
+func newSLRUPolicy[K comparable, V any](protectedFraction float64) *slruPolicy[K, V] {
+	return &slruPolicy[K, V]{
+		protectedFraction: protectedFraction,
+		protectedLimit:    1,
+		probation:         newNodeList[K, V](),
+		protected:         newNodeList[K, V](),
+	}
+}
+
+func (p *slruPolicy[K, V]) Name() string { return "SLRU" }
+
+func (p *slruPolicy[K, V]) SetCapacity(capacity int) {
+	p.protectedLimit = max(1, int(float64(capacity)*p.protectedFraction))
+	p.demoteOverflow()
+}
+
+func (p *slruPolicy[K, V]) Add(node *Node[K, V]) {
+	p.probation.pushFront(node)
+}
+
+func (p *slruPolicy[K, V]) AddCold(node *Node[K, V]) {
+	p.probation.pushBack(node)
+}
+
+func (p *slruPolicy[K, V]) Access(node *Node[K, V]) {
+	if node.list == p.protected {
+		p.protected.moveToFront(node)
+		return
+	}
+	p.probation.remove(node)
+	p.protected.pushFront(node)
+	p.demoteOverflow()
+}
+
+func (p *slruPolicy[K, V]) Remove(node *Node[K, V]) {
+	node.list.remove(node)
+}
+
+func (p *slruPolicy[K, V]) Evict(keep *Node[K, V]) *Node[K, V] {
+	node := p.probation.backExcept(keep)
+	if node == nil {
+		node = p.protected.backExcept(keep)
+	}
+	if node != nil {
+		node.list.remove(node)
+	}
+	return node
+}
+
+func (p *slruPolicy[K, V]) Victim() *Node[K, V] {
+	if node := p.probation.back(); node != nil {
+		return node
+	}
+	return p.protected.back()
+}
+
+func (p *slruPolicy[K, V]) Each(fn func(node *Node[K, V]) bool) {
+	stopped := false
+	p.protected.each(func(node *Node[K, V]) bool {
+		stopped = !fn(node)
+		return !stopped
+	})
+	if !stopped {
+		p.probation.each(fn)
+	}
+}
+
+// Victims follows Evict: probation from its tail, then the protected
+// segment. Demotion moves entries from the end of one to the front of the
+// other, keeping their order.
+func (p *slruPolicy[K, V]) Victims(q victimQuery[K, V], fn func(node *Node[K, V]) bool) {
+	if p.probation.eachBack(q.keep, fn) {
+		p.protected.eachBack(q.keep, fn)
+	}
+}
+
+func (p *slruPolicy[K, V]) Reset() {
+	p.probation.init()
+	p.protected.init()
+}
+
+// Describe reports the size of each segment.
+func (p *slruPolicy[K, V]) Describe() string {
+	return fmt.Sprintf("probation=%d protected=%d", p.probation.len, p.protected.len)
+}
+
+// demoteOverflow moves protected entries beyond the segment's limit back to
+// the front of probation, giving them one more chance to be hit.
+func (p *slruPolicy[K, V]) demoteOverflow() {
+	for p.protected.len > p.protectedLimit {
+		node := p.protected.back()
+		p.protected.remove(node)
+		p.probation.pushFront(node)
+	}
+}
+
+func (p *slruPolicy[K, V]) NodeFlags(node *Node[K, V]) string {
+	if node.list == p.protected {
+		return "segment=protected"
+	}
+	return "segment=probation"
+}
+
+func (p *slruPolicy[K, V]) Check() error {
+	if err := p.probation.check("probation"); err != nil {
+		return err
+	}
+	return p.protected.check("protected")
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// policyCache returns a cache of capacity with the policy spec names, as
+// INIT takes it: a name and its arguments.
+func policyCache(t testing.TB, capacity int, spec string) *LRUCache[string, int] {
+	t.Helper()
+	fields := strings.Fields(spec)
+	policy, err := newPolicy[string, int](fields[0], fields[1:])
+	if err != nil {
+		t.Fatalf("newPolicy(%q): %v", spec, err)
+	}
+	return NewLRUCacheWithPolicy(capacity, policy)
+}
+
+func TestLFUKeepsHotKeyLRUEvicts(t *testing.T) {
+	for _, tc := range []struct {
+		policy  string
+		evicted string
+	}{
+		{"LRU", "a"},
+		{"LFU", "b"},
+	} {
+		c := policyCache(t, 2, tc.policy)
+		c.Put("a", 1)
+		c.Get("a")
+		c.Get("a")
+		c.Get("a")
+		c.Put("b", 2)
+		c.Get("b")
+		c.Put("c", 3)
+		if c.Contains(tc.evicted) || c.Size() != 2 {
+			t.Errorf("%s kept %v, want %s evicted", tc.policy, c.Keys(), tc.evicted)
+		}
+		if err := c.DebugCheck(); err != nil {
+			t.Errorf("%s: %v", tc.policy, err)
+		}
+	}
+}
+
+func TestLFUBreaksTiesByRecency(t *testing.T) {
+	c := policyCache(t, 3, "LFU")
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Put("c", 3)
+	c.Get("a")
+	c.Get("b")
+	c.Get("c")
+	// All used twice: the least recently used of them goes.
+	c.Put("d", 4)
+	if c.Contains("a") || !c.Contains("b") || !c.Contains("c") {
+		t.Errorf("keys %v, want a evicted", c.Keys())
+	}
+	// d, used once, goes before any of the others.
+	c.Put("e", 5)
+	if c.Contains("d") {
+		t.Errorf("keys %v, want d evicted", c.Keys())
+	}
+}
+
+func TestPolicyCommandsBehaveAlike(t *testing.T) {
+	for _, policy := range []string{"LRU", "LFU", "FIFO", "CLOCK", "2Q", "ARC", "SLRU", "LRUK 2"} {
+		s := newTestSession(t)
+		script(t, s,
+			"INIT 3 "+policy, "OK",
+			"PUT a 1", "OK",
+			"PUT a 2", "OK",
+			"GET a", "2",
+			"GET b", "NULL",
+			"SIZE", "1",
+		)
+		for _, key := range []string{"b", "c", "d", "e"} {
+			script(t, s, "PUT "+key+" x", "OK")
+		}
+		if got := s.Execute("SIZE"); got != "3" {
+			t.Errorf("%s: SIZE = %s after filling, want 3", policy, got)
+		}
+		if got := s.Execute("DEBUG CHECK"); got != "OK" {
+			t.Errorf("%s: DEBUG CHECK = %s", policy, got)
+		}
+	}
+}
+
+// TestLFUDecayLetsWarmKeyOutlastStaleHotKey ages a hot key: decay counts
+// adds and accesses, not time, so a long quiet spell on the fake clock
+// leaves its count alone, while the reads of a warm key that follow halve
+// it until the warm key outranks it. Without decay the hot key stays.
+func TestLFUDecayLetsWarmKeyOutlastStaleHotKey(t *testing.T) {
+	for _, tc := range []struct {
+		policy, quiet, warmed, evicted string
+	}{
+		{"LFU DECAY 8", "0 hot 1 freq=7", "0 warm 2 freq=7\n1 hot 1 freq=1", "hot"},
+		{"LFU", "0 hot 1 freq=7", "0 warm 2 freq=17\n1 hot 1 freq=7", "x"},
+	} {
+		s := newClockSession(t)
+		script(t, s, "INIT 3 "+tc.policy, "OK", "PUT hot 1", "OK")
+		for range 6 {
+			script(t, s, "GET hot", "1")
+		}
+		script(t, s,
+			"DEBUG ADVANCECLOCK 3600", "OK",
+			"DEBUG DUMP", "capacity=3 policy=LFU size=1\n"+tc.quiet,
+			"PUT warm 2", "OK",
+		)
+		for range 16 {
+			script(t, s, "GET warm", "2")
+		}
+		script(t, s,
+			"DEBUG DUMP", "capacity=3 policy=LFU size=2\n"+tc.warmed,
+			"PUT x 1", "OK",
+			"PUT y 1", "OK",
+			"EXISTS "+tc.evicted, "0",
+			"EXISTS warm", "1",
+		)
+	}
+}
+
+func TestInitRejectsUnknownPolicy(t *testing.T) {
+	s := newTestSession(t)
+	if got := s.Execute("INIT 3 MRU"); !strings.HasPrefix(got, "ERROR") {
+		t.Errorf("INIT 3 MRU = %q", got)
+	}
+	if s.cache != nil {
+		t.Error("a failed INIT created a cache")
+	}
+}
+
+// numbered returns the keys <prefix>0 to <prefix><n-1>.
+func numbered(prefix string, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = prefix + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// readThrough gets each key in order from c, putting the ones it misses.
+func readThrough(c *LRUCache[string, int], keys []string) (hits int) {
+	for i, key := range keys {
+		if _, ok := c.Get(key); ok {
+			hits++
+		} else {
+			c.Put(key, i)
+		}
+	}
+	return hits
+}
+
+func TestTwoQueueResistsScan(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		kept   bool
+	}{
+		{"LRU", false},
+		{"2Q", true},
+		{"2Q 10,50", true},
+	} {
+		c := policyCache(t, 20, tc.policy)
+		// A key reaches 2Q's main queue by coming back after it has
+		// been evicted from the in queue, which the cold keys between
+		// passes over the working set see to.
+		ws := numbered("w", 10)
+		for round := range 10 {
+			readThrough(c, ws)
+			readThrough(c, numbered("c"+strconv.Itoa(round)+"-", 12))
+		}
+		readThrough(c, numbered("s", 200))
+		held := 0
+		for _, key := range ws {
+			if c.Contains(key) {
+				held++
+			}
+		}
+		if kept := held == len(ws); kept != tc.kept {
+			t.Errorf("%s: %d of the working set survived the scan", tc.policy, held)
+		}
+		if err := c.DebugCheck(); err != nil {
+			t.Errorf("%s: %v", tc.policy, err)
+		}
+	}
+}
+
+func TestMidpointResistsScans(t *testing.T) {
+	ratios := map[string]float64{}
+	for _, policy := range []string{"LRU", "LRU MIDPOINT", "LRU MIDPOINT 0.6"} {
+		c := policyCache(t, 20, policy)
+		// The working set is read twice in a row, the second time from
+		// the bottom if need be, which promotes it, and then a scan of
+		// more keys read once than the cache holds goes by.
+		ws := numbered("w", 10)
+		hits, reads := 0, 0
+		for round := range 20 {
+			hits += readThrough(c, ws) + readThrough(c, ws)
+			reads += 2 * len(ws)
+			readThrough(c, numbered("s"+strconv.Itoa(round)+"-", 30))
+		}
+		ratios[policy] = float64(hits) / float64(reads)
+		if err := c.DebugCheck(); err != nil {
+			t.Errorf("%s: %v", policy, err)
+		}
+	}
+	// Each scan flushes LRU, so only the second read of each pair hits.
+	if ratios["LRU"] != 0.5 {
+		t.Errorf("LRU hit ratio %.2f on the working set, want 0.50", ratios["LRU"])
+	}
+	for _, policy := range []string{"LRU MIDPOINT", "LRU MIDPOINT 0.6"} {
+		if ratios[policy] < 0.9 {
+			t.Errorf("%s hit ratio %.2f on the working set, want at least 0.9", policy, ratios[policy])
+		}
+	}
+}
+
+func TestTwoQueueGhostsAreBounded(t *testing.T) {
+	c := policyCache(t, 20, "2Q 25,50")
+	readThrough(c, numbered("s", 1000))
+	p := c.policy.(*twoQueuePolicy[string, int])
+	if p.out.len() > 10 {
+		t.Errorf("ghost queue holds %d keys, over its limit of 10", p.out.len())
+	}
+	if c.Size() != 20 {
+		t.Errorf("size %d", c.Size())
+	}
+}
+
+func TestTwoQueueSplitArgument(t *testing.T) {
+	for _, arg := range []string{"25", "0,50", "100,50", "25,0", "a,b"} {
+		if _, err := newPolicy[string, int]("2Q", []string{arg}); err == nil {
+			t.Errorf("2Q %s accepted", arg)
+		}
+	}
+	s := newTestSession(t)
+	script(t, s, "INIT 8 2Q 25,50", "OK", "PUT a 1", "OK", "GET a", "1")
+}
+
+func TestARCAdaptsTarget(t *testing.T) {
+	c := policyCache(t, 10, "ARC")
+	p := c.policy.(*arcPolicy[string, int])
+
+	// A few keys used twice take t2, so that keys seen once, in t1, are
+	// remembered in b1 when they are evicted. Asked for again, they are
+	// hits in b1: t1 should have been bigger.
+	for _, key := range numbered("h", 4) {
+		readThrough(c, []string{key, key})
+	}
+	for range 2 {
+		readThrough(c, numbered("r", 10))
+	}
+	grown := p.p
+	if grown <= 0 {
+		t.Fatalf("p = %d after the recency pattern, want it raised", grown)
+	}
+
+	// Keys used twice in a row live in t2, and asked for again after
+	// their eviction from it are hits in b2: t2 should have been bigger.
+	twice := numbered("f", 14)
+	for range 5 {
+		for _, key := range twice {
+			readThrough(c, []string{key, key})
+		}
+	}
+	if p.p >= grown {
+		t.Errorf("p = %d after the frequency pattern, want it below %d", p.p, grown)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestARCCountsOnlyResidentEntries(t *testing.T) {
+	c := policyCache(t, 8, "ARC")
+	p := c.policy.(*arcPolicy[string, int])
+	for range 3 {
+		readThrough(c, numbered("k", 30))
+	}
+	if c.Size() != 8 || p.t1.len+p.t2.len != 8 {
+		t.Errorf("size %d, t1+t2 = %d, want 8", c.Size(), p.t1.len+p.t2.len)
+	}
+	if p.b1.len()+p.b2.len() > 8 {
+		t.Errorf("ghosts hold %d keys, over the capacity", p.b1.len()+p.b2.len())
+	}
+}
+
+func TestARCStatsShowTarget(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s, "INIT 4 ARC", "OK", "PUT a 1", "OK", "GET a", "1")
+	if got := s.Execute("STATS"); !strings.HasSuffix(got, " p=0 t1=0 t2=1 b1=0 b2=0") {
+		t.Errorf("STATS = %q", got)
+	}
+}
+
+func TestSLRUProtectsKeysUsedTwice(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		kept   bool
+	}{
+		{"LRU", false},
+		{"SLRU", true},
+		{"SLRU 0.5", true},
+	} {
+		c := policyCache(t, 10, tc.policy)
+		hot := numbered("h", 5)
+		for _, key := range hot {
+			readThrough(c, []string{key, key})
+		}
+		readThrough(c, numbered("once", 100))
+		held := 0
+		for _, key := range hot {
+			if c.Contains(key) {
+				held++
+			}
+		}
+		if kept := held == len(hot); kept != tc.kept {
+			t.Errorf("%s: %d of the keys used twice survived the burst", tc.policy, held)
+		}
+	}
+}
+
+func TestSLRUDemotesProtectedOverflow(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 5 SLRU 0.4", "OK",
+		"PUT a 1", "OK",
+		"PUT b 2", "OK",
+		"PUT c 3", "OK",
+		"GET a", "1",
+		"GET b", "2",
+		"GET c", "3",
+		// Protected holds 2: a, the least recently used, goes back to
+		// the front of probation.
+		"DEBUG DUMP", "capacity=5 policy=SLRU size=3\n"+
+			"0 c 3 segment=protected\n"+
+			"1 b 2 segment=protected\n"+
+			"2 a 1 segment=probation",
+		"PUT d 4", "OK",
+		"PUT e 5", "OK",
+		"PUT f 6", "OK",
+		// Probation's tail goes first: a was demoted ahead of d and e.
+		"EXISTS a", "0",
+		"DEBUG CHECK", "OK",
+	)
+	if got := s.Execute("STATS"); !strings.HasSuffix(got, " probation=3 protected=2") {
+		t.Errorf("STATS = %q", got)
+	}
+}
+
+func TestSLRURejectsBadFraction(t *testing.T) {
+	for _, arg := range []string{"0", "1", "1.5", "x"} {
+		if _, err := newPolicy[string, int]("SLRU", []string{arg}); err == nil {
+			t.Errorf("SLRU %s accepted", arg)
+		}
+	}
+}
+
+func TestLRUKBeatsLRUOnScans(t *testing.T) {
+	// The working set is read twice to begin with, and then each round
+	// reads it and keys never seen again. The distinct keys of a round
+	// overflow the cache, so LRU evicts the working set before it comes
+	// back; LRU-2 evicts the keys read once.
+	hits := map[string]int{}
+	for _, policy := range []string{"LRU", "LRUK 2"} {
+		c := policyCache(t, 10, policy)
+		ws := numbered("w", 6)
+		readThrough(c, ws)
+		readThrough(c, ws)
+		for round := range 20 {
+			hits[policy] += readThrough(c, ws)
+			readThrough(c, numbered("c"+strconv.Itoa(round)+"-", 8))
+		}
+		if err := c.DebugCheck(); err != nil {
+			t.Errorf("%s: %v", policy, err)
+		}
+	}
+	if hits["LRUK 2"] != 6*20 || hits["LRU"] >= hits["LRUK 2"]/2 {
+		t.Errorf("working set hits: LRU %d, LRU-2 %d", hits["LRU"], hits["LRUK 2"])
+	}
+}
+
+func TestLRUKEvictsShortHistoriesFirst(t *testing.T) {
+	c := policyCache(t, 3, "LRUK 2")
+	c.Put("a", 1)
+	c.Get("a")
+	c.Put("b", 2)
+	c.Put("c", 3)
+	// b and c have one access each, so the older of them goes even though
+	// a was used before either.
+	c.Put("d", 4)
+	if c.Contains("b") || !c.Contains("a") || !c.Contains("c") {
+		t.Errorf("keys %v, want b evicted", c.Keys())
+	}
+	c.Get("c")
+	c.Get("d")
+	// All have two accesses now: a's second most recent is the oldest.
+	c.Put("e", 5)
+	if c.Contains("a") {
+		t.Errorf("keys %v, want a evicted", c.Keys())
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLRUKArgument(t *testing.T) {
+	for _, arg := range []string{"0", "-1", "x"} {
+		if _, err := newPolicy[string, int]("LRUK", []string{arg}); err == nil {
+			t.Errorf("LRUK %s accepted", arg)
+		}
+	}
+	// With k = 1 it is plain LRU.
+	c := policyCache(t, 2, "LRUK 1")
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a")
+	c.Put("c", 3)
+	if c.Contains("b") || !c.Contains("a") {
+		t.Errorf("LRUK 1 kept %v, want b evicted", c.Keys())
+	}
+	s := newTestSession(t)
+	script(t, s, "INIT 3 LRUK 3", "OK")
+	if got := s.Execute("STATS"); !strings.HasSuffix(got, " k=3") {
+		t.Errorf("STATS = %q", got)
+	}
+}
+
+// BenchmarkClockVsLRUGet reads keys that are all cached, in an order that
+// jumps about the list: LRU moves each to the front of its list, while
+// CLOCK only sets its reference bit.
+func BenchmarkClockVsLRUGet(b *testing.B) {
+	const capacity = 1 << 14
+	keys := numbered("k", capacity)
+	for _, policy := range []string{"LRU", "CLOCK"} {
+		b.Run(policy, func(b *testing.B) {
+			c := policyCache(b, capacity, policy)
+			for _, key := range keys {
+				c.Put(key, 1)
+			}
+			b.ResetTimer()
+			for i := range b.N {
+				c.Get(keys[(i*7919)%capacity])
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import "errors"
+
+// Priority ranks entries for eviction: every low priority entry is evicted
+// before any normal one, and every normal one before any high one.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// ErrBadPriority is returned by PutWithPriority for a priority other than
+// PriorityLow, PriorityNormal or PriorityHigh.
+var ErrBadPriority = errors.New("priority must be 0 (low), 1 (normal) or 2 (high)")
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	}
+	return "normal"
+}
+
+// Normal entries are ordered by the eviction policy. Low and high ones are
+// taken out of it, as pinned ones are, and kept on the cache's own lists,
+// most recently used first, so that evicting from either is O(1) whatever
+// the policy. Victims come from the back of the low list, then from the
+// policy, then from the back of the high list. Pinning trumps priority: a
+// pinned entry keeps its priority but stays on the pinned list until it is
+// unpinned. Snapshots and exports do not record priorities, so restored
+// entries are normal; a rewritten AOF keeps those of strings.
+
+// PutWithPriority stores value under key, as Put does, at priority p.
+// Updating a key moves it to p; a plain Put of an existing key keeps its
+// priority, and one of a new key makes it normal.
+func (c *LRUCache[K, V]) PutWithPriority(key K, value V, p Priority) error {
+	key = c.normalize(key)
+	if p < PriorityLow || p > PriorityHigh {
+		return ErrBadPriority
+	}
+	c.lock()
+	defer c.unlock()
+
+	if err := c.put(key, value, 0, c.defaultCost(key, value)); err != nil {
+		return err
+	}
+	// The admission filter may have turned a new key away.
+	if node, ok := c.cache[key]; ok {
+		c.setPriority(node, p)
+	}
+	return nil
+}
+
+// Priority returns the priority of key without promoting it, and false if
+// key is absent.
+func (c *LRUCache[K, V]) Priority(key K) (Priority, bool) {
+	key = c.normalize(key)
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	node, ok := c.cache[key]
+	if !ok || node.expired(c.now()) {
+		return PriorityNormal, false
+	}
+	return node.priority, true
+}
+
+func (c *LRUCache[K, V]) setPriority(node *Node[K, V], p Priority) {
+	if node.priority == p {
+		return
+	}
+	if node.pinned || node.tenant != "" {
+		node.priority = p
+		return
+	}
+	c.displace(node)
+	node.priority = p
+	c.place(node)
+}
+
+// tier returns the list holding unpinned entries of priority p outside any
+// tenant, or nil for normal ones, which the policy holds.
+func (c *LRUCache[K, V]) tier(p Priority) *nodeList[K, V] {
+	switch p {
+	case PriorityLow:
+		return c.lowTier
+	case PriorityHigh:
+		return c.highTier
+	}
+	return nil
+}
+
+// list returns the list holding node if it is unpinned, its tenant's or
+// its priority's, or nil if the policy holds it.
+func (c *LRUCache[K, V]) list(node *Node[K, V]) *nodeList[K, V] {
+	if p := c.tenants[node.tenant]; p != nil {
+		return p.entries
+	}
+	return c.tier(node.priority)
+}
+
+// place hands an unpinned node to the policy or its tenant's or priority's
+// list as a newly added entry.
+func (c *LRUCache[K, V]) place(node *Node[K, V]) {
+	if l := c.list(node); l != nil {
+		l.pushFront(node)
+		return
+	}
+	c.policy.Add(node)
+}
+
+// displace takes node out of whichever of the pinned list, the policy and
+// the tenant and priority lists holds it.
+func (c *LRUCache[K, V]) displace(node *Node[K, V]) {
+	switch l := c.list(node); {
+	case node.pinned:
+		c.pinned.remove(node)
+	case l != nil:
+		l.remove(node)
+	default:
+		c.policy.Remove(node)
+	}
+}
+
+// evictNext takes the next victim other than keep out of the priority
+// lists, the policy or the tenants' lists and returns it, or nil if there
+// is none. The caller removes it from the map.
+func (c *LRUCache[K, V]) evictNext(keep *Node[K, V]) *Node[K, V] {
+	if node := c.lowTier.backExcept(keep); node != nil {
+		c.lowTier.remove(node)
+		return node
+	}
+	if node := c.policy.Evict(keep); node != nil {
+		return node
+	}
+	if node := c.highTier.backExcept(keep); node != nil {
+		c.highTier.remove(node)
+		return node
+	}
+	return c.tenantVictim(keep)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// ErrBadProfileSize is returned by SetProfile for a size that is not a
+// positive multiple of the capacity.
+var ErrBadProfileSize = errors.New("profile sizes must be positive multiples of the capacity")
+
+// profiler estimates the hit ratio the cache would have at other sizes. For
+// each size it keeps a ghost cache: the keys alone, in LRU order, with the
+// cost each was charged, trimmed to that multiple of the real capacity or
+// byte budget. Every Get is looked up in each ghost and every Put stored
+// there, and keys the real cache deletes or expires leave the ghosts too,
+// so each ghost sees exactly the traffic the real cache does.
+//
+// Ghosts always model LRU, whatever policy the real cache uses, since they
+// hold only what LRU needs. Comparing the ghosts with one another, or with
+// a 1x ghost, is therefore fairer than comparing them with the real cache.
+//
+// The profiler has its own lock so that it can be fed from Gets served
+// under the cache's read lock.
+type profiler[K comparable] struct {
+	mu     sync.Mutex
+	ghosts []*ghostCache[K]
+	hits   int // of the real cache since profiling started
+	misses int
+}
+
+type ghostCache[K comparable] struct {
+	multiple float64
+	limit    int
+	used     int
+	order    *list.List // of ghostEntry, front most recently used
+	index    map[K]*list.Element
+	hits     int
+	misses   int
+}
+
+type ghostEntry[K comparable] struct {
+	key  K
+	cost int
+}
+
+// ProfileSize is the outcome of profiling one cache size. The first size
+// Profile returns is the real cache, marked Actual.
+type ProfileSize struct {
+	Multiple float64 `json:"multiple"`
+	Capacity int     `json:"capacity"`
+	Hits     int     `json:"hits"`
+	Misses   int     `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+	Actual   bool    `json:"actual,omitempty"`
+}
+
+func (s ProfileSize) String() string {
+	name := strconv.FormatFloat(s.Multiple, 'g', -1, 64) + "x"
+	if s.Actual {
+		name = "actual"
+	}
+	return fmt.Sprintf("%s capacity=%d hits=%d misses=%d hit_ratio=%.4f", name, s.Capacity, s.Hits, s.Misses, s.HitRatio)
+}
+
+func newProfiler[K comparable](multiples []float64, budget int) *profiler[K] {
+	p := &profiler[K]{}
+	for _, m := range multiples {
+		p.ghosts = append(p.ghosts, &ghostCache[K]{
+			multiple: m,
+			limit:    ghostLimit(m, budget),
+			order:    list.New(),
+			index:    make(map[K]*list.Element),
+		})
+	}
+	return p
+}
+
+// ghostLimit returns multiple times budget, but never less than 1.
+func ghostLimit(multiple float64, budget int) int {
+	return max(int(multiple*float64(budget)), 1)
+}
+
+func (p *profiler[K]) get(key K, hit bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if hit {
+		p.hits++
+	} else {
+		p.misses++
+	}
+	for _, g := range p.ghosts {
+		if elem, ok := g.index[key]; ok {
+			g.hits++
+			g.order.MoveToFront(elem)
+		} else {
+			g.misses++
+		}
+	}
+}
+
+func (p *profiler[K]) put(key K, cost int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range p.ghosts {
+		if elem, ok := g.index[key]; ok {
+			e := elem.Value.(ghostEntry[K])
+			g.used += cost - e.cost
+			elem.Value = ghostEntry[K]{key, cost}
+			g.order.MoveToFront(elem)
+		} else {
+			g.index[key] = g.order.PushFront(ghostEntry[K]{key, cost})
+			g.used += cost
+		}
+		g.trim()
+	}
+}
+
+func (p *profiler[K]) remove(key K) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range p.ghosts {
+		if elem, ok := g.index[key]; ok {
+			g.used -= elem.Value.(ghostEntry[K]).cost
+			g.order.Remove(elem)
+			delete(g.index, key)
+		}
+	}
+}
+
+// resize rescales every ghost to its multiple of a new budget.
+func (p *profiler[K]) resize(budget int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range p.ghosts {
+		g.limit = ghostLimit(g.multiple, budget)
+		g.trim()
+	}
+}
+
+// clear forgets every key, keeping the counts, for when the real cache's
+// contents are replaced wholesale.
+func (p *profiler[K]) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range p.ghosts {
+		g.order.Init()
+		g.index = make(map[K]*list.Element)
+		g.used = 0
+	}
+}
+
+func (g *ghostCache[K]) trim() {
+	for g.used > g.limit {
+		elem := g.order.Back()
+		e := elem.Value.(ghostEntry[K])
+		g.order.Remove(elem)
+		delete(g.index, e.key)
+		g.used -= e.cost
+	}
+}
+
+func (p *profiler[K]) report(budget int) []ProfileSize {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sizes := []ProfileSize{{Multiple: 1, Capacity: budget, Hits: p.hits, Misses: p.misses, HitRatio: hitRatio(p.hits, p.misses), Actual: true}}
+	for _, g := range p.ghosts {
+		sizes = append(sizes, ProfileSize{Multiple: g.multiple, Capacity: g.limit, Hits: g.hits, Misses: g.misses, HitRatio: hitRatio(g.hits, g.misses)})
+	}
+	return sizes
+}
+
+// lookups returns how many lookups the real cache has had since profiling
+// started.
+func (p *profiler[K]) lookups() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hits + p.misses
+}
+
+func hitRatio(hits, misses int) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// budget returns what the cache is bounded by: its byte or weight budget
+// if it has one, and otherwise its capacity.
+func (c *LRUCache[K, V]) budget() int {
+	if c.maxCost > 0 {
+		return c.maxCost
+	}
+	return c.capacity
+}
+
+// SetProfile starts estimating the hit ratio the cache would have at each
+// of multiples times its current size, replacing any profiling already
+// under way, or stops if multiples is empty and frees the ghost caches.
+// Ghosts start empty, so they need the traffic of a few cache-fulls to
+// warm up before they can be compared.
+func (c *LRUCache[K, V]) SetProfile(multiples []float64) error {
+	for _, m := range multiples {
+		if !(m > 0) || math.IsInf(m, 1) {
+			return ErrBadProfileSize
+		}
+	}
+	c.lock()
+	defer c.unlock()
+	if c.tuner != nil {
+		return ErrAutotuning
+	}
+	if len(multiples) == 0 {
+		c.profile.Store(nil)
+		return nil
+	}
+	c.profile.Store(newProfiler[K](multiples, c.budget()))
+	return nil
+}
+
+// Profile reports the hits and misses of the real cache, followed by those
+// each ghost cache would have had, since SetProfile. It returns nil if
+// profiling is off.
+func (c *LRUCache[K, V]) Profile() []ProfileSize {
+	p := c.profile.Load()
+	if p == nil {
+		return nil
+	}
+	c.rlock()
+	budget := c.budget()
+	c.mu.RUnlock()
+	return p.report(budget)
+}
@@ -0,0 +1,91 @@
+package main
+
+import "math/rand/v2"
+
+// The cache keeps every resident node in a dense slice as well as the map,
+// each node recording its index, so that RandomKey can draw uniformly: Go
+// map iteration order is random but far from uniform. Removing a node
+// swaps the last one into its place.
+
+// randomKeyDraws bounds how many draws RandomKey makes before it stops
+// hoping to land on a live entry and looks through them all.
+const randomKeyDraws = 16
+
+func (c *LRUCache[K, V]) addResident(node *Node[K, V]) {
+	node.index = len(c.resident)
+	c.resident = append(c.resident, node)
+	c.chargeTenant(node, 1, node.cost)
+	c.indexKey(node.key)
+}
+
+func (c *LRUCache[K, V]) dropResident(node *Node[K, V]) {
+	c.chargeTenant(node, -1, -node.cost)
+	last := c.resident[len(c.resident)-1]
+	c.resident[node.index] = last
+	last.index = node.index
+	c.resident[len(c.resident)-1] = nil
+	c.resident = c.resident[:len(c.resident)-1]
+	c.unindexKey(node.key)
+}
+
+func (c *LRUCache[K, V]) resetResident() {
+	clear(c.resident)
+	c.resident = c.resident[:0]
+	c.resetKeyIndex()
+}
+
+// RandomKey returns a key chosen uniformly at random from the live entries,
+// without promoting it or counting a hit or miss. It reports false if there
+// are none. Entries past their grace period that it comes across are
+// removed. The choice is drawn from the cache's generator, which Seed
+// makes repeatable.
+func (c *LRUCache[K, V]) RandomKey() (key K, ok bool) {
+	c.lock()
+	defer c.unlock()
+
+	// Drawing until a live entry turns up is uniform over the live
+	// entries; if the draws keep landing on expired ones, pick from a
+	// list of the live ones instead.
+	now := c.now()
+	for range randomKeyDraws {
+		if len(c.resident) == 0 {
+			return key, false
+		}
+		node := c.resident[c.rng.IntN(len(c.resident))]
+		if !node.expired(now) {
+			return node.key, true
+		}
+		if node.gone(now) && !c.readOnly {
+			c.remove(node, node.expiry(now))
+		}
+	}
+	var live []K
+	for _, node := range c.resident {
+		if !node.expired(now) {
+			live = append(live, node.key)
+		}
+	}
+	if len(live) == 0 {
+		return key, false
+	}
+	return live[c.rng.IntN(len(live))], true
+}
+
+// seedable is implemented by policies that make random choices, so that
+// Seed can make them repeatable.
+type seedable interface {
+	Seed(seed uint64)
+}
+
+// Seed reseeds the generator RandomKey draws from, and the policy's if it
+// has one, so that the same seed and the same commands give the same
+// choices.
+func (c *LRUCache[K, V]) Seed(seed uint64) {
+	c.lock()
+	defer c.unlock()
+
+	c.rng = rand.New(rand.NewPCG(seed, seed))
+	if p, ok := c.policy.(seedable); ok {
+		p.Seed(seed)
+	}
+}
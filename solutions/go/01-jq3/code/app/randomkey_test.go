@@ -0,0 +1,84 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRandomKeyOfEmptyCache(t *testing.T) {
+	c, clock := clockCache(4)
+	if k, ok := c.RandomKey(); ok {
+		t.Errorf("RandomKey of a new cache = %q", k)
+	}
+	c.Put("a", 1)
+	c.Remove("a")
+	if k, ok := c.RandomKey(); ok {
+		t.Errorf("RandomKey after the last key went = %q", k)
+	}
+	// Expired entries still resident do not count, however many draws
+	// land on them.
+	for _, k := range numbered("k", 4) {
+		c.PutWithTTL(k, 1, time.Second)
+	}
+	clock.Advance(time.Second)
+	if k, ok := c.RandomKey(); ok {
+		t.Errorf("RandomKey with every entry expired = %q", k)
+	}
+	if n := c.Len(); n != 0 {
+		t.Errorf("%d expired entries left after RandomKey came across them", n)
+	}
+
+	s := newTestSession(t)
+	script(t, s,
+		"RANDOMKEY", "ERROR ERR_NOT_INITIALIZED Cache not initialized",
+		"INIT 2", "OK",
+		"RANDOMKEY", "NULL",
+		"PUT a 1", "OK",
+		"RANDOMKEY", "a",
+		"DELETE a", "OK",
+		"RANDOMKEY", "NULL",
+	)
+}
+
+func TestRandomKeyIsResident(t *testing.T) {
+	c, clock := clockCache(10)
+	c.Seed(1)
+	for i, k := range numbered("k", 30) {
+		if i%3 == 0 {
+			c.PutWithTTL(k, i, time.Second)
+		} else {
+			c.Put(k, i)
+		}
+	}
+	clock.Advance(time.Second)
+	var live []string
+	for _, k := range c.Keys() {
+		if c.Contains(k) {
+			live = append(live, k)
+		}
+	}
+	if len(live) == 0 || len(live) == 10 {
+		t.Fatalf("%d of 10 entries live; want some evicted and some expired", len(live))
+	}
+	order, stats := c.Keys(), c.Stats()
+
+	seen := map[string]int{}
+	for range 1000 {
+		k, ok := c.RandomKey()
+		if !ok || !slices.Contains(live, k) {
+			t.Fatalf("RandomKey = %q, %v; live keys are %q", k, ok, live)
+		}
+		seen[k]++
+	}
+	if len(seen) != len(live) {
+		t.Errorf("1000 draws found only %v of %q", seen, live)
+	}
+	// Drawing marks nothing used and counts nothing.
+	if keys := c.Keys(); !slices.Equal(keys, order) {
+		t.Errorf("keys %q after the draws, %q before", keys, order)
+	}
+	if st := c.Stats(); st.Hits != stats.Hits || st.Misses != stats.Misses {
+		t.Errorf("draws counted hits=%d misses=%d", st.Hits-stats.Hits, st.Misses-stats.Misses)
+	}
+}
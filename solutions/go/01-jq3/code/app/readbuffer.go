@@ -0,0 +1,173 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Get that hits a live entry, or misses, takes only the read lock. It
+// cannot promote the entry there, since that rewrites the policy, so it
+// records the read in a readBuffer instead, and the buffered reads are
+// applied in the order they happened the next time anyone takes the write
+// lock, or as soon as a stripe of the buffer fills. Every write applies them
+// before it changes anything, so eviction, sizes and costs are exactly what
+// they would be with every Get promoting at once; only reads racing each
+// other can land in a different order than they returned, and a read that
+// finds its stripe full and waiting to be drained is not recorded at all.
+// Reads that need the write lock anyway, because the entry has expired or
+// refresh-ahead is on, take it up front.
+
+const (
+	readStripes    = 16 // stripes reads are spread over round-robin
+	readStripeSize = 32 // reads a stripe holds before it is drained
+)
+
+type bufferedRead[K comparable] struct {
+	seq uint64
+	key K
+	at  time.Time // when the read happened, for the access time
+	hit bool
+}
+
+type readStripe[K comparable] struct {
+	mu    sync.Mutex
+	reads []bufferedRead[K]
+}
+
+// readBuffer collects the reads made under the read lock. Each read goes
+// to the next stripe in turn, so concurrent readers rarely wait on the
+// same stripe lock.
+type readBuffer[K comparable] struct {
+	seq     atomic.Uint64
+	pending atomic.Int64 // reads buffered across all stripes
+	stripes [readStripes]readStripe[K]
+	drained []bufferedRead[K] // scratch space for take, reused
+}
+
+// add buffers a read and reports whether its stripe is now full. It must be
+// called with the cache's read lock held, so that the read is buffered
+// before any later write can drain the buffer.
+func (b *readBuffer[K]) add(key K, at time.Time, hit bool) (full bool) {
+	seq := b.seq.Add(1)
+	s := &b.stripes[seq%readStripes]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.reads) < readStripeSize {
+		s.reads = append(s.reads, bufferedRead[K]{seq: seq, key: key, at: at, hit: hit})
+		b.pending.Add(1)
+	}
+	return len(s.reads) == readStripeSize
+}
+
+// take empties every stripe and returns their reads in the order they
+// were made. The result is only valid until the next take. It must be
+// called with the cache's write lock held.
+func (b *readBuffer[K]) take() []bufferedRead[K] {
+	clear(b.drained)
+	b.drained = b.drained[:0]
+	for i := range b.stripes {
+		s := &b.stripes[i]
+		s.mu.Lock()
+		b.drained = append(b.drained, s.reads...)
+		clear(s.reads)
+		s.reads = s.reads[:0]
+		s.mu.Unlock()
+	}
+	b.pending.Add(-int64(len(b.drained)))
+	slices.SortFunc(b.drained, func(x, y bufferedRead[K]) int { return cmp.Compare(x.seq, y.seq) })
+	return b.drained
+}
+
+// lock takes the write lock and applies the reads buffered so far. Every
+// method that changes the cache takes the write lock this way, and
+// releases it with unlock.
+func (c *LRUCache[K, V]) lock() {
+	c.writer <- struct{}{}
+	c.mu.Lock()
+	c.locked()
+}
+
+// locked brings the cache up to date once the write lock is taken: it
+// applies the buffered reads and carries out a scheduled reset that is due.
+func (c *LRUCache[K, V]) locked() {
+	if c.reads.pending.Load() > 0 {
+		c.applyReads()
+	}
+	c.resetIfDue()
+}
+
+// rlock takes the read lock for a method that only looks, first applying
+// any buffered reads so that what it sees reflects them. Reads buffered
+// after that are not reflected, as they could not be had the Get that made
+// them waited for the write lock.
+func (c *LRUCache[K, V]) rlock() {
+	if c.reads.pending.Load() > 0 {
+		c.lock()
+		c.unlock()
+	}
+	c.mu.RLock()
+	if c.resetDue() {
+		c.mu.RUnlock()
+		c.lock()
+		c.unlock()
+		c.mu.RLock()
+	}
+}
+
+// applyReads plays back the buffered reads as get would have: counting the
+// access for the admission filter and, for a hit, promoting the entry and
+// updating its diagnostics. The hit and miss counters were updated at the
+// time.
+func (c *LRUCache[K, V]) applyReads() {
+	for _, r := range c.reads.take() {
+		c.recordAccess(r.key)
+		if !r.hit {
+			continue
+		}
+		// Writes drain the buffer before they start, so the entry is the
+		// one that was read, unless it has been removed since.
+		node, ok := c.cache[r.key]
+		if !ok {
+			continue
+		}
+		node.hits++
+		c.used(node, r.at)
+		c.access(node)
+	}
+}
+
+// getShared serves Get under the read lock when it can, reporting through
+// done whether it did. A hit on a live entry and a miss are both buffered
+// for applyReads; a miss only when the admission filter needs to count it.
+func (c *LRUCache[K, V]) getShared(key K) (value V, ok, done bool) {
+	c.mu.RLock()
+	node, found := c.cache[key]
+	now := c.now()
+	if c.refresher != nil || (found && node.expired(now)) || (!found && c.l2 != nil) || c.resetDue() {
+		c.mu.RUnlock()
+		return value, false, false
+	}
+	if p := c.profile.Load(); p != nil {
+		p.get(key, found)
+	}
+	c.accessed(key, found)
+	if found {
+		value = node.value
+		c.hit()
+	} else {
+		c.miss()
+	}
+	full := false
+	if !c.readOnly && (found || c.sketch != nil) {
+		full = c.reads.add(key, now, found)
+	}
+	c.mu.RUnlock()
+	if full {
+		c.lock()
+		c.unlock()
+	}
+	return value, found, true
+}
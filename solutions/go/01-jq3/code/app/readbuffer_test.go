@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+)
+
+func TestBufferedReadsPromoteBeforeWrites(t *testing.T) {
+	c := NewLRUCache[string, int](3)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Put("c", 3)
+	c.Get("a") // buffered, not yet applied
+	if c.reads.pending.Load() != 1 {
+		t.Fatalf("%d reads pending, want the Get buffered", c.reads.pending.Load())
+	}
+	c.Put("d", 4)
+	if c.Contains("b") || !c.Contains("a") {
+		t.Errorf("keys %v: the write did not apply the buffered read first", c.Keys())
+	}
+	if st := c.Stats(); st.Hits != 1 || st.Evictions != 1 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestFullStripeIsDrained(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	c.Put("a", 1)
+	for range readStripes * readStripeSize * 4 {
+		c.Get("a")
+		if n := c.reads.pending.Load(); n >= readStripes*readStripeSize {
+			t.Fatalf("%d reads pending, over the buffer's size", n)
+		}
+	}
+	if info, _ := c.EntryInfo("a"); info.Hits != readStripes*readStripeSize*4 {
+		t.Errorf("hits recorded on the entry: %d", info.Hits)
+	}
+}
+
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	const capacity = 64
+	c := NewLRUCache[string, int](capacity)
+	keys := numbered("k", 256)
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(g), 1))
+			for i := range 5000 {
+				key := keys[rng.IntN(len(keys))]
+				switch {
+				case rng.IntN(10) == 0:
+					c.Put(key, i)
+				case rng.IntN(50) == 0:
+					c.Remove(key)
+				default:
+					c.Get(key)
+				}
+				if n := c.Size(); n > capacity {
+					t.Errorf("size %d over capacity", n)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	st := c.Stats()
+	if st.Hits+st.Misses == 0 || st.Size > capacity {
+		t.Errorf("stats %+v", st)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+// BenchmarkReadHeavy compares Get under the read lock, with promotions
+// buffered, against taking the write lock for every Get as the cache did
+// before, at 90% and 99% reads. The shared lock only pays off when readers
+// run in parallel, so the gain needs several cores to show.
+func BenchmarkReadHeavy(b *testing.B) {
+	const capacity = 1 << 12
+	keys := numbered("k", capacity)
+	for _, readPct := range []int{90, 99} {
+		for _, shared := range []bool{true, false} {
+			name := fmt.Sprintf("reads=%d/buffered=%v", readPct, shared)
+			b.Run(name, func(b *testing.B) {
+				c := NewLRUCache[string, int](capacity)
+				for i, key := range keys {
+					c.Put(key, i)
+				}
+				get := func(key string) {
+					if shared {
+						c.Get(key)
+						return
+					}
+					c.lock()
+					c.fetch(key)
+					c.unlock()
+				}
+				b.SetParallelism(4)
+				b.RunParallel(func(pb *testing.PB) {
+					rng := rand.New(rand.NewPCG(rand.Uint64(), 0))
+					for pb.Next() {
+						key := keys[rng.IntN(len(keys))]
+						if rng.IntN(100) < readPct {
+							get(key)
+						} else {
+							c.Put(key, 0)
+						}
+					}
+				})
+			})
+		}
+	}
+}
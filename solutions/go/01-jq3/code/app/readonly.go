@@ -0,0 +1,74 @@
+package main
+
+import "bufio"
+
+// In read-only mode reads leave the cache exactly as they found it: a Get
+// neither promotes the entry nor marks it used, an expired entry is left in
+// place though reported missing, a miss loaded from the backing store is
+// returned without being cached and nothing is queued for refreshing. The
+// janitor stops sweeping and refreshes in flight are dropped. Only the hit
+// and miss counters move. Writes are not refused by the cache itself but by
+// the session, which keeps every cache in the mode together.
+
+// SetReadOnly turns read-only mode on or off. Reads buffered before it is
+// turned on are applied first.
+func (c *LRUCache[K, V]) SetReadOnly(on bool) {
+	c.lock()
+	defer c.unlock()
+
+	c.readOnly = on
+	if c.l2 != nil {
+		c.l2.SetReadOnly(on)
+	}
+}
+
+// peekCounted is fetch in read-only mode: it looks key up without changing
+// anything but the hit and miss counters.
+func (c *LRUCache[K, V]) peekCounted(key K) (V, error) {
+	node, err := c.find(key)
+	if err == ErrNotFound && c.l2 != nil {
+		if value, ok := c.l2.Peek(key); ok {
+			c.accessed(key, true)
+			c.hit()
+			return value, nil
+		}
+	}
+	c.accessed(key, err == nil)
+	if err != nil {
+		c.miss()
+		var zero V
+		return zero, err
+	}
+	c.hit()
+	return node.value, nil
+}
+
+// setReadOnly puts the session and every cache in or out of read-only mode.
+func (s *session) setReadOnly(on bool) {
+	s.readOnly.Store(on)
+	for _, cache := range s.caches {
+		cache.SetReadOnly(on)
+	}
+}
+
+// cmdReadonly turns read-only mode on or off, or reports it.
+func (s *session) cmdReadonly(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) == 1 {
+		if s.readOnly.Load() {
+			out.Value("ON")
+		} else {
+			out.Value("OFF")
+		}
+		return
+	}
+	switch parts[1] {
+	case "ON":
+		s.setReadOnly(true)
+	case "OFF":
+		s.setReadOnly(false)
+	default:
+		out.Errorf(CodeUnknownCommand, "Unknown READONLY subcommand: %s", parts[1])
+		return
+	}
+	out.OK()
+}
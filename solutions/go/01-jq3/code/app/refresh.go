@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// refreshWorkers is the number of goroutines running refreshes for one
+// cache, and refreshQueue the number of refreshes that may wait for one.
+// A Get that finds the queue full skips the refresh; a later Get retries.
+const (
+	refreshWorkers = 4
+	refreshQueue   = 64
+)
+
+// ErrInvalidThreshold is returned by StartRefresh for a threshold outside
+// (0, 1).
+var ErrInvalidThreshold = errors.New("refresh threshold must be between 0 and 1")
+
+// RefreshFunc loads a fresh value for key.
+type RefreshFunc[K comparable, V any] func(key K) (V, error)
+
+// refresher runs the refreshes scheduled by Get on a fixed pool of workers.
+// inflight holds the keys queued or being refreshed and is guarded by the
+// cache's lock; closing queue stops the workers.
+type refresher[K comparable, V any] struct {
+	fn        RefreshFunc[K, V]
+	threshold float64
+	queue     chan K
+	inflight  map[K]struct{}
+	done      sync.WaitGroup
+}
+
+// StartRefresh turns on refresh-ahead, replacing any refresher already
+// running. When Get hits an entry with less than threshold of its TTL
+// left, the key is refreshed in the background: fn is called for it, and
+// on success the new value replaces the old one and the TTL starts over.
+// The old value is served until then. A key has at most one refresh in
+// flight; if fn fails the entry is left as it is and a later Get tries
+// again. Entries without a TTL are never refreshed. Failures are logged,
+// and a panic in fn counts as a failure once it has been logged and
+// counted in Stats.Restarts.
+func (c *LRUCache[K, V]) StartRefresh(fn RefreshFunc[K, V], threshold float64) error {
+	if !(threshold > 0 && threshold < 1) {
+		return ErrInvalidThreshold
+	}
+	c.StopRefresh()
+	r := &refresher[K, V]{
+		fn:        fn,
+		threshold: threshold,
+		queue:     make(chan K, refreshQueue),
+		inflight:  make(map[K]struct{}),
+	}
+	for range refreshWorkers {
+		r.done.Add(1)
+		go func() {
+			defer r.done.Done()
+			for key := range r.queue {
+				value, err := c.callRefresh(r, key)
+				c.finishRefresh(r, key, value, err)
+			}
+		}()
+	}
+	c.lock()
+	c.refresher = r
+	c.unlock()
+	return nil
+}
+
+// StopRefresh turns refresh-ahead off, if it is on, and waits for the
+// refreshes already scheduled to finish.
+func (c *LRUCache[K, V]) StopRefresh() {
+	c.lock()
+	r := c.refresher
+	c.refresher = nil
+	c.unlock()
+	if r != nil {
+		close(r.queue)
+		r.done.Wait()
+	}
+}
+
+// scheduleRefresh queues a refresh of node if it is close enough to
+// expiring and not already being refreshed. It must be called with the
+// write lock held.
+func (c *LRUCache[K, V]) scheduleRefresh(node *Node[K, V]) {
+	r := c.refresher
+	if r == nil || node.expireAt.IsZero() || node.ttl <= 0 {
+		return
+	}
+	if left := node.expireAt.Sub(c.now()); float64(left) >= r.threshold*float64(node.ttl) {
+		return
+	}
+	if _, ok := r.inflight[node.key]; ok {
+		return
+	}
+	select {
+	case r.queue <- node.key:
+		r.inflight[node.key] = struct{}{}
+	default:
+	}
+}
+
+// callRefresh calls r.fn for key, turning a panic into an error.
+func (c *LRUCache[K, V]) callRefresh(r *refresher[K, V], key K) (value V, err error) {
+	defer c.rescue("refresh", &err)
+	value, err = r.fn(key)
+	if err != nil {
+		logs.log(levelWarn, "refresh failed", "key", key, "error", err)
+	}
+	return value, err
+}
+
+// finishRefresh stores the outcome of refreshing key. A key removed or
+// expired in the meantime is not brought back.
+func (c *LRUCache[K, V]) finishRefresh(r *refresher[K, V], key K, value V, err error) {
+	c.lock()
+	defer c.unlock()
+
+	delete(r.inflight, key)
+	if c.readOnly {
+		return
+	}
+	if err != nil {
+		c.counters.refreshFailures.Add(1)
+		return
+	}
+	node, ok := c.lookup(key)
+	if !ok {
+		return
+	}
+	if err := c.update(node, value); err != nil {
+		c.counters.refreshFailures.Add(1)
+		return
+	}
+	if !node.expireAt.IsZero() {
+		node.expireAt = c.now().Add(c.jitter(node.ttl))
+		c.share(node)
+	}
+	c.counters.refreshes.Add(1)
+}
@@ -0,0 +1,150 @@
+package main
+
+// RemovalReason says why an entry left the cache.
+type RemovalReason int
+
+const (
+	// RemovalEvicted is an entry dropped to make room, by a write or by
+	// Resize.
+	RemovalEvicted RemovalReason = iota
+	// RemovalExpired is an entry whose TTL ran out, found either by an
+	// operation touching it or by the janitor.
+	RemovalExpired
+	// RemovalDeleted is an explicit removal: Remove, GetAndDelete,
+	// DeletePrefix, RemoveOldest or Clear.
+	RemovalDeleted
+	// RemovalReplaced is a value overwritten by a write to its key; the
+	// handler receives the old value.
+	RemovalReplaced
+	// RemovalIdle is an entry left unused for the cache's max idle time,
+	// found the same ways as an expired one. An entry past both its TTL
+	// and its idle time is reported for whichever it passed first.
+	RemovalIdle
+	// RemovalMemoryPressure is an entry shed by the memory guard because
+	// the process's heap grew past its limit; see StartMemoryGuard.
+	RemovalMemoryPressure
+	// RemovalScheduledReset is an entry cleared by the reset scheduled
+	// with ResetAt.
+	RemovalScheduledReset
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case RemovalEvicted:
+		return "evicted"
+	case RemovalExpired:
+		return "expired"
+	case RemovalDeleted:
+		return "deleted"
+	case RemovalReplaced:
+		return "replaced"
+	case RemovalIdle:
+		return "idle"
+	case RemovalMemoryPressure:
+		return "memory_pressure"
+	case RemovalScheduledReset:
+		return "scheduled_reset"
+	}
+	return "unknown"
+}
+
+// removal is a removal waiting to be reported to the handler.
+type removal[K comparable, V any] struct {
+	key    K
+	value  V
+	reason RemovalReason
+}
+
+// SetRemovalHandler registers fn to be called once for every entry or value
+// that leaves the cache, with the reason it left. Restore replaces the
+// contents wholesale and reports nothing. fn runs after the operation has
+// released the lock, so it may safely call back into the cache.
+func (c *LRUCache[K, V]) SetRemovalHandler(fn func(key K, value V, reason RemovalReason)) {
+	c.lock()
+	defer c.unlock()
+	c.onRemove = fn
+}
+
+// SetEvictionHandler registers fn to be called whenever an entry is evicted
+// due to capacity pressure. It is not called for explicit removals, expiry or
+// value updates. It replaces any handler set with SetRemovalHandler.
+func (c *LRUCache[K, V]) SetEvictionHandler(fn func(key K, value V)) {
+	if fn == nil {
+		c.SetRemovalHandler(nil)
+		return
+	}
+	c.SetRemovalHandler(func(key K, value V, reason RemovalReason) {
+		if reason == RemovalEvicted {
+			fn(key, value)
+		}
+	})
+}
+
+// record counts a removal and queues it for the handler, if there is one,
+// or during a batch write holds it back for the batch to report. The caller
+// must hold the write lock and release it with unlock.
+func (c *LRUCache[K, V]) record(key K, value V, reason RemovalReason) {
+	if reason == RemovalDeleted {
+		c.wrote(key, c.nextSeq(), true)
+	}
+	if c.batch != nil {
+		c.batch.hold(removal[K, V]{key, value, reason})
+		return
+	}
+	switch reason {
+	case RemovalEvicted:
+		c.counters.evictions.Add(1)
+		if w := c.window.Load(); w != nil {
+			w.evicted()
+		}
+	case RemovalExpired:
+		c.counters.expirations.Add(1)
+	case RemovalDeleted:
+		c.counters.deletions.Add(1)
+	case RemovalReplaced:
+		c.counters.replacements.Add(1)
+	case RemovalIdle:
+		c.counters.idled.Add(1)
+	case RemovalMemoryPressure:
+		c.counters.memoryShed.Add(1)
+	case RemovalScheduledReset:
+		c.counters.scheduledReset.Add(1)
+	}
+	// Deleted, expired, idle and reset keys would be gone from a cache of
+	// any size, so they leave the profiler's ghosts too; evicted ones are
+	// what the ghosts are there to remember.
+	if reason == RemovalExpired || reason == RemovalIdle || reason == RemovalDeleted || reason == RemovalScheduledReset {
+		if p := c.profile.Load(); p != nil {
+			p.remove(key)
+		}
+	}
+	if reason == RemovalDeleted {
+		c.markDeleted(key)
+	}
+	if c.onRemove != nil {
+		c.pending = append(c.pending, removal[K, V]{key, value, reason})
+	}
+}
+
+// remove unlinks node, records why and releases it for reuse, so the
+// caller must not touch node afterwards.
+func (c *LRUCache[K, V]) remove(node *Node[K, V], reason RemovalReason) {
+	c.unlink(node)
+	c.record(node.key, node.value, reason)
+	c.release(node)
+}
+
+// unlock releases the write lock and then reports the removals recorded
+// while it was held. Every method that takes the write lock with lock
+// releases it this way.
+func (c *LRUCache[K, V]) unlock() {
+	c.autotune()
+	c.compactStep()
+	pending, fn := c.pending, c.onRemove
+	c.pending = nil
+	c.mu.Unlock()
+	<-c.writer
+	for _, r := range pending {
+		fn(r.key, r.value, r.reason)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/systemquest/lru-cache-starter-go/app/internal/fakeclock"
+)
+
+// removalLog records every removal reported to a cache's handler, as
+// "key=value:reason".
+type removalLog struct {
+	got []string
+}
+
+func (l *removalLog) record(key string, value int, reason RemovalReason) {
+	l.got = append(l.got, fmt.Sprintf("%s=%d:%s", key, value, reason))
+}
+
+func TestEachRemovalPathReportsOnce(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		run  func(c *LRUCache[string, int], clock *fakeclock.Clock)
+		want []string
+	}{
+		{"put over capacity", func(c *LRUCache[string, int], _ *fakeclock.Clock) {
+			c.Put("d", 4)
+		}, []string{"a=1:evicted"}},
+		{"resize", func(c *LRUCache[string, int], _ *fakeclock.Clock) {
+			c.Resize(1)
+		}, []string{"a=1:evicted", "b=2:evicted"}},
+		{"overwrite", func(c *LRUCache[string, int], _ *fakeclock.Clock) {
+			c.Put("b", 20)
+		}, []string{"b=2:replaced"}},
+		{"remove", func(c *LRUCache[string, int], _ *fakeclock.Clock) {
+			c.Remove("b")
+			c.Remove("b")
+		}, []string{"b=2:deleted"}},
+		{"get and delete", func(c *LRUCache[string, int], _ *fakeclock.Clock) {
+			c.GetAndDelete("c")
+		}, []string{"c=3:deleted"}},
+		{"remove oldest", func(c *LRUCache[string, int], _ *fakeclock.Clock) {
+			c.RemoveOldest()
+		}, []string{"a=1:deleted"}},
+		{"delete prefix", func(c *LRUCache[string, int], _ *fakeclock.Clock) {
+			c.Put("x", 9)
+			c.DeletePrefix("x")
+		}, []string{"a=1:evicted", "x=9:deleted"}},
+		{"clear", func(c *LRUCache[string, int], _ *fakeclock.Clock) {
+			c.Clear()
+		}, []string{"a=1:deleted", "b=2:deleted", "c=3:deleted"}},
+		{"rename over a key", func(c *LRUCache[string, int], _ *fakeclock.Clock) {
+			c.Rename("a", "b")
+		}, []string{"b=2:replaced"}},
+		{"expired on get", func(c *LRUCache[string, int], clock *fakeclock.Clock) {
+			c.PutWithTTL("a", 10, time.Second)
+			clock.Advance(time.Second)
+			c.Get("a")
+			c.Get("a")
+		}, []string{"a=10:expired", "a=1:replaced"}},
+		{"expired by sweep", func(c *LRUCache[string, int], clock *fakeclock.Clock) {
+			c.Expire("b", time.Second)
+			clock.Advance(time.Second)
+			c.sweep()
+		}, []string{"b=2:expired"}},
+		{"idle", func(c *LRUCache[string, int], clock *fakeclock.Clock) {
+			c.SetMaxIdle(time.Minute)
+			clock.Advance(time.Minute)
+			c.Get("c")
+		}, []string{"c=3:idle"}},
+	} {
+		clock := fakeclock.New(time.Unix(0, 0))
+		c := NewLRUCache[string, int](3)
+		c.SetClock(clock)
+		c.Put("a", 1)
+		c.Put("b", 2)
+		c.Put("c", 3)
+		log := &removalLog{}
+		c.SetRemovalHandler(log.record)
+		tc.run(c, clock)
+		slices.Sort(log.got)
+		if !slices.Equal(log.got, tc.want) {
+			t.Errorf("%s: removals %v, want %v", tc.name, log.got, tc.want)
+		}
+		if err := c.DebugCheck(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestRemovalCountsInStats(t *testing.T) {
+	clock := fakeclock.New(time.Unix(0, 0))
+	c := NewLRUCache[string, int](2)
+	c.SetClock(clock)
+	c.Put("a", 1)
+	c.Put("a", 2)
+	c.PutWithTTL("b", 1, time.Second)
+	c.Put("c", 1)
+	clock.Advance(time.Second)
+	c.Get("b")
+	c.Remove("c")
+	st := c.Stats()
+	if st.Evictions != 1 || st.Replaced != 1 || st.Deleted != 1 || st.Expirations != 1 {
+		t.Errorf("stats %+v", st)
+	}
+	if s := st.String(); !strings.Contains(s, "evictions=1") || !strings.Contains(s, "deleted=1 replaced=1") {
+		t.Errorf("STATS line %q", s)
+	}
+}
+
+func TestIdleOrTTLWhicheverTripsFirst(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		ttl      time.Duration
+		want     RemovalReason
+		statIdle int
+	}{
+		{"ttl first", 30 * time.Second, RemovalExpired, 0},
+		{"idle first", 90 * time.Second, RemovalIdle, 1},
+		// On the same instant the TTL, being the entry's own, wins.
+		{"both at once", time.Minute, RemovalExpired, 0},
+	} {
+		for _, how := range []string{"get", "sweep"} {
+			clock := fakeclock.New(time.Unix(0, 0))
+			c := NewLRUCache[string, int](3)
+			c.SetClock(clock)
+			c.SetMaxIdle(time.Minute)
+			log := &removalLog{}
+			c.SetRemovalHandler(log.record)
+			c.PutWithTTL("a", 1, tc.ttl)
+			// Well past both limits, so only the order they tripped in
+			// decides the reason.
+			clock.Advance(2 * time.Minute)
+			if how == "get" {
+				c.Get("a")
+			} else {
+				c.sweep()
+			}
+			if want := []string{"a=1:" + tc.want.String()}; !slices.Equal(log.got, want) {
+				t.Errorf("%s, by %s: removals %v, want %v", tc.name, how, log.got, want)
+			}
+			if st := c.Stats(); st.Idle != tc.statIdle || st.Expirations != 1-tc.statIdle {
+				t.Errorf("%s, by %s: idle=%d expirations=%d", tc.name, how, st.Idle, st.Expirations)
+			}
+		}
+	}
+}
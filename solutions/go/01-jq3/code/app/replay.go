@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxTraceLine bounds the length of a trace line so that a file with no
+// newlines cannot make replay buffer it all; longer lines count as invalid.
+const maxTraceLine = 64 << 10
+
+// A trace is a text file with one access per line: either a bare key or a
+// key prefixed with GET or PUT. A bare key is treated as a GET, and a GET or
+// PUT with no key is invalid. Blank lines and comments are ignored.
+type traceOp struct {
+	put bool
+	key string
+}
+
+func parseTraceLine(line string) (op traceOp, ok bool) {
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 1 && fields[0] != "GET" && fields[0] != "PUT":
+		return traceOp{key: fields[0]}, true
+	case len(fields) == 2 && fields[0] == "GET":
+		return traceOp{key: fields[1]}, true
+	case len(fields) == 2 && fields[0] == "PUT":
+		return traceOp{put: true, key: fields[1]}, true
+	}
+	return traceOp{}, false
+}
+
+// readTrace streams the trace in r, calling fn for each access in order. It
+// holds only one line in memory at a time and returns the number of lines
+// that could not be parsed.
+func readTrace(r io.Reader, fn func(op traceOp)) (invalid int, err error) {
+	br := bufio.NewReader(r)
+	for {
+		line, tooLong, err := readLine(br, maxTraceLine)
+		switch {
+		case tooLong:
+			invalid++
+		case strings.TrimSpace(line) != "" && !isComment(line):
+			if op, ok := parseTraceLine(line); ok {
+				fn(op)
+			} else {
+				invalid++
+			}
+		}
+		if err == io.EOF {
+			return invalid, nil
+		}
+		if err != nil {
+			return invalid, err
+		}
+	}
+}
+
+// traceCounts accumulates the outcome of driving one cache with a trace.
+type traceCounts struct {
+	Ops       int `json:"ops"`
+	Hits      int `json:"hits"`
+	Misses    int `json:"misses"`
+	Evictions int `json:"evictions"`
+}
+
+// apply performs op against cache the way a demand-filled cache would see
+// it: a GET that misses inserts the key, and a PUT always writes it. Traces
+// carry no values, so entries are stored with empty ones.
+func (t *traceCounts) apply(cache *LRUCache[string, Value], op traceOp) {
+	t.Ops++
+	if op.put {
+		cache.Put(op.key, Value{})
+		return
+	}
+	if _, ok := cache.Get(op.key); ok {
+		t.Hits++
+		return
+	}
+	t.Misses++
+	cache.Put(op.key, Value{})
+}
+
+func (t traceCounts) hitRatio() float64 {
+	if t.Hits+t.Misses == 0 {
+		return 0
+	}
+	return float64(t.Hits) / float64(t.Hits+t.Misses)
+}
+
+// replayResult summarises one run of replayTrace.
+type replayResult struct {
+	traceCounts
+	HitRatio float64 `json:"hit_ratio"`
+	Invalid  int     `json:"invalid"`
+	Elapsed  float64 `json:"elapsed_seconds"`
+}
+
+func (r replayResult) String() string {
+	return fmt.Sprintf("ops=%d hits=%d misses=%d evictions=%d hit_ratio=%.4f invalid=%d elapsed=%.3fs",
+		r.Ops, r.Hits, r.Misses, r.Evictions, r.HitRatio, r.Invalid, r.Elapsed)
+}
+
+// replayTrace streams the trace file at path through cache.
+func replayTrace(cache *LRUCache[string, Value], path string) (replayResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return replayResult{}, err
+	}
+	defer f.Close()
+
+	var r replayResult
+	before := cache.Stats().Evictions
+	start := time.Now()
+	r.Invalid, err = readTrace(f, func(op traceOp) { r.apply(cache, op) })
+	r.Elapsed = time.Since(start).Seconds()
+	r.Evictions = cache.Stats().Evictions - before
+	r.HitRatio = r.hitRatio()
+	return r, err
+}
+
+// simulateResult is one policy's outcome in a simulation.
+type simulateResult struct {
+	Policy string `json:"policy"`
+	traceCounts
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+func (r simulateResult) String() string {
+	return fmt.Sprintf("%s hits=%d misses=%d evictions=%d hit_ratio=%.4f",
+		r.Policy, r.Hits, r.Misses, r.Evictions, r.HitRatio)
+}
+
+// simulateTrace reads the trace file at path once and feeds every access
+// to a fresh cache of the given capacity for each named policy, so the
+// policies see identical traffic. All names are validated before the file
+// is opened.
+func simulateTrace(path string, capacity int, policies []string) ([]simulateResult, int, error) {
+	caches := make([]*LRUCache[string, Value], len(policies))
+	for i, name := range policies {
+		policy, err := newPolicy[string, Value](name, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		caches[i] = NewLRUCacheWithPolicy(capacity, policy)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	counts := make([]traceCounts, len(caches))
+	invalid, err := readTrace(f, func(op traceOp) {
+		for i, cache := range caches {
+			counts[i].apply(cache, op)
+		}
+	})
+	if err != nil {
+		return nil, invalid, err
+	}
+	results := make([]simulateResult, len(caches))
+	for i, cache := range caches {
+		counts[i].Evictions = cache.Stats().Evictions
+		results[i] = simulateResult{Policy: cache.policy.Name(), traceCounts: counts[i], HitRatio: counts[i].hitRatio()}
+	}
+	return results, invalid, nil
+}
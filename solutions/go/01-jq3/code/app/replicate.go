@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A replica follows the default cache of another server, its primary, into
+// its own default cache. It sends SYNC over the line protocol, replaces its
+// contents with the snapshot that starts the reply and then applies each
+// change that follows, as the AOF would replay it. While a replica runs the
+// session refuses writes with ERR_READONLY, so the cache only ever holds
+// what the primary sent. A lost link is retried with exponential backoff,
+// each attempt starting again from a fresh snapshot.
+//
+// SYNC's reply is "SNAPSHOT <n>", n lines that rebuild the cache, oldest
+// entry first, and then the changefeed, each change prefixed with the time
+// it was published in Unix nanoseconds. A bare time is a heartbeat, sent
+// every replicaHeartbeat. The lag a replica reports is how long ago the
+// last line it applied was stamped, so it is only as accurate as the two
+// clocks agree.
+//
+// What a replica applies is not written to its own AOF or changefeed.
+
+const (
+	replicaHeartbeat = time.Second
+	// replicaTimeout is how long a replica waits to connect or for a line
+	// before it gives up on the link.
+	replicaTimeout    = 3 * replicaHeartbeat
+	replicaMinBackoff = 100 * time.Millisecond
+	replicaMaxBackoff = 10 * time.Second
+)
+
+// Link states, as reported by link_status.
+const (
+	linkConnecting = "connecting"
+	linkSyncing    = "syncing"
+	linkUp         = "up"
+	linkDown       = "down"
+)
+
+type replica struct {
+	addr     string
+	password string // sent with AUTH if not ""
+	cache    *LRUCache[string, Value]
+	dial     func(addr string) (net.Conn, error)
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu     sync.Mutex
+	status string
+	lag    time.Duration
+}
+
+func startReplica(cache *LRUCache[string, Value], addr, password string) *replica {
+	r := newReplica(cache, addr, password)
+	go r.run()
+	return r
+}
+
+// newReplica returns a replica that has not yet connected; run starts it.
+// It dials the primary over TCP unless dial is replaced first.
+func newReplica(cache *LRUCache[string, Value], addr, password string) *replica {
+	return &replica{
+		addr:     addr,
+		password: password,
+		cache:    cache,
+		dial: func(addr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, replicaTimeout)
+		},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		status: linkConnecting,
+	}
+}
+
+// Stop closes the link and waits for the replica to let go of the cache.
+func (r *replica) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+// state returns the link status and the replication lag.
+func (r *replica) state() (string, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status, r.lag
+}
+
+func (r *replica) setStatus(status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *replica) setLag(lag time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lag = max(lag, 0)
+}
+
+func (r *replica) run() {
+	defer close(r.done)
+	backoff := replicaMinBackoff
+	for {
+		r.setStatus(linkConnecting)
+		synced, err := r.followSafely()
+		r.setStatus(linkDown)
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+		logs.log(levelWarn, "replication link down", "primary", r.addr, "error", err)
+		if synced {
+			backoff = replicaMinBackoff
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, replicaMaxBackoff)
+	}
+}
+
+// followSafely is follow, turning a panic into an error so that the
+// replica reconnects.
+func (r *replica) followSafely() (synced bool, err error) {
+	defer r.cache.rescue("replicate", &err)
+	return r.follow()
+}
+
+// follow makes one connection to the primary and applies what it sends
+// until the link fails or the replica is stopped. It reports whether the
+// snapshot was applied, to reset the backoff.
+func (r *replica) follow() (synced bool, err error) {
+	conn, err := r.dial(r.addr)
+	if err != nil {
+		return false, err
+	}
+	// Closing the connection is what interrupts a read when Stop is called.
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		select {
+		case <-r.stop:
+		case <-quit:
+		}
+		conn.Close()
+	}()
+
+	in := bufio.NewReaderSize(conn, streamBuffer)
+	read := func() (string, error) {
+		conn.SetReadDeadline(time.Now().Add(replicaTimeout))
+		line, err := in.ReadString('\n')
+		return strings.TrimSuffix(line, "\n"), err
+	}
+	if r.password != "" {
+		if _, err := io.WriteString(conn, "AUTH "+quoteToken(r.password)+"\n"); err != nil {
+			return false, err
+		}
+		line, err := read()
+		if err != nil {
+			return false, err
+		}
+		if line != "OK" {
+			return false, fmt.Errorf("AUTH refused: %s", line)
+		}
+	}
+	if _, err := io.WriteString(conn, "SYNC\n"); err != nil {
+		return false, err
+	}
+	line, err := read()
+	if err != nil {
+		return false, err
+	}
+	count, ok := strings.CutPrefix(line, "SNAPSHOT ")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 0 {
+		return false, fmt.Errorf("SYNC refused: %s", line)
+	}
+
+	r.setStatus(linkSyncing)
+	r.cache.Clear()
+	for range n {
+		line, err := read()
+		if err != nil {
+			return false, err
+		}
+		r.apply(line)
+	}
+	r.setStatus(linkUp)
+	for {
+		line, err := read()
+		if err != nil {
+			return true, err
+		}
+		stamp, cmd, _ := strings.Cut(line, " ")
+		nanos, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil {
+			return true, fmt.Errorf("unexpected line: %s", line)
+		}
+		if cmd != "" {
+			r.apply(cmd)
+		}
+		r.setLag(time.Since(time.Unix(0, nanos)))
+	}
+}
+
+// apply applies one line from the primary. A line the cache rejects, such
+// as a value over a lower limit than the primary's, is reported and
+// skipped.
+func (r *replica) apply(line string) {
+	parts, err := tokenize(line)
+	if err == nil && len(parts) == 0 {
+		return
+	}
+	if err == nil {
+		err = applyLogged(r.cache, parts)
+	}
+	if err != nil {
+		logs.log(levelWarn, "replicated command skipped", "primary", r.addr, "error", err)
+	}
+}
+
+// replicate makes the default cache a replica of the server at addr, in
+// place of any it already follows.
+func (s *session) replicate(addr, password string) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+
+	if r := s.replica.Swap(nil); r != nil {
+		r.Stop()
+	}
+	s.replica.Store(startReplica(s.caches[defaultCacheName], addr, password))
+}
+
+// stopReplicating stops following the primary, if any. The cache keeps
+// what it holds and takes writes again.
+func (s *session) stopReplicating() {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+
+	if r := s.replica.Swap(nil); r != nil {
+		r.Stop()
+	}
+}
+
+// refusal returns why the session refuses writes, to follow "is not
+// allowed", or "" if it takes them.
+func (s *session) refusal() string {
+	switch {
+	case s.readOnly.Load():
+		return "in read-only mode"
+	case s.replica.Load() != nil:
+		return "on a replica"
+	}
+	return ""
+}
+
+// linkStats adds the state of the link to stats of the cache a replica
+// follows into.
+func (s *session) linkStats(cache *LRUCache[string, Value], stats *Stats) {
+	if r := s.replica.Load(); r != nil && r.cache == cache {
+		status, lag := r.state()
+		stats.LinkStatus = status
+		stats.ReplicationLag = lag.Seconds()
+	}
+}
+
+// cmdReplicate starts, stops or reports replication.
+func (s *session) cmdReplicate(in *bufio.Reader, out *reply, line string, parts []string) {
+	if len(parts) == 1 {
+		r := s.replica.Load()
+		if r == nil {
+			out.Value("OFF")
+			return
+		}
+		status, lag := r.state()
+		out.Result(fmt.Sprintf("%s %s", r.addr, status),
+			map[string]any{"primary": r.addr, "link_status": status, "replication_lag": lag.Seconds()})
+		return
+	}
+	if s.readOnly.Load() {
+		out.Error(CodeReadOnly, "REPLICATE is not allowed in read-only mode")
+		return
+	}
+	if parts[1] == "OFF" {
+		if len(parts) > 2 {
+			out.Error(CodeArity, "REPLICATE OFF takes no arguments")
+			return
+		}
+		s.stopReplicating()
+		out.OK()
+		return
+	}
+	if _, _, err := net.SplitHostPort(parts[1]); err != nil {
+		out.Errorf(CodeInvalid, "Invalid address: %s", parts[1])
+		return
+	}
+	if s.caches[defaultCacheName] == nil {
+		out.Error(CodeNotInitialized, "Cache not initialized")
+		return
+	}
+	password := ""
+	if len(parts) > 2 {
+		password = parts[2]
+	}
+	s.replicate(parts[1], password)
+	out.OK()
+}
+
+// cmdSync sends a snapshot of the default cache and then streams its
+// changes to a replica. The snapshot is taken and the feed subscribed to
+// with writes held off, so each change is either in the snapshot or
+// streamed, never both.
+func (s *session) cmdSync(in *bufio.Reader, out *reply, line string, parts []string) {
+	cache := s.caches[defaultCacheName]
+	if cache == nil {
+		out.Error(CodeNotInitialized, "Cache not initialized")
+		return
+	}
+	s.writing.Lock()
+	sub := s.feed.subscribe()
+	lines := rebuildLines(cache)
+	s.writing.Unlock()
+	defer s.feed.unsubscribe(sub)
+
+	fmt.Fprintf(out.w, "SNAPSHOT %d\n", len(lines))
+	for _, line := range lines {
+		fmt.Fprintln(out.w, line)
+	}
+	s.stream(in, out, sub, replicaHeartbeat, func(c change) {
+		if c.line == "" {
+			fmt.Fprintln(out.w, c.at.UnixNano())
+			return
+		}
+		fmt.Fprintf(out.w, "%d %s\n", c.at.UnixNano(), c.line)
+	})
+}
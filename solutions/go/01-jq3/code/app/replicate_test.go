@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// pipeServer serves a session's line protocol over in-process pipes, one
+// per dial, in place of a TCP listener.
+type pipeServer struct {
+	s *session
+
+	mu    sync.Mutex
+	conns []net.Conn // the server ends, in the order they were dialed
+}
+
+func (p *pipeServer) dial(string) (net.Conn, error) {
+	client, server := net.Pipe()
+	p.mu.Lock()
+	p.conns = append(p.conns, server)
+	p.mu.Unlock()
+	go func() {
+		p.s.serve(server, server, true)
+		server.Close()
+	}()
+	return client, nil
+}
+
+// hangUp closes every connection served so far.
+func (p *pipeServer) hangUp() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+func (p *pipeServer) dials() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// client connects a client to the server over a pipe of its own.
+func (p *pipeServer) client(t testing.TB) *client {
+	conn, _ := p.dial("")
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// follow makes replica's default cache follow primary over pipes.
+func follow(t testing.TB, replica *session, primary *pipeServer) *replica {
+	r := newReplica(replica.caches[defaultCacheName], "primary:6380", "")
+	r.dial = primary.dial
+	replica.replica.Store(r)
+	go r.run()
+	return r
+}
+
+// converged waits until the replica's default cache rebuilds to what the
+// primary's does.
+func converged(t testing.TB, replica, primary *session) {
+	t.Helper()
+	waitFor(t, func() bool {
+		return slices.Equal(rebuildLines(replica.cache), rebuildLines(primary.cache))
+	})
+}
+
+func TestReplicaConvergesAfterBurst(t *testing.T) {
+	primary := newServerSession(t, 500)
+	srv := &pipeServer{s: primary}
+	w := srv.client(t)
+	for i := range 50 {
+		w.do(fmt.Sprintf("PUT before%d %d", i, i))
+	}
+
+	replica := newServerSession(t, 500)
+	r := follow(t, replica, srv)
+	converged(t, replica, primary)
+	waitFor(t, func() bool { status, _ := r.state(); return status == linkUp })
+
+	for i := range 1000 {
+		key := fmt.Sprintf("k%d", i%300)
+		switch i % 5 {
+		case 0:
+			w.do("DELETE " + key)
+		case 1:
+			w.do("INCR " + key)
+		default:
+			w.do(fmt.Sprintf("PUT %s %d", key, i))
+		}
+	}
+	w.do("DELETE before0")
+	converged(t, replica, primary)
+	if err := replica.cache.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+	if got := replica.Execute("EXISTS before0"); got != "0" {
+		t.Errorf("EXISTS before0 on the replica = %q", got)
+	}
+	if got := replica.Execute("STATS"); !strings.Contains(got, " link_status=up") {
+		t.Errorf("replica STATS = %q", got)
+	}
+}
+
+func TestReplicaRefusesWrites(t *testing.T) {
+	primary := newServerSession(t, 10)
+	srv := &pipeServer{s: primary}
+	replica := newServerSession(t, 10)
+	follow(t, replica, srv)
+	if got := replica.Execute("PUT k v"); !strings.HasPrefix(got, "ERROR ERR_READONLY") {
+		t.Errorf("PUT on a replica = %q", got)
+	}
+	script(t, replica, "REPLICATE OFF", "OK", "REPLICATE", "OFF", "PUT k v", "OK")
+}
+
+func TestReplicaResyncsAfterLinkLoss(t *testing.T) {
+	primary := newServerSession(t, 100)
+	srv := &pipeServer{s: primary}
+	w := srv.client(t)
+	w.do("PUT a 1")
+
+	replica := newServerSession(t, 100)
+	r := follow(t, replica, srv)
+	converged(t, replica, primary)
+
+	srv.hangUp()
+	waitFor(t, func() bool { return srv.dials() >= 3 })
+	w = srv.client(t)
+	w.do("PUT b 2")
+	w.do("DELETE a")
+	converged(t, replica, primary)
+	waitFor(t, func() bool { status, _ := r.state(); return status == linkUp })
+	if err := replica.cache.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
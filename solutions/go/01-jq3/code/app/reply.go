@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// reply writes command responses in either the plain-text protocol or, in
+// JSON mode, as one JSON object per response.
+type reply struct {
+	w    io.Writer
+	json bool
+	// framedValues, set with MODE FRAMED, writes every value in text mode
+	// as RawValue does and every miss as MISS, so that no value can be
+	// mistaken for a status such as NULL or ERROR.
+	framedValues bool
+	// legacyErrors writes errors as "ERROR: <message>" with no code, the
+	// format from before error codes, for clients not yet updated.
+	legacyErrors bool
+	// protocol is the version of the protocol the stream speaks, 1 unless
+	// HELLO changed it; see protocolVersions. remote is set on a TCP
+	// connection.
+	protocol int
+	remote   bool
+	// locked is set on a connection that has yet to AUTH, and refuses it
+	// every other command.
+	locked bool
+	// tenant is the tenant set with TENANT, "" for the default pool.
+	tenant string
+	// timeAll, set with TIMEALL, times every command as TIME does, and
+	// elapsed is how long the last command took to run.
+	timeAll bool
+	elapsed time.Duration
+	// seqs, set with SEQ, appends the cache's last write number to the
+	// response of every mutating command that succeeds, and failed is set
+	// by Error to tell it which did not.
+	seqs   bool
+	failed bool
+	// shadow, set with SHADOW ON, checks the commands that need the cache
+	// against a reference model.
+	shadow *shadow
+	// macros are the aliases and macros defined with ALIAS and MACRO, and
+	// expanding is how many of them are running inside each other.
+	macros    map[string]*macro
+	expanding int
+	// errorCount counts the Error responses written. failFast, set on
+	// the stdin stream by --fail-fast, hangs up after the first.
+	errorCount int
+	failFast   bool
+	// hangUp is set by a command after which the connection must close.
+	hangUp bool
+}
+
+// jsonReply is the shape of every response in JSON mode. Status is one of
+// "ok", "hit", "miss" or "error". JSON strings must be valid UTF-8, so
+// invalid bytes in keys and values are written as U+FFFD; use text mode to
+// read such values back exactly.
+type jsonReply struct {
+	Status  string  `json:"status"`
+	Value   *string `json:"value,omitempty"`
+	Result  any     `json:"result,omitempty"`
+	Message string  `json:"message,omitempty"`
+	Code    string  `json:"code,omitempty"`
+}
+
+func (r *reply) writeJSON(v jsonReply) {
+	enc := json.NewEncoder(r.w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+// OK reports success with nothing to return.
+func (r *reply) OK() {
+	if r.json {
+		r.writeJSON(jsonReply{Status: "ok"})
+		return
+	}
+	fmt.Fprintln(r.w, "OK")
+}
+
+// OKWith reports success along with a summary, written as "OK <text>" in
+// text mode and as the result field in JSON mode.
+func (r *reply) OKWith(text string, result any) {
+	if r.json {
+		r.writeJSON(jsonReply{Status: "ok", Result: result})
+		return
+	}
+	fmt.Fprintln(r.w, "OK "+text)
+}
+
+// Null reports a missing key.
+func (r *reply) Null() {
+	if r.json {
+		r.writeJSON(jsonReply{Status: "miss"})
+		return
+	}
+	if r.framedValues {
+		fmt.Fprintln(r.w, "MISS")
+		return
+	}
+	fmt.Fprintln(r.w, "NULL")
+}
+
+// Value reports a cached value. In protocol 2 a value that would not read
+// back as one line is written as RawValue writes it.
+func (r *reply) Value(v string) {
+	if r.json {
+		r.writeJSON(jsonReply{Status: "hit", Value: &v})
+		return
+	}
+	r.textValue(v)
+}
+
+// framed reports whether text written in protocol 2 must be framed, as it
+// spans lines or its first line would read as a frame's header.
+func (r *reply) framed(text string) bool {
+	return r.protocol >= 2 && (strings.Contains(text, "\n") || strings.HasPrefix(text, "VALUE ") || strings.HasPrefix(text, "LINES "))
+}
+
+func (r *reply) textValue(v string) {
+	if r.framedValues || r.framed(v) {
+		fmt.Fprintf(r.w, "VALUE %d\n%s\n", len(v), v)
+		return
+	}
+	fmt.Fprintln(r.w, v)
+}
+
+// Values reports the values of several keys, nil for those missing,
+// written one to a line in text mode with NULL for a miss, or one frame
+// or MISS each in MODE FRAMED, and as the result field in JSON mode.
+func (r *reply) Values(values []*string) {
+	if r.framedValues && !r.json {
+		for _, v := range values {
+			if v == nil {
+				fmt.Fprintln(r.w, "MISS")
+			} else {
+				r.RawValue(*v)
+			}
+		}
+		return
+	}
+	lines := make([]string, len(values))
+	for i, v := range values {
+		lines[i] = "NULL"
+		if v != nil {
+			lines[i] = *v
+		}
+	}
+	r.Result(strings.Join(lines, "\n"), values)
+}
+
+// Default reports a missing key along with the default the command was
+// given for it, written as the value alone in text mode and with status
+// "miss" in JSON mode.
+func (r *reply) Default(v string) {
+	if r.json {
+		r.writeJSON(jsonReply{Status: "miss", Value: &v})
+		return
+	}
+	r.textValue(v)
+}
+
+// Change reports a change to a SUBSCRIBE connection, written as the line
+// itself in text mode and as the value with status "change" in JSON mode.
+func (r *reply) Change(line string) {
+	if r.json {
+		r.writeJSON(jsonReply{Status: "change", Value: &line})
+		return
+	}
+	fmt.Fprintln(r.w, line)
+}
+
+// RawValue reports a cached value as "VALUE <n>" followed by exactly n bytes
+// and a newline, so values may contain newlines.
+func (r *reply) RawValue(v string) {
+	if r.json {
+		r.writeJSON(jsonReply{Status: "hit", Value: &v})
+		return
+	}
+	fmt.Fprintf(r.w, "VALUE %d\n%s\n", len(v), v)
+}
+
+// Tagged reports a value along with an outcome, written as "<TAG> <value>"
+// in text mode and with the lower-cased tag as the status in JSON mode.
+func (r *reply) Tagged(tag, v string) {
+	if r.json {
+		r.writeJSON(jsonReply{Status: strings.ToLower(tag), Value: &v})
+		return
+	}
+	fmt.Fprintln(r.w, tag+" "+v)
+}
+
+// Result reports a command's output, written as text verbatim in text mode
+// and as the result field in JSON mode. In protocol 2, text of several
+// lines is preceded by "LINES <n>", so that a client reading pipelined
+// responses knows where it ends.
+func (r *reply) Result(text string, result any) {
+	if r.json {
+		r.writeJSON(jsonReply{Status: "ok", Result: result})
+		return
+	}
+	if r.framed(text) {
+		fmt.Fprintf(r.w, "LINES %d\n", strings.Count(text, "\n")+1)
+	}
+	fmt.Fprintln(r.w, text)
+}
+
+// Error reports a failed command, written as "ERROR <CODE> <message>" in
+// text mode. --legacy-errors only applies to protocol 1.
+func (r *reply) Error(code ErrorCode, message string) {
+	r.failed = true
+	r.errorCount++
+	r.hangUp = r.hangUp || r.failFast
+	switch {
+	case r.json && r.legacyErrors:
+		r.writeJSON(jsonReply{Status: "error", Message: message})
+	case r.json:
+		r.writeJSON(jsonReply{Status: "error", Message: message, Code: string(code)})
+	case r.legacyErrors:
+		fmt.Fprintln(r.w, "ERROR: "+message)
+	default:
+		fmt.Fprintln(r.w, "ERROR "+string(code)+" "+message)
+	}
+}
+
+func (r *reply) Errorf(code ErrorCode, format string, args ...any) {
+	r.Error(code, fmt.Sprintf(format, args...))
+}
+
+// Err reports a failed command with the code codeOf gives err.
+func (r *reply) Err(err error) {
+	r.Error(codeOf(err), err.Error())
+}
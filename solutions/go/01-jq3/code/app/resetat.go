@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"strconv"
+	"time"
+)
+
+// ResetAt schedules the cache to be cleared once its clock reaches t,
+// whatever the TTLs of the entries, replacing any reset already scheduled;
+// the zero time cancels it. The clear happens in the first operation on or
+// after t, or in a janitor sweep if one comes first, and reports each entry
+// as RemovalScheduledReset. It happens once: the schedule is dropped when
+// it fires. A snapshot carries the schedule, and restoring one that has
+// one replaces the cache's.
+func (c *LRUCache[K, V]) ResetAt(t time.Time) {
+	c.lock()
+	defer c.unlock()
+	c.setResetAt(t)
+}
+
+// setResetAt stores t, the zero time as 0, for resetDue to read without
+// the lock.
+func (c *LRUCache[K, V]) setResetAt(t time.Time) {
+	if t.IsZero() {
+		c.resetAt.Store(0)
+		return
+	}
+	c.resetAt.Store(t.UnixNano())
+}
+
+// scheduledReset returns the time of the scheduled reset, or the zero time
+// if there is none.
+func (c *LRUCache[K, V]) scheduledReset() time.Time {
+	if at := c.resetAt.Load(); at != 0 {
+		return time.Unix(0, at)
+	}
+	return time.Time{}
+}
+
+// resetDue reports whether the scheduled reset is due. The caller must
+// hold the lock, for reading at least.
+func (c *LRUCache[K, V]) resetDue() bool {
+	at := c.resetAt.Load()
+	return at != 0 && c.now().UnixNano() >= at
+}
+
+// resetIfDue clears the cache if the scheduled reset is due. The caller
+// must hold the write lock.
+func (c *LRUCache[K, V]) resetIfDue() {
+	if c.resetDue() {
+		c.resetAt.Store(0)
+		c.clear(RemovalScheduledReset)
+	}
+}
+
+func (s *session) cmdResetat(in *bufio.Reader, out *reply, line string, parts []string) {
+	secs, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || secs < 0 {
+		out.Errorf(CodeInvalid, "Invalid time: %s", parts[1])
+		return
+	}
+	var t time.Time
+	if secs > 0 {
+		t = time.Unix(secs, 0)
+	}
+	s.cache.ResetAt(t)
+	out.OK()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The RESP front end lets redis-cli and other Redis clients talk to the
+// cache. It understands the small command subset below, replying with RESP
+// types; anything else gets an error reply and the connection stays open.
+
+var errProtocol = errors.New("Protocol error")
+
+// runRESP serves RESP requests read from conn until the client disconnects.
+func (s *session) runRESP(conn io.ReadWriter) error {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	locked := s.password != ""
+	for {
+		args, err := readRESPCommand(r)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, errProtocol) {
+			fmt.Fprintf(w, "-ERR %v\r\n", err)
+			return w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		s.inflight.RLock()
+		switch {
+		case len(args) == 0:
+		case strings.EqualFold(args[0], "AUTH"):
+			locked = s.authRESP(w, args, locked)
+		case locked:
+			s.failedAuth()
+			w.WriteString("-NOAUTH Authentication required.\r\n")
+		default:
+			done := s.asTenant("")
+			s.executeRESP(w, args)
+			done()
+		}
+		s.sleep(s.latency)
+		err = w.Flush()
+		s.inflight.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readRESPCommand reads one request: either a RESP array of bulk strings or
+// an inline command, which is a plain line of space-separated words.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	args := make([]string, 0, n)
+	for range n {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if string(buf[size:]) != "\r\n" {
+			return nil, fmt.Errorf("%w: bulk string not terminated", errProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readRESPLine reads a line terminated by CRLF or, for inline commands typed
+// by hand, a bare LF.
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// unexpectedEOF reports EOF in the middle of a request as a truncated request
+// rather than a clean disconnect.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// executeRESP runs one request and writes its reply to w.
+func (s *session) executeRESP(w *bufio.Writer, args []string) {
+	cache := s.cache
+	switch name := strings.ToUpper(args[0]); name {
+	case "GET":
+		if len(args) != 2 {
+			writeRESPArity(w, name)
+			return
+		}
+		ctx, cancel := s.commandContext(context.Background())
+		defer cancel()
+		value, ok, err := cache.GetCtx(ctx, args[1])
+		if err != nil {
+			fmt.Fprintf(w, "-%s %v\r\n", codeOf(err), err)
+			return
+		}
+		if !ok {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		str, err := value.Str()
+		if err != nil {
+			fmt.Fprintf(w, "-%s %v\r\n", codeOf(err), err)
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(str), str)
+
+	case "SET":
+		if len(args) != 3 && len(args) != 5 {
+			writeRESPArity(w, name)
+			return
+		}
+		if why := s.refusal(); why != "" {
+			fmt.Fprintf(w, "-%s %s is not allowed %s\r\n", CodeReadOnly, name, why)
+			return
+		}
+		s.writing.RLock()
+		defer s.writing.RUnlock()
+		var ttl time.Duration
+		if len(args) == 5 {
+			seconds, err := strconv.Atoi(args[4])
+			if strings.ToUpper(args[3]) != "EX" || err != nil {
+				w.WriteString("-ERR syntax error\r\n")
+				return
+			}
+			if seconds <= 0 {
+				w.WriteString("-ERR invalid expire time in 'set' command\r\n")
+				return
+			}
+			ttl = time.Duration(seconds) * time.Second
+		}
+		ctx, cancel := s.commandContext(context.Background())
+		defer cancel()
+		if err := cache.PutCtx(ctx, args[1], StringValue(args[2]), ttl, 0); err != nil {
+			// The code, which starts with ERR, is the error kind.
+			fmt.Fprintf(w, "-%s %v\r\n", codeOf(err), err)
+			return
+		}
+		line := "PUT " + quoteToken(args[1]) + " " + quoteToken(args[2])
+		if ttl > 0 {
+			line += " " + args[4]
+		}
+		s.log(line)
+		w.WriteString("+OK\r\n")
+
+	case "DEL":
+		if len(args) < 2 {
+			writeRESPArity(w, name)
+			return
+		}
+		if why := s.refusal(); why != "" {
+			fmt.Fprintf(w, "-%s %s is not allowed %s\r\n", CodeReadOnly, name, why)
+			return
+		}
+		s.writing.RLock()
+		defer s.writing.RUnlock()
+		removed := 0
+		for _, key := range args[1:] {
+			ok, err := cache.RemoveThrough(key)
+			if err != nil {
+				fmt.Fprintf(w, "-%s %v\r\n", codeOf(err), err)
+				return
+			}
+			if ok {
+				s.log("DELETE " + quoteToken(key))
+				removed++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", removed)
+
+	case "EXISTS":
+		if len(args) < 2 {
+			writeRESPArity(w, name)
+			return
+		}
+		found := 0
+		for _, key := range args[1:] {
+			if cache.Contains(key) {
+				found++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", found)
+
+	case "TTL":
+		if len(args) != 2 {
+			writeRESPArity(w, name)
+			return
+		}
+		ttl, ok := cache.TTL(args[1])
+		switch {
+		case !ok:
+			w.WriteString(":-2\r\n")
+		case ttl == 0:
+			w.WriteString(":-1\r\n")
+		default:
+			fmt.Fprintf(w, ":%d\r\n", (ttl+time.Second/2)/time.Second)
+		}
+
+	case "DBSIZE":
+		if len(args) != 1 {
+			writeRESPArity(w, name)
+			return
+		}
+		fmt.Fprintf(w, ":%d\r\n", cache.Size())
+
+	case "HELLO":
+		// Clients send HELLO to pick the protocol, so it answers as
+		// Redis would, for RESP2, the only version spoken here.
+		if len(args) > 2 {
+			writeRESPArity(w, name)
+			return
+		}
+		if len(args) == 2 && args[1] != "2" {
+			w.WriteString("-NOPROTO unsupported protocol version\r\n")
+			return
+		}
+		features := []string{"ttl", "resp"}
+		if s.password != "" {
+			features = append(features, "auth")
+		}
+		if s.refusal() != "" {
+			features = append(features, "readonly")
+		}
+		fmt.Fprintf(w, "*8\r\n$6\r\nserver\r\n$9\r\nlru-cache\r\n$7\r\nversion\r\n$%d\r\n%s\r\n", len(Version), Version)
+		fmt.Fprintf(w, "$5\r\nproto\r\n:2\r\n$8\r\nfeatures\r\n*%d\r\n", len(features))
+		for _, f := range features {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(f), f)
+		}
+
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+// authRESP answers AUTH <password>, or AUTH default <password> as clients
+// that know about Redis users send it, and returns whether the connection
+// is still locked.
+func (s *session) authRESP(w *bufio.Writer, args []string, locked bool) bool {
+	switch {
+	case len(args) != 2 && len(args) != 3:
+		writeRESPArity(w, args[0])
+	case s.password == "":
+		w.WriteString("-ERR AUTH called without any password configured\r\n")
+	case !s.checkPassword(args[len(args)-1]), len(args) == 3 && !s.checkUser(args[1]):
+		w.WriteString("-WRONGPASS invalid username-password pair\r\n")
+	default:
+		w.WriteString("+OK\r\n")
+		return false
+	}
+	return locked
+}
+
+func writeRESPArity(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(name))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"cmp"
+	"hash/maphash"
+	"slices"
+)
+
+// Scan returns up to count keys starting at cursor, along with the cursor
+// for the next call. Start with cursor 0; a returned cursor of 0 means the
+// scan is complete.
+//
+// Keys are visited in order of a per-cache hash of the key and the cursor
+// is the hash to resume from, so the order does not depend on recency and
+// entries inserted, evicted or promoted between calls do not shift the
+// position. Every key present for the whole scan is returned at least once;
+// keys added or removed mid-scan may or may not be. Each call walks all
+// entries, costing O(n log count).
+func (c *LRUCache[K, V]) Scan(cursor uint64, count int) (keys []K, next uint64) {
+	if count < 1 {
+		count = 10
+	}
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	type hashed struct {
+		hash uint64
+		key  K
+	}
+	now := c.now()
+	var found []hashed
+	for key, node := range c.cache {
+		if node.expired(now) {
+			continue
+		}
+		if h := maphash.Comparable(c.scanSeed, key); h >= cursor {
+			found = append(found, hashed{h, key})
+		}
+	}
+	slices.SortFunc(found, func(a, b hashed) int { return cmp.Compare(a.hash, b.hash) })
+
+	// Keys sharing a hash go out together so that none is skipped by
+	// resuming past their hash.
+	n := min(count, len(found))
+	for n < len(found) && n > 0 && found[n].hash == found[n-1].hash {
+		n++
+	}
+	keys = make([]K, n)
+	for i := range n {
+		keys[i] = found[i].key
+	}
+	if n < len(found) {
+		next = found[n-1].hash + 1
+	}
+	return keys, next
+}
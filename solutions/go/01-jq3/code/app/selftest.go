@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The self-test checks that Get and Put take constant time, as the cache
+// promises, by timing them at sizes three orders of magnitude apart. An
+// accidental scan of the entries in either shows up as the largest cache
+// being hundreds of times slower than the smallest, well past the factor
+// allowed for the largest one outgrowing the CPU caches.
+//
+// Each size is timed on a full, warmed cache, in batches of
+// selftestBatch operations so the clock's resolution does not matter, and
+// the median batch is taken so a GC pause or a preemption does not; of
+// selftestRounds rounds, the fastest counts, as noise only ever slows a
+// round down. Gets
+// are of a fixed few keys, so that every size reads the same amount of
+// memory; Puts are of new keys, each evicting the least recently used
+// entry. The first selftestWarmup batches are not counted.
+
+var selftestSizes = []int{1_000, 100_000, 1_000_000}
+
+const (
+	selftestBatch   = 100
+	selftestBatches = 500
+	selftestWarmup  = 50
+	selftestRounds  = 5
+	// selftestHot is how many keys the Gets are of.
+	selftestHot = 1_000
+	// defaultSelftestFactor is how many times slower than the smallest
+	// the largest size may be.
+	defaultSelftestFactor = 3.0
+)
+
+// selftestRow is the median latency of each operation at one size.
+type selftestRow struct {
+	size     int
+	get, put time.Duration
+}
+
+// runSelftest times the cache at each size, writes a table of the results
+// to w and reports whether the largest size was within factor of the
+// smallest for both operations.
+func runSelftest(w io.Writer, factor float64) bool {
+	runtime.GOMAXPROCS(1)
+	rows := make([]selftestRow, len(selftestSizes))
+	for i, size := range selftestSizes {
+		rows[i] = selftestAt(size)
+	}
+
+	fmt.Fprintf(w, "%-10s %10s %10s\n", "size", "get_ns", "put_ns")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%-10d %10d %10d\n", r.size, r.get.Nanoseconds(), r.put.Nanoseconds())
+	}
+	small, large := rows[0], rows[len(rows)-1]
+	getRatio := float64(large.get) / float64(max(small.get, 1))
+	putRatio := float64(large.put) / float64(max(small.put, 1))
+	ok := getRatio <= factor && putRatio <= factor
+	verdict := "PASS"
+	if !ok {
+		verdict = "FAIL"
+	}
+	fmt.Fprintf(w, "%s: at %d entries get is %.2fx and put %.2fx as slow as at %d, allowed %gx\n",
+		verdict, large.size, getRatio, putRatio, small.size, factor)
+	return ok
+}
+
+// selftestAt times Get and Put on a full cache of size entries.
+func selftestAt(size int) selftestRow {
+	cache := NewLRUCache[string, Value](size)
+	value := StringValue("v")
+	for i := range size {
+		cache.Put("k"+strconv.Itoa(i), value)
+	}
+	rng := rand.New(rand.NewSource(1))
+	hot := make([]string, selftestHot)
+	for i := range hot {
+		hot[i] = "k" + strconv.Itoa(rng.Intn(size))
+	}
+	fresh := make([]string, selftestRounds*selftestBatches*selftestBatch)
+	for i := range fresh {
+		fresh[i] = "n" + strconv.Itoa(i)
+	}
+
+	row := selftestRow{size: size, get: time.Hour, put: time.Hour}
+	for round := range selftestRounds {
+		runtime.GC()
+		row.get = min(row.get, selftestMedian(func(batch int) {
+			for i := range selftestBatch {
+				cache.Get(hot[(batch*selftestBatch+i)%len(hot)])
+			}
+		}))
+		keys := fresh[round*selftestBatches*selftestBatch:]
+		row.put = min(row.put, selftestMedian(func(batch int) {
+			for _, key := range keys[batch*selftestBatch : (batch+1)*selftestBatch] {
+				cache.Put(key, value)
+			}
+		}))
+	}
+	return row
+}
+
+// selftestMedian runs batch selftestBatches times and returns the median
+// time it took per operation, leaving out the warm-up batches.
+func selftestMedian(batch func(n int)) time.Duration {
+	times := make([]time.Duration, 0, selftestBatches-selftestWarmup)
+	for n := range selftestBatches {
+		start := time.Now()
+		batch(n)
+		if n >= selftestWarmup {
+			times = append(times, time.Since(start))
+		}
+	}
+	slices.Sort(times)
+	return times[len(times)/2] / selftestBatch
+}
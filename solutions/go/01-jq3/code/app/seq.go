@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Every cache numbers its writes: each change of value, by whatever
+// command, and each key deleted takes the next number of one sequence that
+// every connection shares, and an entry remembers the number of the write
+// that stored its value. Promotion, Touch, Expire and Rename do not take a
+// number, and neither do evictions and expiries, which no client asked
+// for. A snapshot keeps the numbers, and restoring one never moves the
+// sequence back. Entries restored from an export, and any written before
+// the snapshot format kept them, have number 0.
+//
+// After SEQ ON a connection's mutating commands have " seq=<n>" appended
+// to their response, or a seq field in JSON mode, when they succeed: n is
+// the number of the last write the cache had made when the command
+// finished. Such commands run one at a time, so that is the number of the
+// command's own last write if it made any.
+
+// Seq returns the number of the last write; see above.
+func (c *LRUCache[K, V]) Seq() uint64 {
+	return c.writeSeq.Load()
+}
+
+// nextSeq numbers a write. It must be called with the write lock held.
+func (c *LRUCache[K, V]) nextSeq() uint64 {
+	return c.writeSeq.Add(1)
+}
+
+// restoreSeq moves the sequence forward to seq, the number of the last
+// write a snapshot being restored saw, if it is behind. It must be called
+// with the write lock held.
+func (c *LRUCache[K, V]) restoreSeq(seq uint64) {
+	if seq > c.writeSeq.Load() {
+		c.writeSeq.Store(seq)
+	}
+}
+
+// GetSeq is GetThrough that also returns the number of the write that
+// stored the value.
+func (c *LRUCache[K, V]) GetSeq(key K) (V, uint64, bool, error) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	if value, ok := c.get(key); ok {
+		return value, c.cache[key].seq, true, nil
+	}
+	var zero V
+	if c.readOnly {
+		return zero, 0, false, nil
+	}
+	node, ok, err := c.lookupThrough(key)
+	if !ok {
+		return zero, 0, false, err
+	}
+	return node.value, node.seq, true, nil
+}
+
+// seqReply captures the response written to out until the returned
+// function is called, which writes it with the cache's last write number
+// appended unless the command failed.
+func seqReply(out *reply, cache *LRUCache[string, Value]) func() {
+	w, json := out.w, out.json
+	var buf bytes.Buffer
+	out.w, out.failed = &buf, false
+	return func() {
+		out.w = w
+		if out.failed {
+			w.Write(buf.Bytes())
+			return
+		}
+		writeSeq(w, buf.Bytes(), json, cache.Seq())
+	}
+}
+
+// writeSeq writes resp, the response to one command, with seq appended to
+// its last line or, in JSON mode, to its last object.
+func writeSeq(w io.Writer, resp []byte, json bool, seq uint64) {
+	body := bytes.TrimSuffix(resp, []byte("\n"))
+	if json && bytes.HasSuffix(body, []byte("}")) {
+		fmt.Fprintf(w, "%s,\"seq\":%d}\n", body[:len(body)-1], seq)
+		return
+	}
+	fmt.Fprintf(w, "%s seq=%d\n", body, seq)
+}
+
+func (s *session) cmdGetseq(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, seq, ok, err := s.cache.GetSeq(parts[1])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if !ok {
+		out.Null()
+		return
+	}
+	str, err := value.Str()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	out.Result(strconv.FormatUint(seq, 10)+" "+str, map[string]any{"seq": seq, "value": str})
+}
+
+func (s *session) cmdSeq(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[1] != "ON" && parts[1] != "OFF" {
+		out.Error(CodeArity, "SEQ requires ON or OFF")
+		return
+	}
+	out.seqs = parts[1] == "ON"
+	out.OK()
+}
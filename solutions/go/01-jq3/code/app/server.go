@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// tcpServer accepts TCP connections and runs handle on each one in its own
+// goroutine. A client disconnecting only ends its own connection.
+type tcpServer struct {
+	ln     net.Listener
+	handle func(conn net.Conn) error
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// listenTCP starts listening on addr; Serve then accepts connections.
+func listenTCP(addr string, handle func(conn net.Conn) error) (*tcpServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", ln.Addr())
+	return &tcpServer{ln: ln, handle: handle, conns: make(map[net.Conn]struct{})}, nil
+}
+
+// Serve accepts connections until the listener fails or Shutdown closes it,
+// in which case it returns nil.
+func (srv *tcpServer) Serve() error {
+	for {
+		conn, err := srv.ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			if srv.isClosing() {
+				return nil
+			}
+			return err
+		}
+		if !srv.track(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer srv.untrack(conn)
+			err := srv.handle(conn)
+			// Shutdown ends idle connections with a read deadline, which
+			// is not worth reporting.
+			if err != nil && !(srv.isClosing() && errors.Is(err, os.ErrDeadlineExceeded)) {
+				logs.log(levelWarn, "connection failed", "remote", conn.RemoteAddr(), "error", err)
+			}
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and lets each open one finish the
+// command it is running: a connection waiting for its next command is
+// ended at once, a busy one after writing its response. Connections still
+// open when ctx is done are closed forcibly.
+func (srv *tcpServer) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.closing = true
+	err := srv.ln.Close()
+	for conn := range srv.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	srv.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		srv.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.mu.Lock()
+		for conn := range srv.conns {
+			conn.Close()
+		}
+		srv.mu.Unlock()
+		<-done
+	}
+	return err
+}
+
+func (srv *tcpServer) isClosing() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.closing
+}
+
+// track registers a new connection, or reports false if the server is
+// shutting down.
+func (srv *tcpServer) track(conn net.Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.closing {
+		return false
+	}
+	srv.conns[conn] = struct{}{}
+	srv.wg.Add(1)
+	return true
+}
+
+func (srv *tcpServer) untrack(conn net.Conn) {
+	conn.Close()
+	srv.mu.Lock()
+	delete(srv.conns, conn)
+	srv.mu.Unlock()
+	srv.wg.Done()
+}
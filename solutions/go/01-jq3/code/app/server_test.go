@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newServerSession returns a session set up as main sets it up for
+// --listen with --capacity capacity.
+func newServerSession(t testing.TB, capacity int) *session {
+	t.Helper()
+	s := newTestSession(t)
+	s.cache = NewLRUCache[string, Value](capacity)
+	s.publishRemovals(defaultCacheName, s.cache)
+	s.caches = map[string]*LRUCache[string, Value]{defaultCacheName: s.cache}
+	s.current = defaultCacheName
+	s.server = true
+	return s
+}
+
+// startTCP serves s's line protocol on a free local port until the test
+// ends and returns the address.
+func startTCP(t testing.TB, s *session) string {
+	t.Helper()
+	srv, err := listenTCP("127.0.0.1:0", func(conn net.Conn) error { return s.serve(conn, conn, true) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	return srv.ln.Addr().String()
+}
+
+// client is one connection speaking the line protocol.
+type client struct {
+	t    testing.TB
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t testing.TB, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends line and returns the one-line response.
+func (c *client) do(line string) string {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "%s\n", line); err != nil {
+		c.t.Fatalf("%s: %v", line, err)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("%s: %v", line, err)
+	}
+	return strings.TrimSuffix(resp, "\n")
+}
+
+func TestTCPClientsShareCache(t *testing.T) {
+	s := newServerSession(t, 1000)
+	addr := startTCP(t, s)
+	a, b := dial(t, addr), dial(t, addr)
+
+	var wg sync.WaitGroup
+	for _, tc := range []struct {
+		c      *client
+		prefix string
+	}{{a, "a"}, {b, "b"}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				if got := tc.c.do(fmt.Sprintf("PUT %s%d %d", tc.prefix, i, i)); got != "OK" {
+					t.Errorf("PUT %s%d = %q", tc.prefix, i, got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range 100 {
+		if got := a.do(fmt.Sprintf("GET b%d", i)); got != fmt.Sprint(i) {
+			t.Fatalf("a: GET b%d = %q", i, got)
+		}
+		if got := b.do(fmt.Sprintf("GET a%d", i)); got != fmt.Sprint(i) {
+			t.Fatalf("b: GET a%d = %q", i, got)
+		}
+	}
+	if got := a.do("SIZE"); got != "200" {
+		t.Errorf("SIZE = %q", got)
+	}
+}
+
+func TestTCPRefusesInitAndSurvivesEOF(t *testing.T) {
+	s := newServerSession(t, 10)
+	addr := startTCP(t, s)
+	a := dial(t, addr)
+	if got := a.do("INIT 5"); !strings.HasPrefix(got, "ERROR") {
+		t.Errorf("INIT over TCP = %q", got)
+	}
+	a.do("PUT k v")
+	a.conn.Close()
+
+	b := dial(t, addr)
+	if got := b.do("GET k"); got != "v" {
+		t.Errorf("GET k after the first client left = %q", got)
+	}
+	if got := b.do("CAPACITY"); got != "10" {
+		t.Errorf("CAPACITY = %q", got)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SHADOW ON checks the cache against refModel, a reference LRU cache kept
+// as plainly as possible: a slice of keys, most recently used first, and a
+// map of their values, every operation a scan of the slice. GET, PEEK, a
+// PUT without a TTL and DELETE run on both, and their responses and the
+// keys, values and order each holds afterwards must agree; any other
+// command, and one that fails, is not checked, and the model copies the
+// cache once it has run. A disagreement is reported on stderr as
+// DIVERGENCE and the model copied again, so that one bug is reported once.
+//
+// Only a plain LRU cache bounded by entry count can be followed; while the
+// cache uses anything the model lacks, such as pinning, tiers, tenants or
+// a backing store, nothing is checked. An entry that expires between the
+// cache's look at the clock and the model's may be reported wrongly, so
+// checks with TTLs are best made with --test-clock.
+
+// maxShadowReports is how many of its latest divergences SHADOW REPORT
+// lists.
+const maxShadowReports = 20
+
+// modelEntry is a value the reference model holds.
+type modelEntry struct {
+	value    string
+	expireAt time.Time // zero means it never expires
+}
+
+func (e modelEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// refModel is the reference copy of cache, expired entries included.
+type refModel struct {
+	cache   *LRUCache[string, Value] // nil until the model is copied
+	keys    []string                 // most recently used first
+	entries map[string]modelEntry
+}
+
+// ShadowReport is what SHADOW REPORT prints: how many commands were
+// checked and not, how many diverged, and the latest divergences.
+type ShadowReport struct {
+	Checked     int      `json:"checked"`
+	Unchecked   int      `json:"unchecked"`
+	Divergences int      `json:"divergences"`
+	Reports     []string `json:"reports"`
+}
+
+func (r ShadowReport) String() string {
+	text := fmt.Sprintf("checked=%d unchecked=%d divergences=%d", r.Checked, r.Unchecked, r.Divergences)
+	for _, report := range r.Reports {
+		text += "\n" + report
+	}
+	return text
+}
+
+// shadow is the state of SHADOW ON on one stream.
+type shadow struct {
+	model  refModel
+	report ShadowReport
+}
+
+// modelGap returns what c uses that the reference model lacks, or "" if
+// the model can follow it.
+func (c *LRUCache[K, V]) modelGap() string {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	_, plain := c.policy.(*lruPolicy[K, V])
+	switch {
+	case !plain:
+		return "its policy is not plain LRU"
+	case c.maxCost > 0:
+		return "it is bounded by bytes or weight"
+	case c.l2 != nil:
+		return "it is tiered"
+	case c.sketch != nil:
+		return "admission is on"
+	case c.lowWater > 0:
+		return "it evicts to a low watermark"
+	case c.noEvict:
+		return "eviction is off"
+	case c.maxIdle > 0:
+		return "it has a max idle time"
+	case c.backing != nil:
+		return "it has a backing store"
+	case c.refresher != nil:
+		return "refresh-ahead is on"
+	case c.negativeTTL > 0 || c.deleteRetention > 0 || len(c.negative) > 0:
+		return "it keeps tombstones"
+	case c.readOnly:
+		return "it is read-only"
+	case c.resetAt.Load() != 0:
+		return "a reset is scheduled"
+	case c.janitor != nil || c.memGuard != nil || c.tuner != nil:
+		return "a background worker changes it"
+	}
+	for _, node := range c.cache {
+		switch _, err := textOf(node.value); {
+		case node.pinned:
+			return "it has pinned entries"
+		case node.priority != PriorityNormal:
+			return "it has entries of low or high priority"
+		case node.tenant != "":
+			return "it has tenants"
+		case node.grace > 0:
+			return "it has entries with a grace period"
+		case err != nil:
+			return "it holds lists or hashes"
+		}
+	}
+	return ""
+}
+
+// copy makes m a copy of cache, which modelGap must accept.
+func (m *refModel) copy(cache *LRUCache[string, Value]) {
+	cache.rlock()
+	defer cache.mu.RUnlock()
+
+	m.cache, m.keys, m.entries = cache, nil, make(map[string]modelEntry, len(cache.cache))
+	cache.each(func(node *Node[string, Value]) bool {
+		value, _ := node.value.Str()
+		m.keys = append(m.keys, node.key)
+		m.entries[node.key] = modelEntry{value: value, expireAt: node.expireAt}
+		return true
+	})
+}
+
+// modelled reports whether the model knows what c does given args.
+func modelled(c *commandSpec, args []string) bool {
+	switch c.name {
+	case "GET", "PEEK", "DELETE":
+		return len(args) == 1
+	case "PUT":
+		return len(args) == 2
+	}
+	return false
+}
+
+// find returns where key is among the keys, or -1 if it is not cached or
+// has expired, in which case it is dropped as the cache drops it.
+func (m *refModel) find(key string, now time.Time) int {
+	i := slices.Index(m.keys, key)
+	if i >= 0 && m.entries[key].expired(now) {
+		m.remove(i)
+		return -1
+	}
+	return i
+}
+
+func (m *refModel) remove(i int) {
+	delete(m.entries, m.keys[i])
+	m.keys = slices.Delete(m.keys, i, i+1)
+}
+
+// touch makes the key at i the most recently used.
+func (m *refModel) touch(i int) {
+	key := m.keys[i]
+	m.keys = slices.Insert(slices.Delete(m.keys, i, i+1), 0, key)
+}
+
+// apply runs the command c names with args on the model, writing the
+// response the cache should have given to want.
+func (m *refModel) apply(c *commandSpec, args []string, want *reply) {
+	key := m.cache.normalize(args[0])
+	i := slices.Index(m.keys, key)
+	// DELETE removes an entry that has expired but is still held, and
+	// says so, as the cache does; anything else reaps it first.
+	if c.name != "DELETE" {
+		i = m.find(key, m.cache.now())
+	}
+	switch c.name {
+	case "GET", "PEEK":
+		if i < 0 {
+			want.Null()
+			return
+		}
+		if c.name == "GET" {
+			m.touch(i)
+		}
+		want.Value(m.entries[key].value)
+	case "PUT":
+		if i >= 0 {
+			m.remove(i)
+		}
+		m.keys = slices.Insert(m.keys, 0, key)
+		m.entries[key] = modelEntry{value: args[1]}
+		for capacity := m.cache.Capacity(); capacity > 0 && len(m.keys) > capacity; {
+			m.remove(len(m.keys) - 1)
+		}
+		want.OK()
+	case "DELETE":
+		if i < 0 {
+			want.Null()
+			return
+		}
+		m.remove(i)
+		want.OK()
+	}
+}
+
+// live returns the values of the model's live entries by key and the keys
+// in order.
+func (m *refModel) live(now time.Time) (map[string]string, []string) {
+	values := make(map[string]string)
+	var order []string
+	for _, key := range m.keys {
+		if e := m.entries[key]; !e.expired(now) {
+			values[key] = e.value
+			order = append(order, key)
+		}
+	}
+	return values, order
+}
+
+// liveEntries returns the same of cache.
+func liveEntries(cache *LRUCache[string, Value]) (map[string]string, []string) {
+	_, entries, _ := cache.capture()
+	values := make(map[string]string, len(entries))
+	order := make([]string, len(entries))
+	for i, e := range entries {
+		values[e.Key], _ = e.Value.Str()
+		order[i] = e.Key
+	}
+	return values, order
+}
+
+// differingKey returns the least key the cache and the model disagree on:
+// one only one of them holds or whose values differ, or else one whose
+// place in the order differs. It returns "" if they agree.
+func differingKey(got, want map[string]string, gotOrder, wantOrder []string) string {
+	var keys []string
+	for key, value := range got {
+		if v, ok := want[key]; !ok || v != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range want {
+		if _, ok := got[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		for i := range gotOrder {
+			if gotOrder[i] != wantOrder[i] {
+				keys = append(keys, gotOrder[i])
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	return slices.Min(keys)
+}
+
+// describeKey says where key is among order and what it holds.
+func describeKey(key string, values map[string]string, order []string) string {
+	value, ok := values[key]
+	if !ok {
+		return "missing"
+	}
+	return fmt.Sprintf("%q at %d", value, slices.Index(order, key)+1)
+}
+
+// runShadowed runs the command described by c with run and, if the model
+// can follow it, on the model too, reporting any divergence.
+func (s *session) runShadowed(run func(c *commandSpec, in *bufio.Reader, out *reply, line string, parts []string), c *commandSpec, in *bufio.Reader, out *reply, line string, parts []string) {
+	sh := out.shadow
+	if s.cache != nil && sh.model.cache != s.cache && s.cache.modelGap() == "" {
+		sh.model.copy(s.cache)
+	}
+	before := sh.model.cache
+	w := out.w
+	var buf bytes.Buffer
+	out.w, out.failed = &buf, false
+	run(c, in, out, line, parts)
+	out.w = w
+	w.Write(buf.Bytes())
+
+	cache := s.cache
+	switch {
+	case cache == nil:
+		return
+	case cache.modelGap() != "":
+		sh.model.cache = nil
+		sh.report.Unchecked++
+		return
+	case before != cache || out.failed || !modelled(c, parts[1:]):
+		sh.model.copy(cache)
+		sh.report.Unchecked++
+		return
+	}
+	sh.report.Checked++
+	var wantBuf bytes.Buffer
+	want := reply{w: &wantBuf, json: out.json, framedValues: out.framedValues, legacyErrors: out.legacyErrors, protocol: out.protocol}
+	sh.model.apply(c, parts[1:], &want)
+
+	got, gotOrder := liveEntries(cache)
+	values, order := sh.model.live(cache.now())
+	gotResp := strings.TrimSuffix(buf.String(), "\n")
+	wantResp := strings.TrimSuffix(wantBuf.String(), "\n")
+	key := differingKey(got, values, gotOrder, order)
+	// With SEQ ON the response carries a number the model does not keep.
+	if key == "" && (out.seqs || gotResp == wantResp) {
+		return
+	}
+	if key == "" {
+		key = cache.normalize(parts[1])
+	}
+	words := make([]string, len(parts))
+	for i, part := range parts {
+		words[i] = quoteToken(part)
+	}
+	report := fmt.Sprintf("DIVERGENCE %s: cache %s model %s, key %s: cache %s model %s",
+		strings.Join(words, " "), strconv.Quote(gotResp), strconv.Quote(wantResp), quoteToken(key),
+		describeKey(key, got, gotOrder), describeKey(key, values, order))
+	stderrLines.println(report)
+	sh.report.Divergences++
+	sh.report.Reports = append(sh.report.Reports, report)
+	if n := len(sh.report.Reports); n > maxShadowReports {
+		sh.report.Reports = sh.report.Reports[n-maxShadowReports:]
+	}
+	sh.model.copy(cache)
+}
+
+func (s *session) cmdShadow(in *bufio.Reader, out *reply, line string, parts []string) {
+	switch parts[1] {
+	case "ON":
+		if s.cache != nil {
+			if gap := s.cache.modelGap(); gap != "" {
+				out.Errorf(CodeUnsupported, "SHADOW cannot follow the cache: %s", gap)
+				return
+			}
+		}
+		if out.shadow == nil {
+			out.shadow = &shadow{}
+		}
+		out.OK()
+	case "OFF":
+		out.shadow = nil
+		out.OK()
+	case "REPORT":
+		if out.shadow == nil {
+			out.Error(CodeUnsupported, "SHADOW is off")
+			return
+		}
+		report := out.shadow.report
+		report.Reports = slices.Clone(report.Reports)
+		if report.Reports == nil {
+			report.Reports = []string{}
+		}
+		out.Result(report.String(), report)
+	default:
+		out.Error(CodeArity, "SHADOW requires ON, OFF or REPORT")
+	}
+}
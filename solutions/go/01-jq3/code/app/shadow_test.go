@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+// randomCommand returns a command on a few keys, mostly ones the model
+// checks, with some it does not and some that move the test clock.
+func randomCommand(rng *rand.Rand) string {
+	key := fmt.Sprintf("k%d", rng.IntN(12))
+	switch n := rng.IntN(100); {
+	case n < 35:
+		return fmt.Sprintf("PUT %s %d", key, rng.IntN(1000))
+	case n < 60:
+		return "GET " + key
+	case n < 70:
+		return "PEEK " + key
+	case n < 80:
+		return "DELETE " + key
+	case n < 86:
+		return fmt.Sprintf("PUT %s %d %d", key, rng.IntN(1000), 1+rng.IntN(5))
+	case n < 90:
+		return fmt.Sprintf("DEBUG ADVANCECLOCK %d", rng.IntN(3))
+	case n < 93:
+		return fmt.Sprintf("RENAME %s k%d", key, rng.IntN(12))
+	case n < 95:
+		return "PIN " + key
+	case n < 97:
+		return "UNPIN " + key
+	case n < 98:
+		return "CLEAR"
+	default:
+		return "RESIZE " + fmt.Sprint(1+rng.IntN(10))
+	}
+}
+
+func TestShadowFindsNoDivergences(t *testing.T) {
+	for seed := range uint64(8) {
+		rng := rand.New(rand.NewPCG(seed, 143))
+		lines := []string{fmt.Sprintf("INIT %d", 1+rng.IntN(8)), "SHADOW ON"}
+		for range 5000 {
+			lines = append(lines, randomCommand(rng))
+		}
+		// PIN stops the checks until the entry goes; unpin everything so
+		// they resume before the report.
+		for i := range 12 {
+			lines = append(lines, fmt.Sprintf("UNPIN k%d", i))
+		}
+		lines = append(lines, "GET k0", "SHADOW REPORT")
+
+		s := newClockSession(t)
+		out, err := runScript(t, s, strings.Join(lines, "\n")+"\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var checked, unchecked, divergences int
+		report := out[strings.LastIndex(out, "\nchecked=")+1:]
+		if _, err := fmt.Sscanf(report, "checked=%d unchecked=%d divergences=%d", &checked, &unchecked, &divergences); err != nil {
+			t.Fatalf("seed %d: no report in %q", seed, report)
+		}
+		if divergences != 0 {
+			t.Errorf("seed %d: %d divergences:\n%s", seed, divergences, report)
+		}
+		// Pins, RESIZE and the like leave about half the commands checked.
+		if checked < len(lines)/2 {
+			t.Errorf("seed %d: only %d of %d commands checked (%d not)", seed, checked, len(lines), unchecked)
+		}
+	}
+}
+
+// hookReader hands the stream one line per Read, and runs the hook for a
+// line on the stream's goroutine before handing it over, once the lines
+// before it have run.
+type hookReader struct {
+	lines []string
+	hooks map[int]func()
+}
+
+func (r *hookReader) Read(p []byte) (int, error) {
+	if len(r.lines) == 0 {
+		return 0, io.EOF
+	}
+	if hook := r.hooks[len(r.lines)]; hook != nil {
+		hook()
+	}
+	n := copy(p, r.lines[0]+"\n")
+	r.lines = r.lines[1:]
+	return n, nil
+}
+
+func TestShadowReportsInjectedDivergence(t *testing.T) {
+	s := newTestSession(t)
+	lines := []string{
+		"INIT 3",
+		"SHADOW ON",
+		"PUT a 1",
+		"PUT b 2",
+		"GET a", // a is deleted behind the model's back before this
+		"GET b",
+		"GET a",
+		"SHADOW REPORT",
+	}
+	in := &hookReader{lines: lines, hooks: map[int]func(){
+		len(lines) - 4: func() { s.cache.Delete("a") },
+	}}
+	stderr := captureStderr(t)
+	var out strings.Builder
+	if err := s.run(in, &out); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()[strings.Index(out.String(), "checked="):]
+	want := `checked=5 unchecked=0 divergences=1
+DIVERGENCE GET a: cache "NULL" model "1", key a: cache missing model "1" at 1`
+	if strings.TrimSpace(report) != want {
+		t.Errorf("report:\n%s\nwant:\n%s", report, want)
+	}
+	if !strings.Contains(stderr.String(), "DIVERGENCE GET a") {
+		t.Errorf("stderr %q lacks the divergence", stderr.String())
+	}
+}
+
+func TestShadowRefusesWhatItCannotFollow(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 3 LFU", "OK",
+		"SHADOW ON", "ERROR ERR_UNSUPPORTED SHADOW cannot follow the cache: its policy is not plain LRU",
+		"SHADOW REPORT", "ERROR ERR_UNSUPPORTED SHADOW is off",
+		"SHADOW MAYBE", "ERROR ERR_ARITY SHADOW requires ON, OFF or REPORT",
+	)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"time"
+)
+
+// ShardedLRUCache partitions keys by hash across independent LRUCache
+// shards, each with its own lock, so operations on different shards never
+// contend.
+//
+// Eviction is per shard: when a shard is full it evicts its own least
+// recently used entry, which is not necessarily the globally least recently
+// used one. The result is an approximation of LRU that gets closer as keys
+// spread evenly across shards.
+//
+// The same goes for the budget of a byte-bounded sharded cache: each shard
+// has its share of it, counts the bytes of its own entries under its own
+// lock and evicts to stay within its share, so no count is shared between
+// shards. UsedBytes and Stats add the shards' counts up when asked.
+type ShardedLRUCache[K comparable, V any] struct {
+	seed   maphash.Seed
+	shards []*LRUCache[K, V]
+}
+
+// NewShardedLRUCache creates a cache of the given total capacity split across
+// shards. When capacity does not divide evenly, the remainder is spread one
+// entry at a time over the first shards. The shard count is capped at
+// capacity so that every shard can hold at least one entry. It panics if
+// capacity is less than 1.
+func NewShardedLRUCache[K comparable, V any](capacity, shards int) *ShardedLRUCache[K, V] {
+	checkPositive("capacity", capacity)
+	if shards < 1 {
+		shards = 1
+	}
+	if shards > capacity {
+		shards = capacity
+	}
+
+	c := &ShardedLRUCache[K, V]{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*LRUCache[K, V], shards),
+	}
+	for i := range c.shards {
+		c.shards[i] = NewLRUCache[K, V](shareOf(capacity, shards, i))
+	}
+	return c
+}
+
+// NewShardedLRUCacheBytes creates a cache of string values bounded by the
+// total bytes of its keys and values, as NewLRUCacheBytes is, with the
+// budget split across shards as NewShardedLRUCache splits capacity. A value
+// larger than its shard's share is refused with ErrTooLarge. It panics if
+// maxBytes is less than 1.
+func NewShardedLRUCacheBytes(maxBytes, shards int) *ShardedLRUCache[string, string] {
+	checkPositive("byte budget", maxBytes)
+	shards = min(max(shards, 1), maxBytes)
+	c := &ShardedLRUCache[string, string]{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*LRUCache[string, string], shards),
+	}
+	for i := range c.shards {
+		c.shards[i] = NewLRUCacheBytes(shareOf(maxBytes, shards, i))
+	}
+	return c
+}
+
+// shareOf returns shard i's share of total split over shards, the
+// remainder going one at a time to the first shards.
+func shareOf(total, shards, i int) int {
+	share := total / shards
+	if i < total%shards {
+		share++
+	}
+	return share
+}
+
+func (c *ShardedLRUCache[K, V]) shard(key K) *LRUCache[K, V] {
+	return c.shards[maphash.Comparable(c.seed, key)%uint64(len(c.shards))]
+}
+
+func (c *ShardedLRUCache[K, V]) Get(key K) (V, bool) {
+	return c.shard(key).Get(key)
+}
+
+// GetMulti looks up each key in order. Each lookup locks only its own shard,
+// so the batch is not atomic across shards.
+func (c *ShardedLRUCache[K, V]) GetMulti(keys []K) []Result[V] {
+	results := make([]Result[V], len(keys))
+	for i, key := range keys {
+		results[i].Value, results[i].Found = c.shard(key).Get(key)
+	}
+	return results
+}
+
+func (c *ShardedLRUCache[K, V]) Peek(key K) (V, bool) {
+	return c.shard(key).Peek(key)
+}
+
+func (c *ShardedLRUCache[K, V]) Contains(key K) bool {
+	return c.shard(key).Contains(key)
+}
+
+func (c *ShardedLRUCache[K, V]) Put(key K, value V) error {
+	return c.shard(key).Put(key, value)
+}
+
+func (c *ShardedLRUCache[K, V]) PutWithTTL(key K, value V, ttl time.Duration) error {
+	return c.shard(key).PutWithTTL(key, value, ttl)
+}
+
+func (c *ShardedLRUCache[K, V]) Remove(key K) bool {
+	return c.shard(key).Remove(key)
+}
+
+// SetRemovalHandler registers fn on every shard.
+func (c *ShardedLRUCache[K, V]) SetRemovalHandler(fn func(key K, value V, reason RemovalReason)) {
+	for _, s := range c.shards {
+		s.SetRemovalHandler(fn)
+	}
+}
+
+// SetEvictionHandler registers fn on every shard.
+func (c *ShardedLRUCache[K, V]) SetEvictionHandler(fn func(key K, value V)) {
+	for _, s := range c.shards {
+		s.SetEvictionHandler(fn)
+	}
+}
+
+func (c *ShardedLRUCache[K, V]) Size() int {
+	size := 0
+	for _, s := range c.shards {
+		size += s.Size()
+	}
+	return size
+}
+
+// UsedBytes returns the bytes the entries of a byte-bounded cache take, the
+// sum of the shards' counts, read one shard at a time.
+func (c *ShardedLRUCache[K, V]) UsedBytes() int {
+	used := 0
+	for _, s := range c.shards {
+		used += s.Stats().UsedBytes
+	}
+	return used
+}
+
+// DebugCheck runs DebugCheck on each shard, which among other things
+// checks that its count of bytes or weight in use is the sum over its
+// entries, and checks that none uses more than its budget.
+func (c *ShardedLRUCache[K, V]) DebugCheck() error {
+	for i, s := range c.shards {
+		if err := s.DebugCheck(); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+		st := s.Stats()
+		if used, budget := st.UsedBytes+st.UsedWeight, st.MaxBytes+st.MaxWeight; budget > 0 && used > budget {
+			return fmt.Errorf("shard %d uses %d of a budget of %d", i, used, budget)
+		}
+	}
+	return nil
+}
+
+// Stats sums the counters of all shards. Shards are read one at a time, so
+// under concurrent writes the totals are not a single atomic snapshot.
+func (c *ShardedLRUCache[K, V]) Stats() Stats {
+	var total Stats
+	for _, s := range c.shards {
+		st := s.Stats()
+		total.Hits += st.Hits
+		total.Misses += st.Misses
+		total.Evictions += st.Evictions
+		total.EvictionBatches += st.EvictionBatches
+		total.Expirations += st.Expirations
+		total.Deleted += st.Deleted
+		total.Replaced += st.Replaced
+		total.Rejected += st.Rejected
+		total.AdmissionRejected += st.AdmissionRejected
+		total.Size += st.Size
+		total.Capacity += st.Capacity
+		total.UsedBytes += st.UsedBytes
+		total.MaxBytes += st.MaxBytes
+		total.UsedWeight += st.UsedWeight
+		total.MaxWeight += st.MaxWeight
+	}
+	return total
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestShardedSplitsCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		capacity, shards int
+		want             []int
+	}{
+		{8, 4, []int{2, 2, 2, 2}},
+		{10, 4, []int{3, 3, 2, 2}},
+		{3, 8, []int{1, 1, 1}},
+		{5, 0, []int{5}},
+	} {
+		c := NewShardedLRUCache[string, int](tc.capacity, tc.shards)
+		var got []int
+		for _, s := range c.shards {
+			got = append(got, s.Capacity())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("NewShardedLRUCache(%d, %d) shard capacities %v, want %v", tc.capacity, tc.shards, got, tc.want)
+		}
+		if st := c.Stats(); st.Capacity != tc.capacity {
+			t.Errorf("NewShardedLRUCache(%d, %d) capacity %d", tc.capacity, tc.shards, st.Capacity)
+		}
+	}
+}
+
+func TestShardedRoutesAndAggregates(t *testing.T) {
+	c := NewShardedLRUCache[string, int](800, 8)
+	for i := range 50 {
+		c.Put("k"+strconv.Itoa(i), i)
+	}
+	for i := range 50 {
+		if v, ok := c.Get("k" + strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("Get(k%d) = %d, %v", i, v, ok)
+		}
+	}
+	c.Get("missing")
+	if !c.Remove("k0") || c.Contains("k0") {
+		t.Error("Remove(k0) did not remove it")
+	}
+	st := c.Stats()
+	if st.Size != 49 || c.Size() != 49 || st.Hits != 50 || st.Misses != 1 || st.Deleted != 1 {
+		t.Errorf("stats %+v", st)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShardedEvictsWithinShard(t *testing.T) {
+	c := NewShardedLRUCache[string, int](16, 4)
+	for i := range 1000 {
+		c.Put("k"+strconv.Itoa(i), i)
+		for j, s := range c.shards {
+			if s.Size() > s.Capacity() {
+				t.Fatalf("shard %d holds %d of %d", j, s.Size(), s.Capacity())
+			}
+		}
+	}
+	if c.Size() > 16 {
+		t.Errorf("size %d over capacity", c.Size())
+	}
+}
+
+// BenchmarkShardedVsSingle compares a mixed workload, three gets to each
+// put, on one cache behind one lock and on a cache of 16 shards.
+func BenchmarkShardedVsSingle(b *testing.B) {
+	const capacity, keySpace = 1 << 14, 1 << 15
+	keys := make([]string, keySpace)
+	for i := range keys {
+		keys[i] = "k" + strconv.Itoa(i)
+	}
+	type cache interface {
+		Get(string) (int, bool)
+		Put(string, int) error
+	}
+	for _, goroutines := range []int{8, 32} {
+		for _, tc := range []struct {
+			name string
+			c    cache
+		}{
+			{"single", NewLRUCache[string, int](capacity)},
+			{"sharded", NewShardedLRUCache[string, int](capacity, 16)},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", tc.name, goroutines), func(b *testing.B) {
+				per := b.N/goroutines + 1
+				var wg sync.WaitGroup
+				b.ResetTimer()
+				for g := range goroutines {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := range per {
+							key := keys[(g*per+i)*7919%keySpace]
+							if i%4 == 0 {
+								tc.c.Put(key, i)
+							} else {
+								tc.c.Get(key)
+							}
+						}
+					}()
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
+
+func TestShardedByteAccountingUnderChurn(t *testing.T) {
+	const budget, shards, goroutines = 64 << 10, 8, 16
+	c := NewShardedLRUCacheBytes(budget, shards)
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(g)))
+			for range 3000 {
+				key := "k" + strconv.Itoa(rng.Intn(500))
+				switch n := rng.Intn(10); {
+				case n < 3:
+					c.Remove(key)
+				default:
+					// Sizes from a byte to half a shard's budget, so that
+					// some puts evict several entries and a few fail.
+					c.Put(key, strings.Repeat("v", 1+rng.Intn(budget/shards/2)))
+					st := c.shard(key).Stats()
+					if st.UsedBytes > st.MaxBytes {
+						t.Errorf("a shard holds %d bytes of %d after a put", st.UsedBytes, st.MaxBytes)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for i, s := range c.shards {
+		s.lock()
+		sum := 0
+		for key, node := range s.cache {
+			sum += len(key) + len(node.value)
+		}
+		used, limit := s.usedCost, s.maxCost
+		s.unlock()
+		if sum != used {
+			t.Errorf("shard %d entries take %d bytes but it counts %d", i, sum, used)
+		}
+		if used > limit {
+			t.Errorf("shard %d holds %d bytes of %d", i, used, limit)
+		}
+		total += sum
+	}
+	if got := c.UsedBytes(); got != total {
+		t.Errorf("UsedBytes = %d, entries take %d", got, total)
+	}
+	if st := c.Stats(); st.UsedBytes != total || st.MaxBytes != budget {
+		t.Errorf("stats used %d of %d, want %d of %d", st.UsedBytes, st.MaxBytes, total, budget)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
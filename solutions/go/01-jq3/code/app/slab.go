@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"unsafe"
+)
+
+// With --compact-storage a cache copies the bytes of its string values
+// into large slabs, which it appends to and never overwrites, instead of
+// keeping each value as an allocation of its own, so that a million
+// entries give the garbage collector a few hundred slabs to deal with
+// rather than a million strings. An entry's value is a string pointing
+// into its slab, so it compares and reads like any other; Str copies it
+// out, so that what a caller keeps does not hold a whole slab alive. Keys
+// stay ordinary strings, which the map needs anyway, and so do values of
+// more than slabMaxValue bytes, which are few.
+//
+// Replaced, deleted and evicted values leave dead bytes behind, and a slab
+// left with none live is dropped at once. Once more than slabCompactAt of
+// the bytes are dead, the cache compacts: each slab of which that much is
+// dead, but the one being filled, is evacuated, the values still in it
+// copied to the end of the newest slab. The copying is done a step at a
+// time, slabStepNodes entries each time the write lock is released, so no
+// operation waits long for it. A value is never changed in place, so one a
+// reader took out of a slab before it was evacuated stays as it was.
+
+// compactStorage is set from --compact-storage before any cache is
+// created and never changed afterwards.
+var compactStorage bool
+
+const (
+	slabSize      = 1 << 20
+	slabMaxValue  = slabSize / 16
+	slabCompactAt = 0.5
+	slabStepNodes = 256
+)
+
+type valueSlab struct {
+	buf        []byte // the bytes used so far, of slabSize
+	live       int    // bytes of them that entries still use
+	evacuating bool
+}
+
+// A slabRef is where an entry's value lives: n bytes of slab, or nowhere
+// if slab is nil.
+type slabRef struct {
+	slab *valueSlab
+	n    int
+}
+
+// A slabArena holds the slabs of one cache. It is guarded by the cache's
+// write lock.
+type slabArena struct {
+	slabs      []*valueSlab // in the order made; the last is being filled
+	used, live int          // bytes, over every slab
+	// due is set when bytes are freed past the threshold, for the next
+	// step to start compacting, and cursor is the index in the resident
+	// slice the compaction under way goes on from, -1 if there is none.
+	due         bool
+	cursor      int
+	compactions int
+}
+
+func newSlabArena() *slabArena {
+	return &slabArena{cursor: -1}
+}
+
+// SlabStats describes a cache's slabs.
+type SlabStats struct {
+	Slabs       int `json:"slabs"`
+	Used        int `json:"slab_bytes"`
+	Dead        int `json:"dead_bytes"`
+	Compactions int `json:"compactions"`
+}
+
+func (s SlabStats) String() string {
+	return fmt.Sprintf("slabs=%d slab_bytes=%d dead_bytes=%d compactions=%d", s.Slabs, s.Used, s.Dead, s.Compactions)
+}
+
+// copyIn returns a copy of s at the end of the newest slab, starting a
+// new slab if it does not fit, and where it is. An empty or large s is
+// returned as it is.
+func (a *slabArena) copyIn(s string) (string, slabRef) {
+	n := len(s)
+	if n == 0 || n > slabMaxValue {
+		return s, slabRef{}
+	}
+	if len(a.slabs) == 0 || slabSize-len(a.slabs[len(a.slabs)-1].buf) < n {
+		a.slabs = append(a.slabs, &valueSlab{buf: make([]byte, 0, slabSize)})
+	}
+	slab := a.slabs[len(a.slabs)-1]
+	off := len(slab.buf)
+	slab.buf = append(slab.buf, s...)
+	slab.live += n
+	a.used += n
+	a.live += n
+	return unsafe.String(&slab.buf[off], n), slabRef{slab, n}
+}
+
+// free marks the bytes ref points to as dead, dropping its slab if none
+// of it is live any more and it is not the one being filled.
+func (a *slabArena) free(ref slabRef) {
+	if ref.slab == nil {
+		return
+	}
+	ref.slab.live -= ref.n
+	a.live -= ref.n
+	if a.used-a.live > int(slabCompactAt*float64(a.used)) {
+		a.due = true
+	}
+	if ref.slab.live > 0 || ref.slab == a.slabs[len(a.slabs)-1] {
+		return
+	}
+	if i := slices.Index(a.slabs, ref.slab); i >= 0 {
+		a.used -= len(ref.slab.buf)
+		a.slabs = slices.Delete(a.slabs, i, i+1)
+	}
+}
+
+// begin starts a compaction if one is due and reports whether it did,
+// marking the slabs to evacuate.
+func (a *slabArena) begin() bool {
+	if !a.due {
+		return false
+	}
+	a.due = false
+	marked := false
+	for _, slab := range a.slabs[:len(a.slabs)-1] {
+		if dead := len(slab.buf) - slab.live; float64(dead) >= slabCompactAt*float64(len(slab.buf)) {
+			slab.evacuating, marked = true, true
+		}
+	}
+	if marked {
+		a.cursor = 0
+	}
+	return marked
+}
+
+// finish ends the compaction under way. A slab still live was missed by
+// it, because entries moved in the resident slice as others left; it is
+// left for the next one.
+func (a *slabArena) finish() {
+	for _, slab := range a.slabs {
+		slab.evacuating = false
+	}
+	a.cursor = -1
+	a.compactions++
+}
+
+// resetSlabs drops every slab, for a cache that has dropped every entry
+// at once. It must be called with the write lock held.
+func (c *LRUCache[K, V]) resetSlabs() {
+	if c.arena != nil {
+		*c.arena = slabArena{cursor: -1, compactions: c.arena.compactions}
+	}
+}
+
+func (a *slabArena) stats() SlabStats {
+	return SlabStats{Slabs: len(a.slabs), Used: a.used, Dead: a.used - a.live, Compactions: a.compactions}
+}
+
+// SetCompactStorage turns on compact storage, with storeIn copying a
+// value into the arena's slabs and returning the copy and where it is.
+// It must be called before anything is stored. A second level gets slabs
+// of its own.
+func (c *LRUCache[K, V]) SetCompactStorage(storeIn func(value V, a *slabArena) (V, slabRef)) {
+	c.lock()
+	defer c.unlock()
+	c.arena, c.storeIn = newSlabArena(), storeIn
+	if c.l2 != nil {
+		c.l2.SetCompactStorage(storeIn)
+	}
+}
+
+// SlabStats describes the cache's slabs, and reports false if compact
+// storage is off.
+func (c *LRUCache[K, V]) SlabStats() (SlabStats, bool) {
+	c.rlock()
+	defer c.mu.RUnlock()
+	if c.arena == nil {
+		return SlabStats{}, false
+	}
+	return c.arena.stats(), true
+}
+
+// store moves node's value into the slabs, freeing where it was before.
+// It must be called with the write lock held whenever node's value is set.
+func (c *LRUCache[K, V]) store(node *Node[K, V]) {
+	if c.arena == nil {
+		return
+	}
+	old := node.stored
+	node.value, node.stored = c.storeIn(node.value, c.arena)
+	c.arena.free(old)
+}
+
+// unstore frees the bytes of the value of node, which is leaving the
+// cache.
+func (c *LRUCache[K, V]) unstore(node *Node[K, V]) {
+	if c.arena != nil {
+		c.arena.free(node.stored)
+	}
+}
+
+// compactStep does the next step of a compaction, starting one if it is
+// due. It must be called with the write lock held.
+func (c *LRUCache[K, V]) compactStep() {
+	a := c.arena
+	if a == nil || (a.cursor < 0 && !a.begin()) {
+		return
+	}
+	end := min(a.cursor+slabStepNodes, len(c.resident))
+	for _, node := range c.resident[min(a.cursor, end):end] {
+		if node.stored.slab != nil && node.stored.slab.evacuating {
+			c.store(node)
+		}
+	}
+	a.cursor = end
+	if end == len(c.resident) {
+		a.finish()
+	}
+}
+
+// checkSlabs verifies that the live bytes of each slab are those of the
+// entries stored in it.
+func (c *LRUCache[K, V]) checkSlabs() error {
+	a := c.arena
+	if a == nil {
+		return nil
+	}
+	live := make(map[*valueSlab]int, len(a.slabs))
+	for _, node := range c.resident {
+		if node.stored.slab != nil {
+			live[node.stored.slab] += node.stored.n
+		}
+	}
+	used, total := 0, 0
+	for i, slab := range a.slabs {
+		if slab.live != live[slab] {
+			return fmt.Errorf("slab %d has %d live bytes but entries use %d", i, slab.live, live[slab])
+		}
+		delete(live, slab)
+		used += len(slab.buf)
+		total += slab.live
+	}
+	if len(live) > 0 {
+		return fmt.Errorf("%d entries are stored in dropped slabs", len(live))
+	}
+	if used != a.used || total != a.live {
+		return fmt.Errorf("slabs hold %d bytes, %d live, but the totals are %d and %d", used, total, a.used, a.live)
+	}
+	return nil
+}
+
+// storeIn returns v with its string copied into a's slabs, and where it
+// is. Lists and hashes stay where they are.
+func (v Value) storeIn(a *slabArena) (Value, slabRef) {
+	if v.list != nil || v.hash != nil {
+		return v, slabRef{}
+	}
+	var ref slabRef
+	v.str, ref = a.copyIn(v.str)
+	return v, ref
+}
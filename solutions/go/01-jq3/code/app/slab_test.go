@@ -0,0 +1,273 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// compactCache returns a cache with compact storage, as --compact-storage
+// makes them, holding n values of size bytes under k0 to k<n-1>.
+func compactCache(t *testing.T, n, size int) *LRUCache[string, Value] {
+	t.Helper()
+	compactStorage = true
+	t.Cleanup(func() { compactStorage = false })
+	c := NewLRUCache[string, Value](n)
+	c.SetCompactStorage(Value.storeIn)
+	for i := range n {
+		c.Put("k"+strconv.Itoa(i), slabValue(i, size))
+	}
+	return c
+}
+
+// slabValue is the value of size bytes compactCache stores for entry i.
+func slabValue(i, size int) Value {
+	s := strconv.Itoa(i) + ":"
+	return StringValue(s + strings.Repeat("v", size-len(s)))
+}
+
+// settle releases the write lock until the compaction under way, if any,
+// is done, and returns how many steps it took.
+func settle(c *LRUCache[string, Value]) (steps int) {
+	for {
+		c.lock()
+		busy := c.arena.cursor >= 0 || c.arena.due
+		c.unlock()
+		if !busy {
+			return steps
+		}
+		steps++
+	}
+}
+
+func TestCopyInKeepsLargeAndEmptyValuesOut(t *testing.T) {
+	a := newSlabArena()
+	for _, s := range []string{"", strings.Repeat("x", slabMaxValue+1)} {
+		if got, ref := a.copyIn(s); got != s || ref.slab != nil {
+			t.Errorf("copyIn of %d bytes went into a slab", len(s))
+		}
+	}
+	got, ref := a.copyIn("hello")
+	if got != "hello" || ref.slab == nil || ref.n != 5 {
+		t.Errorf("copyIn(hello) = %q, %+v", got, ref)
+	}
+	if st := a.stats(); st.Slabs != 1 || st.Used != 5 || st.Dead != 0 {
+		t.Errorf("stats %+v", st)
+	}
+	a.free(ref)
+	if st := a.stats(); st.Slabs != 1 || st.Dead != 5 {
+		t.Errorf("the slab being filled was dropped: %+v", st)
+	}
+}
+
+func TestReadsSurviveCompaction(t *testing.T) {
+	const n, size = 4000, 1000
+	c := compactCache(t, n, size)
+	before, _ := c.SlabStats()
+	if before.Slabs < 4 {
+		t.Fatalf("%d slabs for %d entries", before.Slabs, n)
+	}
+	// A value read before its slab is evacuated stays as it was.
+	held, _ := c.Get("k1")
+	heldStr, _ := held.Str()
+	slabs := map[string]*valueSlab{}
+	c.lock()
+	for key, node := range c.cache {
+		slabs[key] = node.stored.slab
+	}
+	c.unlock()
+
+	for i := range n {
+		if i%3 != 0 {
+			c.Remove("k" + strconv.Itoa(i))
+		}
+	}
+	settle(c)
+
+	after, _ := c.SlabStats()
+	if after.Compactions == 0 || after.Dead*2 > after.Used {
+		t.Errorf("slabs %+v after deleting two thirds of %+v", after, before)
+	}
+	moved := 0
+	for i := 0; i < n; i += 3 {
+		key := "k" + strconv.Itoa(i)
+		v, ok := c.Get(key)
+		want, _ := slabValue(i, size).Str()
+		if got, _ := v.Str(); !ok || got != want {
+			t.Fatalf("Get(%s) = %.20q, %v after compaction", key, got, ok)
+		}
+		c.lock()
+		if c.cache[key].stored.slab != slabs[key] {
+			moved++
+		}
+		c.unlock()
+	}
+	if moved == 0 {
+		t.Error("compaction moved no entry")
+	}
+	if want, _ := slabValue(1, size).Str(); heldStr != want {
+		t.Error("a value read before compaction changed")
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompactionStepsAreBounded(t *testing.T) {
+	const n, size = 6000, 500
+	c := compactCache(t, n, size)
+	// Remove three entries in four under one lock, so that the compaction
+	// they set off starts with all the rest to move.
+	c.lock()
+	for i := range n {
+		if i%4 != 0 {
+			c.remove(c.cache["k"+strconv.Itoa(i)], RemovalDeleted)
+		}
+	}
+	slabs := map[*Node[string, Value]]*valueSlab{}
+	snapshot := func() {
+		for _, node := range c.resident {
+			slabs[node] = node.stored.slab
+		}
+	}
+	snapshot()
+	c.unlock()
+
+	steps, total := 0, 0
+	for {
+		c.lock()
+		moved := 0
+		for _, node := range c.resident {
+			if node.stored.slab != slabs[node] {
+				moved++
+			}
+		}
+		if moved > slabStepNodes {
+			t.Errorf("step %d moved %d entries, over %d", steps, moved, slabStepNodes)
+		}
+		total += moved
+		busy := c.arena.cursor >= 0
+		snapshot()
+		c.unlock()
+		if !busy {
+			break
+		}
+		steps++
+	}
+	if steps < 2 || total == 0 {
+		t.Errorf("compaction took %d steps moving %d entries", steps, total)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEmptySlabsAreDropped(t *testing.T) {
+	c := compactCache(t, 3000, 1000)
+	c.Clear()
+	if st, _ := c.SlabStats(); st.Slabs != 0 || st.Used != 0 {
+		t.Errorf("slabs %+v after Clear", st)
+	}
+	c = compactCache(t, 3000, 1000)
+	// Removing the oldest entries empties the first slabs, which go at
+	// once, without a compaction.
+	for i := range 2000 {
+		c.Remove("k" + strconv.Itoa(i))
+	}
+	if st, _ := c.SlabStats(); st.Slabs > 2 {
+		t.Errorf("slabs %+v after removing the first 2000 entries", st)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompactStorageSession(t *testing.T) {
+	compactStorage = true
+	t.Cleanup(func() { compactStorage = false })
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 3", "OK",
+		"PUT a hello", "OK",
+		"PUT a world", "OK",
+		"APPEND a !", "6",
+		"GET a", "world!",
+		"DEBUG CHECK", "OK",
+	)
+	if got := s.Execute("MEMORY"); !strings.Contains(got, " slabs=1 slab_bytes=16 dead_bytes=10 ") {
+		t.Errorf("MEMORY = %q", got)
+	}
+}
+
+// BenchmarkStorage compares Get and Put on a full cache with the default
+// storage and with compact storage, reporting the heap the entries take
+// and the collector's pauses over the run. It fills 200,000 entries to
+// keep go test quick; --storage-bench runs the same comparison at a
+// million.
+func BenchmarkStorage(b *testing.B) {
+	const n, size = 200_000, storageBenchValue
+	keys := numbered("k", n)
+	for _, compact := range []bool{false, true} {
+		name := "default"
+		if compact {
+			name = "compact"
+		}
+		// setup fills a cache and returns it with the heap its entries
+		// take, in bytes and objects, to be reported once the timed loop
+		// is done: b.ResetTimer drops metrics reported before it.
+		setup := func(b *testing.B) (*LRUCache[string, Value], runtime.MemStats) {
+			compactStorage = compact
+			b.Cleanup(func() { compactStorage = false })
+			var start, filled runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&start)
+			c := NewLRUCache[string, Value](n)
+			if compact {
+				c.SetCompactStorage(Value.storeIn)
+			}
+			for i, key := range keys {
+				c.Put(key, slabValue(i, size))
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&filled)
+			filled.HeapAlloc -= start.HeapAlloc
+			filled.HeapObjects -= start.HeapObjects
+			return c, filled
+		}
+		report := func(b *testing.B, heap, from runtime.MemStats) {
+			var now runtime.MemStats
+			runtime.ReadMemStats(&now)
+			b.ReportMetric(float64(heap.HeapAlloc)/(1<<20), "heap_MB")
+			b.ReportMetric(float64(heap.HeapObjects), "heap_objects")
+			b.ReportMetric(float64(now.PauseTotalNs-from.PauseTotalNs)/1e3, "gc_pause_us")
+		}
+		b.Run(name+"/Get", func(b *testing.B) {
+			c, heap := setup(b)
+			var start runtime.MemStats
+			runtime.ReadMemStats(&start)
+			b.ResetTimer()
+			for i := range b.N {
+				if v, ok := c.Get(keys[i*7919%n]); ok {
+					v.Str()
+				}
+			}
+			report(b, heap, start)
+		})
+		b.Run(name+"/Put", func(b *testing.B) {
+			c, heap := setup(b)
+			values := make([]Value, 1024)
+			for i := range values {
+				values[i] = slabValue(n+i, size)
+			}
+			var start runtime.MemStats
+			runtime.ReadMemStats(&start)
+			b.ResetTimer()
+			for i := range b.N {
+				// New keys, so that each evicts and leaves dead bytes.
+				c.Put("n"+strconv.Itoa(i), values[i%len(values)])
+			}
+			report(b, heap, start)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The storage benchmark compares compact storage with the default, one
+// allocation per value, on a full cache of storageBenchSize entries of
+// storageBenchValue bytes: the heap the entries take, how long a full
+// collection of it takes, the total of the collector's pauses over the
+// run, and Get and Put latency timed as the self-test times them. Puts
+// are of new keys, so each evicts an entry and compact storage has dead
+// bytes to compact as it goes.
+
+const (
+	storageBenchSize  = 1_000_000
+	storageBenchValue = 64
+)
+
+type storageBenchRow struct {
+	storage  string
+	heap     uint64
+	gc       time.Duration // one full collection once filled
+	pauses   time.Duration // over filling and timing
+	get, put time.Duration
+}
+
+// runStorageBench benchmarks both storage modes and writes a table of the
+// results to w.
+func runStorageBench(w io.Writer) {
+	rows := []storageBenchRow{storageBenchWith(false), storageBenchWith(true)}
+	fmt.Fprintf(w, "%-8s %10s %8s %10s %8s %8s\n", "storage", "heap_mb", "gc_ms", "pauses_ms", "get_ns", "put_ns")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%-8s %10.1f %8.2f %10.2f %8d %8d\n", r.storage, float64(r.heap)/(1<<20),
+			float64(r.gc)/float64(time.Millisecond), float64(r.pauses)/float64(time.Millisecond),
+			r.get.Nanoseconds(), r.put.Nanoseconds())
+	}
+}
+
+// storageBenchWith benchmarks a cache with compact storage if compact is
+// set, and the default storage otherwise.
+func storageBenchWith(compact bool) storageBenchRow {
+	compactStorage = compact
+	defer func() { compactStorage = false }()
+	row := storageBenchRow{storage: "default", get: time.Hour, put: time.Hour}
+	if compact {
+		row.storage = "compact"
+	}
+
+	value := func(i int) Value {
+		s := strconv.Itoa(i)
+		return StringValue(s + strings.Repeat("v", storageBenchValue-len(s)))
+	}
+	fresh := make([]string, selftestRounds*selftestBatches*selftestBatch)
+	values := make([]Value, len(fresh))
+	for i := range fresh {
+		fresh[i] = "n" + strconv.Itoa(i)
+		values[i] = value(storageBenchSize + i)
+	}
+	rng := rand.New(rand.NewSource(1))
+	hot := make([]string, selftestHot)
+	for i := range hot {
+		hot[i] = "k" + strconv.Itoa(rng.Intn(storageBenchSize))
+	}
+
+	var start, filled, end runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&start)
+	cache := NewLRUCache[string, Value](storageBenchSize)
+	if compact {
+		cache.SetCompactStorage(Value.storeIn)
+	}
+	for i := range storageBenchSize {
+		cache.Put("k"+strconv.Itoa(i), value(i))
+	}
+	began := time.Now()
+	runtime.GC()
+	row.gc = time.Since(began)
+	runtime.ReadMemStats(&filled)
+	row.heap = filled.HeapAlloc - start.HeapAlloc
+
+	for round := range selftestRounds {
+		row.get = min(row.get, selftestMedian(func(batch int) {
+			for i := range selftestBatch {
+				if v, ok := cache.Get(hot[(batch*selftestBatch+i)%len(hot)]); ok {
+					v.Str()
+				}
+			}
+		}))
+		first := round * selftestBatches * selftestBatch
+		row.put = min(row.put, selftestMedian(func(batch int) {
+			for i := first + batch*selftestBatch; i < first+(batch+1)*selftestBatch; i++ {
+				cache.Put(fresh[i], values[i])
+			}
+		}))
+	}
+	runtime.ReadMemStats(&end)
+	row.pauses = time.Duration(end.PauseTotalNs - start.PauseTotalNs)
+	runtime.KeepAlive(cache)
+	return row
+}
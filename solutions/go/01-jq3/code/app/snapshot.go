@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// snapshotVersion is bumped whenever the snapshot format changes
+// incompatibly.
+const snapshotVersion = 1
+
+// A snapshot is a stream of JSON lines: one snapshotHeader followed by
+// exactly header.Entries snapshotEntry lines in retention order, most
+// recently used first.
+type snapshotHeader struct {
+	Version  int       `json:"version"`
+	Policy   string    `json:"policy"`
+	Capacity int       `json:"capacity"`
+	MaxCost  int       `json:"max_cost,omitempty"`
+	Seq      uint64    `json:"seq,omitempty"`     // number of the last write; see Seq
+	ResetAt  time.Time `json:"reset_at,omitzero"` // see ResetAt
+	Entries  int       `json:"entries"`
+}
+
+type snapshotEntry[K comparable, V any] struct {
+	Key      K             `json:"key"`
+	Value    V             `json:"value"`
+	ExpireAt time.Time     `json:"expire_at,omitzero"`
+	TTL      time.Duration `json:"ttl_ns,omitempty"`
+	Grace    time.Duration `json:"grace_ns,omitempty"`
+	Cost     int           `json:"cost,omitempty"`
+	Pinned   bool          `json:"pinned,omitempty"`
+	Seq      uint64        `json:"seq,omitempty"`
+}
+
+var errBadSnapshot = errors.New("corrupt snapshot")
+
+// SnapshotInfo describes a snapshot written by Snapshot.
+type SnapshotInfo struct {
+	Entries int           `json:"entries"`
+	Pause   time.Duration `json:"pause_ns"` // how long the cache was locked
+}
+
+func (i SnapshotInfo) String() string {
+	return fmt.Sprintf("entries=%d pause=%.3fms", i.Entries, float64(i.Pause)/float64(time.Millisecond))
+}
+
+// Snapshot writes the cache configuration and all live entries to w in
+// retention order, as they were at one instant. Only copying the entries
+// out happens under the lock; they are encoded and written after it is
+// released, so writes are not held up by the encoding and never show up in
+// the snapshot. Values are never modified in place, so the copies stay as
+// they were even if the entries change meanwhile.
+func (c *LRUCache[K, V]) Snapshot(w io.Writer) (SnapshotInfo, error) {
+	start := time.Now()
+	header, entries, _ := c.capture()
+	info := SnapshotInfo{Entries: len(entries), Pause: time.Since(start)}
+	return info, writeSnapshot(w, header, entries)
+}
+
+// writeSnapshot encodes header and entries to w.
+func writeSnapshot[K comparable, V any](w io.Writer, header snapshotHeader, entries []snapshotEntry[K, V]) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// capture returns the snapshot header and a copy of the live entries in
+// retention order, taken under the read lock, along with the time it was
+// taken.
+func (c *LRUCache[K, V]) capture() (snapshotHeader, []snapshotEntry[K, V], time.Time) {
+	c.rlock()
+	defer c.mu.RUnlock()
+
+	// Only weighted caches need costs stored; everywhere else they are
+	// derived from the entry.
+	weighted := c.sizer == nil && c.maxCost > 0
+	now := c.now()
+	entries := make([]snapshotEntry[K, V], 0, len(c.cache))
+	c.each(func(node *Node[K, V]) bool {
+		if !node.expired(now) {
+			e := snapshotEntry[K, V]{Key: node.key, Value: node.value, ExpireAt: node.expireAt, TTL: node.ttl, Grace: node.grace, Pinned: node.pinned, Seq: node.seq}
+			if weighted {
+				e.Cost = node.cost
+			}
+			entries = append(entries, e)
+		}
+		return true
+	})
+	header := snapshotHeader{
+		Version:  snapshotVersion,
+		Policy:   c.policy.Name(),
+		Capacity: c.capacity,
+		MaxCost:  c.maxCost,
+		Seq:      c.writeSeq.Load(),
+		ResetAt:  c.scheduledReset(),
+		Entries:  len(entries),
+	}
+	return header, entries, now
+}
+
+// RestoreOptions change how RestoreWith applies a snapshot.
+type RestoreOptions struct {
+	// Merge keeps the entries already cached, but for those whose keys
+	// the snapshot holds, instead of replacing them all.
+	Merge bool
+	// Cold puts the restored entries at the cold end of the retention
+	// order, to be evicted next unless they are read, rather than at the
+	// hot end. Merged, they go behind every entry already cached, and
+	// only as many as there is room for are restored; otherwise they go
+	// in front, evicting entries already cached to make room.
+	Cold bool
+}
+
+// Restore replaces the cache contents with the entries read from r. The
+// cache keeps its own capacity and policy: entries are replayed from least
+// to most recently used so that LRU and FIFO order is recreated exactly,
+// and only the most recent entries that fit are kept. Entries that expired
+// since the snapshot was taken are dropped. The whole snapshot is read and
+// validated before anything is applied, so a corrupt or truncated input
+// leaves the cache unchanged. Eviction handlers are not called.
+func (c *LRUCache[K, V]) Restore(r io.Reader) error {
+	return c.RestoreWith(r, RestoreOptions{})
+}
+
+// RestoreWith is Restore with options. The snapshot's entries keep their
+// order among themselves either way. LRU, FIFO, SLRU, 2Q and LRU with
+// midpoint insertion can put an entry at the cold end; other policies
+// place the restored entries as they would any insert. Entries a merge
+// evicts to make room are evicted as usual.
+func (c *LRUCache[K, V]) RestoreWith(r io.Reader, opts RestoreOptions) error {
+	dec := json.NewDecoder(r)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("%w: %v", errBadSnapshot, err)
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", errBadSnapshot, header.Version)
+	}
+	if header.Entries < 0 {
+		return fmt.Errorf("%w: negative entry count", errBadSnapshot)
+	}
+	entries := make([]snapshotEntry[K, V], 0, header.Entries)
+	seen := make(map[K]bool, header.Entries)
+	for range header.Entries {
+		var e snapshotEntry[K, V]
+		if err := dec.Decode(&e); err != nil {
+			return fmt.Errorf("%w: %v", errBadSnapshot, err)
+		}
+		if seen[e.Key] {
+			return fmt.Errorf("%w: duplicate key %v", errBadSnapshot, e.Key)
+		}
+		seen[e.Key] = true
+		entries = append(entries, e)
+	}
+
+	c.lock()
+	defer c.unlock()
+
+	c.restoreSeq(header.Seq)
+	if !header.ResetAt.IsZero() {
+		c.setResetAt(header.ResetAt)
+	}
+	if opts.Merge {
+		c.merge(entries, c.now(), opts.Cold)
+	} else {
+		c.replace(entries, c.now(), opts.Cold)
+	}
+	return nil
+}
+
+// fit returns the longest most-recent prefix of entries, given in
+// retention order, that fits in room entries and roomCost of cost, with
+// their costs, dropping entries expired by now. Either limit only applies
+// if the cache has one.
+func (c *LRUCache[K, V]) fit(entries []snapshotEntry[K, V], now time.Time, room, roomCost int) ([]snapshotEntry[K, V], []int) {
+	// Costs are worked out under this cache's rules, not the snapshot's.
+	costs := make([]int, 0, len(entries))
+	kept := entries[:0]
+	total := 0
+	for _, e := range entries {
+		if !e.ExpireAt.IsZero() && !now.Before(e.ExpireAt) {
+			continue
+		}
+		cost := 1
+		switch {
+		case c.sizer != nil:
+			cost = c.sizer(e.Key, e.Value)
+		case c.maxCost > 0 && e.Cost > 0:
+			cost = e.Cost
+		}
+		if c.capacity > 0 && len(kept) >= room {
+			break
+		}
+		if c.maxCost > 0 && total+cost > roomCost {
+			break
+		}
+		total += cost
+		kept = append(kept, e)
+		costs = append(costs, cost)
+	}
+	return kept, costs
+}
+
+// replace swaps the cache contents for entries, given in retention order,
+// keeping the longest most-recent prefix that fits and dropping entries
+// expired by now. It must be called with the write lock held.
+func (c *LRUCache[K, V]) replace(entries []snapshotEntry[K, V], now time.Time, cold bool) {
+	kept, costs := c.fit(entries, now, c.capacity, c.maxCost)
+
+	c.cache = make(map[K]*Node[K, V], len(kept))
+	c.unshareAll()
+	c.emptyLower()
+	c.resetResident()
+	c.policy.Reset()
+	c.pinned.init()
+	c.lowTier.init()
+	c.highTier.init()
+	c.resetTenants()
+	c.clearTombstones()
+	c.usedCost, c.pinnedCost, c.savedBytes = 0, 0, 0
+	c.resetSlabs()
+	if p := c.profile.Load(); p != nil {
+		p.clear()
+	}
+	c.insertRestored(kept, costs, now, cold)
+}
+
+// merge adds entries, given in retention order, to the cache contents,
+// replacing the entries of the same keys, at the cold end if cold is set
+// and otherwise at the hot end. It must be called with the write lock
+// held.
+func (c *LRUCache[K, V]) merge(entries []snapshotEntry[K, V], now time.Time, cold bool) {
+	for _, e := range entries {
+		if node, ok := c.cache[e.Key]; ok {
+			c.unlink(node)
+			c.release(node)
+		}
+		if c.l2 != nil {
+			c.l2.take(e.Key)
+		}
+		c.forget(e.Key)
+	}
+	room, roomCost := c.capacity, c.maxCost
+	if cold {
+		room, roomCost = c.capacity-len(c.cache), c.maxCost-c.usedCost
+	}
+	kept, costs := c.fit(entries, now, room, roomCost)
+	c.insertRestored(kept, costs, now, cold)
+	c.evictOverflow(nil)
+}
+
+// insertRestored adds a node for each of entries, given in retention order
+// with their costs, keeping that order, either in front of the entries
+// already cached or, if cold is set and the policy can, behind them.
+func (c *LRUCache[K, V]) insertRestored(entries []snapshotEntry[K, V], costs []int, now time.Time, cold bool) {
+	behind, _ := c.policy.(coldInserter[K, V])
+	if !cold {
+		behind = nil
+	}
+	for n := range entries {
+		// Entries go in from the least recently used forward, unless
+		// they go in behind, from the most recently used back.
+		i := len(entries) - 1 - n
+		if behind != nil {
+			i = n
+		}
+		e := entries[i]
+		node := &Node[K, V]{key: e.Key, value: e.Value, expireAt: e.ExpireAt, ttl: e.TTL, grace: e.Grace, cost: costs[i], version: 1, seq: e.Seq, createdAt: now, accessedAt: now, writtenAt: now, priority: PriorityNormal}
+		c.used(node, now)
+		c.cache[e.Key] = node
+		c.share(node)
+		c.addResident(node)
+		c.store(node)
+		c.tag(node)
+		c.chargeSaved(node)
+		switch {
+		case e.Pinned && behind != nil:
+			node.pinned = true
+			c.pinned.pushBack(node)
+			c.pinnedCost += node.cost
+		case e.Pinned:
+			node.pinned = true
+			c.pinned.pushFront(node)
+			c.pinnedCost += node.cost
+		case behind != nil:
+			behind.AddCold(node)
+		default:
+			c.policy.Add(node)
+		}
+		c.usedCost += node.cost
+	}
+}
+
+// saveSnapshot writes a snapshot of cache to path, replacing the file only
+// once the snapshot has been written completely.
+func saveSnapshot(cache *LRUCache[string, Value], path string) (SnapshotInfo, error) {
+	var info SnapshotInfo
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		var err error
+		info, err = cache.Snapshot(w)
+		return err
+	})
+	return info, err
+}
+
+// writeFileAtomic writes path with write, through a temporary file that
+// replaces it only once write has succeeded.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadSnapshot restores cache from the snapshot at path.
+func loadSnapshot(cache *LRUCache[string, Value], path string, opts RestoreOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return cache.RestoreWith(f, opts)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadModesPlaceEntries(t *testing.T) {
+	snap := filepath.Join(t.TempDir(), "s.snapshot")
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 4", "OK",
+		"PUT s1 1", "OK",
+		"PUT s2 2", "OK",
+		"PUT s3 3", "OK",
+	)
+	if got := s.Execute("SAVE " + snap); !strings.HasPrefix(got, "OK entries=3 ") {
+		t.Fatalf("SAVE: %q", got)
+	}
+
+	for _, tc := range []struct {
+		opts string
+		// loadEvicts is what the LOAD itself evicts, keys what it leaves,
+		// most recent first, and putEvicts what two PUTs then evict.
+		loadEvicts, keys, putEvicts string
+	}{
+		{"", "", "s3 s2 s1", "s1"},
+		// With nothing else cached, the cold end is the only end.
+		{" COLD", "", "s3 s2 s1", "s1"},
+		// The snapshot goes in front, pushing out a.
+		{" MERGE", "a", "s3 s2 s1 b", "b s1"},
+		// The snapshot goes behind a and b, only as far as there is room,
+		// and is the first to go.
+		{" MERGE COLD", "", "b a s3 s2", "s2 s3"},
+	} {
+		s := newTestSession(t)
+		stderr := captureStderr(t)
+		script(t, s,
+			"INIT 4", "OK",
+			"PUT a 1", "OK",
+			"PUT b 2", "OK",
+			"LOAD "+snap+tc.opts, "OK",
+			"KEYS", tc.keys,
+		)
+		loaded := len(stderr.String())
+		script(t, s,
+			"PUT x 1", "OK",
+			"PUT y 1", "OK",
+			"DEBUG CHECK", "OK",
+		)
+		evicts := func(lines string) string {
+			return strings.Join(strings.Fields(strings.ReplaceAll(lines, "EVICT ", "")), " ")
+		}
+		if got := evicts(stderr.String()[:loaded]); got != tc.loadEvicts {
+			t.Errorf("LOAD%s evicted %q, want %q", tc.opts, got, tc.loadEvicts)
+		}
+		if got := evicts(stderr.String()[loaded:]); got != tc.putEvicts {
+			t.Errorf("after LOAD%s, PUTs evicted %q, want %q", tc.opts, got, tc.putEvicts)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With a delete retention, every key explicitly removed, by Remove,
+// GetAndDelete, DeletePrefix, RemoveOldest, Clear or Compute, leaves a
+// tombstone like those of negative caching for that long, numbered in the
+// order of deletion, so that Deleted can tell another instance what to
+// remove to mirror this one. Lookups see the key as missing as soon as it
+// is deleted. The tombstones take up room and go first when it is needed,
+// as negative caching's do, so under pressure a deletion may be forgotten
+// before its time; and writing the key again drops its tombstone. The
+// janitor drops tombstones that have outlived their time, and Deleted
+// never lists them.
+
+// Deletion is a key Deleted reports as deleted.
+type Deletion[K comparable] struct {
+	Seq uint64    `json:"seq"`
+	Key K         `json:"key"`
+	At  time.Time `json:"deleted_at"`
+}
+
+// SetDeleteRetention sets how long deleted keys keep a tombstone; 0 turns
+// this off and drops them.
+func (c *LRUCache[K, V]) SetDeleteRetention(d time.Duration) {
+	c.lock()
+	defer c.unlock()
+
+	c.deleteRetention = max(d, 0)
+	if c.deleteRetention == 0 {
+		c.dropTombstonesIf(func(t tombstone[K]) bool { return t.seq != 0 })
+	}
+}
+
+// markDeleted leaves a tombstone for key, just removed, if deletions are
+// retained. It never evicts an entry: without room, older tombstones go,
+// down to the new one itself.
+func (c *LRUCache[K, V]) markDeleted(key K) {
+	if c.deleteRetention == 0 {
+		return
+	}
+	if elem, ok := c.negative[key]; ok {
+		c.dropTombstone(elem)
+	}
+	now := c.now()
+	c.deleteSeq++
+	var zero V
+	c.pushTombstone(tombstone[K]{key: key, expireAt: now.Add(c.deleteRetention), cost: c.defaultCost(key, zero), seq: c.deleteSeq, deletedAt: now})
+	for c.overBudget() && c.evictTombstone() {
+	}
+}
+
+// Deleted returns the keys deleted after the one numbered since whose
+// tombstones are still retained, in the order they were deleted.
+func (c *LRUCache[K, V]) Deleted(since uint64) []Deletion[K] {
+	c.lock()
+	defer c.unlock()
+
+	var deleted []Deletion[K]
+	if c.tombstones == nil {
+		return deleted
+	}
+	now := c.now()
+	for elem := c.tombstones.Front(); elem != nil; elem = elem.Next() {
+		t := elem.Value.(tombstone[K])
+		if t.seq > since && now.Before(t.expireAt) {
+			deleted = append(deleted, Deletion[K]{Seq: t.seq, Key: t.key, At: t.deletedAt})
+		}
+	}
+	return deleted
+}
+
+// sweepTombstones drops the expired tombstones among the n oldest and
+// returns how many it looked at.
+func (c *LRUCache[K, V]) sweepTombstones(n int) int {
+	c.lock()
+	defer c.unlock()
+
+	if c.readOnly || c.tombstones == nil {
+		return 0
+	}
+	now := c.now()
+	examined := 0
+	for elem := c.tombstones.Front(); elem != nil && examined < n; examined++ {
+		next := elem.Next()
+		if !now.Before(elem.Value.(tombstone[K]).expireAt) {
+			c.dropTombstone(elem)
+		}
+		elem = next
+	}
+	return examined
+}
+
+func (s *session) cmdTombstones(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[1] != "ON" && parts[1] != "OFF" {
+		out.Error(CodeArity, "TOMBSTONES requires ON <seconds> or OFF")
+		return
+	}
+	if parts[1] == "OFF" {
+		s.cache.SetDeleteRetention(0)
+		out.OK()
+		return
+	}
+	if len(parts) < 3 {
+		out.Error(CodeArity, "TOMBSTONES ON requires seconds argument")
+		return
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil || !(seconds > 0) || seconds > math.MaxInt64/float64(time.Second) {
+		out.Errorf(CodeInvalid, "Invalid retention: %s", parts[2])
+		return
+	}
+	s.cache.SetDeleteRetention(time.Duration(seconds * float64(time.Second)))
+	out.OK()
+}
+
+func (s *session) cmdDeleted(in *bufio.Reader, out *reply, line string, parts []string) {
+	since, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid sequence number: %s", parts[1])
+		return
+	}
+	deleted := s.cache.Deleted(since)
+	if len(deleted) == 0 {
+		out.Result("EMPTY", []any{})
+		return
+	}
+	lines := make([]string, len(deleted))
+	for i, d := range deleted {
+		lines[i] = fmt.Sprintf("%d %s", d.Seq, d.Key)
+	}
+	out.Result(strings.Join(lines, "\n"), deleted)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxSplit bounds the number of files SPLIT writes.
+const maxSplit = 1024
+
+// ShardOf returns the shard, from 0 to n-1, that SPLIT assigns key to: the
+// 64-bit FNV-1a hash of the key's bytes, modulo n. The mapping depends on
+// nothing but the key and n, so a router in front of the caches a SPLIT
+// feeds can compute it too. n must be positive.
+func ShardOf(key string, n int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() % uint64(n))
+}
+
+// SplitSnapshot writes the live entries to len(ws) snapshots, each entry
+// to the one shard picks, as they were at one instant. Each snapshot is in
+// the format Snapshot writes and keeps its entries in retention order, and
+// returns how many entries each got.
+func (c *LRUCache[K, V]) SplitSnapshot(ws []io.Writer, shard func(key K) int) ([]int, error) {
+	header, entries, _ := c.capture()
+	parts := make([][]snapshotEntry[K, V], len(ws))
+	for _, e := range entries {
+		i := shard(e.Key)
+		parts[i] = append(parts[i], e)
+	}
+	counts := make([]int, len(ws))
+	for i, w := range ws {
+		header.Entries = len(parts[i])
+		counts[i] = len(parts[i])
+		if err := writeSnapshot(w, header, parts[i]); err != nil {
+			return counts, err
+		}
+	}
+	return counts, nil
+}
+
+// splitPath returns the file SPLIT writes shard i of n to in dir.
+func splitPath(dir string, i, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("shard-%d-of-%d.snapshot", i, n))
+}
+
+// splitSnapshot writes cache to n snapshots in dir, created if need be,
+// and returns how many entries each got. Like SAVE it writes temporary
+// files first, which replace the snapshots only once all are complete.
+func splitSnapshot(cache *LRUCache[string, Value], n int, dir string) ([]int, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var files []*os.File
+	// abandon closes, if need be, and removes the temporary files created
+	// so far.
+	abandon := func() {
+		for i, f := range files {
+			f.Close()
+			os.Remove(splitPath(dir, i, n) + ".tmp")
+		}
+	}
+	ws := make([]io.Writer, n)
+	for i := range ws {
+		f, err := os.Create(splitPath(dir, i, n) + ".tmp")
+		if err != nil {
+			abandon()
+			return nil, err
+		}
+		files = append(files, f)
+		ws[i] = f
+	}
+	counts, err := cache.SplitSnapshot(ws, func(key string) int { return ShardOf(key, n) })
+	if err != nil {
+		abandon()
+		return nil, err
+	}
+	for _, f := range files {
+		if err := f.Close(); err != nil {
+			abandon()
+			return nil, err
+		}
+	}
+	for i := range n {
+		if err := os.Rename(splitPath(dir, i, n)+".tmp", splitPath(dir, i, n)); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+func (s *session) cmdSplit(in *bufio.Reader, out *reply, line string, parts []string) {
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n < 1 || n > maxSplit {
+		out.Errorf(CodeInvalid, "Invalid shard count: %s (1 to %d)", parts[1], maxSplit)
+		return
+	}
+	counts, err := splitSnapshot(s.cache, n, parts[2])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	lines := make([]string, n)
+	list := make([]map[string]any, n)
+	for i, count := range counts {
+		path := splitPath(parts[2], i, n)
+		lines[i] = fmt.Sprintf("%s %d", path, count)
+		list[i] = map[string]any{"path": path, "entries": count}
+	}
+	out.Result(strings.Join(lines, "\n"), list)
+}
@@ -0,0 +1,72 @@
+package main
+
+import "time"
+
+// An entry stored with a grace period is not removed when its TTL runs out.
+// For the grace period after that Get and every other read treat it as
+// missing, but GetStale still returns it, marked stale, so that callers can
+// keep serving a popular key while one of them fetches a fresh value. Once
+// the grace period is over too the entry is gone for everyone.
+
+// PutWithGrace is PutWithTTL for an entry that GetStale may still return
+// for grace after it expires. The grace period only applies along with a
+// positive ttl.
+func (c *LRUCache[K, V]) PutWithGrace(key K, value V, ttl, grace time.Duration) error {
+	key = c.normalize(key)
+	if l := c.latency.Load(); l != nil && l.put.sample(l.every) {
+		defer l.put.since(time.Now())
+	}
+	c.lock()
+	defer c.unlock()
+
+	if err := c.put(key, value, ttl, c.defaultCost(key, value)); err != nil {
+		return err
+	}
+	if node, ok := c.cache[key]; ok && ttl > 0 {
+		node.grace = max(grace, 0)
+	}
+	return nil
+}
+
+// GetStale returns the value for key like Get, and also during the grace
+// period of an expired entry, in which case stale is true and the entry is
+// not promoted. The first GetStale to see an entry stale also gets
+// revalidate true: that caller is the one expected to refresh the key, and
+// the entry stays marked as being revalidated until it is written again.
+// Serving a stale value counts as a hit.
+func (c *LRUCache[K, V]) GetStale(key K) (value V, stale, revalidate, ok bool) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	now := c.now()
+	node, found := c.cache[key]
+	if !found || !node.expired(now) || node.gone(now) {
+		value, ok = c.get(key)
+		return value, false, false, ok
+	}
+	if c.readOnly {
+		c.hit()
+		return node.value, true, false, true
+	}
+	c.recordAccess(key)
+	c.hit()
+	node.hits++
+	revalidate = !node.revalidating
+	node.revalidating = true
+	return node.value, true, revalidate, true
+}
+
+// gone reports whether node has expired and is past its grace period too.
+// Idle entries have no grace period.
+func (n *Node[K, V]) gone(now time.Time) bool {
+	return n.idle(now) || (!n.expireAt.IsZero() && !now.Before(n.expireAt.Add(n.grace)))
+}
+
+// reap removes the entry for key if it has expired, even within its grace
+// period, so that a new entry can take its place.
+func (c *LRUCache[K, V]) reap(key K) {
+	if node, ok := c.cache[key]; ok && node.expired(c.now()) {
+		c.remove(node, node.expiry(c.now()))
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runScript runs input through s as the stdin stream and returns what it
+// wrote.
+func runScript(t testing.TB, s *session, input string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := s.run(strings.NewReader(input), &out)
+	return out.String(), err
+}
+
+func TestHugeLine(t *testing.T) {
+	value := strings.Repeat("x", 1<<20)
+	s := newTestSession(t)
+	got, err := runScript(t, s, "INIT 2\nPUT big "+value+"\nGET big\nSIZE\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "OK\nOK\n" + value + "\n1\n"; got != want {
+		t.Errorf("output of %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestMaxLineBytes(t *testing.T) {
+	s := newTestSession(t)
+	s.maxLine = 100
+	long := strings.Repeat("y", 200)
+	got, err := runScript(t, s, "INIT 2\nPUT a "+long+"\nPUT b short\nGET a\nGET b\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "OK\nERROR ERR_TOO_LARGE Line exceeds 100 bytes\nOK\nNULL\nshort\n"
+	if got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+}
+
+func TestLastLineWithoutNewline(t *testing.T) {
+	s := newTestSession(t)
+	got, _ := runScript(t, s, "INIT 2\r\nPUT a 1\r\nGET a")
+	if got != "OK\nOK\n1\n" {
+		t.Errorf("output %q", got)
+	}
+}
+
+// writeCounter counts the writes made to it.
+type writeCounter struct {
+	writes int
+	bytes.Buffer
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestPipedScriptIsBuffered(t *testing.T) {
+	var script strings.Builder
+	script.WriteString("INIT 100\n")
+	for i := range 1000 {
+		fmt.Fprintf(&script, "PUT k%d %d\n", i, i)
+	}
+	for _, unbuffered := range []bool{false, true} {
+		s := newTestSession(t)
+		s.unbuffered = unbuffered
+		w := &writeCounter{}
+		if err := s.run(strings.NewReader(script.String()), w); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Count(w.String(), "OK\n"); got != 1001 {
+			t.Errorf("unbuffered=%v: %d responses", unbuffered, got)
+		}
+		if unbuffered && w.writes != 1001 {
+			t.Errorf("unbuffered: %d writes, want one per response", w.writes)
+		}
+		if !unbuffered && w.writes > 2 {
+			t.Errorf("buffered: %d writes for a script already at hand", w.writes)
+		}
+	}
+}
+
+// TestInteractiveClientGetsEachResponse plays a client that waits for each
+// response before it sends the next command, over pipes both ways: if a
+// response stayed in the buffer the client would wait forever.
+func TestInteractiveClientGetsEachResponse(t *testing.T) {
+	for _, unbuffered := range []bool{false, true} {
+		s := newTestSession(t)
+		s.unbuffered = unbuffered
+		inR, inW := io.Pipe()
+		outR, outW := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			done <- s.run(inR, outW)
+			outW.Close()
+		}()
+
+		responses := bufio.NewReader(outR)
+		for _, step := range [][2]string{
+			{"INIT 2", "OK"},
+			{"PUT a 1", "OK"},
+			{"GET a", "1"},
+			{"PUT b 2; GET b", "OK\n2"},
+			{"SIZE", "2"},
+		} {
+			if _, err := io.WriteString(inW, step[0]+"\n"); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for range strings.Count(step[1], "\n") + 1 {
+				line, err := readWithin(t, responses, 5*time.Second)
+				if err != nil {
+					t.Fatalf("unbuffered=%v: %s: %v", unbuffered, step[0], err)
+				}
+				got = append(got, line)
+			}
+			if strings.Join(got, "\n") != step[1] {
+				t.Errorf("unbuffered=%v: %s: got %q, want %q", unbuffered, step[0], got, step[1])
+			}
+		}
+		inW.Close()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// readWithin reads a line from r, failing if none arrives within d.
+func readWithin(t *testing.T, r *bufio.Reader, d time.Duration) (string, error) {
+	t.Helper()
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := r.ReadString('\n')
+		ch <- result{strings.TrimSuffix(line, "\n"), err}
+	}()
+	select {
+	case res := <-ch:
+		return res.line, res.err
+	case <-time.After(d):
+		t.Fatal("no response: it is stuck in the output buffer")
+		return "", nil
+	}
+}
+
+// BenchmarkRunScript runs a script of puts and gets through the session,
+// flushing as a piped script is flushed and after every response, into a
+// writer that costs a system call per write.
+func BenchmarkRunScript(b *testing.B) {
+	var script strings.Builder
+	script.WriteString("INIT 1000\n")
+	for i := range 10000 {
+		fmt.Fprintf(&script, "PUT k%d %d\nGET k%d\n", i%2000, i, (i*7)%2000)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	for _, unbuffered := range []bool{false, true} {
+		b.Run(fmt.Sprintf("unbuffered=%v", unbuffered), func(b *testing.B) {
+			s := newTestSession(b)
+			s.unbuffered = unbuffered
+			b.SetBytes(int64(script.Len()))
+			for b.Loop() {
+				if err := s.run(strings.NewReader(script.String()), devNull); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+// runStress hammers cache from the given number of goroutines, each issuing
+// ops random operations over a key space twice the cache's capacity so that
+// gets, puts, removals and evictions all interleave. Byte-bounded caches
+// have no entry capacity, so they get a fixed key space instead.
+func runStress(cache *LRUCache[string, Value], goroutines, ops int) {
+	keySpace := cache.Stats().Capacity * 2
+	if keySpace == 0 {
+		keySpace = 128
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < ops; i++ {
+				key := "k" + strconv.Itoa(rng.Intn(keySpace))
+				switch n := rng.Intn(10); {
+				case n < 5:
+					cache.Get(key)
+				case n < 9:
+					cache.Put(key, StringValue(strconv.Itoa(i)))
+				default:
+					cache.Remove(key)
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Run with -race: gets, puts, removals and evictions from many goroutines
+// at once must leave the cache consistent and never over capacity.
+func TestConcurrentOps(t *testing.T) {
+	const (
+		capacity   = 64
+		goroutines = 16
+		ops        = 5000
+	)
+	c := NewLRUCache[string, int](capacity)
+	var gets atomic.Int64
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	watcher := make(chan int)
+	go func() {
+		worst := 0
+		for {
+			select {
+			case <-done:
+				watcher <- worst
+				return
+			default:
+				worst = max(worst, c.Size())
+			}
+		}
+	}()
+	for g := range goroutines {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := range ops {
+				key := "k" + strconv.Itoa(rng.Intn(capacity*3))
+				switch n := rng.Intn(10); {
+				case n < 5:
+					gets.Add(1)
+					c.Get(key)
+				case n < 9:
+					c.Put(key, i)
+				default:
+					c.Remove(key)
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	close(done)
+	if worst := <-watcher; worst > capacity {
+		t.Errorf("size reached %d, over capacity %d", worst, capacity)
+	}
+	stats := c.Stats()
+	if got := int64(stats.Hits + stats.Misses); got != gets.Load() {
+		t.Errorf("hits+misses = %d, want %d gets", got, gets.Load())
+	}
+	if stats.Evictions == 0 {
+		t.Error("no evictions: the key space is too small to test them")
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStressCommand(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"STRESS 4 100", "ERROR ERR_NOT_INITIALIZED Cache not initialized",
+		"INIT 10", "OK",
+		"STRESS 0 100", "ERROR ERR_INVALID Invalid goroutines: 0",
+		"STRESS 4 x", "ERROR ERR_INVALID Invalid ops: x",
+	)
+	got := s.Execute("STRESS 8 2000")
+	var size, hits, misses, evictions int
+	if _, err := fmt.Sscanf(got, "OK size=%d hits=%d misses=%d evictions=%d", &size, &hits, &misses, &evictions); err != nil {
+		t.Fatalf("STRESS 8 2000 = %q: %v", got, err)
+	}
+	if size > 10 || hits+misses == 0 || evictions == 0 {
+		t.Errorf("STRESS 8 2000 = %q", got)
+	}
+	if err := s.cache.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
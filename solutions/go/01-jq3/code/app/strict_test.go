@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrictAndFailFast(t *testing.T) {
+	const (
+		noErrors   = "INIT 2\nPUT a 1\nEXISTS a\nGET missing\nSIZE\n"
+		earlyError = "INIT 2\nBOGUS\nPUT a 1\nEXISTS a\nSIZE\n"
+		lateError  = "INIT 2\nPUT a 1\nEXISTS a\nSIZE\nINCR a x\n"
+	)
+	for _, tc := range []struct {
+		name, script string
+		strict       bool
+		failFast     bool
+		want         error
+		ran          int // commands that gave a response
+	}{
+		{"no errors, strict", noErrors, true, false, nil, 5},
+		{"no errors, fail-fast", noErrors, false, true, nil, 5},
+		{"early error", earlyError, false, false, nil, 5},
+		{"early error, strict", earlyError, true, false, errStrict, 5},
+		{"early error, fail-fast", earlyError, false, true, errFailFast, 2},
+		{"early error, both", earlyError, true, true, errFailFast, 2},
+		{"late error, strict", lateError, true, false, errStrict, 5},
+		{"late error, fail-fast", lateError, false, true, errFailFast, 5},
+	} {
+		for _, mode := range []string{"text", "json", "framed"} {
+			s := newTestSession(t)
+			s.strict, s.failFast = tc.strict, tc.failFast
+			s.json, s.framed = mode == "json", mode == "framed"
+			out, err := runScript(t, s, tc.script)
+			if err != tc.want {
+				t.Errorf("%s, %s: run returned %v, want %v", tc.name, mode, err, tc.want)
+			}
+			// Every command here gives one line, whatever the mode: no value
+			// is read, which framed mode would send in two.
+			if n := strings.Count(out, "\n"); n != tc.ran {
+				t.Errorf("%s, %s: %d responses, want %d:\n%s", tc.name, mode, n, tc.ran, out)
+			}
+		}
+	}
+}
+
+func TestStrictExitStatus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the program")
+	}
+	for _, tc := range []struct {
+		args   []string
+		script string
+		status int
+		last   string
+	}{
+		{[]string{"--strict"}, "INIT 2\nGET x\n", 0, "NULL"},
+		{[]string{"--strict"}, "INIT 2\nBOGUS\nPUT a 1\n", 1, "OK"},
+		{[]string{"--fail-fast"}, "INIT 2\nBOGUS\nPUT a 1\n", 2, "ERROR ERR_UNKNOWN_COMMAND Unknown command: BOGUS"},
+		{[]string{"--fail-fast", "--json"}, "INIT 2\nBOGUS\nPUT a 1\n", 2, `{"status":"error","message":"Unknown command: BOGUS","code":"ERR_UNKNOWN_COMMAND"}`},
+		{nil, "INIT 2\nBOGUS\n", 0, "ERROR ERR_UNKNOWN_COMMAND Unknown command: BOGUS"},
+	} {
+		out, status := runMain(t, tc.script, tc.args...)
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		if status != tc.status {
+			t.Errorf("%v: exit status %d, want %d", tc.args, status, tc.status)
+		}
+		if lines[len(lines)-1] != tc.last {
+			t.Errorf("%v: last line %q, want %q", tc.args, lines[len(lines)-1], tc.last)
+		}
+	}
+}
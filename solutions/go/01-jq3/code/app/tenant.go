@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync/atomic"
+)
+
+// ErrBadQuota is returned by SetTenantQuota for a share outside 0 to 100.
+var ErrBadQuota = errors.New("tenant quota must be a percentage from 0 to 100")
+
+// Tenants keep clients sharing a cache from evicting one another's data.
+// Each new entry belongs to the tenant named by the last SetTenant, or to
+// the default pool if that is "". A tenant's entries are taken out of the
+// policy, as pinned ones are, and kept on its own list, most recently used
+// first, so they are never the policy's victims. With a quota, a tenant
+// holding more than its share of the budget, counted in entries, bytes or
+// weight as the cache is bounded, loses its own least recently used
+// entries until it is back within it, whatever else the cache holds; room
+// for any other write comes from the default pool first, and from tenants
+// only once that is empty, the writing tenant first and then whichever
+// holds most. A tenant within its quota therefore keeps its entries while
+// the quotas add up to no more than the whole cache.
+//
+// Tenancy trumps priority: a tenant's entry keeps its priority but is
+// ordered on its tenant's list, unless it is pinned. An entry keeps the
+// tenant that created it when others write it. Snapshots, exports and the
+// AOF do not record tenants, so restored entries are in the default pool.
+
+type tenantPool[K comparable, V any] struct {
+	entries *nodeList[K, V] // unpinned entries, most recently used first
+	count   int             // entries, pinned ones included
+	cost    int             // their cost
+	// hits and misses count the Gets made for the tenant, which may read
+	// entries of any tenant. They are counted under the read lock.
+	hits, misses atomic.Int64
+}
+
+// TenantStats describes one tenant in Stats. Used is in the unit of the
+// cache's budget, entries, bytes or weight, and Quota is the most of it
+// the tenant may hold, or 0 without a quota.
+type TenantStats struct {
+	Size   int `json:"size"`
+	Used   int `json:"used"`
+	Quota  int `json:"quota"`
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+}
+
+// SetTenant names the tenant the calls that follow are made for: their new
+// entries are its own and their Gets count in its Stats. "" is the default
+// pool. Callers acting for several tenants at once must serialize their
+// calls around it.
+func (c *LRUCache[K, V]) SetTenant(name string) {
+	c.lock()
+	defer c.unlock()
+
+	c.caller = name
+	if name != "" && c.tenants[name] == nil {
+		if c.tenants == nil {
+			c.tenants = make(map[string]*tenantPool[K, V])
+		}
+		c.tenants[name] = &tenantPool[K, V]{entries: newNodeList[K, V]()}
+	}
+}
+
+// SetTenantQuota sets the share of the budget, in percent, every tenant
+// may hold; 0 lifts the quota. Tenants over a lowered quota lose entries
+// at once.
+func (c *LRUCache[K, V]) SetTenantQuota(percent int) error {
+	if percent < 0 || percent > 100 {
+		return ErrBadQuota
+	}
+	c.lock()
+	defer c.unlock()
+
+	c.tenantQuota = percent
+	c.evictOverflow(nil)
+	return nil
+}
+
+// quota returns the most a tenant may hold, or 0 for no limit.
+func (c *LRUCache[K, V]) quota() int {
+	if c.tenantQuota == 0 {
+		return 0
+	}
+	return max(1, c.budget()*c.tenantQuota/100)
+}
+
+// usage returns what p holds in the unit of the budget.
+func (c *LRUCache[K, V]) usage(p *tenantPool[K, V]) int {
+	if c.maxCost > 0 {
+		return p.cost
+	}
+	return p.count
+}
+
+// tenantNames returns the tenants in a fixed order, so that eviction and
+// iteration do not depend on map order.
+func (c *LRUCache[K, V]) tenantNames() []string {
+	return slices.Sorted(maps.Keys(c.tenants))
+}
+
+// chargeTenant adds n entries of the given cost to node's tenant.
+func (c *LRUCache[K, V]) chargeTenant(node *Node[K, V], n, cost int) {
+	if p := c.tenants[node.tenant]; p != nil {
+		p.count += n
+		p.cost += cost
+	}
+}
+
+// countTenant counts a Get for the calling tenant.
+func (c *LRUCache[K, V]) countTenant(hit bool) {
+	p := c.tenants[c.caller]
+	switch {
+	case p == nil:
+	case hit:
+		p.hits.Add(1)
+	default:
+		p.misses.Add(1)
+	}
+}
+
+// evictTenants evicts the least recently used entries, other than keep, of
+// every tenant over its quota until each is within it or has only pinned
+// entries left, and returns how many it evicted.
+func (c *LRUCache[K, V]) evictTenants(keep *Node[K, V]) int {
+	limit := c.quota()
+	if limit == 0 || len(c.tenants) == 0 {
+		return 0
+	}
+	evicted := 0
+	for _, name := range c.tenantNames() {
+		p := c.tenants[name]
+		for c.usage(p) > limit {
+			victim := p.entries.backExcept(keep)
+			if victim == nil {
+				break
+			}
+			p.entries.remove(victim)
+			c.evict(victim)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// tenantVictim takes the next victim other than keep off the tenants'
+// lists, once nothing else is left to evict: the calling tenant's least
+// recently used entry, or failing that the one of the tenant holding most.
+func (c *LRUCache[K, V]) tenantVictim(keep *Node[K, V]) *Node[K, V] {
+	var from *tenantPool[K, V]
+	if p := c.tenants[c.caller]; p != nil && p.entries.backExcept(keep) != nil {
+		from = p
+	} else {
+		for _, name := range c.tenantNames() {
+			p := c.tenants[name]
+			if p.entries.backExcept(keep) != nil && (from == nil || c.usage(p) > c.usage(from)) {
+				from = p
+			}
+		}
+	}
+	if from == nil {
+		return nil
+	}
+	node := from.entries.backExcept(keep)
+	from.entries.remove(node)
+	return node
+}
+
+// eachTenant visits the unpinned entries of every tenant, tenant by tenant,
+// most recently used first, and reports whether fn asked to stop.
+func (c *LRUCache[K, V]) eachTenant(fn func(node *Node[K, V]) bool) bool {
+	for _, name := range c.tenantNames() {
+		stopped := false
+		c.tenants[name].entries.each(func(node *Node[K, V]) bool {
+			stopped = !fn(node)
+			return !stopped
+		})
+		if stopped {
+			return true
+		}
+	}
+	return false
+}
+
+// resetTenants empties every tenant when the cache is cleared or replaced.
+func (c *LRUCache[K, V]) resetTenants() {
+	for _, p := range c.tenants {
+		p.entries.init()
+		p.count, p.cost = 0, 0
+	}
+}
+
+func (c *LRUCache[K, V]) tenantStats() map[string]TenantStats {
+	if len(c.tenants) == 0 {
+		return nil
+	}
+	stats := make(map[string]TenantStats, len(c.tenants))
+	for name, p := range c.tenants {
+		stats[name] = TenantStats{Size: p.count, Used: c.usage(p), Quota: c.quota(), Hits: int(p.hits.Load()), Misses: int(p.misses.Load())}
+	}
+	return stats
+}
+
+// checkTenants verifies each tenant's list and totals; see DebugCheck.
+func (c *LRUCache[K, V]) checkTenants() error {
+	for _, name := range c.tenantNames() {
+		p := c.tenants[name]
+		if err := p.entries.check("tenant " + name); err != nil {
+			return err
+		}
+		var err error
+		p.entries.each(func(node *Node[K, V]) bool {
+			if node.pinned || node.tenant != name {
+				err = fmt.Errorf("node %v is on the list of tenant %s but is pinned or of tenant %q", node.key, name, node.tenant)
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		count, cost := 0, 0
+		for _, node := range c.resident {
+			if node.tenant == name {
+				count++
+				cost += node.cost
+			}
+		}
+		if count != p.count || cost != p.cost {
+			return fmt.Errorf("tenant %s holds %d entries of cost %d but counts %d of cost %d", name, count, cost, p.count, p.cost)
+		}
+	}
+	return nil
+}
+
+// With --tenant-quota each line protocol connection acts for the tenant it
+// names with TENANT, and RESP and HTTP requests for the default pool. As
+// the cache only knows one caller at a time, commands that use the cache
+// run one at a time.
+
+// asTenant makes the cache's calls until the returned function is called
+// count for the tenant name. It does nothing without --tenant-quota.
+func (s *session) asTenant(name string) func() {
+	if s.tenantQuota == 0 {
+		return func() {}
+	}
+	s.tenantMu.Lock()
+	s.cache.SetTenant(name)
+	return s.tenantMu.Unlock
+}
+
+// defaultTenant runs each HTTP request for the default pool.
+func (s *session) defaultTenant(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer s.asTenant("")()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// cmdTenant sets or reports the connection's tenant.
+func (s *session) cmdTenant(in *bufio.Reader, out *reply, line string, parts []string) {
+	if s.tenantQuota == 0 {
+		out.Error(CodeNotAllowed, "TENANT requires --tenant-quota")
+		return
+	}
+	if len(parts) == 1 {
+		out.Value(out.tenant)
+		return
+	}
+	out.tenant = parts[1]
+	out.OK()
+}
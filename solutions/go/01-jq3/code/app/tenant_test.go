@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTenantBurstKeepsOthersResident(t *testing.T) {
+	c := NewLRUCache[string, int](100)
+	if err := c.SetTenantQuota(40); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range numbered("pool", 40) {
+		c.Put(key, 0)
+	}
+	c.SetTenant("b")
+	bKeys := numbered("b", 30)
+	for i, key := range bKeys {
+		c.Put(key, i)
+	}
+	c.SetTenant("a")
+	for i, key := range numbered("a", 1000) {
+		c.Put(key, i)
+		if i%100 == 0 {
+			// A's reads of B's keys do not make them A's.
+			c.Get(bKeys[i%len(bKeys)])
+		}
+	}
+
+	for _, key := range bKeys {
+		if !c.Contains(key) {
+			t.Fatalf("A's burst evicted %s", key)
+		}
+	}
+	st := c.Stats()
+	a, b := st.Tenants["a"], st.Tenants["b"]
+	if b.Size != len(bKeys) || a.Size != 40 || a.Quota != 40 {
+		t.Errorf("tenants %+v, want b holding 30 and a held to its quota of 40", st.Tenants)
+	}
+	if a.Hits != 10 || b.Hits != 0 {
+		t.Errorf("a hits %d, b hits %d; want 10 and 0", a.Hits, b.Hits)
+	}
+	// The default pool gave up room to A, not B.
+	if st.Size != 100 || st.Size-a.Size-b.Size != 30 {
+		t.Errorf("size %d with a %d and b %d", st.Size, a.Size, b.Size)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTenantOverQuotaLosesItsOwnLRU(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	c.SetTenantQuota(30)
+	c.SetTenant("a")
+	c.Put("a1", 1)
+	c.Put("a2", 2)
+	c.Put("a3", 3)
+	c.Get("a1")
+	c.Put("a4", 4)
+	// a2 is a's least recently used, though the cache has room.
+	if c.Contains("a2") || !c.Contains("a1") || c.Size() != 3 {
+		t.Errorf("keys %v, want a2 evicted", c.Keys())
+	}
+}
+
+func TestTenantsOverTCP(t *testing.T) {
+	s := newServerSession(t, 20)
+	s.tenantQuota = 50
+	s.cache.SetTenantQuota(50)
+	addr := startTCP(t, s)
+	a, b := dial(t, addr), dial(t, addr)
+
+	if got := b.do("TENANT b"); got != "OK" {
+		t.Fatalf("TENANT b = %q", got)
+	}
+	for i := range 8 {
+		b.do(fmt.Sprintf("PUT b%d %d", i, i))
+	}
+	a.do("TENANT a")
+	for i := range 200 {
+		if got := a.do(fmt.Sprintf("PUT a%d %d", i, i)); got != "OK" {
+			t.Fatalf("PUT a%d = %q", i, got)
+		}
+	}
+	for i := range 8 {
+		if got := b.do(fmt.Sprintf("GET b%d", i)); got != fmt.Sprint(i) {
+			t.Errorf("GET b%d = %q after a's burst", i, got)
+		}
+	}
+	if got := a.do("TENANT"); got != "a" {
+		t.Errorf("TENANT = %q", got)
+	}
+	stats := b.do("STATS")
+	for _, want := range []string{" tenant[a]=size:10,used:10,quota:10,", " tenant[b]=size:8,used:8,quota:10,hits:8,misses:0"} {
+		if !strings.Contains(stats, want) {
+			t.Errorf("STATS = %q, want %q in it", stats, want)
+		}
+	}
+}
+
+func TestTenantQuotaRange(t *testing.T) {
+	c := NewLRUCache[string, int](10)
+	for _, percent := range []int{-1, 101} {
+		if err := c.SetTenantQuota(percent); err != ErrBadQuota {
+			t.Errorf("SetTenantQuota(%d) = %v", percent, err)
+		}
+	}
+}
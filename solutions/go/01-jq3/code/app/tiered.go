@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// A tiered cache keeps a second, colder level behind the first. An entry
+// the first level evicts is demoted into the second with the TTL it has
+// left instead of being dropped, and an operation on a key the first level
+// misses finds it in the second and promotes it back, which may demote
+// another. A key is in at most one level at a time. Only what leaves the
+// second level is reported to the removal handler as evicted; demotions
+// and promotions are counted in Stats instead. Peek and Contains look into
+// the second level without promoting.
+//
+// The second level is an LRU cache of its own, only ever used with the
+// first level's write lock held. The first level's policy, limits and
+// background work do not apply to it, and snapshots, exports and AOF
+// rewrites hold the first level only.
+
+// NewTieredCache creates an LRU cache of l1Cap entries that demotes its
+// evictions to a second level of l2Cap. It panics if either is less than
+// 1.
+func NewTieredCache[K comparable, V any](l1Cap, l2Cap int) *LRUCache[K, V] {
+	return newTieredCache(l1Cap, l2Cap, newLRUPolicy[K, V]())
+}
+
+// newTieredCache is NewTieredCache with policy choosing the first level's
+// victims.
+func newTieredCache[K comparable, V any](l1Cap, l2Cap int, policy EvictionPolicy[K, V]) *LRUCache[K, V] {
+	checkPositive("second level capacity", l2Cap)
+	c := NewLRUCacheWithPolicy(l1Cap, policy)
+	c.l2 = NewLRUCache[K, V](l2Cap)
+	// The second level's removals are counted and queued as the first
+	// level's own, to be reported when it releases its lock.
+	c.l2.onRemove = c.record
+	return c
+}
+
+// demote moves victim, already unlinked, into the second level and
+// reports whether it did. An entry past its TTL, or one the second level
+// refuses, is left to be evicted.
+func (c *LRUCache[K, V]) demote(victim *Node[K, V]) bool {
+	now := c.now()
+	if victim.expired(now) {
+		return false
+	}
+	var ttl time.Duration
+	if !victim.expireAt.IsZero() {
+		ttl = victim.expireAt.Sub(now)
+	}
+	if c.l2.PutWithTTL(victim.key, victim.value, ttl) != nil {
+		return false
+	}
+	c.counters.demotions.Add(1)
+	return true
+}
+
+// promote moves key's entry from the second level into the first and
+// returns its node, or nil if the second level does not hold it. If the
+// first level refuses the write the entry stays where it was.
+func (c *LRUCache[K, V]) promote(key K) *Node[K, V] {
+	value, ttl, ok := c.l2.take(key)
+	if !ok {
+		return nil
+	}
+	if err := c.write(key, value, ttl, c.defaultCost(key, value), false); err != nil {
+		c.l2.PutWithTTL(key, value, ttl)
+		return nil
+	}
+	c.counters.promotions.Add(1)
+	return c.cache[key]
+}
+
+// take removes key's live entry without reporting it and returns its value
+// and the TTL it has left, 0 if none.
+func (c *LRUCache[K, V]) take(key K) (V, time.Duration, bool) {
+	c.lock()
+	defer c.unlock()
+
+	node, ok := c.lookup(key)
+	if !ok {
+		var zero V
+		return zero, 0, false
+	}
+	var ttl time.Duration
+	if !node.expireAt.IsZero() {
+		ttl = node.expireAt.Sub(c.now())
+	}
+	value := node.value
+	c.unlink(node)
+	c.release(node)
+	return value, ttl, true
+}
+
+// dropLower removes key from the second level before a write to the first
+// stores a new value, reporting the old one as replaced.
+func (c *LRUCache[K, V]) dropLower(key K) {
+	if c.l2 == nil {
+		return
+	}
+	if old, _, ok := c.l2.take(key); ok {
+		c.record(key, old, RemovalReplaced)
+	}
+}
+
+// emptyLower empties the second level without reporting anything, for
+// Restore.
+func (c *LRUCache[K, V]) emptyLower() {
+	if c.l2 == nil {
+		return
+	}
+	fn := c.l2.onRemove
+	c.l2.onRemove = nil
+	c.l2.Clear()
+	c.l2.onRemove = fn
+}
+
+// checkTiers verifies the second level and that no key is in both; see
+// DebugCheck.
+func (c *LRUCache[K, V]) checkTiers() error {
+	if c.l2 == nil {
+		return nil
+	}
+	if err := c.l2.DebugCheck(); err != nil {
+		return fmt.Errorf("second level: %w", err)
+	}
+	c.l2.rlock()
+	defer c.l2.mu.RUnlock()
+	for key := range c.cache {
+		if _, ok := c.l2.cache[key]; ok {
+			return fmt.Errorf("key %v is in both levels", key)
+		}
+	}
+	return nil
+}
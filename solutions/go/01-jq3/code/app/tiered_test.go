@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// level returns the level of c holding key, 1 or 2, or 0 if neither does.
+func level(c *LRUCache[string, string], key string) int {
+	if _, ok := c.cache[key]; ok {
+		return 1
+	}
+	if c.l2.Contains(key) {
+		return 2
+	}
+	return 0
+}
+
+func TestTieredDemotesAndPromotes(t *testing.T) {
+	c := NewTieredCache[string, string](2, 4)
+	c.Put("a", "1")
+	c.Put("b", "2")
+	c.Put("c", "3")
+	if level(c, "a") != 2 || level(c, "b") != 1 || level(c, "c") != 1 {
+		t.Fatalf("levels a=%d b=%d c=%d, want a demoted", level(c, "a"), level(c, "b"), level(c, "c"))
+	}
+	if v, ok := c.Get("a"); !ok || v != "1" {
+		t.Fatalf("Get(a) = %q, %v", v, ok)
+	}
+	// a came back and b, the least recently used, went down instead.
+	if level(c, "a") != 1 || level(c, "b") != 2 {
+		t.Errorf("levels a=%d b=%d after promoting a", level(c, "a"), level(c, "b"))
+	}
+	st := c.Stats()
+	if st.Demotions != 2 || st.Promotions != 1 || st.Size != 2 || st.L2Size != 1 || st.Evictions != 0 {
+		t.Errorf("stats %+v", st)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTieredPingPong(t *testing.T) {
+	// A working set one larger than the first level comes back from the
+	// second on every read, and is never lost.
+	c := NewTieredCache[string, string](3, 8)
+	ws := []string{"a", "b", "c", "d"}
+	for _, key := range ws {
+		c.Put(key, key)
+	}
+	for round := range 50 {
+		for _, key := range ws {
+			if v, ok := c.Get(key); !ok || v != key {
+				t.Fatalf("round %d: Get(%s) = %q, %v", round, key, v, ok)
+			}
+			if err := c.DebugCheck(); err != nil {
+				t.Fatalf("round %d, after Get(%s): %v", round, key, err)
+			}
+		}
+	}
+	st := c.Stats()
+	if st.Misses != 0 || st.Promotions != 200 || st.Demotions != 201 || c.Size() != 4 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestTieredEvictsFromSecondLevel(t *testing.T) {
+	c := NewTieredCache[string, string](2, 2)
+	var evicted []string
+	c.SetRemovalHandler(func(key, _ string, reason RemovalReason) {
+		if reason == RemovalEvicted {
+			evicted = append(evicted, key)
+		}
+	})
+	for _, key := range numbered("k", 6) {
+		c.Put(key, key)
+	}
+	if fmt.Sprint(evicted) != "[k0 k1]" || c.Stats().Evictions != 2 {
+		t.Errorf("evicted %v, want the two that left the second level", evicted)
+	}
+}
+
+func TestTieredRenameAndCopyAcrossLevels(t *testing.T) {
+	// Each setup puts src, and dst unless it is "", in the levels named,
+	// writing f1 and f2 to push keys down.
+	setups := []struct {
+		name   string
+		writes []string
+		src    int
+		dst    int
+	}{
+		{"both in L1", []string{"src", "dst"}, 1, 1},
+		{"src in L2, dst in L1", []string{"src", "f1", "dst"}, 2, 1},
+		{"src in L1, dst in L2", []string{"dst", "f1", "src"}, 1, 2},
+		{"both in L2", []string{"src", "dst", "f1", "f2"}, 2, 2},
+		{"src in L1, no dst", []string{"src"}, 1, 0},
+		{"src in L2, no dst", []string{"src", "f1", "f2"}, 2, 0},
+	}
+	for _, op := range []string{"Rename", "Copy"} {
+		for _, tc := range setups {
+			c := NewTieredCache[string, string](2, 4)
+			replaced := map[string]string{}
+			c.SetRemovalHandler(func(key, value string, reason RemovalReason) {
+				if reason == RemovalReplaced {
+					replaced[key] = value
+				}
+			})
+			for _, key := range tc.writes {
+				c.Put(key, key)
+			}
+			if level(c, "src") != tc.src || level(c, "dst") != tc.dst {
+				t.Fatalf("%s: setup left src in %d and dst in %d", tc.name, level(c, "src"), level(c, "dst"))
+			}
+
+			var err error
+			if op == "Rename" {
+				err = c.Rename("src", "dst")
+			} else {
+				err = c.Copy("src", "dst")
+			}
+			if err != nil {
+				t.Errorf("%s %s: %v", op, tc.name, err)
+				continue
+			}
+			if err := c.DebugCheck(); err != nil {
+				t.Errorf("%s %s: %v", op, tc.name, err)
+			}
+			if v, ok := c.Peek("dst"); !ok || v != "src" {
+				t.Errorf("%s %s: dst = %q, %v", op, tc.name, v, ok)
+			}
+			if _, ok := c.Peek("src"); ok != (op == "Copy") {
+				t.Errorf("%s %s: src present %v", op, tc.name, ok)
+			}
+			if old, ok := replaced["dst"]; ok != (tc.dst != 0) || (ok && old != "dst") {
+				t.Errorf("%s %s: replaced %v", op, tc.name, replaced)
+			}
+			// Renaming to a new key or copying over an old one keeps the
+			// count; the other two change it by one.
+			want := len(tc.writes)
+			if op == "Rename" && tc.dst != 0 {
+				want--
+			} else if op == "Copy" && tc.dst == 0 {
+				want++
+			}
+			if n := c.Size(); n != want {
+				t.Errorf("%s %s: %d entries across the levels, want %d", op, tc.name, n, want)
+			}
+		}
+	}
+}
+
+func TestTieredCopyKeepsTTL(t *testing.T) {
+	c := NewTieredCache[string, string](1, 4)
+	c.PutWithTTL("src", "v", time.Hour)
+	// Storing dst demotes src, whose node the copy must not read after.
+	if err := c.Copy("src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+	info, ok := c.EntryInfo("dst")
+	if !ok || info.TTL <= 59*time.Minute {
+		t.Errorf("dst info %+v, %v; want the hour src had", info, ok)
+	}
+	if err := c.DebugCheck(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTieredRenameCommand(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT TIERED 1 3", "OK",
+		"PUT e v", "OK",
+		"PUT c vv", "OK",
+		"RENAME e c", "OK",
+		"DEBUG CHECK", "OK",
+		"GET c", "v",
+		"GET e", "NULL",
+		"PUT x 1", "OK",
+		"COPY c e", "OK",
+		"DEBUG CHECK", "OK",
+		"GET e", "v",
+		"GET c", "v",
+		"DEBUG CHECK", "OK",
+	)
+}
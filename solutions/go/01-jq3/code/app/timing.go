@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// A line protocol command prefixed with TIME, or any command after TIMEALL
+// ON, has the time it took appended to its response: " (<micros>µs)" at
+// the end of the text, or an elapsed_us field in JSON mode. Only the
+// command's own work is timed, from after the line is parsed to after its
+// response is formatted, and not the writing of the response.
+
+// cutTime strips a TIME prefix from line, reporting whether it had one.
+// TIME on its own is left to be dispatched, to fail its arity check.
+func cutTime(line string) (string, bool) {
+	word, rest := cutWord(strings.TrimSpace(line))
+	if !strings.EqualFold(word, "TIME") || rest == "" {
+		return line, false
+	}
+	return rest, true
+}
+
+// timeReply captures the responses written to out until the returned
+// function is called, which writes them with the time taken to run the
+// commands, as runCommand records it in out.elapsed. The format is that
+// of the mode in force when the command started.
+func timeReply(out *reply) func() {
+	w, json := out.w, out.json
+	var buf bytes.Buffer
+	out.w, out.elapsed = &buf, 0
+	return func() {
+		out.w = w
+		writeTimed(w, buf.Bytes(), json, out.elapsed)
+	}
+}
+
+// writeTimed writes resp, the response to one command, with d appended to
+// its last line or, in JSON mode, to its last object.
+func writeTimed(w io.Writer, resp []byte, json bool, d time.Duration) {
+	body := bytes.TrimSuffix(resp, []byte("\n"))
+	if json && bytes.HasSuffix(body, []byte("}")) {
+		fmt.Fprintf(w, "%s,\"elapsed_us\":%s}\n", body[:len(body)-1], micros(d))
+		return
+	}
+	fmt.Fprintf(w, "%s (%sµs)\n", body, micros(d))
+}
+
+// cmdTime is never reached: executeCommand strips TIME from the command it
+// times, and TIME alone fails the arity check. The command is registered
+// for HELP.
+func (s *session) cmdTime(in *bufio.Reader, out *reply, line string, parts []string) {}
+
+func (s *session) cmdTimeall(in *bufio.Reader, out *reply, line string, parts []string) {
+	if parts[1] != "ON" && parts[1] != "OFF" {
+		out.Error(CodeArity, "TIMEALL requires ON or OFF")
+		return
+	}
+	out.timeAll = parts[1] == "ON"
+	out.OK()
+}
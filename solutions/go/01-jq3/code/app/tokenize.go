@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+var (
+	errUnterminatedQuote = errors.New("unterminated quoted string")
+	errBadEscape         = errors.New("invalid escape sequence in quoted string")
+	errAfterQuote        = errors.New("closing quote must be followed by whitespace")
+)
+
+// tokenize splits a command line into tokens. Tokens are separated by
+// whitespace; a token starting with a double quote runs to the matching
+// unescaped quote and may contain whitespace and the escapes \", \\, \n
+// and \t. Quotes inside an unquoted token are ordinary characters.
+//
+// Any run of spaces, tabs, carriage returns, vertical tabs and form feeds
+// separates tokens, and leading and trailing whitespace is ignored. Every
+// other byte, including NUL and bytes that are not valid UTF-8, is taken
+// verbatim. A comment line, see isComment, has no tokens; a # anywhere
+// else is an ordinary character.
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) || (tokens == nil && line[i] == '#') {
+			return tokens, nil
+		}
+		if line[i] != '"' {
+			start := i
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			tokens = append(tokens, line[start:i])
+			continue
+		}
+
+		var b strings.Builder
+		i++
+		for {
+			if i == len(line) {
+				return nil, errUnterminatedQuote
+			}
+			c := line[i]
+			i++
+			if c == '"' {
+				break
+			}
+			if c != '\\' {
+				b.WriteByte(c)
+				continue
+			}
+			if i == len(line) {
+				return nil, errUnterminatedQuote
+			}
+			switch line[i] {
+			case '"', '\\':
+				b.WriteByte(line[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return nil, errBadEscape
+			}
+			i++
+		}
+		if i < len(line) && !isSpace(line[i]) {
+			return nil, errAfterQuote
+		}
+		tokens = append(tokens, b.String())
+	}
+}
+
+// isComment reports whether line is a comment, that is whether its first
+// non-whitespace character is #. Like blank lines, comments are skipped
+// without a response, which lets scripts and traces be annotated.
+func isComment(line string) bool {
+	i := 0
+	for i < len(line) && isSpace(line[i]) {
+		i++
+	}
+	return i < len(line) && line[i] == '#'
+}
+
+// cutCommand splits line at its first semicolon outside a quoted token,
+// quoted as tokenize reads them, into the command before it, trimmed, and
+// the rest after it. more reports whether there was such a semicolon. A
+// semicolon in an unquoted token splits it, so values holding one must be
+// quoted.
+func cutCommand(line string) (cmd, rest string, more bool) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quoted && c == '\\':
+			i++
+		case quoted:
+			quoted = c != '"'
+		case c == '"' && (i == 0 || isSpace(line[i-1]) || line[i-1] == ';'):
+			quoted = true
+		case c == ';':
+			return strings.TrimSpace(line[:i]), line[i+1:], true
+		}
+	}
+	return strings.TrimSpace(line), "", false
+}
+
+// splitCommands splits an --eval script into its commands; see cutCommand.
+func splitCommands(script string) []string {
+	var cmds []string
+	for more := true; more; {
+		var cmd string
+		cmd, script, more = cutCommand(script)
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+// cutWord splits line, which has no leading whitespace, into its first
+// whitespace-separated word and the rest with the separating whitespace
+// removed.
+func cutWord(line string) (word, rest string) {
+	i := 0
+	for i < len(line) && !isSpace(line[i]) {
+		i++
+	}
+	word, rest = line[:i], line[i:]
+	for len(rest) > 0 && isSpace(rest[0]) {
+		rest = rest[1:]
+	}
+	return word, rest
+}
+
+// subcommands lists the commands whose first argument may be a keyword,
+// and the keywords it may be.
+var subcommands = map[string][]string{
+	"ADMISSION":     {"TINYLFU", "NONE"},
+	"AOF":           {"ON", "OFF", "REWRITE"},
+	"AUTOTUNE":      {"ON", "OFF"},
+	"BENCH":         {"CONTENTION", "SEED"},
+	"CHECKPOINT":    {"DROP"},
+	"CONFIG":        {"GET"},
+	"DEBUG":         {"DUMP", "CHECK", "OBJECT", "SLEEP", "ADVANCECLOCK"},
+	"EVENTS":        {"ON", "OFF"},
+	"HOTKEYS":       {"ON", "OFF"},
+	"INIT":          {"BYTES", "WEIGHTED", "TIERED"},
+	"JANITOR":       {"ON", "OFF"},
+	"LATENCY":       {"RESET", "ON", "OFF"},
+	"PROFILE":       {"ON", "OFF", "REPORT"},
+	"READONLY":      {"ON", "OFF"},
+	"MODE":          {"JSON", "TEXT", "FRAMED"},
+	"REFRESHSOURCE": {"OFF"},
+	"REPLICATE":     {"OFF"},
+	"SEQ":           {"ON", "OFF"},
+	"SHADOW":        {"ON", "OFF", "REPORT"},
+	"STATS":         {"RESET"},
+	"TIMEALL":       {"ON", "OFF"},
+	"TOMBSTONES":    {"ON", "OFF"},
+}
+
+// options lists the commands that take keyword options after their first
+// argument, and the options. Their other arguments are numbers or, for
+// INIT, the policy and cache names, so a match is always an option; LOAD's
+// only other argument is its first, the path.
+var options = map[string][]string{
+	"BENCH": {"ZIPF"},
+	"INIT":  {"TINYLFU", "NOEVICT", "WATERMARK", "IDLE", "DECAY", "MIDPOINT", "NORM", "MIGRATE"},
+	"LOAD":  {"MERGE", "COLD"},
+	"MACRO": {"BEGIN"},
+}
+
+// normalizeCommand upper-cases the command word of a tokenized line and
+// any keyword arguments in place, so that keywords match whatever case
+// they were typed in. Keys, values and anything else are left alone.
+func normalizeCommand(parts []string) {
+	parts[0] = strings.ToUpper(parts[0])
+	fold := func(i int, keywords []string) {
+		if upper := strings.ToUpper(parts[i]); slices.Contains(keywords, upper) {
+			parts[i] = upper
+		}
+	}
+	if keywords, ok := subcommands[parts[0]]; ok && len(parts) > 1 {
+		fold(1, keywords)
+	}
+	if keywords, ok := options[parts[0]]; ok {
+		for i := 2; i < len(parts); i++ {
+			fold(i, keywords)
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
+
+// quoteToken returns s in a form tokenize reads back as the single token s,
+// quoting it only when necessary.
+func quoteToken(s string) string {
+	if s != "" && s[0] != '"' && !strings.ContainsAny(s, " \t\r\n\v\f") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
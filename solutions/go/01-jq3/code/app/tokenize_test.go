@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestTokenize(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+		err  error
+	}{
+		{`PUT a 1`, []string{"PUT", "a", "1"}, nil},
+		{`  PUT   a	1  `, []string{"PUT", "a", "1"}, nil},
+		{"PUT\ta\t\t1\r", []string{"PUT", "a", "1"}, nil},
+		{``, nil, nil},
+		{`   `, nil, nil},
+		{`PUT greeting "hello world"`, []string{"PUT", "greeting", "hello world"}, nil},
+		{`PUT "" ""`, []string{"PUT", "", ""}, nil},
+		{`PUT "a key" v`, []string{"PUT", "a key", "v"}, nil},
+		{`PUT k "tab\there"`, []string{"PUT", "k", "tab\there"}, nil},
+		{`PUT k "line\nbreak"`, []string{"PUT", "k", "line\nbreak"}, nil},
+		{`PUT k "say \"hi\""`, []string{"PUT", "k", `say "hi"`}, nil},
+		{`PUT k "ends with \""`, []string{"PUT", "k", `ends with "`}, nil},
+		{`PUT k "back\\slash"`, []string{"PUT", "k", `back\slash`}, nil},
+		{`PUT k "\\"`, []string{"PUT", "k", `\`}, nil},
+		{"PUT k \"embedded\ttab\"", []string{"PUT", "k", "embedded\ttab"}, nil},
+		{`PUT k a"b"c`, []string{"PUT", "k", `a"b"c`}, nil},
+		{"PUT k \x00\xff", []string{"PUT", "k", "\x00\xff"}, nil},
+		{`# a comment`, nil, nil},
+		{`PUT k #v`, []string{"PUT", "k", "#v"}, nil},
+		{`PUT k "open`, nil, errUnterminatedQuote},
+		{`PUT k "ends with \"`, nil, errUnterminatedQuote},
+		{`PUT k "bad \q"`, nil, errBadEscape},
+		{`PUT k "a"b`, nil, errAfterQuote},
+	} {
+		got, err := tokenize(tc.line)
+		if err != tc.err {
+			t.Errorf("tokenize(%q) error %v, want %v", tc.line, err, tc.err)
+			continue
+		}
+		if err == nil && !slices.Equal(got, tc.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestQuotedValuesRoundTrip(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 5", "OK",
+		`PUT greeting "hello world"`, "OK",
+		"GET greeting", "hello world",
+		`PUT "" empty-key`, "OK",
+		`GET ""`, "empty-key",
+		`PUT q "say \"hi\""`, "OK",
+		"GET q", `say "hi"`,
+		`PUT k "open`, "ERROR ERR_SYNTAX unterminated quoted string",
+		"SIZE", "3",
+	)
+}
+
+func TestQuoteTokenRoundTrips(t *testing.T) {
+	for _, s := range []string{"", "plain", "two words", "tab\t", `q"uote`, `back\`, "new\nline", "#hash"} {
+		got, err := tokenize("X " + quoteToken(s))
+		if err != nil || len(got) != 2 || got[1] != s {
+			t.Errorf("tokenize(quoteToken(%q)) = %q, %v", s, got, err)
+		}
+	}
+}
+
+func TestWhitespaceAndRawBytes(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"  INIT   3  ", "OK",
+		"PUT\ta\t\t1", "OK",
+		"GET a  ", "1",
+		"PUT \x00 \xff\xfe", "OK",
+		"GET \x00", "\xff\xfe",
+		"PUT k \"caf\xc3\"", "OK",
+		"GET k", "caf\xc3",
+		"SIZE\r", "3",
+	)
+}
+
+// mixedCase returns word with its letters in alternating case, starting
+// lower: "pUt".
+func mixedCase(word string) string {
+	b := []byte(strings.ToLower(word))
+	for i := 1; i < len(b); i += 2 {
+		b[i] = byte(unicode.ToUpper(rune(b[i])))
+	}
+	return string(b)
+}
+
+// outcome reduces a response to what does not vary from run to run: the
+// code of an error, or that it succeeded.
+func outcome(resp string) string {
+	if rest, ok := strings.CutPrefix(resp, "ERROR "); ok {
+		code, _, _ := strings.Cut(rest, " ")
+		return code
+	}
+	return "ok"
+}
+
+func TestCommandsMatchInAnyCase(t *testing.T) {
+	t.Chdir(t.TempDir()) // for the commands that write files
+	// fillers stand in for the arguments of commands whose first argument
+	// is itself a command, and of BENCH CONTENTION, which would take a
+	// second of 1; any other argument is 1.
+	fillers := map[string]string{"TIME": "get k", "DRYRUN": "put k v", "ALIAS": "g get", "BENCH CONTENTION": "0.05"}
+	// keywordLines hold each option keyword in a line it succeeds in.
+	keywordLines := map[string][]string{
+		"BENCH": {"BENCH 10 10 ZIPF 1.1"},
+		"INIT": {"INIT 10 LRU TINYLFU", "INIT 10 NOEVICT", "INIT 10 LRU WATERMARK 5", "INIT 10 LRU IDLE 3",
+			"INIT 10 LFU DECAY 4", "INIT 10 LRU MIDPOINT", "INIT 10 LRU NORM lower", "INIT 10 LRU MIGRATE"},
+		"LOAD":  {"LOAD s MERGE", "LOAD s COLD"},
+		"MACRO": {"MACRO m BEGIN\nPUT k v\nEND"},
+	}
+	for name, keywords := range options {
+		for _, kw := range keywords {
+			if !slices.ContainsFunc(keywordLines[name], func(line string) bool { return strings.Contains(line, " "+kw) }) {
+				t.Errorf("no line covers %s's %s option", name, kw)
+			}
+		}
+	}
+
+	var lines []string
+	for _, c := range commandTable {
+		args := slices.Repeat([]string{"1"}, c.minArgs)
+		if filler, ok := fillers[c.name]; ok && c.minArgs > 0 {
+			args = []string{filler}
+		}
+		// A command that needs a subcommand is covered by those.
+		if len(subcommands[c.name]) == 0 || c.minArgs == 0 {
+			lines = append(lines, strings.Join(append([]string{c.name}, args...), " "))
+		}
+		for _, sub := range subcommands[c.name] {
+			line := c.name + " " + sub
+			if filler, ok := fillers[line]; ok {
+				line += " " + filler
+			} else {
+				for range c.minArgs - 1 {
+					line += " 1"
+				}
+			}
+			lines = append(lines, line)
+		}
+		lines = append(lines, keywordLines[c.name]...)
+	}
+
+	for _, line := range lines {
+		run := func(line string) string {
+			s := newClockSession(t)
+			var err error
+			if s.config, err = LoadConfig(flag.NewFlagSet("lru", flag.ContinueOnError), "", nil); err != nil {
+				t.Fatal(err)
+			}
+			script(t, s, "INIT 10", "OK", "PUT k v", "OK")
+			if got := s.Execute("SAVE s"); !strings.HasPrefix(got, "OK") {
+				t.Fatalf("SAVE: %q", got)
+			}
+			return s.Execute(line)
+		}
+		// Mix the case of every word but arguments, which keep theirs.
+		head, payload, _ := strings.Cut(line, "\n")
+		words := strings.Fields(head)
+		mixed := slices.Clone(words)
+		for i, w := range words {
+			if w != "1" && w != "k" && w != "v" && w != "s" && w != "m" && w != "lower" {
+				mixed[i] = mixedCase(w)
+			}
+		}
+		mixedLine := strings.Join(mixed, " ")
+		if payload != "" {
+			mixedLine += "\n" + payload
+		}
+		want := run(line)
+		if outcome(want) == string(CodeUnknownCommand) {
+			t.Errorf("%q = %q", line, want)
+			continue
+		}
+		if got := run(mixedLine); outcome(got) != outcome(want) {
+			t.Errorf("%q = %q, but %q = %q", mixedLine, got, line, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/systemquest/lru-cache-starter-go/app/internal/fakeclock"
+)
+
+// clockCache returns a cache of capacity on a fake clock.
+func clockCache(capacity int) (*LRUCache[string, int], *fakeclock.Clock) {
+	clock := fakeclock.New(time.Unix(1_000_000, 0))
+	c := NewLRUCache[string, int](capacity)
+	c.SetClock(clock)
+	return c, clock
+}
+
+// newClockSession returns a session running as under --test-clock.
+func newClockSession(t *testing.T) *session {
+	t.Helper()
+	s := newTestSession(t)
+	s.clock = fakeclock.New(time.Unix(1_000_000, 0))
+	return s
+}
+
+func TestTTLExpiresAtDeadline(t *testing.T) {
+	c, clock := clockCache(4)
+	c.PutWithTTL("a", 1, 10*time.Second)
+	clock.Advance(10*time.Second - time.Nanosecond)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) just before its deadline = %d, %v", v, ok)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get(a) at its deadline hit")
+	}
+	if st := c.Stats(); st.Expirations != 1 || st.Size != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestTTLReadsDoNotExtend(t *testing.T) {
+	c, clock := clockCache(4)
+	c.PutWithTTL("a", 1, 10*time.Second)
+	for range 9 {
+		clock.Advance(time.Second)
+		c.Get("a")
+	}
+	clock.Advance(time.Second)
+	if c.Contains("a") {
+		t.Error("reads kept a alive past its TTL")
+	}
+}
+
+func TestTTLRewriteReplacesDeadline(t *testing.T) {
+	c, clock := clockCache(4)
+	c.PutWithTTL("a", 1, 5*time.Second)
+	clock.Advance(4 * time.Second)
+	c.PutWithTTL("a", 2, 5*time.Second)
+	clock.Advance(4 * time.Second)
+	if v, ok := c.Get("a"); !ok || v != 2 {
+		t.Errorf("Get(a) = %d, %v, want the rewritten value still live", v, ok)
+	}
+	c.Put("a", 3)
+	clock.Advance(time.Hour)
+	if v, ok := c.Get("a"); !ok || v != 3 {
+		t.Errorf("Get(a) = %d, %v after a Put without TTL", v, ok)
+	}
+}
+
+func TestTTLCommands(t *testing.T) {
+	s := newClockSession(t)
+	script(t, s,
+		"INIT 3", "OK",
+		"PUT a 1 10", "OK",
+		"INFO a", "key=a hits=0 age=0.000 idle=0.000 ttl=10.000 pos=0",
+		"DEBUG ADVANCECLOCK 9.5", "OK",
+		"GET a", "1",
+		"INFO a", "key=a hits=1 age=9.500 idle=0.000 ttl=0.500 pos=0",
+		"DEBUG ADVANCECLOCK 0.5", "OK",
+		"GET a", "NULL",
+		"PUT c 3", "OK",
+		"EXPIRE c 1", "OK",
+		"DEBUG ADVANCECLOCK 1", "OK",
+		"EXISTS c", "0",
+		"PUT d 4 1", "OK",
+		"PERSIST d", "OK",
+		"DEBUG ADVANCECLOCK 100", "OK",
+		"GET d", "4",
+		"DEBUG ADVANCECLOCK x", "ERROR ERR_INVALID Invalid seconds: x",
+		"DEBUG ADVANCECLOCK -1", "ERROR ERR_INVALID Invalid seconds: -1",
+	)
+	if got := s.Execute("STATS"); got != "hits=2 misses=1 evictions=0 expirations=2 size=1 capacity=3 hit_ratio=0.67" {
+		t.Errorf("STATS = %q", got)
+	}
+}
+
+func TestGraceAndIdleFollowTheClock(t *testing.T) {
+	s := newClockSession(t)
+	script(t, s,
+		"INIT 3", "OK",
+		"PUT b 2 10 5", "OK",
+		"DEBUG ADVANCECLOCK 10", "OK",
+		"GETSTALE b", "REVALIDATE 2",
+		"DEBUG ADVANCECLOCK 5", "OK",
+		"GETSTALE b", "NULL",
+		"SETIDLE 10", "OK",
+		"PUT i 1", "OK",
+		"DEBUG ADVANCECLOCK 9", "OK",
+		"GET i", "1",
+		"DEBUG ADVANCECLOCK 9", "OK",
+		"GET i", "1",
+		"DEBUG ADVANCECLOCK 10", "OK",
+		"GET i", "NULL",
+	)
+}
+
+func TestAdvanceClockNeedsTestClock(t *testing.T) {
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 1", "OK",
+		"DEBUG ADVANCECLOCK 1", "ERROR ERR_UNKNOWN_COMMAND Unknown DEBUG subcommand: ADVANCECLOCK",
+	)
+}
+
+func TestJanitorReadsTheClock(t *testing.T) {
+	c, clock := clockCache(10)
+	c.PutWithTTL("a", 1, time.Minute)
+	c.sweep()
+	if c.Size() != 1 {
+		t.Fatal("the sweep removed a live entry")
+	}
+	clock.Advance(time.Minute)
+	c.sweep()
+	if c.Size() != 0 {
+		t.Error("the sweep kept an entry the clock says has expired")
+	}
+}
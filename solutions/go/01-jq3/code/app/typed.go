@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// The typed getters parse the string stored under a key, for callers that
+// keep numbers, flags and small JSON documents as strings. Each returns
+// whether the key was found and, if it was but its value is not a string
+// or does not parse, an error, so a parse failure is never mistaken for a
+// miss. They work on caches of strings and of the session's Values.
+//
+// GetJSON keeps what it decoded with the entry, so that reading the same
+// value again costs a copy rather than a parse. Any write to the key
+// changes its version and so drops what was decoded; see GetVersioned. The
+// decoded values are not counted against a byte or weight budget.
+
+var (
+	// ErrNotBool is returned by GetBool when the value is not one that
+	// strconv.ParseBool accepts.
+	ErrNotBool = errors.New("value is not a boolean")
+	// ErrNotFloat is returned by GetFloat when the value is not a
+	// floating-point number.
+	ErrNotFloat = errors.New("value is not a float")
+	// ErrNotJSON wraps the error GetJSON got decoding the value.
+	ErrNotJSON = errors.New("value is not valid JSON")
+)
+
+// parsedJSON is what GetJSON decoded from the value of an entry at version.
+type parsedJSON struct {
+	version uint64
+	value   reflect.Value
+}
+
+// textOf returns the string v holds: v itself in a cache of strings, or
+// what Str returns in one of Values. Other types give errNotString.
+func textOf[V any](v V) (string, error) {
+	switch t := any(v).(type) {
+	case string:
+		return t, nil
+	case interface{ Str() (string, error) }:
+		return t.Str()
+	}
+	return "", errNotString
+}
+
+// getText is Get returning the value's string.
+func (c *LRUCache[K, V]) getText(key K) (string, bool, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return "", false, nil
+	}
+	s, err := textOf(value)
+	return s, true, err
+}
+
+// GetInt returns the base-10 64-bit integer stored under key, as INCR
+// reads it, or ErrNotInteger if the value is something else.
+func (c *LRUCache[K, V]) GetInt(key K) (int64, bool, error) {
+	s, ok, err := c.getText(key)
+	if !ok || err != nil {
+		return 0, ok, err
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, true, ErrNotInteger
+	}
+	return n, true, nil
+}
+
+// GetBool returns the boolean stored under key, in any form
+// strconv.ParseBool accepts, or ErrNotBool.
+func (c *LRUCache[K, V]) GetBool(key K) (bool, bool, error) {
+	s, ok, err := c.getText(key)
+	if !ok || err != nil {
+		return false, ok, err
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, true, ErrNotBool
+	}
+	return b, true, nil
+}
+
+// GetFloat returns the 64-bit floating-point number stored under key, or
+// ErrNotFloat.
+func (c *LRUCache[K, V]) GetFloat(key K) (float64, bool, error) {
+	s, ok, err := c.getText(key)
+	if !ok || err != nil {
+		return 0, ok, err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, true, ErrNotFloat
+	}
+	return f, true, nil
+}
+
+// GetJSON decodes the JSON document stored under key into v, which must be
+// a non-nil pointer, and reports whether key was found. v is overwritten
+// rather than merged into as json.Unmarshal would. A value decoded before
+// for the same type of v is copied instead of decoded again; maps, slices
+// and pointers in it are shared with the entry and must not be modified.
+func (c *LRUCache[K, V]) GetJSON(key K, v any) (bool, error) {
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Pointer || dst.IsNil() {
+		return false, &json.InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	dst = dst.Elem()
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	value, ok := c.get(key)
+	if !ok {
+		return false, nil
+	}
+	node := c.cache[key]
+	if p := node.parsed; p != nil && p.version == node.version && p.value.Type() == dst.Type() {
+		dst.Set(p.value)
+		return true, nil
+	}
+	s, err := textOf(value)
+	if err != nil {
+		return true, err
+	}
+	parsed := reflect.New(dst.Type())
+	if err := json.Unmarshal([]byte(s), parsed.Interface()); err != nil {
+		return true, fmt.Errorf("%w: %v", ErrNotJSON, err)
+	}
+	node.parsed = &parsedJSON{version: node.version, value: parsed.Elem()}
+	dst.Set(parsed.Elem())
+	return true, nil
+}
+
+func (s *session) cmdGetint(in *bufio.Reader, out *reply, line string, parts []string) {
+	n, ok, err := s.cache.GetInt(parts[1])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if !ok {
+		out.Null()
+		return
+	}
+	out.Result(strconv.FormatInt(n, 10), n)
+}
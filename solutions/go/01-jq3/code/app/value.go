@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrWrongType is returned by an operation on a key holding a different
+// type of value than the operation works on. The stored value is left
+// unchanged.
+var ErrWrongType = errors.New("wrong type")
+
+// Value is what the session's caches store: a string, a list or a hash. A
+// list or hash is one cache entry, so it is promoted and evicted as a unit,
+// and in a byte-bounded cache it is charged the total length of its
+// elements, or of its fields and their values. At most one of list and hash
+// is set; a Value with neither holds a string.
+//
+// Values are immutable: the list and hash operations store a modified copy,
+// so a Value read from the cache stays valid after the lock is released.
+// Values compare equal when they are the same string or the same stored
+// list or hash.
+//
+// A cache may store a long string compressed, with packed set, if that
+// makes it shorter; see compressFrom. Str and String decompress it, and Len
+// still reports its full length; only Size, which byte budgets charge,
+// reports what it takes to store. Compression is deterministic, so equal
+// strings still compare equal. With compact storage, the string of a cached
+// value is in one of the cache's slabs; see slab.go.
+type Value struct {
+	list   *listData
+	hash   *hashData
+	str    string
+	packed bool
+}
+
+type listData struct {
+	items []string
+	bytes int
+}
+
+type hashData struct {
+	fields map[string]string
+	bytes  int
+}
+
+// StringValue returns a Value holding s.
+func StringValue(s string) Value {
+	return Value{str: s}
+}
+
+// Type names the type of v as error messages show it.
+func (v Value) Type() string {
+	switch {
+	case v.list != nil:
+		return "list"
+	case v.hash != nil:
+		return "hash"
+	}
+	return "string"
+}
+
+// Len returns the length of v in bytes, which length limits count.
+func (v Value) Len() int {
+	switch {
+	case v.list != nil:
+		return v.list.bytes
+	case v.hash != nil:
+		return v.hash.bytes
+	case v.packed:
+		return packedLen(v.str)
+	}
+	return len(v.str)
+}
+
+// Size returns the bytes v takes to store, which byte budgets count: Len,
+// or less for a compressed string.
+func (v Value) Size() int {
+	if v.packed {
+		return len(v.str)
+	}
+	return v.Len()
+}
+
+// saved returns the bytes compression saves in storing v.
+func (v Value) saved() int {
+	return v.Len() - v.Size()
+}
+
+// Str returns the string v holds, or ErrWrongType if it holds something
+// else.
+func (v Value) Str() (string, error) {
+	if v.list != nil || v.hash != nil {
+		return "", v.wrongType()
+	}
+	if v.packed {
+		return unpack(v.str)
+	}
+	if compactStorage {
+		return strings.Clone(v.str), nil
+	}
+	return v.str, nil
+}
+
+// List returns the elements of the list v holds, or ErrWrongType if it
+// holds something else. The slice must not be modified.
+func (v Value) List() ([]string, error) {
+	if v.list == nil {
+		return nil, v.wrongType()
+	}
+	return v.list.items, nil
+}
+
+// Hash returns the fields of the hash v holds, or ErrWrongType if it holds
+// something else. The map must not be modified.
+func (v Value) Hash() (map[string]string, error) {
+	if v.hash == nil {
+		return nil, v.wrongType()
+	}
+	return v.hash.fields, nil
+}
+
+func (v Value) wrongType() error {
+	return fmt.Errorf("%w: key holds a %s", ErrWrongType, v.Type())
+}
+
+// String returns a string as it is, a list in brackets and a hash in braces
+// as field value pairs in field order, with each element quoted as the
+// protocol would need it.
+func (v Value) String() string {
+	var items []string
+	switch {
+	case v.list != nil:
+		for _, item := range v.list.items {
+			items = append(items, quoteToken(item))
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case v.hash != nil:
+		for _, field := range v.fieldNames() {
+			items = append(items, quoteToken(field), quoteToken(v.hash.fields[field]))
+		}
+		return "{" + strings.Join(items, " ") + "}"
+	}
+	str, _ := v.Str()
+	return str
+}
+
+// fieldNames returns the fields of a hash in sorted order.
+func (v Value) fieldNames() []string {
+	return slices.Sorted(maps.Keys(v.hash.fields))
+}
+
+// rebuild returns the logged commands that recreate v under key with the
+// TTL ttl, in seconds, or none if ttl is empty: a PUT for a string, with
+// the grace period if there is one, and an RPUSH per element of a list or
+// an HSET per field of a hash followed by an EXPIRE.
+func (v Value) rebuild(key, ttl, grace string) []string {
+	key = quoteToken(key)
+	var lines []string
+	switch {
+	case v.list != nil:
+		for _, item := range v.list.items {
+			lines = append(lines, "RPUSH "+key+" "+quoteToken(item))
+		}
+	case v.hash != nil:
+		for _, field := range v.fieldNames() {
+			lines = append(lines, "HSET "+key+" "+quoteToken(field)+" "+quoteToken(v.hash.fields[field]))
+		}
+	default:
+		line := "PUT " + key + " " + quoteToken(v.String())
+		if ttl != "" {
+			line += " " + ttl
+			if grace != "" {
+				line += " " + grace
+			}
+		}
+		return []string{line}
+	}
+	if ttl != "" {
+		lines = append(lines, "EXPIRE "+key+" "+ttl)
+	}
+	return lines
+}
+
+// MarshalJSON writes a string as a JSON string, as snapshots have always
+// stored values, a list as {"list": [...]} and a hash as {"hash": {...}}.
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch {
+	case v.list != nil:
+		return json.Marshal(map[string][]string{"list": v.list.items})
+	case v.hash != nil:
+		return json.Marshal(map[string]map[string]string{"hash": v.hash.fields})
+	}
+	return json.Marshal(v.String())
+}
+
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = StringValue(s)
+		return nil
+	}
+	var obj struct {
+		List []string          `json:"list"`
+		Hash map[string]string `json:"hash"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return errors.New("value is not a string, list or hash")
+	}
+	switch {
+	case obj.List != nil:
+		*v = newList(obj.List)
+	case len(obj.Hash) > 0:
+		*v = newHash(obj.Hash)
+	default:
+		return errors.New("value is not a string, list or hash")
+	}
+	return nil
+}
+
+// newList returns a Value holding items, which it takes ownership of.
+func newList(items []string) Value {
+	l := &listData{items: items}
+	for _, item := range items {
+		l.bytes += len(item)
+	}
+	return Value{list: l}
+}
+
+// newHash returns a Value holding fields, which it takes ownership of.
+func newHash(fields map[string]string) Value {
+	h := &hashData{fields: fields}
+	for field, value := range fields {
+		h.bytes += len(field) + len(value)
+	}
+	return Value{hash: h}
+}
+
+// The operations below work on the session's caches. Each is one atomic
+// step, and a key of the wrong type is reported and left alone.
+
+// incrementValue adds delta to the integer string under key, storing delta
+// if key is absent, and returns the result.
+func incrementValue(cache *LRUCache[string, Value], key string, delta int64) (int64, error) {
+	n := delta
+	err := cache.Modify(key, func(old Value, ok bool) (Value, bool, error) {
+		if !ok {
+			return StringValue(strconv.FormatInt(n, 10)), true, nil
+		}
+		s, err := old.Str()
+		if err != nil {
+			return old, false, err
+		}
+		if n, err = addInteger(s, delta); err != nil {
+			return old, false, err
+		}
+		return StringValue(strconv.FormatInt(n, 10)), true, nil
+	})
+	return n, err
+}
+
+// appendValue adds suffix to the string under key, storing suffix if key is
+// absent, and returns the new length.
+func appendValue(cache *LRUCache[string, Value], key, suffix string) (int, error) {
+	n := 0
+	err := cache.Modify(key, func(old Value, ok bool) (Value, bool, error) {
+		s := ""
+		if ok {
+			var err error
+			if s, err = old.Str(); err != nil {
+				return old, false, err
+			}
+		}
+		s += suffix
+		n = len(s)
+		return StringValue(s), true, nil
+	})
+	return n, err
+}
+
+// pushValue adds item to the front or back of the list under key, creating
+// the list if key is absent, and returns the new length.
+func pushValue(cache *LRUCache[string, Value], key, item string, front bool) (int, error) {
+	n := 0
+	err := cache.Modify(key, func(old Value, ok bool) (Value, bool, error) {
+		var items []string
+		if ok {
+			var err error
+			if items, err = old.List(); err != nil {
+				return old, false, err
+			}
+		}
+		pushed := make([]string, 0, len(items)+1)
+		if front {
+			pushed = append(append(pushed, item), items...)
+		} else {
+			pushed = append(append(pushed, items...), item)
+		}
+		n = len(pushed)
+		return newList(pushed), true, nil
+	})
+	return n, err
+}
+
+// hashSet sets field in the hash under key, creating the hash if key is
+// absent, and reports whether the field is new.
+func hashSet(cache *LRUCache[string, Value], key, field, value string) (bool, error) {
+	added := false
+	err := cache.Modify(key, func(old Value, ok bool) (Value, bool, error) {
+		var fields map[string]string
+		if ok {
+			var err error
+			if fields, err = old.Hash(); err != nil {
+				return old, false, err
+			}
+		}
+		_, exists := fields[field]
+		added = !exists
+		fields = maps.Clone(fields)
+		if fields == nil {
+			fields = make(map[string]string, 1)
+		}
+		fields[field] = value
+		return newHash(fields), true, nil
+	})
+	return added && err == nil, err
+}
+
+// errUnchanged makes Modify leave an entry alone when there is nothing to
+// write.
+var errUnchanged = errors.New("unchanged")
+
+// hashDelete removes field from the hash under key and reports whether it
+// was there. Removing the last field removes key.
+func hashDelete(cache *LRUCache[string, Value], key, field string) (bool, error) {
+	err := cache.Modify(key, func(old Value, ok bool) (Value, bool, error) {
+		if !ok {
+			return old, false, errUnchanged
+		}
+		fields, err := old.Hash()
+		if err != nil {
+			return old, false, err
+		}
+		if _, found := fields[field]; !found {
+			return old, false, errUnchanged
+		}
+		fields = maps.Clone(fields)
+		delete(fields, field)
+		return newHash(fields), len(fields) > 0, nil
+	})
+	if err == errUnchanged {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// listRange returns the elements of items from start to stop inclusive.
+// Negative indices count from the end, so -1 is the last element, and
+// indices past either end are clamped.
+func listRange(items []string, start, stop int) []string {
+	n := len(items)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return nil
+	}
+	return items[start : stop+1]
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestHashCommands(t *testing.T) {
+	const wrongType = "ERROR ERR_WRONG_TYPE wrong type: key holds a string"
+	s := newTestSession(t)
+	script(t, s,
+		"INIT 3", "OK",
+		"HSET h a 1", "1",
+		"HSET h a 2", "0", // an update, not an addition
+		"HSET h b 3", "1",
+		"HGET h a", "2",
+		"HGET h x", "NULL",
+		"HGET nothing a", "NULL",
+		"HGETALL h", "a 2\nb 3",
+		`HSET h "a b" "c d"`, "1",
+		`HGET h "a b"`, "c d",
+		"HDEL h x", "0",
+		"HDEL nothing a", "0",
+		"HDEL h a", "1",
+		"HDEL h a", "0",
+		`HDEL h "a b"`, "1",
+		// Deleting the last field deletes the key.
+		"HDEL h b", "1",
+		"EXISTS h", "0",
+		"HGETALL h", "EMPTY",
+
+		"PUT s v", "OK",
+		"HSET s a 1", wrongType,
+		"HGET s a", wrongType,
+		"HDEL s a", wrongType,
+		"HGETALL s", wrongType,
+		"GET s", "v",
+		"HSET h a 1", "1",
+		"GET h", "ERROR ERR_WRONG_TYPE wrong type: key holds a hash",
+		"DEBUG CHECK", "OK",
+	)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"strconv"
+)
+
+// Every entry carries a version: 1 when the key is created, bumped each
+// time its value changes, by whatever command. Promotion, Touch and Expire
+// leave it alone, and so does Rename, which moves the entry whole. A key
+// deleted, evicted or expired and then written again starts over at 1,
+// and so does every entry restored from a snapshot or export or replayed
+// from the AOF. Version 0 stands for a missing key. PUTV is logged as it
+// was given, so replaying the AOF from the start reproduces the versions
+// it checked.
+
+// GetVersioned is GetThrough that also returns the entry's version.
+func (c *LRUCache[K, V]) GetVersioned(key K) (V, uint64, bool, error) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	if value, ok := c.get(key); ok {
+		return value, c.cache[key].version, true, nil
+	}
+	var zero V
+	if c.readOnly {
+		return zero, 0, false, nil
+	}
+	node, ok, err := c.lookupThrough(key)
+	if !ok {
+		return zero, 0, false, err
+	}
+	return node.value, node.version, true, nil
+}
+
+// PutVersioned stores value under key only if the key's version is
+// expected, 0 meaning that key must be missing, as one atomic step. It
+// returns the new version and true, or the current version and false if
+// it did not match. Over an existing entry it keeps the TTL and promotes
+// it, as PutIfEquals does; a new entry gets no TTL. If the cache declines
+// a new entry, as the admission filter may, the version returned is 0.
+func (c *LRUCache[K, V]) PutVersioned(key K, value V, expected uint64) (uint64, bool, error) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+
+	node, ok, err := c.lookupThrough(key)
+	if err != nil {
+		return 0, false, err
+	}
+	var current uint64
+	if ok {
+		current = node.version
+	}
+	if current != expected {
+		return current, false, nil
+	}
+	if ok {
+		err = c.update(node, value)
+	} else {
+		err = c.put(key, value, 0, c.defaultCost(key, value))
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if node, ok := c.cache[key]; ok {
+		return node.version, true, nil
+	}
+	return 0, true, nil
+}
+
+func (s *session) cmdGetv(in *bufio.Reader, out *reply, line string, parts []string) {
+	value, version, ok, err := s.cache.GetVersioned(parts[1])
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if !ok {
+		out.Null()
+		return
+	}
+	str, err := value.Str()
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	out.Result(strconv.FormatUint(version, 10)+" "+str, map[string]any{"version": version, "value": str})
+}
+
+func (s *session) cmdPutv(in *bufio.Reader, out *reply, line string, parts []string) {
+	expected, err := strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid version: %s", parts[3])
+		return
+	}
+	version, ok, err := s.cache.PutVersioned(parts[1], StringValue(parts[2]), expected)
+	if err != nil {
+		out.Err(err)
+		return
+	}
+	if !ok {
+		out.Tagged("CONFLICT", strconv.FormatUint(version, 10))
+		return
+	}
+	s.log(line)
+	out.Result(strconv.FormatUint(version, 10), version)
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestVersionStartsOverWhenKeyIsRecreated(t *testing.T) {
+	s := newClockSession(t)
+	script(t, s,
+		"INIT 2", "OK",
+		"PUTV a x 0", "1",
+		"PUTV a y 1", "2",
+		"PUT a z", "OK",
+		"GETV a", "3 z",
+		"DELETE a", "OK",
+		"GETV a", "NULL",
+		// The version held from before the delete no longer matches.
+		"PUTV a w 3", "CONFLICT 0",
+		"PUTV a w 0", "1",
+		"GETV a", "1 w",
+		"PUTV a v 1", "2",
+
+		// A plain PUT starts over too, and so do eviction and expiry.
+		"DELETE a", "OK",
+		"PUT a u", "OK",
+		"GETV a", "1 u",
+		"PUTV a t 1", "2",
+		"PUT b 1", "OK",
+		"PUT c 1", "OK", // evicts a
+		"PUTV a s 2", "CONFLICT 0",
+		"PUTV a s 0", "1",
+		"PUTV a r 1", "2",
+		"EXPIRE a 5", "OK",
+		"DEBUG ADVANCECLOCK 5", "OK",
+		"PUTV a q 2", "CONFLICT 0",
+		"PUTV a q 0", "1",
+		"GETV a", "1 q",
+	)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// warmResult summarises one run of warmCache.
+type warmResult struct {
+	Loaded  int `json:"loaded"`
+	Evicted int `json:"evicted"`
+	Skipped int `json:"skipped"`
+}
+
+func (r warmResult) String() string {
+	out := fmt.Sprintf("loaded=%d evicted=%d", r.Loaded, r.Evicted)
+	if r.Skipped > 0 {
+		out += fmt.Sprintf(" skipped=%d", r.Skipped)
+	}
+	return out
+}
+
+// warmCache stores the entries of the preload file at path in cache. Each
+// line holds a key and a value, quoted as they would be for PUT, and they
+// are stored in file order, so the cache ends up as if each line had been
+// PUT in turn: the last lines are the most recently used, and if there are
+// more than fit only the tail survives. The file is read in full and then
+// stored as one batch, so an entry a later line evicts and another later
+// line stores again is not reported evicted; see writeBatch. Lines that do
+// not parse, and entries the cache refuses, are skipped and counted. Blank
+// lines and comments are ignored. onLoad, if not nil, is called for each
+// entry stored.
+func warmCache(cache *LRUCache[string, Value], path string, maxLine int, onLoad func(key, value string)) (warmResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return warmResult{}, err
+	}
+	defer f.Close()
+
+	var r warmResult
+	var pairs []KV[string, Value]
+	br := bufio.NewReader(f)
+	for {
+		line, tooLong, err := readLine(br, maxLine)
+		switch {
+		case tooLong:
+			r.Skipped++
+		case strings.TrimSpace(line) != "" && !isComment(line):
+			parts, perr := tokenize(strings.TrimRight(line, "\r\n"))
+			if perr != nil || len(parts) != 2 {
+				r.Skipped++
+				break
+			}
+			pairs = append(pairs, KV[string, Value]{Key: parts[0], Value: StringValue(parts[1])})
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return r, err
+		}
+	}
+
+	before := cache.Stats().Evictions
+	for i, err := range cache.PutEach(pairs) {
+		if err != nil {
+			r.Skipped++
+			continue
+		}
+		r.Loaded++
+		if onLoad != nil {
+			onLoad(pairs[i].Key, pairs[i].Value.String())
+		}
+	}
+	r.Evicted = cache.Stats().Evictions - before
+	return r, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// GET /watch?prefix=<p> streams, as server-sent events, what happens to
+// the keys of the default cache that start with p: each write of a value
+// as put, each deletion as delete and each expiry as expire, with the key
+// and the number of the write, or for an expiry, which takes no number,
+// that of the last write before it was seen. Each watcher gets the events
+// through a changefeed buffer and is disconnected with a final error event
+// if it falls feedBuffer events behind. Evictions are not sent: an evicted
+// key has not changed, and a client may still hold its value.
+
+// watchEvent is the data of an event GET /watch sends.
+type watchEvent struct {
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+}
+
+// SetWriteHandler registers fn to be called for every value written and
+// every key deleted, with the number the write took; see Seq. Like the
+// access handler, fn runs with the lock held, so it must be quick and must
+// not call back into the cache.
+func (c *LRUCache[K, V]) SetWriteHandler(fn func(key K, seq uint64, deleted bool)) {
+	c.lock()
+	defer c.unlock()
+	c.onWrite = fn
+}
+
+// wrote reports a write to the write handler, if there is one.
+func (c *LRUCache[K, V]) wrote(key K, seq uint64, deleted bool) {
+	if c.onWrite != nil {
+		c.onWrite(key, seq, deleted)
+	}
+}
+
+// watched passes the event of key in the cache named name to the watchers,
+// if it is the default cache.
+func (s *session) watched(name, key, event string, seq uint64) {
+	if name == defaultCacheName {
+		s.watches.send(change{key: key, event: event, seq: seq})
+	}
+}
+
+// serveWatch is GET /watch. It streams until the client goes away, the
+// watcher is dropped or the server shuts down.
+func (s *session) serveWatch(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, CodeUnsupported, "streaming is not supported")
+		return
+	}
+	sub := s.watches.subscribeTo(r.URL.Query().Get("prefix"))
+	defer s.watches.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case c := <-sub.lines:
+			data, _ := json.Marshal(watchEvent{Key: c.key, Type: c.event, Seq: c.seq})
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.seq, c.event, data)
+			if len(sub.lines) == 0 {
+				flusher.Flush()
+			}
+		case <-sub.dropped:
+			data, _ := json.Marshal(map[string]string{"error": "watcher fell too far behind", "code": string(CodeSlowConsumer)})
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		}
+	}
+}
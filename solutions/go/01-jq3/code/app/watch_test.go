@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/systemquest/lru-cache-starter-go/app/internal/fakeclock"
+)
+
+// watch opens GET /watch?prefix= on srv and returns its events as they
+// come, until ctx is done or the stream ends.
+func watch(t *testing.T, ctx context.Context, srv *httptest.Server, prefix string) <-chan watchEvent {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/watch?prefix="+prefix, nil)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	events := make(chan watchEvent, feedBuffer)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		var e watchEvent
+		r := bufio.NewScanner(resp.Body)
+		for r.Scan() {
+			switch line := r.Text(); {
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(line[len("data: "):]), &e)
+			case line == "":
+				events <- e
+				e = watchEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+// watchers returns how many watchers s has.
+func watchers(s *session) int {
+	if subs := s.watches.subs.Load(); subs != nil {
+		return len(*subs)
+	}
+	return 0
+}
+
+func httpDo(t *testing.T, srv *httptest.Server, method, key, body string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, srv.URL+"/cache/"+key, strings.NewReader(body))
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("%s %s: %s", method, key, resp.Status)
+	}
+}
+
+func TestWatchOverlappingPrefixes(t *testing.T) {
+	s := newServerSession(t, 1000)
+	srv := httptest.NewServer(newHTTPHandler(s))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all := watch(t, ctx, srv, "user:")
+	one := watch(t, ctx, srv, "user:1")
+	if n := watchers(s); n != 2 {
+		t.Fatalf("%d watchers, want 2", n)
+	}
+
+	// The events each watcher should see, in order; the writer is the only
+	// goroutine changing the cache.
+	var wantAll, wantOne []watchEvent
+	for i := range 50 {
+		for _, key := range []string{fmt.Sprintf("user:1/%d", i), fmt.Sprintf("user:2/%d", i), fmt.Sprintf("admin:%d", i)} {
+			e := watchEvent{Key: key, Type: "put"}
+			if strings.HasPrefix(key, "user:") {
+				wantAll = append(wantAll, e)
+			}
+			if strings.HasPrefix(key, "user:1") {
+				wantOne = append(wantOne, e)
+			}
+		}
+		if i%5 == 0 {
+			e := watchEvent{Key: fmt.Sprintf("user:1/%d", i), Type: "delete"}
+			wantAll, wantOne = append(wantAll, e), append(wantOne, e)
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 50 {
+			httpDo(t, srv, "PUT", fmt.Sprintf("user:1/%d", i), `{"value":"a"}`)
+			httpDo(t, srv, "PUT", fmt.Sprintf("user:2/%d", i), `{"value":"b"}`)
+			httpDo(t, srv, "PUT", fmt.Sprintf("admin:%d", i), `{"value":"c"}`)
+			if i%5 == 0 {
+				httpDo(t, srv, "DELETE", fmt.Sprintf("user:1/%d", i), "")
+			}
+		}
+	}()
+
+	check := func(name string, events <-chan watchEvent, want []watchEvent) {
+		var last uint64
+		for i, w := range want {
+			var e watchEvent
+			select {
+			case e = <-events:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timed out after %d of %d events", name, i, len(want))
+			}
+			if e.Key != w.Key || e.Type != w.Type || e.Seq <= last {
+				t.Fatalf("%s: event %d is %+v, want %s %s after seq %d", name, i, e, w.Type, w.Key, last)
+			}
+			last = e.Seq
+		}
+	}
+	check("user:", all, wantAll)
+	check("user:1", one, wantOne)
+	wg.Wait()
+
+	// The handlers return once their clients go away, and unsubscribe.
+	cancel()
+	waitFor(t, func() bool { return watchers(s) == 0 })
+	for range all {
+	}
+}
+
+func TestWatchSendsExpiryButNotEviction(t *testing.T) {
+	s := newServerSession(t, 2)
+	clock := fakeclock.New(time.Unix(1_000_000, 0))
+	s.cache.SetClock(clock)
+	srv := httptest.NewServer(newHTTPHandler(s))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := watch(t, ctx, srv, "")
+
+	s.cache.PutWithTTL("a", StringValue("1"), time.Second)
+	s.cache.Put("b", StringValue("2"))
+	s.cache.Put("c", StringValue("3")) // evicts a, unannounced
+	s.cache.PutWithTTL("d", StringValue("4"), time.Second)
+	clock.Advance(time.Second)
+	s.cache.Get("d")
+	for _, want := range []string{"put a", "put b", "put c", "put d", "expire d"} {
+		select {
+		case e := <-events:
+			if got := e.Type + " " + e.Key; got != want {
+				t.Fatalf("event %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestWatchEndsWithServer(t *testing.T) {
+	s := newServerSession(t, 2)
+	s.stopping = make(chan struct{})
+	srv := httptest.NewServer(newHTTPHandler(s))
+	defer srv.Close()
+	events := watch(t, context.Background(), srv, "")
+	close(s.stopping)
+	select {
+	case _, open := <-events:
+		if open {
+			t.Error("an event after shutdown started")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch did not end on shutdown")
+	}
+	waitFor(t, func() bool { return watchers(s) == 0 })
+}
+
+func TestChangefeedDropsSlowWatchers(t *testing.T) {
+	var f changefeed
+	slow := f.subscribeTo("a")
+	other := f.subscribeTo("b")
+	// The writer never waits for the slow watcher: the change past its
+	// buffer drops it instead.
+	returnsWithin(t, time.Second, func() error {
+		for range feedBuffer + 1 {
+			f.send(change{key: "a", event: "put"})
+		}
+		return nil
+	})
+	select {
+	case <-slow.dropped:
+	default:
+		t.Error("the watcher past its buffer was not dropped")
+	}
+	select {
+	case <-other.dropped:
+		t.Error("a watcher of other keys was dropped")
+	default:
+	}
+	if len(other.lines) != 0 {
+		t.Errorf("watcher of b got %d events of a", len(other.lines))
+	}
+	f.unsubscribe(slow)
+	f.unsubscribe(other)
+	if subs := f.subs.Load(); len(*subs) != 0 {
+		t.Errorf("%d subscribers left", len(*subs))
+	}
+}
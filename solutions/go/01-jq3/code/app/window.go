@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// The counters in STATS add up everything since the cache was created or
+// last reset, so after an hour of warm traffic a sudden fall in the hit
+// ratio barely moves them. STATSWINDOW <n> also keeps the outcomes of the
+// last n lookups in a ring, with how many entries were evicted between
+// each and the one before, and STATS and /metrics then report the hit
+// ratio over the window and the evictions per lookup. Evictions since the
+// last lookup count towards the window too. Recording an outcome is a few
+// adds under a mutex of the window's own and allocates nothing.
+
+// maxStatsWindow bounds the window, which takes 8 bytes a lookup.
+const maxStatsWindow = 1 << 24
+
+type windowSlot struct {
+	hit       bool
+	evictions uint32 // made since the lookup before
+}
+
+// statsWindow is the ring of the last lookups.
+type statsWindow struct {
+	mu    sync.Mutex
+	slots []windowSlot
+	next  int // the slot the next lookup goes in
+	count int // slots filled, up to len(slots)
+	// hits and evictions are the totals over the filled slots, and
+	// pending the evictions since the last lookup.
+	hits, evictions, pending int
+}
+
+// WindowStats describes the last lookups; see SetStatsWindow.
+type WindowStats struct {
+	Size         int     `json:"size"`
+	Lookups      int     `json:"lookups"`
+	HitRatio     float64 `json:"hit_ratio"`
+	EvictionRate float64 `json:"eviction_rate"` // evictions per lookup
+}
+
+func (w WindowStats) String() string {
+	return fmt.Sprintf("window=%d window_lookups=%d window_hit_ratio=%.2f window_eviction_rate=%.2f",
+		w.Size, w.Lookups, w.HitRatio, w.EvictionRate)
+}
+
+func (w *statsWindow) lookup(hit bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	slot := &w.slots[w.next]
+	if w.count == len(w.slots) {
+		if slot.hit {
+			w.hits--
+		}
+		w.evictions -= int(slot.evictions)
+	} else {
+		w.count++
+	}
+	*slot = windowSlot{hit: hit, evictions: uint32(w.pending)}
+	if hit {
+		w.hits++
+	}
+	w.evictions += w.pending
+	w.pending = 0
+	if w.next++; w.next == len(w.slots) {
+		w.next = 0
+	}
+}
+
+func (w *statsWindow) evicted() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending++
+}
+
+func (w *statsWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	clear(w.slots)
+	w.next, w.count, w.hits, w.evictions, w.pending = 0, 0, 0, 0, 0
+}
+
+func (w *statsWindow) stats() WindowStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := WindowStats{Size: len(w.slots), Lookups: w.count}
+	if w.count > 0 {
+		stats.HitRatio = float64(w.hits) / float64(w.count)
+		stats.EvictionRate = float64(w.evictions+w.pending) / float64(w.count)
+	}
+	return stats
+}
+
+// SetStatsWindow keeps the outcomes of the last n lookups, starting with
+// none, or stops keeping them if n is 0.
+func (c *LRUCache[K, V]) SetStatsWindow(n int) error {
+	if n < 0 || n > maxStatsWindow {
+		return fmt.Errorf("window must be between 0 and %d", maxStatsWindow)
+	}
+	if n == 0 {
+		c.window.Store(nil)
+		return nil
+	}
+	c.window.Store(&statsWindow{slots: make([]windowSlot, n)})
+	return nil
+}
+
+// hit counts a lookup that found a live entry.
+func (c *LRUCache[K, V]) hit() {
+	c.counters.hits.Add(1)
+	if w := c.window.Load(); w != nil {
+		w.lookup(true)
+	}
+}
+
+// miss counts a lookup that found nothing.
+func (c *LRUCache[K, V]) miss() {
+	c.counters.misses.Add(1)
+	if w := c.window.Load(); w != nil {
+		w.lookup(false)
+	}
+}
+
+// windowStats describes the window, or returns nil if there is none.
+func (c *LRUCache[K, V]) windowStats() *WindowStats {
+	w := c.window.Load()
+	if w == nil {
+		return nil
+	}
+	stats := w.stats()
+	return &stats
+}
+
+func (s *session) cmdStatswindow(in *bufio.Reader, out *reply, line string, parts []string) {
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		out.Errorf(CodeInvalid, "Invalid window: %s", parts[1])
+		return
+	}
+	if err := s.cache.SetStatsWindow(n); err != nil {
+		out.Err(err)
+		return
+	}
+	out.OK()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// zipf draws integers from [0, n) with P(k) proportional to 1/(k+1)^s, so
+// 0 is the most frequent. It uses Hörmann and Derflinger's
+// rejection-inversion method, which needs O(1) setup and a few logs and
+// exps per sample regardless of n, and unlike rand.Zipf accepts any s > 0,
+// including the s < 1 typical of web traffic.
+type zipf struct {
+	rng *rand.Rand
+	n   float64
+	s   float64
+
+	hIntegralX1 float64
+	hIntegralN  float64
+	threshold   float64
+}
+
+func newZipf(rng *rand.Rand, s float64, n int) *zipf {
+	z := &zipf{rng: rng, n: float64(n), s: s}
+	z.hIntegralX1 = z.hIntegral(1.5) - 1
+	z.hIntegralN = z.hIntegral(z.n + 0.5)
+	z.threshold = 2 - z.hIntegralInverse(z.hIntegral(2.5)-z.h(2))
+	return z
+}
+
+func (z *zipf) Next() int {
+	for {
+		u := z.hIntegralN + z.rng.Float64()*(z.hIntegralX1-z.hIntegralN)
+		x := z.hIntegralInverse(u)
+		k := math.Min(math.Max(math.Floor(x+0.5), 1), z.n)
+		if k-x <= z.threshold || u >= z.hIntegral(k+0.5)-z.h(k) {
+			return int(k) - 1
+		}
+	}
+}
+
+// h is the unnormalised probability of rank x, x^-s.
+func (z *zipf) h(x float64) float64 {
+	return math.Exp(-z.s * math.Log(x))
+}
+
+// hIntegral is an antiderivative of h, (x^(1-s) - 1) / (1-s), written so
+// that it stays accurate as s approaches 1.
+func (z *zipf) hIntegral(x float64) float64 {
+	logX := math.Log(x)
+	return expm1OverX((1-z.s)*logX) * logX
+}
+
+func (z *zipf) hIntegralInverse(x float64) float64 {
+	t := x * (1 - z.s)
+	if t < -1 {
+		t = -1
+	}
+	return math.Exp(log1pOverX(t) * x)
+}
+
+// expm1OverX and log1pOverX compute (e^x - 1)/x and log(1+x)/x, falling
+// back to their Taylor series near zero where the division loses precision.
+func expm1OverX(x float64) float64 {
+	if math.Abs(x) > 1e-8 {
+		return math.Expm1(x) / x
+	}
+	return 1 + x*0.5*(1+x/3*(1+0.25*x))
+}
+
+func log1pOverX(x float64) float64 {
+	if math.Abs(x) > 1e-8 {
+		return math.Log1p(x) / x
+	}
+	return 1 - x*(0.5-x*(1.0/3-0.25*x))
+}
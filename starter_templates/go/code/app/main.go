@@ -40,6 +40,65 @@ var _ = strconv.Atoi
 // func (c *LRUCache) Size() int {
 // 	return len(c.cache)
 // }
+//
+// // CommandProcessor runs protocol lines against the cache created by INIT.
+// type CommandProcessor struct {
+// 	cache *LRUCache
+// }
+//
+// // Execute runs a single command line and returns the response to print.
+// func (p *CommandProcessor) Execute(line string) string {
+// 	parts := strings.Fields(line)
+// 	command := parts[0]
+//
+// 	switch command {
+// 	case "INIT":
+// 		if len(parts) < 2 {
+// 			return "ERROR: INIT requires capacity argument"
+// 		}
+// 		capacity, err := strconv.Atoi(parts[1])
+// 		if err != nil {
+// 			return fmt.Sprintf("ERROR: Invalid capacity: %v", err)
+// 		}
+// 		p.cache = NewLRUCache(capacity)
+// 		return "OK"
+//
+// 	case "PUT":
+// 		if p.cache == nil {
+// 			return "ERROR: Cache not initialized"
+// 		}
+// 		if len(parts) < 3 {
+// 			return "ERROR: PUT requires key and value arguments"
+// 		}
+// 		key := parts[1]
+// 		value := parts[2]
+// 		p.cache.Put(key, value)
+// 		return "OK"
+//
+// 	case "GET":
+// 		if p.cache == nil {
+// 			return "ERROR: Cache not initialized"
+// 		}
+// 		if len(parts) < 2 {
+// 			return "ERROR: GET requires key argument"
+// 		}
+// 		key := parts[1]
+// 		value, ok := p.cache.Get(key)
+// 		if !ok {
+// 			return "NULL"
+// 		}
+// 		return value
+//
+// 	case "SIZE":
+// 		if p.cache == nil {
+// 			return "ERROR: Cache not initialized"
+// 		}
+// 		return strconv.Itoa(p.cache.Size())
+//
+// 	default:
+// 		return fmt.Sprintf("ERROR: Unknown command: %s", command)
+// 	}
+// }
 
 func main() {
 	// You can use print statements as follows for debugging, they'll be visible when running tests.
@@ -47,7 +106,7 @@ func main() {
 
 	// Uncomment this block to pass the first stage
 	//
-	// var cache *LRUCache
+	// processor := &CommandProcessor{}
 	// scanner := bufio.NewScanner(os.Stdin)
 	//
 	// for scanner.Scan() {
@@ -55,65 +114,7 @@ func main() {
 	// 	if line == "" {
 	// 		continue
 	// 	}
-	//
-	// 	parts := strings.Fields(line)
-	// 	command := parts[0]
-	//
-	// 	switch command {
-	// 	case "INIT":
-	// 		if len(parts) < 2 {
-	// 			fmt.Println("ERROR: INIT requires capacity argument")
-	// 			continue
-	// 		}
-	// 		capacity, err := strconv.Atoi(parts[1])
-	// 		if err != nil {
-	// 			fmt.Printf("ERROR: Invalid capacity: %v\n", err)
-	// 			continue
-	// 		}
-	// 		cache = NewLRUCache(capacity)
-	// 		fmt.Println("OK")
-	//
-	// 	case "PUT":
-	// 		if cache == nil {
-	// 			fmt.Println("ERROR: Cache not initialized")
-	// 			continue
-	// 		}
-	// 		if len(parts) < 3 {
-	// 			fmt.Println("ERROR: PUT requires key and value arguments")
-	// 			continue
-	// 		}
-	// 		key := parts[1]
-	// 		value := parts[2]
-	// 		cache.Put(key, value)
-	// 		fmt.Println("OK")
-	//
-	// 	case "GET":
-	// 		if cache == nil {
-	// 			fmt.Println("ERROR: Cache not initialized")
-	// 			continue
-	// 		}
-	// 		if len(parts) < 2 {
-	// 			fmt.Println("ERROR: GET requires key argument")
-	// 			continue
-	// 		}
-	// 		key := parts[1]
-	// 		value, ok := cache.Get(key)
-	// 		if !ok {
-	// 			fmt.Println("NULL")
-	// 		} else {
-	// 			fmt.Println(value)
-	// 		}
-	//
-	// 	case "SIZE":
-	// 		if cache == nil {
-	// 			fmt.Println("ERROR: Cache not initialized")
-	// 			continue
-	// 		}
-	// 		fmt.Println(cache.Size())
-	//
-	// 	default:
-	// 		fmt.Printf("ERROR: Unknown command: %s\n", command)
-	// 	}
+	// 	fmt.Println(processor.Execute(line))
 	// }
 	//
 	// if err := scanner.Err(); err != nil {